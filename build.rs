@@ -1,11 +1,69 @@
 use std::env;
 use std::path::Path;
+use std::process::Command;
+use std::time::{SystemTime, UNIX_EPOCH};
 
 fn main() {
     // Only generate types if explicitly requested via environment variable
     if env::var("GENERATE_TYPES").is_ok() {
         generate_typescript_types();
     }
+
+    println!("cargo:rustc-env=DHD_GIT_COMMIT={}", git_commit());
+    println!("cargo:rustc-env=DHD_BUILD_DATE={}", build_date());
+    println!("cargo:rerun-if-changed=build.rs");
+    println!("cargo:rerun-if-changed=.git/HEAD");
+    println!("cargo:rerun-if-changed=.git/refs/heads");
+    println!("cargo:rerun-if-env-changed=GENERATE_TYPES");
+    println!("cargo:rerun-if-env-changed=DHD_GIT_COMMIT");
+    println!("cargo:rerun-if-env-changed=SOURCE_DATE_EPOCH");
+}
+
+/// The commit being built, which packagers building from a tarball can give
+/// in `DHD_GIT_COMMIT`
+fn git_commit() -> String {
+    if let Ok(commit) = env::var("DHD_GIT_COMMIT") {
+        return commit;
+    }
+    Command::new("git")
+        .args(["rev-parse", "--short=12", "HEAD"])
+        .output()
+        .ok()
+        .filter(|output| output.status.success())
+        .map(|output| String::from_utf8_lossy(&output.stdout).trim().to_string())
+        .filter(|commit| !commit.is_empty())
+        .unwrap_or_else(|| "unknown".to_string())
+}
+
+/// The build's date as YYYY-MM-DD, from `SOURCE_DATE_EPOCH` for reproducible
+/// builds
+fn build_date() -> String {
+    let secs = env::var("SOURCE_DATE_EPOCH")
+        .ok()
+        .and_then(|epoch| epoch.parse::<u64>().ok())
+        .unwrap_or_else(|| {
+            SystemTime::now()
+                .duration_since(UNIX_EPOCH)
+                .map(|elapsed| elapsed.as_secs())
+                .unwrap_or_default()
+        });
+
+    // Days since 1970-01-01 to a civil date, after Howard Hinnant's algorithm
+    let days = (secs / 86_400) as i64 + 719_468;
+    let era = days.div_euclid(146_097);
+    let day_of_era = days.rem_euclid(146_097);
+    let year_of_era =
+        (day_of_era - day_of_era / 1_460 + day_of_era / 36_524 - day_of_era / 146_096) / 365;
+    let day_of_year = day_of_era - (365 * year_of_era + year_of_era / 4 - year_of_era / 100);
+    let shifted_month = (5 * day_of_year + 2) / 153;
+    let day = day_of_year - (153 * shifted_month + 2) / 5 + 1;
+    let month = if shifted_month < 10 {
+        shifted_month + 3
+    } else {
+        shifted_month - 9
+    };
+    let year = year_of_era + era * 400 + (month <= 2) as i64;
+    format!("{:04}-{:02}-{:02}", year, month, day)
 }
 
 fn generate_typescript_types() {
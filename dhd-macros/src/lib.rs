@@ -1,5 +1,6 @@
 use proc_macro::TokenStream;
 use quote::quote;
+use syn::ext::IdentExt;
 use syn::{
     parse_macro_input, FnArg, ImplItem, ItemEnum, ItemFn, ItemImpl, ItemStruct, Pat, ReturnType,
     Type, Visibility,
@@ -79,14 +80,16 @@ pub fn typescript_type(_attr: TokenStream, item: TokenStream) -> TokenStream {
     // Create a unique static name
     let static_name = quote::format_ident!("__{}_TYPE", struct_name.to_string().to_uppercase());
 
-    // Generate TypeScript interface
+    // Generate TypeScript interface, and the fields for the JSON Schema
     let mut ts_fields = Vec::new();
+    let mut schema_fields = Vec::new();
     if let syn::Fields::Named(fields) = &input.fields {
         for field in &fields.named {
             // Only include public fields
             if matches!(field.vis, Visibility::Public(_)) {
                 if let Some(field_name) = &field.ident {
-                    let field_name_str = field_name.to_string();
+                    // Raw identifiers like `r#ref` keep their plain name in TypeScript
+                    let field_name_str = field_name.unraw().to_string();
                     let field_type = type_to_typescript(&field.ty);
 
                     // Check if field is optional
@@ -98,16 +101,26 @@ pub fn typescript_type(_attr: TokenStream, item: TokenStream) -> TokenStream {
 
                     // Extract field documentation from comments
                     let mut field_doc = None;
+                    let mut field_doc_lines = Vec::new();
                     for attr in &field.attrs {
                         if attr.path().is_ident("doc") {
                             if let syn::Meta::NameValue(meta_nv) = &attr.meta {
                                 if let syn::Expr::Lit(syn::ExprLit { lit: syn::Lit::Str(lit_str), .. }) = &meta_nv.value {
                                     field_doc = Some(lit_str.value());
+                                    field_doc_lines.push(lit_str.value().trim().to_string());
                                 }
                             }
                         }
                     }
 
+                    schema_fields.push(format!(
+                        "{{\"name\":{},\"schema\":{},\"optional\":{},\"description\":{}}}",
+                        json_string(&ts_field_name),
+                        type_to_json_schema(&field.ty),
+                        is_optional,
+                        json_string(&field_doc_lines.join(" "))
+                    ));
+
                     // Add field with documentation
                     let field_with_doc = if let Some(doc) = field_doc {
                         format!("    /**\n     * {}\n     */\n    {}{}: {}",
@@ -160,17 +173,102 @@ pub fn typescript_type(_attr: TokenStream, item: TokenStream) -> TokenStream {
         )
     };
 
+    // The first paragraph of the docs describes the type in the JSON Schema
+    let description = doc_comments
+        .iter()
+        .map(|line| line.trim())
+        .take_while(|line| !line.is_empty())
+        .collect::<Vec<_>>()
+        .join(" ");
+    let schema = format!(
+        "{{\"description\":{},\"fields\":[{}]}}",
+        json_string(&description),
+        schema_fields.join(",")
+    );
+    let schema_name = quote::format_ident!("__{}_SCHEMA", struct_name.to_string().to_uppercase());
+
     let expanded = quote! {
         #[derive(Clone, Debug, PartialEq)]
         #input
 
         #[linkme::distributed_slice(crate::typescript::TYPESCRIPT_TYPES)]
         static #static_name: (&'static str, &'static str) = (stringify!(#struct_name), #ts_interface);
+
+        #[linkme::distributed_slice(crate::typescript::JSON_SCHEMAS)]
+        static #schema_name: (&'static str, &'static str) = (stringify!(#struct_name), #schema);
     };
 
     TokenStream::from(expanded)
 }
 
+/// `s` as a JSON string literal
+fn json_string(s: &str) -> String {
+    let mut out = String::from("\"");
+    for ch in s.chars() {
+        match ch {
+            '"' => out.push_str("\\\""),
+            '\\' => out.push_str("\\\\"),
+            '\n' => out.push_str("\\n"),
+            '\t' => out.push_str("\\t"),
+            ch if (ch as u32) < 0x20 => out.push_str(&format!("\\u{:04x}", ch as u32)),
+            ch => out.push(ch),
+        }
+    }
+    out.push('"');
+    out
+}
+
+/// The JSON Schema of a field's type; other DHD types are references into
+/// `$defs`, which the schema generator resolves
+fn type_to_json_schema(ty: &Type) -> String {
+    let Type::Path(type_path) = ty else {
+        return "{}".to_string();
+    };
+    let Some(segment) = type_path.path.segments.last() else {
+        return "{}".to_string();
+    };
+    let args: Vec<&Type> = match &segment.arguments {
+        syn::PathArguments::AngleBracketed(args) => args
+            .args
+            .iter()
+            .filter_map(|arg| match arg {
+                syn::GenericArgument::Type(ty) => Some(ty),
+                _ => None,
+            })
+            .collect(),
+        _ => Vec::new(),
+    };
+    match segment.ident.to_string().as_str() {
+        "String" => r#"{"type":"string"}"#.to_string(),
+        "bool" => r#"{"type":"boolean"}"#.to_string(),
+        "i32" | "i64" | "u8" | "u16" | "u32" | "u64" | "usize" => {
+            r#"{"type":"integer"}"#.to_string()
+        }
+        "f32" | "f64" => r#"{"type":"number"}"#.to_string(),
+        "Vec" => match args.first() {
+            Some(inner) => format!(
+                r#"{{"type":"array","items":{}}}"#,
+                type_to_json_schema(inner)
+            ),
+            None => r#"{"type":"array"}"#.to_string(),
+        },
+        "HashMap" | "BTreeMap" => match args.get(1) {
+            Some(value) => format!(
+                r#"{{"type":"object","additionalProperties":{}}}"#,
+                type_to_json_schema(value)
+            ),
+            None => r#"{"type":"object"}"#.to_string(),
+        },
+        "Option" | "Box" => args
+            .first()
+            .map(|inner| type_to_json_schema(inner))
+            .unwrap_or_else(|| "{}".to_string()),
+        "Value" => "{}".to_string(),
+        "ActionType" => r##"{"$ref":"#/$defs/action"}"##.to_string(),
+        other => format!(r##"{{"$ref":"#/$defs/{}"}}"##, other),
+    }
+}
+
 fn is_option_type(ty: &Type) -> bool {
     if let Type::Path(type_path) = ty {
         if let Some(segment) = type_path.path.segments.last() {
@@ -314,12 +412,36 @@ pub fn typescript_enum(_attr: TokenStream, item: TokenStream) -> TokenStream {
         ts_variants.join("\n    | ")
     );
 
+    // Enums of plain names, like PackageManager, are given as their lowercase
+    // names in modules
+    let schema = if input
+        .variants
+        .iter()
+        .all(|variant| matches!(variant.fields, syn::Fields::Unit))
+    {
+        let names = input
+            .variants
+            .iter()
+            .map(|variant| json_string(&variant.ident.to_string().to_lowercase()))
+            .collect::<Vec<_>>();
+        let schema = format!("{{\"type\":\"string\",\"enum\":[{}]}}", names.join(","));
+        let schema_name = quote::format_ident!("__{}_SCHEMA", enum_name.to_string().to_uppercase());
+        quote! {
+            #[linkme::distributed_slice(crate::typescript::JSON_SCHEMAS)]
+            static #schema_name: (&'static str, &'static str) = (stringify!(#enum_name), #schema);
+        }
+    } else {
+        quote! {}
+    };
+
     let expanded = quote! {
         #[derive(Clone, Debug, PartialEq)]
         #input
 
         #[linkme::distributed_slice(crate::typescript::TYPESCRIPT_TYPES)]
         static #static_name: (&'static str, &'static str) = (stringify!(#enum_name), #ts_type);
+
+        #schema
     };
 
     TokenStream::from(expanded)
@@ -489,4 +611,42 @@ mod tests {
         let action_type: Type = parse_quote!(ActionType);
         assert_eq!(type_to_typescript(&action_type), "ActionType");
     }
+
+    #[test]
+    fn test_type_to_json_schema() {
+        use syn::parse_quote;
+
+        let ty: Type = parse_quote!(Option<String>);
+        assert_eq!(type_to_json_schema(&ty), r#"{"type":"string"}"#);
+
+        let ty: Type = parse_quote!(Option<u32>);
+        assert_eq!(type_to_json_schema(&ty), r#"{"type":"integer"}"#);
+
+        let ty: Type = parse_quote!(Vec<String>);
+        assert_eq!(
+            type_to_json_schema(&ty),
+            r#"{"type":"array","items":{"type":"string"}}"#
+        );
+
+        let ty: Type = parse_quote!(Option<HashMap<String, Vec<String>>>);
+        assert_eq!(
+            type_to_json_schema(&ty),
+            r#"{"type":"object","additionalProperties":{"type":"array","items":{"type":"string"}}}"#
+        );
+
+        let ty: Type = parse_quote!(Box<ActionType>);
+        assert_eq!(type_to_json_schema(&ty), r##"{"$ref":"#/$defs/action"}"##);
+
+        let ty: Type = parse_quote!(Option<Checksum>);
+        assert_eq!(type_to_json_schema(&ty), r##"{"$ref":"#/$defs/Checksum"}"##);
+
+        let ty: Type = parse_quote!(Option<serde_json::Value>);
+        assert_eq!(type_to_json_schema(&ty), "{}");
+    }
+
+    #[test]
+    fn test_json_string() {
+        assert_eq!(json_string("plain"), r#""plain""#);
+        assert_eq!(json_string("say \"hi\"\n"), r#""say \"hi\"\n""#);
+    }
 }
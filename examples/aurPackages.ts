@@ -0,0 +1,13 @@
+export default defineModule("aur-packages")
+    .description("Install repo and AUR packages on Arch")
+    .actions([
+        packageInstall({
+            names: ["base-devel", "git"],
+            manager: "pacman",
+        }),
+        // Installed with paru or yay, whichever is present; repo dependencies are pulled in automatically
+        packageInstall({
+            names: ["visual-studio-code-bin", "spotify"],
+            aur: true,
+        }),
+    ]);
@@ -0,0 +1,11 @@
+export default defineModule("become")
+    .description("System files next to user dotfiles, with root only where needed")
+    .actions([
+        // Written through sudo; DHD asks for the password once per apply
+        copyFile({ source: "hosts", target: "/etc/hosts", become: true }),
+        ensureDir({ path: "/etc/app", mode: 0o755, become: true }),
+        lineInFile({ path: "/etc/environment", line: "EDITOR=nvim", become: true }),
+        executeCommand({ command: "update-ca-certificates", become: true }),
+        // Runs as your user
+        symlink({ source: "zshrc", target: "~/.zshrc" }),
+    ]);
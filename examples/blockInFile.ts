@@ -0,0 +1,15 @@
+export default defineModule("blockInFile")
+    .description("Manage parts of files that other tools also edit")
+    .actions([
+        // Replaced as a whole between "# BEGIN dhd:work" and "# END dhd:work"
+        blockInFile({
+            path: "~/.ssh/config",
+            name: "work",
+            content: `Host work
+    HostName work.example.com
+    User me`,
+        }),
+        // Removes a block an earlier version of this module added
+        blockInFile({ path: "~/.ssh/config", name: "old-vpn", absent: true }),
+        lineInFile({ path: "/etc/hosts", line: "10.0.0.5 nas.lan", escalate: true }),
+    ]);
@@ -0,0 +1,16 @@
+export default defineModule("command")
+    .description("Run idempotent shell commands guarded by onlyIf/unless")
+    .actions([
+        // Skipped once rustup is installed
+        command({
+            run: "curl -sSf https://sh.rustup.rs | sh -s -- -y",
+            unless: "command -v rustup",
+        }),
+        // Only pulls when the checkout exists
+        command({
+            run: "git pull --ff-only",
+            onlyIf: "test -d .git",
+            cwd: "~/src/dotfiles",
+            shell: "bash",
+        }),
+    ]);
@@ -0,0 +1,18 @@
+export default defineModule("copyFile")
+    .description("Copy files into place with explicit permissions")
+    .actions([
+        copyFile({
+            source: "./ssh/config",
+            target: "~/.ssh/config",
+            escalate: false,
+            mode: 0o600,
+        }),
+        copyFile({
+            source: "./sudoers/dhd",
+            target: "/etc/sudoers.d/dhd",
+            escalate: true, // owner/group require root or escalation
+            mode: 0o440,
+            owner: "root",
+            group: "root",
+        }),
+    ]);
@@ -0,0 +1,23 @@
+export default defineModule("cron")
+    .description("Recurring backups and update checks")
+    .actions([
+        // Every night at 03:00; re-applying with a new schedule replaces this entry
+        cron({
+            name: "backup",
+            schedule: "0 3 * * *",
+            command: "restic backup ~/Documents",
+        }),
+        cron({
+            name: "update-check",
+            schedule: "@weekly",
+            command: "topgrade --dry-run",
+            backend: "systemd",
+        }),
+        // Delete a job added before
+        cron({
+            name: "old-sync",
+            schedule: "@hourly",
+            command: "true",
+            ensure: "absent",
+        }),
+    ]);
@@ -0,0 +1,11 @@
+export default defineModule("cli-tools")
+    .description("Install the same tools on Arch, Debian/Ubuntu, Fedora and macOS")
+    .actions([
+        // No manager: DHD picks pacman, apt, dnf or brew based on /etc/os-release
+        packageInstall({
+            names: ["git", "fd", "ripgrep"],
+            overrides: {
+                fd: { debian: "fd-find", fedora: "fd-find" },
+            },
+        }),
+    ]);
@@ -0,0 +1,16 @@
+export default defineModule("decryptFile")
+    .description("Install SSH keys kept encrypted in the repository")
+    .actions([
+        // Written with mode 0o600 unless another mode is given
+        decryptFile({
+            source: "./secrets/id_ed25519.age",
+            target: "~/.ssh/id_ed25519",
+        }),
+        // Decrypted with a hardware key through an age plugin identity
+        decryptFile({
+            source: "./secrets/ssh_config.age",
+            target: "~/.ssh/config",
+            mode: 0o644,
+            identity: "~/.config/age/yubikey-identity.txt",
+        }),
+    ]);
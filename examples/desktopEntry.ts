@@ -0,0 +1,26 @@
+export default defineModule("desktopEntry")
+    .description("Add launchers for applications outside the package manager")
+    .actions([
+        // ~/.local/share/applications/obsidian.desktop
+        desktopEntry({
+            name: "Obsidian",
+            exec: "/opt/obsidian/Obsidian.AppImage %U",
+            icon: "./icons/obsidian.png",
+            categories: ["Office", "Utility"],
+        }),
+        // A terminal program, under a file name of its own
+        desktopEntry({
+            name: "Bottom",
+            exec: "btm",
+            comment: "System monitor",
+            terminal: true,
+            categories: ["System", "Monitor"],
+            id: "com.example.bottom",
+        }),
+        // Deletes the launcher written by an earlier apply
+        desktopEntry({
+            name: "Old Editor",
+            exec: "old-editor",
+            ensure: "absent",
+        }),
+    ]);
@@ -45,7 +45,7 @@ export default defineModule("dev-environment")
     }),
 
     // Set up SSH keys
-    directory({
+    ensureDir({
       path: "~/.ssh",
       mode: "0700"
     }),
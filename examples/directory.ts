@@ -1,16 +1,22 @@
 export default defineModule("directory")
     .description("Create directories with optional privilege escalation")
     .actions([
-        directory({
+        ensureDir({
             path: "~/.config/myapp",
             escalate: false,
         }),
-        directory({
+        ensureDir({
             path: "/etc/myapp",
             escalate: true, // this field should default to false and be optional
         }),
-        directory({
+        ensureDir({
             path: "~/.cache/myapp/logs",
             escalate: false,
         }),
+        // ensureDir is the same action; mode is applied to existing directories too
+        ensureDir({
+            path: "~/.gnupg",
+            mode: 0o700,
+            recursive: false,
+        }),
     ]);
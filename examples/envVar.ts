@@ -0,0 +1,9 @@
+export default defineModule("envVar")
+    .description("Manage environment variables and PATH for every shell")
+    .actions([
+        envVar({ name: "EDITOR", value: "nvim" }),
+        // The shell expands $VAR references in values
+        envVar({ name: "GOPATH", value: "$HOME/go", shells: ["bash", "zsh", "fish"] }),
+        // Added once, even if the env file is sourced again
+        envVar({ pathPrepend: "~/.local/bin", shells: ["bash", "zsh", "fish"] }),
+    ]);
@@ -0,0 +1,10 @@
+export default defineModule("flatpak-apps")
+    .description("Install GUI applications from Flathub")
+    .actions([
+        packageInstall({
+            names: ["org.mozilla.firefox", "com.spotify.Client"],
+            manager: "flatpak",
+            remote: "flathub", // added automatically if missing
+            scope: "user", // or "system" (default)
+        }),
+    ]);
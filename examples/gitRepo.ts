@@ -0,0 +1,18 @@
+export default defineModule("gitRepo")
+    .description("Keep shell plugin checkouts up to date")
+    .actions([
+        // Cloned once, then fast-forwarded on every apply
+        gitRepo({
+            url: "https://github.com/zsh-users/zsh-autosuggestions",
+            path: "~/.oh-my-zsh/custom/plugins/zsh-autosuggestions",
+            depth: 1,
+            update: true,
+        }),
+        // Pinned to a tag; no fetch happens while the tag is checked out
+        gitRepo({
+            url: "https://github.com/zsh-users/zsh-syntax-highlighting",
+            path: "~/.oh-my-zsh/custom/plugins/zsh-syntax-highlighting",
+            ref: "0.8.0",
+            update: true,
+        }),
+    ]);
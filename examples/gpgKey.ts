@@ -0,0 +1,14 @@
+export default defineModule("gpgKey")
+    .description("Signing keys for git and apt repositories")
+    .actions([
+        // Fetched from keyserver.ubuntu.com unless `keyserver` says otherwise
+        gpgKey({ keyId: "0x0123456789ABCDEF", trust: "ultimate" }),
+        // A key shipped next to this module
+        gpgKey({ keyFile: "./keys/colleague.asc", trust: "full" }),
+        // An apt signing key; reference it with `signed-by=/etc/apt/keyrings/docker.gpg`
+        gpgKey({
+            keyUrl: "https://download.docker.com/linux/ubuntu/gpg",
+            keyring: "/etc/apt/keyrings/docker.gpg",
+            escalate: true,
+        }),
+    ]);
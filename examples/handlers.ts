@@ -0,0 +1,19 @@
+export default defineModule("nginx-handlers")
+    .description("Reload nginx once, however many of its config files changed")
+    .handlers({
+        "reload-nginx": command({ run: "sudo systemctl reload nginx" }),
+    })
+    .actions([
+        copyFile({
+            source: "nginx.conf",
+            target: "/etc/nginx/nginx.conf",
+            escalate: true,
+            notify: "reload-nginx",
+        }),
+        copyFile({
+            source: "site.conf",
+            target: "/etc/nginx/sites-enabled/site.conf",
+            escalate: true,
+            notify: "reload-nginx",
+        }),
+    ]);
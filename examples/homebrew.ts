@@ -0,0 +1,10 @@
+export default defineModule("homebrew")
+    .description("Install formulae and casks on macOS")
+    .actions([
+        packageInstall({
+            names: ["ripgrep", "fd", "neovim"],
+            manager: "brew",
+            casks: ["firefox", "iterm2", "font-fira-code"],
+            taps: ["homebrew/cask-fonts"], // added before anything is installed
+        }),
+    ]);
@@ -0,0 +1,15 @@
+export default defineModule("nginx")
+    .description("Validate the config before applying and reload nginx afterwards")
+    .preApply("nginx -t")
+    // Only reload when a file actually changed
+    .postApply({
+        run: "sudo systemctl reload nginx",
+        onlyIfChanged: true,
+    })
+    .actions([
+        copyFile({
+            source: "nginx.conf",
+            target: "/etc/nginx/nginx.conf",
+            escalate: true,
+        }),
+    ]);
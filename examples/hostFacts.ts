@@ -0,0 +1,19 @@
+// Restrict modules to hosts by matching host facts.
+// Every fact that is set must match: hostname, os, arch, distro, family, hasCommand.
+
+export default defineModule("docker-tools")
+    .description("Docker helpers for Linux machines that have docker installed")
+    .when(host({ os: "linux", hasCommand: "docker" }))
+    .actions([
+        packageInstall({ names: ["docker-compose"] }),
+    ]);
+
+// Facts are also available to templates, e.g. {{ host.hostname }} or {{ os.family }}
+export const workstation = defineModule("workstation")
+    .when(host({ hostname: "atlas", arch: "x86_64" }))
+    .actions([
+        template({
+            source: "./templates/motd.tmpl",
+            target: "~/.config/motd",
+        }),
+    ]);
@@ -0,0 +1,14 @@
+export default defineModule("nix-tools")
+    .description("Install CLI tools into the Nix user profile")
+    .actions([
+        packageInstall({
+            names: ["ripgrep", "fd", "jq"],
+            manager: "nix",
+        }),
+        // Pin to a specific nixpkgs release for reproducible versions
+        packageInstall({
+            names: ["neovim"],
+            manager: "nix",
+            flake: "github:NixOS/nixpkgs/nixos-24.05",
+        }),
+    ]);
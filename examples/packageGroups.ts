@@ -0,0 +1,9 @@
+// Groups come from packageGroups in dhd.config.ts, e.g.
+//   packageGroups: { "dev-tools": ["ripgrep", "fd", "jq"] }
+export default defineModule("package-groups")
+    .description("Install shared bundles of packages and pacman groups")
+    .actions([
+        packageInstall({ names: ["neovim"], groups: ["dev-tools"] }),
+        // Not in packageGroups, so pacman expands it itself
+        packageInstall({ groups: ["xorg"], manager: "pacman" }),
+    ]);
@@ -0,0 +1,14 @@
+export default defineModule("cleanup")
+    .description("Remove packages that are no longer wanted")
+    .actions([
+        // Uninstall with the detected package manager, skipping ones already gone
+        packageInstall({
+            names: ["nano", "fd"],
+            ensure: "absent",
+            overrides: { fd: { debian: "fd-find", ubuntu: "fd-find" } },
+        }),
+        packageRemove({
+            names: ["org.mozilla.firefox"],
+            manager: "flatpak",
+        }),
+    ]);
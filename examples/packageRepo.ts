@@ -0,0 +1,20 @@
+export default defineModule("packageRepo")
+    .description("Third-party package repositories")
+    .actions([
+        // Suite defaults to this release's codename, components to ["main"]
+        packageRepo({
+            name: "docker",
+            uri: "https://download.docker.com/linux/ubuntu",
+            key: "https://download.docker.com/linux/ubuntu/gpg",
+            components: ["stable"],
+            manager: "apt",
+        }),
+        // A key shipped next to this module
+        packageRepo({
+            name: "vscode",
+            uri: "https://packages.microsoft.com/yumrepos/vscode",
+            key: "./keys/microsoft.asc",
+            manager: "dnf",
+        }),
+        packageInstall({ names: ["docker-ce"], manager: "apt" }),
+    ]);
@@ -0,0 +1,23 @@
+export default defineModule("remoteFile")
+    .description("Install binaries and fonts straight from release downloads")
+    .actions([
+        // A single file from a release archive
+        remoteFile({
+            url: "https://github.com/BurntSushi/ripgrep/releases/download/14.1.0/ripgrep-14.1.0-x86_64-unknown-linux-musl.tar.gz",
+            target: "~/.local/bin/rg",
+            sha256: "<sha256 from the release checksums>",
+            extract: "ripgrep-14.1.0-x86_64-unknown-linux-musl/rg",
+            mode: 0o755,
+        }),
+        // A whole archive unpacked as a directory
+        remoteFile({
+            url: "https://github.com/ryanoasis/nerd-fonts/releases/download/v3.2.1/JetBrainsMono.zip",
+            target: "~/.local/share/fonts/JetBrainsMono",
+            extract: ".",
+        }),
+        // A plain file; without sha256 the server's ETag decides whether to download again
+        remoteFile({
+            url: "https://raw.githubusercontent.com/git/git/master/contrib/completion/git-prompt.sh",
+            target: "~/.local/share/git/git-prompt.sh",
+        }),
+    ]);
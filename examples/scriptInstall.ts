@@ -0,0 +1,19 @@
+export default defineModule("scriptInstall")
+    .description("Run vendor install scripts, verified and only once")
+    .actions([
+        // Runs again only when the script or the version changes
+        scriptInstall({
+            url: "https://sh.rustup.rs",
+            sha256: "<sha256 of the script you reviewed>",
+            args: ["-y", "--no-modify-path"],
+            version: "1.28",
+        }),
+        // A system-wide installer with its options in the environment
+        scriptInstall({
+            url: "https://example.com/install.sh",
+            sha256: "<sha256 of the script you reviewed>",
+            shell: "bash",
+            env: { PREFIX: "/usr/local" },
+            escalate: true,
+        }),
+    ]);
@@ -0,0 +1,14 @@
+export default defineModule("desktop")
+    .description("Install desktop applications from the Snap Store")
+    .actions([
+        packageInstall({
+            names: ["code"],
+            manager: "snap",
+            classic: true,
+        }),
+        packageInstall({
+            names: ["firefox"],
+            manager: "snap",
+            channel: "latest/beta",
+        }),
+    ]);
@@ -0,0 +1,18 @@
+export default defineModule("sshConfig")
+    .description("Manage Host blocks of ~/.ssh/config next to ones written by hand")
+    .actions([
+        sshConfig({
+            hosts: [
+                // Replaced as a whole between "# BEGIN dhd:Host work" and "# END dhd:Host work"
+                {
+                    host: "work",
+                    hostname: "work.example.com",
+                    user: "me",
+                    identityFile: "~/.ssh/work_ed25519",
+                },
+                { host: "nas", hostname: "10.0.0.5", port: 2222, options: { ForwardAgent: "yes" } },
+                // Removes a block an earlier version of this module added
+                { host: "old-vpn", absent: true },
+            ],
+        }),
+    ]);
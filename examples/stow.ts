@@ -0,0 +1,10 @@
+export default defineModule("stow")
+    .description("Dotfiles kept in GNU Stow layout")
+    .actions([
+        // dotfiles/zsh/.zshrc -> ~/.zshrc
+        stow({ source: "dotfiles/zsh" }),
+        // Take over an existing ~/.config/git/config into the package
+        stow({ source: "dotfiles/git", adopt: true }),
+        // No longer wanted: remove its links
+        stow({ source: "dotfiles/tmux", delete: true }),
+    ]);
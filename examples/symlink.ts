@@ -0,0 +1,13 @@
+export default defineModule("symlink")
+    .description("Symlink dotfiles from the module directory into place")
+    .actions([
+        symlink({
+            source: "./dotfiles/.zshrc",
+            target: "~/.zshrc",
+        }),
+        symlink({
+            source: "./dotfiles/gitignore",
+            target: "~/.config/git/ignore",
+            force: true, // replace an existing file and create parent directories
+        }),
+    ]);
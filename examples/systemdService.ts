@@ -26,4 +26,27 @@ export default defineModule("systemdService")
             serviceType: "simple",
             scope: "user",
         }),
+        // Full unit file with a timer, enabled and started in the user session
+        systemdService({
+            name: "backup.service",
+            unit: `[Unit]
+Description=Nightly backup
+
+[Service]
+Type=oneshot
+ExecStart=%h/.local/bin/backup
+`,
+            timer: `[Unit]
+Description=Run backup nightly
+
+[Timer]
+OnCalendar=daily
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`,
+            enable: true,
+            start: true,
+        }),
     ]);
@@ -0,0 +1,14 @@
+export default defineModule("template")
+    .description("Render a git config from a template")
+    // Shared by every template below; dhd.config.ts variables fill in the rest
+    .variables({ name: "Jane Doe" })
+    .actions([
+        template({
+            source: "./templates/gitconfig.tmpl",
+            target: "~/.gitconfig",
+            variables: {
+                email: "jane@example.com",
+                work: "true", // enables {{#if work}} ... {{/if}} sections
+            },
+        }),
+    ]);
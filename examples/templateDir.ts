@@ -0,0 +1,17 @@
+export default defineModule("templateDir")
+    .description("Render an application's config directory")
+    .variables({ font: "JetBrains Mono" })
+    .actions([
+        // .tmpl files are rendered without the extension, the rest copied
+        templateDir({
+            source: "./config/waybar",
+            target: "~/.config/waybar",
+        }),
+        // Jinja-style names for a tree shared with other tools
+        templateDir({
+            source: "./config/kitty",
+            target: "~/.config/kitty",
+            variables: { theme: "dark" },
+            extension: ".j2",
+        }),
+    ]);
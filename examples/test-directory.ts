@@ -1,7 +1,7 @@
 export default defineModule("test-directory")
     .description("Test directory creation")
     .actions([
-        directory({
+        ensureDir({
             path: "/tmp/test",
             escalate: false,
         }),
@@ -0,0 +1,78 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use std::path::Path;
+
+/// Keep a block of lines in a file dhd doesn't fully own
+///
+/// The block sits between `# BEGIN dhd:<name>` and `# END dhd:<name>` and is
+/// replaced as a whole when `content` changes; the rest of the file is kept.
+#[typescript_type]
+pub struct BlockInFile {
+    /// File to edit (supports `~/`); created if missing
+    pub path: String,
+    /// Name in the markers, unique within the file
+    pub name: String,
+    /// Lines between the markers; required unless `absent`
+    pub content: Option<String>,
+    /// Remove the block, markers included, instead
+    pub absent: Option<bool>,
+    /// Write the file through sudo, e.g. for `/etc/hosts`
+    pub escalate: Option<bool>,
+}
+
+impl crate::actions::Action for BlockInFile {
+    fn name(&self) -> &str {
+        "BlockInFile"
+    }
+
+    fn plan(&self, _module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let content = if self.absent.unwrap_or(false) {
+            None
+        } else {
+            Some(self.content.clone().unwrap_or_default())
+        };
+
+        vec![Box::new(AtomCompat::new(
+            Box::new(crate::atoms::block_in_file::BlockInFile::new(
+                crate::paths::expand_path(&self.path),
+                self.name.clone(),
+                content,
+                self.escalate.unwrap_or(false),
+            )),
+            "block_in_file".to_string(),
+        ))]
+    }
+}
+
+#[typescript_fn]
+pub fn block_in_file(config: BlockInFile) -> crate::actions::ActionType {
+    crate::actions::ActionType::BlockInFile(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    #[test]
+    fn test_block_in_file_plan() {
+        let mut action = BlockInFile {
+            path: "/etc/hosts".to_string(),
+            name: "lab".to_string(),
+            content: Some("10.0.0.5 nas".to_string()),
+            absent: None,
+            escalate: Some(true),
+        };
+
+        assert_eq!(action.name(), "BlockInFile");
+
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(atoms[0].describe(), "Update block 'lab' in /etc/hosts");
+
+        action.absent = Some(true);
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms[0].describe(), "Remove block 'lab' from /etc/hosts");
+    }
+}
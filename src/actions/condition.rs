@@ -1,13 +1,16 @@
 use crate::execution::VERBOSE_MODE;
+use crate::logging::LoggedCommand;
 use crate::system_info::SystemInfo;
 use dhd_macros::{typescript_enum, typescript_fn, typescript_impl, typescript_type};
-use std::path::Path;
 use std::process::Command;
 
 fn get_property_value(info: &SystemInfo, path: &str) -> Option<String> {
     let parts: Vec<&str> = path.split('.').collect();
     
     match parts.as_slice() {
+        ["host", "hostname"] => Some(info.host.hostname.clone()),
+        ["host", "os"] => Some(info.host.os.clone()),
+        ["host", "arch"] => Some(info.host.arch.clone()),
         ["os", "family"] => Some(info.os.family.clone()),
         ["os", "distro"] => Some(info.os.distro.clone()),
         ["os", "version"] => Some(info.os.version.clone()),
@@ -97,15 +100,9 @@ impl Condition {
             
             Condition::Not { condition } => !condition.evaluate()?,
             
-            Condition::FileExists { path } => {
-                let expanded = shellexpand::tilde(path);
-                Path::new(expanded.as_ref()).is_file()
-            }
+            Condition::FileExists { path } => crate::paths::expand_path(path).is_file(),
             
-            Condition::DirectoryExists { path } => {
-                let expanded = shellexpand::tilde(path);
-                Path::new(expanded.as_ref()).is_dir()
-            }
+            Condition::DirectoryExists { path } => crate::paths::expand_path(path).is_dir(),
             
             Condition::CommandExists { command } => {
                 which::which(command).is_ok()
@@ -117,7 +114,7 @@ impl Condition {
                     cmd.args(args);
                 }
                 
-                match cmd.output() {
+                match cmd.logged_output() {
                     Ok(output) => output.status.success(),
                     Err(_) => false,
                 }
@@ -137,9 +134,7 @@ impl Condition {
             }
             
             Condition::SystemProperty { path, operator, value } => {
-                let info = crate::system_info::get_system_info();
-                
-                match get_property_value(&info, path) {
+                match get_property_value(crate::system_info::facts(), path) {
                     Some(prop_value) => {
                         match operator {
                             ComparisonOperator::Equals => prop_value == *value,
@@ -156,20 +151,33 @@ impl Condition {
             Condition::SecretExists { reference } => {
                 // For now, we just check if it's a valid reference format
                 // In the future, we could actually check with the secret provider
-                reference.starts_with("op://") || 
-                reference.starts_with("env://") || 
-                reference.starts_with("literal://")
+                crate::secrets::SecretReference::parse(reference).is_ok()
             }
         };
         
         if verbose {
             let emoji = if result { "✓" } else { "✗" };
             println!("      {} Condition {}: {}", emoji, if result { "passed" } else { "failed" }, self.describe());
+        } else {
+            log::debug!("condition {}: {}", if result { "passed" } else { "failed" }, self.describe());
         }
         
         Ok(result)
     }
     
+    /// Whether evaluating the condition runs a command, which `dhd audit`
+    /// doesn't do
+    pub fn runs_command(&self) -> bool {
+        match self {
+            Condition::AllOf { conditions } | Condition::AnyOf { conditions } => {
+                conditions.iter().any(Condition::runs_command)
+            }
+            Condition::Not { condition } => condition.runs_command(),
+            Condition::CommandSucceeds { .. } => true,
+            _ => false,
+        }
+    }
+
     pub fn describe(&self) -> String {
         match self {
             Condition::AllOf { conditions } => {
@@ -315,7 +323,65 @@ impl PropertyBuilder {
     }
 }
 
+/// Host facts a module can be restricted to; every fact that is set must match
+#[typescript_type]
+pub struct HostFacts {
+    /// Machine hostname
+    pub hostname: Option<String>,
+    /// Operating system, e.g. "linux" or "macos"
+    pub os: Option<String>,
+    /// CPU architecture, e.g. "x86_64" or "aarch64"
+    pub arch: Option<String>,
+    /// Distribution id, e.g. "ubuntu" or "fedora"
+    pub distro: Option<String>,
+    /// Distribution family, e.g. "debian" or "arch"
+    pub family: Option<String>,
+    /// Executable that must be on PATH
+    pub has_command: Option<String>,
+}
+
+impl HostFacts {
+    pub fn into_condition(self) -> Condition {
+        let properties = [
+            ("host.hostname", self.hostname),
+            ("host.os", self.os),
+            (
+                "host.arch",
+                self.arch.map(|arch| crate::platform::normalize_arch(&arch)),
+            ),
+            ("os.distro", self.distro),
+            ("os.family", self.family),
+        ];
+
+        let mut conditions: Vec<Condition> = properties
+            .into_iter()
+            .filter_map(|(path, value)| {
+                value.map(|value| Condition::SystemProperty {
+                    path: path.to_string(),
+                    operator: ComparisonOperator::Equals,
+                    value,
+                })
+            })
+            .collect();
+
+        if let Some(command) = self.has_command {
+            conditions.push(Condition::CommandExists { command });
+        }
+
+        if conditions.len() == 1 {
+            conditions.remove(0)
+        } else {
+            Condition::AllOf { conditions }
+        }
+    }
+}
+
 // Helper functions for creating conditions
+#[typescript_fn]
+pub fn host(facts: HostFacts) -> Condition {
+    facts.into_condition()
+}
+
 #[typescript_fn]
 pub fn all_of(conditions: Vec<Condition>) -> Condition {
     Condition::AllOf { conditions }
@@ -517,4 +583,28 @@ mod tests {
         ]);
         assert_eq!(condition.describe(), "all of 2 conditions");
     }
-}
\ No newline at end of file
+
+    #[test]
+    fn test_host_facts() {
+        let matching = host(HostFacts {
+            hostname: None,
+            os: Some(std::env::consts::OS.to_string()),
+            arch: Some(std::env::consts::ARCH.to_string()),
+            distro: None,
+            family: None,
+            has_command: Some("sh".to_string()),
+        });
+        assert!(matching.evaluate().unwrap());
+
+        let other_os = host(HostFacts {
+            hostname: None,
+            os: Some("plan9".to_string()),
+            arch: None,
+            distro: None,
+            family: None,
+            has_command: None,
+        });
+        assert_eq!(other_os.describe(), "system property host.os == plan9");
+        assert!(!other_os.evaluate().unwrap());
+    }
+}
@@ -1,12 +1,31 @@
 use crate::atoms::AtomCompat;
 use dhd_macros::{typescript_fn, typescript_type};
-use std::path::{Path, PathBuf};
+use std::path::Path;
 
 #[typescript_type]
+/// Copies a file from the module directory to a destination
+///
+/// A glob `source` like `./themes/*.toml` copies each matching file into the
+/// `target` directory under its own name. Small files can give their
+/// `content` instead of a `source`; one of the two is required.
+///
+/// * `mode` - Unix permission bits to set on the target (e.g. `0o600`)
+/// * `owner` / `group` - Ownership to apply; requires root or `escalate: true`
+/// * `create_parents` - Create missing parent directories of the target
+///   (default: `true`)
+/// * `ensure` - `"present"` (default) or `"absent"` to remove the copy an
+///   earlier apply made, or put back what it replaced
 pub struct CopyFile {
-    pub source: String,
+    pub source: Option<String>,
+    /// Text written to the target instead of copying a `source`
+    pub content: Option<String>,
     pub target: String,
     pub escalate: bool,
+    pub mode: Option<u32>,
+    pub owner: Option<String>,
+    pub group: Option<String>,
+    pub create_parents: Option<bool>,
+    pub ensure: Option<String>,
 }
 
 #[typescript_fn]
@@ -20,26 +39,44 @@ impl crate::actions::Action for CopyFile {
     }
 
     fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
-        let source_path = if self.source.starts_with('/') {
-            PathBuf::from(&self.source)
-        } else {
-            module_dir.join(&self.source)
+        let target_path = crate::paths::expand_path(&self.target);
+        let files = match &self.source {
+            Some(source) => crate::actions::glob_sources(
+                crate::paths::resolve(module_dir, source),
+                target_path,
+                "copyFile",
+                false,
+            ),
+            // Inline content has no source file
+            None => vec![(std::path::PathBuf::new(), target_path)],
         };
 
-        let target_path = if self.target.starts_with("~/") {
-            let home = std::env::var("HOME").unwrap_or_else(|_| String::from("/home/user"));
-            PathBuf::from(self.target.replacen("~/", &format!("{}/", home), 1))
-        } else {
-            PathBuf::from(&self.target)
-        };
+        if self.ensure.as_deref() == Some("absent") {
+            return files
+                .into_iter()
+                .flat_map(|(_, target)| crate::actions::retire(target, "copy_file"))
+                .collect();
+        }
 
-        vec![Box::new(AtomCompat::new(
-            Box::new(crate::atoms::copy_file::CopyFile::new(
-                source_path,
-                target_path,
-                self.escalate,
-            )),
-            "copy_file".to_string(),
-        ))]
+        files
+            .into_iter()
+            .map(|(source, target)| -> Box<dyn crate::atom::Atom> {
+                Box::new(AtomCompat::new(
+                    Box::new(
+                        crate::atoms::copy_file::CopyFile::new(
+                            source,
+                            target,
+                            self.escalate,
+                            self.mode,
+                            self.owner.clone(),
+                            self.group.clone(),
+                        )
+                        .with_create_parents(self.create_parents.unwrap_or(true))
+                        .with_content(self.content.clone()),
+                    ),
+                    "copy_file".to_string(),
+                ))
+            })
+            .collect()
     }
 }
@@ -0,0 +1,86 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use crate::atoms::cron::CronBackend;
+use std::path::Path;
+
+/// Schedule a command, keyed by `name` so a changed job replaces the old one
+///
+/// * `schedule` - Five cron fields (`"0 3 * * *"`) or a shorthand like `"@daily"`
+/// * `user` - Whose crontab to edit through sudo; for timers, a system unit
+///   running as that user
+/// * `ensure` - `"present"` (default) or `"absent"` to delete the job
+/// * `backend` - `"crontab"` or `"systemd"` (default: the crontab if `crontab`
+///   is installed, otherwise a systemd timer on systemd hosts)
+#[typescript_type]
+pub struct Cron {
+    pub name: String,
+    pub schedule: String,
+    pub command: String,
+    pub user: Option<String>,
+    pub ensure: Option<String>,
+    pub backend: Option<String>,
+}
+
+impl crate::actions::Action for Cron {
+    fn name(&self) -> &str {
+        "Cron"
+    }
+
+    fn plan(&self, _module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let backend = match self.backend.as_deref() {
+            Some("crontab") => CronBackend::Crontab,
+            Some("systemd") => CronBackend::Systemd,
+            _ => CronBackend::detect(),
+        };
+
+        vec![Box::new(AtomCompat::new(
+            Box::new(crate::atoms::cron::Cron::new(
+                self.name.clone(),
+                self.schedule.clone(),
+                self.command.clone(),
+                self.user.clone(),
+                self.ensure.as_deref() != Some("absent"),
+                backend,
+            )),
+            "cron".to_string(),
+        ))]
+    }
+}
+
+#[typescript_fn]
+pub fn cron(config: Cron) -> crate::actions::ActionType {
+    crate::actions::ActionType::Cron(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    #[test]
+    fn test_cron_plan() {
+        let mut action = Cron {
+            name: "backup".to_string(),
+            schedule: "0 3 * * *".to_string(),
+            command: "restic backup ~".to_string(),
+            user: None,
+            ensure: None,
+            backend: Some("crontab".to_string()),
+        };
+
+        assert_eq!(action.name(), "Cron");
+
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(
+            atoms[0].describe(),
+            "Schedule cron job 'backup' (0 3 * * *): restic backup ~"
+        );
+
+        action.ensure = Some("absent".to_string());
+        action.backend = Some("systemd".to_string());
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms[0].describe(), "Remove timer dhd-cron-backup.timer");
+    }
+}
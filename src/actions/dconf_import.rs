@@ -1,7 +1,7 @@
 use super::Action;
 use crate::atoms::AtomCompat;
 use dhd_macros::{typescript_fn, typescript_type};
-use std::path::{Path, PathBuf};
+use std::path::Path;
 
 /// Import dconf settings from a file
 #[typescript_type]
@@ -18,11 +18,7 @@ impl Action for DconfImport {
     }
 
     fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
-        let source_path = if self.source.starts_with('/') {
-            PathBuf::from(&self.source)
-        } else {
-            module_dir.join(&self.source)
-        };
+        let source_path = crate::paths::resolve(module_dir, &self.source);
 
         vec![Box::new(AtomCompat::new(
             Box::new(crate::atoms::dconf_import::DconfImportAtom::new(
@@ -0,0 +1,69 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use std::path::Path;
+
+#[typescript_type]
+pub struct DecryptFile {
+    /// age-encrypted file, relative to the module directory unless absolute
+    pub source: String,
+    /// Where the plaintext is written (supports `~/`)
+    pub target: String,
+    /// Unix permission bits for the plaintext (default: `0o600`)
+    pub mode: Option<u32>,
+    /// age identity file to decrypt with (default: `$DHD_AGE_IDENTITY` or
+    /// `~/.config/age/keys.txt`); plugin identities work too
+    pub identity: Option<String>,
+}
+
+impl crate::actions::Action for DecryptFile {
+    fn name(&self) -> &str {
+        "DecryptFile"
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let source = crate::paths::resolve(module_dir, &self.source);
+        let target = crate::paths::expand_path(&self.target);
+        let identity = self
+            .identity
+            .as_ref()
+            .map(|identity| crate::paths::expand_path(identity));
+
+        vec![Box::new(AtomCompat::new(
+            Box::new(crate::atoms::decrypt_file::DecryptFile::new(
+                source, target, self.mode, identity,
+            )),
+            "decrypt_file".to_string(),
+        ))]
+    }
+}
+
+#[typescript_fn]
+pub fn decrypt_file(config: DecryptFile) -> crate::actions::ActionType {
+    crate::actions::ActionType::DecryptFile(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    #[test]
+    fn test_decrypt_file_plan() {
+        let action = DecryptFile {
+            source: "secrets/id_ed25519.age".to_string(),
+            target: "/home/user/.ssh/id_ed25519".to_string(),
+            mode: None,
+            identity: None,
+        };
+
+        assert_eq!(action.name(), "DecryptFile");
+
+        let atoms = action.plan(Path::new("/modules/ssh"));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(
+            atoms[0].describe(),
+            "Decrypt /modules/ssh/secrets/id_ed25519.age -> /home/user/.ssh/id_ed25519 (mode 600)"
+        );
+    }
+}
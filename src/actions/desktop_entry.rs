@@ -0,0 +1,214 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use crate::atoms::desktop_entry::HEADER;
+use std::path::{Path, PathBuf};
+
+/// Write a launcher for an application to `applications` of the XDG data
+/// directory (`~/.local/share/applications`), e.g. for an AppImage
+///
+/// * `name` - Name shown in menus
+/// * `exec` - Command line starting the application
+/// * `icon` - Icon name from the icon theme, or a path to an image relative
+///   to the module directory unless absolute
+/// * `categories` - Menu categories, like `["Development", "Utility"]`
+/// * `comment` - Tooltip shown for the launcher
+/// * `terminal` - Run `exec` in a terminal (default: false)
+/// * `id` - File name without `.desktop` (default: the words of `name` in
+///   lowercase, joined by dashes)
+/// * `ensure` - `"present"` (default) or `"absent"` to delete the entry DHD wrote
+///
+/// The desktop database is refreshed after every change, where
+/// `update-desktop-database` is installed.
+#[typescript_type]
+pub struct DesktopEntry {
+    pub name: String,
+    pub exec: String,
+    pub icon: Option<String>,
+    pub categories: Option<Vec<String>>,
+    pub comment: Option<String>,
+    pub terminal: Option<bool>,
+    pub id: Option<String>,
+    pub ensure: Option<String>,
+}
+
+#[typescript_fn]
+pub fn desktop_entry(config: DesktopEntry) -> crate::actions::ActionType {
+    crate::actions::ActionType::DesktopEntry(config)
+}
+
+/// `value` escaped as a desktop entry string
+fn escape(value: &str) -> String {
+    value
+        .replace('\\', "\\\\")
+        .replace('\n', "\\n")
+        .replace('\t', "\\t")
+        .replace('\r', "\\r")
+}
+
+impl DesktopEntry {
+    /// The file name of the entry, without `.desktop`
+    pub fn file_id(&self) -> String {
+        if let Some(id) = &self.id {
+            return id.clone();
+        }
+        let name = self.name.to_lowercase();
+        let words: Vec<&str> = name
+            .split(|c: char| !c.is_alphanumeric())
+            .filter(|word| !word.is_empty())
+            .collect();
+        words.join("-")
+    }
+
+    /// Where the entry is written
+    fn path(&self) -> PathBuf {
+        let data = crate::paths::xdg_dir("XDG_DATA_HOME")
+            .unwrap_or_else(|| crate::paths::expand_path("~/.local/share"));
+        data.join("applications")
+            .join(format!("{}.desktop", self.file_id()))
+    }
+
+    /// The entry's content, as the Desktop Entry Specification lays it out
+    fn content(&self, module_dir: &Path) -> String {
+        let mut lines = vec![
+            HEADER.to_string(),
+            "[Desktop Entry]".to_string(),
+            "Type=Application".to_string(),
+            format!("Name={}", escape(&self.name)),
+        ];
+        if let Some(comment) = &self.comment {
+            lines.push(format!("Comment={}", escape(comment)));
+        }
+        lines.push(format!("Exec={}", escape(&self.exec)));
+        if let Some(icon) = &self.icon {
+            // Icon theme names are looked up; only paths are the module's
+            let icon = if icon.contains('/') {
+                crate::paths::resolve(module_dir, icon)
+                    .to_string_lossy()
+                    .into_owned()
+            } else {
+                icon.clone()
+            };
+            lines.push(format!("Icon={}", escape(&icon)));
+        }
+        lines.push(format!("Terminal={}", self.terminal.unwrap_or(false)));
+        if let Some(categories) = self.categories.as_ref().filter(|c| !c.is_empty()) {
+            let categories: Vec<String> = categories.iter().map(|c| escape(c)).collect();
+            lines.push(format!("Categories={};", categories.join(";")));
+        }
+        lines.join("\n") + "\n"
+    }
+}
+
+impl crate::actions::Action for DesktopEntry {
+    fn name(&self) -> &str {
+        "DesktopEntry"
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let content = match self.ensure.as_deref() {
+            Some("absent") => None,
+            _ => Some(self.content(module_dir)),
+        };
+
+        vec![Box::new(AtomCompat::new(
+            Box::new(crate::atoms::desktop_entry::DesktopEntry::new(
+                self.path(),
+                content,
+            )),
+            "desktop_entry".to_string(),
+        ))]
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::{Action, ActionType};
+
+    fn obsidian() -> DesktopEntry {
+        DesktopEntry {
+            name: "Obsidian".to_string(),
+            exec: "/opt/obsidian/Obsidian.AppImage %U".to_string(),
+            icon: Some("icons/obsidian.png".to_string()),
+            categories: Some(vec!["Office".to_string(), "Utility".to_string()]),
+            comment: None,
+            terminal: None,
+            id: None,
+            ensure: None,
+        }
+    }
+
+    #[test]
+    fn test_desktop_entry_helper_function() {
+        match desktop_entry(obsidian()) {
+            ActionType::DesktopEntry(entry) => assert_eq!(entry.name, "Obsidian"),
+            _ => panic!("Expected DesktopEntry action type"),
+        }
+    }
+
+    #[test]
+    fn test_desktop_entry_content() {
+        let content = obsidian().content(Path::new("/modules/obsidian"));
+        assert_eq!(
+            content,
+            format!(
+                "{}\n[Desktop Entry]\nType=Application\nName=Obsidian\n\
+                 Exec=/opt/obsidian/Obsidian.AppImage %U\n\
+                 Icon=/modules/obsidian/icons/obsidian.png\nTerminal=false\n\
+                 Categories=Office;Utility;\n",
+                HEADER
+            )
+        );
+
+        let themed = DesktopEntry {
+            icon: Some("obsidian".to_string()),
+            comment: Some("Notes\nand more".to_string()),
+            ..obsidian()
+        };
+        let content = themed.content(Path::new("/modules/obsidian"));
+        assert!(content.contains("\nIcon=obsidian\n"), "{}", content);
+        assert!(
+            content.contains("\nComment=Notes\\nand more\n"),
+            "{}",
+            content
+        );
+    }
+
+    #[test]
+    fn test_desktop_entry_file_id() {
+        let entry = DesktopEntry {
+            name: "VS Code (Insiders)".to_string(),
+            ..obsidian()
+        };
+        assert_eq!(entry.file_id(), "vs-code-insiders");
+        assert_eq!(obsidian().file_id(), "obsidian");
+
+        let named = DesktopEntry {
+            id: Some("md.obsidian.Obsidian".to_string()),
+            ..obsidian()
+        };
+        assert!(
+            named
+                .path()
+                .ends_with("applications/md.obsidian.Obsidian.desktop")
+        );
+    }
+
+    #[test]
+    fn test_desktop_entry_plan() {
+        let action = obsidian();
+        assert_eq!(action.name(), "DesktopEntry");
+        let atoms = action.plan(Path::new("/modules/obsidian"));
+        assert_eq!(atoms.len(), 1);
+        assert!(atoms[0].describe().starts_with("Write desktop entry "));
+
+        let removal = DesktopEntry {
+            ensure: Some("absent".to_string()),
+            ..obsidian()
+        };
+        let atoms = removal.plan(Path::new("/modules/obsidian"));
+        assert!(atoms[0].describe().starts_with("Remove desktop entry "));
+        assert!(atoms[0].describe().ends_with("obsidian.desktop"));
+    }
+}
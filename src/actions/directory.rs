@@ -1,12 +1,22 @@
 use dhd_macros::{typescript_fn, typescript_type};
 
 use crate::atoms::AtomCompat;
-use std::path::{Path, PathBuf};
-
+use std::path::Path;
+
+/// Ensures a directory exists, creating it when missing
+///
+/// * `mode` - Unix permission bits for the directory (e.g. `0o700`), also
+///   applied when it already exists
+/// * `recursive` - Create missing parents too (default: `true`)
+/// * `owner` / `group` - Ownership to apply; requires root or `escalate: true`
 #[typescript_type]
 pub struct Directory {
     pub path: String,
     pub escalate: Option<bool>,
+    pub mode: Option<u32>,
+    pub recursive: Option<bool>,
+    pub owner: Option<String>,
+    pub group: Option<String>,
 }
 
 impl crate::actions::Action for Directory {
@@ -15,18 +25,18 @@ impl crate::actions::Action for Directory {
     }
 
     fn plan(&self, _module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
-        let directory_path = if self.path.starts_with("~/") {
-            let home = std::env::var("HOME").unwrap_or_else(|_| String::from("/home/user"));
-            PathBuf::from(self.path.replacen("~/", &format!("{}/", home), 1))
-        } else {
-            PathBuf::from(&self.path)
-        };
+        let directory_path = crate::paths::expand_path(&self.path);
 
         vec![Box::new(AtomCompat::new(
-            Box::new(crate::atoms::create_directory::CreateDirectory::new(
-                directory_path,
-                self.escalate.unwrap_or(false),
-            )),
+            Box::new(
+                crate::atoms::create_directory::CreateDirectory::new(
+                    directory_path,
+                    self.escalate.unwrap_or(false),
+                )
+                .with_mode(self.mode)
+                .with_recursive(self.recursive.unwrap_or(true))
+                .with_ownership(self.owner.clone(), self.group.clone()),
+            ),
             "directory".to_string(),
         ))]
     }
@@ -37,6 +47,11 @@ pub fn directory(config: Directory) -> crate::actions::ActionType {
     crate::actions::ActionType::Directory(config)
 }
 
+#[typescript_fn]
+pub fn ensure_dir(config: Directory) -> crate::actions::ActionType {
+    crate::actions::ActionType::Directory(config)
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -47,6 +62,10 @@ mod tests {
         let action = Directory {
             path: "/tmp/test".to_string(),
             escalate: Some(false),
+            mode: None,
+            recursive: None,
+            owner: None,
+            group: None,
         };
 
         assert_eq!(action.path, "/tmp/test");
@@ -58,6 +77,10 @@ mod tests {
         let action = directory(Directory {
             path: "/home/user/.config".to_string(),
             escalate: None,
+            mode: None,
+            recursive: None,
+            owner: None,
+            group: None,
         });
 
         match action {
@@ -74,6 +97,10 @@ mod tests {
         let action = Directory {
             path: "/tmp/test".to_string(),
             escalate: None,
+            mode: None,
+            recursive: None,
+            owner: None,
+            group: None,
         };
 
         assert_eq!(action.name(), "Directory");
@@ -84,6 +111,10 @@ mod tests {
         let action = Directory {
             path: "/tmp/test".to_string(),
             escalate: None,
+            mode: None,
+            recursive: None,
+            owner: None,
+            group: None,
         };
 
         let atoms = action.plan(std::path::Path::new("."));
@@ -97,6 +128,10 @@ mod tests {
         let action = Directory {
             path: "/etc/test".to_string(),
             escalate: Some(true),
+            mode: None,
+            recursive: None,
+            owner: None,
+            group: None,
         };
 
         assert_eq!(action.escalate, Some(true));
@@ -105,6 +140,22 @@ mod tests {
         assert_eq!(atoms.len(), 1);
     }
 
+    #[test]
+    fn test_ensure_dir_plans_mode() {
+        let action = ensure_dir(Directory {
+            path: "/tmp/test".to_string(),
+            escalate: None,
+            mode: Some(0o700),
+            recursive: Some(false),
+            owner: None,
+            group: None,
+        });
+
+        let atoms = action.plan(std::path::Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(atoms[0].describe(), "Create directory /tmp/test (mode 700)");
+    }
+
     #[test]
     fn test_directory_home_expansion() {
         unsafe {
@@ -114,6 +165,10 @@ mod tests {
         let action = Directory {
             path: "~/test".to_string(),
             escalate: None,
+            mode: None,
+            recursive: None,
+            owner: None,
+            group: None,
         };
 
         let atoms = action.plan(std::path::Path::new("."));
@@ -0,0 +1,153 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use crate::atoms::env_var::{
+    ENV_FILE, EnvEntry, EnvFileEntry, FISH_ENV_FILE, Shell, SourceEnvFile, parse_shells,
+};
+use std::path::Path;
+
+/// Set an environment variable or prepend to PATH for login and interactive shells
+///
+/// Entries are kept in `~/.config/dhd/env.sh` (`env.fish` for fish), which
+/// each shell's rc file sources from a block between dhd markers, unless
+/// `wire` is false.
+#[typescript_type]
+pub struct EnvVar {
+    /// Variable to set, together with `value`
+    pub name: Option<String>,
+    /// Value of `name`; `$VAR` references are expanded by the shell
+    pub value: Option<String>,
+    /// Directory to put in front of PATH, once (supports `~/`)
+    pub path_prepend: Option<String>,
+    /// Shells to configure: `bash`, `zsh` and `fish` (default: bash and zsh)
+    pub shells: Option<Vec<String>>,
+    /// Source the env file from the shells' rc files (default: true)
+    pub wire: Option<bool>,
+}
+
+impl EnvVar {
+    pub fn shells(&self) -> Vec<Shell> {
+        parse_shells(self.shells.as_deref())
+    }
+
+    fn entries(&self) -> Vec<EnvEntry> {
+        let mut entries = Vec::new();
+        if let (Some(name), Some(value)) = (&self.name, &self.value) {
+            entries.push(EnvEntry::Var {
+                name: name.clone(),
+                value: value.clone(),
+            });
+        }
+        if let Some(dir) = &self.path_prepend {
+            entries.push(EnvEntry::PathPrepend(dir.clone()));
+        }
+        entries
+    }
+}
+
+impl crate::actions::Action for EnvVar {
+    fn name(&self) -> &str {
+        "EnvVar"
+    }
+
+    fn plan(&self, _module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let home = crate::paths::expand_path("~");
+        let env_file = |shell: Shell| {
+            home.join(if shell.is_fish() {
+                FISH_ENV_FILE
+            } else {
+                ENV_FILE
+            })
+        };
+        let shells = self.shells();
+        let mut atoms: Vec<Box<dyn crate::atom::Atom>> = Vec::new();
+
+        // bash and zsh share one env file
+        let mut env_files: Vec<Shell> = Vec::new();
+        for shell in &shells {
+            if !env_files.iter().any(|s| s.is_fish() == shell.is_fish()) {
+                env_files.push(*shell);
+            }
+        }
+        for shell in env_files {
+            for entry in self.entries() {
+                atoms.push(Box::new(AtomCompat::new(
+                    Box::new(EnvFileEntry::new(env_file(shell), entry, shell.is_fish())),
+                    "env_file_entry".to_string(),
+                )));
+            }
+        }
+
+        if self.wire == Some(false) {
+            return atoms;
+        }
+        for shell in shells {
+            atoms.push(Box::new(AtomCompat::new(
+                Box::new(SourceEnvFile::new(
+                    home.join(shell.rc_file()),
+                    env_file(shell),
+                    shell.is_fish(),
+                )),
+                "source_env_file".to_string(),
+            )));
+        }
+
+        atoms
+    }
+}
+
+#[typescript_fn]
+pub fn env_var(config: EnvVar) -> crate::actions::ActionType {
+    crate::actions::ActionType::EnvVar(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    #[test]
+    fn test_env_var_plan() {
+        let action = EnvVar {
+            name: Some("EDITOR".to_string()),
+            value: Some("nvim".to_string()),
+            path_prepend: Some("~/.local/bin".to_string()),
+            shells: Some(vec!["zsh".to_string(), "fish".to_string()]),
+            wire: None,
+        };
+
+        assert_eq!(action.name(), "EnvVar");
+
+        let descriptions: Vec<String> = action
+            .plan(Path::new("/modules/shell"))
+            .iter()
+            .map(|atom| atom.describe())
+            .collect();
+        assert_eq!(descriptions.len(), 6);
+        assert!(descriptions[0].starts_with("Set EDITOR=nvim in "));
+        assert!(descriptions[0].ends_with(ENV_FILE));
+        assert!(descriptions[1].starts_with("Prepend ~/.local/bin to PATH in "));
+        assert!(descriptions[3].ends_with(FISH_ENV_FILE));
+        assert!(descriptions[4].ends_with(".zshrc"));
+        assert!(descriptions[5].ends_with("config.fish"));
+    }
+
+    #[test]
+    fn test_shells_default_to_bash_and_zsh() {
+        let action = EnvVar {
+            name: None,
+            value: None,
+            path_prepend: Some("/opt/bin".to_string()),
+            shells: None,
+            wire: None,
+        };
+        assert_eq!(action.shells(), vec![Shell::Bash, Shell::Zsh]);
+        assert_eq!(action.plan(Path::new(".")).len(), 3);
+
+        let unwired = EnvVar {
+            wire: Some(false),
+            ..action
+        };
+        assert_eq!(unwired.plan(Path::new(".")).len(), 1);
+    }
+}
@@ -13,7 +13,7 @@ use super::Action;
 pub struct ExecuteCommand {
     /// Optional shell to use for executing the command.
     ///
-    /// If not specified, defaults to "sh".
+    /// If not specified, defaults to `shell` of dhd.config.ts, or "sh".
     pub shell: Option<String>,
     /// The command to execute.
     ///
@@ -48,7 +48,7 @@ impl Action for ExecuteCommand {
     }
 
     fn plan(&self, _module_dir: &std::path::Path) -> Vec<Box<dyn crate::atom::Atom>> {
-        let shell = self.shell.clone().unwrap_or_else(|| "sh".to_string());
+        let shell = crate::shell::choose(self.shell.as_deref());
 
         let full_command = if let Some(args) = &self.args {
             let mut parts = vec![self.command.clone()];
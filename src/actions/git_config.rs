@@ -3,6 +3,7 @@ use dhd_macros::{typescript_type, typescript_fn};
 
 use crate::actions::Action;
 use crate::atoms::AtomCompat;
+use crate::atoms::git_config::GitConfigScope;
 use std::path::Path;
 
 #[typescript_type]
@@ -16,6 +17,20 @@ pub struct GitConfigEntry {
 }
 
 /// Git configuration that accepts a nested object structure
+///
+/// `global`, `system` and `local` each write their whole config file. To set
+/// some keys and leave the rest of the file to other tools, give `entries`
+/// instead:
+///
+/// * `entries` - Keys and their values, e.g. `{ "user.name": "Dev" }`
+///   (nested objects work too); an array of values makes a multi-valued key.
+///   Only keys whose values differ are written, with `git config`
+/// * `scope` - `"global"` (default), `"local"` or `"system"`; `local` is the
+///   repository of the module directory
+/// * `path` - Config file to edit instead of the scope's, relative to the
+///   module or absolute
+/// * `ensure` - `"present"` (default) sets the keys, `"absent"` removes them
+///   whatever their values
 #[derive(Serialize, Deserialize)]
 #[typescript_type]
 pub struct GitConfig {
@@ -30,6 +45,35 @@ pub struct GitConfig {
     /// Local git configuration (repository-specific)
     #[serde(skip_serializing_if = "Option::is_none")]
     pub local: Option<serde_json::Value>,
+
+    /// Keys to set with `git config`, leaving the rest of the file alone
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub entries: Option<serde_json::Value>,
+
+    /// Scope of `entries`: global (default), local or system
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub scope: Option<String>,
+
+    /// Config file `entries` go to instead of the scope's
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub path: Option<String>,
+
+    /// Whether the keys of `entries` are set ("present") or removed ("absent")
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub ensure: Option<String>,
+}
+
+/// The scope `scope` names, if it's a valid one
+pub fn parse_scope(scope: &str) -> Result<GitConfigScope, String> {
+    match scope {
+        "global" => Ok(GitConfigScope::Global),
+        "local" => Ok(GitConfigScope::Local),
+        "system" => Ok(GitConfigScope::System),
+        _ => Err(format!(
+            "'scope' must be 'global', 'local' or 'system', got '{}'",
+            scope
+        )),
+    }
 }
 
 impl Action for GitConfig {
@@ -37,8 +81,38 @@ impl Action for GitConfig {
         "GitConfig"
     }
 
-    fn plan(&self, _module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
         let mut atoms: Vec<Box<dyn crate::atom::Atom>> = Vec::new();
+
+        if let Some(entries) = &self.entries {
+            let mut keys: Vec<(String, Vec<String>)> = Vec::new();
+            for entry in process_config_value(entries) {
+                match keys.iter_mut().find(|(key, _)| *key == entry.key) {
+                    Some((_, values)) => values.push(entry.value),
+                    None => keys.push((entry.key, vec![entry.value])),
+                }
+            }
+            if !keys.is_empty() {
+                atoms.push(Box::new(AtomCompat::new(
+                    Box::new(crate::atoms::git_config::GitConfigKeys {
+                        entries: keys,
+                        // The loader rejects invalid scopes
+                        scope: self
+                            .scope
+                            .as_deref()
+                            .and_then(|scope| parse_scope(scope).ok())
+                            .unwrap_or(GitConfigScope::Global),
+                        file: self
+                            .path
+                            .as_deref()
+                            .map(|path| crate::paths::resolve(module_dir, path)),
+                        dir: module_dir.to_path_buf(),
+                        absent: self.ensure.as_deref() == Some("absent"),
+                    }),
+                    "git_config".to_string(),
+                )));
+            }
+        }
         
         // Process global config
         if let Some(global_config) = &self.global {
@@ -169,4 +243,48 @@ fn process_section(prefix: &str, value: &serde_json::Value, entries: &mut Vec<Gi
 #[typescript_fn]
 pub fn git_config(config: GitConfig) -> crate::actions::ActionType {
     crate::actions::ActionType::GitConfig(config)
-}
\ No newline at end of file
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_git_config_entries_plan() {
+        let action = GitConfig {
+            global: None,
+            system: None,
+            local: None,
+            entries: Some(serde_json::json!({
+                "user.name": "Dev",
+                "user": { "email": "dev@example.com" },
+                "credential.helper": ["cache", "store"]
+            })),
+            scope: Some("local".to_string()),
+            path: None,
+            ensure: None,
+        };
+        let atoms = action.plan(Path::new("/modules/git"));
+        assert_eq!(atoms.len(), 1);
+        let description = atoms[0].describe();
+        assert!(description.starts_with("Set "));
+        assert!(description.contains("user.email"));
+        assert!(description.ends_with("in local git config"));
+
+        let absent = GitConfig {
+            entries: Some(serde_json::json!({ "user.signingkey": "" })),
+            scope: None,
+            path: Some("~/.config/git/work".to_string()),
+            ensure: Some("absent".to_string()),
+            ..action
+        };
+        let atoms = absent.plan(Path::new("/modules/git"));
+        assert!(atoms[0].describe().starts_with("Unset user.signingkey in git config "));
+    }
+
+    #[test]
+    fn test_parse_scope() {
+        assert!(parse_scope("global").is_ok());
+        assert!(parse_scope("user").unwrap_err().contains("'scope' must be"));
+    }
+}
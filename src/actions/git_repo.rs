@@ -0,0 +1,106 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use crate::atoms::retry::{Retry, RetryPolicy};
+use std::path::Path;
+
+#[typescript_type]
+pub struct GitRepo {
+    /// Repository URL to clone from
+    pub url: String,
+    /// Where to check out the repository
+    pub path: String,
+    /// Branch, tag or commit to check out (default: the remote's default branch)
+    pub r#ref: Option<String>,
+    /// Create a shallow clone with this many commits
+    pub depth: Option<u32>,
+    /// Fetch and check out `ref` when the repository already exists (default: false)
+    pub update: Option<bool>,
+    /// Retries of clones and fetches failing on network errors (default: 2)
+    pub retries: Option<u32>,
+    /// Seconds to wait before the first retry, doubling after each (default: 1)
+    pub retry_delay: Option<u64>,
+}
+
+impl crate::actions::Action for GitRepo {
+    fn name(&self) -> &str {
+        "GitRepo"
+    }
+
+    fn plan(&self, _module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let path = crate::paths::expand_path(&self.path);
+
+        vec![Box::new(AtomCompat::new(
+            Box::new(Retry::new(
+                Box::new(crate::atoms::git_repo::GitRepo::new(
+                    self.url.clone(),
+                    path,
+                    self.r#ref.clone(),
+                    self.depth,
+                    self.update.unwrap_or(false),
+                )),
+                RetryPolicy::new(self.retries, self.retry_delay),
+            )),
+            "git_repo".to_string(),
+        ))]
+    }
+}
+
+#[typescript_fn]
+pub fn git_repo(config: GitRepo) -> crate::actions::ActionType {
+    crate::actions::ActionType::GitRepo(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    fn zsh_autosuggestions() -> GitRepo {
+        GitRepo {
+            url: "https://github.com/zsh-users/zsh-autosuggestions".to_string(),
+            path: "~/.oh-my-zsh/custom/plugins/zsh-autosuggestions".to_string(),
+            r#ref: None,
+            depth: Some(1),
+            update: None,
+            retries: None,
+            retry_delay: None,
+        }
+    }
+
+    #[test]
+    fn test_git_repo_helper_function() {
+        let action = git_repo(GitRepo {
+            r#ref: Some("v0.7.0".to_string()),
+            ..zsh_autosuggestions()
+        });
+
+        match action {
+            crate::actions::ActionType::GitRepo(repo) => {
+                assert_eq!(repo.r#ref, Some("v0.7.0".to_string()));
+                assert_eq!(repo.depth, Some(1));
+            }
+            _ => panic!("Expected GitRepo action type"),
+        }
+    }
+
+    #[test]
+    fn test_git_repo_name() {
+        assert_eq!(zsh_autosuggestions().name(), "GitRepo");
+    }
+
+    #[test]
+    fn test_git_repo_plan() {
+        let action = GitRepo {
+            update: Some(true),
+            ..zsh_autosuggestions()
+        };
+
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        let description = atoms[0].describe();
+        assert!(description.contains("zsh-users/zsh-autosuggestions"));
+        assert!(!description.contains("~/"));
+        assert!(description.contains("(update)"));
+    }
+}
@@ -0,0 +1,115 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use crate::atoms::gpg_key::KeySource;
+use crate::atoms::retry::{Retry, RetryPolicy};
+use std::path::Path;
+
+/// Import a GPG public key, skipping it when it is in the keyring already
+///
+/// Give one of `keyUrl`, `keyFile` or `keyId`; a `keyId` alone is fetched
+/// from `keyserver`. `keyId` also lets DHD tell the key is imported without
+/// downloading it.
+#[typescript_type]
+pub struct GpgKey {
+    /// Key ID or fingerprint
+    pub key_id: Option<String>,
+    /// URL serving the key, armored or binary
+    pub key_url: Option<String>,
+    /// Key file relative to the module (supports `~/`)
+    pub key_file: Option<String>,
+    /// Keyserver a bare `keyId` is fetched from (default: `keyserver.ubuntu.com`)
+    pub keyserver: Option<String>,
+    /// Ownertrust to set: `unknown`, `never`, `marginal`, `full` or `ultimate`
+    pub trust: Option<String>,
+    /// Write the key to this keyring file instead, e.g. for apt's `signed-by=`
+    pub keyring: Option<String>,
+    /// Write `keyring` through sudo
+    pub escalate: Option<bool>,
+    /// Retries after a network failure (default: 2)
+    pub retries: Option<u32>,
+    /// Seconds to wait before the first retry, doubling after each (default: 1)
+    pub retry_delay: Option<u64>,
+}
+
+impl crate::actions::Action for GpgKey {
+    fn name(&self) -> &str {
+        "GpgKey"
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let source = match (&self.key_url, &self.key_file) {
+            (Some(url), _) => KeySource::Url(url.clone()),
+            (None, Some(file)) => KeySource::File(crate::paths::resolve(module_dir, file)),
+            (None, None) => KeySource::Keyserver {
+                server: self
+                    .keyserver
+                    .clone()
+                    .unwrap_or_else(|| "keyserver.ubuntu.com".to_string()),
+                id: self.key_id.clone().unwrap_or_default(),
+            },
+        };
+
+        let key = crate::atoms::gpg_key::GpgKey::new(source, self.key_id.clone())
+            .with_trust(self.trust.clone())
+            .with_keyring(
+                self.keyring
+                    .as_ref()
+                    .map(|keyring| crate::paths::expand_path(keyring)),
+                self.escalate.unwrap_or(false),
+            );
+
+        vec![Box::new(AtomCompat::new(
+            Box::new(Retry::new(
+                Box::new(key),
+                RetryPolicy::new(self.retries, self.retry_delay),
+            )),
+            "gpg_key".to_string(),
+        ))]
+    }
+}
+
+#[typescript_fn]
+pub fn gpg_key(config: GpgKey) -> crate::actions::ActionType {
+    crate::actions::ActionType::GpgKey(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    #[test]
+    fn test_gpg_key_plan() {
+        let mut action = GpgKey {
+            key_id: Some("0xABCD1234".to_string()),
+            key_url: None,
+            key_file: None,
+            keyserver: None,
+            trust: Some("ultimate".to_string()),
+            keyring: None,
+            escalate: None,
+            retries: None,
+            retry_delay: None,
+        };
+
+        assert_eq!(action.name(), "GpgKey");
+
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(
+            atoms[0].describe(),
+            "Import GPG key 0xABCD1234 (trust: ultimate)"
+        );
+
+        action.key_id = None;
+        action.trust = None;
+        action.key_file = Some("keys/docker.asc".to_string());
+        action.keyring = Some("/etc/apt/keyrings/docker.gpg".to_string());
+        let atoms = action.plan(Path::new("/modules"));
+        assert_eq!(
+            atoms[0].describe(),
+            "Write GPG key from /modules/keys/docker.asc to /etc/apt/keyrings/docker.gpg"
+        );
+    }
+}
@@ -1,7 +1,8 @@
 use dhd_macros::{typescript_fn, typescript_type};
 
 use crate::atoms::AtomCompat;
-use std::path::{Path, PathBuf};
+use crate::atoms::retry::{Retry, RetryPolicy};
+use std::path::Path;
 
 #[typescript_type]
 pub struct Checksum {
@@ -15,6 +16,10 @@ pub struct HttpDownload {
     pub destination: String,
     pub checksum: Option<Checksum>,
     pub mode: Option<u32>,
+    /// Retries of downloads failing on network errors (default: 2)
+    pub retries: Option<u32>,
+    /// Seconds to wait before the first retry, doubling after each (default: 1)
+    pub retry_delay: Option<u64>,
 }
 
 impl crate::actions::Action for HttpDownload {
@@ -23,12 +28,7 @@ impl crate::actions::Action for HttpDownload {
     }
 
     fn plan(&self, _module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
-        let destination_path = if self.destination.starts_with("~/") {
-            let home = std::env::var("HOME").unwrap_or_else(|_| String::from("/home/user"));
-            PathBuf::from(self.destination.replacen("~/", &format!("{}/", home), 1))
-        } else {
-            PathBuf::from(&self.destination)
-        };
+        let destination_path = crate::paths::expand_path(&self.destination);
 
         let checksum_str = self
             .checksum
@@ -36,11 +36,14 @@ impl crate::actions::Action for HttpDownload {
             .map(|c| format!("{}:{}", c.algorithm, c.value));
 
         vec![Box::new(AtomCompat::new(
-            Box::new(crate::atoms::http_download::HttpDownload::new(
-                self.url.clone(),
-                destination_path,
-                checksum_str,
-                self.mode,
+            Box::new(Retry::new(
+                Box::new(crate::atoms::http_download::HttpDownload::new(
+                    self.url.clone(),
+                    destination_path,
+                    checksum_str,
+                    self.mode,
+                )),
+                RetryPolicy::new(self.retries, self.retry_delay),
             )),
             "http_download".to_string(),
         ))]
@@ -80,6 +83,8 @@ mod tests {
             destination: "/usr/local/bin/kubectl".to_string(),
             checksum: Some(checksum),
             mode: Some(0o755),
+            retries: None,
+            retry_delay: None,
         };
 
         assert_eq!(action.url, "https://github.com/kubernetes/kubectl/releases/download/v1.28.0/kubectl");
@@ -95,6 +100,8 @@ mod tests {
             destination: "/tmp/helm.tar.gz".to_string(),
             checksum: None,
             mode: None,
+            retries: None,
+            retry_delay: None,
         });
 
         match action {
@@ -115,6 +122,8 @@ mod tests {
             destination: "/tmp/terraform.zip".to_string(),
             checksum: None,
             mode: None,
+            retries: None,
+            retry_delay: None,
         };
 
         assert_eq!(action.name(), "HttpDownload");
@@ -127,6 +136,8 @@ mod tests {
             destination: "/opt/node-v20.tar.xz".to_string(),
             checksum: None,
             mode: None,
+            retries: None,
+            retry_delay: None,
         };
 
         let atoms = action.plan(std::path::Path::new("."));
@@ -147,6 +158,8 @@ mod tests {
             destination: "/usr/local/bin/cosign".to_string(),
             checksum: Some(checksum),
             mode: Some(0o755),
+            retries: None,
+            retry_delay: None,
         };
 
         let atoms = action.plan(std::path::Path::new("."));
@@ -165,6 +178,8 @@ mod tests {
             destination: "~/tools/go1.21.5.tar.gz".to_string(),
             checksum: None,
             mode: None,
+            retries: None,
+            retry_delay: None,
         };
 
         let atoms = action.plan(std::path::Path::new("."));
@@ -184,6 +199,8 @@ mod tests {
             destination: "/tmp/docker.tgz".to_string(),
             checksum: Some(checksum),
             mode: None,
+            retries: None,
+            retry_delay: None,
         };
 
         // Test that checksum gets formatted correctly in the atom creation
@@ -0,0 +1,65 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use std::path::Path;
+
+/// Keep one line in a file dhd doesn't fully own
+#[typescript_type]
+pub struct LineInFile {
+    /// File to edit (supports `~/`); created if missing
+    pub path: String,
+    /// The exact line; appended when no line of the file matches it
+    pub line: String,
+    /// Remove every matching line instead
+    pub absent: Option<bool>,
+    /// Write the file through sudo, e.g. for `/etc/hosts`
+    pub escalate: Option<bool>,
+}
+
+impl crate::actions::Action for LineInFile {
+    fn name(&self) -> &str {
+        "LineInFile"
+    }
+
+    fn plan(&self, _module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        vec![Box::new(AtomCompat::new(
+            Box::new(crate::atoms::line_in_file::LineInFile::new(
+                crate::paths::expand_path(&self.path),
+                self.line.clone(),
+                !self.absent.unwrap_or(false),
+                self.escalate.unwrap_or(false),
+            )),
+            "line_in_file".to_string(),
+        ))]
+    }
+}
+
+#[typescript_fn]
+pub fn line_in_file(config: LineInFile) -> crate::actions::ActionType {
+    crate::actions::ActionType::LineInFile(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    #[test]
+    fn test_line_in_file_plan() {
+        let action = LineInFile {
+            path: "/etc/hosts".to_string(),
+            line: "10.0.0.5 nas".to_string(),
+            absent: Some(true),
+            escalate: None,
+        };
+
+        assert_eq!(action.name(), "LineInFile");
+
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(
+            atoms[0].describe(),
+            "Remove line '10.0.0.5 nas' from /etc/hosts"
+        );
+    }
+}
@@ -1,32 +1,13 @@
 use super::Action;
 use crate::atoms::AtomCompat;
 use dhd_macros::{typescript_fn, typescript_type};
-use directories::BaseDirs;
 use std::path::PathBuf;
 
 /// Resolve XDG paths like relative config paths to their full locations
 fn resolve_xdg_target(target: &str) -> PathBuf {
-    let target_path = PathBuf::from(target);
-
-    // If it's already absolute, return as-is
-    if target_path.is_absolute() {
-        return target_path;
-    }
-
-    // Handle tilde expansion for home directory
-    if let Some(stripped) = target.strip_prefix("~/") {
-        if let Some(base_dirs) = BaseDirs::new() {
-            return base_dirs.home_dir().join(stripped);
-        }
-    }
-
-    // If it's a relative path, assume it's relative to XDG_CONFIG_HOME
-    if let Some(base_dirs) = BaseDirs::new() {
-        base_dirs.config_dir().join(target)
-    } else {
-        // Fallback if we can't determine base directories
-        PathBuf::from(".config").join(target)
-    }
+    // Absolute paths, also after expanding `~`, are used as-is; relative
+    // paths are relative to XDG_CONFIG_HOME
+    crate::paths::resolve(&crate::platform::config_dir(), target)
 }
 
 #[typescript_type]
@@ -59,11 +40,7 @@ impl Action for LinkFile {
         let source_path = resolve_xdg_target(&self.source);
 
         // Resolve target path relative to module directory if it's not absolute
-        let target_path = if PathBuf::from(&self.target).is_absolute() {
-            PathBuf::from(&self.target)
-        } else {
-            module_dir.join(&self.target)
-        };
+        let target_path = crate::paths::resolve(module_dir, &self.target);
 
         vec![Box::new(AtomCompat::new(
             Box::new(crate::atoms::link_file::LinkFile {
@@ -1,42 +1,90 @@
 use dhd_macros::typescript_enum;
 
+pub mod block_in_file;
 pub mod compat;
 pub mod condition;
 pub mod conditional;
 pub mod copy_file;
+pub mod cron;
 pub mod dconf_import;
+pub mod decrypt_file;
+pub mod desktop_entry;
 pub mod directory;
+pub mod env_var;
 pub mod execute_command;
 pub mod git_config;
+pub mod git_repo;
+pub mod gpg_key;
 pub mod gnome_extensions;
 pub mod http_download;
 pub mod link_directory;
+pub mod line_in_file;
 pub mod link_file;
+pub mod notify;
+pub mod ordered;
 pub mod package_install;
 pub mod package_remove;
+pub mod package_repo;
+pub mod plugin;
+pub mod reboot;
+pub mod remote_file;
+pub mod script_install;
+pub mod shell_command;
+pub mod shell_source;
+pub mod ssh_config;
+pub mod stow;
+pub mod symlink;
+pub mod tagged;
 pub mod systemd_manage;
 pub mod systemd_service;
 pub mod systemd_socket;
+pub mod template;
+pub mod template_dir;
+pub mod verified;
 
+pub use block_in_file::{BlockInFile, block_in_file};
 pub use condition::{
-    Condition, ComparisonOperator, all_of, any_of, and, or, command, command_exists, 
-    command_succeeds, directory_exists, env_var, file_exists, not, property, secret_exists,
+    Condition, ComparisonOperator, HostFacts, all_of, any_of, and, or, command, command_exists, 
+    command_succeeds, directory_exists, env_var, file_exists, host, not, property, secret_exists,
 };
 pub use conditional::{ConditionalAction, only_if, skip_if};
 pub use copy_file::{CopyFile, copy_file};
+pub use cron::{Cron, cron};
 pub use dconf_import::{DconfImport, dconf_import};
-pub use directory::{Directory, directory};
+pub use decrypt_file::{DecryptFile, decrypt_file};
+pub use desktop_entry::{DesktopEntry, desktop_entry};
+pub use directory::{Directory, directory, ensure_dir};
+pub use env_var::{EnvVar, env_var as set_env_var};
 pub use execute_command::ExecuteCommand;
 pub use git_config::{GitConfig, git_config};
+pub use git_repo::{GitRepo, git_repo};
+pub use gpg_key::{GpgKey, gpg_key};
 pub use gnome_extensions::{InstallGnomeExtensions, install_gnome_extensions};
 pub use http_download::{HttpDownload, http_download};
+pub use line_in_file::{LineInFile, line_in_file};
 pub use link_directory::{LinkDirectory, link_directory};
 pub use link_file::{LinkFile, link_file};
+pub use notify::NotifyAction;
+pub use ordered::{OrderedAction, order_actions};
 pub use package_install::{PackageInstall, package_install};
 pub use package_remove::{PackageRemove, package_remove};
+pub use package_repo::{PackageRepo, package_repo};
+pub use plugin::{Plugin, plugin};
+pub use reboot::RebootAction;
+pub use remote_file::{RemoteFile, RemoteFileVariant, remote_file};
+pub use script_install::{ScriptInstall, script_install};
+pub use shell_command::{ShellCommand, command as shell_command};
+pub use shell_source::{ShellSource, shell_source};
+pub use ssh_config::{SshConfig, SshHost, ssh_config};
+pub use stow::{Stow, stow};
+pub use symlink::{Symlink, symlink};
+pub use tagged::TaggedAction;
 pub use systemd_manage::{SystemdManage, systemd_manage};
 pub use systemd_service::{SystemdService, systemd_service};
 pub use systemd_socket::{SystemdSocket, systemd_socket};
+pub use template::{Template, template};
+pub use template_dir::{TemplateDir, template_dir};
+pub use verified::VerifiedAction;
 
 #[typescript_enum]
 pub enum ActionType {
@@ -55,6 +103,30 @@ pub enum ActionType {
     PackageRemove(PackageRemove),
     SystemdManage(SystemdManage),
     GitConfig(GitConfig),
+    Symlink(Symlink),
+    Template(Template),
+    ShellCommand(ShellCommand),
+    GitRepo(GitRepo),
+    DecryptFile(DecryptFile),
+    EnvVar(EnvVar),
+    BlockInFile(BlockInFile),
+    LineInFile(LineInFile),
+    RemoteFile(RemoteFile),
+    Cron(Cron),
+    GpgKey(GpgKey),
+    PackageRepo(PackageRepo),
+    Stow(Stow),
+    Notify(NotifyAction),
+    Plugin(Plugin),
+    Tagged(TaggedAction),
+    ShellSource(ShellSource),
+    Verified(VerifiedAction),
+    Ordered(OrderedAction),
+    Reboot(RebootAction),
+    TemplateDir(TemplateDir),
+    DesktopEntry(DesktopEntry),
+    ScriptInstall(ScriptInstall),
+    SshConfig(SshConfig),
 }
 
 pub trait Action {
@@ -80,6 +152,30 @@ impl Action for ActionType {
             ActionType::PackageRemove(action) => action.name(),
             ActionType::SystemdManage(action) => action.name(),
             ActionType::GitConfig(action) => action.name(),
+            ActionType::Symlink(action) => action.name(),
+            ActionType::Template(action) => action.name(),
+            ActionType::ShellCommand(action) => action.name(),
+            ActionType::GitRepo(action) => action.name(),
+            ActionType::DecryptFile(action) => action.name(),
+            ActionType::EnvVar(action) => action.name(),
+            ActionType::BlockInFile(action) => action.name(),
+            ActionType::LineInFile(action) => action.name(),
+            ActionType::RemoteFile(action) => action.name(),
+            ActionType::Cron(action) => action.name(),
+            ActionType::GpgKey(action) => action.name(),
+            ActionType::PackageRepo(action) => action.name(),
+            ActionType::Stow(action) => action.name(),
+            ActionType::ShellSource(action) => action.name(),
+            ActionType::Notify(action) => action.name(),
+            ActionType::Plugin(action) => action.name(),
+            ActionType::Tagged(action) => action.name(),
+            ActionType::Verified(action) => action.name(),
+            ActionType::Ordered(action) => action.name(),
+            ActionType::Reboot(action) => action.name(),
+            ActionType::TemplateDir(action) => action.name(),
+            ActionType::DesktopEntry(action) => action.name(),
+            ActionType::ScriptInstall(action) => action.name(),
+            ActionType::SshConfig(action) => action.name(),
         }
     }
 
@@ -100,6 +196,256 @@ impl Action for ActionType {
             ActionType::PackageRemove(action) => action.plan(module_dir),
             ActionType::SystemdManage(action) => action.plan(module_dir),
             ActionType::GitConfig(action) => action.plan(module_dir),
+            ActionType::Symlink(action) => action.plan(module_dir),
+            ActionType::Template(action) => action.plan(module_dir),
+            ActionType::ShellCommand(action) => action.plan(module_dir),
+            ActionType::GitRepo(action) => action.plan(module_dir),
+            ActionType::DecryptFile(action) => action.plan(module_dir),
+            ActionType::EnvVar(action) => action.plan(module_dir),
+            ActionType::BlockInFile(action) => action.plan(module_dir),
+            ActionType::LineInFile(action) => action.plan(module_dir),
+            ActionType::RemoteFile(action) => action.plan(module_dir),
+            ActionType::Cron(action) => action.plan(module_dir),
+            ActionType::GpgKey(action) => action.plan(module_dir),
+            ActionType::PackageRepo(action) => action.plan(module_dir),
+            ActionType::Stow(action) => action.plan(module_dir),
+            ActionType::ShellSource(action) => action.plan(module_dir),
+            ActionType::Notify(action) => action.plan(module_dir),
+            ActionType::Plugin(action) => action.plan(module_dir),
+            ActionType::Tagged(action) => action.plan(module_dir),
+            ActionType::Verified(action) => action.plan(module_dir),
+            ActionType::Ordered(action) => action.plan(module_dir),
+            ActionType::Reboot(action) => action.plan(module_dir),
+            ActionType::TemplateDir(action) => action.plan(module_dir),
+            ActionType::DesktopEntry(action) => action.plan(module_dir),
+            ActionType::ScriptInstall(action) => action.plan(module_dir),
+            ActionType::SshConfig(action) => action.plan(module_dir),
+        }
+    }
+}
+
+/// What a file action with `ensure: "absent"` plans instead: taking back
+/// what earlier applies did at `target`
+pub(crate) fn retire(target: std::path::PathBuf, name: &str) -> Vec<Box<dyn crate::atom::Atom>> {
+    vec![Box::new(crate::atoms::AtomCompat::new(
+        Box::new(crate::atoms::retire_file::RetireFile { path: target }),
+        name.to_string(),
+    ))]
+}
+
+/// The sources and targets a file action works on: `source` and `target`
+/// themselves, or, when `source` is a glob, each match and a target of the
+/// same name in the `target` directory
+///
+/// Directories among the matches are left out unless `dirs`. A glob that
+/// matches nothing is warned about, since the action then does nothing.
+pub(crate) fn glob_sources(
+    source: std::path::PathBuf,
+    target: std::path::PathBuf,
+    name: &str,
+    dirs: bool,
+) -> Vec<(std::path::PathBuf, std::path::PathBuf)> {
+    let Some(matches) = crate::paths::glob(&source) else {
+        return vec![(source, target)];
+    };
+    let pairs: Vec<_> = matches
+        .into_iter()
+        .filter(|path| dirs || !path.is_dir())
+        .filter_map(|path| {
+            let file_name = path.file_name()?.to_owned();
+            Some((path, target.join(file_name)))
+        })
+        .collect();
+    if pairs.is_empty() {
+        log::warn!("{}: no files match {}", name, source.display());
+    }
+    pairs
+}
+
+/// Function names modules can call to declare an action, as listed in load errors
+pub const ACTION_TYPES: &[&str] = &[
+    "packageInstall",
+    "linkFile",
+    "linkDirectory",
+    "executeCommand",
+    "command",
+    "copyFile",
+    "directory",
+    "ensureDir",
+    "httpDownload",
+    "systemdService",
+    "systemdSocket",
+    "systemdManage",
+    "packageRemove",
+    "dconfImport",
+    "installGnomeExtensions",
+    "gitConfig",
+    "gitRepo",
+    "symlink",
+    "template",
+    "templateDir",
+    "decryptFile",
+    "envVar",
+    "blockInFile",
+    "lineInFile",
+    "remoteFile",
+    "scriptInstall",
+    "cron",
+    "desktopEntry",
+    "sshConfig",
+    "gpgKey",
+    "packageRepo",
+    "stow",
+    "shellSource",
+    "plugin",
+];
+
+impl ActionType {
+    /// The function name that declares this action, e.g. `packageInstall`
+    pub fn type_name(&self) -> &'static str {
+        match self {
+            ActionType::PackageInstall(_) => "packageInstall",
+            ActionType::LinkFile(_) => "linkFile",
+            ActionType::LinkDirectory(_) => "linkDirectory",
+            ActionType::ExecuteCommand(_) => "executeCommand",
+            ActionType::CopyFile(_) => "copyFile",
+            ActionType::Directory(_) => "ensureDir",
+            ActionType::HttpDownload(_) => "httpDownload",
+            ActionType::SystemdSocket(_) => "systemdSocket",
+            ActionType::SystemdService(_) => "systemdService",
+            ActionType::Conditional(action) => action.action.type_name(),
+            ActionType::DconfImport(_) => "dconfImport",
+            ActionType::InstallGnomeExtensions(_) => "installGnomeExtensions",
+            ActionType::PackageRemove(_) => "packageRemove",
+            ActionType::SystemdManage(_) => "systemdManage",
+            ActionType::GitConfig(_) => "gitConfig",
+            ActionType::Symlink(_) => "symlink",
+            ActionType::Template(_) => "template",
+            ActionType::ShellCommand(_) => "command",
+            ActionType::GitRepo(_) => "gitRepo",
+            ActionType::DecryptFile(_) => "decryptFile",
+            ActionType::EnvVar(_) => "envVar",
+            ActionType::BlockInFile(_) => "blockInFile",
+            ActionType::LineInFile(_) => "lineInFile",
+            ActionType::RemoteFile(_) => "remoteFile",
+            ActionType::Cron(_) => "cron",
+            ActionType::GpgKey(_) => "gpgKey",
+            ActionType::PackageRepo(_) => "packageRepo",
+            ActionType::Stow(_) => "stow",
+            ActionType::ShellSource(_) => "shellSource",
+            ActionType::Notify(action) => action.action.type_name(),
+            ActionType::Plugin(_) => "plugin",
+            ActionType::Tagged(action) => action.action.type_name(),
+            ActionType::Verified(action) => action.action.type_name(),
+            ActionType::Reboot(action) => action.action.type_name(),
+            ActionType::Ordered(action) => action.action.type_name(),
+            ActionType::TemplateDir(_) => "templateDir",
+            ActionType::DesktopEntry(_) => "desktopEntry",
+            ActionType::ScriptInstall(_) => "scriptInstall",
+            ActionType::SshConfig(_) => "sshConfig",
+        }
+    }
+
+    /// Run this action as root, as `become: true` asks; false if it always
+    /// runs as the user
+    pub fn escalate(&mut self) -> bool {
+        match self {
+            ActionType::ExecuteCommand(action) => action.escalate = Some(true),
+            ActionType::CopyFile(action) => action.escalate = true,
+            ActionType::Directory(action) => action.escalate = Some(true),
+            ActionType::BlockInFile(action) => action.escalate = Some(true),
+            ActionType::LineInFile(action) => action.escalate = Some(true),
+            ActionType::GpgKey(action) => action.escalate = Some(true),
+            ActionType::ScriptInstall(action) => action.escalate = Some(true),
+            ActionType::Conditional(action) => return action.action.escalate(),
+            ActionType::Notify(action) => return action.action.escalate(),
+            ActionType::Tagged(action) => return action.action.escalate(),
+            ActionType::Verified(action) => return action.action.escalate(),
+            ActionType::Reboot(action) => return action.action.escalate(),
+            ActionType::Ordered(action) => return action.action.escalate(),
+            _ => return false,
+        }
+        true
+    }
+
+    /// Whether this action runs as root through `become` or `escalate`
+    pub fn escalates(&self) -> bool {
+        match self {
+            ActionType::ExecuteCommand(action) => action.escalate == Some(true),
+            ActionType::CopyFile(action) => action.escalate,
+            ActionType::Directory(action) => action.escalate == Some(true),
+            ActionType::BlockInFile(action) => action.escalate == Some(true),
+            ActionType::LineInFile(action) => action.escalate == Some(true),
+            ActionType::GpgKey(action) => action.escalate == Some(true),
+            ActionType::ScriptInstall(action) => action.escalate == Some(true),
+            ActionType::Conditional(action) => action.action.escalates(),
+            ActionType::Notify(action) => action.action.escalates(),
+            ActionType::Tagged(action) => action.action.escalates(),
+            ActionType::Verified(action) => action.action.escalates(),
+            ActionType::Reboot(action) => action.action.escalates(),
+            ActionType::Ordered(action) => action.action.escalates(),
+            _ => false,
+        }
+    }
+
+    /// Make this a handler's action, which runs because a notifying action
+    /// changed something; services are then refreshed whenever it runs
+    pub fn as_handler(&mut self) {
+        match self {
+            ActionType::SystemdService(action) => action.notified = true,
+            ActionType::Conditional(action) => action.action.as_handler(),
+            ActionType::Notify(action) => action.action.as_handler(),
+            ActionType::Tagged(action) => action.action.as_handler(),
+            ActionType::Verified(action) => action.action.as_handler(),
+            ActionType::Reboot(action) => action.action.as_handler(),
+            ActionType::Ordered(action) => action.action.as_handler(),
+            _ => {}
+        }
+    }
+
+    /// Whether this action is declared with `name`, counting `directory` as
+    /// the older name of `ensureDir`
+    pub fn is_type(&self, name: &str) -> bool {
+        let name = if name == "directory" { "ensureDir" } else { name };
+        self.type_name() == name
+    }
+
+    /// The tags set on this action with `tags`
+    pub fn tags(&self) -> &[String] {
+        match self {
+            ActionType::Tagged(action) => &action.tags,
+            ActionType::Conditional(action) => action.action.tags(),
+            ActionType::Notify(action) => action.action.tags(),
+            ActionType::Verified(action) => action.action.tags(),
+            ActionType::Reboot(action) => action.action.tags(),
+            ActionType::Ordered(action) => action.action.tags(),
+            _ => &[],
+        }
+    }
+
+    /// The id set on this action with `id`, for other actions' `after`
+    pub fn id(&self) -> Option<&str> {
+        match self {
+            ActionType::Ordered(action) => action.id.as_deref(),
+            ActionType::Conditional(action) => action.action.id(),
+            ActionType::Notify(action) => action.action.id(),
+            ActionType::Tagged(action) => action.action.id(),
+            ActionType::Verified(action) => action.action.id(),
+            ActionType::Reboot(action) => action.action.id(),
+            _ => None,
+        }
+    }
+
+    /// The ids of the actions this one runs after, set with `after`
+    pub fn after(&self) -> &[String] {
+        match self {
+            ActionType::Ordered(action) => &action.after,
+            ActionType::Conditional(action) => action.action.after(),
+            ActionType::Notify(action) => action.action.after(),
+            ActionType::Tagged(action) => action.action.after(),
+            ActionType::Verified(action) => action.action.after(),
+            ActionType::Reboot(action) => action.action.after(),
+            _ => &[],
         }
     }
 }
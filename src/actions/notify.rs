@@ -0,0 +1,149 @@
+use super::{Action, ActionType};
+use crate::atom::{Atom, AtomStatus, Destruction};
+use dhd_macros::typescript_type;
+use std::any::Any;
+use std::path::Path;
+
+/// An action that notifies module handlers when it changes something
+///
+/// Set with `notify: "reload-nginx"` (or an array of names) on any action.
+/// Each notified handler runs once at the end of the apply, however many
+/// actions notified it.
+#[typescript_type]
+pub struct NotifyAction {
+    /// The wrapped action
+    pub action: Box<ActionType>,
+    /// Names of the module's handlers to notify
+    pub handlers: Vec<String>,
+}
+
+impl NotifyAction {
+    pub fn new(action: ActionType, handlers: Vec<String>) -> Self {
+        Self {
+            action: Box::new(action),
+            handlers,
+        }
+    }
+
+    /// Mark atoms planned for the wrapped action as notifying the handlers
+    pub fn wrap(&self, atoms: Vec<Box<dyn Atom>>) -> Vec<Box<dyn Atom>> {
+        atoms
+            .into_iter()
+            .map(|inner| {
+                Box::new(NotifyingAtom {
+                    inner,
+                    handlers: self.handlers.clone(),
+                }) as Box<dyn Atom>
+            })
+            .collect()
+    }
+}
+
+impl Action for NotifyAction {
+    fn name(&self) -> &str {
+        self.action.name()
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn Atom>> {
+        self.wrap(self.action.plan(module_dir))
+    }
+}
+
+struct NotifyingAtom {
+    inner: Box<dyn Atom>,
+    handlers: Vec<String>,
+}
+
+impl Atom for NotifyingAtom {
+    fn check(&self) -> anyhow::Result<bool> {
+        self.inner.check()
+    }
+
+    fn execute(&self) -> anyhow::Result<()> {
+        self.inner.execute()
+    }
+
+    fn execute_changed(&self) -> anyhow::Result<bool> {
+        self.inner.execute_changed()
+    }
+
+    fn verify(&self) -> anyhow::Result<()> {
+        self.inner.verify()
+    }
+
+    fn describe(&self) -> String {
+        self.inner.describe()
+    }
+
+    fn module(&self) -> &str {
+        self.inner.module()
+    }
+
+    fn as_any(&self) -> &dyn Any {
+        self.inner.as_any()
+    }
+
+    fn id(&self) -> String {
+        self.inner.id()
+    }
+
+    fn status(&self) -> anyhow::Result<AtomStatus> {
+        self.inner.status()
+    }
+
+    fn audit(&self) -> AtomStatus {
+        self.inner.audit()
+    }
+
+    fn file_change(&self) -> Option<Result<crate::diff::FileChange, String>> {
+        self.inner.file_change()
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        self.inner.destruction()
+    }
+
+    fn managed_paths(&self) -> Result<Vec<std::path::PathBuf>, String> {
+        self.inner.managed_paths()
+    }
+
+    fn dependencies(&self) -> Vec<String> {
+        self.inner.dependencies()
+    }
+
+    fn notifies(&self) -> &[String] {
+        &self.handlers
+    }
+
+    fn requires_reboot(&self) -> bool {
+        self.inner.requires_reboot()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::ShellCommand;
+
+    #[test]
+    fn test_notify_wraps_planned_atoms() {
+        let action = NotifyAction::new(
+            ActionType::ShellCommand(ShellCommand {
+                run: "true".to_string(),
+                shell: None,
+                env: None,
+                only_if: None,
+                unless: None,
+                cwd: None,
+                changed_when: None,
+                failed_when: None,
+            }),
+            vec!["reload-nginx".to_string()],
+        );
+
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(atoms[0].notifies(), ["reload-nginx".to_string()]);
+        assert_eq!(action.name(), "ShellCommand");
+    }
+}
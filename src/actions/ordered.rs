@@ -0,0 +1,230 @@
+use super::{Action, ActionType};
+use crate::atom::Atom;
+use dhd_macros::typescript_type;
+use std::collections::{BTreeSet, HashMap};
+use std::path::Path;
+
+/// An action with an `id` that other actions of its module can run after,
+/// or that runs after others
+///
+/// Set with `id: "config-dir"` and `after: "config-dir"` (or an array of
+/// ids) on any action. A module's actions run in the order they're declared,
+/// except that each one runs after the actions it names.
+#[typescript_type]
+pub struct OrderedAction {
+    /// The wrapped action
+    pub action: Box<ActionType>,
+    /// The name `after` refers to this action by
+    pub id: Option<String>,
+    /// Ids of the actions of the module this one runs after
+    pub after: Vec<String>,
+}
+
+impl OrderedAction {
+    pub fn new(action: ActionType, id: Option<String>, after: Vec<String>) -> Self {
+        Self {
+            action: Box::new(action),
+            id,
+            after,
+        }
+    }
+}
+
+impl Action for OrderedAction {
+    fn name(&self) -> &str {
+        self.action.name()
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn Atom>> {
+        self.action.plan(module_dir)
+    }
+}
+
+/// Put a module's actions in the order their `after` asks for, keeping the
+/// declared order wherever it doesn't say otherwise
+///
+/// Fails if an action is after an id no action has, two actions have the
+/// same id, or actions are after each other.
+pub fn order_actions(actions: Vec<ActionType>) -> Result<Vec<ActionType>, String> {
+    if actions
+        .iter()
+        .all(|action| action.id().is_none() && action.after().is_empty())
+    {
+        return Ok(actions);
+    }
+
+    let describe = |idx: usize| match actions[idx].id() {
+        Some(id) => format!("'{}'", id),
+        None => format!("{} (action {})", actions[idx].name(), idx + 1),
+    };
+
+    let mut ids: HashMap<&str, usize> = HashMap::new();
+    for (idx, action) in actions.iter().enumerate() {
+        if let Some(id) = action.id() {
+            if ids.insert(id, idx).is_some() {
+                return Err(format!("Two actions have the id '{}'", id));
+            }
+        }
+    }
+
+    // The actions each action has to run before, and how many it waits for
+    let mut before: Vec<Vec<usize>> = vec![Vec::new(); actions.len()];
+    let mut waiting = vec![0; actions.len()];
+    for (idx, action) in actions.iter().enumerate() {
+        for after in action.after() {
+            let Some(&first) = ids.get(after.as_str()) else {
+                return Err(format!(
+                    "{} runs after '{}', but no action has that id",
+                    describe(idx),
+                    after
+                ));
+            };
+            before[first].push(idx);
+            waiting[idx] += 1;
+        }
+    }
+
+    // Kahn's algorithm, taking ready actions in declared order
+    let mut ready: BTreeSet<usize> = (0..actions.len())
+        .filter(|&idx| waiting[idx] == 0)
+        .collect();
+    let mut order = Vec::with_capacity(actions.len());
+    while let Some(idx) = ready.pop_first() {
+        order.push(idx);
+        for &next in &before[idx] {
+            waiting[next] -= 1;
+            if waiting[next] == 0 {
+                ready.insert(next);
+            }
+        }
+    }
+
+    if order.len() < actions.len() {
+        // Walk back through `after` from an action still waiting until the
+        // walk comes around to an action it already passed
+        let mut path = vec![
+            (0..actions.len())
+                .find(|&idx| waiting[idx] > 0)
+                .unwrap_or(0),
+        ];
+        loop {
+            let current = path[path.len() - 1];
+            let Some(previous) = actions[current]
+                .after()
+                .iter()
+                .filter_map(|after| ids.get(after.as_str()).copied())
+                .find(|&previous| waiting[previous] > 0)
+            else {
+                break;
+            };
+            if let Some(start) = path.iter().position(|&idx| idx == previous) {
+                let mut cycle: Vec<String> = path[start..]
+                    .iter()
+                    .rev()
+                    .map(|&idx| describe(idx))
+                    .collect();
+                cycle.push(describe(current));
+                return Err(format!(
+                    "Actions run after each other: {}",
+                    cycle.join(" -> ")
+                ));
+            }
+            path.push(previous);
+        }
+        return Err("Actions run after each other".to_string());
+    }
+
+    let mut actions: Vec<Option<ActionType>> = actions.into_iter().map(Some).collect();
+    Ok(order
+        .into_iter()
+        .filter_map(|idx| actions[idx].take())
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::ShellCommand;
+
+    fn command(run: &str, id: Option<&str>, after: &[&str]) -> ActionType {
+        ActionType::Ordered(OrderedAction::new(
+            ActionType::ShellCommand(ShellCommand {
+                run: run.to_string(),
+                shell: None,
+                env: None,
+                only_if: None,
+                unless: None,
+                cwd: None,
+                changed_when: None,
+                failed_when: None,
+            }),
+            id.map(str::to_string),
+            after.iter().map(|after| after.to_string()).collect(),
+        ))
+    }
+
+    fn runs(actions: &[ActionType]) -> Vec<String> {
+        actions
+            .iter()
+            .map(|action| match action {
+                ActionType::Ordered(ordered) => match ordered.action.as_ref() {
+                    ActionType::ShellCommand(command) => command.run.clone(),
+                    _ => unreachable!(),
+                },
+                _ => unreachable!(),
+            })
+            .collect()
+    }
+
+    #[test]
+    fn test_actions_run_after_the_ones_they_name() {
+        let actions = order_actions(vec![
+            command("reload", None, &["config"]),
+            command("write", Some("config"), &["dir"]),
+            command("other", None, &[]),
+            command("mkdir", Some("dir"), &[]),
+        ])
+        .unwrap();
+        assert_eq!(runs(&actions), ["other", "mkdir", "write", "reload"]);
+
+        // Without `after`, the declared order stays
+        let actions = order_actions(vec![
+            command("b", Some("b"), &[]),
+            command("a", Some("a"), &[]),
+        ])
+        .unwrap();
+        assert_eq!(runs(&actions), ["b", "a"]);
+        assert_eq!(actions[0].type_name(), "command");
+    }
+
+    #[test]
+    fn test_bad_orders_are_refused() {
+        let error = order_actions(vec![command("write", None, &["dir"])]).unwrap_err();
+        assert_eq!(
+            error,
+            "ShellCommand (action 1) runs after 'dir', but no action has that id"
+        );
+
+        let error = order_actions(vec![
+            command("a", Some("x"), &[]),
+            command("b", Some("x"), &[]),
+        ])
+        .unwrap_err();
+        assert_eq!(error, "Two actions have the id 'x'");
+
+        let error = order_actions(vec![
+            command("a", Some("a"), &["c"]),
+            command("b", Some("b"), &["a"]),
+            command("c", Some("c"), &["b"]),
+            command("d", None, &["a"]),
+        ])
+        .unwrap_err();
+        assert_eq!(
+            error,
+            "Actions run after each other: 'b' -> 'c' -> 'a' -> 'b'"
+        );
+
+        let error = order_actions(vec![command("a", Some("a"), &["a"])]).unwrap_err();
+        assert_eq!(error, "Actions run after each other: 'a' -> 'a'");
+    }
+}
@@ -1,14 +1,50 @@
 use crate::ActionType;
 use crate::atoms::AtomCompat;
-use crate::atoms::package::PackageManager;
+use crate::atoms::package::{PackageManager, PackageOptions};
+use crate::atoms::retry::{Retry, RetryPolicy};
 use dhd_macros::{typescript_fn, typescript_type};
+use std::collections::HashMap;
 
 use super::Action;
 
 #[typescript_type]
+/// Installs packages with the given or auto-detected package manager
+///
+/// With apt, dnf and pacman, a name may pin a version, as in `docker.io=24.0.7`.
+///
+/// * `aur` - On Arch, install `names` from the AUR using `paru` or `yay`
+/// * `remote` - Flatpak remote to install from (defaults to `flathub`, added if missing)
+/// * `scope` - Flatpak installation scope: `"user"` or `"system"`
+/// * `casks` - Homebrew casks to install alongside `names` (implies `manager: "brew"`)
+/// * `taps` - Homebrew taps to add before installing
+/// * `flake` - Nix flake to install from, e.g. `github:NixOS/nixpkgs/nixos-24.05` (defaults to `nixpkgs`)
+/// * `classic` - Install snaps with `--classic` confinement
+/// * `channel` - Snap channel to track, e.g. `latest/stable`
+/// * `overrides` - Per-distro names for packages in `names`, e.g. `{ fd: { debian: "fd-find" } }`;
+///   keys may be a distro (`arch`, `debian`, `ubuntu`, `fedora`, `macos`), a manager (`apt`)
+///   or a CPU architecture (`x86_64`, `aarch64`), which is used when no other key matches
+/// * `ensure` - `"present"` (default) installs the packages, `"latest"` also upgrades them on
+///   every apply, `"absent"` uninstalls them
+/// * `retries` / `retry_delay` - Retries of installs failing on network errors (default: 2),
+///   and the seconds to wait before the first one (default: 1), doubling after each
+/// * `groups` - Package groups to install alongside `names`: the `packageGroups` of
+///   `dhd.config.ts`, or groups of the package manager itself, like pacman's `xorg`
 pub struct PackageInstall {
     pub names: Vec<String>,
     pub manager: Option<PackageManager>,
+    pub aur: Option<bool>,
+    pub remote: Option<String>,
+    pub scope: Option<String>,
+    pub casks: Option<Vec<String>>,
+    pub taps: Option<Vec<String>>,
+    pub flake: Option<String>,
+    pub classic: Option<bool>,
+    pub channel: Option<String>,
+    pub overrides: Option<HashMap<String, HashMap<String, String>>>,
+    pub ensure: Option<String>,
+    pub retries: Option<u32>,
+    pub retry_delay: Option<u64>,
+    pub groups: Option<Vec<String>>,
 }
 
 #[typescript_fn]
@@ -22,11 +58,47 @@ impl Action for PackageInstall {
     }
 
     fn plan(&self, _module_dir: &std::path::Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let manager = if self.aur.unwrap_or(false) {
+            Some(PackageManager::Aur)
+        } else if self.manager.is_none() && self.casks.is_some() {
+            Some(PackageManager::Brew)
+        } else {
+            self.manager.clone()
+        };
+
+        let options = PackageOptions {
+            remote: self.remote.clone(),
+            scope: self.scope.clone(),
+            casks: self.casks.clone().unwrap_or_default(),
+            taps: self.taps.clone().unwrap_or_default(),
+            flake: self.flake.clone(),
+            classic: self.classic.unwrap_or(false),
+            channel: self.channel.clone(),
+            overrides: self.overrides.clone().unwrap_or_default(),
+        };
+
+        if self.ensure.as_deref() == Some("absent") {
+            return vec![Box::new(AtomCompat::new(
+                Box::new(crate::atoms::remove_packages::RemovePackages::new(
+                    self.names.clone(),
+                    manager,
+                    options,
+                )),
+                "remove_packages".to_string(),
+            ))];
+        }
+
         vec![Box::new(AtomCompat::new(
-            Box::new(crate::atoms::InstallPackages {
-                packages: self.names.clone(),
-                manager: self.manager.clone(),
-            }),
+            Box::new(Retry::new(
+                Box::new(crate::atoms::InstallPackages {
+                    packages: self.names.clone(),
+                    manager,
+                    options,
+                    latest: self.ensure.as_deref() == Some("latest"),
+                    groups: self.groups.clone().unwrap_or_default(),
+                }),
+                RetryPolicy::new(self.retries, self.retry_delay),
+            )),
             "package_install".to_string(),
         ))]
     }
@@ -42,6 +114,19 @@ mod tests {
         let action = PackageInstall {
             names: packages.clone(),
             manager: None,
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
         };
 
         assert_eq!(action.names, packages);
@@ -53,6 +138,19 @@ mod tests {
         let action = package_install(PackageInstall {
             names: vec!["postgresql-client".to_string(), "redis-tools".to_string()],
             manager: None,
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
         });
 
         match action {
@@ -69,6 +167,19 @@ mod tests {
         let action = PackageInstall {
             names: vec!["nginx".to_string()],
             manager: None,
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
         };
 
         assert_eq!(action.name(), "PackageInstall");
@@ -79,6 +190,19 @@ mod tests {
         let action = PackageInstall {
             names: vec!["rustup".to_string(), "cargo-watch".to_string(), "cargo-edit".to_string()],
             manager: None,
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
         };
 
         let atoms = action.plan(std::path::Path::new("."));
@@ -87,11 +211,152 @@ mod tests {
         assert_eq!(atoms.len(), 1);
     }
 
+    #[test]
+    fn test_package_install_aur_flag() {
+        let action = PackageInstall {
+            names: vec!["paru-bin".to_string()],
+            manager: None,
+            aur: Some(true),
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
+        };
+
+        let atoms = action.plan(std::path::Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert!(atoms[0].describe().contains("(aur)"));
+    }
+
+    #[test]
+    fn test_package_install_flatpak_options() {
+        let action = PackageInstall {
+            names: vec!["org.mozilla.firefox".to_string()],
+            manager: Some(PackageManager::Flatpak),
+            aur: None,
+            remote: Some("flathub".to_string()),
+            scope: Some("user".to_string()),
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
+        };
+
+        let atoms = action.plan(std::path::Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert!(atoms[0].describe().contains("(flatpak)"));
+    }
+
+    #[test]
+    fn test_package_install_brew_casks() {
+        let action = PackageInstall {
+            names: vec!["ripgrep".to_string()],
+            manager: None,
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: Some(vec!["firefox".to_string()]),
+            taps: Some(vec!["homebrew/cask-fonts".to_string()]),
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
+        };
+
+        let atoms = action.plan(std::path::Path::new("."));
+        assert_eq!(atoms.len(), 1);
+
+        let description = atoms[0].describe();
+        assert!(description.contains("(brew)"));
+        assert!(description.contains("casks: firefox"));
+    }
+
+    #[test]
+    fn test_package_install_nix_flake() {
+        let action = PackageInstall {
+            names: vec!["ripgrep".to_string()],
+            manager: Some(PackageManager::Nix),
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: Some("github:NixOS/nixpkgs/nixos-24.05".to_string()),
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
+        };
+
+        let atoms = action.plan(std::path::Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert!(atoms[0].describe().contains("(nix)"));
+    }
+
+    #[test]
+    fn test_package_install_snap_options() {
+        let action = PackageInstall {
+            names: vec!["code".to_string()],
+            manager: Some(PackageManager::Snap),
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: Some(true),
+            channel: Some("latest/stable".to_string()),
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
+        };
+
+        let atoms = action.plan(std::path::Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert!(atoms[0].describe().contains("(snap)"));
+    }
+
     #[test]
     fn test_package_install_with_manager() {
         let action = PackageInstall {
             names: vec!["@nestjs/cli".to_string(), "@angular/cli".to_string(), "vite".to_string()],
             manager: Some(PackageManager::Bun),
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
         };
 
         assert_eq!(action.names, vec!["@nestjs/cli".to_string(), "@angular/cli".to_string(), "vite".to_string()]);
@@ -100,4 +365,57 @@ mod tests {
         let atoms = action.plan(std::path::Path::new("."));
         assert_eq!(atoms.len(), 1);
     }
+
+    #[test]
+    fn test_package_install_ensure_absent_removes() {
+        let action = PackageInstall {
+            names: vec!["htop".to_string()],
+            manager: Some(PackageManager::Apt),
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: Some("absent".to_string()),
+            retries: None,
+            retry_delay: None,
+            groups: None,
+        };
+
+        let atoms = action.plan(std::path::Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(atoms[0].describe(), "Remove packages (apt): htop");
+    }
+
+    #[test]
+    fn test_package_install_ensure_latest_upgrades() {
+        let action = PackageInstall {
+            names: vec!["htop".to_string()],
+            manager: Some(PackageManager::Apt),
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: Some("latest".to_string()),
+            retries: None,
+            retry_delay: None,
+            groups: None,
+        };
+
+        let atoms = action.plan(std::path::Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(
+            atoms[0].describe(),
+            "Install or upgrade package (apt): htop"
+        );
+    }
 }
@@ -1,16 +1,22 @@
 use super::Action;
 use crate::atoms::AtomCompat;
-use crate::atoms::package::PackageManager;
+use crate::atoms::package::{PackageManager, PackageOptions};
 use dhd_macros::{typescript_fn, typescript_type};
+use std::collections::HashMap;
 use std::path::Path;
 
 /// Remove packages from the system
+///
+/// Packages that are already gone are skipped, and essential system packages
+/// (such as `sudo` or `systemd`) are never removed.
 #[typescript_type]
 pub struct PackageRemove {
     /// List of package names to remove
     pub names: Vec<String>,
     /// Optional package manager to use
     pub manager: Option<PackageManager>,
+    /// Per-distro names for packages in `names`, as for `packageInstall`
+    pub overrides: Option<HashMap<String, HashMap<String, String>>>,
 }
 
 impl Action for PackageRemove {
@@ -23,6 +29,10 @@ impl Action for PackageRemove {
             Box::new(crate::atoms::remove_packages::RemovePackages::new(
                 self.names.clone(),
                 self.manager.clone(),
+                PackageOptions {
+                    overrides: self.overrides.clone().unwrap_or_default(),
+                    ..PackageOptions::default()
+                },
             )),
             "remove_packages".to_string(),
         ))]
@@ -33,3 +43,24 @@ impl Action for PackageRemove {
 pub fn package_remove(config: PackageRemove) -> crate::actions::ActionType {
     crate::actions::ActionType::PackageRemove(config)
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_package_remove_plan() {
+        let action = PackageRemove {
+            names: vec!["fd".to_string()],
+            manager: Some(PackageManager::Apt),
+            overrides: Some(HashMap::from([(
+                "fd".to_string(),
+                HashMap::from([("apt".to_string(), "fd-find".to_string())]),
+            )])),
+        };
+
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(atoms[0].describe(), "Remove packages (apt): fd");
+    }
+}
@@ -0,0 +1,91 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use crate::atoms::package_repo::RepoBackend;
+use crate::atoms::retry::{Retry, RetryPolicy};
+use std::path::Path;
+
+/// Add a third-party package repository, refreshing the package index when it changes
+///
+/// * `key` - URL of the signing key, or a key file relative to the module
+/// * `components` - apt components (default: `["main"]`)
+/// * `distro` - apt suite (default: this release's codename, e.g. `noble`)
+/// * `manager` - `"apt"`, `"dnf"` or `"pacman"` (default: the detected one)
+#[typescript_type]
+pub struct PackageRepo {
+    pub name: String,
+    pub uri: String,
+    pub key: Option<String>,
+    pub components: Option<Vec<String>>,
+    pub distro: Option<String>,
+    pub manager: Option<String>,
+}
+
+impl crate::actions::Action for PackageRepo {
+    fn name(&self) -> &str {
+        "PackageRepo"
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let backend = match &self.manager {
+            Some(manager) => RepoBackend::from_manager(manager),
+            None => RepoBackend::detect(),
+        };
+        let key = self.key.as_ref().map(|key| {
+            if key.contains("://") {
+                key.clone()
+            } else {
+                let path = crate::paths::resolve(module_dir, key);
+                path.to_string_lossy().into_owned()
+            }
+        });
+
+        let repo = crate::atoms::package_repo::PackageRepo::new(
+            self.name.clone(),
+            self.uri.clone(),
+            backend,
+        )
+        .with_key(key)
+        .with_suite(
+            self.components.clone().unwrap_or_default(),
+            self.distro.clone(),
+        );
+
+        vec![Box::new(AtomCompat::new(
+            Box::new(Retry::new(Box::new(repo), RetryPolicy::default())),
+            "package_repo".to_string(),
+        ))]
+    }
+}
+
+#[typescript_fn]
+pub fn package_repo(config: PackageRepo) -> crate::actions::ActionType {
+    crate::actions::ActionType::PackageRepo(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    #[test]
+    fn test_package_repo_plan() {
+        let action = PackageRepo {
+            name: "vscode".to_string(),
+            uri: "https://packages.microsoft.com/yumrepos/vscode".to_string(),
+            key: Some("https://packages.microsoft.com/keys/microsoft.asc".to_string()),
+            components: None,
+            distro: None,
+            manager: Some("dnf".to_string()),
+        };
+
+        assert_eq!(action.name(), "PackageRepo");
+
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(
+            atoms[0].describe(),
+            "Add dnf repository vscode: https://packages.microsoft.com/yumrepos/vscode"
+        );
+    }
+}
@@ -0,0 +1,93 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use std::path::{Path, PathBuf};
+
+/// Run an action implemented by an external executable, for steps the
+/// built-in actions don't cover
+///
+/// * `name` - Names the plugin; its executable is `dhd-plugin-<name>` on the
+///   `PATH` unless `command` is given
+/// * `command` - The executable, either a path relative to the module
+///   (`"./plugins/deploy"`) or a command on the `PATH`
+/// * `spec` - Passed to the plugin as is, as JSON
+/// * `description` - Shown in plans instead of the plugin's name alone
+#[typescript_type]
+pub struct Plugin {
+    pub name: String,
+    pub command: Option<String>,
+    pub spec: Option<serde_json::Value>,
+    pub description: Option<String>,
+}
+
+impl Plugin {
+    /// The executable to run, resolved against the module directory and the
+    /// `PATH`
+    pub fn executable(&self, module_dir: &Path) -> PathBuf {
+        let command = self
+            .command
+            .clone()
+            .unwrap_or_else(|| format!("dhd-plugin-{}", self.name));
+        if command.contains('/') {
+            return crate::paths::resolve(module_dir, &command);
+        }
+        // A missing command is reported when the plugin runs
+        which::which(&command).unwrap_or_else(|_| PathBuf::from(command))
+    }
+}
+
+impl crate::actions::Action for Plugin {
+    fn name(&self) -> &str {
+        "Plugin"
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        vec![Box::new(AtomCompat::new(
+            Box::new(crate::atoms::plugin::Plugin {
+                name: self.name.clone(),
+                command: self.executable(module_dir),
+                spec: self.spec.clone().unwrap_or(serde_json::Value::Null),
+                module_dir: module_dir.to_path_buf(),
+                description: self.description.clone(),
+            }),
+            "plugin".to_string(),
+        ))]
+    }
+}
+
+#[typescript_fn]
+pub fn plugin(config: Plugin) -> crate::actions::ActionType {
+    crate::actions::ActionType::Plugin(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    #[test]
+    fn test_plugin_plan() {
+        let mut action = Plugin {
+            name: "deploy".to_string(),
+            command: Some("./plugins/deploy".to_string()),
+            spec: Some(serde_json::json!({ "environment": "staging" })),
+            description: Some("deploy to staging".to_string()),
+        };
+
+        assert_eq!(action.name(), "Plugin");
+        assert_eq!(
+            action.executable(Path::new("/dotfiles")),
+            Path::new("/dotfiles/./plugins/deploy")
+        );
+
+        let atoms = action.plan(Path::new("/dotfiles"));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(atoms[0].describe(), "Plugin deploy: deploy to staging");
+
+        action.command = None;
+        assert_eq!(
+            action.executable(Path::new("/dotfiles")),
+            Path::new("dhd-plugin-deploy")
+        );
+    }
+}
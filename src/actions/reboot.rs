@@ -0,0 +1,141 @@
+use super::{Action, ActionType};
+use crate::atom::{Atom, AtomStatus, Destruction};
+use dhd_macros::typescript_type;
+use std::any::Any;
+use std::path::Path;
+
+/// An action after which the system needs a reboot, if it changed something
+///
+/// Set with `requiresReboot: true` on any action, e.g. the one installing a
+/// kernel. DHD never reboots on its own: it lists the modules that need one
+/// at the end of the apply and exits with `exit_code::REBOOT_REQUIRED`.
+#[typescript_type]
+pub struct RebootAction {
+    /// The wrapped action
+    pub action: Box<ActionType>,
+}
+
+impl RebootAction {
+    pub fn new(action: ActionType) -> Self {
+        Self {
+            action: Box::new(action),
+        }
+    }
+
+    /// Mark atoms planned for the wrapped action as requiring a reboot
+    pub fn wrap(atoms: Vec<Box<dyn Atom>>) -> Vec<Box<dyn Atom>> {
+        atoms
+            .into_iter()
+            .map(|inner| Box::new(RebootingAtom { inner }) as Box<dyn Atom>)
+            .collect()
+    }
+}
+
+impl Action for RebootAction {
+    fn name(&self) -> &str {
+        self.action.name()
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn Atom>> {
+        Self::wrap(self.action.plan(module_dir))
+    }
+}
+
+struct RebootingAtom {
+    inner: Box<dyn Atom>,
+}
+
+impl Atom for RebootingAtom {
+    fn check(&self) -> anyhow::Result<bool> {
+        self.inner.check()
+    }
+
+    fn execute(&self) -> anyhow::Result<()> {
+        self.inner.execute()
+    }
+
+    fn execute_changed(&self) -> anyhow::Result<bool> {
+        self.inner.execute_changed()
+    }
+
+    fn verify(&self) -> anyhow::Result<()> {
+        self.inner.verify()
+    }
+
+    fn describe(&self) -> String {
+        self.inner.describe()
+    }
+
+    fn module(&self) -> &str {
+        self.inner.module()
+    }
+
+    fn as_any(&self) -> &dyn Any {
+        self.inner.as_any()
+    }
+
+    fn id(&self) -> String {
+        self.inner.id()
+    }
+
+    fn status(&self) -> anyhow::Result<AtomStatus> {
+        self.inner.status()
+    }
+
+    fn audit(&self) -> AtomStatus {
+        self.inner.audit()
+    }
+
+    fn file_change(&self) -> Option<Result<crate::diff::FileChange, String>> {
+        self.inner.file_change()
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        self.inner.destruction()
+    }
+
+    fn managed_paths(&self) -> Result<Vec<std::path::PathBuf>, String> {
+        self.inner.managed_paths()
+    }
+
+    fn dependencies(&self) -> Vec<String> {
+        self.inner.dependencies()
+    }
+
+    fn notifies(&self) -> &[String] {
+        self.inner.notifies()
+    }
+
+    fn requires_reboot(&self) -> bool {
+        true
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::{NotifyAction, ShellCommand};
+
+    #[test]
+    fn test_reboot_wraps_planned_atoms() {
+        let command = ActionType::ShellCommand(ShellCommand {
+            run: "true".to_string(),
+            shell: None,
+            env: None,
+            only_if: None,
+            unless: None,
+            cwd: None,
+            changed_when: None,
+            failed_when: None,
+        });
+        assert!(!command.plan(Path::new("."))[0].requires_reboot());
+
+        let notify = NotifyAction::new(command, vec!["reload".to_string()]);
+        let action = RebootAction::new(ActionType::Notify(notify));
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert!(atoms[0].requires_reboot());
+        assert_eq!(atoms[0].notifies(), ["reload".to_string()]);
+        assert_eq!(action.name(), "ShellCommand");
+    }
+}
@@ -0,0 +1,145 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use crate::atoms::retry::{Retry, RetryPolicy};
+use std::collections::HashMap;
+use std::path::Path;
+
+/// Download a file, or one member of a release archive, to `target`
+///
+/// The file is staged next to `target` and only moved into place once its
+/// checksum matches, so an interrupted download never leaves a partial file.
+#[typescript_type]
+pub struct RemoteFile {
+    pub url: String,
+    /// Where to install the file (supports `~/`)
+    pub target: String,
+    /// Expected sha256 of the download; on a mismatch `target` is left alone
+    pub sha256: Option<String>,
+    /// Permission bits of the installed file, e.g. `0o755`
+    pub mode: Option<u32>,
+    /// Path inside a `.tar.*` or `.zip` archive to install, or `"."` for all of it
+    pub extract: Option<String>,
+    /// Retries of downloads failing on network errors (default: 2)
+    pub retries: Option<u32>,
+    /// Seconds to wait before the first retry, doubling after each (default: 1)
+    pub retry_delay: Option<u64>,
+    /// What to download instead on other CPU architectures, keyed by
+    /// architecture: `x86_64` (or `amd64`), `aarch64` (or `arm64`), ...
+    pub arch: Option<HashMap<String, RemoteFileVariant>>,
+}
+
+/// The `url`, `sha256` and `extract` of a remote file on one CPU architecture;
+/// what's left out is taken from the file itself
+#[typescript_type]
+pub struct RemoteFileVariant {
+    pub url: Option<String>,
+    pub sha256: Option<String>,
+    pub extract: Option<String>,
+}
+
+impl RemoteFile {
+    /// The variant for `arch`, if there is one
+    fn variant(&self, arch: &str) -> Option<&RemoteFileVariant> {
+        self.arch.as_ref()?.iter().find_map(|(key, variant)| {
+            (crate::platform::normalize_arch(key) == arch).then_some(variant)
+        })
+    }
+
+    /// The `url`, `sha256` and `extract` to use on this machine's architecture
+    pub fn selected(&self) -> (String, Option<String>, Option<String>) {
+        let Some(variant) = self.variant(&crate::platform::current_arch()) else {
+            return (self.url.clone(), self.sha256.clone(), self.extract.clone());
+        };
+        (
+            variant.url.clone().unwrap_or_else(|| self.url.clone()),
+            variant.sha256.clone().or_else(|| self.sha256.clone()),
+            variant.extract.clone().or_else(|| self.extract.clone()),
+        )
+    }
+}
+
+impl crate::actions::Action for RemoteFile {
+    fn name(&self) -> &str {
+        "RemoteFile"
+    }
+
+    fn plan(&self, _module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let (url, sha256, extract) = self.selected();
+        let arch = crate::platform::current_arch();
+        if self.variant(&arch).is_some() {
+            log::info!("Using the {} variant of {}: {}", arch, self.target, url);
+        }
+        vec![Box::new(AtomCompat::new(
+            Box::new(Retry::new(
+                Box::new(crate::atoms::remote_file::RemoteFile::new(
+                    url,
+                    crate::paths::expand_path(&self.target),
+                    sha256,
+                    self.mode,
+                    extract,
+                )),
+                RetryPolicy::new(self.retries, self.retry_delay),
+            )),
+            "remote_file".to_string(),
+        ))]
+    }
+}
+
+#[typescript_fn]
+pub fn remote_file(config: RemoteFile) -> crate::actions::ActionType {
+    crate::actions::ActionType::RemoteFile(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    #[test]
+    fn test_remote_file_plan() {
+        let action = RemoteFile {
+            url: "https://github.com/BurntSushi/ripgrep/releases/download/14.1.0/ripgrep-14.1.0-x86_64-unknown-linux-musl.tar.gz".to_string(),
+            target: "/opt/bin/rg".to_string(),
+            sha256: None,
+            mode: Some(0o755),
+            extract: Some("ripgrep-14.1.0-x86_64-unknown-linux-musl/rg".to_string()),
+            retries: None,
+            retry_delay: None,
+            arch: None,
+        };
+
+        assert_eq!(action.name(), "RemoteFile");
+
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert!(
+            atoms[0].describe().ends_with(
+                "and extract ripgrep-14.1.0-x86_64-unknown-linux-musl/rg -> /opt/bin/rg"
+            )
+        );
+    }
+
+    #[test]
+    fn test_remote_file_arch_variant() {
+        let variant = RemoteFileVariant {
+            url: Some("https://example.com/tool-arm64.tar.gz".to_string()),
+            sha256: None,
+            extract: Some("tool-arm64/tool".to_string()),
+        };
+        let action = RemoteFile {
+            url: "https://example.com/tool-amd64.tar.gz".to_string(),
+            target: "/opt/bin/tool".to_string(),
+            sha256: Some("0".repeat(64)),
+            mode: None,
+            extract: Some("tool-amd64/tool".to_string()),
+            retries: None,
+            retry_delay: None,
+            arch: Some(HashMap::from([("arm64".to_string(), variant)])),
+        };
+
+        let arm = action.variant("aarch64").unwrap();
+        assert_eq!(arm.extract.as_deref(), Some("tool-arm64/tool"));
+        assert!(action.variant("x86_64").is_none());
+    }
+}
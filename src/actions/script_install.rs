@@ -0,0 +1,97 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use std::collections::HashMap;
+use std::path::Path;
+
+/// Download an installer script, like the ones `curl ... | sh` runs, and run
+/// it once its sha256 matches
+///
+/// A script that doesn't match is never run and fails the action. DHD's
+/// state remembers the sha256 and `version` of the script that ran, so
+/// later applies only run it again when either changes.
+#[typescript_type]
+pub struct ScriptInstall {
+    pub url: String,
+    /// Expected sha256 of the script
+    pub sha256: String,
+    /// Shell running the script (default: `shell` of dhd.config.ts, or "sh")
+    pub shell: Option<String>,
+    /// Arguments passed to the script, e.g. `["-y"]`
+    pub args: Option<Vec<String>>,
+    /// Environment variables for the script, over the inherited ones
+    pub env: Option<HashMap<String, String>>,
+    /// Version the script installs; changing it runs the script again
+    pub version: Option<String>,
+    /// Run the script as root
+    pub escalate: Option<bool>,
+}
+
+#[typescript_fn]
+pub fn script_install(config: ScriptInstall) -> crate::actions::ActionType {
+    crate::actions::ActionType::ScriptInstall(config)
+}
+
+impl crate::actions::Action for ScriptInstall {
+    fn name(&self) -> &str {
+        "ScriptInstall"
+    }
+
+    fn plan(&self, _module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        vec![Box::new(AtomCompat::new(
+            Box::new(
+                crate::atoms::script_install::ScriptInstall::new(
+                    self.url.clone(),
+                    self.sha256.clone(),
+                    crate::shell::choose(self.shell.as_deref()),
+                    self.version.clone(),
+                )
+                .with_command(
+                    self.args.clone().unwrap_or_default(),
+                    self.env.clone().unwrap_or_default(),
+                )
+                .with_escalate(self.escalate.unwrap_or(false)),
+            ),
+            "script_install".to_string(),
+        ))]
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::{Action, ActionType};
+
+    fn rustup() -> ScriptInstall {
+        ScriptInstall {
+            url: "https://sh.rustup.rs".to_string(),
+            sha256: "0123456789abcdef".to_string(),
+            shell: None,
+            args: Some(vec!["-y".to_string()]),
+            env: None,
+            version: Some("1.28".to_string()),
+            escalate: None,
+        }
+    }
+
+    #[test]
+    fn test_script_install_helper_function() {
+        match script_install(rustup()) {
+            ActionType::ScriptInstall(script) => assert_eq!(script.url, "https://sh.rustup.rs"),
+            _ => panic!("Expected ScriptInstall action type"),
+        }
+    }
+
+    #[test]
+    fn test_script_install_plan() {
+        let action = rustup();
+        assert_eq!(action.name(), "ScriptInstall");
+
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(
+            atoms[0].describe(),
+            "Run install script https://sh.rustup.rs (1.28)"
+        );
+    }
+}
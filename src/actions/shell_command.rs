@@ -0,0 +1,117 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+
+#[typescript_type]
+pub struct ShellCommand {
+    /// Command line passed to the shell
+    pub run: String,
+    /// Shell used for `run` and the guards, like `bash` or `fish` (default:
+    /// `shell` of dhd.config.ts, or sh)
+    pub shell: Option<String>,
+    /// Environment variables for `run` and the guards, added to the inherited
+    /// ones and replacing those of the same name
+    pub env: Option<HashMap<String, String>>,
+    /// Only run when this command succeeds
+    pub only_if: Option<String>,
+    /// Skip running when this command succeeds
+    pub unless: Option<String>,
+    /// Working directory, relative to the module (default: the module directory)
+    pub cwd: Option<String>,
+    /// Run after `run`; the action only counts as changed, and only notifies
+    /// its handlers, if this succeeds. It gets the exit code and output of
+    /// `run` in `DHD_EXIT_CODE`, `DHD_STDOUT` and `DHD_STDERR`
+    pub changed_when: Option<String>,
+    /// Run after `run` in place of checking its exit code; the action fails
+    /// if this succeeds. It gets the same variables as `changed_when`
+    pub failed_when: Option<String>,
+}
+
+impl crate::actions::Action for ShellCommand {
+    fn name(&self) -> &str {
+        "ShellCommand"
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let cwd = match &self.cwd {
+            Some(cwd) => crate::paths::resolve(module_dir, cwd),
+            None => PathBuf::from(module_dir),
+        };
+
+        vec![Box::new(AtomCompat::new(
+            Box::new(
+                crate::atoms::shell_command::ShellCommand::new(
+                    crate::shell::choose(self.shell.as_deref()),
+                    self.run.clone(),
+                    self.only_if.clone(),
+                    self.unless.clone(),
+                    Some(cwd),
+                )
+                .with_outcome(self.changed_when.clone(), self.failed_when.clone())
+                .with_env(self.env.clone().unwrap_or_default()),
+            ),
+            "shell_command".to_string(),
+        ))]
+    }
+}
+
+#[typescript_fn]
+pub fn command(config: ShellCommand) -> crate::actions::ActionType {
+    crate::actions::ActionType::ShellCommand(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    fn shell_command(run: &str) -> ShellCommand {
+        ShellCommand {
+            run: run.to_string(),
+            shell: None,
+            env: None,
+            only_if: None,
+            unless: None,
+            cwd: None,
+            changed_when: None,
+            failed_when: None,
+        }
+    }
+
+    #[test]
+    fn test_shell_command_helper_function() {
+        let action = command(ShellCommand {
+            unless: Some("command -v rustup".to_string()),
+            ..shell_command("curl -sSf https://sh.rustup.rs | sh -s -- -y")
+        });
+
+        match action {
+            crate::actions::ActionType::ShellCommand(cmd) => {
+                assert_eq!(cmd.unless, Some("command -v rustup".to_string()));
+                assert_eq!(cmd.only_if, None);
+            }
+            _ => panic!("Expected ShellCommand action type"),
+        }
+    }
+
+    #[test]
+    fn test_shell_command_name() {
+        assert_eq!(shell_command("true").name(), "ShellCommand");
+    }
+
+    #[test]
+    fn test_shell_command_plan() {
+        let action = ShellCommand {
+            only_if: Some("test -d .git".to_string()),
+            cwd: Some("repo".to_string()),
+            ..shell_command("git pull")
+        };
+
+        let atoms = action.plan(Path::new("/modules/dev"));
+        assert_eq!(atoms.len(), 1);
+        assert!(atoms[0].describe().contains("git pull"));
+        assert!(atoms[0].describe().contains("only if: test -d .git"));
+    }
+}
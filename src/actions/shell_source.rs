@@ -0,0 +1,106 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use crate::atoms::env_var::{ENV_FILE, FISH_ENV_FILE, SourceEnvFile, parse_shells};
+use std::path::Path;
+
+/// Source a DHD-managed file from the rc files of some shells
+///
+/// * `file` - File to source, relative to the module or absolute (default: the
+///   env file of `envVar`, `env.fish` for fish); every shell in `shells`
+///   sources it, so it must be valid in each of them
+/// * `shells` - Shells whose rc file sources it: `bash`, `zsh` and `fish`
+///   (default: bash and zsh)
+/// * `ensure` - `"present"` (default) adds the block, `"absent"` removes it
+#[typescript_type]
+pub struct ShellSource {
+    pub file: Option<String>,
+    pub shells: Option<Vec<String>>,
+    pub ensure: Option<String>,
+}
+
+impl crate::actions::Action for ShellSource {
+    fn name(&self) -> &str {
+        "ShellSource"
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let home = crate::paths::expand_path("~");
+        parse_shells(self.shells.as_deref())
+            .into_iter()
+            .map(|shell| {
+                let atom = match &self.file {
+                    Some(file) => {
+                        let path = crate::paths::resolve(module_dir, file);
+                        let path = std::path::absolute(&path).unwrap_or(path);
+                        SourceEnvFile::new(home.join(shell.rc_file()), path, shell.is_fish())
+                            .with_marker(&format!("source {}", file))
+                    }
+                    None => {
+                        let env_file = if shell.is_fish() {
+                            FISH_ENV_FILE
+                        } else {
+                            ENV_FILE
+                        };
+                        SourceEnvFile::new(
+                            home.join(shell.rc_file()),
+                            home.join(env_file),
+                            shell.is_fish(),
+                        )
+                    }
+                };
+                let atom = if self.ensure.as_deref() == Some("absent") {
+                    atom.removed()
+                } else {
+                    atom
+                };
+                Box::new(AtomCompat::new(
+                    Box::new(atom),
+                    "source_env_file".to_string(),
+                )) as Box<dyn crate::atom::Atom>
+            })
+            .collect()
+    }
+}
+
+#[typescript_fn]
+pub fn shell_source(config: ShellSource) -> crate::actions::ActionType {
+    crate::actions::ActionType::ShellSource(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    #[test]
+    fn test_shell_source_plan() {
+        let action = ShellSource {
+            file: Some("/opt/dhd/aliases.sh".to_string()),
+            shells: Some(vec!["zsh".to_string()]),
+            ensure: None,
+        };
+        let descriptions: Vec<String> = action
+            .plan(Path::new("/modules/shell"))
+            .iter()
+            .map(|atom| atom.describe())
+            .collect();
+        assert_eq!(descriptions.len(), 1);
+        assert!(descriptions[0].starts_with("Source /opt/dhd/aliases.sh from "));
+        assert!(descriptions[0].ends_with(".zshrc"));
+
+        let unwire = ShellSource {
+            file: None,
+            shells: Some(vec!["bash".to_string(), "fish".to_string()]),
+            ensure: Some("absent".to_string()),
+        };
+        let descriptions: Vec<String> = unwire
+            .plan(Path::new("/modules/shell"))
+            .iter()
+            .map(|atom| atom.describe())
+            .collect();
+        assert!(descriptions[0].starts_with("Stop sourcing "));
+        assert!(descriptions[0].contains(ENV_FILE));
+        assert!(descriptions[1].contains(FISH_ENV_FILE));
+    }
+}
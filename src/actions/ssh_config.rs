@@ -0,0 +1,158 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use std::collections::HashMap;
+use std::path::Path;
+
+/// Keep `Host` blocks in an SSH client config without owning the whole file
+///
+/// * `hosts` - The blocks, one per host alias
+/// * `path` - Config to edit (default: `~/.ssh/config`)
+///
+/// Each block sits between `# BEGIN dhd:Host <alias>` and `# END dhd:Host
+/// <alias>` and is replaced as a whole when its host changes. Blocks and
+/// lines DHD didn't write are kept, new blocks go before the first `Host *`,
+/// and the file is kept at mode `0600`.
+#[typescript_type]
+pub struct SshConfig {
+    pub hosts: Vec<SshHost>,
+    pub path: Option<String>,
+}
+
+/// One `Host` block of `sshConfig`
+#[typescript_type]
+pub struct SshHost {
+    /// Alias or pattern after `Host`, e.g. `work` or `*.lab`; names the block
+    pub host: String,
+    pub hostname: Option<String>,
+    pub user: Option<String>,
+    pub port: Option<u32>,
+    /// Key to log in with (supports `~/`)
+    pub identity_file: Option<String>,
+    pub proxy_jump: Option<String>,
+    /// Other keywords, e.g. `{ ForwardAgent: "yes" }`
+    pub options: Option<HashMap<String, String>>,
+    /// Remove the block instead
+    pub absent: Option<bool>,
+}
+
+impl SshHost {
+    /// The block's lines, `Host` first and its keywords indented below
+    pub fn block(&self) -> String {
+        let port = self.port.map(|port| port.to_string());
+        let keywords = [
+            ("HostName", &self.hostname),
+            ("User", &self.user),
+            ("Port", &port),
+            ("IdentityFile", &self.identity_file),
+            ("ProxyJump", &self.proxy_jump),
+        ];
+
+        let mut lines = vec![format!("Host {}", self.host)];
+        for (keyword, value) in keywords {
+            if let Some(value) = value {
+                lines.push(format!("    {} {}", keyword, value));
+            }
+        }
+        let mut options: Vec<_> = self.options.iter().flatten().collect();
+        options.sort();
+        for (keyword, value) in options {
+            lines.push(format!("    {} {}", keyword, value));
+        }
+        lines.join("\n") + "\n"
+    }
+}
+
+impl crate::actions::Action for SshConfig {
+    fn name(&self) -> &str {
+        "SshConfig"
+    }
+
+    fn plan(&self, _module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let path = self.path.as_deref().unwrap_or("~/.ssh/config");
+        let hosts = self
+            .hosts
+            .iter()
+            .map(|host| {
+                let block = (!host.absent.unwrap_or(false)).then(|| host.block());
+                (host.host.clone(), block)
+            })
+            .collect();
+
+        vec![Box::new(AtomCompat::new(
+            Box::new(crate::atoms::ssh_config::SshConfig::new(
+                crate::paths::expand_path(path),
+                hosts,
+            )),
+            "ssh_config".to_string(),
+        ))]
+    }
+}
+
+#[typescript_fn]
+pub fn ssh_config(config: SshConfig) -> crate::actions::ActionType {
+    crate::actions::ActionType::SshConfig(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    fn host(alias: &str) -> SshHost {
+        SshHost {
+            host: alias.to_string(),
+            hostname: None,
+            user: None,
+            port: None,
+            identity_file: None,
+            proxy_jump: None,
+            options: None,
+            absent: None,
+        }
+    }
+
+    #[test]
+    fn test_ssh_host_block() {
+        let work = SshHost {
+            hostname: Some("work.example.com".to_string()),
+            user: Some("me".to_string()),
+            port: Some(2222),
+            identity_file: Some("~/.ssh/work".to_string()),
+            options: Some(HashMap::from([
+                ("ServerAliveInterval".to_string(), "60".to_string()),
+                ("ForwardAgent".to_string(), "yes".to_string()),
+            ])),
+            ..host("work")
+        };
+        assert_eq!(
+            work.block(),
+            "Host work\n    HostName work.example.com\n    User me\n    Port 2222\n    \
+             IdentityFile ~/.ssh/work\n    ForwardAgent yes\n    ServerAliveInterval 60\n"
+        );
+        assert_eq!(host("*.lab").block(), "Host *.lab\n");
+    }
+
+    #[test]
+    fn test_ssh_config_plan() {
+        let action = SshConfig {
+            hosts: vec![
+                host("work"),
+                SshHost {
+                    absent: Some(true),
+                    ..host("old")
+                },
+            ],
+            path: Some("/home/user/.ssh/config".to_string()),
+        };
+
+        assert_eq!(action.name(), "SshConfig");
+
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(
+            atoms[0].describe(),
+            "Update Host work and remove Host old in /home/user/.ssh/config"
+        );
+    }
+}
@@ -0,0 +1,89 @@
+use dhd_macros::{typescript_fn, typescript_type};
+
+use crate::atoms::AtomCompat;
+use crate::atoms::stow::Conflict;
+use std::path::Path;
+
+/// Symlink every file of a directory into a target tree, like GNU Stow
+///
+/// * `source` - Directory relative to the module whose tree is mirrored
+/// * `target` - Where the tree is mirrored (default: `~`)
+/// * `delete` - Remove the links instead, and directories left empty
+/// * `adopt` - Move files already in the way into `source`, then link them
+/// * `force` - Replace files already in the way
+#[typescript_type]
+pub struct Stow {
+    pub source: String,
+    pub target: Option<String>,
+    pub delete: Option<bool>,
+    pub adopt: Option<bool>,
+    pub force: Option<bool>,
+}
+
+impl crate::actions::Action for Stow {
+    fn name(&self) -> &str {
+        "Stow"
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let source = crate::paths::resolve(module_dir, &self.source);
+        // Links have to point at an absolute path to work from anywhere
+        let source = std::path::absolute(&source).unwrap_or(source);
+        let target = crate::paths::expand_path(self.target.as_deref().unwrap_or("~"));
+        let conflict = if self.adopt.unwrap_or(false) {
+            Conflict::Adopt
+        } else if self.force.unwrap_or(false) {
+            Conflict::Force
+        } else {
+            Conflict::Fail
+        };
+
+        vec![Box::new(AtomCompat::new(
+            Box::new(crate::atoms::stow::Stow {
+                source,
+                target,
+                delete: self.delete.unwrap_or(false),
+                conflict,
+            }),
+            "stow".to_string(),
+        ))]
+    }
+}
+
+#[typescript_fn]
+pub fn stow(config: Stow) -> crate::actions::ActionType {
+    crate::actions::ActionType::Stow(config)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::Action;
+
+    #[test]
+    fn test_stow_plan() {
+        let mut action = Stow {
+            source: "nvim".to_string(),
+            target: Some("/home/user".to_string()),
+            delete: None,
+            adopt: None,
+            force: None,
+        };
+
+        assert_eq!(action.name(), "Stow");
+
+        let atoms = action.plan(Path::new("/modules/editor"));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(
+            atoms[0].describe(),
+            "Link files of /modules/editor/nvim into /home/user"
+        );
+
+        action.delete = Some(true);
+        let atoms = action.plan(Path::new("/modules/editor"));
+        assert_eq!(
+            atoms[0].describe(),
+            "Remove links into /modules/editor/nvim from /home/user"
+        );
+    }
+}
@@ -0,0 +1,130 @@
+use super::Action;
+use crate::atoms::AtomCompat;
+use dhd_macros::{typescript_fn, typescript_type};
+use std::path::Path;
+
+#[typescript_type]
+/// Creates a symbolic link at `target` pointing to `source`
+///
+/// * `source` - File the symlink points to, relative to the module directory unless absolute;
+///   a glob like `./bin/*` links each match into the `target` directory under its own name
+/// * `target` - Path where the symlink will be created (supports `~/`)
+/// * `force` - If true, creates parent directories and replaces an existing file at `target`
+/// * `ensure` - `"present"` (default) or `"absent"` to remove the symlink an
+///   earlier apply created; anything DHD didn't create is left alone
+pub struct Symlink {
+    pub source: String,
+    pub target: String,
+    pub force: Option<bool>,
+    pub ensure: Option<String>,
+}
+
+#[typescript_fn]
+pub fn symlink(config: Symlink) -> super::ActionType {
+    super::ActionType::Symlink(config)
+}
+
+impl Action for Symlink {
+    fn name(&self) -> &str {
+        "Symlink"
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let source_path = crate::paths::resolve(module_dir, &self.source);
+        let target_path = crate::paths::expand_path(&self.target);
+        let links = super::glob_sources(source_path, target_path, "symlink", true);
+
+        if self.ensure.as_deref() == Some("absent") {
+            return links
+                .into_iter()
+                .flat_map(|(_, target)| super::retire(target, "symlink"))
+                .collect();
+        }
+
+        // The link atom names the link location `source` and what it points to `target`
+        links
+            .into_iter()
+            .map(|(source, target)| -> Box<dyn crate::atom::Atom> {
+                Box::new(AtomCompat::new(
+                    Box::new(crate::atoms::LinkFile {
+                        source: target,
+                        target: source,
+                        force: self.force.unwrap_or(false),
+                    }),
+                    "symlink".to_string(),
+                ))
+            })
+            .collect()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::ActionType;
+
+    #[test]
+    fn test_symlink_helper_function() {
+        let action = symlink(Symlink {
+            source: "./dotfiles/.zshrc".to_string(),
+            target: "~/.zshrc".to_string(),
+            force: None,
+            ensure: None,
+        });
+
+        match action {
+            ActionType::Symlink(link) => {
+                assert_eq!(link.source, "./dotfiles/.zshrc");
+                assert_eq!(link.target, "~/.zshrc");
+                assert_eq!(link.force, None);
+            }
+            _ => panic!("Expected Symlink action type"),
+        }
+    }
+
+    #[test]
+    fn test_symlink_name() {
+        let action = Symlink {
+            source: "zshrc".to_string(),
+            target: "/tmp/.zshrc".to_string(),
+            force: None,
+            ensure: None,
+        };
+
+        assert_eq!(action.name(), "Symlink");
+    }
+
+    #[test]
+    fn test_symlink_plan_resolves_source_against_module_dir() {
+        let action = Symlink {
+            source: "dotfiles/.zshrc".to_string(),
+            target: "/home/user/.zshrc".to_string(),
+            force: Some(false),
+            ensure: None,
+        };
+
+        let atoms = action.plan(Path::new("/home/user/modules/shell"));
+        assert_eq!(atoms.len(), 1);
+
+        let description = atoms[0].describe();
+        assert!(description.contains("/home/user/.zshrc"));
+        assert!(description.contains("/home/user/modules/shell/dotfiles/.zshrc"));
+    }
+
+    #[test]
+    fn test_symlink_plan_expands_tilde() {
+        let action = Symlink {
+            source: "/absolute/zshrc".to_string(),
+            target: "~/.zshrc".to_string(),
+            force: None,
+            ensure: None,
+        };
+
+        let atoms = action.plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+
+        let description = atoms[0].describe();
+        assert!(!description.contains("~"));
+        assert!(description.contains("/absolute/zshrc"));
+    }
+}
@@ -5,7 +5,7 @@ use std::path::Path;
 #[typescript_type]
 pub struct SystemdManage {
     pub name: String,
-    pub operation: String, // "enable", "disable", "start", "stop", "restart", "enable-now", "disable-now"
+    pub operation: String, // "enable", "disable", "start", "stop", "restart", "reload", "enable-now", "disable-now"
     pub scope: String,     // "user" or "system"
 }
 
@@ -21,6 +21,7 @@ impl crate::actions::Action for SystemdManage {
             "start" => crate::atoms::systemd_manage::SystemdOperation::Start,
             "stop" => crate::atoms::systemd_manage::SystemdOperation::Stop,
             "restart" => crate::atoms::systemd_manage::SystemdOperation::Restart,
+            "reload" => crate::atoms::systemd_manage::SystemdOperation::Reload,
             "enable-now" => crate::atoms::systemd_manage::SystemdOperation::EnableNow,
             "disable-now" => crate::atoms::systemd_manage::SystemdOperation::DisableNow,
             _ => crate::atoms::systemd_manage::SystemdOperation::Enable, // Default to enable
@@ -109,6 +110,7 @@ mod tests {
             "start",
             "stop",
             "restart",
+            "reload",
             "enable-now",
             "disable-now",
         ];
@@ -1,16 +1,36 @@
 use crate::atoms::AtomCompat;
+use crate::atoms::systemd_service::Refresh;
 use dhd_macros::{typescript_fn, typescript_type};
 use std::path::Path;
 
 #[typescript_type]
+/// Writes a systemd unit and optionally enables and starts it
+///
+/// Either give the full unit file content in `unit`, or let DHD generate one
+/// from `description`, `execStart` and `serviceType`. Unit files are only
+/// rewritten, and systemd only reloaded, when their content changes.
 pub struct SystemdService {
     pub name: String,
-    pub description: String,
-    pub exec_start: String,
-    pub service_type: String,
-    pub scope: String, // "user" or "system"
+    pub description: Option<String>,
+    pub exec_start: Option<String>,
+    pub service_type: Option<String>,
+    pub scope: Option<String>, // "user" (default) or "system"
     pub restart: Option<String>,
     pub restart_sec: Option<u32>,
+    /// Full unit file content, used instead of the generated unit
+    pub unit: Option<String>,
+    /// Content of a companion `.timer` unit; enable/start then act on the timer
+    pub timer: Option<String>,
+    /// Enable the unit so it starts on login (user) or boot (system)
+    pub enable: Option<bool>,
+    /// Start the unit now, restarting it when its definition changed
+    pub start: Option<bool>,
+    /// How the running unit picks up changes: `"restart-on-change"` (default)
+    /// restarts it when its unit files changed, `"restart"` on every apply, and
+    /// `"reload"` reloads it instead; as a handler, it's refreshed when notified
+    pub action: Option<String>,
+    /// Whether this is a handler's action, run because a notifying action changed
+    pub(crate) notified: bool,
 }
 
 impl crate::actions::Action for SystemdService {
@@ -19,16 +39,31 @@ impl crate::actions::Action for SystemdService {
     }
 
     fn plan(&self, _module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let service = crate::atoms::systemd_service::SystemdService::new(
+            self.name.clone(),
+            self.description
+                .clone()
+                .unwrap_or_else(|| self.name.clone()),
+            self.exec_start.clone().unwrap_or_default(),
+            self.service_type
+                .clone()
+                .unwrap_or_else(|| "simple".to_string()),
+            self.scope.clone().unwrap_or_else(|| "user".to_string()),
+            self.restart.clone(),
+            self.restart_sec,
+        )
+        .with_unit_file(self.unit.clone(), self.timer.clone())
+        .with_activation(self.enable.unwrap_or(false), self.start.unwrap_or(false))
+        .with_refresh(
+            self.action
+                .as_deref()
+                .and_then(|action| Refresh::parse(action).ok())
+                .unwrap_or(Refresh::RestartOnChange),
+            self.notified,
+        );
+
         vec![Box::new(AtomCompat::new(
-            Box::new(crate::atoms::systemd_service::SystemdService::new(
-                self.name.clone(),
-                self.description.clone(),
-                self.exec_start.clone(),
-                self.service_type.clone(),
-                self.scope.clone(),
-                self.restart.clone(),
-                self.restart_sec,
-            )),
+            Box::new(service),
             "systemd_service".to_string(),
         ))]
     }
@@ -48,19 +83,25 @@ mod tests {
     fn test_systemd_service_creation() {
         let action = SystemdService {
             name: "postgres-backup.service".to_string(),
-            description: "PostgreSQL Automated Backup Service".to_string(),
-            exec_start: "/usr/local/bin/pg-backup.sh".to_string(),
-            service_type: "oneshot".to_string(),
-            scope: "system".to_string(),
+            description: Some("PostgreSQL Automated Backup Service".to_string()),
+            exec_start: Some("/usr/local/bin/pg-backup.sh".to_string()),
+            service_type: Some("oneshot".to_string()),
+            scope: Some("system".to_string()),
             restart: Some("on-failure".to_string()),
             restart_sec: Some(30),
+            unit: None,
+            timer: None,
+            enable: None,
+            start: None,
+            action: None,
+            notified: false,
         };
 
         assert_eq!(action.name, "postgres-backup.service");
-        assert_eq!(action.description, "PostgreSQL Automated Backup Service");
-        assert_eq!(action.exec_start, "/usr/local/bin/pg-backup.sh");
-        assert_eq!(action.service_type, "oneshot");
-        assert_eq!(action.scope, "system");
+        assert_eq!(action.description, Some("PostgreSQL Automated Backup Service".to_string()));
+        assert_eq!(action.exec_start, Some("/usr/local/bin/pg-backup.sh".to_string()));
+        assert_eq!(action.service_type, Some("oneshot".to_string()));
+        assert_eq!(action.scope, Some("system".to_string()));
         assert_eq!(action.restart, Some("on-failure".to_string()));
         assert_eq!(action.restart_sec, Some(30));
     }
@@ -69,21 +110,27 @@ mod tests {
     fn test_systemd_service_helper_function() {
         let action = systemd_service(SystemdService {
             name: "node-app.service".to_string(),
-            description: "Node.js Production Application".to_string(),
-            exec_start: "/usr/bin/node /var/www/app/server.js".to_string(),
-            service_type: "simple".to_string(),
-            scope: "system".to_string(),
+            description: Some("Node.js Production Application".to_string()),
+            exec_start: Some("/usr/bin/node /var/www/app/server.js".to_string()),
+            service_type: Some("simple".to_string()),
+            scope: Some("system".to_string()),
             restart: Some("always".to_string()),
             restart_sec: Some(10),
+            unit: None,
+            timer: None,
+            enable: None,
+            start: None,
+            action: None,
+            notified: false,
         });
 
         match action {
             crate::actions::ActionType::SystemdService(service) => {
                 assert_eq!(service.name, "node-app.service");
-                assert_eq!(service.description, "Node.js Production Application");
-                assert_eq!(service.exec_start, "/usr/bin/node /var/www/app/server.js");
-                assert_eq!(service.service_type, "simple");
-                assert_eq!(service.scope, "system");
+                assert_eq!(service.description, Some("Node.js Production Application".to_string()));
+                assert_eq!(service.exec_start, Some("/usr/bin/node /var/www/app/server.js".to_string()));
+                assert_eq!(service.service_type, Some("simple".to_string()));
+                assert_eq!(service.scope, Some("system".to_string()));
                 assert_eq!(service.restart, Some("always".to_string()));
                 assert_eq!(service.restart_sec, Some(10));
             }
@@ -95,12 +142,18 @@ mod tests {
     fn test_systemd_service_name() {
         let action = SystemdService {
             name: "redis-sentinel.service".to_string(),
-            description: "Redis Sentinel Service".to_string(),
-            exec_start: "/usr/bin/redis-sentinel /etc/redis/sentinel.conf".to_string(),
-            service_type: "notify".to_string(),
-            scope: "system".to_string(),
+            description: Some("Redis Sentinel Service".to_string()),
+            exec_start: Some("/usr/bin/redis-sentinel /etc/redis/sentinel.conf".to_string()),
+            service_type: Some("notify".to_string()),
+            scope: Some("system".to_string()),
             restart: None,
             restart_sec: None,
+            unit: None,
+            timer: None,
+            enable: None,
+            start: None,
+            action: None,
+            notified: false,
         };
 
         assert_eq!(action.name(), "SystemdService");
@@ -110,12 +163,18 @@ mod tests {
     fn test_systemd_service_plan() {
         let action = SystemdService {
             name: "docker-cleanup.service".to_string(),
-            description: "Docker System Cleanup Timer".to_string(),
-            exec_start: "/usr/bin/docker system prune -af".to_string(),
-            service_type: "oneshot".to_string(),
-            scope: "system".to_string(),
+            description: Some("Docker System Cleanup Timer".to_string()),
+            exec_start: Some("/usr/bin/docker system prune -af".to_string()),
+            service_type: Some("oneshot".to_string()),
+            scope: Some("system".to_string()),
             restart: None,
             restart_sec: None,
+            unit: None,
+            timer: None,
+            enable: None,
+            start: None,
+            action: None,
+            notified: false,
         };
 
         let atoms = action.plan(std::path::Path::new("."));
@@ -128,12 +187,18 @@ mod tests {
     fn test_systemd_service_with_restart() {
         let action = SystemdService {
             name: "prometheus.service".to_string(),
-            description: "Prometheus Monitoring Server".to_string(),
-            exec_start: "/usr/local/bin/prometheus --config.file=/etc/prometheus/prometheus.yml".to_string(),
-            service_type: "simple".to_string(),
-            scope: "system".to_string(),
+            description: Some("Prometheus Monitoring Server".to_string()),
+            exec_start: Some("/usr/local/bin/prometheus --config.file=/etc/prometheus/prometheus.yml".to_string()),
+            service_type: Some("simple".to_string()),
+            scope: Some("system".to_string()),
             restart: Some("always".to_string()),
             restart_sec: Some(10),
+            unit: None,
+            timer: None,
+            enable: None,
+            start: None,
+            action: None,
+            notified: false,
         };
 
         let atoms = action.plan(std::path::Path::new("."));
@@ -145,16 +210,78 @@ mod tests {
     fn test_systemd_service_user_scope() {
         let action = SystemdService {
             name: "code-server.service".to_string(),
-            description: "VS Code Server for Remote Development".to_string(),
-            exec_start: "/home/developer/.local/bin/code-server --bind-addr 0.0.0.0:8080".to_string(),
-            service_type: "simple".to_string(),
-            scope: "user".to_string(),
+            description: Some("VS Code Server for Remote Development".to_string()),
+            exec_start: Some("/home/developer/.local/bin/code-server --bind-addr 0.0.0.0:8080".to_string()),
+            service_type: Some("simple".to_string()),
+            scope: Some("user".to_string()),
             restart: Some("on-failure".to_string()),
             restart_sec: Some(5),
+            unit: None,
+            timer: None,
+            enable: None,
+            start: None,
+            action: None,
+            notified: false,
         };
 
         let atoms = action.plan(std::path::Path::new("."));
         assert_eq!(atoms.len(), 1);
         assert!(atoms[0].describe().contains("systemd service"));
     }
+
+    #[test]
+    fn test_systemd_service_unit_with_timer() {
+        let action = SystemdService {
+            name: "backup.service".to_string(),
+            description: None,
+            exec_start: None,
+            service_type: None,
+            scope: None,
+            restart: None,
+            restart_sec: None,
+            unit: Some("[Service]\nType=oneshot\nExecStart=%h/bin/backup\n".to_string()),
+            timer: Some("[Timer]\nOnCalendar=daily\n\n[Install]\nWantedBy=timers.target\n".to_string()),
+            enable: Some(true),
+            start: Some(true),
+            action: None,
+            notified: false,
+        };
+
+        let atoms = action.plan(std::path::Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert_eq!(
+            atoms[0].describe(),
+            "Create systemd service: backup.service with backup.timer (enable, start)"
+        );
+    }
+
+    #[test]
+    fn test_systemd_service_reload_as_handler() {
+        let mut action = SystemdService {
+            name: "nginx.service".to_string(),
+            description: None,
+            exec_start: Some("/usr/sbin/nginx".to_string()),
+            service_type: Some("forking".to_string()),
+            scope: Some("system".to_string()),
+            restart: None,
+            restart_sec: None,
+            unit: None,
+            timer: None,
+            enable: None,
+            start: Some(true),
+            action: Some("reload".to_string()),
+            notified: false,
+        };
+        let describe = |action: &SystemdService| action.plan(Path::new("."))[0].describe();
+
+        assert_eq!(
+            describe(&action),
+            "Create systemd service: nginx.service (start, reload on change)"
+        );
+        action.notified = true;
+        assert_eq!(
+            describe(&action),
+            "Create systemd service: nginx.service (start, reload)"
+        );
+    }
 }
@@ -0,0 +1,64 @@
+use super::{Action, ActionType};
+use crate::atom::Atom;
+use dhd_macros::typescript_type;
+use std::path::Path;
+
+/// An action carrying tags, for running or skipping it with `--only-tags`
+/// and `--skip-tags`
+///
+/// Set with `tags: ["heavy"]` (or a single name) on any action. Unlike module
+/// tags, these select actions within the modules that are selected.
+#[typescript_type]
+pub struct TaggedAction {
+    /// The wrapped action
+    pub action: Box<ActionType>,
+    /// The action's tags
+    pub tags: Vec<String>,
+}
+
+impl TaggedAction {
+    pub fn new(action: ActionType, tags: Vec<String>) -> Self {
+        Self {
+            action: Box::new(action),
+            tags,
+        }
+    }
+}
+
+impl Action for TaggedAction {
+    fn name(&self) -> &str {
+        self.action.name()
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn Atom>> {
+        self.action.plan(module_dir)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::ShellCommand;
+
+    #[test]
+    fn test_tagged_plans_the_wrapped_action() {
+        let action = ActionType::Tagged(TaggedAction::new(
+            ActionType::ShellCommand(ShellCommand {
+                run: "true".to_string(),
+                shell: None,
+                env: None,
+                only_if: None,
+                unless: None,
+                cwd: None,
+                changed_when: None,
+                failed_when: None,
+            }),
+            vec!["heavy".to_string()],
+        ));
+
+        assert_eq!(action.plan(Path::new(".")).len(), 1);
+        assert_eq!(action.name(), "ShellCommand");
+        assert_eq!(action.type_name(), "command");
+        assert_eq!(action.tags(), ["heavy".to_string()]);
+    }
+}
@@ -0,0 +1,113 @@
+use super::Action;
+use crate::atoms::AtomCompat;
+use dhd_macros::{typescript_fn, typescript_type};
+use std::collections::HashMap;
+use std::path::Path;
+
+#[typescript_type]
+/// Renders a template file from the module directory to a destination
+///
+/// * `source` - Template file, relative to the module directory unless absolute
+/// * `content` - The template itself, for small files, instead of a `source`
+/// * `target` - Path where the rendered file is written (supports `~/`)
+/// * `variables` - Values for `{{ name }}` placeholders and `{{#if name}}` blocks
+/// * `ensure` - `"present"` (default) or `"absent"` to remove the file an
+///   earlier apply rendered, or put back what it replaced
+///
+/// Host facts such as `{{ host.hostname }}` or `{{ os.family }}` are available
+/// too; declared variables take precedence over facts with the same name.
+pub struct Template {
+    pub source: Option<String>,
+    pub content: Option<String>,
+    pub target: String,
+    pub variables: Option<HashMap<String, String>>,
+    pub ensure: Option<String>,
+}
+
+#[typescript_fn]
+pub fn template(config: Template) -> super::ActionType {
+    super::ActionType::Template(config)
+}
+
+impl Action for Template {
+    fn name(&self) -> &str {
+        "Template"
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let source_path = self
+            .source
+            .as_ref()
+            .map(|source| crate::paths::resolve(module_dir, source))
+            .unwrap_or_default();
+
+        let target_path = crate::paths::expand_path(&self.target);
+        if self.ensure.as_deref() == Some("absent") {
+            return super::retire(target_path, "render_template");
+        }
+
+        let mut variables = crate::system_info::fact_variables();
+        variables.extend(self.variables.clone().unwrap_or_default());
+
+        vec![Box::new(AtomCompat::new(
+            Box::new(
+                crate::atoms::RenderTemplate::new(source_path, target_path, variables)
+                    .with_module_dir(module_dir.to_path_buf())
+                    .with_content(self.content.clone()),
+            ),
+            "render_template".to_string(),
+        ))]
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::ActionType;
+
+    #[test]
+    fn test_template_helper_function() {
+        let mut variables = HashMap::new();
+        variables.insert("email".to_string(), "jane@example.com".to_string());
+
+        let action = template(Template {
+            source: Some("gitconfig.tmpl".to_string()),
+            content: None,
+            target: "~/.gitconfig".to_string(),
+            variables: Some(variables),
+            ensure: None,
+        });
+
+        match action {
+            ActionType::Template(tmpl) => {
+                assert_eq!(tmpl.source.as_deref(), Some("gitconfig.tmpl"));
+                assert_eq!(tmpl.target, "~/.gitconfig");
+                assert_eq!(
+                    tmpl.variables.unwrap().get("email"),
+                    Some(&"jane@example.com".to_string())
+                );
+            }
+            _ => panic!("Expected Template action type"),
+        }
+    }
+
+    #[test]
+    fn test_template_plan() {
+        let action = Template {
+            source: Some("templates/gitconfig.tmpl".to_string()),
+            content: None,
+            target: "/home/user/.gitconfig".to_string(),
+            variables: None,
+            ensure: None,
+        };
+
+        assert_eq!(action.name(), "Template");
+
+        let atoms = action.plan(Path::new("/modules/git"));
+        assert_eq!(atoms.len(), 1);
+
+        let description = atoms[0].describe();
+        assert!(description.contains("/modules/git/templates/gitconfig.tmpl"));
+        assert!(description.contains("/home/user/.gitconfig"));
+    }
+}
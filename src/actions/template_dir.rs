@@ -0,0 +1,198 @@
+use super::Action;
+use crate::atoms::AtomCompat;
+use dhd_macros::{typescript_fn, typescript_type};
+use std::collections::HashMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// Entries of a source tree that are never rendered or copied
+const IGNORED: &[&str] = &[".git"];
+
+#[typescript_type]
+/// Renders a directory tree of templates from the module directory to a
+/// target tree
+///
+/// * `source` - Directory relative to the module directory unless absolute
+/// * `target` - Directory the tree is written to (supports `~/`)
+/// * `variables` - Values for the templates' placeholders, as for `template`
+/// * `extension` - Files ending in it are rendered and written without it;
+///   other files are copied as they are (default: `.tmpl`)
+///
+/// Every file keeps its path under `source` and its permission bits.
+pub struct TemplateDir {
+    pub source: String,
+    pub target: String,
+    pub variables: Option<HashMap<String, String>>,
+    pub extension: Option<String>,
+}
+
+#[typescript_fn]
+pub fn template_dir(config: TemplateDir) -> super::ActionType {
+    super::ActionType::TemplateDir(config)
+}
+
+impl TemplateDir {
+    /// The suffix of the files that are rendered, like `.tmpl`
+    fn suffix(&self) -> String {
+        let extension = self.extension.as_deref().unwrap_or("tmpl");
+        format!(".{}", extension.trim_start_matches('.'))
+    }
+}
+
+/// Every file under `dir`, leaving out ignored entries
+fn files(dir: &Path, found: &mut Vec<PathBuf>) -> Result<(), String> {
+    let read = fs::read_dir(dir).map_err(|e| format!("Failed to read {}: {}", dir.display(), e))?;
+    for entry in read {
+        let entry = entry.map_err(|e| format!("Failed to read {}: {}", dir.display(), e))?;
+        if IGNORED.iter().any(|ignored| entry.file_name() == *ignored) {
+            continue;
+        }
+        let path = entry.path();
+        if path.is_dir() {
+            files(&path, found)?;
+        } else {
+            found.push(path);
+        }
+    }
+    Ok(())
+}
+
+/// The permission bits of `path`, where files have them
+fn mode(path: &Path) -> Option<u32> {
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::PermissionsExt;
+        fs::metadata(path)
+            .ok()
+            .map(|metadata| metadata.permissions().mode() & 0o7777)
+    }
+
+    #[cfg(not(unix))]
+    {
+        let _ = path;
+        None
+    }
+}
+
+impl Action for TemplateDir {
+    fn name(&self) -> &str {
+        "TemplateDir"
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn crate::atom::Atom>> {
+        let source_dir = crate::paths::resolve(module_dir, &self.source);
+        let target_dir = crate::paths::expand_path(&self.target);
+
+        let mut sources = Vec::new();
+        if let Err(e) = files(&source_dir, &mut sources) {
+            log::warn!("templateDir: {}", e);
+            return Vec::new();
+        }
+        sources.sort();
+
+        let mut variables = crate::system_info::fact_variables();
+        variables.extend(self.variables.clone().unwrap_or_default());
+        let suffix = self.suffix();
+
+        // One atom per file, so each is checked, written and backed up on its own
+        sources
+            .into_iter()
+            .filter_map(|source| -> Option<Box<dyn crate::atom::Atom>> {
+                let relative = source.strip_prefix(&source_dir).ok()?.to_string_lossy();
+                let mode = mode(&source);
+                let atom: Box<dyn crate::atoms::Atom> = match relative.strip_suffix(&suffix) {
+                    // A file named just `.tmpl` has no name to be written under
+                    Some(rendered) if !rendered.is_empty() && !rendered.ends_with('/') => Box::new(
+                        crate::atoms::RenderTemplate::new(
+                            source.clone(),
+                            target_dir.join(rendered),
+                            variables.clone(),
+                        )
+                        .with_module_dir(module_dir.to_path_buf())
+                        .with_mode(mode),
+                    ),
+                    _ => Box::new(crate::atoms::copy_file::CopyFile::new(
+                        source.clone(),
+                        target_dir.join(relative.as_ref()),
+                        false,
+                        mode,
+                        None,
+                        None,
+                    )),
+                };
+                Some(Box::new(AtomCompat::new(atom, "template_dir".to_string())))
+            })
+            .collect()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::ActionType;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_template_dir_helper_function() {
+        let action = template_dir(TemplateDir {
+            source: "config/app".to_string(),
+            target: "~/.config/app".to_string(),
+            variables: None,
+            extension: Some("j2".to_string()),
+        });
+
+        match action {
+            ActionType::TemplateDir(dir) => {
+                assert_eq!(dir.source, "config/app");
+                assert_eq!(dir.suffix(), ".j2");
+            }
+            _ => panic!("Expected TemplateDir action type"),
+        }
+    }
+
+    #[test]
+    fn test_template_dir_plan_renders_templates_and_copies_other_files() {
+        let temp_dir = TempDir::new().unwrap();
+        let source = temp_dir.path().join("app");
+        fs::create_dir_all(source.join("themes")).unwrap();
+        fs::create_dir_all(source.join(".git")).unwrap();
+        fs::write(source.join("config.toml.tmpl"), "user = {{ user }}\n").unwrap();
+        fs::write(source.join("themes/dark.css"), "body {}\n").unwrap();
+        fs::write(source.join(".git/HEAD"), "ref: main\n").unwrap();
+
+        let action = TemplateDir {
+            source: "app".to_string(),
+            target: "/home/user/.config/app".to_string(),
+            variables: None,
+            extension: None,
+        };
+        assert_eq!(action.name(), "TemplateDir");
+
+        let atoms = action.plan(temp_dir.path());
+        let descriptions: Vec<String> = atoms.iter().map(|atom| atom.describe()).collect();
+        assert_eq!(descriptions.len(), 2, "{:?}", descriptions);
+        assert!(
+            descriptions[0].starts_with("Render template")
+                && descriptions[0].ends_with("-> /home/user/.config/app/config.toml"),
+            "{}",
+            descriptions[0]
+        );
+        assert!(
+            descriptions[1].contains("/home/user/.config/app/themes/dark.css"),
+            "{}",
+            descriptions[1]
+        );
+    }
+
+    #[test]
+    fn test_template_dir_plan_missing_source() {
+        let action = TemplateDir {
+            source: "missing".to_string(),
+            target: "/home/user/.config/app".to_string(),
+            variables: None,
+            extension: None,
+        };
+
+        assert!(action.plan(Path::new("/nonexistent/module")).is_empty());
+    }
+}
@@ -0,0 +1,218 @@
+use super::{Action, ActionType};
+use crate::atom::{Atom, AtomStatus, Destruction};
+use crate::logging::LoggedCommand;
+use dhd_macros::typescript_type;
+use std::any::Any;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+use std::sync::Arc;
+use std::sync::atomic::{AtomicBool, Ordering};
+
+/// An action whose outcome is asserted with a command once it has run
+///
+/// Set with `verify: "command -v rg"` on any action. The command runs in the
+/// module's directory after the action changed something, and the action
+/// fails if it exits non-zero, even though applying it succeeded.
+#[typescript_type]
+pub struct VerifiedAction {
+    /// The wrapped action
+    pub action: Box<ActionType>,
+    /// Shell command that must succeed once the action has run
+    pub verify: String,
+}
+
+impl VerifiedAction {
+    pub fn new(action: ActionType, verify: String) -> Self {
+        Self {
+            action: Box::new(action),
+            verify,
+        }
+    }
+
+    /// Mark atoms planned for the wrapped action as verified by the command,
+    /// which runs after the last of them if any of them ran
+    pub fn wrap(&self, atoms: Vec<Box<dyn Atom>>, module_dir: &Path) -> Vec<Box<dyn Atom>> {
+        let ran = Arc::new(AtomicBool::new(false));
+        let last = atoms.len().saturating_sub(1);
+        atoms
+            .into_iter()
+            .enumerate()
+            .map(|(idx, inner)| {
+                Box::new(VerifyingAtom {
+                    inner,
+                    ran: Arc::clone(&ran),
+                    verify: (idx == last).then(|| Verification {
+                        command: self.verify.clone(),
+                        dir: module_dir.to_path_buf(),
+                    }),
+                }) as Box<dyn Atom>
+            })
+            .collect()
+    }
+}
+
+impl Action for VerifiedAction {
+    fn name(&self) -> &str {
+        self.action.name()
+    }
+
+    fn plan(&self, module_dir: &Path) -> Vec<Box<dyn Atom>> {
+        self.wrap(self.action.plan(module_dir), module_dir)
+    }
+}
+
+struct Verification {
+    command: String,
+    dir: PathBuf,
+}
+
+impl Verification {
+    fn run(&self) -> anyhow::Result<()> {
+        let output = Command::new("sh")
+            .arg("-c")
+            .arg(&self.command)
+            .current_dir(&self.dir)
+            .logged_output()
+            .map_err(|e| anyhow::anyhow!("Failed to run '{}': {}", self.command, e))?;
+        if output.status.success() {
+            return Ok(());
+        }
+
+        let code = output
+            .status
+            .code()
+            .map_or("a signal".to_string(), |code| code.to_string());
+        Err(anyhow::anyhow!(
+            "'{}' exited with {}\nstdout: {}\nstderr: {}",
+            self.command,
+            code,
+            String::from_utf8_lossy(&output.stdout).trim(),
+            String::from_utf8_lossy(&output.stderr).trim()
+        ))
+    }
+}
+
+/// An atom of a verified action, recording whether it ran; the action's last
+/// atom also carries the verification
+struct VerifyingAtom {
+    inner: Box<dyn Atom>,
+    /// Whether any atom of the action ran
+    ran: Arc<AtomicBool>,
+    verify: Option<Verification>,
+}
+
+impl Atom for VerifyingAtom {
+    fn check(&self) -> anyhow::Result<bool> {
+        self.inner.check()
+    }
+
+    fn execute(&self) -> anyhow::Result<()> {
+        self.inner.execute()?;
+        self.ran.store(true, Ordering::Relaxed);
+        Ok(())
+    }
+
+    fn execute_changed(&self) -> anyhow::Result<bool> {
+        let changed = self.inner.execute_changed()?;
+        if changed {
+            self.ran.store(true, Ordering::Relaxed);
+        }
+        Ok(changed)
+    }
+
+    fn verify(&self) -> anyhow::Result<()> {
+        self.inner.verify()?;
+        match &self.verify {
+            Some(verification) if self.ran.load(Ordering::Relaxed) => verification.run(),
+            _ => Ok(()),
+        }
+    }
+
+    fn describe(&self) -> String {
+        self.inner.describe()
+    }
+
+    fn module(&self) -> &str {
+        self.inner.module()
+    }
+
+    fn as_any(&self) -> &dyn Any {
+        self.inner.as_any()
+    }
+
+    fn id(&self) -> String {
+        self.inner.id()
+    }
+
+    fn status(&self) -> anyhow::Result<AtomStatus> {
+        self.inner.status()
+    }
+
+    fn audit(&self) -> AtomStatus {
+        self.inner.audit()
+    }
+
+    fn file_change(&self) -> Option<Result<crate::diff::FileChange, String>> {
+        self.inner.file_change()
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        self.inner.destruction()
+    }
+
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        self.inner.managed_paths()
+    }
+
+    fn dependencies(&self) -> Vec<String> {
+        self.inner.dependencies()
+    }
+
+    fn notifies(&self) -> &[String] {
+        self.inner.notifies()
+    }
+
+    fn requires_reboot(&self) -> bool {
+        self.inner.requires_reboot()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::ShellCommand;
+
+    fn verified(run: &str, verify: &str) -> VerifiedAction {
+        VerifiedAction::new(
+            ActionType::ShellCommand(ShellCommand {
+                run: run.to_string(),
+                shell: None,
+                env: None,
+                only_if: None,
+                unless: None,
+                cwd: None,
+                changed_when: None,
+                failed_when: None,
+            }),
+            verify.to_string(),
+        )
+    }
+
+    #[test]
+    fn test_verify_runs_only_after_the_action_ran() {
+        let atoms = verified("true", "false").plan(Path::new("."));
+        assert_eq!(atoms.len(), 1);
+        assert!(atoms[0].verify().is_ok());
+
+        atoms[0].execute_changed().unwrap();
+        let error = atoms[0].verify().unwrap_err().to_string();
+        assert!(error.contains("'false' exited with 1"), "{}", error);
+    }
+
+    #[test]
+    fn test_verify_passes_when_the_command_succeeds() {
+        let atoms = verified("true", "test -d .").plan(Path::new("."));
+        atoms[0].execute_changed().unwrap();
+        assert!(atoms[0].verify().is_ok());
+    }
+}
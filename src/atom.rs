@@ -1,4 +1,40 @@
 use std::any::Any;
+use std::path::PathBuf;
+
+/// Whether an atom still has work to do
+#[derive(Debug, Clone, Copy, PartialEq, Eq, serde::Serialize, serde::Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum AtomStatus {
+    /// The system already matches what the atom would produce
+    Satisfied,
+    /// The atom would change the system
+    Pending,
+    /// The atom has no idempotency check and always runs
+    Unchecked,
+}
+
+/// Something an atom would destroy, which apply asks about before running
+#[derive(Debug, Clone, PartialEq)]
+pub enum Destruction {
+    /// An existing file that would be overwritten or replaced
+    Overwrite(PathBuf),
+    /// Installed packages that would be removed
+    RemovePackages {
+        manager: String,
+        packages: Vec<String>,
+    },
+}
+
+impl Destruction {
+    pub fn describe(&self) -> String {
+        match self {
+            Destruction::Overwrite(path) => format!("overwrite {}", path.display()),
+            Destruction::RemovePackages { manager, packages } => {
+                format!("remove {} packages: {}", manager, packages.join(", "))
+            }
+        }
+    }
+}
 
 /// Low-level atomic operation that can be executed
 pub trait Atom: Send + Sync {
@@ -8,6 +44,18 @@ pub trait Atom: Send + Sync {
     /// Execute the atom
     fn execute(&self) -> anyhow::Result<()>;
 
+    /// Execute the atom, reporting whether it changed anything; atoms that
+    /// can't tell report a change
+    fn execute_changed(&self) -> anyhow::Result<bool> {
+        self.execute().map(|()| true)
+    }
+
+    /// Assert the outcome once the atom has been checked and run, failing
+    /// the atom even though it applied; nothing to assert by default
+    fn verify(&self) -> anyhow::Result<()> {
+        Ok(())
+    }
+
     /// Get a human-readable description
     fn describe(&self) -> String;
 
@@ -22,8 +70,54 @@ pub trait Atom: Send + Sync {
         format!("{}::{}", self.module(), self.describe())
     }
 
+    /// Report whether this atom would change anything, without mutating the system
+    fn status(&self) -> anyhow::Result<AtomStatus> {
+        Ok(if self.check()? {
+            AtomStatus::Pending
+        } else {
+            AtomStatus::Satisfied
+        })
+    }
+
+    /// Report like `status`, but only from what can be read without side
+    /// effects, for `dhd audit`; `Unchecked` when the atom can't tell that way
+    fn audit(&self) -> AtomStatus {
+        AtomStatus::Unchecked
+    }
+
+    /// The file content this atom would write, for atoms that manage a whole file
+    fn file_change(&self) -> Option<Result<crate::diff::FileChange, String>> {
+        None
+    }
+
+    /// What executing this atom would destroy, without mutating the system
+    fn destruction(&self) -> Option<Destruction> {
+        None
+    }
+
+    /// The paths this atom creates or replaces, which `apply --prune` keeps
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        Ok(Vec::new())
+    }
+
     /// Get dependencies for this atom (empty by default)
     fn dependencies(&self) -> Vec<String> {
         vec![]
     }
+
+    /// Handlers to run at the end of the apply if this atom changes anything
+    fn notifies(&self) -> &[String] {
+        &[]
+    }
+
+    /// Whether the system needs a reboot once this atom changed something
+    fn requires_reboot(&self) -> bool {
+        false
+    }
+
+    /// The packages this atom installs, if it can install them in one
+    /// command together with other atoms
+    fn package_batch(&self) -> Option<crate::atoms::install_packages::PackageBatch> {
+        None
+    }
 }
@@ -0,0 +1,204 @@
+//! Replacing files without ever leaving them half-written
+//!
+//! The new content goes to a temp file next to the target, which is renamed
+//! over it once complete. An interrupted apply leaves either the old file or
+//! the new one, never a mix, plus at worst a stray `.<name>.dhd-<pid>.tmp`.
+
+use std::fs;
+use std::io::Write;
+use std::path::{Path, PathBuf};
+use std::process::Stdio;
+
+/// Replace `target` with `content` through a temp file in the same directory
+///
+/// The file gets `mode` if given; otherwise an existing target keeps its
+/// mode, and a new one gets the default. An existing target also keeps its
+/// owner and group; a new one in the home of `--target-user` is theirs. A
+/// symlinked target has the file it points to replaced, not the link. With
+/// `escalate`, the file is written as root. Errors are the reason only, for
+/// callers to name the file.
+pub fn write(
+    target: &Path,
+    content: &[u8],
+    mode: Option<u32>,
+    escalate: bool,
+) -> Result<(), String> {
+    let target = resolve_links(target);
+    let file_name = target
+        .file_name()
+        .ok_or_else(|| format!("invalid path {}", target.display()))?;
+    let temp = target.with_file_name(format!(
+        ".{}.dhd-{}.tmp",
+        file_name.to_string_lossy(),
+        std::process::id()
+    ));
+
+    if escalate {
+        return write_as_root(&target, &temp, content, mode);
+    }
+
+    // A temp file left by an interrupted run would make create_new fail
+    let _ = fs::remove_file(&temp);
+    let existing = fs::metadata(&target).ok();
+
+    let write = || -> std::io::Result<()> {
+        let mut options = fs::OpenOptions::new();
+        options.write(true).create_new(true);
+        #[cfg(unix)]
+        if mode.is_some() || existing.is_some() {
+            use std::os::unix::fs::OpenOptionsExt;
+            // Private until it has its final mode, in case the content is secret
+            options.mode(0o600);
+        }
+
+        let mut file = options.open(&temp)?;
+        file.write_all(content)?;
+        file.sync_all()?;
+
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::{MetadataExt, PermissionsExt};
+            if let Some(existing) = &existing {
+                let written = file.metadata()?;
+                if (written.uid(), written.gid()) != (existing.uid(), existing.gid()) {
+                    std::os::unix::fs::chown(&temp, Some(existing.uid()), Some(existing.gid()))?;
+                }
+            } else {
+                crate::target_user::give(&temp).map_err(std::io::Error::other)?;
+            }
+            let mode = mode.or(existing.as_ref().map(|existing| existing.mode() & 0o7777));
+            if let Some(mode) = mode {
+                file.set_permissions(fs::Permissions::from_mode(mode))?;
+            }
+        }
+        #[cfg(not(unix))]
+        let _ = (mode, &existing);
+
+        fs::rename(&temp, &target)?;
+        // Make the rename itself survive a crash
+        if let Some(dir) = target
+            .parent()
+            .and_then(|parent| fs::File::open(parent).ok())
+        {
+            let _ = dir.sync_all();
+        }
+        Ok(())
+    };
+
+    write().map_err(|e| {
+        let _ = fs::remove_file(&temp);
+        e.to_string()
+    })
+}
+
+/// Write the temp file and rename it as root, with `content` on stdin
+///
+/// `cp -p` first gives the temp file the target's mode and ownership, which
+/// `cat` then keeps while replacing the content.
+fn write_as_root(
+    target: &Path,
+    temp: &Path,
+    content: &[u8],
+    mode: Option<u32>,
+) -> Result<(), String> {
+    let mut script = String::from(
+        "set -e\ntrap 'rm -f \"$1\"' EXIT\nrm -f \"$1\"\n\
+         if [ -e \"$2\" ]; then cp -p \"$2\" \"$1\"; fi\ncat > \"$1\"\n",
+    );
+    if let Some(mode) = mode {
+        script.push_str(&format!("chmod {:o} \"$1\"\n", mode));
+    }
+    script.push_str("mv -f \"$1\" \"$2\"\n");
+
+    let mut child = crate::privilege::root_command("sh")?
+        .args(["-c", &script, "sh"])
+        .arg(temp)
+        .arg(target)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::null())
+        .stderr(Stdio::piped())
+        .spawn()
+        .map_err(|e| e.to_string())?;
+    if let Some(mut stdin) = child.stdin.take() {
+        stdin.write_all(content).map_err(|e| e.to_string())?;
+    }
+    let output = child.wait_with_output().map_err(|e| e.to_string())?;
+    if !output.status.success() {
+        return Err(String::from_utf8_lossy(&output.stderr).trim().to_string());
+    }
+    Ok(())
+}
+
+/// The file `path` ends up at through symlinks, or `path` itself if it isn't
+/// a link or the link is dangling
+fn resolve_links(path: &Path) -> PathBuf {
+    let is_link = fs::symlink_metadata(path)
+        .map(|metadata| metadata.file_type().is_symlink())
+        .unwrap_or(false);
+    if is_link {
+        fs::canonicalize(path).unwrap_or_else(|_| path.to_path_buf())
+    } else {
+        path.to_path_buf()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_write_replaces_the_file_and_keeps_its_mode() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("zshrc");
+        fs::write(&path, "old").unwrap();
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            fs::set_permissions(&path, fs::Permissions::from_mode(0o640)).unwrap();
+        }
+
+        write(&path, b"new", None, false).unwrap();
+        assert_eq!(fs::read_to_string(&path).unwrap(), "new");
+        // Only the target is left in the directory
+        assert_eq!(fs::read_dir(temp_dir.path()).unwrap().count(), 1);
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            let mode = fs::metadata(&path).unwrap().permissions().mode() & 0o7777;
+            assert_eq!(mode, 0o640);
+
+            write(&path, b"newer", Some(0o600), false).unwrap();
+            let mode = fs::metadata(&path).unwrap().permissions().mode() & 0o7777;
+            assert_eq!(mode, 0o600);
+        }
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_write_through_a_symlink_replaces_the_linked_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let file = temp_dir.path().join("dotfiles-zshrc");
+        let link = temp_dir.path().join(".zshrc");
+        fs::write(&file, "old").unwrap();
+        std::os::unix::fs::symlink(&file, &link).unwrap();
+
+        write(&link, b"new", None, false).unwrap();
+        assert!(
+            fs::symlink_metadata(&link)
+                .unwrap()
+                .file_type()
+                .is_symlink()
+        );
+        assert_eq!(fs::read_to_string(&file).unwrap(), "new");
+    }
+
+    #[test]
+    fn test_failed_write_leaves_the_target_alone() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("missing").join("zshrc");
+
+        assert!(write(&path, b"new", None, false).is_err());
+        assert!(!temp_dir.path().join("missing").exists());
+    }
+}
@@ -0,0 +1,342 @@
+use crate::atom::Destruction;
+use crate::atoms::Atom;
+use crate::diff::FileChange;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// Keep a named block of lines between dhd markers in a file dhd doesn't own
+///
+/// Everything outside the markers is left as it is. With `content` set to
+/// `None` the block, markers included, is removed.
+#[derive(Debug, Clone)]
+pub struct BlockInFile {
+    pub path: PathBuf,
+    pub name: String,
+    pub content: Option<String>,
+    pub escalate: bool,
+    /// Line a new block goes before, when the file has it; otherwise it's appended
+    pub before: Option<String>,
+}
+
+impl BlockInFile {
+    pub fn new(path: PathBuf, name: String, content: Option<String>, escalate: bool) -> Self {
+        Self {
+            path,
+            name,
+            content,
+            escalate,
+            before: None,
+        }
+    }
+
+    /// Add the block before the first `line` of the file, if there is one
+    pub fn with_before(mut self, line: &str) -> Self {
+        self.before = Some(line.to_string());
+        self
+    }
+
+    fn begin(&self) -> String {
+        format!("# BEGIN dhd:{}", self.name)
+    }
+
+    fn end(&self) -> String {
+        format!("# END dhd:{}", self.name)
+    }
+
+    /// `current` with the block updated, added or removed
+    pub(crate) fn desired(&self, current: &str) -> String {
+        let begin = self.begin();
+        let end = self.end();
+        let mut lines: Vec<&str> = current.lines().collect();
+
+        // The block's line range, markers included
+        let existing = lines
+            .iter()
+            .position(|line| *line == begin)
+            .and_then(|start| {
+                lines[start..]
+                    .iter()
+                    .position(|line| *line == end)
+                    .map(|len| (start, start + len))
+            });
+
+        let block: Vec<&str> = match &self.content {
+            Some(content) => std::iter::once(begin.as_str())
+                .chain(content.trim_end_matches('\n').lines())
+                .chain(std::iter::once(end.as_str()))
+                .collect(),
+            None => Vec::new(),
+        };
+
+        match existing {
+            Some((start, end)) => {
+                lines.splice(start..=end, block);
+            }
+            None if block.is_empty() => return current.to_string(),
+            None => {
+                let at = self
+                    .before
+                    .as_deref()
+                    .and_then(|before| position_before(&lines, before))
+                    .unwrap_or(lines.len());
+                lines.splice(at..at, block);
+            }
+        }
+
+        if lines.is_empty() {
+            String::new()
+        } else {
+            lines.join("\n") + "\n"
+        }
+    }
+
+    fn change(&self) -> Result<FileChange, String> {
+        file_change(&self.path, |current| self.desired(current))
+    }
+}
+
+/// Where a block added before `before` goes: at the first line that is
+/// `before`, or at the markers of the dhd block that line is in
+fn position_before(lines: &[&str], before: &str) -> Option<usize> {
+    let mut block = None;
+    for (i, line) in lines.iter().enumerate() {
+        if line.starts_with("# BEGIN dhd:") {
+            block = Some(i);
+        } else if line.starts_with("# END dhd:") {
+            block = None;
+        } else if line.trim() == before {
+            return Some(block.unwrap_or(i));
+        }
+    }
+    None
+}
+
+/// The change `edit` makes to the file at `path`, which need not exist
+pub(crate) fn file_change(
+    path: &Path,
+    edit: impl Fn(&str) -> String,
+) -> Result<FileChange, String> {
+    let current = match fs::read(path) {
+        Ok(current) => Some(current),
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => None,
+        Err(e) => return Err(format!("Failed to read {}: {}", path.display(), e)),
+    };
+    let text = current
+        .as_deref()
+        .map(String::from_utf8_lossy)
+        .unwrap_or_default();
+
+    // A missing file stays missing when there is nothing to put in it
+    let desired = edit(&text);
+    let current = match current {
+        None if desired.is_empty() => Some(Vec::new()),
+        current => current,
+    };
+
+    Ok(FileChange {
+        target: path.to_path_buf(),
+        desired: desired.into_bytes(),
+        current,
+    })
+}
+
+/// Replace a file with its edited content, keeping its ownership, and its
+/// mode unless `mode` is given
+pub(crate) fn write_file(
+    change: &FileChange,
+    mode: Option<u32>,
+    escalate: bool,
+) -> Result<(), String> {
+    let path = &change.target;
+    if change.current.is_none() {
+        if let Some(parent) = path.parent().filter(|parent| !parent.exists()) {
+            if escalate {
+                let output = crate::privilege::root_command("mkdir")?
+                    .arg("-p")
+                    .arg(parent)
+                    .output()
+                    .map_err(|e| format!("Failed to create {}: {}", parent.display(), e))?;
+                if !output.status.success() {
+                    return Err(format!(
+                        "Failed to create {}: {}",
+                        parent.display(),
+                        String::from_utf8_lossy(&output.stderr)
+                    ));
+                }
+            } else {
+                crate::target_user::create_dir_all(parent)
+                    .map_err(|e| format!("Failed to create {}: {}", parent.display(), e))?;
+            }
+        }
+    }
+    let previous = crate::state::preserve(path);
+
+    crate::atoms::atomic_write::write(path, &change.desired, mode, escalate)
+        .map_err(|e| format!("Failed to write {}: {}", path.display(), e))?;
+
+    if let Some(previous) = previous {
+        crate::state::record(crate::state::Change::File {
+            path: path.clone(),
+            previous,
+            escalate,
+            written: None,
+        });
+    }
+    Ok(())
+}
+
+impl Atom for BlockInFile {
+    fn name(&self) -> &str {
+        "BlockInFile"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        let change = self.change()?;
+        if !change.is_changed() {
+            return Ok(());
+        }
+        write_file(&change, None, self.escalate)
+    }
+
+    fn check(&self) -> Option<bool> {
+        self.change().ok().map(|change| change.is_changed())
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
+    }
+
+    fn file_change(&self) -> Option<Result<FileChange, String>> {
+        Some(self.change())
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        // Only the lines between the markers are managed
+        None
+    }
+
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        Ok(vec![self.path.clone()])
+    }
+
+    fn describe(&self) -> String {
+        match self.content {
+            Some(_) => format!("Update block '{}' in {}", self.name, self.path.display()),
+            None => format!("Remove block '{}' from {}", self.name, self.path.display()),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn block(path: &Path, content: Option<&str>) -> BlockInFile {
+        BlockInFile::new(
+            path.to_path_buf(),
+            "work".to_string(),
+            content.map(String::from),
+            false,
+        )
+    }
+
+    #[test]
+    fn test_block_is_added_updated_and_removed() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("config");
+        fs::write(&path, "Host *\n    AddKeysToAgent yes\n").unwrap();
+
+        let add = block(&path, Some("Host work\n    User me\n"));
+        add.execute().unwrap();
+        assert_eq!(add.check(), Some(false));
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            "Host *\n    AddKeysToAgent yes\n# BEGIN dhd:work\nHost work\n    User me\n# END dhd:work\n"
+        );
+
+        // Lines added after the block stay after it
+        let mut content = fs::read_to_string(&path).unwrap();
+        content.push_str("Host other\n");
+        fs::write(&path, content).unwrap();
+        block(&path, Some("Host work\n    User you"))
+            .execute()
+            .unwrap();
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            "Host *\n    AddKeysToAgent yes\n# BEGIN dhd:work\nHost work\n    User you\n# END dhd:work\nHost other\n"
+        );
+
+        let remove = block(&path, None);
+        remove.execute().unwrap();
+        assert_eq!(remove.check(), Some(false));
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            "Host *\n    AddKeysToAgent yes\nHost other\n"
+        );
+    }
+
+    #[test]
+    fn test_removing_from_a_missing_file_creates_nothing() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("hosts");
+
+        let remove = block(&path, None);
+        assert_eq!(remove.check(), Some(false));
+        remove.execute().unwrap();
+        assert!(!path.exists());
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_file_mode_is_kept() {
+        use std::os::unix::fs::PermissionsExt;
+
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("config");
+        fs::write(&path, "").unwrap();
+        fs::set_permissions(&path, fs::Permissions::from_mode(0o600)).unwrap();
+
+        block(&path, Some("Host work")).execute().unwrap();
+        let mode = fs::metadata(&path).unwrap().permissions().mode();
+        assert_eq!(mode & 0o777, 0o600);
+    }
+
+    #[test]
+    fn test_new_block_goes_before_a_given_line() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("config");
+        fs::write(&path, "Host nas\n    User admin\n\nHost *\n    User me\n").unwrap();
+
+        block(&path, Some("Host work"))
+            .with_before("Host *")
+            .execute()
+            .unwrap();
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            "Host nas\n    User admin\n\n# BEGIN dhd:work\nHost work\n# END dhd:work\nHost *\n    User me\n"
+        );
+
+        // A line in another block puts it before that block
+        let mut other = block(&path, Some("Host nas"));
+        other.name = "nas".to_string();
+        fs::write(&path, "# BEGIN dhd:all\nHost *\n# END dhd:all\n").unwrap();
+        other.with_before("Host *").execute().unwrap();
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            "# BEGIN dhd:nas\nHost nas\n# END dhd:nas\n# BEGIN dhd:all\nHost *\n# END dhd:all\n"
+        );
+
+        // Without the line, the block is appended
+        let other = temp_dir.path().join("other");
+        fs::write(&other, "Host nas\n").unwrap();
+        block(&other, Some("Host work"))
+            .with_before("Host *")
+            .execute()
+            .unwrap();
+        assert_eq!(
+            fs::read_to_string(&other).unwrap(),
+            "Host nas\n# BEGIN dhd:work\nHost work\n# END dhd:work\n"
+        );
+    }
+}
@@ -1,5 +1,5 @@
 /// Compatibility adapter to bridge old Atom trait to new Atom trait
-use crate::atom::Atom as NewAtom;
+use crate::atom::{Atom as NewAtom, AtomStatus, Destruction};
 use std::any::Any;
 
 pub struct AtomCompat {
@@ -15,14 +15,52 @@ impl AtomCompat {
 
 impl NewAtom for AtomCompat {
     fn check(&self) -> anyhow::Result<bool> {
-        // Old atoms don't have check, always return true
-        Ok(true)
+        // Atoms without an idempotency check always run
+        Ok(self.inner.check().unwrap_or(true))
+    }
+
+    fn status(&self) -> anyhow::Result<AtomStatus> {
+        Ok(match self.inner.check() {
+            Some(true) => AtomStatus::Pending,
+            Some(false) => AtomStatus::Satisfied,
+            None => AtomStatus::Unchecked,
+        })
+    }
+
+    fn audit(&self) -> AtomStatus {
+        match self.inner.audit() {
+            Some(true) => AtomStatus::Pending,
+            Some(false) => AtomStatus::Satisfied,
+            None => AtomStatus::Unchecked,
+        }
+    }
+
+    fn file_change(&self) -> Option<Result<crate::diff::FileChange, String>> {
+        self.inner.file_change()
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        self.inner.destruction()
+    }
+
+    fn managed_paths(&self) -> Result<Vec<std::path::PathBuf>, String> {
+        self.inner.managed_paths()
+    }
+
+    fn package_batch(&self) -> Option<crate::atoms::install_packages::PackageBatch> {
+        self.inner.package_batch()
     }
 
     fn execute(&self) -> anyhow::Result<()> {
         self.inner.execute().map_err(|e| anyhow::anyhow!("{}", e))
     }
 
+    fn execute_changed(&self) -> anyhow::Result<bool> {
+        self.inner
+            .execute_changed()
+            .map_err(|e| anyhow::anyhow!("{}", e))
+    }
+
     fn describe(&self) -> String {
         self.inner.describe()
     }
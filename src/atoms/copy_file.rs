@@ -1,4 +1,6 @@
 use crate::atoms::Atom;
+use crate::diff::FileChange;
+use crate::logging::LoggedCommand;
 use std::fs;
 use std::path::PathBuf;
 use std::process::Command;
@@ -8,16 +10,176 @@ pub struct CopyFile {
     pub source: PathBuf,
     pub target: PathBuf,
     pub escalate: bool,
+    pub mode: Option<u32>,
+    pub owner: Option<String>,
+    pub group: Option<String>,
+    /// Create missing parent directories of the target
+    pub create_parents: bool,
+    /// Text to write to the target instead of the source's content
+    pub content: Option<String>,
 }
 
 impl CopyFile {
-    pub fn new(source: PathBuf, target: PathBuf, escalate: bool) -> Self {
+    pub fn new(
+        source: PathBuf,
+        target: PathBuf,
+        escalate: bool,
+        mode: Option<u32>,
+        owner: Option<String>,
+        group: Option<String>,
+    ) -> Self {
         Self {
             source,
             target,
             escalate,
+            mode,
+            owner,
+            group,
+            create_parents: true,
+            content: None,
         }
     }
+
+    pub fn with_create_parents(mut self, create_parents: bool) -> Self {
+        self.create_parents = create_parents;
+        self
+    }
+
+    /// Write `content` instead of copying the source, when given
+    pub fn with_content(mut self, content: Option<String>) -> Self {
+        self.content = content;
+        self
+    }
+
+    /// Compare the source, or the content, with the current target content
+    fn content_change(&self) -> Result<FileChange, String> {
+        let desired = match &self.content {
+            Some(content) => content.clone().into_bytes(),
+            None => fs::read(&self.source).map_err(|e| {
+                format!(
+                    "Failed to read source file {}: {}",
+                    self.source.display(),
+                    e
+                )
+            })?,
+        };
+
+        Ok(FileChange {
+            target: self.target.clone(),
+            current: fs::read(&self.target).ok(),
+            desired,
+        })
+    }
+
+    /// Check whether the target already has the same content as the source
+    fn content_matches(&self) -> bool {
+        self.content_change()
+            .map(|change| !change.is_changed())
+            .unwrap_or(false)
+    }
+
+    /// Check whether the target already has the requested permission bits
+    fn mode_matches(&self) -> bool {
+        let Some(mode) = self.mode else {
+            return true;
+        };
+
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            fs::metadata(&self.target)
+                .map(|metadata| metadata.permissions().mode() & 0o7777 == mode)
+                .unwrap_or(false)
+        }
+
+        #[cfg(not(unix))]
+        {
+            let _ = mode;
+            true
+        }
+    }
+
+    fn run(&self, program: &str, args: &[&str], action: &str) -> Result<(), String> {
+        let mut cmd = if self.escalate {
+            crate::privilege::root_command(program)?
+        } else {
+            Command::new(program)
+        };
+
+        let output = cmd
+            .args(args)
+            .logged_output()
+            .map_err(|e| format!("Failed to {}: {}", action, e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to {}: {}",
+                action,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
+    fn apply_mode(&self, mode: u32) -> Result<(), String> {
+        if self.escalate {
+            let mode_str = format!("{:o}", mode);
+            let target = self.target.to_string_lossy();
+            return self.run("chmod", &[mode_str.as_str(), target.as_ref()], "set file mode");
+        }
+
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            fs::set_permissions(&self.target, fs::Permissions::from_mode(mode)).map_err(|e| {
+                format!(
+                    "Failed to set mode {:o} on {}: {}",
+                    mode,
+                    self.target.display(),
+                    e
+                )
+            })?;
+        }
+
+        Ok(())
+    }
+
+    fn apply_ownership(&self) -> Result<(), String> {
+        let spec = match (&self.owner, &self.group) {
+            (Some(owner), Some(group)) => format!("{}:{}", owner, group),
+            (Some(owner), None) => owner.clone(),
+            (None, Some(group)) => format!(":{}", group),
+            (None, None) => return Ok(()),
+        };
+
+        if !self.escalate && !crate::privilege::is_root() {
+            return Err(format!(
+                "Setting owner/group on {} requires root; run dhd as root or set escalate: true",
+                self.target.display()
+            ));
+        }
+
+        let target = self.target.to_string_lossy();
+        self.run("chown", &[spec.as_str(), target.as_ref()], "set file ownership")
+    }
+}
+
+/// The permission bits of `path`, where files have them
+fn source_mode(path: &std::path::Path) -> Option<u32> {
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::PermissionsExt;
+        fs::metadata(path)
+            .ok()
+            .map(|metadata| metadata.permissions().mode() & 0o7777)
+    }
+
+    #[cfg(not(unix))]
+    {
+        let _ = path;
+        None
+    }
 }
 
 impl Atom for CopyFile {
@@ -27,7 +189,7 @@ impl Atom for CopyFile {
 
     fn execute(&self) -> Result<(), String> {
         // Check if source exists
-        if !self.source.exists() {
+        if self.content.is_none() && !self.source.exists() {
             return Err(format!(
                 "Source file {} does not exist",
                 self.source.display()
@@ -37,9 +199,15 @@ impl Atom for CopyFile {
         // Create parent directories if needed
         if let Some(parent) = self.target.parent() {
             if !parent.exists() {
+                if !self.create_parents {
+                    return Err(format!(
+                        "Parent directory {} does not exist and createParents is false",
+                        parent.display()
+                    ));
+                }
                 if self.escalate {
-                    let output = Command::new("sudo")
-                        .args(["mkdir", "-p", &parent.to_string_lossy()])
+                    let output = crate::privilege::root_command("mkdir")?
+                        .args(["-p", &parent.to_string_lossy()])
                         .output()
                         .map_err(|e| format!("Failed to create parent directory: {}", e))?;
 
@@ -50,48 +218,192 @@ impl Atom for CopyFile {
                         ));
                     }
                 } else {
-                    fs::create_dir_all(parent)
+                    crate::target_user::create_dir_all(parent)
                         .map_err(|e| format!("Failed to create parent directory: {}", e))?;
                 }
             }
         }
 
-        // Copy the file
-        if self.escalate {
-            let output = Command::new("sudo")
-                .args([
-                    "cp",
-                    &self.source.to_string_lossy(),
-                    &self.target.to_string_lossy(),
-                ])
-                .output()
-                .map_err(|e| format!("Failed to copy file: {}", e))?;
-
-            if !output.status.success() {
-                return Err(format!(
-                    "Failed to copy file: {}",
-                    String::from_utf8_lossy(&output.stderr)
-                ));
-            }
-        } else {
-            fs::copy(&self.source, &self.target).map_err(|e| {
-                format!(
+        // Only rewrite the target when its content differs
+        let change = self.content_change()?;
+        if change.is_changed() {
+            let previous = crate::state::preserve(&self.target);
+
+            // Like cp, the copy gets the source's mode unless one is given
+            let mode = match &self.content {
+                Some(_) => self.mode,
+                None => self.mode.or_else(|| source_mode(&self.source)),
+            };
+            crate::atoms::atomic_write::write(&self.target, &change.desired, mode, self.escalate)
+                .map_err(|e| match &self.content {
+                Some(_) => format!("Failed to write {}: {}", self.target.display(), e),
+                None => format!(
                     "Failed to copy {} to {}: {}",
                     self.source.display(),
                     self.target.display(),
                     e
-                )
+                ),
             })?;
+
+            if let Some(previous) = previous {
+                crate::state::record(crate::state::Change::File {
+                    path: self.target.clone(),
+                    previous,
+                    escalate: self.escalate,
+                    written: Some(crate::state::sha256(&change.desired)),
+                });
+            }
         }
 
-        Ok(())
+        if let Some(mode) = self.mode {
+            self.apply_mode(mode)?;
+        }
+
+        self.apply_ownership()
+    }
+
+    fn check(&self) -> Option<bool> {
+        // Ownership can't be compared without resolving user names, so always apply it
+        if self.owner.is_some() || self.group.is_some() {
+            return None;
+        }
+
+        Some(!(self.content_matches() && self.mode_matches()))
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
+    }
+
+    fn file_change(&self) -> Option<Result<FileChange, String>> {
+        Some(self.content_change())
+    }
+
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        Ok(vec![self.target.clone()])
     }
 
     fn describe(&self) -> String {
-        format!(
-            "Copy {} -> {}",
-            self.source.display(),
-            self.target.display()
-        )
+        let mut description = match &self.content {
+            Some(_) => format!("Write inline content -> {}", self.target.display()),
+            None => format!(
+                "Copy {} -> {}",
+                self.source.display(),
+                self.target.display()
+            ),
+        };
+        if let Some(mode) = self.mode {
+            description.push_str(&format!(" (mode {:o})", mode));
+        }
+        description
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_copy_file_copies_content() {
+        let temp_dir = TempDir::new().unwrap();
+        let source = temp_dir.path().join("source.txt");
+        let target = temp_dir.path().join("nested/target.txt");
+        fs::write(&source, "hello").unwrap();
+
+        let atom = CopyFile::new(source, target.clone(), false, None, None, None);
+        assert!(atom.execute().is_ok());
+        assert_eq!(fs::read_to_string(&target).unwrap(), "hello");
+    }
+
+    #[test]
+    fn test_copy_file_can_require_existing_parent() {
+        let temp_dir = TempDir::new().unwrap();
+        let source = temp_dir.path().join("source.txt");
+        let target = temp_dir.path().join("nested/target.txt");
+        fs::write(&source, "hello").unwrap();
+
+        let atom = CopyFile::new(source, target.clone(), false, None, None, None)
+            .with_create_parents(false);
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("createParents is false"), "{}", err);
+        assert!(!target.parent().unwrap().exists());
+    }
+
+    #[test]
+    fn test_copy_file_skips_identical_content() {
+        let temp_dir = TempDir::new().unwrap();
+        let source = temp_dir.path().join("source.txt");
+        let target = temp_dir.path().join("target.txt");
+        fs::write(&source, "same").unwrap();
+        fs::write(&target, "same").unwrap();
+
+        let atom = CopyFile::new(source, target, false, None, None, None);
+        assert!(atom.content_matches());
+        assert_eq!(atom.check(), Some(false));
+        assert!(atom.execute().is_ok());
+    }
+
+    #[test]
+    #[cfg(unix)]
+    fn test_copy_file_sets_mode() {
+        use std::os::unix::fs::PermissionsExt;
+
+        let temp_dir = TempDir::new().unwrap();
+        let source = temp_dir.path().join("id_ed25519");
+        let target = temp_dir.path().join("ssh/id_ed25519");
+        fs::write(&source, "key").unwrap();
+
+        let atom = CopyFile::new(source, target.clone(), false, Some(0o600), None, None);
+        assert_eq!(atom.check(), Some(true));
+        assert!(atom.execute().is_ok());
+        assert_eq!(atom.check(), Some(false));
+
+        let mode = fs::metadata(&target).unwrap().permissions().mode();
+        assert_eq!(mode & 0o777, 0o600);
+    }
+
+    #[test]
+    fn test_copy_file_missing_source() {
+        let temp_dir = TempDir::new().unwrap();
+        let atom = CopyFile::new(
+            temp_dir.path().join("missing"),
+            temp_dir.path().join("target"),
+            false,
+            None,
+            None,
+            None,
+        );
+
+        let result = atom.execute();
+        assert!(result.is_err());
+        assert!(result.unwrap_err().contains("does not exist"));
+    }
+
+    #[test]
+    fn test_copy_file_writes_inline_content() {
+        let temp_dir = TempDir::new().unwrap();
+        let target = temp_dir.path().join("conf.d/editor.conf");
+
+        let atom = CopyFile::new(PathBuf::new(), target.clone(), false, None, None, None)
+            .with_content(Some("EDITOR=nvim\n".to_string()));
+        assert_eq!(atom.check(), Some(true));
+        assert!(atom.execute().is_ok());
+        assert_eq!(fs::read_to_string(&target).unwrap(), "EDITOR=nvim\n");
+        assert_eq!(atom.check(), Some(false));
+        assert!(atom.describe().starts_with("Write inline content -> "));
+    }
+
+    #[test]
+    fn test_copy_file_reports_binary_change() {
+        let temp_dir = TempDir::new().unwrap();
+        let source = temp_dir.path().join("source.bin");
+        let target = temp_dir.path().join("target.bin");
+        fs::write(&source, [0u8, 1, 2]).unwrap();
+        fs::write(&target, [0u8, 1, 3]).unwrap();
+
+        let atom = CopyFile::new(source, target, false, None, None, None);
+        let change = atom.file_change().unwrap().unwrap();
+        assert!(change.render().unwrap().contains("differs (binary)"));
     }
 }
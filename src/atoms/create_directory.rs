@@ -1,4 +1,5 @@
 use crate::atoms::Atom;
+use crate::logging::LoggedCommand;
 use std::fs;
 use std::path::PathBuf;
 use std::process::Command;
@@ -7,6 +8,11 @@ use std::process::Command;
 pub struct CreateDirectory {
     pub path: PathBuf,
     pub requires_privilege_escalation: bool,
+    pub mode: Option<u32>,
+    /// Create missing parents too; otherwise a missing parent is an error
+    pub recursive: bool,
+    pub owner: Option<String>,
+    pub group: Option<String>,
 }
 
 impl CreateDirectory {
@@ -14,8 +20,152 @@ impl CreateDirectory {
         Self {
             path,
             requires_privilege_escalation,
+            mode: None,
+            recursive: true,
+            owner: None,
+            group: None,
         }
     }
+
+    pub fn with_mode(mut self, mode: Option<u32>) -> Self {
+        self.mode = mode;
+        self
+    }
+
+    pub fn with_recursive(mut self, recursive: bool) -> Self {
+        self.recursive = recursive;
+        self
+    }
+
+    pub fn with_ownership(mut self, owner: Option<String>, group: Option<String>) -> Self {
+        self.owner = owner;
+        self.group = group;
+        self
+    }
+
+    /// Fail when something other than a directory is in the way
+    fn ensure_not_file(&self) -> Result<(), String> {
+        if self.path.exists() && !self.path.is_dir() {
+            return Err(format!(
+                "{} exists but is not a directory",
+                self.path.display()
+            ));
+        }
+        Ok(())
+    }
+
+    fn mode_matches(&self) -> bool {
+        let Some(mode) = self.mode else {
+            return true;
+        };
+
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            fs::metadata(&self.path)
+                .map(|metadata| metadata.permissions().mode() & 0o7777 == mode)
+                .unwrap_or(false)
+        }
+
+        #[cfg(not(unix))]
+        {
+            let _ = mode;
+            true
+        }
+    }
+
+    fn run(&self, program: &str, args: &[&str], action: &str) -> Result<(), String> {
+        let mut cmd = if self.requires_privilege_escalation {
+            crate::privilege::root_command(program)?
+        } else {
+            Command::new(program)
+        };
+
+        let output = cmd
+            .args(args)
+            .logged_output()
+            .map_err(|e| format!("Failed to {}: {}", action, e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to {}: {}",
+                action,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
+    fn create(&self) -> Result<(), String> {
+        let path = self.path.to_string_lossy();
+        if self.requires_privilege_escalation {
+            let args: &[&str] = if self.recursive {
+                &["-p", path.as_ref()]
+            } else {
+                &[path.as_ref()]
+            };
+            return self.run("mkdir", args, "create directory");
+        }
+
+        let result = if self.recursive {
+            crate::target_user::create_dir_all(&self.path)
+        } else {
+            fs::create_dir(&self.path)
+        };
+        result.map_err(|e| format!("Failed to create directory {}: {}", path, e))?;
+        crate::target_user::give(&self.path)
+    }
+
+    fn apply_mode(&self, mode: u32) -> Result<(), String> {
+        if self.requires_privilege_escalation {
+            let mode_str = format!("{:o}", mode);
+            let path = self.path.to_string_lossy();
+            return self.run(
+                "chmod",
+                &[mode_str.as_str(), path.as_ref()],
+                "set directory mode",
+            );
+        }
+
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            fs::set_permissions(&self.path, fs::Permissions::from_mode(mode)).map_err(|e| {
+                format!(
+                    "Failed to set mode {:o} on {}: {}",
+                    mode,
+                    self.path.display(),
+                    e
+                )
+            })?;
+        }
+
+        Ok(())
+    }
+
+    fn apply_ownership(&self) -> Result<(), String> {
+        let spec = match (&self.owner, &self.group) {
+            (Some(owner), Some(group)) => format!("{}:{}", owner, group),
+            (Some(owner), None) => owner.clone(),
+            (None, Some(group)) => format!(":{}", group),
+            (None, None) => return Ok(()),
+        };
+
+        if !self.requires_privilege_escalation && !crate::privilege::is_root() {
+            return Err(format!(
+                "Setting owner/group on {} requires root; run dhd as root or set escalate: true",
+                self.path.display()
+            ));
+        }
+
+        let path = self.path.to_string_lossy();
+        self.run(
+            "chown",
+            &[spec.as_str(), path.as_ref()],
+            "set directory ownership",
+        )
+    }
 }
 
 impl Atom for CreateDirectory {
@@ -24,32 +174,98 @@ impl Atom for CreateDirectory {
     }
 
     fn execute(&self) -> Result<(), String> {
-        if self.path.exists() && self.path.is_dir() {
-            return Ok(());
+        self.ensure_not_file()?;
+
+        if !self.path.is_dir() {
+            if !self.recursive {
+                if let Some(parent) = self.path.parent().filter(|p| !p.is_dir()) {
+                    return Err(format!(
+                        "Parent directory {} does not exist; set recursive: true to create it",
+                        parent.display()
+                    ));
+                }
+            }
+            self.create()?;
         }
 
-        if self.requires_privilege_escalation {
-            let output = Command::new("sudo")
-                .args(["mkdir", "-p", &self.path.to_string_lossy()])
-                .output()
-                .map_err(|e| format!("Failed to create directory: {}", e))?;
-
-            if !output.status.success() {
-                return Err(format!(
-                    "Failed to create directory: {}",
-                    String::from_utf8_lossy(&output.stderr)
-                ));
+        if let Some(mode) = self.mode {
+            if !self.mode_matches() {
+                self.apply_mode(mode)?;
             }
-        } else {
-            fs::create_dir_all(&self.path).map_err(|e| {
-                format!("Failed to create directory {}: {}", self.path.display(), e)
-            })?;
         }
 
-        Ok(())
+        self.apply_ownership()
+    }
+
+    fn check(&self) -> Option<bool> {
+        // Ownership can't be compared without resolving user names, so always apply it
+        if self.owner.is_some() || self.group.is_some() {
+            return None;
+        }
+
+        Some(!(self.path.is_dir() && self.mode_matches()))
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
     }
 
     fn describe(&self) -> String {
-        format!("Create directory {}", self.path.display())
+        let mut description = format!("Create directory {}", self.path.display());
+        if let Some(mode) = self.mode {
+            description.push_str(&format!(" (mode {:o})", mode));
+        }
+        description
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_file_in_the_way_is_reported() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("app");
+        fs::write(&path, "").unwrap();
+
+        let err = CreateDirectory::new(path, false).execute().unwrap_err();
+        assert!(
+            err.ends_with("app exists but is not a directory"),
+            "{}",
+            err
+        );
+    }
+
+    #[test]
+    fn test_missing_parent_needs_recursive() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("config").join("app");
+
+        let flat = CreateDirectory::new(path.clone(), false).with_recursive(false);
+        let err = flat.execute().unwrap_err();
+        assert!(err.contains("set recursive: true"), "{}", err);
+
+        CreateDirectory::new(path.clone(), false).execute().unwrap();
+        assert!(path.is_dir());
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_mode_is_applied_to_existing_directories() {
+        use std::os::unix::fs::PermissionsExt;
+
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("private");
+        fs::create_dir(&path).unwrap();
+        fs::set_permissions(&path, fs::Permissions::from_mode(0o755)).unwrap();
+
+        let atom = CreateDirectory::new(path.clone(), false).with_mode(Some(0o700));
+        assert_eq!(atom.check(), Some(true));
+        atom.execute().unwrap();
+        assert_eq!(atom.check(), Some(false));
+        let mode = fs::metadata(&path).unwrap().permissions().mode();
+        assert_eq!(mode & 0o777, 0o700);
     }
 }
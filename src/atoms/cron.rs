@@ -0,0 +1,508 @@
+use crate::atoms::Atom;
+use crate::atoms::systemd_service::SystemdService;
+use crate::logging::LoggedCommand;
+use std::fs;
+use std::io::Write;
+use std::path::PathBuf;
+use std::process::{Command, Stdio};
+
+/// Where a scheduled job is installed
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum CronBackend {
+    /// A line in the user's crontab, after a `# dhd:<name>` marker
+    Crontab,
+    /// A `dhd-cron-<name>` service and timer unit
+    Systemd,
+}
+
+impl CronBackend {
+    /// The crontab when `crontab` is installed, otherwise a systemd timer on
+    /// systemd hosts
+    pub fn detect() -> Self {
+        if crate::atoms::package::command_exists("crontab")
+            || !std::path::Path::new("/run/systemd/system").exists()
+        {
+            CronBackend::Crontab
+        } else {
+            CronBackend::Systemd
+        }
+    }
+}
+
+/// A scheduled command kept in the crontab or as a systemd timer, keyed by `name`
+///
+/// Re-applying with another schedule or command replaces the job; the rest
+/// of the crontab is left as it is. With `present` unset the job is removed.
+#[derive(Debug, Clone)]
+pub struct Cron {
+    pub name: String,
+    pub schedule: String,
+    pub command: String,
+    /// Whose crontab, or the `User=` of a system timer; the current user's when unset
+    pub user: Option<String>,
+    pub present: bool,
+    pub backend: CronBackend,
+}
+
+impl Cron {
+    pub fn new(
+        name: String,
+        schedule: String,
+        command: String,
+        user: Option<String>,
+        present: bool,
+        backend: CronBackend,
+    ) -> Self {
+        Self {
+            name,
+            schedule,
+            command,
+            user,
+            present,
+            backend,
+        }
+    }
+
+    fn marker(&self) -> String {
+        format!("# dhd:{}", self.name)
+    }
+
+    fn crontab(&self) -> Result<Command, String> {
+        match &self.user {
+            Some(user) => {
+                let mut cmd = crate::privilege::root_command("crontab")?;
+                cmd.args(["-u", user]);
+                Ok(cmd)
+            }
+            None => Ok(crate::target_user::command("crontab")),
+        }
+    }
+
+    /// The current crontab, empty when there is none yet
+    fn read_crontab(&self) -> Result<String, String> {
+        let output = self
+            .crontab()?
+            .arg("-l")
+            .logged_output()
+            .map_err(|e| format!("Failed to run crontab: {}", e))?;
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        if output.status.success() {
+            Ok(String::from_utf8_lossy(&output.stdout).into_owned())
+        } else if stderr.contains("no crontab") {
+            Ok(String::new())
+        } else {
+            Err(format!("Failed to read the crontab: {}", stderr.trim()))
+        }
+    }
+
+    fn write_crontab(&self, content: &str) -> Result<(), String> {
+        let mut child = self
+            .crontab()?
+            .arg("-")
+            .stdin(Stdio::piped())
+            .stdout(Stdio::null())
+            .stderr(Stdio::piped())
+            .spawn()
+            .map_err(|e| format!("Failed to run crontab: {}", e))?;
+        if let Some(mut stdin) = child.stdin.take() {
+            stdin
+                .write_all(content.as_bytes())
+                .map_err(|e| format!("Failed to write the crontab: {}", e))?;
+        }
+        let output = child
+            .wait_with_output()
+            .map_err(|e| format!("Failed to write the crontab: {}", e))?;
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to write the crontab: {}",
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+        Ok(())
+    }
+
+    /// `current` with the job's marker and entry updated, added or removed
+    fn desired_crontab(&self, current: &str) -> String {
+        let marker = self.marker();
+        let entry = format!("{} {}", self.schedule, self.command);
+        let mut lines: Vec<&str> = current.lines().collect();
+
+        let job: Vec<&str> = if self.present {
+            vec![marker.as_str(), entry.as_str()]
+        } else {
+            Vec::new()
+        };
+        match lines.iter().position(|line| *line == marker) {
+            Some(start) => {
+                let end = (start + 2).min(lines.len());
+                lines.splice(start..end, job);
+            }
+            None => lines.extend(job),
+        }
+
+        if lines.is_empty() {
+            String::new()
+        } else {
+            lines.join("\n") + "\n"
+        }
+    }
+
+    fn unit_name(&self) -> String {
+        format!("dhd-cron-{}", self.name)
+    }
+
+    fn service(&self) -> Result<SystemdService, String> {
+        let calendar = to_calendar(&self.schedule)?;
+        let description = format!("dhd cron job {}", self.name);
+        // systemd expands `%` specifiers and `$` variables itself
+        let command = self
+            .command
+            .replace('\\', "\\\\")
+            .replace('"', "\\\"")
+            .replace('%', "%%")
+            .replace('$', "$$");
+
+        let mut unit = format!(
+            "[Unit]\nDescription={}\n\n[Service]\nType=oneshot\nExecStart=/bin/sh -c \"{}\"\n",
+            description, command
+        );
+        if let Some(user) = &self.user {
+            unit.push_str(&format!("User={}\n", user));
+        }
+        let timer = format!(
+            "[Unit]\nDescription={}\n\n[Timer]\nOnCalendar={}\nPersistent=true\n\n[Install]\nWantedBy=timers.target\n",
+            description, calendar
+        );
+
+        let scope = if self.user.is_some() {
+            "system"
+        } else {
+            "user"
+        };
+        Ok(SystemdService::new(
+            format!("{}.service", self.unit_name()),
+            description,
+            String::new(),
+            "oneshot".to_string(),
+            scope.to_string(),
+            None,
+            None,
+        )
+        .with_unit_file(Some(unit), Some(timer))
+        .with_activation(true, true))
+    }
+
+    /// The service and timer files, where `SystemdService` writes them
+    fn unit_files(&self) -> Vec<PathBuf> {
+        let dir = if self.user.is_some() {
+            PathBuf::from("/etc/systemd/system")
+        } else {
+            let home = crate::paths::home_dir()
+                .map(|home| home.display().to_string())
+                .unwrap_or_else(|| String::from("/home/user"));
+            PathBuf::from(format!("{}/.config/systemd/user", home))
+        };
+        vec![
+            dir.join(format!("{}.service", self.unit_name())),
+            dir.join(format!("{}.timer", self.unit_name())),
+        ]
+    }
+
+    fn remove_timer(&self) -> Result<(), String> {
+        let files: Vec<PathBuf> = self
+            .unit_files()
+            .into_iter()
+            .filter(|path| path.exists())
+            .collect();
+        if files.is_empty() {
+            return Ok(());
+        }
+
+        let timer = format!("{}.timer", self.unit_name());
+        let systemctl = |args: &[&str]| -> Result<(), String> {
+            let mut cmd = if self.user.is_none() {
+                let mut cmd = crate::target_user::command("systemctl");
+                cmd.arg("--user");
+                cmd
+            } else {
+                Command::new("systemctl")
+            };
+            let output = cmd
+                .args(args)
+                .logged_output()
+                .map_err(|e| format!("Failed to run systemctl {}: {}", args.join(" "), e))?;
+            if !output.status.success() {
+                return Err(format!(
+                    "systemctl {} failed: {}",
+                    args.join(" "),
+                    String::from_utf8_lossy(&output.stderr).trim()
+                ));
+            }
+            Ok(())
+        };
+
+        systemctl(&["disable", "--now", timer.as_str()])?;
+        for path in files {
+            fs::remove_file(&path)
+                .map_err(|e| format!("Failed to remove {}: {}", path.display(), e))?;
+        }
+        systemctl(&["daemon-reload"])
+    }
+}
+
+impl Atom for Cron {
+    fn name(&self) -> &str {
+        "Cron"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        match self.backend {
+            CronBackend::Crontab => {
+                let current = self.read_crontab()?;
+                let desired = self.desired_crontab(&current);
+                if desired == current {
+                    return Ok(());
+                }
+                self.write_crontab(&desired)
+            }
+            CronBackend::Systemd => {
+                crate::platform::require_linux("cron timers")?;
+                if self.present {
+                    self.service()?.execute()
+                } else {
+                    self.remove_timer()
+                }
+            }
+        }
+    }
+
+    fn check(&self) -> Option<bool> {
+        match self.backend {
+            CronBackend::Crontab => {
+                let current = self.read_crontab().ok()?;
+                Some(self.desired_crontab(&current) != current)
+            }
+            CronBackend::Systemd if self.present => self.service().ok()?.check(),
+            CronBackend::Systemd => Some(self.unit_files().iter().any(|path| path.exists())),
+        }
+    }
+
+    fn describe(&self) -> String {
+        let job = match self.backend {
+            CronBackend::Crontab => format!("cron job '{}'", self.name),
+            CronBackend::Systemd => format!("timer {}.timer", self.unit_name()),
+        };
+        if self.present {
+            format!("Schedule {} ({}): {}", job, self.schedule, self.command)
+        } else {
+            format!("Remove {}", job)
+        }
+    }
+}
+
+/// Check that `schedule` has five cron fields or is one of the `@` shorthands
+pub fn validate_schedule(schedule: &str) -> Result<(), String> {
+    if schedule.starts_with('@') {
+        return match schedule {
+            "@reboot" | "@hourly" | "@daily" | "@midnight" | "@weekly" | "@monthly" | "@yearly"
+            | "@annually" => Ok(()),
+            _ => Err(format!("Unknown cron schedule '{}'", schedule)),
+        };
+    }
+
+    let fields = schedule.split_whitespace().count();
+    if fields != 5 {
+        return Err(format!(
+            "Cron schedule '{}' needs 5 fields (minute hour day month weekday), got {}",
+            schedule, fields
+        ));
+    }
+    Ok(())
+}
+
+/// The systemd `OnCalendar=` expression for a cron schedule
+///
+/// systemd matches a day when both the day of month and the weekday match,
+/// where cron accepts either, so a schedule restricting both is refused.
+fn to_calendar(schedule: &str) -> Result<String, String> {
+    validate_schedule(schedule)?;
+    match schedule {
+        "@reboot" => {
+            return Err("@reboot can't be run by a timer; use the crontab backend".to_string());
+        }
+        "@hourly" => return Ok("hourly".to_string()),
+        "@daily" | "@midnight" => return Ok("daily".to_string()),
+        "@weekly" => return Ok("weekly".to_string()),
+        "@monthly" => return Ok("monthly".to_string()),
+        "@yearly" | "@annually" => return Ok("yearly".to_string()),
+        _ => {}
+    }
+
+    let fields: Vec<&str> = schedule.split_whitespace().collect();
+    let [minute, hour, day, month, weekday] = fields[..] else {
+        unreachable!("validated above");
+    };
+    if day != "*" && weekday != "*" {
+        return Err(format!(
+            "Cron schedule '{}' restricts both the day and the weekday, which a timer can't express",
+            schedule
+        ));
+    }
+
+    let minute = calendar_field(minute, 0, schedule)?;
+    let hour = calendar_field(hour, 0, schedule)?;
+    let day = calendar_field(day, 1, schedule)?;
+    let month = calendar_field(month, 1, schedule)?;
+    let date = format!("*-{}-{} {}:{}:00", month, day, hour, minute);
+    if weekday == "*" {
+        return Ok(date);
+    }
+
+    Ok(format!("{} {}", weekdays(weekday, schedule)?, date))
+}
+
+/// One numeric cron field in calendar syntax: `*/15` becomes `0/15`, `1-5` becomes `1..5`
+fn calendar_field(field: &str, first: u32, schedule: &str) -> Result<String, String> {
+    let unsupported = || {
+        format!(
+            "Can't turn '{}' of cron schedule '{}' into a timer",
+            field, schedule
+        )
+    };
+    let parts: Result<Vec<String>, String> = field
+        .split(',')
+        .map(|part| {
+            if let Some(step) = part.strip_prefix("*/") {
+                step.parse::<u32>().map_err(|_| unsupported())?;
+                return Ok(format!("{}/{}", first, step));
+            }
+            if part == "*" {
+                return Ok("*".to_string());
+            }
+            let (start, end) = part.split_once('-').unwrap_or((part, ""));
+            start.parse::<u32>().map_err(|_| unsupported())?;
+            if end.is_empty() {
+                return Ok(part.to_string());
+            }
+            end.parse::<u32>().map_err(|_| unsupported())?;
+            Ok(format!("{}..{}", start, end))
+        })
+        .collect();
+    Ok(parts?.join(","))
+}
+
+/// A cron weekday field as systemd weekday names, e.g. `1-5` as `Mon..Fri`
+fn weekdays(field: &str, schedule: &str) -> Result<String, String> {
+    const NAMES: [&str; 8] = ["Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"];
+    let name = |day: &str| {
+        let day = day.to_ascii_lowercase();
+        NAMES
+            .iter()
+            .position(|name| name.to_ascii_lowercase() == day)
+            .or_else(|| day.parse::<usize>().ok().filter(|day| *day < NAMES.len()))
+            .map(|index| NAMES[index])
+            .ok_or_else(|| {
+                format!(
+                    "Can't turn weekday '{}' of cron schedule '{}' into a timer",
+                    field, schedule
+                )
+            })
+    };
+
+    let parts: Result<Vec<String>, String> = field
+        .split(',')
+        .map(|part| match part.split_once('-') {
+            Some((start, end)) => Ok(format!("{}..{}", name(start)?, name(end)?)),
+            None => name(part).map(String::from),
+        })
+        .collect();
+    Ok(parts?.join(","))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn job(present: bool) -> Cron {
+        Cron::new(
+            "backup".to_string(),
+            "0 3 * * *".to_string(),
+            "restic backup ~".to_string(),
+            None,
+            present,
+            CronBackend::Crontab,
+        )
+    }
+
+    #[test]
+    fn test_crontab_entry_is_added_updated_and_removed() {
+        let other = "MAILTO=me@example.com\n*/5 * * * * uptime >> /tmp/load\n";
+
+        let added = job(true).desired_crontab(other);
+        assert_eq!(
+            added,
+            format!("{}# dhd:backup\n0 3 * * * restic backup ~\n", other)
+        );
+        assert_eq!(job(true).desired_crontab(&added), added);
+
+        let mut moved = job(true);
+        moved.schedule = "30 4 * * 0".to_string();
+        assert_eq!(
+            moved.desired_crontab(&added),
+            format!("{}# dhd:backup\n30 4 * * 0 restic backup ~\n", other)
+        );
+
+        assert_eq!(job(false).desired_crontab(&added), other);
+        assert_eq!(job(false).desired_crontab(""), "");
+    }
+
+    #[test]
+    fn test_validate_schedule() {
+        assert!(validate_schedule("0 3 * * *").is_ok());
+        assert!(validate_schedule("@daily").is_ok());
+        assert!(validate_schedule("@often").is_err());
+        assert!(validate_schedule("0 3 * *").is_err());
+    }
+
+    #[test]
+    fn test_schedule_to_calendar() {
+        assert_eq!(to_calendar("0 3 * * *").unwrap(), "*-*-* 3:0:00");
+        assert_eq!(to_calendar("*/15 * * * *").unwrap(), "*-*-* *:0/15:00");
+        assert_eq!(
+            to_calendar("30 9 * * 1-5").unwrap(),
+            "Mon..Fri *-*-* 9:30:00"
+        );
+        assert_eq!(to_calendar("0 0 1,15 * *").unwrap(), "*-*-1,15 0:0:00");
+        assert_eq!(to_calendar("0 12 * * sun").unwrap(), "Sun *-*-* 12:0:00");
+        assert_eq!(to_calendar("@weekly").unwrap(), "weekly");
+        assert!(to_calendar("@reboot").is_err());
+        assert!(to_calendar("0 0 1 * 1").is_err());
+        assert!(to_calendar("0-30/5 * * * *").is_err());
+    }
+
+    #[test]
+    fn test_timer_units() {
+        let mut cron = job(true);
+        cron.backend = CronBackend::Systemd;
+        cron.command = "echo \"50%\" $HOME".to_string();
+        assert_eq!(
+            cron.describe(),
+            "Schedule timer dhd-cron-backup.timer (0 3 * * *): echo \"50%\" $HOME"
+        );
+
+        let service = cron.service().unwrap();
+        assert_eq!(service.scope, "user");
+        assert!(
+            service
+                .unit
+                .unwrap()
+                .contains("ExecStart=/bin/sh -c \"echo \\\"50%%\\\" $$HOME\"\n")
+        );
+        assert!(service.timer.unwrap().contains("OnCalendar=*-*-* 3:0:00\n"));
+
+        cron.user = Some("postgres".to_string());
+        let service = cron.service().unwrap();
+        assert_eq!(service.scope, "system");
+        assert!(service.unit.unwrap().contains("User=postgres\n"));
+    }
+}
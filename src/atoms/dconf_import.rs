@@ -20,6 +20,8 @@ impl Atom for DconfImportAtom {
     }
 
     fn execute(&self) -> Result<(), String> {
+        crate::platform::require_linux("dconfImport")?;
+
         // Check if dconf is installed
         let check = Command::new("which")
             .arg("dconf")
@@ -39,7 +41,7 @@ impl Atom for DconfImportAtom {
         }
 
         // Import the dconf settings
-        let output = Command::new("dconf")
+        let output = crate::target_user::command("dconf")
             .arg("load")
             .arg(&self.path)
             .stdin(
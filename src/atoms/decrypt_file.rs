@@ -0,0 +1,221 @@
+use crate::atom::Destruction;
+use crate::atoms::Atom;
+use crate::secrets::age::AgeProvider;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// Mode for decrypted files unless one is given: readable by the owner only
+const DEFAULT_MODE: u32 = 0o600;
+
+#[derive(Debug, Clone)]
+pub struct DecryptFile {
+    pub source: PathBuf,
+    pub target: PathBuf,
+    pub mode: Option<u32>,
+    pub identity: Option<PathBuf>,
+}
+
+impl DecryptFile {
+    pub fn new(
+        source: PathBuf,
+        target: PathBuf,
+        mode: Option<u32>,
+        identity: Option<PathBuf>,
+    ) -> Self {
+        Self {
+            source,
+            target,
+            mode,
+            identity,
+        }
+    }
+
+    fn mode(&self) -> u32 {
+        self.mode.unwrap_or(DEFAULT_MODE)
+    }
+
+    /// Decrypt the source in memory; the plaintext never touches a temp file
+    fn decrypt(&self) -> Result<Vec<u8>, String> {
+        let plaintext = AgeProvider::new(self.identity.clone())
+            .decrypt_blocking(&self.source)
+            .map_err(|e| format!("Failed to decrypt {}: {}", self.source.display(), e))?;
+
+        // Keep the plaintext out of error messages and logs
+        if let Ok(text) = std::str::from_utf8(&plaintext) {
+            crate::secrets::reveal(text.trim_end());
+        }
+        Ok(plaintext)
+    }
+
+    fn content_matches(&self, plaintext: &[u8]) -> bool {
+        fs::read(&self.target).is_ok_and(|current| current == plaintext)
+    }
+
+    fn mode_matches(&self) -> bool {
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            fs::metadata(&self.target)
+                .map(|metadata| metadata.permissions().mode() & 0o7777 == self.mode())
+                .unwrap_or(false)
+        }
+
+        #[cfg(not(unix))]
+        {
+            true
+        }
+    }
+
+    fn apply_mode(&self) -> Result<(), String> {
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            fs::set_permissions(&self.target, fs::Permissions::from_mode(self.mode())).map_err(
+                |e| {
+                    format!(
+                        "Failed to set mode {:o} on {}: {}",
+                        self.mode(),
+                        self.target.display(),
+                        e
+                    )
+                },
+            )?;
+        }
+
+        Ok(())
+    }
+}
+
+/// Write `content` to `target` through a temp file in the same directory
+///
+/// The temp file is created owner-only, so the plaintext is never readable by
+/// other users, and renamed over the target once complete.
+fn write_private(target: &Path, content: &[u8], mode: u32) -> Result<(), String> {
+    crate::atoms::atomic_write::write(target, content, Some(mode), false)
+        .map_err(|e| format!("Failed to write {}: {}", target.display(), e))
+}
+
+impl Atom for DecryptFile {
+    fn name(&self) -> &str {
+        "DecryptFile"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        if !self.source.exists() {
+            return Err(format!(
+                "Encrypted file {} does not exist",
+                self.source.display()
+            ));
+        }
+
+        let plaintext = self.decrypt()?;
+
+        // Only rewrite the target when the decrypted content differs
+        if !self.content_matches(&plaintext) {
+            if let Some(parent) = self.target.parent() {
+                crate::target_user::create_dir_all(parent)
+                    .map_err(|e| format!("Failed to create parent directory: {}", e))?;
+            }
+
+            let previous = crate::state::preserve(&self.target);
+            write_private(&self.target, &plaintext, self.mode())?;
+            if let Some(previous) = previous {
+                crate::state::record(crate::state::Change::File {
+                    path: self.target.clone(),
+                    previous,
+                    escalate: false,
+                    written: None,
+                });
+            }
+        }
+
+        if !self.mode_matches() {
+            self.apply_mode()?;
+        }
+
+        Ok(())
+    }
+
+    fn check(&self) -> Option<bool> {
+        // Decryption errors are reported when the atom executes
+        let Ok(plaintext) = self.decrypt() else {
+            return Some(true);
+        };
+        Some(!(self.content_matches(&plaintext) && self.mode_matches()))
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        // The plaintext isn't exposed as a file change, so compare it here
+        let plaintext = self.decrypt().ok()?;
+        (self.target.exists() && !self.content_matches(&plaintext))
+            .then(|| Destruction::Overwrite(self.target.clone()))
+    }
+
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        Ok(vec![self.target.clone()])
+    }
+
+    fn describe(&self) -> String {
+        format!(
+            "Decrypt {} -> {} (mode {:o})",
+            self.source.display(),
+            self.target.display(),
+            self.mode()
+        )
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    #[cfg(unix)]
+    fn test_write_private_sets_mode_and_replaces_target() {
+        use std::os::unix::fs::PermissionsExt;
+
+        let temp_dir = TempDir::new().unwrap();
+        let target = temp_dir.path().join("id_ed25519");
+        fs::write(&target, "old").unwrap();
+
+        write_private(&target, b"new key", 0o600).unwrap();
+
+        assert_eq!(fs::read_to_string(&target).unwrap(), "new key");
+        let mode = fs::metadata(&target).unwrap().permissions().mode() & 0o7777;
+        assert_eq!(mode, 0o600);
+        // Nothing is left behind next to the target
+        assert_eq!(fs::read_dir(temp_dir.path()).unwrap().count(), 1);
+    }
+
+    #[test]
+    fn test_missing_source_fails() {
+        let temp_dir = TempDir::new().unwrap();
+        let atom = DecryptFile::new(
+            temp_dir.path().join("missing.age"),
+            temp_dir.path().join("plain"),
+            None,
+            Some(temp_dir.path().join("keys.txt")),
+        );
+
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("missing.age"));
+        assert_eq!(atom.check(), Some(true));
+        assert!(!temp_dir.path().join("plain").exists());
+    }
+
+    #[test]
+    fn test_describe_includes_mode() {
+        let atom = DecryptFile::new(
+            PathBuf::from("/dotfiles/ssh/config.age"),
+            PathBuf::from("/home/user/.ssh/config"),
+            Some(0o644),
+            None,
+        );
+
+        assert_eq!(
+            atom.describe(),
+            "Decrypt /dotfiles/ssh/config.age -> /home/user/.ssh/config (mode 644)"
+        );
+    }
+}
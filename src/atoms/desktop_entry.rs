@@ -0,0 +1,202 @@
+use crate::atoms::{Atom, atomic_write};
+use crate::diff::FileChange;
+use crate::logging::LoggedCommand;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// First line of the entries DHD writes, so removing one never deletes a
+/// launcher that something else installed
+pub const HEADER: &str = "# Written by dhd";
+
+/// Writes a `.desktop` file, or removes one DHD wrote, and refreshes the
+/// desktop database of its directory afterwards
+#[derive(Debug, Clone)]
+pub struct DesktopEntry {
+    pub path: PathBuf,
+    /// The entry's content, starting with [`HEADER`], or `None` to remove it
+    pub content: Option<String>,
+}
+
+impl DesktopEntry {
+    pub fn new(path: PathBuf, content: Option<String>) -> Self {
+        Self { path, content }
+    }
+
+    /// Whether the file at `path` is an entry DHD wrote
+    fn written_by_dhd(&self) -> bool {
+        fs::read_to_string(&self.path).is_ok_and(|content| content.starts_with(HEADER))
+    }
+
+    fn content_change(&self, content: &str) -> FileChange {
+        FileChange {
+            target: self.path.clone(),
+            current: fs::read(&self.path).ok(),
+            desired: content.as_bytes().to_vec(),
+        }
+    }
+
+    fn pending(&self) -> bool {
+        match &self.content {
+            Some(content) => self.content_change(content).is_changed(),
+            None => self.written_by_dhd(),
+        }
+    }
+
+    /// Let menus pick the change up, where `update-desktop-database` is
+    /// installed; without it they notice on their own next scan
+    fn refresh(&self) -> Result<(), String> {
+        let dir = self.path.parent().unwrap_or(Path::new("."));
+        if !crate::atoms::package::command_exists("update-desktop-database") {
+            log::debug!(
+                "update-desktop-database isn't installed, not refreshing {}",
+                dir.display()
+            );
+            return Ok(());
+        }
+
+        let output = crate::target_user::command("update-desktop-database")
+            .arg(dir)
+            .logged_output()
+            .map_err(|e| format!("Failed to run update-desktop-database: {}", e))?;
+        if !output.status.success() {
+            return Err(format!(
+                "update-desktop-database {} failed: {}",
+                dir.display(),
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+        Ok(())
+    }
+
+    fn write(&self, content: &str) -> Result<(), String> {
+        if let Some(parent) = self.path.parent() {
+            if !parent.exists() {
+                crate::target_user::create_dir_all(parent)
+                    .map_err(|e| format!("Failed to create {}: {}", parent.display(), e))?;
+            }
+        }
+
+        let previous = crate::state::preserve(&self.path);
+        atomic_write::write(&self.path, content.as_bytes(), Some(0o644), false)
+            .map_err(|e| format!("Failed to write {}: {}", self.path.display(), e))?;
+        if let Some(previous) = previous {
+            crate::state::record(crate::state::Change::File {
+                path: self.path.clone(),
+                previous,
+                escalate: false,
+                written: None,
+            });
+        }
+        Ok(())
+    }
+
+    fn remove(&self) -> Result<(), String> {
+        let previous = crate::state::preserve(&self.path);
+        fs::remove_file(&self.path)
+            .map_err(|e| format!("Failed to remove {}: {}", self.path.display(), e))?;
+        if let Some(previous) = previous {
+            crate::state::record(crate::state::Change::File {
+                path: self.path.clone(),
+                previous,
+                escalate: false,
+                written: None,
+            });
+        }
+        Ok(())
+    }
+}
+
+impl Atom for DesktopEntry {
+    fn name(&self) -> &str {
+        "DesktopEntry"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        self.execute_changed().map(|_| ())
+    }
+
+    fn execute_changed(&self) -> Result<bool, String> {
+        if !self.pending() {
+            if self.content.is_none() && self.path.exists() {
+                log::info!(
+                    "{} wasn't written by DHD, leaving it alone",
+                    self.path.display()
+                );
+            }
+            return Ok(false);
+        }
+
+        match &self.content {
+            Some(content) => self.write(content)?,
+            None => self.remove()?,
+        }
+        self.refresh()?;
+        Ok(true)
+    }
+
+    fn check(&self) -> Option<bool> {
+        Some(self.pending())
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
+    }
+
+    fn file_change(&self) -> Option<Result<FileChange, String>> {
+        let content = self.content.as_ref()?;
+        Some(Ok(self.content_change(content)))
+    }
+
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        Ok(vec![self.path.clone()])
+    }
+
+    fn describe(&self) -> String {
+        match &self.content {
+            Some(_) => format!("Write desktop entry {}", self.path.display()),
+            None => format!("Remove desktop entry {}", self.path.display()),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_desktop_entry_writes_and_removes_its_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("applications/obsidian.desktop");
+        let content = format!("{}\n[Desktop Entry]\nName=Obsidian\n", HEADER);
+
+        let atom = DesktopEntry::new(path.clone(), Some(content.clone()));
+        assert_eq!(atom.check(), Some(true));
+        assert_eq!(atom.execute_changed(), Ok(true));
+        assert_eq!(fs::read_to_string(&path).unwrap(), content);
+        assert_eq!(atom.check(), Some(false));
+        assert_eq!(atom.execute_changed(), Ok(false));
+
+        let removal = DesktopEntry::new(path.clone(), None);
+        assert_eq!(removal.check(), Some(true));
+        assert_eq!(removal.execute_changed(), Ok(true));
+        assert!(!path.exists());
+        assert_eq!(removal.check(), Some(false));
+    }
+
+    #[test]
+    fn test_desktop_entry_leaves_other_launchers() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("firefox.desktop");
+        fs::write(&path, "[Desktop Entry]\nName=Firefox\n").unwrap();
+
+        let removal = DesktopEntry::new(path.clone(), None);
+        assert_eq!(removal.check(), Some(false));
+        assert_eq!(removal.execute_changed(), Ok(false));
+        assert!(path.exists());
+        assert_eq!(
+            removal.describe(),
+            format!("Remove desktop entry {}", path.display())
+        );
+    }
+}
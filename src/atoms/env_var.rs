@@ -0,0 +1,592 @@
+use crate::atom::Destruction;
+use crate::atoms::Atom;
+use crate::diff::FileChange;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::str::FromStr;
+use std::sync::Mutex;
+
+/// Env files written by dhd, relative to the home directory
+pub const ENV_FILE: &str = ".config/dhd/env.sh";
+pub const FISH_ENV_FILE: &str = ".config/dhd/env.fish";
+
+/// Markers around the block that sources the env file from a shell rc file
+pub const BLOCK_BEGIN: &str = "# >>> dhd env >>>";
+pub const BLOCK_END: &str = "# <<< dhd env <<<";
+
+/// Env files and rc files are shared by every module, so edits must not overlap
+static ENV_FILES: Mutex<()> = Mutex::new(());
+
+/// A shell whose rc file sources the env file
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Shell {
+    Bash,
+    Zsh,
+    Fish,
+}
+
+impl Shell {
+    /// The rc file of the shell, relative to the home directory
+    pub fn rc_file(&self) -> &'static str {
+        match self {
+            Shell::Bash => ".bashrc",
+            Shell::Zsh => ".zshrc",
+            Shell::Fish => ".config/fish/config.fish",
+        }
+    }
+
+    pub fn is_fish(&self) -> bool {
+        *self == Shell::Fish
+    }
+}
+
+impl FromStr for Shell {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "bash" => Ok(Shell::Bash),
+            "zsh" => Ok(Shell::Zsh),
+            "fish" => Ok(Shell::Fish),
+            _ => Err(format!("Unknown shell '{}': expected bash, zsh or fish", s)),
+        }
+    }
+}
+
+/// The shells of an action's `shells`, warning about unknown ones (default:
+/// bash and zsh)
+pub fn parse_shells(shells: Option<&[String]>) -> Vec<Shell> {
+    match shells {
+        Some(shells) => shells
+            .iter()
+            .filter_map(|shell| match shell.parse() {
+                Ok(shell) => Some(shell),
+                Err(e) => {
+                    log::warn!("{}", e);
+                    None
+                }
+            })
+            .collect(),
+        None => vec![Shell::Bash, Shell::Zsh],
+    }
+}
+
+/// A line managed in an env file
+#[derive(Debug, Clone, PartialEq)]
+pub enum EnvEntry {
+    Var { name: String, value: String },
+    PathPrepend(String),
+}
+
+impl EnvEntry {
+    /// The entry as a line of POSIX shell, or of fish
+    fn line(&self, fish: bool) -> String {
+        match (self, fish) {
+            (EnvEntry::Var { name, value }, false) => {
+                format!("export {}=\"{}\"", name, quote(value))
+            }
+            (EnvEntry::Var { name, value }, true) => {
+                format!("set -gx {} \"{}\"", name, quote(value))
+            }
+            // Re-sourcing the file must not add the directory again
+            (EnvEntry::PathPrepend(dir), false) => {
+                let dir = quote(&home_relative(dir));
+                format!(
+                    "case \":$PATH:\" in *\":{dir}:\"*) ;; *) export PATH=\"{dir}:$PATH\" ;; esac"
+                )
+            }
+            (EnvEntry::PathPrepend(dir), true) => {
+                let dir = quote(&home_relative(dir));
+                format!("contains -- \"{dir}\" $PATH; or set -gx PATH \"{dir}\" $PATH")
+            }
+        }
+    }
+
+    /// Whether `line` sets what this entry sets, so it gets replaced
+    fn matches(&self, line: &str, fish: bool) -> bool {
+        match self {
+            EnvEntry::Var { name, .. } if fish => line.starts_with(&format!("set -gx {} ", name)),
+            EnvEntry::Var { name, .. } => line.starts_with(&format!("export {}=", name)),
+            EnvEntry::PathPrepend(_) => line == self.line(fish),
+        }
+    }
+}
+
+/// Escape a value for a double-quoted string, leaving `$VAR` references working
+fn quote(value: &str) -> String {
+    let mut quoted = String::with_capacity(value.len());
+    for c in value.chars() {
+        if matches!(c, '"' | '\\' | '`') {
+            quoted.push('\\');
+        }
+        quoted.push(c);
+    }
+    quoted
+}
+
+/// Write `~/` as `$HOME/`, which the shell expands inside quotes
+fn home_relative(path: &str) -> String {
+    match path.strip_prefix("~/") {
+        Some(rest) => format!("$HOME/{}", rest),
+        None => path.to_string(),
+    }
+}
+
+/// Write `content` to `path`, recording the change for rollback
+fn write_recorded(path: &Path, content: &str) -> Result<(), String> {
+    if let Some(parent) = path.parent() {
+        crate::target_user::create_dir_all(parent)
+            .map_err(|e| format!("Failed to create {}: {}", parent.display(), e))?;
+    }
+
+    let previous = crate::state::preserve(path);
+    crate::atoms::atomic_write::write(path, content.as_bytes(), None, false)
+        .map_err(|e| format!("Failed to write {}: {}", path.display(), e))?;
+    if let Some(previous) = previous {
+        crate::state::record(crate::state::Change::File {
+            path: path.to_path_buf(),
+            previous,
+            escalate: false,
+            written: None,
+        });
+    }
+    Ok(())
+}
+
+/// `content` without any of the blocks that source DHD-managed files, the
+/// ones between `# >>> dhd <name> >>>` and `# <<< dhd <name> <<<`
+pub fn unwired(content: &str) -> String {
+    const BEGIN: &str = "# >>> dhd ";
+    let mut unwired = String::with_capacity(content.len());
+    let mut rest = content;
+    while let Some(start) = rest.find(BEGIN) {
+        let line_end = rest[start..]
+            .find('\n')
+            .map_or(rest.len(), |end| start + end);
+        let end = rest[start..line_end]
+            .strip_prefix(BEGIN)
+            .and_then(|line| line.strip_suffix(" >>>"))
+            .map(|name| format!("# <<< dhd {} <<<", name))
+            .and_then(|end| {
+                rest[start..]
+                    .find(&end)
+                    .map(|found| start + found + end.len())
+            });
+        match end {
+            Some(mut end) => {
+                if rest[end..].starts_with('\n') {
+                    end += 1;
+                }
+                unwired.push_str(&rest[..start]);
+                rest = &rest[end..];
+            }
+            // Not a block, or one without its end marker: left as it is
+            None => {
+                unwired.push_str(&rest[..line_end]);
+                rest = &rest[line_end..];
+            }
+        }
+    }
+    unwired.push_str(rest);
+    unwired
+}
+
+/// Remove the blocks sourcing DHD-managed files from a shell's rc file,
+/// returning whether it had any
+pub fn unwire(rc_file: &Path, dry_run: bool) -> Result<bool, String> {
+    let _lock = ENV_FILES.lock().unwrap_or_else(|e| e.into_inner());
+    let content = match fs::read_to_string(rc_file) {
+        Ok(content) => content,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(false),
+        Err(e) => return Err(format!("Failed to read {}: {}", rc_file.display(), e)),
+    };
+    let unwired = unwired(&content);
+    if unwired == content {
+        return Ok(false);
+    }
+    if !dry_run {
+        write_recorded(rc_file, &unwired)?;
+    }
+    Ok(true)
+}
+
+/// Set a variable or PATH entry in a DHD-managed env file, one line per entry
+#[derive(Debug, Clone)]
+pub struct EnvFileEntry {
+    pub path: PathBuf,
+    pub entry: EnvEntry,
+    /// Write fish syntax instead of POSIX shell
+    pub fish: bool,
+}
+
+impl EnvFileEntry {
+    pub fn new(path: PathBuf, entry: EnvEntry, fish: bool) -> Self {
+        Self { path, entry, fish }
+    }
+
+    fn desired(&self, current: &str) -> String {
+        let line = self.entry.line(self.fish);
+        let mut lines: Vec<String> = Vec::new();
+        let mut found = false;
+        for existing in current.lines() {
+            if self.entry.matches(existing, self.fish) {
+                // Keep the first match only, so duplicates are cleaned up
+                if !found {
+                    lines.push(line.clone());
+                    found = true;
+                }
+            } else {
+                lines.push(existing.to_string());
+            }
+        }
+        if !found {
+            if lines.is_empty() {
+                lines.push("# Managed by dhd; changes are overwritten on apply".to_string());
+            }
+            lines.push(line);
+        }
+        lines.join("\n") + "\n"
+    }
+
+    fn change(&self) -> FileChange {
+        let current = fs::read(&self.path).ok();
+        let text = current
+            .as_deref()
+            .map(String::from_utf8_lossy)
+            .unwrap_or_default();
+        FileChange {
+            target: self.path.clone(),
+            desired: self.desired(&text).into_bytes(),
+            current,
+        }
+    }
+}
+
+impl Atom for EnvFileEntry {
+    fn name(&self) -> &str {
+        "EnvFileEntry"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        let _lock = ENV_FILES.lock().unwrap_or_else(|e| e.into_inner());
+        let change = self.change();
+        if !change.is_changed() {
+            return Ok(());
+        }
+        write_recorded(&self.path, &String::from_utf8_lossy(&change.desired))
+    }
+
+    fn check(&self) -> Option<bool> {
+        Some(self.change().is_changed())
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
+    }
+
+    fn file_change(&self) -> Option<Result<FileChange, String>> {
+        Some(Ok(self.change()))
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        // Only the entry's own line is rewritten
+        None
+    }
+
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        Ok(vec![self.path.clone()])
+    }
+
+    fn describe(&self) -> String {
+        match &self.entry {
+            EnvEntry::Var { name, value } => {
+                format!("Set {}={} in {}", name, value, self.path.display())
+            }
+            EnvEntry::PathPrepend(dir) => {
+                format!("Prepend {} to PATH in {}", dir, self.path.display())
+            }
+        }
+    }
+}
+
+/// Source an env file from a shell rc file, in a block between markers
+#[derive(Debug, Clone)]
+pub struct SourceEnvFile {
+    pub rc_file: PathBuf,
+    pub env_file: PathBuf,
+    pub fish: bool,
+    /// Name in the markers, `env` for the env file of `envVar`
+    pub marker: String,
+    /// Remove the block instead of keeping it up to date
+    pub absent: bool,
+}
+
+impl SourceEnvFile {
+    pub fn new(rc_file: PathBuf, env_file: PathBuf, fish: bool) -> Self {
+        Self {
+            rc_file,
+            env_file,
+            fish,
+            marker: "env".to_string(),
+            absent: false,
+        }
+    }
+
+    /// Mark the block `# >>> dhd <marker> >>>`, so several files can be sourced
+    pub fn with_marker(mut self, marker: &str) -> Self {
+        self.marker = marker.to_string();
+        self
+    }
+
+    /// Remove the block from the rc file instead of adding it
+    pub fn removed(mut self) -> Self {
+        self.absent = true;
+        self
+    }
+
+    fn markers(&self) -> (String, String) {
+        (
+            format!("# >>> dhd {} >>>", self.marker),
+            format!("# <<< dhd {} <<<", self.marker),
+        )
+    }
+
+    fn block(&self) -> String {
+        let (begin, end) = self.markers();
+        let env_file = quote(&self.env_file.to_string_lossy());
+        let source = if self.fish {
+            format!("test -f \"{0}\"; and source \"{0}\"", env_file)
+        } else {
+            format!("[ -f \"{0}\" ] && . \"{0}\"", env_file)
+        };
+        format!("{}\n{}\n{}", begin, source, end)
+    }
+
+    /// `current` with the block replaced, or appended if it isn't there yet,
+    /// or without it if absent
+    fn desired(&self, current: &str) -> String {
+        let (begin, end) = self.markers();
+        if let Some(start) = current.find(&begin) {
+            if let Some(found) = current[start..].find(&end) {
+                let mut end = start + found + end.len();
+                if self.absent {
+                    // The block's own line break goes with it
+                    if current[end..].starts_with('\n') {
+                        end += 1;
+                    }
+                    return format!("{}{}", &current[..start], &current[end..]);
+                }
+                return format!("{}{}{}", &current[..start], self.block(), &current[end..]);
+            }
+        }
+        if self.absent {
+            return current.to_string();
+        }
+
+        let mut content = current.to_string();
+        if !content.is_empty() && !content.ends_with('\n') {
+            content.push('\n');
+        }
+        content.push_str(&self.block());
+        content.push('\n');
+        content
+    }
+
+    fn change(&self) -> FileChange {
+        let current = fs::read(&self.rc_file).ok();
+        let text = current
+            .as_deref()
+            .map(String::from_utf8_lossy)
+            .unwrap_or_default();
+        FileChange {
+            target: self.rc_file.clone(),
+            desired: self.desired(&text).into_bytes(),
+            current,
+        }
+    }
+
+    /// Whether there's no rc file to remove the block from
+    fn nothing_to_remove(&self) -> bool {
+        self.absent && fs::symlink_metadata(&self.rc_file).is_err()
+    }
+}
+
+impl Atom for SourceEnvFile {
+    fn name(&self) -> &str {
+        "SourceEnvFile"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        let _lock = ENV_FILES.lock().unwrap_or_else(|e| e.into_inner());
+        if self.nothing_to_remove() {
+            return Ok(());
+        }
+        let change = self.change();
+        if !change.is_changed() {
+            return Ok(());
+        }
+        write_recorded(&self.rc_file, &String::from_utf8_lossy(&change.desired))
+    }
+
+    fn check(&self) -> Option<bool> {
+        Some(!self.nothing_to_remove() && self.change().is_changed())
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
+    }
+
+    fn file_change(&self) -> Option<Result<FileChange, String>> {
+        if self.nothing_to_remove() {
+            return None;
+        }
+        Some(Ok(self.change()))
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        // Everything outside the markers is left as it is
+        None
+    }
+
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        Ok(vec![self.rc_file.clone()])
+    }
+
+    fn describe(&self) -> String {
+        let verb = if self.absent {
+            "Stop sourcing"
+        } else {
+            "Source"
+        };
+        format!(
+            "{} {} from {}",
+            verb,
+            self.env_file.display(),
+            self.rc_file.display()
+        )
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn var(name: &str, value: &str) -> EnvEntry {
+        EnvEntry::Var {
+            name: name.to_string(),
+            value: value.to_string(),
+        }
+    }
+
+    #[test]
+    fn test_entries_are_replaced_in_place() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("env.sh");
+
+        let editor = EnvFileEntry::new(path.clone(), var("EDITOR", "vim"), false);
+        let bin = EnvFileEntry::new(
+            path.clone(),
+            EnvEntry::PathPrepend("~/.local/bin".to_string()),
+            false,
+        );
+        editor.execute().unwrap();
+        bin.execute().unwrap();
+        assert_eq!(bin.check(), Some(false));
+
+        EnvFileEntry::new(path.clone(), var("EDITOR", "nvim"), false)
+            .execute()
+            .unwrap();
+        bin.execute().unwrap();
+
+        let content = fs::read_to_string(&path).unwrap();
+        let lines: Vec<&str> = content.lines().skip(1).collect();
+        assert_eq!(
+            lines,
+            vec![
+                "export EDITOR=\"nvim\"",
+                "case \":$PATH:\" in *\":$HOME/.local/bin:\"*) ;; *) export PATH=\"$HOME/.local/bin:$PATH\" ;; esac",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_fish_syntax_and_quoting() {
+        assert_eq!(
+            var("GREETING", "say \"hi\"").line(true),
+            "set -gx GREETING \"say \\\"hi\\\"\""
+        );
+        assert_eq!(
+            EnvEntry::PathPrepend("/opt/bin".to_string()).line(true),
+            "contains -- \"/opt/bin\" $PATH; or set -gx PATH \"/opt/bin\" $PATH"
+        );
+    }
+
+    #[test]
+    fn test_source_block_is_added_once_and_updated() {
+        let temp_dir = TempDir::new().unwrap();
+        let rc_file = temp_dir.path().join(".zshrc");
+        fs::write(&rc_file, "alias ll='ls -l'").unwrap();
+
+        let atom = SourceEnvFile::new(rc_file.clone(), PathBuf::from("/home/me/env.sh"), false);
+        atom.execute().unwrap();
+        atom.execute().unwrap();
+        assert_eq!(atom.check(), Some(false));
+        assert_eq!(
+            fs::read_to_string(&rc_file).unwrap(),
+            format!(
+                "alias ll='ls -l'\n{}\n[ -f \"/home/me/env.sh\" ] && . \"/home/me/env.sh\"\n{}\n",
+                BLOCK_BEGIN, BLOCK_END
+            )
+        );
+
+        let moved = SourceEnvFile::new(rc_file.clone(), PathBuf::from("/srv/env.sh"), false);
+        moved.execute().unwrap();
+        let content = fs::read_to_string(&rc_file).unwrap();
+        assert_eq!(content.matches(BLOCK_BEGIN).count(), 1);
+        assert!(content.contains(". \"/srv/env.sh\""));
+        assert!(content.starts_with("alias ll='ls -l'\n"));
+
+        moved.clone().removed().execute().unwrap();
+        assert_eq!(fs::read_to_string(&rc_file).unwrap(), "alias ll='ls -l'\n");
+        assert_eq!(moved.removed().check(), Some(false));
+
+        let missing =
+            SourceEnvFile::new(temp_dir.path().join(".bashrc"), PathBuf::from("/x"), false);
+        assert_eq!(missing.removed().check(), Some(false));
+    }
+
+    #[test]
+    fn test_unwire_removes_every_dhd_block() {
+        let temp_dir = TempDir::new().unwrap();
+        let rc_file = temp_dir.path().join(".bashrc");
+        fs::write(&rc_file, "export A=1\n").unwrap();
+
+        SourceEnvFile::new(rc_file.clone(), PathBuf::from("/home/me/env.sh"), false)
+            .execute()
+            .unwrap();
+        let aliases =
+            SourceEnvFile::new(rc_file.clone(), PathBuf::from("/home/me/aliases.sh"), false)
+                .with_marker("source ~/aliases.sh");
+        aliases.execute().unwrap();
+        fs::write(
+            &rc_file,
+            fs::read_to_string(&rc_file).unwrap() + "# >>> dhd not a block\nalias l=ls\n",
+        )
+        .unwrap();
+        assert!(
+            fs::read_to_string(&rc_file)
+                .unwrap()
+                .contains("# >>> dhd source ~/aliases.sh >>>")
+        );
+
+        assert!(unwire(&rc_file, true).unwrap());
+        assert!(unwire(&rc_file, false).unwrap());
+        assert_eq!(
+            fs::read_to_string(&rc_file).unwrap(),
+            "export A=1\n# >>> dhd not a block\nalias l=ls\n"
+        );
+        assert!(!unwire(&rc_file, false).unwrap());
+        assert!(!unwire(&temp_dir.path().join(".zshrc"), false).unwrap());
+    }
+}
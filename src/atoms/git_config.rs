@@ -1,7 +1,9 @@
 use crate::atoms::Atom;
 use gix_config::file::Metadata;
 use gix_config::{File, Source};
+use crate::logging::LoggedCommand;
 use std::path::PathBuf;
+use std::process::{Command, Output};
 use std::fs;
 use bstr::BString;
 
@@ -51,17 +53,13 @@ impl GitConfig {
             }
             GitConfigScope::Global => {
                 // Use XDG config directory for global git config
-                let config_dir = std::env::var("XDG_CONFIG_HOME")
-                    .map(PathBuf::from)
-                    .or_else(|_| {
-                        std::env::var("HOME")
-                            .map(|home| PathBuf::from(home).join(".config"))
-                    })
-                    .or_else(|_| {
+                let config_dir = crate::paths::xdg_dir("XDG_CONFIG_HOME")
+                    .or_else(|| {
                         std::env::var("USERPROFILE")
                             .map(|home| PathBuf::from(home).join(".config"))
+                            .ok()
                     })
-                    .map_err(|_| "Unable to determine config directory".to_string())?;
+                    .ok_or_else(|| "Unable to determine config directory".to_string())?;
                 
                 Ok(config_dir.join("git").join("config"))
             }
@@ -167,7 +165,7 @@ impl Atom for GitConfig {
 
         // Create parent directories if needed
         if let Some(parent) = config_path.parent() {
-            fs::create_dir_all(parent)
+            crate::target_user::create_dir_all(parent)
                 .map_err(|e| format!("Failed to create config directory: {}", e))?;
         }
 
@@ -179,6 +177,7 @@ impl Atom for GitConfig {
         
         fs::write(&config_path, buffer)
             .map_err(|e| format!("Failed to write git config to {}: {}", config_path.display(), e))?;
+        crate::target_user::give(&config_path)?;
 
         Ok(())
     }
@@ -201,6 +200,149 @@ impl Atom for GitConfig {
     }
 }
 
+/// Sets or unsets single keys with `git config`, leaving the rest of the
+/// config file alone
+///
+/// Keys already holding their values aren't written, so other tools editing
+/// the same file don't see spurious changes.
+#[derive(Debug, Clone)]
+pub struct GitConfigKeys {
+    /// Every key with all of its values, in order; more than one value makes
+    /// a multi-valued key
+    pub entries: Vec<(String, Vec<String>)>,
+    pub scope: GitConfigScope,
+    /// Config file to edit instead of the scope's (`git config --file`)
+    pub file: Option<PathBuf>,
+    /// Where git runs, which picks the repository of the local scope
+    pub dir: PathBuf,
+    /// Remove the keys instead of setting them
+    pub absent: bool,
+}
+
+impl GitConfigKeys {
+    fn git(&self) -> Command {
+        // The system config is root's to write
+        let mut cmd = match self.scope {
+            GitConfigScope::System if self.file.is_none() => Command::new("git"),
+            _ => crate::target_user::command("git"),
+        };
+        cmd.current_dir(&self.dir).arg("config");
+        match (&self.file, &self.scope) {
+            (Some(file), _) => cmd.arg("--file").arg(file),
+            (None, GitConfigScope::Local) => cmd.arg("--local"),
+            (None, GitConfigScope::Global) => {
+                // The global config of the user DHD applies for, also through sudo
+                if let Some(home) = crate::paths::home_dir() {
+                    cmd.env("HOME", home);
+                }
+                cmd.arg("--global")
+            }
+            (None, GitConfigScope::System) => cmd.arg("--system"),
+        };
+        cmd
+    }
+
+    fn run(&self, args: &[&str]) -> Result<Output, String> {
+        self.git()
+            .args(args)
+            .logged_output()
+            .map_err(|e| format!("Failed to run git config: {}", e))
+    }
+
+    /// The values `key` has now, empty if it isn't set
+    fn values(&self, key: &str) -> Result<Vec<String>, String> {
+        let output = self.run(&["--get-all", key])?;
+        match output.status.code() {
+            Some(0) => Ok(String::from_utf8_lossy(&output.stdout)
+                .lines()
+                .map(String::from)
+                .collect()),
+            // The key isn't set, or the file doesn't exist yet
+            Some(1) => Ok(Vec::new()),
+            _ => Err(format!(
+                "git config --get-all {} failed: {}",
+                key,
+                String::from_utf8_lossy(&output.stderr).trim()
+            )),
+        }
+    }
+
+    /// The entries whose key doesn't hold its values yet, with its current ones
+    fn pending(&self) -> Result<Vec<(&str, &[String], Vec<String>)>, String> {
+        let mut pending = Vec::new();
+        for (key, values) in &self.entries {
+            let current = self.values(key)?;
+            let done = if self.absent {
+                current.is_empty()
+            } else {
+                &current == values
+            };
+            if !done {
+                pending.push((key.as_str(), values.as_slice(), current));
+            }
+        }
+        Ok(pending)
+    }
+
+    fn change(&self, args: &[&str]) -> Result<(), String> {
+        let output = self.run(args)?;
+        if !output.status.success() {
+            return Err(format!(
+                "git config {} failed: {}",
+                args.join(" "),
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+        Ok(())
+    }
+}
+
+impl Atom for GitConfigKeys {
+    fn name(&self) -> &str {
+        "GitConfigKeys"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        if let Some(parent) = self.file.as_ref().and_then(|file| file.parent()) {
+            crate::target_user::create_dir_all(parent)
+                .map_err(|e| format!("Failed to create {}: {}", parent.display(), e))?;
+        }
+
+        for (key, values, current) in self.pending()? {
+            if self.absent || values.len() > 1 {
+                if !current.is_empty() {
+                    self.change(&["--unset-all", key])?;
+                }
+                if !self.absent {
+                    for value in values {
+                        self.change(&["--add", key, value])?;
+                    }
+                }
+            } else {
+                self.change(&["--replace-all", key, &values[0]])?;
+            }
+        }
+        Ok(())
+    }
+
+    fn check(&self) -> Option<bool> {
+        // Errors, like an invalid key, are reported when the atom executes
+        Some(self.pending().map_or(true, |pending| !pending.is_empty()))
+    }
+
+    fn describe(&self) -> String {
+        let keys: Vec<&str> = self.entries.iter().map(|(key, _)| key.as_str()).collect();
+        let action = if self.absent { "Unset" } else { "Set" };
+        let file = match (&self.file, &self.scope) {
+            (Some(file), _) => format!("git config {}", file.display()),
+            (None, GitConfigScope::Local) => "local git config".to_string(),
+            (None, GitConfigScope::Global) => "global git config".to_string(),
+            (None, GitConfigScope::System) => "system git config".to_string(),
+        };
+        format!("{} {} in {}", action, keys.join(", "), file)
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -298,4 +440,80 @@ mod tests {
         assert_eq!(git_config.entries[0].add, Some(true));
         assert_eq!(git_config.entries[1].add, Some(true));
     }
-}
\ No newline at end of file
+
+    fn keys(dir: &std::path::Path, entries: &[(&str, &[&str])], absent: bool) -> GitConfigKeys {
+        GitConfigKeys {
+            entries: entries
+                .iter()
+                .map(|(key, values)| {
+                    (
+                        key.to_string(),
+                        values.iter().map(|v| v.to_string()).collect(),
+                    )
+                })
+                .collect(),
+            scope: GitConfigScope::Global,
+            file: Some(dir.join("git/config")),
+            dir: dir.to_path_buf(),
+            absent,
+        }
+    }
+
+    #[test]
+    fn test_git_config_keys_only_write_what_differs() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let file = temp_dir.path().join("git/config");
+        let atom = keys(
+            temp_dir.path(),
+            &[
+                ("user.name", &["Dev"]),
+                ("user.email", &["dev@example.com"]),
+                ("credential.helper", &["cache", "store"]),
+            ],
+            false,
+        );
+        assert_eq!(atom.check(), Some(true));
+        atom.execute().unwrap();
+        assert_eq!(atom.check(), Some(false));
+        assert_eq!(
+            atom.describe(),
+            format!(
+                "Set user.name, user.email, credential.helper in git config {}",
+                file.display()
+            )
+        );
+
+        // Keys DHD doesn't manage are left alone
+        let other = keys(temp_dir.path(), &[("core.editor", &["vim"])], false);
+        other.execute().unwrap();
+        assert_eq!(atom.check(), Some(false));
+        assert_eq!(
+            atom.values("credential.helper").unwrap(),
+            ["cache", "store"]
+        );
+
+        let changed = keys(temp_dir.path(), &[("user.name", &["Other"])], false);
+        assert_eq!(changed.check(), Some(true));
+        changed.execute().unwrap();
+        assert_eq!(atom.values("user.name").unwrap(), ["Other"]);
+    }
+
+    #[test]
+    fn test_git_config_keys_absent() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        keys(
+            temp_dir.path(),
+            &[("user.signingkey", &["ABCD"]), ("core.editor", &["vim"])],
+            false,
+        )
+        .execute()
+        .unwrap();
+
+        let absent = keys(temp_dir.path(), &[("user.signingkey", &[])], true);
+        assert_eq!(absent.check(), Some(true));
+        absent.execute().unwrap();
+        assert_eq!(absent.check(), Some(false));
+        assert!(absent.values("user.signingkey").unwrap().is_empty());
+        assert_eq!(absent.values("core.editor").unwrap(), ["vim"]);
+    }
+}
@@ -0,0 +1,482 @@
+use crate::atoms::{Atom, network};
+use crate::logging::LoggedCommand;
+use std::path::PathBuf;
+use std::process::Command;
+
+/// Clones a git repository and optionally keeps it at a ref
+#[derive(Debug, Clone)]
+pub struct GitRepo {
+    pub url: String,
+    pub path: PathBuf,
+    /// Branch, tag or commit to check out (default: the remote's default branch)
+    pub git_ref: Option<String>,
+    pub depth: Option<u32>,
+    /// Fetch and check out `git_ref` when the repository already exists
+    pub update: bool,
+}
+
+/// A ref as advertised by the remote
+#[derive(Debug, Clone, PartialEq)]
+struct RemoteRef {
+    commit: String,
+    branch: bool,
+}
+
+impl GitRepo {
+    pub fn new(
+        url: String,
+        path: PathBuf,
+        git_ref: Option<String>,
+        depth: Option<u32>,
+        update: bool,
+    ) -> Self {
+        Self {
+            url,
+            path,
+            git_ref,
+            depth,
+            update,
+        }
+    }
+
+    fn run_git(&self, args: &[&str], in_repo: bool) -> Result<String, String> {
+        let mut cmd = crate::target_user::command("git");
+        if in_repo {
+            cmd.arg("-C").arg(&self.path);
+        }
+
+        let output = cmd
+            .args(args)
+            .logged_output()
+            .map_err(|e| format!("Failed to run git {}: {}", args.join(" "), e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "git {} failed for {}: {}",
+                args.join(" "),
+                self.path.display(),
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+
+        Ok(String::from_utf8_lossy(&output.stdout).trim().to_string())
+    }
+
+    fn git(&self, args: &[&str]) -> Result<String, String> {
+        self.run_git(args, true)
+    }
+
+    fn depth_arg(&self) -> Option<String> {
+        self.depth.map(|depth| format!("--depth={}", depth))
+    }
+
+    fn head(&self) -> Option<String> {
+        self.git(&["rev-parse", "HEAD"]).ok()
+    }
+
+    fn is_dirty(&self) -> Result<bool, String> {
+        Ok(!self.git(&["status", "--porcelain"])?.is_empty())
+    }
+
+    /// The ref to keep checked out: the requested one, or the current branch
+    fn target_ref(&self) -> Option<String> {
+        if let Some(git_ref) = &self.git_ref {
+            return Some(git_ref.clone());
+        }
+
+        self.git(&["rev-parse", "--abbrev-ref", "HEAD"])
+            .ok()
+            .filter(|branch| branch != "HEAD")
+    }
+
+    /// Whether HEAD is already at a tag or commit, which can't move upstream
+    fn matches_locally(&self, target: &str, head: &str) -> bool {
+        if is_commit_id(target) && head.starts_with(target) {
+            return true;
+        }
+
+        self.git(&[
+            "rev-parse",
+            "--verify",
+            "--quiet",
+            &format!("refs/tags/{}^{{commit}}", target),
+        ])
+        .is_ok_and(|commit| commit == head)
+    }
+
+    fn remote_ref(&self, target: &str) -> Result<Option<RemoteRef>, String> {
+        let listing = self.git(&["ls-remote", "origin", target])?;
+        Ok(parse_ls_remote(&listing, target))
+    }
+
+    /// Work out whether an existing checkout is behind the requested ref
+    fn needs_update(&self) -> Result<bool, String> {
+        let Some(target) = self.target_ref() else {
+            // Detached HEAD without a requested ref has nothing to follow
+            return Ok(false);
+        };
+        let head = self.head().unwrap_or_default();
+
+        if self.matches_locally(&target, &head) {
+            return Ok(false);
+        }
+
+        match self.remote_ref(&target)? {
+            Some(remote) => Ok(remote.commit != head),
+            None if is_commit_id(&target) => Ok(true),
+            None => Err(format!(
+                "Ref '{}' not found on origin for {}",
+                target,
+                self.path.display()
+            )),
+        }
+    }
+
+    fn fetch(&self, target: &str) -> Result<(), String> {
+        let mut args = vec!["fetch", "--quiet"];
+        let depth = self.depth_arg();
+        if let Some(depth) = &depth {
+            args.push(depth);
+        }
+        args.extend(["origin", target]);
+        self.git(&args).map(|_| ())
+    }
+
+    fn checkout(&self, target: &str) -> Result<(), String> {
+        // Commits that are already present need no fetch, and short ids can't be fetched
+        if is_commit_id(target)
+            && self
+                .git(&[
+                    "rev-parse",
+                    "--verify",
+                    "--quiet",
+                    &format!("{}^{{commit}}", target),
+                ])
+                .is_ok()
+        {
+            return self
+                .git(&["checkout", "--quiet", "--detach", target])
+                .map(|_| ());
+        }
+
+        let branch =
+            target != "HEAD" && self.remote_ref(target)?.is_some_and(|remote| remote.branch);
+        self.fetch(target)?;
+
+        if !branch {
+            return self
+                .git(&["checkout", "--quiet", "--detach", "FETCH_HEAD"])
+                .map(|_| ());
+        }
+
+        let local_branch = format!("refs/heads/{}", target);
+        if self
+            .git(&["rev-parse", "--verify", "--quiet", &local_branch])
+            .is_ok()
+        {
+            self.git(&["checkout", "--quiet", target])?;
+            self.git(&["merge", "--quiet", "--ff-only", "FETCH_HEAD"])
+                .map(|_| ())
+        } else {
+            self.git(&["checkout", "--quiet", "-b", target, "FETCH_HEAD"])
+                .map(|_| ())
+        }
+    }
+
+    fn clone_repo(&self) -> Result<(), String> {
+        if let Some(parent) = self.path.parent() {
+            crate::target_user::create_dir_all(parent)
+                .map_err(|e| format!("Failed to create parent directory: {}", e))?;
+        }
+
+        let path = self.path.to_string_lossy();
+        let mut args = vec!["clone", "--quiet"];
+        let depth = self.depth_arg();
+        if let Some(depth) = &depth {
+            args.push(depth);
+        }
+        // --branch handles branches and tags; commits are fetched after cloning
+        let branch = self.git_ref.as_deref().filter(|r| !is_commit_id(r));
+        if let Some(branch) = branch {
+            args.extend(["--branch", branch]);
+        }
+        args.extend([self.url.as_str(), path.as_ref()]);
+        self.run_git(&args, false)?;
+
+        match &self.git_ref {
+            Some(commit) if is_commit_id(commit) => self.checkout(commit),
+            _ => Ok(()),
+        }
+    }
+
+    /// What updating the clone does, for the offline summary
+    fn update_description(&self) -> String {
+        format!("Update {} from {}", self.path.display(), self.url)
+    }
+}
+
+/// Whether a ref looks like an abbreviated or full commit hash
+fn is_commit_id(git_ref: &str) -> bool {
+    (7..=40).contains(&git_ref.len()) && git_ref.chars().all(|c| c.is_ascii_hexdigit())
+}
+
+/// Pick the commit for `target` out of `git ls-remote` output
+fn parse_ls_remote(listing: &str, target: &str) -> Option<RemoteRef> {
+    let refs: Vec<(&str, &str)> = listing
+        .lines()
+        .filter_map(|line| line.split_once('\t'))
+        .collect();
+    let find = |name: &str| {
+        refs.iter()
+            .find(|(_, r)| *r == name)
+            .map(|(commit, _)| commit.to_string())
+    };
+
+    if let Some(commit) = find(&format!("refs/heads/{}", target)) {
+        return Some(RemoteRef {
+            commit,
+            branch: true,
+        });
+    }
+
+    // Annotated tags are listed with their peeled commit as `^{}`
+    let tag = format!("refs/tags/{}", target);
+    if let Some(commit) = find(&format!("{}^{{}}", tag)).or_else(|| find(&tag)) {
+        return Some(RemoteRef {
+            commit,
+            branch: false,
+        });
+    }
+
+    find(target).map(|commit| RemoteRef {
+        commit,
+        branch: false,
+    })
+}
+
+impl Atom for GitRepo {
+    fn name(&self) -> &str {
+        "GitRepo"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        if !self.path.exists() {
+            network::require(&self.describe())?;
+            let _network = network::acquire(&self.describe());
+            return self.clone_repo();
+        }
+
+        if !self.path.join(".git").exists() {
+            return Err(format!(
+                "{} exists but is not a git repository",
+                self.path.display()
+            ));
+        }
+
+        if !self.update {
+            return Ok(());
+        }
+
+        if self.is_dirty()? {
+            return Err(format!(
+                "Refusing to update {}: working tree has uncommitted changes",
+                self.path.display()
+            ));
+        }
+
+        if network::skip(&self.update_description()) {
+            return Ok(());
+        }
+        let _network = network::acquire(&self.describe());
+        if !self.needs_update()? {
+            return Ok(());
+        }
+
+        match self.target_ref() {
+            Some(target) => self.checkout(&target),
+            None => Ok(()),
+        }
+    }
+
+    fn check(&self) -> Option<bool> {
+        if !self.path.join(".git").exists() {
+            return Some(true);
+        }
+
+        if !self.update {
+            return Some(false);
+        }
+
+        // Dirty trees and unknown refs are reported when the atom executes
+        match self.is_dirty() {
+            Ok(false) if network::skip(&self.update_description()) => Some(false),
+            Ok(false) => {
+                let _network = network::acquire(&self.describe());
+                Some(self.needs_update().unwrap_or(true))
+            }
+            _ => Some(true),
+        }
+    }
+
+    fn describe(&self) -> String {
+        let mut description = format!("Clone {} -> {}", self.url, self.path.display());
+        if let Some(git_ref) = &self.git_ref {
+            description.push_str(&format!(" at {}", git_ref));
+        }
+        if self.update {
+            description.push_str(" (update)");
+        }
+        description
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+    use std::path::Path;
+    use tempfile::TempDir;
+
+    fn git_in(dir: &Path, args: &[&str]) -> String {
+        let output = Command::new("git")
+            .arg("-C")
+            .arg(dir)
+            .args(["-c", "user.name=dhd", "-c", "user.email=dhd@example.com"])
+            .args(args)
+            .output()
+            .unwrap();
+        assert!(output.status.success(), "git {:?} failed", args);
+        String::from_utf8_lossy(&output.stdout).trim().to_string()
+    }
+
+    /// Create an upstream repository with one commit on `main`
+    fn upstream(temp_dir: &TempDir) -> PathBuf {
+        let upstream = temp_dir.path().join("upstream");
+        fs::create_dir(&upstream).unwrap();
+        git_in(&upstream, &["init", "--quiet", "--initial-branch=main"]);
+        commit(&upstream, "one");
+        upstream
+    }
+
+    fn commit(repo: &Path, content: &str) -> String {
+        fs::write(repo.join("file.txt"), content).unwrap();
+        git_in(repo, &["add", "file.txt"]);
+        git_in(repo, &["commit", "--quiet", "-m", content]);
+        git_in(repo, &["rev-parse", "HEAD"])
+    }
+
+    fn git_repo(upstream: &Path, path: PathBuf, git_ref: Option<&str>) -> GitRepo {
+        GitRepo::new(
+            upstream.display().to_string(),
+            path,
+            git_ref.map(String::from),
+            None,
+            true,
+        )
+    }
+
+    #[test]
+    fn test_git_repo_clones_and_updates() {
+        let temp_dir = TempDir::new().unwrap();
+        let upstream = upstream(&temp_dir);
+        let checkout = temp_dir.path().join("checkout");
+
+        let atom = git_repo(&upstream, checkout.clone(), Some("main"));
+        assert_eq!(atom.check(), Some(true));
+        atom.execute().unwrap();
+        assert_eq!(
+            fs::read_to_string(checkout.join("file.txt")).unwrap(),
+            "one"
+        );
+        assert_eq!(atom.check(), Some(false));
+
+        let second = commit(&upstream, "two");
+        assert_eq!(atom.check(), Some(true));
+        atom.execute().unwrap();
+        assert_eq!(git_in(&checkout, &["rev-parse", "HEAD"]), second);
+        assert_eq!(
+            git_in(&checkout, &["rev-parse", "--abbrev-ref", "HEAD"]),
+            "main"
+        );
+    }
+
+    #[test]
+    fn test_git_repo_checks_out_commit() {
+        let temp_dir = TempDir::new().unwrap();
+        let upstream = upstream(&temp_dir);
+        let first = git_in(&upstream, &["rev-parse", "HEAD"]);
+        commit(&upstream, "two");
+        let checkout = temp_dir.path().join("checkout");
+
+        let atom = git_repo(&upstream, checkout.clone(), Some(&first[..12]));
+        atom.execute().unwrap();
+        assert_eq!(git_in(&checkout, &["rev-parse", "HEAD"]), first);
+        // A checked out commit is matched without talking to the remote
+        assert!(atom.matches_locally(&first[..12], &first));
+        assert_eq!(atom.check(), Some(false));
+    }
+
+    #[test]
+    fn test_git_repo_refuses_dirty_tree() {
+        let temp_dir = TempDir::new().unwrap();
+        let upstream = upstream(&temp_dir);
+        let checkout = temp_dir.path().join("checkout");
+
+        let atom = git_repo(&upstream, checkout.clone(), None);
+        atom.execute().unwrap();
+        commit(&upstream, "two");
+        fs::write(checkout.join("file.txt"), "local edit").unwrap();
+
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("uncommitted changes"));
+        assert!(err.contains(&checkout.display().to_string()));
+        assert_eq!(
+            fs::read_to_string(checkout.join("file.txt")).unwrap(),
+            "local edit"
+        );
+    }
+
+    #[test]
+    fn test_git_repo_without_update_leaves_checkout() {
+        let temp_dir = TempDir::new().unwrap();
+        let upstream = upstream(&temp_dir);
+        let checkout = temp_dir.path().join("checkout");
+
+        let mut atom = git_repo(&upstream, checkout.clone(), None);
+        atom.update = false;
+        atom.execute().unwrap();
+        let first = git_in(&checkout, &["rev-parse", "HEAD"]);
+        commit(&upstream, "two");
+
+        assert_eq!(atom.check(), Some(false));
+        atom.execute().unwrap();
+        assert_eq!(git_in(&checkout, &["rev-parse", "HEAD"]), first);
+    }
+
+    #[test]
+    fn test_parse_ls_remote_prefers_peeled_tags() {
+        let listing = "aaaaaaa\trefs/tags/v1\nbbbbbbb\trefs/tags/v1^{}\ncccccccc\trefs/heads/main";
+        assert_eq!(
+            parse_ls_remote(listing, "v1"),
+            Some(RemoteRef {
+                commit: "bbbbbbb".to_string(),
+                branch: false
+            })
+        );
+        assert_eq!(
+            parse_ls_remote(listing, "main"),
+            Some(RemoteRef {
+                commit: "cccccccc".to_string(),
+                branch: true
+            })
+        );
+        assert_eq!(parse_ls_remote(listing, "missing"), None);
+    }
+
+    #[test]
+    fn test_is_commit_id() {
+        assert!(is_commit_id("0123abc"));
+        assert!(!is_commit_id("main"));
+        assert!(!is_commit_id("abc"));
+    }
+}
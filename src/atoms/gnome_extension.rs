@@ -18,6 +18,8 @@ impl Atom for InstallGnomeExtension {
     }
 
     fn execute(&self) -> Result<(), String> {
+        crate::platform::require_linux("installGnomeExtensions")?;
+
         // Check if gnome-extensions-cli is installed
         let check = Command::new("which")
             .arg("gext")
@@ -32,7 +34,7 @@ impl Atom for InstallGnomeExtension {
         }
 
         // Install the extension
-        let output = Command::new("gext")
+        let output = crate::target_user::command("gext")
             .args(["install", &self.extension_id])
             .output()
             .map_err(|e| format!("Failed to execute gext install: {}", e))?;
@@ -51,7 +53,7 @@ impl Atom for InstallGnomeExtension {
         }
 
         // Enable the extension
-        let enable_output = Command::new("gext")
+        let enable_output = crate::target_user::command("gext")
             .args(["enable", &self.extension_id])
             .output()
             .map_err(|e| format!("Failed to execute gext enable: {}", e))?;
@@ -0,0 +1,384 @@
+use crate::atoms::{Atom, network};
+use crate::diff::FileChange;
+use crate::logging::LoggedCommand;
+use std::fs;
+use std::io::Write;
+use std::path::{Path, PathBuf};
+use std::process::{Command, Stdio};
+
+/// Where the key comes from
+#[derive(Debug, Clone, PartialEq)]
+pub enum KeySource {
+    Url(String),
+    File(PathBuf),
+    /// Fetched from a keyserver over HKPS, e.g. `keyserver.ubuntu.com`
+    Keyserver {
+        server: String,
+        id: String,
+    },
+}
+
+/// Ownertrust levels as `gpg --import-ownertrust` numbers them
+const TRUST_LEVELS: &[(&str, u8)] = &[
+    ("unknown", 2),
+    ("never", 3),
+    ("marginal", 4),
+    ("full", 5),
+    ("ultimate", 6),
+];
+
+/// Import a GPG public key into the user's keyring, or write it to a keyring file
+///
+/// A key already in the keyring isn't imported again. With `keyring` set,
+/// the key is written there in binary form, as apt's `signed-by=` expects,
+/// and the user's keyring is left alone.
+#[derive(Debug, Clone)]
+pub struct GpgKey {
+    pub source: KeySource,
+    /// Key ID or fingerprint, used to tell without fetching whether it's imported
+    pub key_id: Option<String>,
+    /// Ownertrust to set, one of `TRUST_LEVELS`
+    pub trust: Option<String>,
+    pub keyring: Option<PathBuf>,
+    /// Write `keyring` through sudo, e.g. under `/etc/apt/keyrings`
+    pub escalate: bool,
+}
+
+impl GpgKey {
+    pub fn new(source: KeySource, key_id: Option<String>) -> Self {
+        Self {
+            source,
+            key_id,
+            trust: None,
+            keyring: None,
+            escalate: false,
+        }
+    }
+
+    pub fn with_trust(mut self, trust: Option<String>) -> Self {
+        self.trust = trust;
+        self
+    }
+
+    pub fn with_keyring(mut self, keyring: Option<PathBuf>, escalate: bool) -> Self {
+        self.keyring = keyring;
+        self.escalate = escalate;
+        self
+    }
+
+    /// The key as served or stored, armored or binary
+    pub(crate) fn key_data(&self) -> Result<Vec<u8>, String> {
+        let url = match &self.source {
+            KeySource::File(path) => {
+                return fs::read(path)
+                    .map_err(|e| format!("Failed to read key {}: {}", path.display(), e));
+            }
+            KeySource::Url(url) => url.clone(),
+            KeySource::Keyserver { server, id } => keyserver_url(server, id),
+        };
+        network::require(&format!("Download key {}", url))?;
+
+        let output = Command::new("curl")
+            .args(["-fsSL", &url])
+            .logged_output()
+            .map_err(|e| format!("Failed to download key {}: {}", url, e))?;
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to download key {}: {}",
+                url,
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+        Ok(output.stdout)
+    }
+
+    /// The key in binary form, for keyring files
+    fn binary_key(&self) -> Result<Vec<u8>, String> {
+        let data = self.key_data()?;
+        if !data.starts_with(b"-----BEGIN PGP") {
+            return Ok(data);
+        }
+        gpg(&["--dearmor"], Some(&data))
+    }
+
+    fn keyring_change(&self, keyring: &Path) -> Result<FileChange, String> {
+        let current = match fs::read(keyring) {
+            Ok(current) => Some(current),
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => None,
+            Err(e) => return Err(format!("Failed to read {}: {}", keyring.display(), e)),
+        };
+        Ok(FileChange {
+            target: keyring.to_path_buf(),
+            desired: self.binary_key()?,
+            current,
+        })
+    }
+
+    /// Fingerprints of the primary keys in `data`, without importing them
+    pub(crate) fn fingerprints(data: &[u8]) -> Result<Vec<String>, String> {
+        let listing = gpg(
+            &["--with-colons", "--import-options", "show-only", "--import"],
+            Some(data),
+        )?;
+        let listing = String::from_utf8_lossy(&listing);
+
+        // Each `pub` record is followed by the `fpr` of that key; `sub` ones by their subkey's
+        let mut fingerprints = Vec::new();
+        let mut previous = "";
+        for line in listing.lines() {
+            let mut fields = line.split(':');
+            let record = fields.next().unwrap_or_default();
+            if record == "fpr" && previous == "pub" {
+                if let Some(fingerprint) = fields.nth(8) {
+                    fingerprints.push(fingerprint.to_string());
+                }
+            }
+            previous = record;
+        }
+        if fingerprints.is_empty() {
+            return Err("No public key found in the key data".to_string());
+        }
+        Ok(fingerprints)
+    }
+
+    fn is_imported(key: &str) -> bool {
+        gpg(&["--list-keys", key], None).is_ok()
+    }
+
+    fn trust_level(&self) -> Option<u8> {
+        let trust = self.trust.as_deref()?;
+        TRUST_LEVELS
+            .iter()
+            .find(|(name, _)| *name == trust)
+            .map(|(_, level)| *level)
+    }
+
+    /// Fingerprints whose ownertrust isn't `level` yet
+    fn untrusted(fingerprints: &[String], level: u8) -> Result<Vec<String>, String> {
+        let ownertrust = gpg(&["--export-ownertrust"], None)?;
+        let ownertrust = String::from_utf8_lossy(&ownertrust);
+        Ok(fingerprints
+            .iter()
+            .filter(|fingerprint| {
+                !ownertrust
+                    .lines()
+                    .any(|line| line == format!("{}:{}:", fingerprint, level))
+            })
+            .cloned()
+            .collect())
+    }
+
+    /// Fingerprints of the key when it is in the keyring already, without fetching it
+    fn imported_fingerprints(&self) -> Option<Vec<String>> {
+        let key_id = self.key_id.as_deref()?;
+        let listing = gpg(&["--with-colons", "--fingerprint", key_id], None).ok()?;
+        let fingerprint = String::from_utf8_lossy(&listing)
+            .lines()
+            .find(|line| line.starts_with("fpr:"))
+            .and_then(|line| line.split(':').nth(9).map(String::from))?;
+        Some(vec![fingerprint])
+    }
+
+    fn pending(&self) -> Result<bool, String> {
+        if let Some(keyring) = &self.keyring {
+            return Ok(self.keyring_change(keyring)?.is_changed());
+        }
+
+        let fingerprints = match self.imported_fingerprints() {
+            Some(fingerprints) => fingerprints,
+            None => Self::fingerprints(&self.key_data()?)?,
+        };
+        if !fingerprints.iter().all(|key| Self::is_imported(key)) {
+            return Ok(true);
+        }
+        match self.trust_level() {
+            Some(level) => Ok(!Self::untrusted(&fingerprints, level)?.is_empty()),
+            None => Ok(false),
+        }
+    }
+}
+
+/// The HKPS lookup URL of `id` on `server`
+fn keyserver_url(server: &str, id: &str) -> String {
+    let server = server
+        .trim_start_matches("hkps://")
+        .trim_start_matches("https://")
+        .trim_end_matches('/');
+    let id = id.trim_start_matches("0x").replace(' ', "");
+    format!(
+        "https://{}/pks/lookup?op=get&options=mr&search=0x{}",
+        server, id
+    )
+}
+
+/// Run `gpg --batch` with `input` on stdin, returning its stdout
+fn gpg(args: &[&str], input: Option<&[u8]>) -> Result<Vec<u8>, String> {
+    let mut cmd = Command::new("gpg");
+    cmd.arg("--batch").args(args);
+    let Some(input) = input else {
+        let output = cmd
+            .logged_output()
+            .map_err(|e| format!("Failed to run gpg: {}", e))?;
+        return finish(args, output);
+    };
+
+    let mut child = cmd
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .map_err(|e| format!("Failed to run gpg: {}", e))?;
+    if let Some(mut stdin) = child.stdin.take() {
+        stdin
+            .write_all(input)
+            .map_err(|e| format!("Failed to run gpg: {}", e))?;
+    }
+    let output = child
+        .wait_with_output()
+        .map_err(|e| format!("Failed to run gpg: {}", e))?;
+    finish(args, output)
+}
+
+fn finish(args: &[&str], output: std::process::Output) -> Result<Vec<u8>, String> {
+    if !output.status.success() {
+        return Err(format!(
+            "gpg {} failed: {}",
+            args.join(" "),
+            String::from_utf8_lossy(&output.stderr).trim()
+        ));
+    }
+    Ok(output.stdout)
+}
+
+impl Atom for GpgKey {
+    fn name(&self) -> &str {
+        "GpgKey"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        if let Some(keyring) = &self.keyring {
+            let change = self.keyring_change(keyring)?;
+            if !change.is_changed() {
+                return Ok(());
+            }
+            for fingerprint in Self::fingerprints(&change.desired)? {
+                log::info!("Writing GPG key {} to {}", fingerprint, keyring.display());
+            }
+            return crate::atoms::block_in_file::write_file(&change, None, self.escalate);
+        }
+
+        let fingerprints = match self.imported_fingerprints() {
+            Some(fingerprints) => fingerprints,
+            None => {
+                let data = self.key_data()?;
+                let fingerprints = Self::fingerprints(&data)?;
+                if !fingerprints.iter().all(|key| Self::is_imported(key)) {
+                    gpg(&["--import"], Some(&data))?;
+                    for fingerprint in &fingerprints {
+                        log::info!("Imported GPG key {}", fingerprint);
+                    }
+                }
+                fingerprints
+            }
+        };
+
+        if let Some(level) = self.trust_level() {
+            let untrusted = Self::untrusted(&fingerprints, level)?;
+            if !untrusted.is_empty() {
+                let ownertrust: String = untrusted
+                    .iter()
+                    .map(|fingerprint| format!("{}:{}:\n", fingerprint, level))
+                    .collect();
+                gpg(&["--import-ownertrust"], Some(ownertrust.as_bytes()))?;
+            }
+        }
+        Ok(())
+    }
+
+    fn check(&self) -> Option<bool> {
+        self.pending().ok()
+    }
+
+    fn describe(&self) -> String {
+        let key = match (&self.key_id, &self.source) {
+            (Some(id), _) => format!("GPG key {}", id),
+            (None, KeySource::Url(url)) => format!("GPG key from {}", url),
+            (None, KeySource::File(path)) => format!("GPG key from {}", path.display()),
+            (None, KeySource::Keyserver { id, .. }) => format!("GPG key {}", id),
+        };
+        let mut description = match &self.keyring {
+            Some(keyring) => format!("Write {} to {}", key, keyring.display()),
+            None => format!("Import {}", key),
+        };
+        if let Some(trust) = &self.trust {
+            description.push_str(&format!(" (trust: {})", trust));
+        }
+        description
+    }
+}
+
+/// Whether `trust` is an ownertrust level `gpgKey` knows
+pub fn is_trust_level(trust: &str) -> bool {
+    TRUST_LEVELS.iter().any(|(name, _)| *name == trust)
+}
+
+/// The ownertrust levels `gpgKey` accepts
+pub fn trust_levels() -> Vec<&'static str> {
+    TRUST_LEVELS.iter().map(|(name, _)| *name).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_keyserver_url() {
+        assert_eq!(
+            keyserver_url("keyserver.ubuntu.com", "0xABCD1234"),
+            "https://keyserver.ubuntu.com/pks/lookup?op=get&options=mr&search=0xABCD1234"
+        );
+        assert_eq!(
+            keyserver_url("hkps://keys.openpgp.org/", "ABCD 1234"),
+            "https://keys.openpgp.org/pks/lookup?op=get&options=mr&search=0xABCD1234"
+        );
+    }
+
+    #[test]
+    fn test_describe() {
+        let key = GpgKey::new(
+            KeySource::Url("https://example.com/key.asc".to_string()),
+            None,
+        );
+        assert_eq!(
+            key.describe(),
+            "Import GPG key from https://example.com/key.asc"
+        );
+
+        let key = key
+            .with_trust(Some("full".to_string()))
+            .with_keyring(Some(PathBuf::from("/etc/apt/keyrings/example.gpg")), true);
+        assert_eq!(
+            key.describe(),
+            "Write GPG key from https://example.com/key.asc to /etc/apt/keyrings/example.gpg (trust: full)"
+        );
+        assert_eq!(key.trust_level(), Some(5));
+    }
+
+    #[test]
+    fn test_keyring_file_is_written_from_a_binary_key() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let source = temp_dir.path().join("key.gpg");
+        let keyring = temp_dir.path().join("keyrings/example.gpg");
+        fs::write(&source, [0x99, 0x01, 0x0d]).unwrap();
+
+        let key =
+            GpgKey::new(KeySource::File(source), None).with_keyring(Some(keyring.clone()), false);
+        let change = key.keyring_change(&keyring).unwrap();
+        assert!(change.is_changed());
+        assert_eq!(change.desired, vec![0x99, 0x01, 0x0d]);
+
+        fs::create_dir_all(keyring.parent().unwrap()).unwrap();
+        fs::write(&keyring, [0x99, 0x01, 0x0d]).unwrap();
+        assert_eq!(key.check(), Some(false));
+    }
+}
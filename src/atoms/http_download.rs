@@ -1,4 +1,4 @@
-use crate::atoms::Atom;
+use crate::atoms::{Atom, network};
 use std::fs;
 use std::path::PathBuf;
 use std::process::Command;
@@ -33,17 +33,24 @@ impl Atom for HttpDownload {
     }
 
     fn execute(&self) -> Result<(), String> {
+        // Offline, a file downloaded before is kept as it is
+        if self.destination.exists() && network::skip(&self.describe()) {
+            return Ok(());
+        }
+        network::require(&self.describe())?;
+
         // Create parent directories if needed
         if let Some(parent) = self.destination.parent() {
             if !parent.exists() {
-                fs::create_dir_all(parent)
+                crate::target_user::create_dir_all(parent)
                     .map_err(|e| format!("Failed to create parent directory: {}", e))?;
             }
         }
 
-        // Download the file using curl
+        // Download the file using curl, treating HTTP errors as failures
+        let _network = network::acquire(&self.describe());
         let output = Command::new("curl")
-            .args(["-L", "-o", &self.destination.to_string_lossy(), &self.url])
+            .args(["-fsSL", "-o", &self.destination.to_string_lossy(), &self.url])
             .output()
             .map_err(|e| format!("Failed to download file: {}", e))?;
 
@@ -83,6 +90,7 @@ impl Atom for HttpDownload {
                 ));
             }
         }
+        crate::target_user::give(&self.destination)?;
 
         // Set file mode if requested
         if let Some(mode) = self.mode {
@@ -1,10 +1,20 @@
-use super::package::PackageManager;
-use crate::atoms::Atom;
+use super::package::brew::BrewProvider;
+use super::package::{
+    PackageManager, PackageOptions, PackageProvider, pick_version, split_version, version_matches,
+};
+use crate::atoms::{Atom, network};
+use crate::platform::current_platform;
+use std::sync::Mutex;
 
 #[derive(Debug, Clone)]
 pub struct InstallPackages {
     pub packages: Vec<String>,
     pub manager: Option<PackageManager>,
+    pub options: PackageOptions,
+    /// Upgrade packages that are installed already
+    pub latest: bool,
+    /// Groups the package manager expands into packages itself
+    pub groups: Vec<String>,
 }
 
 impl Atom for InstallPackages {
@@ -13,7 +23,7 @@ impl Atom for InstallPackages {
     }
 
     fn execute(&self) -> Result<(), String> {
-        if self.packages.is_empty() {
+        if self.is_empty() {
             return Ok(());
         }
 
@@ -25,42 +35,87 @@ impl Atom for InstallPackages {
                 .ok_or_else(|| "No supported package manager found".to_string())?
         };
 
-        let provider = manager.get_provider();
+        // Package databases take an exclusive lock, so installs must not overlap
+        let _backend_lock = manager.lock()?;
+        // Only once the backend is ours, so no download slot waits on its lock
+        let _network = network::acquire(&self.describe());
 
-        // Filter out already installed packages
-        let mut packages_to_install = Vec::new();
-        for package in &self.packages {
-            match provider.is_package_installed(package) {
-                Ok(true) => {
-                    // Silently skip already installed packages
-                }
-                Ok(false) => {
-                    packages_to_install.push(package.clone());
-                }
-                Err(_e) => {
-                    // If we can't check, assume it needs to be installed
-                    packages_to_install.push(package.clone());
-                }
-            }
-        }
+        let provider = manager.get_provider_with_options(&self.options)?;
 
-        if packages_to_install.is_empty() {
-            return Ok(());
+        // Map generic names to their distro-specific equivalents
+        let mut packages = self.resolved_names(&manager);
+        expand_groups(provider.as_ref(), &self.groups, &mut packages)?;
+        if network::offline() {
+            return self.keep_installed(provider.as_ref(), &packages);
         }
+        install_missing(provider.as_ref(), &manager, &packages, false, self.latest)?;
 
-        // Install each package
-        for package in &packages_to_install {
-            match provider.install_package(package) {
-                Ok(_) => {}
-                Err(e) => {
-                    return Err(format!("Failed to install package {}: {}", package, e));
-                }
+        if !self.options.casks.is_empty() {
+            if manager != PackageManager::Brew {
+                return Err(format!(
+                    "Casks can only be installed with brew, not {}",
+                    provider.name()
+                ));
             }
+            let cask_provider = BrewProvider::new(true, self.options.taps.clone());
+            install_missing(
+                &cask_provider,
+                &manager,
+                &self.options.casks,
+                true,
+                self.latest,
+            )?;
         }
 
         Ok(())
     }
 
+    fn check(&self) -> Option<bool> {
+        if self.is_empty() {
+            return Some(false);
+        }
+        // Whether a newer version is available is only known by trying
+        if self.latest && !network::skip(&self.upgrade_description()) {
+            return Some(true);
+        }
+
+        let manager = match &self.manager {
+            Some(mgr) => mgr.clone(),
+            None => PackageManager::detect()?,
+        };
+        let provider = manager.get_provider_with_options(&self.options).ok()?;
+        let mut packages = self.resolved_names(&manager);
+        // A group that can't be expanded fails the install with the reason
+        if expand_groups(provider.as_ref(), &self.groups, &mut packages).is_err() {
+            return Some(true);
+        }
+        let installed = self.all_installed(provider.as_ref(), &packages);
+
+        // A batch installed them, but this atom is the one asking for them
+        if take_batched(&manager, &packages) {
+            return Some(true);
+        }
+        Some(!installed)
+    }
+
+    fn audit(&self) -> Option<bool> {
+        if self.is_empty() {
+            return Some(false);
+        }
+        // What's newest, or what a group holds, can take a refreshed index
+        if self.latest || !self.groups.is_empty() {
+            return None;
+        }
+
+        let manager = match &self.manager {
+            Some(mgr) => mgr.clone(),
+            None => PackageManager::detect()?,
+        };
+        let provider = manager.get_provider_with_options(&self.options).ok()?;
+        let packages = self.resolved_names(&manager);
+        Some(!self.all_installed(provider.as_ref(), &packages))
+    }
+
     fn describe(&self) -> String {
         let manager_str = if let Some(mgr) = &self.manager {
             let provider = mgr.get_provider();
@@ -69,18 +124,342 @@ impl Atom for InstallPackages {
             String::new()
         };
 
-        if self.packages.is_empty() {
-            format!("Install packages{}: (none)", manager_str)
+        let verb = if self.latest {
+            "Install or upgrade"
+        } else {
+            "Install"
+        };
+        let mut description = if self.packages.is_empty() && !self.groups.is_empty() {
+            format!(
+                "{} package groups{}: {}",
+                verb,
+                manager_str,
+                self.groups.join(", ")
+            )
+        } else if self.packages.is_empty() {
+            format!("{} packages{}: (none)", verb, manager_str)
         } else if self.packages.len() == 1 {
-            format!("Install package{}: {}", manager_str, self.packages[0])
+            format!("{} package{}: {}", verb, manager_str, self.packages[0])
         } else {
             format!(
-                "Install packages{}: {}",
+                "{} packages{}: {}",
+                verb,
                 manager_str,
                 self.packages.join(", ")
             )
+        };
+
+        if !self.packages.is_empty() && !self.groups.is_empty() {
+            description.push_str(&format!(" (groups: {})", self.groups.join(", ")));
+        }
+        if !self.options.casks.is_empty() {
+            description.push_str(&format!(" (casks: {})", self.options.casks.join(", ")));
+        }
+
+        description
+    }
+
+    fn package_batch(&self) -> Option<PackageBatch> {
+        if self.latest || !self.groups.is_empty() || !self.options.casks.is_empty() {
+            return None;
+        }
+        let manager = match &self.manager {
+            Some(mgr) => mgr.clone(),
+            None => PackageManager::detect()?,
+        };
+        if !manager.installs_in_batches() {
+            return None;
+        }
+
+        // Pinned versions are installed by the atom itself
+        let platform = current_platform();
+        let packages: Vec<String> = self
+            .packages
+            .iter()
+            .map(|package| self.options.resolve_name(package, &manager, &platform))
+            .filter(|package| split_version(package).1.is_none())
+            .collect();
+        if packages.is_empty() {
+            return None;
+        }
+        Some(PackageBatch { manager, packages })
+    }
+}
+
+impl InstallPackages {
+    fn is_empty(&self) -> bool {
+        self.packages.is_empty() && self.groups.is_empty() && self.options.casks.is_empty()
+    }
+
+    /// The packages' names for `manager` on this platform
+    fn resolved_names(&self, manager: &PackageManager) -> Vec<String> {
+        let platform = current_platform();
+        self.packages
+            .iter()
+            .map(|package| self.options.resolve_name(package, manager, &platform))
+            .collect()
+    }
+
+    /// Whether `packages` and the casks are all installed
+    fn all_installed(&self, provider: &dyn PackageProvider, packages: &[String]) -> bool {
+        let casks_installed = self.options.casks.is_empty() || {
+            let cask_provider = BrewProvider::new(true, self.options.taps.clone());
+            self.options
+                .casks
+                .iter()
+                .all(|cask| cask_provider.is_package_installed(cask).unwrap_or(false))
+        };
+        casks_installed
+            && packages
+                .iter()
+                .all(|package| is_installed(provider, package))
+    }
+
+    /// What upgrading the installed packages is, for the offline report
+    fn upgrade_description(&self) -> String {
+        let names: Vec<&str> = self
+            .packages
+            .iter()
+            .chain(&self.groups)
+            .chain(&self.options.casks)
+            .map(String::as_str)
+            .collect();
+        format!("Upgrade packages: {}", names.join(", "))
+    }
+
+    /// Offline, leave installed packages as they are and fail on missing ones
+    fn keep_installed(
+        &self,
+        provider: &dyn PackageProvider,
+        packages: &[String],
+    ) -> Result<(), String> {
+        if !self.all_installed(provider, packages) {
+            return network::require(&self.describe());
+        }
+        if self.latest {
+            network::skip(&self.upgrade_description());
+        }
+        Ok(())
+    }
+}
+
+/// Packages an atom installs that can be installed together with those of
+/// other atoms, in one command of their package manager
+#[derive(Debug, Clone, PartialEq)]
+pub struct PackageBatch {
+    pub manager: PackageManager,
+    pub packages: Vec<String>,
+}
+
+/// Packages a batch installed that no atom has claimed yet, by manager
+static BATCHED: Mutex<Vec<(&'static str, String)>> = Mutex::new(Vec::new());
+
+/// Install the missing packages of every module's batch with one command,
+/// returning the packages installed
+///
+/// Each package is recorded for `dhd uninstall` as a change of the first
+/// module asking for it. The atoms asking for them still run afterwards and
+/// report them as installed, without installing anything themselves.
+pub fn install_batch(
+    manager: &PackageManager,
+    requests: &[(String, Vec<String>)],
+) -> Result<Vec<String>, String> {
+    // Offline, each atom tells what it has installed and what it can't install
+    if network::offline() {
+        return Ok(Vec::new());
+    }
+    let _backend_lock = manager.lock()?;
+    let _network = network::acquire(&format!("Install batch of {}", manager.as_str()));
+    let provider = manager.get_provider();
+    batch_install(provider.as_ref(), manager, requests)
+}
+
+fn batch_install(
+    provider: &dyn PackageProvider,
+    manager: &PackageManager,
+    requests: &[(String, Vec<String>)],
+) -> Result<Vec<String>, String> {
+    // Whatever an earlier apply batched is installed by now
+    BATCHED
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .retain(|(name, _)| *name != manager.as_str());
+
+    let mut missing: Vec<(&str, &String)> = Vec::new();
+    for (module, packages) in requests {
+        for package in packages {
+            if missing.iter().any(|(_, known)| *known == package) {
+                continue;
+            }
+            // Packages that can't be checked are left to their atoms
+            if provider.is_package_installed(package) == Ok(false) {
+                missing.push((module, package));
+            }
+        }
+    }
+    if missing.is_empty() {
+        return Ok(Vec::new());
+    }
+
+    let packages: Vec<String> = missing
+        .iter()
+        .map(|(_, package)| (*package).clone())
+        .collect();
+    provider.install_packages(&packages)?;
+
+    let mut batched = BATCHED.lock().unwrap_or_else(|e| e.into_inner());
+    for (module, package) in missing {
+        let _attribution = crate::state::attribute_to(module);
+        crate::state::record(crate::state::Change::Package {
+            manager: manager.as_str().to_string(),
+            name: package.clone(),
+            cask: false,
+        });
+        batched.push((manager.as_str(), package.clone()));
+    }
+    Ok(packages)
+}
+
+/// Claim the batch-installed packages among `packages`, returning whether
+/// there were any
+fn take_batched(manager: &PackageManager, packages: &[String]) -> bool {
+    let mut batched = BATCHED.lock().unwrap_or_else(|e| e.into_inner());
+    let before = batched.len();
+    batched.retain(|(name, package)| *name != manager.as_str() || !packages.contains(package));
+    batched.len() != before
+}
+
+/// Add the packages of the manager's `groups` to `packages`, logging what
+/// each group expanded to
+fn expand_groups(
+    provider: &dyn PackageProvider,
+    groups: &[String],
+    packages: &mut Vec<String>,
+) -> Result<(), String> {
+    for group in groups {
+        let members = provider.group_packages(group).map_err(|e| {
+            format!(
+                "Package group '{}' isn't in packageGroups of dhd.config.ts: {}",
+                group, e
+            )
+        })?;
+        log::info!("package group {} is {}", group, members.join(", "));
+        for member in members {
+            if !packages.contains(&member) {
+                packages.push(member);
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Whether a package is installed, at its pinned version if it has one
+fn is_installed(provider: &dyn PackageProvider, package: &str) -> bool {
+    match split_version(package) {
+        (name, Some(pinned)) => provider
+            .installed_version(name)
+            .is_some_and(|installed| version_matches(&installed, pinned)),
+        (name, None) => provider.is_package_installed(name).unwrap_or(false),
+    }
+}
+
+/// Install the packages that the provider does not report as installed, and
+/// upgrade the others when `latest` is set
+///
+/// Pinned packages are installed at their version instead, replacing any
+/// other version installed, and aren't upgraded.
+fn install_missing(
+    provider: &dyn PackageProvider,
+    manager: &PackageManager,
+    packages: &[String],
+    cask: bool,
+    latest: bool,
+) -> Result<(), String> {
+    // Filter out already installed packages
+    let mut packages_to_install = Vec::new();
+    let mut pinned_to_install = Vec::new();
+    for package in packages {
+        if let (name, Some(pinned)) = split_version(package) {
+            match provider.installed_version(name) {
+                Some(installed) if version_matches(&installed, pinned) => {}
+                installed => pinned_to_install.push((name, pinned, installed)),
+            }
+            continue;
+        }
+        match provider.is_package_installed(package) {
+            Ok(true) => {
+                if latest {
+                    upgrade(provider, package)?;
+                }
+            }
+            Ok(false) => {
+                packages_to_install.push(package.clone());
+            }
+            Err(_e) => {
+                // If we can't check, assume it needs to be installed
+                packages_to_install.push(package.clone());
+            }
+        }
+    }
+
+    // Install each package
+    for package in &packages_to_install {
+        match provider.install_package(package) {
+            Ok(_) => crate::state::record(crate::state::Change::Package {
+                manager: manager.as_str().to_string(),
+                name: package.clone(),
+                cask,
+            }),
+            Err(e) => {
+                return Err(format!("Failed to install package {}: {}", package, e));
+            }
+        }
+    }
+
+    for (name, pinned, installed) in pinned_to_install {
+        install_pinned(provider, name, pinned)
+            .map_err(|e| format!("Failed to install package {}={}: {}", name, pinned, e))?;
+        match installed {
+            Some(installed) => log::info!("Changed {} from {} to {}", name, installed, pinned),
+            // Only a package that wasn't installed at all is the apply's to uninstall
+            None => crate::state::record(crate::state::Change::Package {
+                manager: manager.as_str().to_string(),
+                name: name.to_string(),
+                cask,
+            }),
         }
     }
+
+    Ok(())
+}
+
+/// Install the available version a pin asks for, or fail listing the
+/// versions the manager has
+fn install_pinned(provider: &dyn PackageProvider, name: &str, pinned: &str) -> Result<(), String> {
+    let version = match provider.available_versions(name) {
+        Some(available) => pick_version(provider.name(), name, pinned, &available)?,
+        // Without a list, the manager decides whether it has the version
+        None => pinned.to_string(),
+    };
+    provider.install_version(name, &version)
+}
+
+/// Upgrade an installed package, logging whether its version changed
+fn upgrade(provider: &dyn PackageProvider, package: &str) -> Result<(), String> {
+    let before = provider.installed_version(package);
+    provider.upgrade_package(package)?;
+
+    match (before, provider.installed_version(package)) {
+        (Some(before), Some(after)) if before != after => {
+            log::info!("Upgraded {} from {} to {}", package, before, after)
+        }
+        (Some(version), Some(_)) => {
+            log::info!("{} is already at the latest version ({})", package, version)
+        }
+        _ => log::info!("Upgraded {} (version unknown)", package),
+    }
+
+    Ok(())
 }
 
 #[cfg(test)]
@@ -92,6 +471,9 @@ mod tests {
         let atom = InstallPackages {
             packages: vec!["vim".to_string()],
             manager: None,
+            options: PackageOptions::default(),
+            latest: false,
+            groups: Vec::new(),
         };
         assert_eq!(atom.name(), "InstallPackages");
     }
@@ -101,9 +483,13 @@ mod tests {
         let atom = InstallPackages {
             packages: vec![],
             manager: None,
+            options: PackageOptions::default(),
+            latest: false,
+            groups: Vec::new(),
         };
 
         // Should succeed even with empty package list
+        assert_eq!(atom.check(), Some(false));
         let result = atom.execute();
         assert!(result.is_ok());
     }
@@ -113,6 +499,9 @@ mod tests {
         let atom = InstallPackages {
             packages: vec!["vim".to_string()],
             manager: None,
+            options: PackageOptions::default(),
+            latest: false,
+            groups: Vec::new(),
         };
 
         // Currently just prints, should succeed
@@ -125,6 +514,9 @@ mod tests {
         let atom = InstallPackages {
             packages: vec!["vim".to_string(), "git".to_string(), "curl".to_string()],
             manager: None,
+            options: PackageOptions::default(),
+            latest: false,
+            groups: Vec::new(),
         };
 
         // Currently just prints, should succeed
@@ -137,11 +529,244 @@ mod tests {
         let atom = InstallPackages {
             packages: vec!["vim".to_string()],
             manager: Some(PackageManager::Apt),
+            options: PackageOptions::default(),
+            latest: false,
+            groups: Vec::new(),
         };
 
         let cloned = atom.clone();
         assert_eq!(cloned.packages, atom.packages);
         assert_eq!(cloned.manager, atom.manager);
+        assert_eq!(cloned.options, atom.options);
         assert_eq!(cloned.name(), atom.name());
     }
+
+    /// Records calls; `git` 2.43.0 is installed, everything else is missing
+    #[derive(Default)]
+    struct FakeProvider {
+        calls: std::sync::Mutex<Vec<String>>,
+    }
+
+    impl PackageProvider for FakeProvider {
+        fn is_available(&self) -> bool {
+            true
+        }
+
+        fn is_package_installed(&self, package: &str) -> Result<bool, String> {
+            Ok(package == "git")
+        }
+
+        fn install_package(&self, package: &str) -> Result<(), String> {
+            self.calls
+                .lock()
+                .unwrap()
+                .push(format!("install {}", package));
+            Ok(())
+        }
+
+        fn install_packages(&self, packages: &[String]) -> Result<(), String> {
+            self.calls
+                .lock()
+                .unwrap()
+                .push(format!("install {}", packages.join(" ")));
+            Ok(())
+        }
+
+        fn upgrade_package(&self, package: &str) -> Result<(), String> {
+            self.calls
+                .lock()
+                .unwrap()
+                .push(format!("upgrade {}", package));
+            Ok(())
+        }
+
+        fn uninstall_package(&self, _package: &str) -> Result<(), String> {
+            Ok(())
+        }
+
+        fn installed_version(&self, package: &str) -> Option<String> {
+            (package == "git").then(|| "1:2.43.0-1".to_string())
+        }
+
+        fn install_version(&self, package: &str, version: &str) -> Result<(), String> {
+            self.calls
+                .lock()
+                .unwrap()
+                .push(format!("install {} {}", package, version));
+            Ok(())
+        }
+
+        fn available_versions(&self, package: &str) -> Option<Vec<String>> {
+            (package == "git").then(|| vec!["1:2.44.0-1".to_string(), "1:2.43.0-1".to_string()])
+        }
+
+        fn group_packages(&self, group: &str) -> Result<Vec<String>, String> {
+            match group {
+                "devel" => Ok(vec!["git".to_string(), "make".to_string()]),
+                _ => Err(format!("fake has no package group {}", group)),
+            }
+        }
+
+        fn update(&self) -> Result<(), String> {
+            Ok(())
+        }
+
+        fn name(&self) -> &str {
+            "fake"
+        }
+
+        fn install_command(&self) -> Vec<String> {
+            vec![]
+        }
+    }
+
+    #[test]
+    fn test_install_missing_upgrades_installed_packages_when_latest() {
+        let packages = vec!["git".to_string(), "vim".to_string()];
+
+        let provider = FakeProvider::default();
+        install_missing(&provider, &PackageManager::Apt, &packages, false, false).unwrap();
+        assert_eq!(*provider.calls.lock().unwrap(), vec!["install vim"]);
+
+        let provider = FakeProvider::default();
+        install_missing(&provider, &PackageManager::Apt, &packages, false, true).unwrap();
+        assert_eq!(
+            *provider.calls.lock().unwrap(),
+            vec!["upgrade git", "install vim"]
+        );
+    }
+
+    #[test]
+    fn test_pinned_packages_install_at_their_version() {
+        let provider = FakeProvider::default();
+        let packages = vec![
+            "git=2.43.0".to_string(),
+            "vim=9.1".to_string(),
+            "curl".to_string(),
+        ];
+        install_missing(&provider, &PackageManager::Apt, &packages, false, true).unwrap();
+        // The pinned git is left alone even with latest, vim's version is up to apt
+        assert_eq!(
+            *provider.calls.lock().unwrap(),
+            vec!["install curl", "install vim 9.1"]
+        );
+        assert!(is_installed(&provider, "git=2.43.0"));
+        assert!(!is_installed(&provider, "git=2.44.0"));
+
+        // A drifted package is changed to the available version matching the pin
+        let provider = FakeProvider::default();
+        let packages = vec!["git=2.44.0".to_string()];
+        install_missing(&provider, &PackageManager::Apt, &packages, false, false).unwrap();
+        assert_eq!(
+            *provider.calls.lock().unwrap(),
+            vec!["install git 1:2.44.0-1"]
+        );
+
+        let packages = vec!["git=2.40.1".to_string()];
+        let error =
+            install_missing(&provider, &PackageManager::Apt, &packages, false, false).unwrap_err();
+        assert_eq!(
+            error,
+            "Failed to install package git=2.40.1: git 2.40.1 isn't available from fake; \
+             available versions: 1:2.44.0-1, 1:2.43.0-1"
+        );
+    }
+
+    #[test]
+    fn test_latest_always_runs() {
+        let atom = InstallPackages {
+            packages: vec!["git".to_string()],
+            manager: Some(PackageManager::Apt),
+            options: PackageOptions::default(),
+            latest: true,
+            groups: Vec::new(),
+        };
+
+        assert_eq!(atom.check(), Some(true));
+        assert_eq!(atom.describe(), "Install or upgrade package (apt): git");
+    }
+
+    #[test]
+    fn test_groups_expand_into_their_packages() {
+        let provider = FakeProvider::default();
+        let mut packages = vec!["make".to_string(), "vim".to_string()];
+        expand_groups(&provider, &["devel".to_string()], &mut packages).unwrap();
+        assert_eq!(packages, vec!["make", "vim", "git"]);
+
+        let error = expand_groups(&provider, &["gnome".to_string()], &mut packages).unwrap_err();
+        assert!(
+            error.contains("Package group 'gnome' isn't in packageGroups"),
+            "{}",
+            error
+        );
+
+        let atom = InstallPackages {
+            packages: Vec::new(),
+            manager: Some(PackageManager::Pacman),
+            options: PackageOptions::default(),
+            latest: false,
+            groups: vec!["xorg".to_string()],
+        };
+        assert_eq!(atom.describe(), "Install package groups (pacman): xorg");
+    }
+
+    #[test]
+    fn test_batch_installs_missing_packages_at_once() {
+        let provider = FakeProvider::default();
+        let requests = vec![
+            (
+                "shell".to_string(),
+                vec!["zsh-batch-test".to_string(), "git".to_string()],
+            ),
+            (
+                "editor".to_string(),
+                vec!["vim-batch-test".to_string(), "zsh-batch-test".to_string()],
+            ),
+        ];
+
+        let installed = batch_install(&provider, &PackageManager::Dnf, &requests).unwrap();
+        assert_eq!(installed, vec!["zsh-batch-test", "vim-batch-test"]);
+        assert_eq!(
+            *provider.calls.lock().unwrap(),
+            vec!["install zsh-batch-test vim-batch-test"]
+        );
+
+        // The first atom asking for a package reports it, the next one doesn't
+        let packages = vec!["zsh-batch-test".to_string()];
+        assert!(!take_batched(&PackageManager::Apt, &packages));
+        assert!(take_batched(&PackageManager::Dnf, &packages));
+        assert!(!take_batched(&PackageManager::Dnf, &packages));
+    }
+
+    #[test]
+    fn test_only_plain_installs_are_batched() {
+        let atom = |latest: bool, casks: Vec<String>| InstallPackages {
+            packages: vec!["ripgrep".to_string()],
+            manager: Some(PackageManager::Apt),
+            options: PackageOptions {
+                casks,
+                ..Default::default()
+            },
+            latest,
+            groups: Vec::new(),
+        };
+
+        assert_eq!(
+            atom(false, Vec::new()).package_batch(),
+            Some(PackageBatch {
+                manager: PackageManager::Apt,
+                packages: vec!["ripgrep".to_string()],
+            })
+        );
+        assert_eq!(atom(true, Vec::new()).package_batch(), None);
+        let pinned = InstallPackages {
+            packages: vec!["ripgrep=14.1.0".to_string()],
+            ..atom(false, Vec::new())
+        };
+        assert_eq!(pinned.package_batch(), None);
+        assert_eq!(
+            atom(false, vec!["firefox".to_string()]).package_batch(),
+            None
+        );
+    }
 }
@@ -0,0 +1,113 @@
+use crate::atom::Destruction;
+use crate::atoms::Atom;
+use crate::atoms::block_in_file::{file_change, write_file};
+use crate::diff::FileChange;
+use std::path::PathBuf;
+
+/// Make sure a file contains a line, or doesn't, leaving other lines alone
+#[derive(Debug, Clone)]
+pub struct LineInFile {
+    pub path: PathBuf,
+    pub line: String,
+    pub present: bool,
+    pub escalate: bool,
+}
+
+impl LineInFile {
+    pub fn new(path: PathBuf, line: String, present: bool, escalate: bool) -> Self {
+        Self {
+            path,
+            line,
+            present,
+            escalate,
+        }
+    }
+
+    fn desired(&self, current: &str) -> String {
+        let mut lines: Vec<&str> = current.lines().collect();
+        let found = lines.contains(&self.line.as_str());
+        match (self.present, found) {
+            (true, false) => lines.push(&self.line),
+            (false, true) => lines.retain(|line| *line != self.line),
+            _ => return current.to_string(),
+        }
+
+        if lines.is_empty() {
+            String::new()
+        } else {
+            lines.join("\n") + "\n"
+        }
+    }
+
+    fn change(&self) -> Result<FileChange, String> {
+        file_change(&self.path, |current| self.desired(current))
+    }
+}
+
+impl Atom for LineInFile {
+    fn name(&self) -> &str {
+        "LineInFile"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        let change = self.change()?;
+        if !change.is_changed() {
+            return Ok(());
+        }
+        write_file(&change, None, self.escalate)
+    }
+
+    fn check(&self) -> Option<bool> {
+        self.change().ok().map(|change| change.is_changed())
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
+    }
+
+    fn file_change(&self) -> Option<Result<FileChange, String>> {
+        Some(self.change())
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        // Only the one line is managed
+        None
+    }
+
+    fn describe(&self) -> String {
+        if self.present {
+            format!("Add line '{}' to {}", self.line, self.path.display())
+        } else {
+            format!("Remove line '{}' from {}", self.line, self.path.display())
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_line_is_added_once_and_removed() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("hosts");
+        fs::write(&path, "127.0.0.1 localhost").unwrap();
+
+        let line = "10.0.0.5 nas".to_string();
+        let add = LineInFile::new(path.clone(), line.clone(), true, false);
+        add.execute().unwrap();
+        add.execute().unwrap();
+        assert_eq!(add.check(), Some(false));
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            "127.0.0.1 localhost\n10.0.0.5 nas\n"
+        );
+
+        LineInFile::new(path.clone(), line, false, false)
+            .execute()
+            .unwrap();
+        assert_eq!(fs::read_to_string(&path).unwrap(), "127.0.0.1 localhost\n");
+    }
+}
@@ -1,5 +1,10 @@
+use crate::atom::Destruction;
 use crate::atoms::Atom;
-use std::path::PathBuf;
+use std::fs;
+use std::path::{Component, Path, PathBuf};
+
+/// Links followed before a chain of them counts as a loop, as on Linux
+const MAX_LINK_HOPS: usize = 40;
 
 #[derive(Debug, Clone)]
 pub struct LinkFile {
@@ -8,6 +13,93 @@ pub struct LinkFile {
     pub force: bool,
 }
 
+impl LinkFile {
+    /// Refuse a link that would point at itself, close a loop of links, or
+    /// have `force` delete the file it points to
+    fn refuse_unsafe(&self) -> Result<(), String> {
+        let link = lexical(&self.source);
+        let resolved_link = resolve_parent(&link);
+        let refuse = |reason: String| {
+            Err(format!(
+                "Refusing to create symlink at {}: {}",
+                self.source.display(),
+                reason
+            ))
+        };
+
+        // Follow what the link would point to, as the kernel would
+        let replaces_file = self.force && self.source.exists() && !self.source.is_symlink();
+        let mut chain = vec![lexical(&self.target)];
+        loop {
+            let current = chain.last().unwrap().clone();
+            let resolved = resolve_parent(&current);
+            // Reaching it would mean going through the link itself
+            if current.starts_with(&link) || resolved.starts_with(&resolved_link) {
+                if replaces_file {
+                    return refuse(format!(
+                        "replacing it with force would delete {}, which the link points to",
+                        current.display()
+                    ));
+                }
+                if current != link && resolved != resolved_link {
+                    return refuse(format!(
+                        "it would point inside itself, at {}",
+                        current.display()
+                    ));
+                }
+                if chain.len() == 1 {
+                    return refuse("it would point at itself".to_string());
+                }
+                let hops: Vec<String> = std::iter::once(&link)
+                    .chain(&chain)
+                    .map(|path| path.display().to_string())
+                    .collect();
+                return refuse(format!(
+                    "it would close a loop of symlinks: {}",
+                    hops.join(" -> ")
+                ));
+            }
+            let Ok(next) = fs::read_link(&current) else {
+                break;
+            };
+            let next = lexical(&current.parent().unwrap_or(Path::new("/")).join(next));
+            if chain.contains(&next) || chain.len() > MAX_LINK_HOPS {
+                return refuse(format!("{} is a loop of symlinks", self.target.display()));
+            }
+            chain.push(next);
+        }
+        Ok(())
+    }
+}
+
+/// `path` made absolute and without `.` and `..`
+fn lexical(path: &Path) -> PathBuf {
+    let path = std::path::absolute(path).unwrap_or_else(|_| path.to_path_buf());
+    let mut normal = PathBuf::new();
+    for component in path.components() {
+        match component {
+            Component::CurDir => {}
+            Component::ParentDir => {
+                normal.pop();
+            }
+            other => normal.push(other),
+        }
+    }
+    normal
+}
+
+/// `path` with the directories above it resolved through any links, so two
+/// names of the same file compare equal; the last component is kept
+fn resolve_parent(path: &Path) -> PathBuf {
+    let parent = path
+        .parent()
+        .and_then(|parent| fs::canonicalize(parent).ok());
+    match (parent, path.file_name()) {
+        (Some(parent), Some(name)) => parent.join(name),
+        _ => path.to_path_buf(),
+    }
+}
+
 impl Atom for LinkFile {
     fn name(&self) -> &str {
         "LinkFile"
@@ -16,7 +108,7 @@ impl Atom for LinkFile {
     fn execute(&self) -> Result<(), String> {
         #[cfg(unix)]
         {
-            use std::fs;
+            self.refuse_unsafe()?;
 
             // Check if symlink already exists and points to the correct target
             if self.source.is_symlink() {
@@ -33,11 +125,22 @@ impl Atom for LinkFile {
                 }
             }
 
+            // Refuse to clobber a real file or directory unless force is set
+            if !self.force && self.source.exists() && !self.source.is_symlink() {
+                return Err(format!(
+                    "Failed to create symlink at {}: a file already exists at that path (set force: true to replace it)",
+                    self.source.display()
+                ));
+            }
+
+            // Whatever is replaced has to be saved before it is removed
+            let previous = crate::state::preserve(&self.source);
+
             // If force is enabled, create parent directories and handle existing files
             if self.force {
                 // Create parent directories if they don't exist
                 if let Some(parent) = self.source.parent() {
-                    fs::create_dir_all(parent).map_err(|e| {
+                    crate::target_user::create_dir_all(parent).map_err(|e| {
                         format!(
                             "Failed to create parent directories for {}: {}",
                             self.source.display(),
@@ -77,7 +180,17 @@ impl Atom for LinkFile {
                     self.target.display(),
                     e
                 )
-            })
+            })?;
+            crate::target_user::give(&self.source)?;
+
+            if let Some(previous) = previous {
+                crate::state::record(crate::state::Change::Symlink {
+                    path: self.source.clone(),
+                    target: self.target.clone(),
+                    previous,
+                });
+            }
+            Ok(())
         }
 
         #[cfg(not(unix))]
@@ -86,6 +199,26 @@ impl Atom for LinkFile {
         }
     }
 
+    fn check(&self) -> Option<bool> {
+        let up_to_date = self.source.is_symlink()
+            && std::fs::read_link(&self.source).is_ok_and(|existing| existing == self.target);
+        Some(!up_to_date)
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        // Without force the atom fails rather than replace a real file
+        (self.force && self.source.exists() && !self.source.is_symlink())
+            .then(|| Destruction::Overwrite(self.source.clone()))
+    }
+
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        Ok(vec![self.source.clone()])
+    }
+
     fn describe(&self) -> String {
         format!(
             "Create symlink at {} -> {}",
@@ -141,8 +274,10 @@ mod tests {
             force: false,
         };
 
+        assert_eq!(atom.check(), Some(true));
         let result = atom.execute();
         assert!(result.is_ok());
+        assert_eq!(atom.check(), Some(false));
 
         // Verify symlink was created
         assert!(source_path.is_symlink());
@@ -169,7 +304,9 @@ mod tests {
 
         let result = atom.execute();
         assert!(result.is_err());
-        assert!(result.unwrap_err().contains("Failed to create symlink"));
+        let err = result.unwrap_err();
+        assert!(err.contains("Failed to create symlink"));
+        assert!(err.contains("force"));
     }
 
     #[test]
@@ -288,4 +425,114 @@ mod tests {
         let link_target = fs::read_link(&source_path).unwrap();
         assert_eq!(link_target, target_path);
     }
+
+    #[test]
+    #[cfg(unix)]
+    fn test_forced_link_over_a_file_is_destructive() {
+        let temp_dir = TempDir::new().unwrap();
+        let source_path = temp_dir.path().join("link.txt");
+        let target_path = temp_dir.path().join("target.txt");
+        fs::write(&target_path, "target content").unwrap();
+
+        let atom = |force| LinkFile {
+            source: source_path.clone(),
+            target: target_path.clone(),
+            force,
+        };
+        assert_eq!(atom(true).destruction(), None);
+
+        fs::write(&source_path, "existing content").unwrap();
+        assert_eq!(
+            atom(true).destruction(),
+            Some(Destruction::Overwrite(source_path.clone()))
+        );
+        assert_eq!(atom(false).destruction(), None);
+    }
+
+    #[test]
+    #[cfg(unix)]
+    fn test_link_to_itself_is_refused() {
+        let temp_dir = TempDir::new().unwrap();
+        let link = temp_dir.path().join(".zshrc");
+        let atom = LinkFile {
+            source: link.clone(),
+            target: temp_dir.path().join("dir/../.zshrc"),
+            force: true,
+        };
+
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("it would point at itself"), "{}", err);
+        assert!(!link.is_symlink());
+    }
+
+    #[test]
+    #[cfg(unix)]
+    fn test_link_closing_a_loop_is_refused() {
+        let temp_dir = TempDir::new().unwrap();
+        let link = temp_dir.path().join(".zshrc");
+        let dotfiles = temp_dir.path().join("zshrc");
+        // The module's file is itself a link back to where the new link goes
+        std::os::unix::fs::symlink(&link, &dotfiles).unwrap();
+
+        let atom = LinkFile {
+            source: link.clone(),
+            target: dotfiles.clone(),
+            force: false,
+        };
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("it would close a loop of symlinks"), "{}", err);
+        assert!(
+            err.contains(&format!("{} -> {}", dotfiles.display(), link.display())),
+            "{}",
+            err
+        );
+        assert!(!link.is_symlink());
+
+        // A target that already loops is refused as well
+        let looped = temp_dir.path().join("a");
+        std::os::unix::fs::symlink(temp_dir.path().join("b"), &looped).unwrap();
+        std::os::unix::fs::symlink(&looped, temp_dir.path().join("b")).unwrap();
+        let atom = LinkFile {
+            source: link.clone(),
+            target: looped,
+            force: false,
+        };
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("is a loop of symlinks"), "{}", err);
+    }
+
+    #[test]
+    #[cfg(unix)]
+    fn test_force_deleting_the_linked_file_is_refused() {
+        let temp_dir = TempDir::new().unwrap();
+        let config = temp_dir.path().join("nvim");
+        fs::create_dir(&config).unwrap();
+        fs::write(config.join("init.lua"), "-- init").unwrap();
+        // Points into the directory the link would replace
+        let alias = temp_dir.path().join("init.lua");
+        std::os::unix::fs::symlink(config.join("init.lua"), &alias).unwrap();
+
+        let atom = LinkFile {
+            source: config.clone(),
+            target: alias,
+            force: true,
+        };
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("with force would delete"), "{}", err);
+        assert!(
+            err.contains("init.lua, which the link points to"),
+            "{}",
+            err
+        );
+        assert!(config.join("init.lua").exists());
+
+        // Pointing inside itself can't ever resolve
+        let atom = LinkFile {
+            source: temp_dir.path().join("link"),
+            target: temp_dir.path().join("link/file"),
+            force: false,
+        };
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("it would point inside itself"), "{}", err);
+    }
 }
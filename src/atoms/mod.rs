@@ -1,15 +1,35 @@
+pub mod atomic_write;
+pub mod block_in_file;
 pub mod compat;
 pub mod copy_file;
 pub mod create_directory;
+pub mod cron;
 pub mod dconf_import;
+pub mod decrypt_file;
+pub mod desktop_entry;
+pub mod env_var;
 pub mod git_config;
+pub mod git_repo;
+pub mod gpg_key;
 pub mod gnome_extension;
 pub mod http_download;
 pub mod install_packages;
+pub mod line_in_file;
 pub mod link_file;
+pub mod network;
 pub mod package;
+pub mod package_repo;
+pub mod plugin;
+pub mod remote_file;
 pub mod remove_packages;
+pub mod render_template;
+pub mod retire_file;
+pub mod retry;
 pub mod run_command;
+pub mod script_install;
+pub mod shell_command;
+pub mod ssh_config;
+pub mod stow;
 pub mod systemd_manage;
 pub mod systemd_service;
 pub mod systemd_socket;
@@ -20,11 +40,60 @@ pub use create_directory::CreateDirectory;
 pub use http_download::HttpDownload;
 pub use install_packages::InstallPackages;
 pub use link_file::LinkFile;
+pub use render_template::RenderTemplate;
 pub use run_command::RunCommand;
+pub use shell_command::ShellCommand;
 
 /// Legacy Atom trait for backwards compatibility
 pub trait Atom: Send + Sync {
     fn name(&self) -> &str;
     fn execute(&self) -> Result<(), String>;
     fn describe(&self) -> String;
+
+    /// Execute the atom, reporting whether it changed anything; atoms that
+    /// can't tell report a change
+    fn execute_changed(&self) -> Result<bool, String> {
+        self.execute().map(|()| true)
+    }
+
+    /// Check whether the atom needs to run: `Some(false)` if already satisfied,
+    /// `Some(true)` if pending, `None` if it cannot tell without running
+    fn check(&self) -> Option<bool> {
+        None
+    }
+
+    /// Like `check`, but only from what can be read without side effects:
+    /// files, links and the package database as it is. Atoms whose check
+    /// runs commands, looks up secrets or could refresh a package index
+    /// leave this `None`
+    fn audit(&self) -> Option<bool> {
+        None
+    }
+
+    /// The file content this atom would write, for atoms that manage a whole file
+    fn file_change(&self) -> Option<Result<crate::diff::FileChange, String>> {
+        None
+    }
+
+    /// What executing this atom would destroy, without mutating the system
+    ///
+    /// Atoms managing a whole file destroy whatever different content is
+    /// already at their target.
+    fn destruction(&self) -> Option<crate::atom::Destruction> {
+        let change = self.file_change()?.ok()?;
+        change
+            .overwrites()
+            .then(|| crate::atom::Destruction::Overwrite(change.target))
+    }
+
+    /// The paths this atom creates or replaces, which `apply --prune` keeps
+    fn managed_paths(&self) -> Result<Vec<std::path::PathBuf>, String> {
+        Ok(Vec::new())
+    }
+
+    /// The packages this atom installs, if it can install them in one
+    /// command together with other atoms
+    fn package_batch(&self) -> Option<install_packages::PackageBatch> {
+        None
+    }
 }
@@ -0,0 +1,177 @@
+//! Bounding how many network operations run at once
+//!
+//! Modules apply with `--jobs` workers, which is fine for local work but can
+//! saturate a connection or trip a server's rate limit when many of them clone
+//! repositories or download files at the same time. Atoms that go over the
+//! network hold a [`Permit`] while they do, and at most `--net-jobs` permits
+//! are out at once; the rest queue for one.
+//!
+//! With `--no-network`, nothing goes over the network at all. What's already
+//! there counts as done, and whatever would only refresh it, like fetching a
+//! clone or a package index, is [skipped](skip) and listed after the summary.
+//! Anything missing [fails right away](require) instead of waiting on a
+//! connection that never comes.
+
+use std::sync::atomic::{AtomicBool, AtomicUsize, Ordering};
+use std::sync::{Condvar, Mutex};
+
+/// How many network operations run at once by default
+pub const DEFAULT_JOBS: usize = 4;
+
+static JOBS: AtomicUsize = AtomicUsize::new(DEFAULT_JOBS);
+
+static POOL: Pool = Pool::new();
+
+static OFFLINE: AtomicBool = AtomicBool::new(false);
+
+/// What was left out for being offline, in the order it came up
+static SKIPPED: Mutex<Vec<String>> = Mutex::new(Vec::new());
+
+/// Run at most `jobs` network operations at once from now on
+pub fn set_jobs(jobs: usize) {
+    JOBS.store(jobs.max(1), Ordering::Relaxed);
+    POOL.released.notify_all();
+}
+
+/// How many network operations run at once
+pub fn jobs() -> usize {
+    JOBS.load(Ordering::Relaxed)
+}
+
+/// Keep everything off the network from now on, as `--no-network` asks
+pub fn set_offline(offline: bool) {
+    OFFLINE.store(offline, Ordering::Relaxed);
+}
+
+/// Whether nothing may go over the network
+pub fn offline() -> bool {
+    OFFLINE.load(Ordering::Relaxed)
+}
+
+/// Fail `what` when offline, since it can't be done without the network
+pub fn require(what: &str) -> Result<(), String> {
+    if offline() {
+        return Err(format!(
+            "{} needs the network, which --no-network turns off",
+            what
+        ));
+    }
+    Ok(())
+}
+
+/// Whether to leave out `what`, which only refreshes something already
+/// there, for being offline; what's left out is noted for the summary
+pub fn skip(what: &str) -> bool {
+    if !offline() {
+        return false;
+    }
+    let mut skipped = SKIPPED.lock().unwrap_or_else(|e| e.into_inner());
+    if !skipped.iter().any(|known| known == what) {
+        skipped.push(what.to_string());
+    }
+    true
+}
+
+/// What was left out for being offline so far
+pub fn skipped() -> Vec<String> {
+    SKIPPED.lock().unwrap_or_else(|e| e.into_inner()).clone()
+}
+
+/// The lines listing what was left out for being offline, or nothing when
+/// nothing was
+pub fn summary() -> String {
+    let skipped = skipped();
+    if skipped.is_empty() {
+        return String::new();
+    }
+    let mut summary = format!("\n📴 Skipped offline ({}):\n", skipped.len());
+    for what in &skipped {
+        summary.push_str(&format!("   - {}\n", what));
+    }
+    summary.push_str("   What's there was kept; run without --no-network to refresh it.\n");
+    summary
+}
+
+/// Wait for a slot in the network pool for `what`
+pub fn acquire(what: &str) -> Permit<'static> {
+    POOL.acquire(what, jobs)
+}
+
+/// Slots for network operations, counting those handed out
+struct Pool {
+    in_use: Mutex<usize>,
+    released: Condvar,
+}
+
+/// A slot in the network pool, given back when dropped
+///
+/// Permits aren't reentrant: an atom takes one around all of its network
+/// work, not one per step, so it never waits on itself.
+#[must_use = "the slot is given back as soon as the permit is dropped"]
+pub struct Permit<'a>(&'a Pool);
+
+impl Drop for Permit<'_> {
+    fn drop(&mut self) {
+        let mut in_use = self.0.in_use.lock().unwrap_or_else(|e| e.into_inner());
+        *in_use -= 1;
+        self.0.released.notify_one();
+    }
+}
+
+impl Pool {
+    const fn new() -> Self {
+        Self {
+            in_use: Mutex::new(0),
+            released: Condvar::new(),
+        }
+    }
+
+    /// Wait until fewer than `jobs()` slots are in use, then take one
+    fn acquire(&self, what: &str, jobs: impl Fn() -> usize) -> Permit<'_> {
+        let mut in_use = self.in_use.lock().unwrap_or_else(|e| e.into_inner());
+        if *in_use >= jobs() {
+            log::trace!(
+                "{} queued for the network: {} of {} slots in use",
+                what,
+                *in_use,
+                jobs()
+            );
+            while *in_use >= jobs() {
+                in_use = self
+                    .released
+                    .wait(in_use)
+                    .unwrap_or_else(|e| e.into_inner());
+            }
+            log::trace!("{} got a network slot", what);
+        }
+        *in_use += 1;
+        Permit(self)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+
+    #[test]
+    fn test_acquire_runs_at_most_jobs_at_once() {
+        let pool = Pool::new();
+        let running = AtomicUsize::new(0);
+        let most = AtomicUsize::new(0);
+        std::thread::scope(|scope| {
+            for i in 0..6 {
+                let (pool, running, most) = (&pool, &running, &most);
+                scope.spawn(move || {
+                    let _permit = pool.acquire(&format!("download {}", i), || 2);
+                    let now = running.fetch_add(1, Ordering::SeqCst) + 1;
+                    most.fetch_max(now, Ordering::SeqCst);
+                    std::thread::sleep(Duration::from_millis(20));
+                    running.fetch_sub(1, Ordering::SeqCst);
+                });
+            }
+        });
+        assert_eq!(most.load(Ordering::SeqCst), 2);
+        assert_eq!(*pool.in_use.lock().unwrap(), 0);
+    }
+}
@@ -1,8 +1,21 @@
-use super::{PackageProvider, command_exists};
+use super::args::extra_args;
+use super::{PackageManager, PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
 use std::process::Command;
 
 pub struct AptProvider;
 
+/// apt-get as root, waiting for dpkg's lock as long as DHD would, in case
+/// another process takes it after DHD checked
+fn apt_get() -> Result<Command, String> {
+    let mut cmd = crate::privilege::root_command("apt-get")?;
+    cmd.arg("-o").arg(format!(
+        "DPkg::Lock::Timeout={}",
+        super::lock::timeout().as_secs()
+    ));
+    Ok(cmd)
+}
+
 impl PackageProvider for AptProvider {
     fn is_available(&self) -> bool {
         command_exists("apt-get")
@@ -12,16 +25,18 @@ impl PackageProvider for AptProvider {
         let output = Command::new("dpkg")
             .arg("-l")
             .arg(package)
-            .output()
+            .logged_output()
             .map_err(|e| format!("Failed to check package status: {}", e))?;
 
         Ok(output.status.success())
     }
 
     fn install_package(&self, package: &str) -> Result<(), String> {
-        let output = Command::new("sudo")
-            .args(["apt-get", "install", "-y", package])
-            .output()
+        let output = apt_get()?
+            .args(["install", "-y"])
+            .args(extra_args(&PackageManager::Apt))
+            .arg(package)
+            .logged_output()
             .map_err(|e| format!("Failed to install package: {}", e))?;
 
         if !output.status.success() {
@@ -35,10 +50,31 @@ impl PackageProvider for AptProvider {
         Ok(())
     }
 
+    fn install_packages(&self, packages: &[String]) -> Result<(), String> {
+        let output = apt_get()?
+            .args(["install", "-y"])
+            .args(extra_args(&PackageManager::Apt))
+            .args(packages)
+            .logged_output()
+            .map_err(|e| format!("Failed to install packages: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to install {}: {}",
+                packages.join(", "),
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
     fn uninstall_package(&self, package: &str) -> Result<(), String> {
-        let output = Command::new("sudo")
-            .args(["apt-get", "remove", "-y", package])
-            .output()
+        let output = apt_get()?
+            .args(["remove", "-y"])
+            .args(extra_args(&PackageManager::Apt))
+            .arg(package)
+            .logged_output()
             .map_err(|e| format!("Failed to uninstall package: {}", e))?;
 
         if !output.status.success() {
@@ -52,10 +88,80 @@ impl PackageProvider for AptProvider {
         Ok(())
     }
 
+    fn upgrade_package(&self, package: &str) -> Result<(), String> {
+        let output = apt_get()?
+            .args(["install", "-y", "--only-upgrade"])
+            .args(extra_args(&PackageManager::Apt))
+            .arg(package)
+            .logged_output()
+            .map_err(|e| format!("Failed to upgrade package: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to upgrade {}: {}",
+                package,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
+    fn installed_version(&self, package: &str) -> Option<String> {
+        let output = Command::new("dpkg-query")
+            .args(["-W", "-f=${Version}", package])
+            .logged_output()
+            .ok()?;
+
+        let version = String::from_utf8_lossy(&output.stdout).trim().to_string();
+        (output.status.success() && !version.is_empty()).then_some(version)
+    }
+
+    fn install_version(&self, package: &str, version: &str) -> Result<(), String> {
+        let output = apt_get()?
+            .args(["install", "-y", "--allow-downgrades"])
+            .args(extra_args(&PackageManager::Apt))
+            .arg(format!("{}={}", package, version))
+            .logged_output()
+            .map_err(|e| format!("Failed to install package: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to install {} {}: {}",
+                package,
+                version,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
+    fn available_versions(&self, package: &str) -> Option<Vec<String>> {
+        // apt-cache madison prints "<name> | <version> | <source>", newest first
+        let output = Command::new("apt-cache")
+            .args(["madison", package])
+            .logged_output()
+            .ok()?;
+        if !output.status.success() {
+            return None;
+        }
+
+        let mut versions: Vec<String> = Vec::new();
+        for line in String::from_utf8_lossy(&output.stdout).lines() {
+            if let Some(version) = line.split('|').nth(1).map(str::trim) {
+                if !version.is_empty() && !versions.iter().any(|known| known == version) {
+                    versions.push(version.to_string());
+                }
+            }
+        }
+        Some(versions)
+    }
+
     fn update(&self) -> Result<(), String> {
-        let output = Command::new("sudo")
-            .args(["apt-get", "update"])
-            .output()
+        let output = apt_get()?
+            .args(["update"])
+            .logged_output()
             .map_err(|e| format!("Failed to update package database: {}", e))?;
 
         if !output.status.success() {
@@ -0,0 +1,137 @@
+//! Extra flags for package manager commands, from `packageManagers` of
+//! `dhd.config.ts`
+//!
+//! The flags go into every command that installs, removes or upgrades
+//! packages, after DHD's own flags and before the packages, e.g. to set a
+//! proxy or a cache directory. Querying and refreshing package indexes don't
+//! get them. DHD keeps choosing the verbs and the packages; flags that would
+//! make it install something else than its checks look for are refused.
+
+use super::PackageManager;
+use std::sync::Mutex;
+
+static EXTRA_ARGS: Mutex<Vec<(PackageManager, Vec<String>)>> = Mutex::new(Vec::new());
+
+/// Pass `args` to `manager`'s installs, removals and upgrades from now on
+pub fn set_extra_args(manager: PackageManager, args: Vec<String>) {
+    let mut extra = EXTRA_ARGS.lock().unwrap_or_else(|e| e.into_inner());
+    extra.retain(|(known, _)| *known != manager);
+    if !args.is_empty() {
+        extra.push((manager, args));
+    }
+}
+
+/// The extra flags of `manager`'s installs, removals and upgrades
+pub fn extra_args(manager: &PackageManager) -> Vec<String> {
+    EXTRA_ARGS
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .iter()
+        .find(|(known, _)| known == manager)
+        .map(|(_, args)| args.clone())
+        .unwrap_or_default()
+}
+
+/// Flags of `manager` that change what its commands install or where, so
+/// DHD's checks would never see it done; `--flag` also covers `--flag=value`
+///
+/// `None` for managers DHD doesn't run a command of its own for.
+fn reserved_flags(manager: &PackageManager) -> Option<&'static [&'static str]> {
+    Some(match manager {
+        PackageManager::Apt => &[
+            "-s",
+            "--simulate",
+            "--just-print",
+            "--dry-run",
+            "--recon",
+            "--no-act",
+            "-d",
+            "--download-only",
+            "--print-uris",
+        ],
+        PackageManager::Dnf => &["--downloadonly", "--assumeno", "--installroot"],
+        PackageManager::Pacman | PackageManager::Aur => &[
+            "-p",
+            "--print",
+            "-w",
+            "--downloadonly",
+            "-r",
+            "--root",
+            "-b",
+            "--dbpath",
+            "--dbonly",
+        ],
+        PackageManager::Brew => &["-n", "--dry-run", "--formula", "--cask"],
+        PackageManager::Flatpak => &["--no-deploy", "--user", "--system", "--installation"],
+        PackageManager::Snap => &["--classic", "--channel"],
+        PackageManager::Nix => &["--dry-run", "--profile"],
+        PackageManager::Cargo => &["--root", "--list"],
+        PackageManager::Bun => &["--dry-run", "--cwd"],
+        PackageManager::Go => &["-n"],
+        PackageManager::Uv => &[],
+        _ => return None,
+    })
+}
+
+/// Check that `args` can be passed to `manager`'s commands
+pub fn validate_extra_args(manager: &PackageManager, args: &[String]) -> Result<(), String> {
+    let Some(reserved) = reserved_flags(manager) else {
+        return Err(format!(
+            "DHD runs no {} command that extraArgs could go to",
+            manager.as_str()
+        ));
+    };
+    for arg in args {
+        if arg.is_empty() {
+            return Err("extraArgs can't include an empty argument".to_string());
+        }
+        let flag = arg.split_once('=').map_or(arg.as_str(), |(flag, _)| flag);
+        if reserved.contains(&flag) {
+            return Err(format!(
+                "extraArgs can't include '{}': it changes what {} installs or where, \
+                 so DHD's checks would never see it done",
+                arg,
+                manager.as_str()
+            ));
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn args(args: &[&str]) -> Vec<String> {
+        args.iter().map(|arg| arg.to_string()).collect()
+    }
+
+    #[test]
+    fn test_extra_args_are_kept_per_manager() {
+        set_extra_args(PackageManager::Cargo, args(&["--locked"]));
+        assert_eq!(extra_args(&PackageManager::Cargo), ["--locked"]);
+        assert!(extra_args(&PackageManager::Uv).is_empty());
+
+        set_extra_args(PackageManager::Cargo, args(&["--locked", "--jobs=2"]));
+        assert_eq!(extra_args(&PackageManager::Cargo), ["--locked", "--jobs=2"]);
+
+        set_extra_args(PackageManager::Cargo, Vec::new());
+        assert!(extra_args(&PackageManager::Cargo).is_empty());
+    }
+
+    #[test]
+    fn test_reserved_flags_are_refused() {
+        let proxy = args(&["-o", "Acquire::http::Proxy=http://proxy.example.com:3128"]);
+        assert!(validate_extra_args(&PackageManager::Apt, &proxy).is_ok());
+        assert!(validate_extra_args(&PackageManager::Pacman, &args(&["--noconfirm"])).is_ok());
+
+        let err = validate_extra_args(&PackageManager::Apt, &args(&["--simulate"])).unwrap_err();
+        assert!(err.contains("'--simulate'"), "{}", err);
+        assert!(validate_extra_args(&PackageManager::Dnf, &args(&["--installroot=/mnt"])).is_err());
+        assert!(validate_extra_args(&PackageManager::Brew, &args(&["--cask"])).is_err());
+        assert!(validate_extra_args(&PackageManager::Apt, &args(&[""])).is_err());
+
+        let err = validate_extra_args(&PackageManager::Npm, &args(&["--global"])).unwrap_err();
+        assert!(err.contains("runs no npm command"), "{}", err);
+    }
+}
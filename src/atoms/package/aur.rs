@@ -0,0 +1,109 @@
+use super::args::extra_args;
+use super::{PackageManager, PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
+use std::process::Command;
+
+/// AUR helpers in order of preference
+const AUR_HELPERS: [&str; 2] = ["paru", "yay"];
+
+pub struct AurProvider;
+
+impl AurProvider {
+    /// Find the first AUR helper installed on the system
+    pub fn helper(&self) -> Option<&'static str> {
+        AUR_HELPERS.into_iter().find(|helper| command_exists(helper))
+    }
+
+    fn require_helper(&self) -> Result<&'static str, String> {
+        self.helper().ok_or_else(|| {
+            "No AUR helper found. Install paru or yay first, e.g.: \
+             sudo pacman -S --needed base-devel git && \
+             git clone https://aur.archlinux.org/paru-bin.git && \
+             cd paru-bin && makepkg -si"
+                .to_string()
+        })
+    }
+
+    /// Run the helper with `args`, followed by the config's extraArgs and
+    /// `packages` when it works on packages
+    fn run(&self, args: &[&str], packages: &[&str], action: &str) -> Result<(), String> {
+        let helper = self.require_helper()?;
+        let extra = if packages.is_empty() {
+            Vec::new()
+        } else {
+            extra_args(&PackageManager::Aur)
+        };
+
+        // AUR helpers refuse to run as root and escalate via sudo on their own
+        let output = Command::new(helper)
+            .args(args)
+            .args(extra)
+            .args(packages)
+            .logged_output()
+            .map_err(|e| format!("Failed to {} with {}: {}", action, helper, e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to {} with {}: {}",
+                action,
+                helper,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+}
+
+impl PackageProvider for AurProvider {
+    fn is_available(&self) -> bool {
+        self.helper().is_some()
+    }
+
+    fn is_package_installed(&self, package: &str) -> Result<bool, String> {
+        // AUR packages are registered in the local pacman database once built
+        let output = Command::new("pacman")
+            .arg("-Q")
+            .arg(package)
+            .logged_output()
+            .map_err(|e| format!("Failed to run pacman -Q: {}", e))?;
+
+        Ok(output.status.success())
+    }
+
+    fn install_package(&self, package: &str) -> Result<(), String> {
+        // --needed lets the helper pull repo dependencies without reinstalling them
+        self.run(
+            &["-S", "--needed", "--noconfirm"],
+            &[package],
+            &format!("install {}", package),
+        )
+    }
+
+    fn uninstall_package(&self, package: &str) -> Result<(), String> {
+        self.run(
+            &["-R", "--noconfirm"],
+            &[package],
+            &format!("uninstall {}", package),
+        )
+    }
+
+    fn update(&self) -> Result<(), String> {
+        self.run(&["-Sy"], &[], "update package databases")
+    }
+
+    fn name(&self) -> &str {
+        "aur"
+    }
+
+    fn install_command(&self) -> Vec<String> {
+        let helper = self.helper().unwrap_or(AUR_HELPERS[0]);
+        vec![
+            helper.to_string(),
+            "-S".to_string(),
+            "--needed".to_string(),
+            "--noconfirm".to_string(),
+        ]
+    }
+}
+
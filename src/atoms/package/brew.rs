@@ -1,26 +1,111 @@
-use super::{PackageProvider, command_exists};
+use super::args::extra_args;
+use super::{PackageManager, PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
+use std::path::Path;
 use std::process::Command;
 
-pub struct BrewProvider;
+/// Default install locations used when brew is not on PATH yet
+const BREW_PATHS: [&str; 3] = [
+    "/opt/homebrew/bin/brew",
+    "/usr/local/bin/brew",
+    "/home/linuxbrew/.linuxbrew/bin/brew",
+];
+
+#[derive(Default)]
+pub struct BrewProvider {
+    /// Install casks instead of formulae
+    pub cask: bool,
+    /// Third-party taps to add before installing
+    pub taps: Vec<String>,
+}
+
+impl BrewProvider {
+    pub fn new(cask: bool, taps: Vec<String>) -> Self {
+        Self { cask, taps }
+    }
+
+    fn binary(&self) -> Option<String> {
+        if command_exists("brew") {
+            return Some("brew".to_string());
+        }
+
+        BREW_PATHS
+            .into_iter()
+            .find(|path| Path::new(path).exists())
+            .map(String::from)
+    }
+
+    fn require_binary(&self) -> Result<String, String> {
+        self.binary().ok_or_else(|| {
+            "Homebrew is not installed. Install it with: \
+             /bin/bash -c \"$(curl -fsSL https://raw.githubusercontent.com/Homebrew/install/HEAD/install.sh)\""
+                .to_string()
+        })
+    }
+
+    fn kind_flag(&self) -> &'static str {
+        if self.cask { "--cask" } else { "--formula" }
+    }
+
+    /// Add any configured taps that are not present yet
+    fn ensure_taps(&self, brew: &str) -> Result<(), String> {
+        if self.taps.is_empty() {
+            return Ok(());
+        }
+
+        let output = Command::new(brew)
+            .arg("tap")
+            .logged_output()
+            .map_err(|e| format!("Failed to list taps: {}", e))?;
+        let existing = String::from_utf8_lossy(&output.stdout);
+
+        for tap in &self.taps {
+            if existing.lines().any(|line| line.trim() == tap) {
+                continue;
+            }
+
+            let output = Command::new(brew)
+                .args(["tap", tap])
+                .logged_output()
+                .map_err(|e| format!("Failed to tap {}: {}", tap, e))?;
+
+            if !output.status.success() {
+                return Err(format!(
+                    "Failed to tap {}: {}",
+                    tap,
+                    String::from_utf8_lossy(&output.stderr)
+                ));
+            }
+        }
+
+        Ok(())
+    }
+}
 
 impl PackageProvider for BrewProvider {
     fn is_available(&self) -> bool {
-        command_exists("brew")
+        self.binary().is_some()
     }
 
     fn is_package_installed(&self, package: &str) -> Result<bool, String> {
-        let output = Command::new("brew")
-            .args(["list", "--formula", package])
-            .output()
+        let brew = self.require_binary()?;
+        let output = Command::new(&brew)
+            .args(["list", self.kind_flag(), package])
+            .logged_output()
             .map_err(|e| format!("Failed to check package status: {}", e))?;
 
         Ok(output.status.success())
     }
 
     fn install_package(&self, package: &str) -> Result<(), String> {
-        let output = Command::new("brew")
-            .args(["install", package])
-            .output()
+        let brew = self.require_binary()?;
+        self.ensure_taps(&brew)?;
+
+        let output = Command::new(&brew)
+            .args(["install", self.kind_flag()])
+            .args(extra_args(&PackageManager::Brew))
+            .arg(package)
+            .logged_output()
             .map_err(|e| format!("Failed to install package: {}", e))?;
 
         if !output.status.success() {
@@ -35,9 +120,12 @@ impl PackageProvider for BrewProvider {
     }
 
     fn uninstall_package(&self, package: &str) -> Result<(), String> {
-        let output = Command::new("brew")
-            .args(["uninstall", package])
-            .output()
+        let brew = self.require_binary()?;
+        let output = Command::new(&brew)
+            .args(["uninstall", self.kind_flag()])
+            .args(extra_args(&PackageManager::Brew))
+            .arg(package)
+            .logged_output()
             .map_err(|e| format!("Failed to uninstall package: {}", e))?;
 
         if !output.status.success() {
@@ -51,10 +139,48 @@ impl PackageProvider for BrewProvider {
         Ok(())
     }
 
+    fn upgrade_package(&self, package: &str) -> Result<(), String> {
+        let brew = self.require_binary()?;
+        let output = Command::new(&brew)
+            .args(["upgrade", self.kind_flag()])
+            .args(extra_args(&PackageManager::Brew))
+            .arg(package)
+            .logged_output()
+            .map_err(|e| format!("Failed to upgrade package: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to upgrade {}: {}",
+                package,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
+    fn installed_version(&self, package: &str) -> Option<String> {
+        // Prints "<name> <version>...", newest version last
+        let output = Command::new(self.binary()?)
+            .args(["list", "--versions", self.kind_flag(), package])
+            .logged_output()
+            .ok()?;
+        if !output.status.success() {
+            return None;
+        }
+
+        String::from_utf8_lossy(&output.stdout)
+            .split_whitespace()
+            .skip(1)
+            .last()
+            .map(String::from)
+    }
+
     fn update(&self) -> Result<(), String> {
-        let output = Command::new("brew")
+        let brew = self.require_binary()?;
+        let output = Command::new(&brew)
             .args(["update"])
-            .output()
+            .logged_output()
             .map_err(|e| format!("Failed to update brew: {}", e))?;
 
         if !output.status.success() {
@@ -72,6 +198,10 @@ impl PackageProvider for BrewProvider {
     }
 
     fn install_command(&self) -> Vec<String> {
-        vec!["brew".to_string(), "install".to_string()]
+        vec![
+            "brew".to_string(),
+            "install".to_string(),
+            self.kind_flag().to_string(),
+        ]
     }
 }
@@ -1,4 +1,6 @@
-use super::{PackageProvider, command_exists};
+use super::args::extra_args;
+use super::{PackageManager, PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
 use std::process::Command;
 
 pub struct BunProvider;
@@ -23,8 +25,10 @@ impl PackageProvider for BunProvider {
 
     fn install_package(&self, package: &str) -> Result<(), String> {
         let output = Command::new("bun")
-            .args(["add", "--global", package])
-            .output()
+            .args(["add", "--global"])
+            .args(extra_args(&PackageManager::Bun))
+            .arg(package)
+            .logged_output()
             .map_err(|e| format!("Failed to install package: {}", e))?;
 
         if !output.status.success() {
@@ -40,8 +44,10 @@ impl PackageProvider for BunProvider {
 
     fn uninstall_package(&self, package: &str) -> Result<(), String> {
         let output = Command::new("bun")
-            .args(["remove", "--global", package])
-            .output()
+            .args(["remove", "--global"])
+            .args(extra_args(&PackageManager::Bun))
+            .arg(package)
+            .logged_output()
             .map_err(|e| format!("Failed to uninstall package: {}", e))?;
 
         if !output.status.success() {
@@ -59,7 +65,7 @@ impl PackageProvider for BunProvider {
         // Bun updates itself
         let output = Command::new("bun")
             .args(["upgrade"])
-            .output()
+            .logged_output()
             .map_err(|e| format!("Failed to update bun: {}", e))?;
 
         if !output.status.success() {
@@ -1,4 +1,6 @@
-use super::{PackageProvider, command_exists};
+use super::args::extra_args;
+use super::{PackageManager, PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
 use std::process::Command;
 
 pub struct CargoProvider;
@@ -18,8 +20,10 @@ impl PackageProvider for CargoProvider {
 
     fn install_package(&self, package: &str) -> Result<(), String> {
         let output = Command::new("cargo")
-            .args(["install", package])
-            .output()
+            .arg("install")
+            .args(extra_args(&PackageManager::Cargo))
+            .arg(package)
+            .logged_output()
             .map_err(|e| format!("Failed to install package: {}", e))?;
 
         if !output.status.success() {
@@ -35,8 +39,10 @@ impl PackageProvider for CargoProvider {
 
     fn uninstall_package(&self, package: &str) -> Result<(), String> {
         let output = Command::new("cargo")
-            .args(["uninstall", package])
-            .output()
+            .arg("uninstall")
+            .args(extra_args(&PackageManager::Cargo))
+            .arg(package)
+            .logged_output()
             .map_err(|e| format!("Failed to uninstall package: {}", e))?;
 
         if !output.status.success() {
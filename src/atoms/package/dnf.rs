@@ -1,4 +1,7 @@
-use super::{PackageProvider, command_exists};
+use super::args::extra_args;
+use super::{PackageManager, PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
+use std::process::Command;
 
 pub struct DnfProvider;
 
@@ -7,20 +10,160 @@ impl PackageProvider for DnfProvider {
         command_exists("dnf")
     }
 
-    fn is_package_installed(&self, _package: &str) -> Result<bool, String> {
-        todo!("Implement dnf package check")
+    fn is_package_installed(&self, package: &str) -> Result<bool, String> {
+        // rpm -q returns 0 if package is installed, 1 if not
+        let output = Command::new("rpm")
+            .args(["-q", package])
+            .logged_output()
+            .map_err(|e| format!("Failed to check package status: {}", e))?;
+
+        Ok(output.status.success())
     }
 
-    fn install_package(&self, _package: &str) -> Result<(), String> {
-        todo!("Implement dnf install")
+    fn install_package(&self, package: &str) -> Result<(), String> {
+        let output = crate::privilege::root_command("dnf")?
+            .args(["install", "-y"])
+            .args(extra_args(&PackageManager::Dnf))
+            .arg(package)
+            .logged_output()
+            .map_err(|e| format!("Failed to install package: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to install {}: {}",
+                package,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
+    fn install_packages(&self, packages: &[String]) -> Result<(), String> {
+        let output = crate::privilege::root_command("dnf")?
+            .args(["install", "-y"])
+            .args(extra_args(&PackageManager::Dnf))
+            .args(packages)
+            .logged_output()
+            .map_err(|e| format!("Failed to install packages: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to install {}: {}",
+                packages.join(", "),
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
     }
 
-    fn uninstall_package(&self, _package: &str) -> Result<(), String> {
-        todo!("Implement dnf uninstall")
+    fn uninstall_package(&self, package: &str) -> Result<(), String> {
+        let output = crate::privilege::root_command("dnf")?
+            .args(["remove", "-y"])
+            .args(extra_args(&PackageManager::Dnf))
+            .arg(package)
+            .logged_output()
+            .map_err(|e| format!("Failed to uninstall package: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to uninstall {}: {}",
+                package,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
+    fn upgrade_package(&self, package: &str) -> Result<(), String> {
+        let output = crate::privilege::root_command("dnf")?
+            .args(["upgrade", "-y"])
+            .args(extra_args(&PackageManager::Dnf))
+            .arg(package)
+            .logged_output()
+            .map_err(|e| format!("Failed to upgrade package: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to upgrade {}: {}",
+                package,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
+    fn installed_version(&self, package: &str) -> Option<String> {
+        let output = Command::new("rpm")
+            .args(["-q", "--qf", "%{VERSION}-%{RELEASE}", package])
+            .logged_output()
+            .ok()?;
+
+        let version = String::from_utf8_lossy(&output.stdout).trim().to_string();
+        (output.status.success() && !version.is_empty()).then_some(version)
+    }
+
+    fn install_version(&self, package: &str, version: &str) -> Result<(), String> {
+        // Given an exact version, dnf install upgrades or downgrades to it
+        let output = crate::privilege::root_command("dnf")?
+            .args(["install", "-y"])
+            .args(extra_args(&PackageManager::Dnf))
+            .arg(format!("{}-{}", package, version))
+            .logged_output()
+            .map_err(|e| format!("Failed to install package: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to install {} {}: {}",
+                package,
+                version,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
+    fn available_versions(&self, package: &str) -> Option<Vec<String>> {
+        // Lines are "<name>.<arch> <[epoch:]version-release> <repo>", oldest first
+        let output = Command::new("dnf")
+            .args(["list", "--showduplicates", "-q", package])
+            .logged_output()
+            .ok()?;
+        if !output.status.success() {
+            return None;
+        }
+
+        let prefix = format!("{}.", package);
+        let mut versions: Vec<String> = Vec::new();
+        for line in String::from_utf8_lossy(&output.stdout).lines().rev() {
+            let columns: Vec<&str> = line.split_whitespace().collect();
+            if let [name, version, _] = columns[..] {
+                if name.starts_with(&prefix) && !versions.iter().any(|known| known == version) {
+                    versions.push(version.to_string());
+                }
+            }
+        }
+        Some(versions)
     }
 
     fn update(&self) -> Result<(), String> {
-        todo!("Implement dnf update")
+        let output = crate::privilege::root_command("dnf")?
+            .args(["makecache"])
+            .logged_output()
+            .map_err(|e| format!("Failed to update package database: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to update package database: {}",
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
     }
 
     fn name(&self) -> &str {
@@ -1,7 +1,96 @@
-use super::{PackageProvider, command_exists};
+use super::args::extra_args;
+use super::{PackageManager, PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
 use std::process::Command;
 
-pub struct FlatpakProvider;
+const FLATHUB_REMOTE: &str = "flathub";
+const FLATHUB_URL: &str = "https://dl.flathub.org/repo/flathub.flatpakrepo";
+
+pub struct FlatpakProvider {
+    /// Remote to install applications from
+    pub remote: String,
+    /// Install into the per-user installation instead of the system one
+    pub user: bool,
+}
+
+impl Default for FlatpakProvider {
+    fn default() -> Self {
+        Self {
+            remote: FLATHUB_REMOTE.to_string(),
+            user: false,
+        }
+    }
+}
+
+impl FlatpakProvider {
+    pub fn new(remote: Option<String>, scope: Option<&str>) -> Result<Self, String> {
+        let user = match scope {
+            None | Some("system") => false,
+            Some("user") => true,
+            Some(other) => {
+                return Err(format!(
+                    "Invalid flatpak scope '{}': expected 'user' or 'system'",
+                    other
+                ));
+            }
+        };
+
+        Ok(Self {
+            remote: remote.unwrap_or_else(|| FLATHUB_REMOTE.to_string()),
+            user,
+        })
+    }
+
+    fn scope_flag(&self) -> &'static str {
+        if self.user { "--user" } else { "--system" }
+    }
+
+    fn has_remote(&self) -> Result<bool, String> {
+        let output = Command::new("flatpak")
+            .args(["remotes", self.scope_flag(), "--columns=name"])
+            .logged_output()
+            .map_err(|e| format!("Failed to list flatpak remotes: {}", e))?;
+
+        let remotes = String::from_utf8_lossy(&output.stdout);
+        Ok(remotes.lines().any(|line| line.trim() == self.remote))
+    }
+
+    /// Add the Flathub remote if it is missing; other remotes must already be configured
+    fn ensure_remote(&self) -> Result<(), String> {
+        if self.has_remote()? {
+            return Ok(());
+        }
+
+        if self.remote != FLATHUB_REMOTE {
+            return Err(format!(
+                "Flatpak remote '{}' is not configured; add it with `flatpak remote-add {} {} <url>`",
+                self.remote,
+                self.scope_flag(),
+                self.remote
+            ));
+        }
+
+        let output = Command::new("flatpak")
+            .args([
+                "remote-add",
+                "--if-not-exists",
+                self.scope_flag(),
+                FLATHUB_REMOTE,
+                FLATHUB_URL,
+            ])
+            .logged_output()
+            .map_err(|e| format!("Failed to add flathub remote: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to add flathub remote: {}",
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+}
 
 impl PackageProvider for FlatpakProvider {
     fn is_available(&self) -> bool {
@@ -10,8 +99,8 @@ impl PackageProvider for FlatpakProvider {
 
     fn is_package_installed(&self, package: &str) -> Result<bool, String> {
         let output = Command::new("flatpak")
-            .args(["list", "--app", "--columns=application"])
-            .output()
+            .args(["list", "--app", self.scope_flag(), "--columns=application"])
+            .logged_output()
             .map_err(|e| format!("Failed to check package status: {}", e))?;
 
         let installed = String::from_utf8_lossy(&output.stdout);
@@ -19,9 +108,13 @@ impl PackageProvider for FlatpakProvider {
     }
 
     fn install_package(&self, package: &str) -> Result<(), String> {
+        self.ensure_remote()?;
+
         let output = Command::new("flatpak")
-            .args(["install", "-y", "flathub", package])
-            .output()
+            .args(["install", "-y", "--noninteractive", self.scope_flag()])
+            .args(extra_args(&PackageManager::Flatpak))
+            .args([self.remote.as_str(), package])
+            .logged_output()
             .map_err(|e| format!("Failed to install package: {}", e))?;
 
         if !output.status.success() {
@@ -37,8 +130,10 @@ impl PackageProvider for FlatpakProvider {
 
     fn uninstall_package(&self, package: &str) -> Result<(), String> {
         let output = Command::new("flatpak")
-            .args(["uninstall", "-y", package])
-            .output()
+            .args(["uninstall", "-y", self.scope_flag()])
+            .args(extra_args(&PackageManager::Flatpak))
+            .arg(package)
+            .logged_output()
             .map_err(|e| format!("Failed to uninstall package: {}", e))?;
 
         if !output.status.success() {
@@ -54,8 +149,8 @@ impl PackageProvider for FlatpakProvider {
 
     fn update(&self) -> Result<(), String> {
         let output = Command::new("flatpak")
-            .args(["update", "-y"])
-            .output()
+            .args(["update", "-y", self.scope_flag()])
+            .logged_output()
             .map_err(|e| format!("Failed to update flatpak: {}", e))?;
 
         if !output.status.success() {
@@ -77,7 +172,8 @@ impl PackageProvider for FlatpakProvider {
             "flatpak".to_string(),
             "install".to_string(),
             "-y".to_string(),
-            "flathub".to_string(),
+            self.scope_flag().to_string(),
+            self.remote.clone(),
         ]
     }
 }
@@ -1,4 +1,5 @@
 use super::{PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
 use std::process::Command;
 use std::path::PathBuf;
 use std::fs;
@@ -45,7 +46,7 @@ impl GitHubProvider {
         
         let output = Command::new("curl")
             .args(&["-s", "-H", "Accept: application/vnd.github.v3+json", &url])
-            .output()
+            .logged_output()
             .map_err(|e| format!("Failed to fetch release info: {}", e))?;
         
         if !output.status.success() {
@@ -115,15 +116,15 @@ impl GitHubProvider {
         let output = if archive_path.ends_with(".tar.gz") || archive_path.ends_with(".tgz") {
             Command::new("tar")
                 .args(&["-xzf", archive_path, "-C", dest_dir])
-                .output()
+                .logged_output()
         } else if archive_path.ends_with(".tar.xz") {
             Command::new("tar")
                 .args(&["-xJf", archive_path, "-C", dest_dir])
-                .output()
+                .logged_output()
         } else if archive_path.ends_with(".zip") {
             Command::new("unzip")
                 .args(&["-q", archive_path, "-d", dest_dir])
-                .output()
+                .logged_output()
         } else {
             return Err(format!("Unsupported archive format: {}", archive_path));
         };
@@ -174,7 +175,7 @@ impl PackageProvider for GitHubProvider {
         
         let output = Command::new("curl")
             .args(&["-L", "-o", &download_path.to_string_lossy(), &download_url])
-            .output()
+            .logged_output()
             .map_err(|e| format!("Failed to download asset: {}", e))?;
         
         if !output.status.success() {
@@ -1,4 +1,6 @@
-use super::{PackageProvider, command_exists};
+use super::args::extra_args;
+use super::{PackageManager, PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
 
 pub struct GoProvider;
 
@@ -21,8 +23,10 @@ impl PackageProvider for GoProvider {
         use std::process::Command;
         
         let output = Command::new("go")
-            .args(&["install", package])
-            .output()
+            .arg("install")
+            .args(extra_args(&PackageManager::Go))
+            .arg(package)
+            .logged_output()
             .map_err(|e| format!("Failed to run go install: {}", e))?;
         
         if output.status.success() {
@@ -0,0 +1,198 @@
+//! Waiting for package databases locked by other processes
+//!
+//! DHD's own installs and removals never overlap, since each takes its
+//! backend's lock in [`PackageManager::lock`]. Another process can still hold
+//! the system's lock, like an unattended upgrade or a package manager run by
+//! hand; DHD waits for it to let go, up to `--lock-timeout`, instead of
+//! failing on the spot.
+
+use super::PackageManager;
+use std::path::Path;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::time::{Duration, Instant};
+
+/// How long to wait for a lock by default, in seconds
+pub const DEFAULT_TIMEOUT_SECS: u64 = 300;
+
+static TIMEOUT_SECS: AtomicU64 = AtomicU64::new(DEFAULT_TIMEOUT_SECS);
+
+const POLL_INTERVAL: Duration = Duration::from_millis(500);
+
+/// Wait up to `timeout` for locks from now on
+pub fn set_timeout(timeout: Duration) {
+    TIMEOUT_SECS.store(timeout.as_secs(), Ordering::Relaxed);
+}
+
+/// How long to wait for a lock held by another process
+pub fn timeout() -> Duration {
+    Duration::from_secs(TIMEOUT_SECS.load(Ordering::Relaxed))
+}
+
+/// How a package manager marks its database as in use
+enum LockFile {
+    /// Locked while the file exists
+    Exists(&'static str),
+    /// Locked while the process whose ID the file holds runs
+    Pid(&'static str),
+    /// Locked while a process holds a POSIX lock on the file
+    Posix(&'static str),
+}
+
+fn lock_files(manager: &PackageManager) -> &'static [LockFile] {
+    match manager {
+        PackageManager::Apt => &[
+            LockFile::Posix("/var/lib/dpkg/lock-frontend"),
+            LockFile::Posix("/var/lib/dpkg/lock"),
+            LockFile::Posix("/var/lib/apt/lists/lock"),
+        ],
+        PackageManager::Pacman | PackageManager::Aur => {
+            &[LockFile::Exists("/var/lib/pacman/db.lck")]
+        }
+        PackageManager::Zypper => &[LockFile::Pid("/run/zypp.pid")],
+        // dnf and yum wait for their lock by themselves
+        _ => &[],
+    }
+}
+
+/// What holds `lock`, if another process does
+fn holder(lock: &LockFile) -> Option<String> {
+    match lock {
+        LockFile::Exists(path) => Path::new(path).exists().then(|| path.to_string()),
+        LockFile::Pid(path) => {
+            let pid = std::fs::read_to_string(path)
+                .ok()?
+                .trim()
+                .parse::<u32>()
+                .ok()?;
+            (pid != std::process::id() && Path::new(&format!("/proc/{}", pid)).exists())
+                .then(|| format!("{}, held by process {}", path, pid))
+        }
+        LockFile::Posix(path) => {
+            posix_holder(Path::new(path)).map(|pid| format!("{}, held by process {}", path, pid))
+        }
+    }
+}
+
+/// The process holding a POSIX lock on `path`, from `/proc/locks`
+#[cfg(target_os = "linux")]
+fn posix_holder(path: &Path) -> Option<u32> {
+    use std::os::unix::fs::MetadataExt;
+
+    let metadata = std::fs::metadata(path).ok()?;
+    let locks = std::fs::read_to_string("/proc/locks").ok()?;
+    let dev = metadata.dev();
+    // The kernel's encoding of device numbers
+    let major = ((dev >> 8) & 0xfff) | ((dev >> 32) & 0xffff_f000);
+    let minor = (dev & 0xff) | ((dev >> 12) & 0xffff_ff00);
+    lock_holder(&locks, major, minor, metadata.ino())
+}
+
+#[cfg(not(target_os = "linux"))]
+fn posix_holder(_path: &Path) -> Option<u32> {
+    None
+}
+
+/// The process holding a lock on inode `ino` of device `major:minor` in the
+/// `/proc/locks` listing `locks`; processes waiting for it are skipped
+fn lock_holder(locks: &str, major: u64, minor: u64, ino: u64) -> Option<u32> {
+    let file = format!("{:02x}:{:02x}:{}", major, minor, ino);
+    locks
+        .lines()
+        .map(|line| line.split_whitespace().collect::<Vec<_>>())
+        // `1: POSIX  ADVISORY  WRITE 1234 08:01:131075 0 EOF`
+        .filter(|fields| fields.len() >= 6 && fields[1] != "->" && fields[5] == file)
+        .find_map(|fields| fields[4].parse().ok())
+}
+
+/// Wait until no other process holds the package database of `manager`
+///
+/// Gives up after [`timeout`], naming the lock and its holder.
+pub fn wait_until_free(manager: &PackageManager) -> Result<(), String> {
+    let name = manager.get_provider().name().to_string();
+    wait_for(
+        &name,
+        || lock_files(manager).iter().find_map(holder),
+        timeout(),
+        POLL_INTERVAL,
+    )
+}
+
+fn wait_for(
+    name: &str,
+    mut held: impl FnMut() -> Option<String>,
+    timeout: Duration,
+    interval: Duration,
+) -> Result<(), String> {
+    let started = Instant::now();
+    let mut waiting = false;
+    while let Some(lock) = held() {
+        if started.elapsed() >= timeout {
+            return Err(format!(
+                "{} is still locked by another process after {}s ({}); \
+                 try again once it finishes, or wait longer with --lock-timeout",
+                name,
+                timeout.as_secs(),
+                lock
+            ));
+        }
+        if !waiting {
+            log::warn!(
+                "{} is locked by another process ({}); waiting up to {}s",
+                name,
+                lock,
+                timeout.as_secs()
+            );
+            waiting = true;
+        }
+        std::thread::sleep(interval);
+    }
+    if waiting {
+        log::info!(
+            "{} lock released after {}s",
+            name,
+            started.elapsed().as_secs()
+        );
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_lock_holder_reads_proc_locks() {
+        let locks = "\
+1: POSIX  ADVISORY  WRITE 4242 103:02:1703989 0 EOF
+1: -> POSIX  ADVISORY  WRITE 5151 103:02:1703989 0 EOF
+2: FLOCK  ADVISORY  WRITE 777 103:02:1700000 0 EOF
+";
+        assert_eq!(lock_holder(locks, 0x103, 0x02, 1703989), Some(4242));
+        assert_eq!(lock_holder(locks, 0x103, 0x02, 1703990), None);
+        assert_eq!(lock_holder("", 0x103, 0x02, 1703989), None);
+    }
+
+    #[test]
+    fn test_wait_for_returns_once_the_lock_is_released() {
+        let mut polls = 0;
+        let held = || {
+            polls += 1;
+            (polls < 3).then(|| "/var/lib/pacman/db.lck".to_string())
+        };
+        assert!(wait_for("pacman", held, Duration::from_secs(5), Duration::ZERO).is_ok());
+    }
+
+    #[test]
+    fn test_wait_for_gives_up_after_the_timeout() {
+        let err = wait_for(
+            "apt",
+            || Some("/var/lib/dpkg/lock-frontend, held by process 4242".to_string()),
+            Duration::ZERO,
+            Duration::ZERO,
+        )
+        .unwrap_err();
+        assert!(err.contains("apt is still locked by another process after 0s"));
+        assert!(err.contains("held by process 4242"));
+        assert!(err.contains("--lock-timeout"));
+    }
+}
@@ -1,8 +1,13 @@
+use crate::platform::{LinuxDistro, Platform, current_platform};
 use dhd_macros::typescript_enum;
+use std::collections::HashMap;
 use std::process::Command;
 use std::str::FromStr;
+use std::sync::{Mutex, MutexGuard, OnceLock};
 
 pub mod apt;
+pub mod args;
+pub mod aur;
 pub mod brew;
 pub mod bun;
 pub mod cargo;
@@ -10,6 +15,8 @@ pub mod dnf;
 pub mod flatpak;
 pub mod github;
 pub mod go;
+pub mod lock;
+pub mod nix;
 pub mod npm;
 pub mod pacman;
 pub mod pip;
@@ -19,6 +26,7 @@ pub mod uv;
 #[typescript_enum]
 pub enum PackageManager {
     Apt,
+    Aur,
     Brew,
     Bun,
     Cargo,
@@ -37,6 +45,27 @@ pub enum PackageManager {
     Uv,
 }
 
+/// Manager-specific settings declared on a `packageInstall` action
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct PackageOptions {
+    /// Flatpak remote to install from (defaults to flathub)
+    pub remote: Option<String>,
+    /// Installation scope: "user" or "system"
+    pub scope: Option<String>,
+    /// Homebrew casks to install alongside formulae
+    pub casks: Vec<String>,
+    /// Homebrew taps to add before installing
+    pub taps: Vec<String>,
+    /// Nix flake reference to install from (defaults to nixpkgs)
+    pub flake: Option<String>,
+    /// Install snaps with classic confinement
+    pub classic: bool,
+    /// Snap channel to track
+    pub channel: Option<String>,
+    /// Per-distro package names, keyed by generic name then by distro or manager
+    pub overrides: HashMap<String, HashMap<String, String>>,
+}
+
 pub trait PackageProvider: Send + Sync {
     /// Check if this package manager is available on the system
     fn is_available(&self) -> bool;
@@ -58,6 +87,38 @@ pub trait PackageProvider: Send + Sync {
     /// Uninstall a package
     fn uninstall_package(&self, package: &str) -> Result<(), String>;
 
+    /// Upgrade an installed package to the newest version available
+    fn upgrade_package(&self, package: &str) -> Result<(), String> {
+        Err(format!("{} can't upgrade {}", self.name(), package))
+    }
+
+    /// Installed version of a package, if the manager reports one
+    fn installed_version(&self, _package: &str) -> Option<String> {
+        None
+    }
+
+    /// Install exactly `version` of a package, in place of any other version
+    /// installed
+    fn install_version(&self, package: &str, version: &str) -> Result<(), String> {
+        Err(format!(
+            "{} can't install pinned versions, like {}={}",
+            self.name(),
+            package,
+            version
+        ))
+    }
+
+    /// Versions of a package the manager could install, newest first, if it
+    /// can list them
+    fn available_versions(&self, _package: &str) -> Option<Vec<String>> {
+        None
+    }
+
+    /// Packages of a group the manager defines, like pacman's `xorg`
+    fn group_packages(&self, group: &str) -> Result<Vec<String>, String> {
+        Err(format!("{} has no package group {}", self.name(), group))
+    }
+
     /// Update package manager cache/database
     fn update(&self) -> Result<(), String>;
 
@@ -74,6 +135,7 @@ impl FromStr for PackageManager {
     fn from_str(s: &str) -> Result<Self, Self::Err> {
         match s.to_lowercase().as_str() {
             "apt" => Ok(PackageManager::Apt),
+            "aur" => Ok(PackageManager::Aur),
             "brew" => Ok(PackageManager::Brew),
             "bun" => Ok(PackageManager::Bun),
             "cargo" => Ok(PackageManager::Cargo),
@@ -95,27 +157,170 @@ impl FromStr for PackageManager {
     }
 }
 
+/// One lock per package backend, shared by every module running in parallel
+static BACKEND_LOCKS: OnceLock<Mutex<HashMap<&'static str, &'static Mutex<()>>>> = OnceLock::new();
+
 impl PackageManager {
+    /// The name `from_str` accepts for this manager
+    pub fn as_str(&self) -> &'static str {
+        match self {
+            PackageManager::Apt => "apt",
+            PackageManager::Aur => "aur",
+            PackageManager::Brew => "brew",
+            PackageManager::Bun => "bun",
+            PackageManager::Cargo => "cargo",
+            PackageManager::Dnf => "dnf",
+            PackageManager::Flatpak => "flatpak",
+            PackageManager::GitHub => "github",
+            PackageManager::Npm => "npm",
+            PackageManager::Pacman => "pacman",
+            PackageManager::Snap => "snap",
+            PackageManager::Go => "go",
+            PackageManager::Yum => "yum",
+            PackageManager::Zypper => "zypper",
+            PackageManager::Pip => "pip",
+            PackageManager::Gem => "gem",
+            PackageManager::Nix => "nix",
+            PackageManager::Uv => "uv",
+        }
+    }
+
+    /// Name of the lock this manager holds while changing packages
+    ///
+    /// Managers that share a package database share a lock.
+    pub fn lock_name(&self) -> &'static str {
+        match self {
+            PackageManager::Apt => "apt",
+            PackageManager::Pacman | PackageManager::Aur => "pacman",
+            PackageManager::Dnf | PackageManager::Yum => "dnf",
+            PackageManager::Zypper => "zypper",
+            PackageManager::Brew => "brew",
+            PackageManager::Bun => "bun",
+            PackageManager::Cargo => "cargo",
+            PackageManager::Flatpak => "flatpak",
+            PackageManager::GitHub => "github",
+            PackageManager::Npm => "npm",
+            PackageManager::Snap => "snap",
+            PackageManager::Go => "go",
+            PackageManager::Pip => "pip",
+            PackageManager::Gem => "gem",
+            PackageManager::Nix => "nix",
+            PackageManager::Uv => "uv",
+        }
+    }
+
+    /// Whether many packages install faster with one command than one by one,
+    /// which is what makes batching the installs of several modules worth it
+    pub fn installs_in_batches(&self) -> bool {
+        matches!(
+            self,
+            PackageManager::Apt | PackageManager::Dnf | PackageManager::Pacman
+        )
+    }
+
+    /// Take this backend's lock so installs and removals never overlap, then
+    /// wait for other processes to release the system's package database
+    pub fn lock(&self) -> Result<MutexGuard<'static, ()>, String> {
+        let lock = {
+            let mut locks = BACKEND_LOCKS
+                .get_or_init(Default::default)
+                .lock()
+                .unwrap_or_else(|e| e.into_inner());
+            // The set of backends is fixed, so leaking one mutex per backend is bounded
+            *locks
+                .entry(self.lock_name())
+                .or_insert_with(|| Box::leak(Box::new(Mutex::new(()))))
+        };
+
+        let guard = lock.lock().unwrap_or_else(|e| e.into_inner());
+        lock::wait_until_free(self)?;
+        Ok(guard)
+    }
+
     pub fn get_provider(&self) -> Box<dyn PackageProvider> {
         match self {
             PackageManager::Apt => Box::new(apt::AptProvider),
-            PackageManager::Brew => Box::new(brew::BrewProvider),
+            PackageManager::Aur => Box::new(aur::AurProvider),
+            PackageManager::Brew => Box::new(brew::BrewProvider::default()),
             PackageManager::Bun => Box::new(bun::BunProvider),
             PackageManager::Cargo => Box::new(cargo::CargoProvider),
             PackageManager::Dnf => Box::new(dnf::DnfProvider),
-            PackageManager::Flatpak => Box::new(flatpak::FlatpakProvider),
+            PackageManager::Flatpak => Box::new(flatpak::FlatpakProvider::default()),
             PackageManager::GitHub => Box::new(github::GitHubProvider),
             PackageManager::Npm => Box::new(npm::NpmProvider),
             PackageManager::Pacman => Box::new(pacman::PacmanProvider),
-            PackageManager::Snap => Box::new(snap::SnapProvider),
+            PackageManager::Snap => Box::new(snap::SnapProvider::default()),
             PackageManager::Go => Box::new(go::GoProvider),
+            PackageManager::Nix => Box::new(nix::NixProvider::default()),
             PackageManager::Pip => Box::new(pip::PipProvider),
             PackageManager::Uv => Box::new(uv::UvProvider),
             _ => panic!("Provider not implemented for {:?}", self),
         }
     }
 
+    /// Get a provider configured with the action's manager-specific options
+    pub fn get_provider_with_options(
+        &self,
+        options: &PackageOptions,
+    ) -> Result<Box<dyn PackageProvider>, String> {
+        match self {
+            PackageManager::Flatpak => Ok(Box::new(flatpak::FlatpakProvider::new(
+                options.remote.clone(),
+                options.scope.as_deref(),
+            )?)),
+            PackageManager::Brew => Ok(Box::new(brew::BrewProvider::new(
+                false,
+                options.taps.clone(),
+            ))),
+            PackageManager::Nix => Ok(Box::new(nix::NixProvider::new(options.flake.clone()))),
+            PackageManager::Snap => Ok(Box::new(snap::SnapProvider::new(
+                options.classic,
+                options.channel.clone(),
+            ))),
+            _ => Ok(self.get_provider()),
+        }
+    }
+
+    /// The native system package manager for a platform
+    pub fn for_platform(platform: &Platform) -> Option<Self> {
+        match platform {
+            Platform::Linux(distro) => match distro {
+                LinuxDistro::Ubuntu | LinuxDistro::Debian => Some(PackageManager::Apt),
+                LinuxDistro::Fedora => Some(PackageManager::Dnf),
+                LinuxDistro::Arch => Some(PackageManager::Pacman),
+                LinuxDistro::NixOS => Some(PackageManager::Nix),
+                LinuxDistro::Other => None,
+            },
+            Platform::MacOS => Some(PackageManager::Brew),
+            _ => None,
+        }
+    }
+
+    /// Whether this manager only exists on Linux systems
+    pub fn is_linux_only(&self) -> bool {
+        matches!(
+            self,
+            PackageManager::Apt
+                | PackageManager::Aur
+                | PackageManager::Dnf
+                | PackageManager::Flatpak
+                | PackageManager::Pacman
+                | PackageManager::Snap
+                | PackageManager::Yum
+                | PackageManager::Zypper
+        )
+    }
+
+    /// Detect the host package manager from `/etc/os-release`, falling back to
+    /// the first package manager found on PATH that works on this OS
     pub fn detect() -> Option<Self> {
+        let platform = current_platform();
+        if let Some(manager) = Self::for_platform(&platform) {
+            if manager.get_provider().is_available() {
+                return Some(manager);
+            }
+        }
+
         let managers = [
             PackageManager::Apt,
             PackageManager::Dnf,
@@ -133,10 +338,114 @@ impl PackageManager {
 
         managers
             .into_iter()
+            .filter(|manager| platform.is_linux() || !manager.is_linux_only())
             .find(|manager| manager.get_provider().is_available())
     }
 }
 
+impl PackageOptions {
+    /// Resolve the package name to install for the given manager on this platform
+    ///
+    /// Overrides are looked up by manager name (`apt`), then distro id (`ubuntu`),
+    /// then the distro it derives from (`debian`), then the CPU architecture
+    /// (`aarch64`), falling back to the generic name.
+    ///
+    /// A pinned `name=version` keeps its version with the resolved name.
+    pub fn resolve_name(&self, package: &str, manager: &PackageManager, platform: &Platform) -> String {
+        let (name, version) = split_version(package);
+        let Some(names) = self.overrides.get(name) else {
+            return package.to_string();
+        };
+
+        let mut keys = vec![manager.get_provider().name().to_string()];
+        match platform {
+            Platform::Linux(distro) => {
+                keys.push(distro.id().to_string());
+                if let Some(family) = distro.family() {
+                    keys.push(family.id().to_string());
+                }
+            }
+            Platform::MacOS => keys.push("macos".to_string()),
+            _ => {}
+        }
+        let arch = crate::platform::current_arch();
+        let by_arch = names
+            .iter()
+            .find(|(key, _)| crate::platform::normalize_arch(key) == arch);
+
+        let name = match keys.iter().find_map(|key| names.get(key)) {
+            Some(name) => name.as_str(),
+            None => match by_arch {
+                Some((_, variant)) => {
+                    log::info!("Using {} for package {} on {}", variant, name, arch);
+                    variant.as_str()
+                }
+                None => name,
+            },
+        };
+        match version {
+            Some(version) => format!("{}={}", name, version),
+            None => name.to_string(),
+        }
+    }
+}
+
+/// Split a package into its name and the version it's pinned to, as in
+/// `docker=24.0.7`
+pub fn split_version(package: &str) -> (&str, Option<&str>) {
+    match package.split_once('=') {
+        Some((name, version)) if !name.is_empty() && !version.is_empty() => (name, Some(version)),
+        _ => (package, None),
+    }
+}
+
+/// Whether an installed version is the pinned one
+///
+/// The pin may leave out the epoch and the package release, so `24.0.7`
+/// matches `5:24.0.7-1~ubuntu.22.04`.
+pub fn version_matches(installed: &str, pinned: &str) -> bool {
+    let installed = match installed.split_once(':') {
+        Some((epoch, version))
+            if !pinned.contains(':') && epoch.chars().all(|c| c.is_ascii_digit()) =>
+        {
+            version
+        }
+        _ => installed,
+    };
+    installed == pinned
+        || installed
+            .strip_prefix(pinned)
+            .is_some_and(|rest| rest.starts_with(['-', '+', '~']))
+}
+
+/// The newest of `available` matching `pinned`, or an error listing them
+pub fn pick_version(
+    manager: &str,
+    package: &str,
+    pinned: &str,
+    available: &[String],
+) -> Result<String, String> {
+    if let Some(version) = available
+        .iter()
+        .find(|version| version_matches(version, pinned))
+    {
+        return Ok(version.clone());
+    }
+    if available.is_empty() {
+        return Err(format!(
+            "{} {} isn't available from {}, which has no versions of it",
+            package, pinned, manager
+        ));
+    }
+    Err(format!(
+        "{} {} isn't available from {}; available versions: {}",
+        package,
+        pinned,
+        manager,
+        available.join(", ")
+    ))
+}
+
 /// Helper function to check if a command exists
 pub fn command_exists(cmd: &str) -> bool {
     Command::new("which")
@@ -145,3 +454,154 @@ pub fn command_exists(cmd: &str) -> bool {
         .map(|output| output.status.success())
         .unwrap_or(false)
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn fd_overrides() -> PackageOptions {
+        let mut names = HashMap::new();
+        names.insert("debian".to_string(), "fd-find".to_string());
+        names.insert("arch".to_string(), "fd".to_string());
+
+        let mut options = PackageOptions::default();
+        options.overrides.insert("fd".to_string(), names);
+        options
+    }
+
+    #[test]
+    fn test_linux_only_managers() {
+        assert!(PackageManager::Apt.is_linux_only());
+        assert!(PackageManager::Flatpak.is_linux_only());
+        assert!(!PackageManager::Brew.is_linux_only());
+        assert!(!PackageManager::Cargo.is_linux_only());
+    }
+
+    #[test]
+    fn test_for_platform() {
+        assert_eq!(
+            PackageManager::for_platform(&Platform::Linux(LinuxDistro::Arch)),
+            Some(PackageManager::Pacman)
+        );
+        assert_eq!(
+            PackageManager::for_platform(&Platform::Linux(LinuxDistro::Ubuntu)),
+            Some(PackageManager::Apt)
+        );
+        assert_eq!(
+            PackageManager::for_platform(&Platform::Linux(LinuxDistro::Fedora)),
+            Some(PackageManager::Dnf)
+        );
+        assert_eq!(
+            PackageManager::for_platform(&Platform::MacOS),
+            Some(PackageManager::Brew)
+        );
+        assert_eq!(
+            PackageManager::for_platform(&Platform::Linux(LinuxDistro::Other)),
+            None
+        );
+    }
+
+    #[test]
+    fn test_resolve_name_uses_distro_override() {
+        let options = fd_overrides();
+        let arch = Platform::Linux(LinuxDistro::Arch);
+        assert_eq!(options.resolve_name("fd", &PackageManager::Pacman, &arch), "fd");
+
+        let debian = Platform::Linux(LinuxDistro::Debian);
+        assert_eq!(options.resolve_name("fd", &PackageManager::Apt, &debian), "fd-find");
+    }
+
+    #[test]
+    fn test_resolve_name_uses_distro_family() {
+        let options = fd_overrides();
+        let ubuntu = Platform::Linux(LinuxDistro::Ubuntu);
+        assert_eq!(options.resolve_name("fd", &PackageManager::Apt, &ubuntu), "fd-find");
+    }
+
+    #[test]
+    fn test_resolve_name_without_override() {
+        let options = fd_overrides();
+        let fedora = Platform::Linux(LinuxDistro::Fedora);
+        assert_eq!(options.resolve_name("fd", &PackageManager::Dnf, &fedora), "fd");
+        assert_eq!(options.resolve_name("git", &PackageManager::Dnf, &fedora), "git");
+    }
+
+    #[test]
+    fn test_resolve_name_uses_arch_override() {
+        let mut options = fd_overrides();
+        let mut names = HashMap::new();
+        names.insert(std::env::consts::ARCH.to_string(), "tool-native".to_string());
+        names.insert("fedora".to_string(), "tool-fedora".to_string());
+        options.overrides.insert("tool".to_string(), names);
+
+        let debian = Platform::Linux(LinuxDistro::Debian);
+        assert_eq!(options.resolve_name("tool", &PackageManager::Apt, &debian), "tool-native");
+        // Distro overrides are more specific
+        let fedora = Platform::Linux(LinuxDistro::Fedora);
+        assert_eq!(options.resolve_name("tool", &PackageManager::Dnf, &fedora), "tool-fedora");
+    }
+
+    #[test]
+    fn test_resolve_name_keeps_the_pinned_version() {
+        let options = fd_overrides();
+        let debian = Platform::Linux(LinuxDistro::Debian);
+        assert_eq!(
+            options.resolve_name("fd=8.7.0", &PackageManager::Apt, &debian),
+            "fd-find=8.7.0"
+        );
+        assert_eq!(
+            options.resolve_name("git=2.43", &PackageManager::Apt, &debian),
+            "git=2.43"
+        );
+    }
+
+    #[test]
+    fn test_pinned_versions() {
+        assert_eq!(split_version("docker=24.0.7"), ("docker", Some("24.0.7")));
+        assert_eq!(split_version("docker"), ("docker", None));
+        assert_eq!(split_version("docker="), ("docker=", None));
+
+        assert!(version_matches("24.0.7", "24.0.7"));
+        assert!(version_matches("5:24.0.7-1~ubuntu.22.04", "24.0.7"));
+        assert!(version_matches("3:24.0.7-1.fc39", "3:24.0.7-1.fc39"));
+        assert!(!version_matches("24.0.70-1", "24.0.7"));
+        assert!(!version_matches("1:24.0.7-1", "2:24.0.7"));
+
+        let available = vec![
+            "24.0.9-1".to_string(),
+            "24.0.7-3".to_string(),
+            "24.0.7-1".to_string(),
+        ];
+        assert_eq!(
+            pick_version("apt", "docker", "24.0.7", &available),
+            Ok("24.0.7-3".to_string())
+        );
+        assert_eq!(
+            pick_version("apt", "docker", "23.0.1", &available),
+            Err("docker 23.0.1 isn't available from apt; available versions: 24.0.9-1, 24.0.7-3, 24.0.7-1".to_string())
+        );
+    }
+
+    #[test]
+    fn test_backends_sharing_a_database_share_a_lock() {
+        assert_eq!(PackageManager::Aur.lock_name(), PackageManager::Pacman.lock_name());
+        assert_eq!(PackageManager::Yum.lock_name(), PackageManager::Dnf.lock_name());
+        assert_ne!(PackageManager::Apt.lock_name(), PackageManager::Flatpak.lock_name());
+    }
+
+    #[test]
+    fn test_backend_lock_is_exclusive() {
+        let guard = PackageManager::Zypper.lock().unwrap();
+        let lock = BACKEND_LOCKS.get().unwrap().lock().unwrap()["zypper"];
+        assert!(lock.try_lock().is_err());
+        drop(guard);
+        assert!(lock.try_lock().is_ok());
+    }
+
+    #[test]
+    fn test_as_str_round_trips() {
+        for manager in [PackageManager::GitHub, PackageManager::Aur, PackageManager::Uv] {
+            assert_eq!(manager.as_str().parse::<PackageManager>(), Ok(manager));
+        }
+    }
+}
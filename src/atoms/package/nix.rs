@@ -0,0 +1,204 @@
+use super::args::extra_args;
+use super::{PackageManager, PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
+use std::process::Command;
+
+const DEFAULT_FLAKE: &str = "nixpkgs";
+
+pub struct NixProvider {
+    /// Flake reference packages are installed from, e.g. `github:NixOS/nixpkgs/nixos-24.05`
+    pub flake: String,
+}
+
+impl Default for NixProvider {
+    fn default() -> Self {
+        Self {
+            flake: DEFAULT_FLAKE.to_string(),
+        }
+    }
+}
+
+impl NixProvider {
+    pub fn new(flake: Option<String>) -> Self {
+        Self {
+            flake: flake.unwrap_or_else(|| DEFAULT_FLAKE.to_string()),
+        }
+    }
+
+    /// Check that the `nix-command` and `flakes` experimental features are enabled
+    fn flakes_enabled(&self) -> bool {
+        // `nix config show` replaced `nix show-config` in newer releases
+        let output = Command::new("nix")
+            .args(["config", "show", "experimental-features"])
+            .logged_output()
+            .ok()
+            .filter(|output| output.status.success())
+            .or_else(|| Command::new("nix").arg("show-config").logged_output().ok());
+
+        let Some(output) = output else {
+            return false;
+        };
+
+        let config = String::from_utf8_lossy(&output.stdout);
+        config.lines().any(|line| {
+            // `show-config` prints `key = value` lines, `config show <key>` prints the bare value
+            let features = match line.split_once('=') {
+                Some((key, value)) if key.trim() == "experimental-features" => value,
+                Some(_) => return false,
+                None => line,
+            };
+            let features: Vec<&str> = features.split_whitespace().collect();
+            features.contains(&"flakes") && features.contains(&"nix-command")
+        })
+    }
+
+    fn require_flakes(&self) -> Result<(), String> {
+        if !command_exists("nix") {
+            return Err("Nix is not installed; see https://nixos.org/download".to_string());
+        }
+
+        if !self.flakes_enabled() {
+            return Err(
+                "Nix flakes are not enabled. Add `experimental-features = nix-command flakes` \
+                 to ~/.config/nix/nix.conf or /etc/nix/nix.conf"
+                    .to_string(),
+            );
+        }
+
+        Ok(())
+    }
+
+    fn installable(&self, package: &str) -> String {
+        format!("{}#{}", self.flake, package)
+    }
+
+    /// Collect the attribute paths and names of everything in the user profile
+    fn installed_elements(&self) -> Result<Vec<String>, String> {
+        let output = Command::new("nix")
+            .args(["profile", "list", "--json"])
+            .logged_output()
+            .map_err(|e| format!("Failed to run nix profile list: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to run nix profile list: {}",
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        let json: serde_json::Value = serde_json::from_slice(&output.stdout)
+            .map_err(|e| format!("Failed to parse nix profile list output: {}", e))?;
+
+        let mut names = Vec::new();
+        // Newer releases key elements by name; older ones return an array
+        match json.get("elements") {
+            Some(serde_json::Value::Object(elements)) => {
+                for (name, element) in elements {
+                    names.push(name.clone());
+                    if let Some(attr_path) = element.get("attrPath").and_then(|v| v.as_str()) {
+                        names.push(attr_path.to_string());
+                    }
+                }
+            }
+            Some(serde_json::Value::Array(elements)) => {
+                for element in elements {
+                    if let Some(attr_path) = element.get("attrPath").and_then(|v| v.as_str()) {
+                        names.push(attr_path.to_string());
+                    }
+                }
+            }
+            _ => {}
+        }
+
+        Ok(names)
+    }
+}
+
+impl PackageProvider for NixProvider {
+    fn is_available(&self) -> bool {
+        command_exists("nix")
+    }
+
+    fn is_package_installed(&self, package: &str) -> Result<bool, String> {
+        self.require_flakes()?;
+
+        let suffix = format!(".{}", package);
+        Ok(self
+            .installed_elements()?
+            .iter()
+            .any(|element| element == package || element.ends_with(&suffix)))
+    }
+
+    fn install_package(&self, package: &str) -> Result<(), String> {
+        self.require_flakes()?;
+
+        let installable = self.installable(package);
+        let output = Command::new("nix")
+            .args(["profile", "install"])
+            .args(extra_args(&PackageManager::Nix))
+            .arg(&installable)
+            .logged_output()
+            .map_err(|e| format!("Failed to install package: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to install {}: {}",
+                installable,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
+    fn uninstall_package(&self, package: &str) -> Result<(), String> {
+        self.require_flakes()?;
+
+        let output = Command::new("nix")
+            .args(["profile", "remove"])
+            .args(extra_args(&PackageManager::Nix))
+            .arg(package)
+            .logged_output()
+            .map_err(|e| format!("Failed to uninstall package: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to uninstall {}: {}",
+                package,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
+    fn update(&self) -> Result<(), String> {
+        self.require_flakes()?;
+
+        let output = Command::new("nix")
+            .args(["profile", "upgrade", "--all"])
+            .logged_output()
+            .map_err(|e| format!("Failed to upgrade nix profile: {}", e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to upgrade nix profile: {}",
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+
+    fn name(&self) -> &str {
+        "nix"
+    }
+
+    fn install_command(&self) -> Vec<String> {
+        vec![
+            "nix".to_string(),
+            "profile".to_string(),
+            "install".to_string(),
+        ]
+    }
+}
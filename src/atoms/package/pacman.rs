@@ -1,7 +1,65 @@
-use super::{PackageProvider, command_exists};
+use super::args::extra_args;
+use super::{PackageManager, PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
+use std::path::PathBuf;
+
+/// Where pacman keeps the packages it downloaded, older versions included
+const PACKAGE_CACHE: &str = "/var/cache/pacman/pkg";
 
 pub struct PacmanProvider;
 
+impl PacmanProvider {
+    /// The version of a package in the sync databases
+    fn repo_version(&self, package: &str) -> Option<String> {
+        use std::process::Command;
+
+        let output = Command::new("pacman")
+            .args(["-Si", package])
+            .logged_output()
+            .ok()?;
+        if !output.status.success() {
+            return None;
+        }
+
+        String::from_utf8_lossy(&output.stdout)
+            .lines()
+            .find_map(|line| {
+                let (key, value) = line.split_once(':')?;
+                (key.trim() == "Version").then(|| value.trim().to_string())
+            })
+    }
+
+    /// Versions of a package in the package cache, with their files
+    fn cached_versions(&self, package: &str) -> Vec<(String, PathBuf)> {
+        let Ok(entries) = std::fs::read_dir(PACKAGE_CACHE) else {
+            return Vec::new();
+        };
+
+        let mut versions = Vec::new();
+        for entry in entries.flatten() {
+            let file_name = entry.file_name().to_string_lossy().to_string();
+            if file_name.ends_with(".sig") {
+                continue;
+            }
+            // Files are "<name>-<version>-<release>-<arch>.pkg.tar.<compression>"
+            let Some(rest) = file_name.strip_prefix(&format!("{}-", package)) else {
+                continue;
+            };
+            let Some((rest, _)) = rest.split_once(".pkg.tar") else {
+                continue;
+            };
+            // Another package whose name starts with this one's, like docker-compose
+            if !rest.starts_with(|c: char| c.is_ascii_digit()) {
+                continue;
+            }
+            if let Some((version, _arch)) = rest.rsplit_once('-') {
+                versions.push((version.to_string(), entry.path()));
+            }
+        }
+        versions
+    }
+}
+
 impl PackageProvider for PacmanProvider {
     fn is_available(&self) -> bool {
         command_exists("pacman")
@@ -13,7 +71,7 @@ impl PackageProvider for PacmanProvider {
         let output = Command::new("pacman")
             .arg("-Q")
             .arg(package)
-            .output()
+            .logged_output()
             .map_err(|e| format!("Failed to run pacman -Q: {}", e))?;
 
         // pacman -Q returns 0 if package is installed, 1 if not
@@ -24,10 +82,14 @@ impl PackageProvider for PacmanProvider {
         use std::process::Command;
 
         let mut cmd = Command::new("pkexec");
-        cmd.arg("pacman").arg("-S").arg("--noconfirm").arg(package);
+        cmd.arg("pacman")
+            .arg("-S")
+            .arg("--noconfirm")
+            .args(extra_args(&PackageManager::Pacman))
+            .arg(package);
 
         let output = cmd
-            .output()
+            .logged_output()
             .map_err(|e| format!("Failed to run pacman install: {}", e))?;
 
         if output.status.success() {
@@ -38,10 +100,147 @@ impl PackageProvider for PacmanProvider {
         }
     }
 
+    fn install_packages(&self, packages: &[String]) -> Result<(), String> {
+        use std::process::Command;
+
+        let mut cmd = Command::new("pkexec");
+        cmd.arg("pacman")
+            .arg("-S")
+            .arg("--noconfirm")
+            .args(extra_args(&PackageManager::Pacman))
+            .args(packages);
+
+        let output = cmd
+            .logged_output()
+            .map_err(|e| format!("Failed to run pacman install: {}", e))?;
+
+        if output.status.success() {
+            Ok(())
+        } else {
+            let stderr = String::from_utf8_lossy(&output.stderr);
+            Err(format!(
+                "Failed to install packages {}: {}",
+                packages.join(", "),
+                stderr
+            ))
+        }
+    }
+
     fn uninstall_package(&self, _package: &str) -> Result<(), String> {
         todo!("Implement pacman uninstall")
     }
 
+    fn upgrade_package(&self, package: &str) -> Result<(), String> {
+        use std::process::Command;
+
+        // --needed leaves the package alone when the synced version is installed
+        let output = Command::new("pkexec")
+            .args(["pacman", "-S", "--needed", "--noconfirm"])
+            .args(extra_args(&PackageManager::Pacman))
+            .arg(package)
+            .logged_output()
+            .map_err(|e| format!("Failed to run pacman upgrade: {}", e))?;
+
+        if output.status.success() {
+            Ok(())
+        } else {
+            let stderr = String::from_utf8_lossy(&output.stderr);
+            Err(format!("Failed to upgrade package {}: {}", package, stderr))
+        }
+    }
+
+    fn installed_version(&self, package: &str) -> Option<String> {
+        use std::process::Command;
+
+        // pacman -Q prints "<name> <version>"
+        let output = Command::new("pacman")
+            .args(["-Q", package])
+            .logged_output()
+            .ok()?;
+        if !output.status.success() {
+            return None;
+        }
+
+        String::from_utf8_lossy(&output.stdout)
+            .split_whitespace()
+            .nth(1)
+            .map(String::from)
+    }
+
+    fn install_version(&self, package: &str, version: &str) -> Result<(), String> {
+        // The sync databases only have the current version; older ones can
+        // come from the package cache
+        let mut cmd = crate::privilege::root_command("pacman")?;
+        if self.repo_version(package).as_deref() == Some(version) {
+            cmd.args(["-S", "--noconfirm"])
+                .args(extra_args(&PackageManager::Pacman))
+                .arg(package);
+        } else {
+            let (_, file) = self
+                .cached_versions(package)
+                .into_iter()
+                .find(|(cached, _)| cached == version)
+                .ok_or_else(|| {
+                    format!(
+                        "{} {} is neither in the sync databases nor in {}",
+                        package, version, PACKAGE_CACHE
+                    )
+                })?;
+            cmd.args(["-U", "--noconfirm"])
+                .args(extra_args(&PackageManager::Pacman))
+                .arg(file);
+        }
+
+        let output = cmd
+            .logged_output()
+            .map_err(|e| format!("Failed to run pacman install: {}", e))?;
+
+        if output.status.success() {
+            Ok(())
+        } else {
+            let stderr = String::from_utf8_lossy(&output.stderr);
+            Err(format!(
+                "Failed to install package {} {}: {}",
+                package, version, stderr
+            ))
+        }
+    }
+
+    fn available_versions(&self, package: &str) -> Option<Vec<String>> {
+        let mut versions: Vec<String> = self.repo_version(package).into_iter().collect();
+        let mut cached: Vec<String> = self
+            .cached_versions(package)
+            .into_iter()
+            .map(|(version, _)| version)
+            .filter(|version| !versions.contains(version))
+            .collect();
+        // Newest first, as far as comparing the names tells
+        cached.sort_by(|a, b| b.cmp(a));
+        cached.dedup();
+        versions.extend(cached);
+        Some(versions)
+    }
+
+    fn group_packages(&self, group: &str) -> Result<Vec<String>, String> {
+        use std::process::Command;
+
+        // pacman -Sgq prints the packages of a group in the sync databases, one per line
+        let output = Command::new("pacman")
+            .args(["-Sgq", group])
+            .logged_output()
+            .map_err(|e| format!("Failed to run pacman -Sg: {}", e))?;
+        let packages: Vec<String> = String::from_utf8_lossy(&output.stdout)
+            .lines()
+            .map(str::trim)
+            .filter(|line| !line.is_empty())
+            .map(String::from)
+            .collect();
+        if !output.status.success() || packages.is_empty() {
+            return Err(format!("pacman has no package group {}", group));
+        }
+        Ok(packages)
+    }
+
     fn update(&self) -> Result<(), String> {
         todo!("Implement pacman update")
     }
@@ -1,26 +1,96 @@
-use super::{PackageProvider, command_exists};
+use super::args::extra_args;
+use super::{PackageManager, PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
+use std::process::Command;
 
-pub struct SnapProvider;
+#[derive(Default)]
+pub struct SnapProvider {
+    /// Install with `--classic` confinement
+    pub classic: bool,
+    /// Channel to track, e.g. `latest/stable` or `beta`
+    pub channel: Option<String>,
+}
+
+impl SnapProvider {
+    pub fn new(classic: bool, channel: Option<String>) -> Self {
+        Self { classic, channel }
+    }
+
+    fn require_snapd(&self) -> Result<(), String> {
+        if command_exists("snap") {
+            Ok(())
+        } else {
+            Err("snapd is not installed. Install it with `sudo apt install snapd` \
+                 (or your distribution's snapd package) and log in again"
+                .to_string())
+        }
+    }
+
+    /// Run snap with `args`, followed by the config's extraArgs and
+    /// `packages` when it works on packages
+    fn run(&self, args: &[&str], packages: &[&str], action: &str) -> Result<(), String> {
+        self.require_snapd()?;
+        let extra = if packages.is_empty() {
+            Vec::new()
+        } else {
+            extra_args(&PackageManager::Snap)
+        };
+
+        let output = crate::privilege::root_command("snap")?
+            .args(args)
+            .args(extra)
+            .args(packages)
+            .logged_output()
+            .map_err(|e| format!("Failed to {}: {}", action, e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to {}: {}",
+                action,
+                String::from_utf8_lossy(&output.stderr)
+            ));
+        }
+
+        Ok(())
+    }
+}
 
 impl PackageProvider for SnapProvider {
     fn is_available(&self) -> bool {
         command_exists("snap")
     }
 
-    fn is_package_installed(&self, _package: &str) -> Result<bool, String> {
-        todo!("Implement snap package check")
+    fn is_package_installed(&self, package: &str) -> Result<bool, String> {
+        self.require_snapd()?;
+
+        // snap list exits non-zero when the snap is not installed
+        let output = Command::new("snap")
+            .args(["list", package])
+            .logged_output()
+            .map_err(|e| format!("Failed to check package status: {}", e))?;
+
+        Ok(output.status.success())
     }
 
-    fn install_package(&self, _package: &str) -> Result<(), String> {
-        todo!("Implement snap install")
+    fn install_package(&self, package: &str) -> Result<(), String> {
+        let mut args = vec!["install"];
+        if self.classic {
+            args.push("--classic");
+        }
+        let channel = self.channel.as_ref().map(|c| format!("--channel={}", c));
+        if let Some(channel) = &channel {
+            args.push(channel.as_str());
+        }
+
+        self.run(&args, &[package], &format!("install {}", package))
     }
 
-    fn uninstall_package(&self, _package: &str) -> Result<(), String> {
-        todo!("Implement snap uninstall")
+    fn uninstall_package(&self, package: &str) -> Result<(), String> {
+        self.run(&["remove"], &[package], &format!("uninstall {}", package))
     }
 
     fn update(&self) -> Result<(), String> {
-        todo!("Implement snap update")
+        self.run(&["refresh"], &[], "refresh snaps")
     }
 
     fn name(&self) -> &str {
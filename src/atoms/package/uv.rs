@@ -1,4 +1,6 @@
-use super::{PackageProvider, command_exists};
+use super::args::extra_args;
+use super::{PackageManager, PackageProvider, command_exists};
+use crate::logging::LoggedCommand;
 
 pub struct UvProvider;
 
@@ -13,7 +15,7 @@ impl PackageProvider for UvProvider {
         // uv tool list shows all installed tools
         let output = Command::new("uv")
             .args(&["tool", "list"])
-            .output()
+            .logged_output()
             .map_err(|e| format!("Failed to run uv tool list: {}", e))?;
         
         if output.status.success() {
@@ -32,8 +34,10 @@ impl PackageProvider for UvProvider {
         use std::process::Command;
         
         let output = Command::new("uv")
-            .args(&["tool", "install", package])
-            .output()
+            .args(["tool", "install"])
+            .args(extra_args(&PackageManager::Uv))
+            .arg(package)
+            .logged_output()
             .map_err(|e| format!("Failed to run uv tool install: {}", e))?;
         
         if output.status.success() {
@@ -50,8 +54,10 @@ impl PackageProvider for UvProvider {
         use std::process::Command;
         
         let output = Command::new("uv")
-            .args(&["tool", "uninstall", package])
-            .output()
+            .args(["tool", "uninstall"])
+            .args(extra_args(&PackageManager::Uv))
+            .arg(package)
+            .logged_output()
             .map_err(|e| format!("Failed to run uv tool uninstall: {}", e))?;
         
         if output.status.success() {
@@ -70,7 +76,7 @@ impl PackageProvider for UvProvider {
         // uv tool upgrade upgrades all installed tools
         let output = Command::new("uv")
             .args(&["tool", "upgrade", "--all"])
-            .output()
+            .logged_output()
             .map_err(|e| format!("Failed to run uv tool upgrade: {}", e))?;
         
         if output.status.success() {
@@ -0,0 +1,435 @@
+use crate::atoms::block_in_file::{BlockInFile, file_change, write_file};
+use crate::atoms::gpg_key::{GpgKey, KeySource};
+use crate::atoms::{Atom, network};
+use crate::diff::FileChange;
+use crate::logging::LoggedCommand;
+use std::io::Write;
+use std::path::PathBuf;
+use std::process::{Command, Stdio};
+
+/// Package managers `packageRepo` can add repositories to
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum RepoBackend {
+    Apt,
+    Dnf,
+    Pacman,
+}
+
+impl RepoBackend {
+    pub fn from_manager(manager: &str) -> Option<Self> {
+        match manager {
+            "apt" => Some(RepoBackend::Apt),
+            "dnf" | "yum" => Some(RepoBackend::Dnf),
+            "pacman" => Some(RepoBackend::Pacman),
+            _ => None,
+        }
+    }
+
+    /// The backend of this machine's package manager
+    pub fn detect() -> Option<Self> {
+        use crate::atoms::package::PackageManager;
+
+        match PackageManager::detect()? {
+            PackageManager::Apt => Some(RepoBackend::Apt),
+            PackageManager::Dnf | PackageManager::Yum => Some(RepoBackend::Dnf),
+            PackageManager::Pacman => Some(RepoBackend::Pacman),
+            _ => None,
+        }
+    }
+
+    /// The package manager whose database this backend's refresh changes
+    pub fn manager(&self) -> crate::atoms::package::PackageManager {
+        use crate::atoms::package::PackageManager;
+
+        match self {
+            RepoBackend::Apt => PackageManager::Apt,
+            RepoBackend::Dnf => PackageManager::Dnf,
+            RepoBackend::Pacman => PackageManager::Pacman,
+        }
+    }
+}
+
+/// A third-party package repository and its signing key
+///
+/// * apt: `/etc/apt/sources.list.d/<name>.sources`, signed by the key written
+///   to `/etc/apt/keyrings/<name>.gpg`
+/// * dnf: `/etc/yum.repos.d/<name>.repo`, with the key URL as `gpgkey`
+/// * pacman: a `[<name>]` block in `/etc/pacman.conf`, with the key added
+///   to pacman's keyring and locally signed
+///
+/// The package index is refreshed when anything changed.
+#[derive(Debug, Clone)]
+pub struct PackageRepo {
+    pub name: String,
+    pub uri: String,
+    /// URL or path of the signing key
+    pub key: Option<String>,
+    /// apt components (default: `main`)
+    pub components: Vec<String>,
+    /// apt suite (default: this release's codename)
+    pub distro: Option<String>,
+    /// `None` when the package manager has no repositories `packageRepo` knows
+    pub backend: Option<RepoBackend>,
+}
+
+impl PackageRepo {
+    pub fn new(name: String, uri: String, backend: Option<RepoBackend>) -> Self {
+        Self {
+            name,
+            uri,
+            key: None,
+            components: Vec::new(),
+            distro: None,
+            backend,
+        }
+    }
+
+    pub fn with_key(mut self, key: Option<String>) -> Self {
+        self.key = key;
+        self
+    }
+
+    /// apt's components and suite
+    pub fn with_suite(mut self, components: Vec<String>, distro: Option<String>) -> Self {
+        self.components = components;
+        self.distro = distro;
+        self
+    }
+
+    fn backend(&self) -> Result<RepoBackend, String> {
+        self.backend.ok_or_else(|| {
+            format!(
+                "Can't add repository '{}': packageRepo supports apt, dnf and pacman; set 'manager'",
+                self.name
+            )
+        })
+    }
+
+    fn key_source(&self) -> Option<KeySource> {
+        let key = self.key.as_ref()?;
+        if key.contains("://") {
+            Some(KeySource::Url(key.clone()))
+        } else {
+            Some(KeySource::File(PathBuf::from(key)))
+        }
+    }
+
+    fn apt_keyring(&self) -> PathBuf {
+        PathBuf::from(format!("/etc/apt/keyrings/{}.gpg", self.name))
+    }
+
+    /// The apt signing key, written to its own keyring
+    fn apt_key(&self) -> Option<GpgKey> {
+        let key = GpgKey::new(self.key_source()?, None);
+        Some(key.with_keyring(Some(self.apt_keyring()), true))
+    }
+
+    fn suite(&self) -> Result<String, String> {
+        if let Some(distro) = &self.distro {
+            return Ok(distro.clone());
+        }
+        os_info::get().codename().map(String::from).ok_or_else(|| {
+            format!(
+                "Can't tell this release's codename for repository '{}'; set 'distro'",
+                self.name
+            )
+        })
+    }
+
+    /// The repository file of apt or dnf, with its desired content
+    fn repo_file(&self) -> Result<(PathBuf, String), String> {
+        match self.backend()? {
+            RepoBackend::Apt => {
+                let components = if self.components.is_empty() {
+                    "main".to_string()
+                } else {
+                    self.components.join(" ")
+                };
+                let mut content = format!(
+                    "Types: deb\nURIs: {}\nSuites: {}\nComponents: {}\n",
+                    self.uri,
+                    self.suite()?,
+                    components
+                );
+                if self.key.is_some() {
+                    content.push_str(&format!("Signed-By: {}\n", self.apt_keyring().display()));
+                }
+                let path = format!("/etc/apt/sources.list.d/{}.sources", self.name);
+                Ok((PathBuf::from(path), content))
+            }
+            RepoBackend::Dnf => {
+                let mut content = format!(
+                    "[{name}]\nname={name}\nbaseurl={}\nenabled=1\n",
+                    self.uri,
+                    name = self.name
+                );
+                match &self.key {
+                    Some(key) => content.push_str(&format!("gpgcheck=1\ngpgkey={}\n", key)),
+                    None => content.push_str("gpgcheck=0\n"),
+                }
+                let path = format!("/etc/yum.repos.d/{}.repo", self.name);
+                Ok((PathBuf::from(path), content))
+            }
+            RepoBackend::Pacman => Err("pacman repositories live in pacman.conf".to_string()),
+        }
+    }
+
+    fn repo_change(&self) -> Result<FileChange, String> {
+        let (path, content) = self.repo_file()?;
+        file_change(&path, |_| content.clone())
+    }
+
+    /// The `[<name>]` block of pacman.conf
+    fn pacman_block(&self) -> BlockInFile {
+        let sig_level = if self.key.is_some() {
+            "Required DatabaseOptional"
+        } else {
+            "Optional TrustAll"
+        };
+        BlockInFile::new(
+            PathBuf::from("/etc/pacman.conf"),
+            self.name.clone(),
+            Some(format!(
+                "[{}]\nSigLevel = {}\nServer = {}\n",
+                self.name, sig_level, self.uri
+            )),
+            true,
+        )
+    }
+
+    /// Fingerprints of the pacman signing key, when pacman-key doesn't know them yet
+    fn pacman_keys_missing(&self) -> Result<Option<(Vec<u8>, Vec<String>)>, String> {
+        let Some(source) = self.key_source() else {
+            return Ok(None);
+        };
+        let key = GpgKey::new(source, None);
+        let data = key.key_data()?;
+        let fingerprints = GpgKey::fingerprints(&data)?;
+        let known = fingerprints.iter().all(|fingerprint| {
+            Command::new("pacman-key")
+                .args(["--list-keys", fingerprint])
+                .logged_output()
+                .is_ok_and(|output| output.status.success())
+        });
+        Ok((!known).then_some((data, fingerprints)))
+    }
+
+    fn add_pacman_keys(&self, data: &[u8], fingerprints: &[String]) -> Result<(), String> {
+        let mut child = crate::privilege::root_command("pacman-key")?
+            .args(["--add", "-"])
+            .stdin(Stdio::piped())
+            .stdout(Stdio::null())
+            .stderr(Stdio::piped())
+            .spawn()
+            .map_err(|e| format!("Failed to run pacman-key: {}", e))?;
+        if let Some(mut stdin) = child.stdin.take() {
+            stdin
+                .write_all(data)
+                .map_err(|e| format!("Failed to run pacman-key: {}", e))?;
+        }
+        let output = child
+            .wait_with_output()
+            .map_err(|e| format!("Failed to run pacman-key: {}", e))?;
+        if !output.status.success() {
+            return Err(format!(
+                "pacman-key --add failed: {}",
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+
+        for fingerprint in fingerprints {
+            sudo(&["pacman-key", "--lsign-key", fingerprint])?;
+            log::info!("Added pacman signing key {}", fingerprint);
+        }
+        Ok(())
+    }
+
+    fn refresh(&self) -> Result<(), String> {
+        let backend = self.backend()?;
+        // Refreshing writes the package database, like installs do
+        let _backend_lock = backend.manager().lock()?;
+        match backend {
+            RepoBackend::Apt => sudo(&["apt-get", "update"]),
+            RepoBackend::Dnf => sudo(&["dnf", "makecache"]),
+            RepoBackend::Pacman => sudo(&["pacman", "-Sy"]),
+        }
+    }
+
+    fn pending(&self) -> Result<bool, String> {
+        match self.backend()? {
+            RepoBackend::Pacman => Ok(self.pacman_block().check().unwrap_or(true)
+                || self.pacman_keys_missing()?.is_some()),
+            RepoBackend::Apt => {
+                let key_pending = self
+                    .apt_key()
+                    .is_some_and(|key| key.check().unwrap_or(true));
+                Ok(key_pending || self.repo_change()?.is_changed())
+            }
+            RepoBackend::Dnf => Ok(self.repo_change()?.is_changed()),
+        }
+    }
+}
+
+fn sudo(args: &[&str]) -> Result<(), String> {
+    let output = crate::privilege::root_command(args[0])?
+        .args(&args[1..])
+        .logged_output()
+        .map_err(|e| format!("Failed to run {}: {}", args[0], e))?;
+    if !output.status.success() {
+        return Err(format!(
+            "{} failed: {}",
+            args.join(" "),
+            String::from_utf8_lossy(&output.stderr).trim()
+        ));
+    }
+    Ok(())
+}
+
+impl Atom for PackageRepo {
+    fn name(&self) -> &str {
+        "PackageRepo"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        let mut changed = false;
+        match self.backend()? {
+            RepoBackend::Pacman => {
+                if let Some((data, fingerprints)) = self.pacman_keys_missing()? {
+                    self.add_pacman_keys(&data, &fingerprints)?;
+                    changed = true;
+                }
+                let block = self.pacman_block();
+                if block.check() != Some(false) {
+                    block.execute()?;
+                    changed = true;
+                }
+            }
+            backend => {
+                // dnf imports the key from `gpgkey` itself
+                if let Some(key) = self.apt_key().filter(|_| backend == RepoBackend::Apt) {
+                    if key.check() != Some(false) {
+                        key.execute()?;
+                        changed = true;
+                    }
+                }
+                let change = self.repo_change()?;
+                if change.is_changed() {
+                    write_file(&change, None, true)?;
+                    changed = true;
+                }
+            }
+        }
+
+        if changed {
+            // Offline, the package manager's next refresh picks the repository up
+            let refresh = format!("Refresh the package index for {}", self.describe());
+            if !network::skip(&refresh) {
+                self.refresh()?;
+            }
+        }
+        Ok(())
+    }
+
+    fn check(&self) -> Option<bool> {
+        self.pending().ok()
+    }
+
+    fn file_change(&self) -> Option<Result<FileChange, String>> {
+        match self.backend {
+            Some(RepoBackend::Apt | RepoBackend::Dnf) => Some(self.repo_change()),
+            Some(RepoBackend::Pacman) => self.pacman_block().file_change(),
+            None => None,
+        }
+    }
+
+    fn destruction(&self) -> Option<crate::atom::Destruction> {
+        // The file is named after the repository and only holds it
+        None
+    }
+
+    fn describe(&self) -> String {
+        match self.backend {
+            Some(RepoBackend::Apt) => format!("Add apt repository {}: {}", self.name, self.uri),
+            Some(RepoBackend::Dnf) => format!("Add dnf repository {}: {}", self.name, self.uri),
+            Some(RepoBackend::Pacman) => {
+                format!("Add pacman repository {}: {}", self.name, self.uri)
+            }
+            None => format!("Add package repository {}: {}", self.name, self.uri),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn repo(backend: RepoBackend) -> PackageRepo {
+        PackageRepo::new(
+            "docker".to_string(),
+            "https://download.docker.com/linux/ubuntu".to_string(),
+            Some(backend),
+        )
+        .with_key(Some(
+            "https://download.docker.com/linux/ubuntu/gpg".to_string(),
+        ))
+    }
+
+    #[test]
+    fn test_apt_sources_file() {
+        let repo = repo(RepoBackend::Apt)
+            .with_suite(vec!["stable".to_string()], Some("noble".to_string()));
+        let (path, content) = repo.repo_file().unwrap();
+        assert_eq!(
+            path,
+            PathBuf::from("/etc/apt/sources.list.d/docker.sources")
+        );
+        assert_eq!(
+            content,
+            "Types: deb\nURIs: https://download.docker.com/linux/ubuntu\nSuites: noble\nComponents: stable\nSigned-By: /etc/apt/keyrings/docker.gpg\n"
+        );
+        assert_eq!(
+            repo.apt_key().unwrap().keyring,
+            Some(PathBuf::from("/etc/apt/keyrings/docker.gpg"))
+        );
+    }
+
+    #[test]
+    fn test_dnf_repo_file() {
+        let (path, content) = repo(RepoBackend::Dnf).repo_file().unwrap();
+        assert_eq!(path, PathBuf::from("/etc/yum.repos.d/docker.repo"));
+        assert_eq!(
+            content,
+            "[docker]\nname=docker\nbaseurl=https://download.docker.com/linux/ubuntu\nenabled=1\ngpgcheck=1\ngpgkey=https://download.docker.com/linux/ubuntu/gpg\n"
+        );
+
+        let unsigned = repo(RepoBackend::Dnf).with_key(None);
+        assert!(unsigned.repo_file().unwrap().1.ends_with("gpgcheck=0\n"));
+    }
+
+    #[test]
+    fn test_pacman_block() {
+        let block = repo(RepoBackend::Pacman).with_key(None).pacman_block();
+        assert_eq!(block.path, PathBuf::from("/etc/pacman.conf"));
+        assert_eq!(
+            block.content.as_deref(),
+            Some(
+                "[docker]\nSigLevel = Optional TrustAll\nServer = https://download.docker.com/linux/ubuntu\n"
+            )
+        );
+    }
+
+    #[test]
+    fn test_unknown_backend_fails() {
+        let repo = PackageRepo::new("tools".to_string(), "https://example.com".to_string(), None);
+        assert_eq!(
+            repo.describe(),
+            "Add package repository tools: https://example.com"
+        );
+        assert!(
+            repo.execute()
+                .unwrap_err()
+                .contains("supports apt, dnf and pacman")
+        );
+        assert_eq!(repo.check(), None);
+    }
+}
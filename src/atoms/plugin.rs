@@ -0,0 +1,215 @@
+//! Actions carried out by an external executable
+//!
+//! The executable is run with `check` or `apply` as its argument, in the
+//! module directory, and gets a JSON request on stdin:
+//!
+//! ```json
+//! { "protocol": 1, "operation": "check", "name": "vpn", "moduleDir": "/home/user/dotfiles", "spec": { "server": "vpn.example.com" } }
+//! ```
+//!
+//! It answers with a JSON object on stdout. For `check`, `changed` tells
+//! whether applying would change anything; leaving it out means the plugin
+//! can't tell, so the action always runs. For `apply`, `changed` tells
+//! whether it did. `failed: true` or a non-zero exit fails the action, with
+//! `message` (or stderr) as the error; otherwise `message` is logged. Plans
+//! and `dhd status` only ever run `check`.
+
+use crate::atoms::Atom;
+use crate::logging::LoggedCommand;
+use serde_json::{Value, json};
+use std::path::PathBuf;
+use std::process::Command;
+
+/// Version of the request and response format
+pub const PROTOCOL: u64 = 1;
+
+#[derive(Debug, Clone)]
+pub struct Plugin {
+    pub name: String,
+    /// The plugin executable
+    pub command: PathBuf,
+    pub spec: Value,
+    pub module_dir: PathBuf,
+    pub description: Option<String>,
+}
+
+/// What a plugin answered
+#[derive(Debug, Clone, PartialEq)]
+pub struct PluginResponse {
+    pub changed: Option<bool>,
+    pub message: Option<String>,
+}
+
+impl Plugin {
+    /// Run the plugin for `operation`, returning its response, or an error if
+    /// it failed or didn't answer with JSON
+    fn call(&self, operation: &str) -> Result<PluginResponse, String> {
+        let request = json!({
+            "protocol": PROTOCOL,
+            "operation": operation,
+            "name": self.name,
+            "moduleDir": self.module_dir,
+            "spec": self.spec,
+        });
+        let output = Command::new(&self.command)
+            .arg(operation)
+            .current_dir(&self.module_dir)
+            .env("DHD_PLUGIN_PROTOCOL", PROTOCOL.to_string())
+            .logged_output_with_input(request.to_string().as_bytes())
+            .map_err(|e| {
+                format!(
+                    "Failed to run plugin {} ({}): {}",
+                    self.name,
+                    self.command.display(),
+                    e
+                )
+            })?;
+
+        let stdout = String::from_utf8_lossy(&output.stdout);
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        let response = parse_response(&stdout);
+        let failed = response
+            .as_ref()
+            .is_ok_and(|response| response.get("failed") == Some(&Value::Bool(true)));
+        if !output.status.success() || failed {
+            let reason = response
+                .as_ref()
+                .ok()
+                .and_then(|response| response.get("message")?.as_str().map(String::from))
+                .unwrap_or_else(|| stderr.trim().to_string());
+            return Err(format!(
+                "Plugin {} failed to {}: {}",
+                self.name, operation, reason
+            ));
+        }
+
+        let response = response.map_err(|e| {
+            format!(
+                "Plugin {} answered {} with invalid JSON: {}",
+                self.name, operation, e
+            )
+        })?;
+        Ok(PluginResponse {
+            changed: response.get("changed").and_then(|v| v.as_bool()),
+            message: response
+                .get("message")
+                .and_then(|v| v.as_str())
+                .map(String::from),
+        })
+    }
+}
+
+/// The JSON object a plugin printed, ignoring blank output around it
+fn parse_response(stdout: &str) -> Result<serde_json::Map<String, Value>, String> {
+    match serde_json::from_str(stdout.trim()) {
+        Ok(Value::Object(response)) => Ok(response),
+        Ok(_) => Err("expected an object".to_string()),
+        Err(e) => Err(e.to_string()),
+    }
+}
+
+impl Atom for Plugin {
+    fn name(&self) -> &str {
+        "Plugin"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        let response = self.call("apply")?;
+        match (&response.message, response.changed) {
+            (Some(message), _) => log::info!("plugin {}: {}", self.name, message),
+            (None, Some(false)) => log::info!("plugin {} changed nothing", self.name),
+            _ => {}
+        }
+        Ok(())
+    }
+
+    fn check(&self) -> Option<bool> {
+        match self.call("check") {
+            Ok(response) => response.changed,
+            Err(e) => {
+                // The failure is reported when the atom runs
+                log::debug!("{}", e);
+                None
+            }
+        }
+    }
+
+    fn describe(&self) -> String {
+        match &self.description {
+            Some(description) => format!("Plugin {}: {}", self.name, description),
+            None => format!("Run plugin {}", self.name),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::os::unix::fs::PermissionsExt;
+    use tempfile::TempDir;
+
+    /// A plugin that is up to date once `marker` exists, and creates it
+    fn plugin(temp_dir: &TempDir, script: &str) -> Plugin {
+        let command = temp_dir.path().join("dhd-plugin-marker");
+        std::fs::write(&command, format!("#!/bin/sh\n{}", script)).unwrap();
+        std::fs::set_permissions(&command, std::fs::Permissions::from_mode(0o755)).unwrap();
+        Plugin {
+            name: "marker".to_string(),
+            command,
+            spec: json!({ "file": "marker" }),
+            module_dir: temp_dir.path().to_path_buf(),
+            description: None,
+        }
+    }
+
+    #[test]
+    fn test_plugin_checks_and_applies() {
+        let temp_dir = TempDir::new().unwrap();
+        let atom = plugin(
+            &temp_dir,
+            r#"
+request=$(cat)
+case "$request" in *'"file":"marker"'*) ;; *) echo '{"failed": true, "message": "no spec"}'; exit 0 ;; esac
+if [ "$1" = check ]; then
+  if [ -e marker ]; then echo '{"changed": false}'; else echo '{"changed": true}'; fi
+else
+  touch marker && echo '{"changed": true, "message": "created marker"}'
+fi
+"#,
+        );
+
+        assert_eq!(atom.check(), Some(true));
+        atom.execute().unwrap();
+        assert!(temp_dir.path().join("marker").exists());
+        assert_eq!(atom.check(), Some(false));
+        assert_eq!(atom.describe(), "Run plugin marker");
+    }
+
+    #[test]
+    fn test_plugin_failures_are_errors() {
+        let temp_dir = TempDir::new().unwrap();
+        let atom = plugin(
+            &temp_dir,
+            "echo '{\"failed\": true, \"message\": \"server unreachable\"}'\n",
+        );
+        assert_eq!(atom.check(), None);
+        assert_eq!(
+            atom.execute().unwrap_err(),
+            "Plugin marker failed to apply: server unreachable"
+        );
+
+        let atom = plugin(&temp_dir, "echo 'boom' >&2\nexit 3\n");
+        assert_eq!(
+            atom.execute().unwrap_err(),
+            "Plugin marker failed to apply: boom"
+        );
+
+        let atom = plugin(&temp_dir, "echo done\n");
+        let error = atom.execute().unwrap_err();
+        assert!(
+            error.contains("answered apply with invalid JSON"),
+            "{}",
+            error
+        );
+    }
+}
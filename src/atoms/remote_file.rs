@@ -0,0 +1,490 @@
+use crate::atom::Destruction;
+use crate::atoms::{Atom, network};
+use crate::logging::LoggedCommand;
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+
+/// Where downloads are staged and what was installed from them is remembered
+/// (`$XDG_CACHE_HOME/dhd/downloads`, or `$DHD_HOME/cache/downloads`)
+pub fn cache_dir() -> PathBuf {
+    crate::platform::dhd_cache_dir().join("downloads")
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum ArchiveFormat {
+    Tar,
+    Zip,
+}
+
+impl ArchiveFormat {
+    /// The format of the archive at `url`, from its file extension
+    pub fn from_url(url: &str) -> Option<Self> {
+        let path = url.split(['?', '#']).next().unwrap_or(url).to_lowercase();
+        if path.ends_with(".zip") {
+            return Some(ArchiveFormat::Zip);
+        }
+        const TAR: &[&str] = &[
+            ".tar", ".tar.gz", ".tgz", ".tar.xz", ".txz", ".tar.bz2", ".tbz2", ".tar.zst",
+        ];
+        TAR.iter()
+            .any(|ext| path.ends_with(ext))
+            .then_some(ArchiveFormat::Tar)
+    }
+}
+
+/// What was last installed from a URL to a target
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+struct Installed {
+    sha256: String,
+    etag: Option<String>,
+}
+
+/// The outcome of a (conditional) download
+enum Download {
+    NotModified,
+    Fetched { etag: Option<String> },
+}
+
+/// Download a file, or one file or directory of an archive, to a target
+///
+/// The download is staged in the cache and only moved into place once its
+/// checksum matches, so a failed or corrupt download never leaves a partial
+/// target. Later applies skip the download when the target still matches
+/// `sha256`, or when the server reports the same ETag as last time.
+#[derive(Debug, Clone)]
+pub struct RemoteFile {
+    pub url: String,
+    pub target: PathBuf,
+    pub sha256: Option<String>,
+    pub mode: Option<u32>,
+    /// Path inside the archive to install at `target`; `.` for all of it
+    pub extract: Option<String>,
+    pub cache: PathBuf,
+}
+
+impl RemoteFile {
+    pub fn new(
+        url: String,
+        target: PathBuf,
+        sha256: Option<String>,
+        mode: Option<u32>,
+        extract: Option<String>,
+    ) -> Self {
+        Self {
+            url,
+            target,
+            sha256: sha256.map(|sha256| sha256.to_lowercase()),
+            mode,
+            extract,
+            cache: cache_dir(),
+        }
+    }
+
+    pub fn with_cache_dir(mut self, cache: PathBuf) -> Self {
+        self.cache = cache;
+        self
+    }
+
+    /// Cache file name for this URL and target
+    fn cache_file(&self, extension: &str) -> PathBuf {
+        let digest = Sha256::digest(format!("{}\n{}", self.url, self.target.display()));
+        let key: String = digest
+            .iter()
+            .take(8)
+            .map(|b| format!("{:02x}", b))
+            .collect();
+        self.cache.join(format!("{}.{}", key, extension))
+    }
+
+    fn installed(&self) -> Option<Installed> {
+        let content = fs::read_to_string(self.cache_file("json")).ok()?;
+        serde_json::from_str(&content).ok()
+    }
+
+    /// Whether the target is up to date, if that can be told without the network
+    fn is_current(&self) -> Option<bool> {
+        if !self.target.exists() {
+            return Some(false);
+        }
+        let installed = self.installed();
+
+        match (&self.sha256, &self.extract) {
+            (Some(expected), None) => {
+                Some(sha256_file(&self.target).ok().as_ref() == Some(expected))
+            }
+            (Some(expected), Some(_)) => {
+                Some(installed.is_some_and(|installed| &installed.sha256 == expected))
+            }
+            (None, _) => match installed {
+                // Without a checksum or ETag there is nothing to compare against
+                Some(Installed { etag: None, .. }) => Some(true),
+                Some(_) => None,
+                None => Some(false),
+            },
+        }
+    }
+
+    fn download(&self, part: &Path, etag: Option<&str>) -> Result<Download, String> {
+        let _network = network::acquire(&self.describe());
+        let headers = self.cache_file("headers");
+        let mut cmd = Command::new("curl");
+        cmd.arg("-fsSL")
+            .arg("-o")
+            .arg(part)
+            .arg("-D")
+            .arg(&headers)
+            .args(["-w", "%{http_code}"]);
+        if let Some(etag) = etag {
+            cmd.args(["-H", &format!("If-None-Match: {}", etag)]);
+        }
+        let output = cmd
+            .arg(&self.url)
+            .logged_output()
+            .map_err(|e| format!("Failed to download {}: {}", self.url, e))?;
+
+        let headers_content = fs::read_to_string(&headers).unwrap_or_default();
+        let _ = fs::remove_file(&headers);
+        if !output.status.success() {
+            let _ = fs::remove_file(part);
+            return Err(format!(
+                "Failed to download {}: {}",
+                self.url,
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+
+        if String::from_utf8_lossy(&output.stdout).trim() == "304" {
+            let _ = fs::remove_file(part);
+            return Ok(Download::NotModified);
+        }
+
+        // After redirects the last response's ETag is the one that counts
+        let etag = headers_content
+            .lines()
+            .filter_map(|line| line.split_once(':'))
+            .filter(|(name, _)| name.trim().eq_ignore_ascii_case("etag"))
+            .map(|(_, value)| value.trim().to_string())
+            .last();
+        Ok(Download::Fetched { etag })
+    }
+
+    /// A sibling of the target, so moving it into place is a rename
+    fn sibling(&self, suffix: &str) -> PathBuf {
+        let name = self
+            .target
+            .file_name()
+            .map(|name| name.to_string_lossy().to_string())
+            .unwrap_or_default();
+        self.target.with_file_name(format!(".{}.{}", name, suffix))
+    }
+
+    fn install_file(&self, source: &Path) -> Result<(), String> {
+        let staged = self.sibling("dhd-tmp");
+        fs::copy(source, &staged)
+            .map_err(|e| format!("Failed to write {}: {}", staged.display(), e))?;
+        self.place(&staged)
+    }
+
+    fn install_archive(&self, archive: &Path, member: &str) -> Result<(), String> {
+        let format = ArchiveFormat::from_url(&self.url).ok_or_else(|| {
+            format!(
+                "Cannot tell the archive format of {}; expected .tar.gz, .tar.xz, .zip or similar",
+                self.url
+            )
+        })?;
+
+        let staging = self.sibling("dhd-extract");
+        let _ = fs::remove_dir_all(&staging);
+        fs::create_dir_all(&staging)
+            .map_err(|e| format!("Failed to create {}: {}", staging.display(), e))?;
+
+        let result = self.extract_to(format, archive, &staging).and_then(|()| {
+            let extracted = staging.join(member);
+            if !extracted.exists() {
+                return Err(format!(
+                    "'{}' is not in the archive from {}",
+                    member, self.url
+                ));
+            }
+            self.place(&extracted)
+        });
+        let _ = fs::remove_dir_all(&staging);
+        result
+    }
+
+    fn extract_to(&self, format: ArchiveFormat, archive: &Path, dir: &Path) -> Result<(), String> {
+        let output = match format {
+            ArchiveFormat::Tar => Command::new("tar")
+                .arg("-xf")
+                .arg(archive)
+                .arg("-C")
+                .arg(dir)
+                .logged_output(),
+            ArchiveFormat::Zip => Command::new("unzip")
+                .arg("-q")
+                .arg(archive)
+                .arg("-d")
+                .arg(dir)
+                .logged_output(),
+        }
+        .map_err(|e| format!("Failed to extract {}: {}", self.url, e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "Failed to extract {}: {}",
+                self.url,
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+        Ok(())
+    }
+
+    /// Move a staged file or directory to the target
+    fn place(&self, staged: &Path) -> Result<(), String> {
+        if let Some(mode) = self.mode.filter(|_| staged.is_file()) {
+            #[cfg(unix)]
+            {
+                use std::os::unix::fs::PermissionsExt;
+                fs::set_permissions(staged, fs::Permissions::from_mode(mode)).map_err(|e| {
+                    format!(
+                        "Failed to set mode {:o} on {}: {}",
+                        mode,
+                        self.target.display(),
+                        e
+                    )
+                })?;
+            }
+            #[cfg(not(unix))]
+            let _ = mode;
+        }
+
+        if staged.is_dir() {
+            if self.target.is_dir() {
+                fs::remove_dir_all(&self.target)
+                    .map_err(|e| format!("Failed to replace {}: {}", self.target.display(), e))?;
+            } else if self.target.exists() {
+                fs::remove_file(&self.target)
+                    .map_err(|e| format!("Failed to replace {}: {}", self.target.display(), e))?;
+            }
+            fs::rename(staged, &self.target).map_err(|e| {
+                format!("Failed to move {} into place: {}", self.target.display(), e)
+            })?;
+            return crate::target_user::give_all(&self.target);
+        }
+
+        let previous = crate::state::preserve(&self.target);
+        fs::rename(staged, &self.target).map_err(|e| {
+            let _ = fs::remove_file(staged);
+            format!("Failed to move {} into place: {}", self.target.display(), e)
+        })?;
+        crate::target_user::give(&self.target)?;
+        if let Some(previous) = previous {
+            crate::state::record(crate::state::Change::File {
+                path: self.target.clone(),
+                previous,
+                escalate: false,
+                written: None,
+            });
+        }
+        Ok(())
+    }
+}
+
+pub fn sha256_file(path: &Path) -> Result<String, String> {
+    let content =
+        fs::read(path).map_err(|e| format!("Failed to read {}: {}", path.display(), e))?;
+    Ok(Sha256::digest(&content)
+        .iter()
+        .map(|b| format!("{:02x}", b))
+        .collect())
+}
+
+impl Atom for RemoteFile {
+    fn name(&self) -> &str {
+        "RemoteFile"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        match self.is_current() {
+            Some(true) => return Ok(()),
+            // Offline, what an earlier apply installed is kept as it is
+            None if network::skip(&self.describe()) => return Ok(()),
+            _ => network::require(&self.describe())?,
+        }
+
+        fs::create_dir_all(&self.cache)
+            .map_err(|e| format!("Failed to create {}: {}", self.cache.display(), e))?;
+        if let Some(parent) = self.target.parent() {
+            crate::target_user::create_dir_all(parent)
+                .map_err(|e| format!("Failed to create parent directory: {}", e))?;
+        }
+
+        // Ask the server to skip the body if nothing changed since the last install
+        let installed = self.installed().filter(|_| self.target.exists());
+        let etag = installed
+            .as_ref()
+            .and_then(|installed| installed.etag.as_deref());
+
+        let part = self.cache_file("part");
+        let etag = match self.download(&part, etag)? {
+            Download::NotModified => return Ok(()),
+            Download::Fetched { etag } => etag,
+        };
+
+        let result = sha256_file(&part).and_then(|actual| {
+            if let Some(expected) = &self.sha256 {
+                if &actual != expected {
+                    return Err(format!(
+                        "Checksum mismatch for {}: expected sha256 {}, got {}",
+                        self.url, expected, actual
+                    ));
+                }
+            }
+
+            match &self.extract {
+                Some(member) => self.install_archive(&part, member)?,
+                None => self.install_file(&part)?,
+            }
+            Ok(actual)
+        });
+        let _ = fs::remove_file(&part);
+
+        let installed = Installed {
+            sha256: result?,
+            etag,
+        };
+        let content = serde_json::to_string(&installed).map_err(|e| e.to_string())?;
+        fs::write(self.cache_file("json"), content)
+            .map_err(|e| format!("Failed to record download of {}: {}", self.url, e))
+    }
+
+    fn check(&self) -> Option<bool> {
+        match self.is_current() {
+            None if network::skip(&self.describe()) => Some(false),
+            current => current.map(|current| !current),
+        }
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        // Targets installed by an earlier apply are dhd's to replace
+        let foreign = self.target.exists() && self.installed().is_none();
+        (foreign && self.is_current() != Some(true))
+            .then(|| Destruction::Overwrite(self.target.clone()))
+    }
+
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        Ok(vec![self.target.clone()])
+    }
+
+    fn describe(&self) -> String {
+        match &self.extract {
+            Some(member) if member == "." => {
+                format!(
+                    "Download and extract {} -> {}",
+                    self.url,
+                    self.target.display()
+                )
+            }
+            Some(member) => format!(
+                "Download {} and extract {} -> {}",
+                self.url,
+                member,
+                self.target.display()
+            ),
+            None => format!("Download {} -> {}", self.url, self.target.display()),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn remote(temp_dir: &TempDir, source: &Path, target: &str) -> RemoteFile {
+        RemoteFile::new(
+            format!("file://{}", source.display()),
+            temp_dir.path().join(target),
+            None,
+            None,
+            None,
+        )
+        .with_cache_dir(temp_dir.path().join("cache"))
+    }
+
+    #[test]
+    fn test_archive_format_from_url() {
+        let format = |url| ArchiveFormat::from_url(url);
+        assert_eq!(format("https://x/rg-14.tar.gz"), Some(ArchiveFormat::Tar));
+        assert_eq!(format("https://x/tool.TGZ?raw=1"), Some(ArchiveFormat::Tar));
+        assert_eq!(format("https://x/tool.zip"), Some(ArchiveFormat::Zip));
+        assert_eq!(format("https://x/tool"), None);
+    }
+
+    #[test]
+    fn test_checksum_is_verified_and_remembered() {
+        let temp_dir = TempDir::new().unwrap();
+        let source = temp_dir.path().join("tool");
+        fs::write(&source, "#!/bin/sh\n").unwrap();
+        let sha256 = sha256_file(&source).unwrap();
+
+        let mut atom = remote(&temp_dir, &source, "bin/tool");
+        atom.sha256 = Some("0".repeat(64));
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("Checksum mismatch"), "{}", err);
+        assert!(!atom.target.exists());
+
+        atom.sha256 = Some(sha256);
+        atom.mode = Some(0o755);
+        assert_eq!(atom.check(), Some(true));
+        atom.execute().unwrap();
+        assert_eq!(atom.check(), Some(false));
+        assert_eq!(fs::read_to_string(&atom.target).unwrap(), "#!/bin/sh\n");
+
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            let mode = fs::metadata(&atom.target).unwrap().permissions().mode();
+            assert_eq!(mode & 0o777, 0o755);
+        }
+    }
+
+    #[test]
+    fn test_member_is_extracted_from_archive() {
+        let temp_dir = TempDir::new().unwrap();
+        let release = temp_dir.path().join("tool-1.0");
+        fs::create_dir_all(&release).unwrap();
+        fs::write(release.join("tool"), "binary").unwrap();
+        let archive = temp_dir.path().join("tool-1.0.tar.gz");
+        let status = Command::new("tar")
+            .arg("-czf")
+            .arg(&archive)
+            .arg("-C")
+            .arg(temp_dir.path())
+            .arg("tool-1.0")
+            .status()
+            .unwrap();
+        assert!(status.success());
+
+        let mut atom = remote(&temp_dir, &archive, "bin/tool");
+        atom.extract = Some("tool-1.0/tool".to_string());
+        atom.execute().unwrap();
+        assert_eq!(fs::read_to_string(&atom.target).unwrap(), "binary");
+        assert!(!atom.sibling("dhd-extract").exists());
+        // Without a checksum or ETag, an installed target is left alone
+        assert_eq!(atom.check(), Some(false));
+
+        let mut missing = remote(&temp_dir, &archive, "bin/other");
+        missing.extract = Some("tool-1.0/missing".to_string());
+        let err = missing.execute().unwrap_err();
+        assert!(
+            err.contains("'tool-1.0/missing' is not in the archive"),
+            "{}",
+            err
+        );
+        assert!(!missing.target.exists());
+    }
+}
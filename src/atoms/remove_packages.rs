@@ -1,87 +1,280 @@
+use super::package::brew::BrewProvider;
+use super::package::{PackageManager, PackageOptions, PackageProvider, split_version};
+use crate::atom::Destruction;
 use crate::atoms::Atom;
-use crate::atoms::package::PackageManager;
-use crate::platform::{LinuxDistro, Platform, current_platform};
-use std::process::Command;
+use crate::platform::current_platform;
+
+/// Packages a system can't boot or manage packages without; never removed
+const ESSENTIAL_PACKAGES: &[&str] = &[
+    "apt",
+    "base",
+    "bash",
+    "coreutils",
+    "dnf",
+    "dpkg",
+    "filesystem",
+    "glibc",
+    "grub",
+    "grub2",
+    "kernel",
+    "libc6",
+    "linux",
+    "linux-firmware",
+    "pacman",
+    "rpm",
+    "sudo",
+    "systemd",
+    "util-linux",
+];
 
 #[derive(Debug, Clone)]
 pub struct RemovePackages {
-    pub names: Vec<String>,
+    pub packages: Vec<String>,
     pub manager: Option<PackageManager>,
+    pub options: PackageOptions,
 }
 
 impl RemovePackages {
-    pub fn new(names: Vec<String>, manager: Option<PackageManager>) -> Self {
-        Self { names, manager }
+    pub fn new(
+        packages: Vec<String>,
+        manager: Option<PackageManager>,
+        options: PackageOptions,
+    ) -> Self {
+        Self {
+            packages,
+            manager,
+            options,
+        }
     }
 
-    fn detect_package_manager(&self) -> Result<PackageManager, String> {
-        if let Some(manager) = &self.manager {
-            return Ok(manager.clone());
-        }
+    fn detect_package_manager(&self) -> Option<PackageManager> {
+        self.manager.clone().or_else(PackageManager::detect)
+    }
 
-        match current_platform() {
-            Platform::Linux(distro) => match distro {
-                LinuxDistro::Ubuntu | LinuxDistro::Debian => Ok(PackageManager::Apt),
-                LinuxDistro::Fedora => Ok(PackageManager::Dnf),
-                LinuxDistro::Arch => Ok(PackageManager::Pacman),
-                LinuxDistro::NixOS => Ok(PackageManager::Nix),
-                _ => {
-                    Err("Unable to detect package manager for this Linux distribution".to_string())
-                }
-            },
-            Platform::MacOS => Ok(PackageManager::Brew),
-            _ => Err("Unsupported platform for package removal".to_string()),
-        }
+    /// Distro-specific names of the packages to remove, whatever version a
+    /// pin names
+    fn resolved_names(&self, manager: &PackageManager) -> Vec<String> {
+        let platform = current_platform();
+        self.packages
+            .iter()
+            .map(|package| self.options.resolve_name(package, manager, &platform))
+            .map(|package| split_version(&package).0.to_string())
+            .collect()
+    }
+
+    /// The resolved names, minus essential packages
+    fn removable(&self, manager: &PackageManager) -> Vec<String> {
+        self.resolved_names(manager)
+            .into_iter()
+            .filter(|package| !is_essential(package))
+            .collect()
     }
 }
 
+fn is_essential(package: &str) -> bool {
+    ESSENTIAL_PACKAGES.contains(&package)
+}
+
 impl Atom for RemovePackages {
     fn name(&self) -> &str {
         "RemovePackages"
     }
 
     fn execute(&self) -> Result<(), String> {
-        if self.names.is_empty() {
+        if self.packages.is_empty() && self.options.casks.is_empty() {
             return Ok(());
         }
 
-        let manager = self.detect_package_manager()?;
+        let manager = self
+            .detect_package_manager()
+            .ok_or_else(|| "No supported package manager found".to_string())?;
 
-        let (command, args) = match manager {
-            PackageManager::Apt => ("sudo", vec!["apt-get", "remove", "-y"]),
-            PackageManager::Dnf => ("sudo", vec!["dnf", "remove", "-y"]),
-            PackageManager::Pacman => ("sudo", vec!["pacman", "-R", "--noconfirm"]),
-            PackageManager::Brew => ("brew", vec!["uninstall"]),
-            PackageManager::Nix => return Err("Package removal not supported for Nix".to_string()),
-            _ => return Err(format!("Package removal not implemented for {:?}", manager)),
-        };
+        // Package databases take an exclusive lock, so removals must not overlap
+        let _backend_lock = manager.lock()?;
 
-        let mut cmd = Command::new(command);
-        for arg in args {
-            cmd.arg(arg);
-        }
-        for package in &self.names {
-            cmd.arg(package);
+        for package in self.resolved_names(&manager) {
+            if is_essential(&package) {
+                log::warn!(
+                    "Not removing {}: it is an essential system package",
+                    package
+                );
+            }
         }
 
-        let output = cmd
-            .output()
-            .map_err(|e| format!("Failed to execute package removal: {}", e))?;
+        let provider = manager.get_provider_with_options(&self.options)?;
+        remove_installed(provider.as_ref(), &self.removable(&manager))?;
 
-        if !output.status.success() {
-            let stderr = String::from_utf8_lossy(&output.stderr);
-            // Check if packages are not installed (not an error)
-            if stderr.contains("is not installed") || stderr.contains("No packages found") {
-                println!("Some packages were not installed, skipping");
-                return Ok(());
+        if !self.options.casks.is_empty() {
+            if manager != PackageManager::Brew {
+                return Err(format!(
+                    "Casks can only be removed with brew, not {}",
+                    provider.name()
+                ));
             }
-            return Err(format!("Failed to remove packages: {}", stderr));
+            let cask_provider = BrewProvider::new(true, self.options.taps.clone());
+            remove_installed(&cask_provider, &self.options.casks)?;
         }
 
         Ok(())
     }
 
+    fn check(&self) -> Option<bool> {
+        if self.packages.is_empty() && self.options.casks.is_empty() {
+            return Some(false);
+        }
+
+        let manager = self.detect_package_manager()?;
+        let provider = manager.get_provider_with_options(&self.options).ok()?;
+
+        let packages_installed = self
+            .removable(&manager)
+            .iter()
+            .any(|package| provider.is_package_installed(package).unwrap_or(false));
+        let casks_installed = !self.options.casks.is_empty() && {
+            let cask_provider = BrewProvider::new(true, self.options.taps.clone());
+            self.options
+                .casks
+                .iter()
+                .any(|cask| cask_provider.is_package_installed(cask).unwrap_or(false))
+        };
+
+        Some(packages_installed || casks_installed)
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        let manager = self.detect_package_manager()?;
+        let provider = manager.get_provider_with_options(&self.options).ok()?;
+
+        let mut installed: Vec<String> = self
+            .removable(&manager)
+            .into_iter()
+            .filter(|package| provider.is_package_installed(package).unwrap_or(false))
+            .collect();
+        if !self.options.casks.is_empty() {
+            let cask_provider = BrewProvider::new(true, self.options.taps.clone());
+            installed.extend(
+                self.options
+                    .casks
+                    .iter()
+                    .filter(|cask| cask_provider.is_package_installed(cask).unwrap_or(false))
+                    .cloned(),
+            );
+        }
+
+        (!installed.is_empty()).then(|| Destruction::RemovePackages {
+            manager: provider.name().to_string(),
+            packages: installed,
+        })
+    }
+
     fn describe(&self) -> String {
-        format!("Remove packages: {}", self.names.join(", "))
+        let manager_str = match &self.manager {
+            Some(mgr) => format!(" ({})", mgr.get_provider().name()),
+            None => String::new(),
+        };
+
+        let mut description = format!(
+            "Remove packages{}: {}",
+            manager_str,
+            self.packages.join(", ")
+        );
+        if !self.options.casks.is_empty() {
+            description.push_str(&format!(" (casks: {})", self.options.casks.join(", ")));
+        }
+
+        let essential: Vec<&str> = self
+            .packages
+            .iter()
+            .map(String::as_str)
+            .filter(|package| is_essential(package))
+            .collect();
+        if !essential.is_empty() {
+            description.push_str(&format!(" (keeping essential: {})", essential.join(", ")));
+        }
+        description
+    }
+}
+
+/// Uninstall the packages that the provider reports as installed
+fn remove_installed(provider: &dyn PackageProvider, packages: &[String]) -> Result<(), String> {
+    for package in packages {
+        // Packages that are already gone are fine; if we can't tell, try anyway
+        if let Ok(false) = provider.is_package_installed(package) {
+            continue;
+        }
+
+        provider
+            .uninstall_package(package)
+            .map_err(|e| format!("Failed to remove package {}: {}", package, e))?;
+    }
+
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_remove_packages_empty_is_satisfied() {
+        let atom = RemovePackages::new(vec![], None, PackageOptions::default());
+
+        assert_eq!(atom.check(), Some(false));
+        assert!(atom.execute().is_ok());
+    }
+
+    #[test]
+    fn test_essential_packages_are_never_removed() {
+        let atom = RemovePackages::new(
+            vec![
+                "sudo".to_string(),
+                "htop".to_string(),
+                "systemd".to_string(),
+            ],
+            Some(PackageManager::Apt),
+            PackageOptions::default(),
+        );
+
+        assert_eq!(
+            atom.removable(&PackageManager::Apt),
+            vec!["htop".to_string()]
+        );
+    }
+
+    #[test]
+    fn test_removal_uses_distro_overrides() {
+        let mut options = PackageOptions::default();
+        options.overrides.insert(
+            "fd".to_string(),
+            [("apt".to_string(), "fd-find".to_string())].into(),
+        );
+        let atom = RemovePackages::new(vec!["fd".to_string()], Some(PackageManager::Apt), options);
+
+        assert_eq!(
+            atom.removable(&PackageManager::Apt),
+            vec!["fd-find".to_string()]
+        );
+    }
+
+    #[test]
+    fn test_remove_packages_describe() {
+        let atom = RemovePackages::new(
+            vec!["htop".to_string(), "nano".to_string()],
+            Some(PackageManager::Apt),
+            PackageOptions::default(),
+        );
+
+        assert_eq!(atom.describe(), "Remove packages (apt): htop, nano");
+
+        let atom = RemovePackages::new(
+            vec!["htop".to_string(), "sudo".to_string()],
+            None,
+            PackageOptions::default(),
+        );
+        assert!(atom.describe().ends_with("(keeping essential: sudo)"));
     }
 }
@@ -0,0 +1,391 @@
+use crate::atoms::{Atom, atomic_write};
+use crate::diff::FileChange;
+use std::collections::HashMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+#[derive(Debug, Clone)]
+pub struct RenderTemplate {
+    pub source: PathBuf,
+    pub target: PathBuf,
+    pub variables: HashMap<String, String>,
+    /// Where `secret(...)` looks for age files and `include` for templates;
+    /// defaults to the template's directory
+    pub module_dir: Option<PathBuf>,
+    /// The template itself, rendered instead of the source's content
+    pub content: Option<String>,
+    /// Permission bits of the rendered file
+    pub mode: Option<u32>,
+}
+
+impl RenderTemplate {
+    pub fn new(source: PathBuf, target: PathBuf, variables: HashMap<String, String>) -> Self {
+        Self {
+            source,
+            target,
+            variables,
+            module_dir: None,
+            content: None,
+            mode: None,
+        }
+    }
+
+    pub fn with_module_dir(mut self, module_dir: PathBuf) -> Self {
+        self.module_dir = Some(module_dir);
+        self
+    }
+
+    /// Render `content` instead of the source, when given
+    pub fn with_content(mut self, content: Option<String>) -> Self {
+        self.content = content;
+        self
+    }
+
+    /// Give the rendered file `mode`, when given
+    pub fn with_mode(mut self, mode: Option<u32>) -> Self {
+        self.mode = mode;
+        self
+    }
+
+    /// Whether the target has the requested permission bits, if any
+    fn mode_matches(&self) -> bool {
+        let Some(mode) = self.mode else {
+            return true;
+        };
+
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            fs::metadata(&self.target)
+                .map(|metadata| metadata.permissions().mode() & 0o7777 == mode)
+                .unwrap_or(false)
+        }
+
+        #[cfg(not(unix))]
+        {
+            let _ = mode;
+            true
+        }
+    }
+
+    /// The template's file, or that it's inline, for messages
+    fn origin(&self) -> String {
+        match &self.content {
+            Some(_) => "inline template".to_string(),
+            None => format!("template {}", self.source.display()),
+        }
+    }
+
+    /// The template's text
+    fn template(&self) -> Result<String, String> {
+        match &self.content {
+            Some(content) => Ok(content.clone()),
+            None => fs::read_to_string(&self.source).map_err(|e| {
+                format!(
+                    "Failed to read template {}: {}",
+                    self.source.display(),
+                    e
+                )
+            }),
+        }
+    }
+
+    fn base_dir(&self) -> &Path {
+        match &self.module_dir {
+            Some(dir) => dir.as_path(),
+            None => self.source.parent().unwrap_or(Path::new(".")),
+        }
+    }
+
+    fn render(&self) -> Result<String, String> {
+        let secret = |name: &str| {
+            crate::secrets::resolve_blocking(name, self.base_dir()).map_err(|e| e.to_string())
+        };
+        self.render_with(&secret)
+    }
+
+    /// Render the template and what it includes, looking up secrets with `secret`
+    fn render_with(
+        &self,
+        secret: &dyn Fn(&str) -> Result<String, String>,
+    ) -> Result<String, String> {
+        let template = self.template()?;
+        let source = self.content.is_none().then_some(self.source.as_path());
+        crate::template::render_with_includes(
+            &template,
+            &self.variables,
+            secret,
+            self.base_dir(),
+            source,
+        )
+        .map_err(|e| format!("Failed to render {}: {}", self.origin(), e))
+    }
+
+    /// Compare the rendered output with the current target content
+    fn content_change(&self, rendered: String) -> FileChange {
+        FileChange {
+            target: self.target.clone(),
+            current: fs::read(&self.target).ok(),
+            desired: rendered.into_bytes(),
+        }
+    }
+
+    /// Give an unchanged target the requested permission bits
+    fn apply_mode(&self) -> Result<(), String> {
+        if self.mode_matches() {
+            return Ok(());
+        }
+
+        #[cfg(unix)]
+        if let Some(mode) = self.mode {
+            use std::os::unix::fs::PermissionsExt;
+            fs::set_permissions(&self.target, fs::Permissions::from_mode(mode)).map_err(|e| {
+                format!(
+                    "Failed to set mode {:o} on {}: {}",
+                    mode,
+                    self.target.display(),
+                    e
+                )
+            })?;
+        }
+        Ok(())
+    }
+}
+
+impl Atom for RenderTemplate {
+    fn name(&self) -> &str {
+        "RenderTemplate"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        let rendered = self.render()?;
+
+        // Only rewrite the target when the rendered output differs
+        let change = self.content_change(rendered);
+        if !change.is_changed() {
+            return self.apply_mode();
+        }
+
+        if let Some(parent) = self.target.parent() {
+            if !parent.exists() {
+                crate::target_user::create_dir_all(parent)
+                    .map_err(|e| format!("Failed to create parent directory: {}", e))?;
+            }
+        }
+
+        let previous = crate::state::preserve(&self.target);
+        atomic_write::write(&self.target, &change.desired, self.mode, false).map_err(|e| {
+            format!(
+                "Failed to write rendered template to {}: {}",
+                self.target.display(),
+                e
+            )
+        })?;
+        if let Some(previous) = previous {
+            crate::state::record(crate::state::Change::File {
+                path: self.target.clone(),
+                previous,
+                escalate: false,
+                written: Some(crate::state::sha256(&change.desired)),
+            });
+        }
+
+        Ok(())
+    }
+
+    fn check(&self) -> Option<bool> {
+        // Rendering errors are reported when the atom executes
+        let Ok(rendered) = self.render() else {
+            return Some(true);
+        };
+        Some(self.content_change(rendered).is_changed() || !self.mode_matches())
+    }
+
+    fn audit(&self) -> Option<bool> {
+        // Looking up a secret can take a password manager or the network
+        let rendered = self
+            .render_with(&|name| Err(format!("Secret '{}' is not available here", name)))
+            .ok()?;
+        Some(self.content_change(rendered).is_changed() || !self.mode_matches())
+    }
+
+    fn file_change(&self) -> Option<Result<FileChange, String>> {
+        Some(self.render().map(|rendered| self.content_change(rendered)))
+    }
+
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        Ok(vec![self.target.clone()])
+    }
+
+    fn describe(&self) -> String {
+        format!("Render {} -> {}", self.origin(), self.target.display())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_render_template_writes_target() {
+        let temp_dir = TempDir::new().unwrap();
+        let source = temp_dir.path().join("gitconfig.tmpl");
+        let target = temp_dir.path().join("out/.gitconfig");
+        fs::write(&source, "[user]\n  name = {{ name }}\n").unwrap();
+
+        let mut variables = HashMap::new();
+        variables.insert("name".to_string(), "Jane".to_string());
+
+        let atom = RenderTemplate::new(source, target.clone(), variables);
+        assert_eq!(atom.check(), Some(true));
+        assert!(atom.execute().is_ok());
+        assert_eq!(atom.check(), Some(false));
+        assert_eq!(
+            fs::read_to_string(&target).unwrap(),
+            "[user]\n  name = Jane\n"
+        );
+    }
+
+    #[test]
+    fn test_render_template_unknown_variable_reports_name_and_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let source = temp_dir.path().join("config.tmpl");
+        let target = temp_dir.path().join("config");
+        fs::write(&source, "value = {{ undefined_var }}").unwrap();
+
+        let atom = RenderTemplate::new(source, target.clone(), HashMap::new());
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("undefined_var"));
+        assert!(err.contains("config.tmpl"));
+        assert!(!target.exists());
+    }
+
+    #[test]
+    fn test_render_template_resolves_secrets() {
+        let temp_dir = TempDir::new().unwrap();
+        let source = temp_dir.path().join("netrc.tmpl");
+        let target = temp_dir.path().join("netrc");
+        fs::write(
+            &source,
+            "password {{ secret(\"env://DHD_RENDER_TEMPLATE_SECRET\") }}\n",
+        )
+        .unwrap();
+        unsafe {
+            std::env::set_var("DHD_RENDER_TEMPLATE_SECRET", "t0ken-render-test");
+        }
+
+        let atom = RenderTemplate::new(source, target.clone(), HashMap::new());
+        assert!(atom.execute().is_ok());
+        assert_eq!(
+            fs::read_to_string(&target).unwrap(),
+            "password t0ken-render-test\n"
+        );
+
+        assert_eq!(
+            crate::secrets::mask("+password t0ken-render-test"),
+            "+password ********"
+        );
+    }
+
+    #[test]
+    fn test_render_template_file_change_shows_rendered_content() {
+        let temp_dir = TempDir::new().unwrap();
+        let source = temp_dir.path().join("config.tmpl");
+        let target = temp_dir.path().join("config");
+        fs::write(&source, "theme = {{ theme }}\n").unwrap();
+        fs::write(&target, "theme = light\n").unwrap();
+
+        let mut variables = HashMap::new();
+        variables.insert("theme".to_string(), "dark".to_string());
+
+        let atom = RenderTemplate::new(source, target, variables);
+        let change = atom.file_change().unwrap().unwrap();
+        assert!(change.is_changed());
+        let diff = change.render().unwrap();
+        assert!(diff.contains("-theme = light"));
+        assert!(diff.contains("+theme = dark"));
+    }
+
+    #[test]
+    fn test_render_template_renders_inline_content() {
+        let temp_dir = TempDir::new().unwrap();
+        let target = temp_dir.path().join("theme");
+
+        let mut variables = HashMap::new();
+        variables.insert("theme".to_string(), "dark".to_string());
+
+        let atom = RenderTemplate::new(PathBuf::new(), target.clone(), variables)
+            .with_content(Some("theme = {{ theme }}\n".to_string()));
+        assert!(atom.execute().is_ok());
+        assert_eq!(fs::read_to_string(&target).unwrap(), "theme = dark\n");
+        assert_eq!(atom.check(), Some(false));
+
+        let atom = RenderTemplate::new(PathBuf::new(), target, HashMap::new())
+            .with_content(Some("{{ missing }}".to_string()));
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("Failed to render inline template"), "{}", err);
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_render_template_sets_mode() {
+        use std::os::unix::fs::PermissionsExt;
+
+        let temp_dir = TempDir::new().unwrap();
+        let source = temp_dir.path().join("hook.tmpl");
+        let target = temp_dir.path().join("hook");
+        fs::write(&source, "#!/bin/sh\n").unwrap();
+        fs::write(&target, "#!/bin/sh\n").unwrap();
+        fs::set_permissions(&target, fs::Permissions::from_mode(0o644)).unwrap();
+
+        let atom =
+            RenderTemplate::new(source, target.clone(), HashMap::new()).with_mode(Some(0o755));
+        assert_eq!(atom.check(), Some(true));
+        assert!(atom.execute().is_ok());
+        assert_eq!(atom.check(), Some(false));
+        let mode = fs::metadata(&target).unwrap().permissions().mode();
+        assert_eq!(mode & 0o7777, 0o755);
+    }
+
+    #[test]
+    fn test_render_template_includes_files_of_the_module() {
+        let temp_dir = TempDir::new().unwrap();
+        let module_dir = temp_dir.path();
+        fs::create_dir(module_dir.join("shell")).unwrap();
+        let prelude = module_dir.join("prelude.sh");
+        fs::write(&prelude, "export EDITOR={{ editor }}\n").unwrap();
+        let source = module_dir.join("shell/zshrc.tmpl");
+        fs::write(&source, "{{ include \"prelude.sh\" }}bindkey -e\n").unwrap();
+
+        let mut variables = HashMap::new();
+        variables.insert("editor".to_string(), "nvim".to_string());
+
+        let target = module_dir.join(".zshrc");
+        let atom = RenderTemplate::new(source.clone(), target.clone(), variables)
+            .with_module_dir(module_dir.to_path_buf());
+        assert!(atom.execute().is_ok());
+        assert_eq!(
+            fs::read_to_string(&target).unwrap(),
+            "export EDITOR=nvim\nbindkey -e\n"
+        );
+        assert_eq!(atom.audit(), Some(false));
+
+        fs::write(&prelude, "{{ include \"shell/zshrc.tmpl\" }}").unwrap();
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("Cyclic include"), "{}", err);
+    }
+
+    #[test]
+    fn test_render_template_missing_source() {
+        let temp_dir = TempDir::new().unwrap();
+        let atom = RenderTemplate::new(
+            temp_dir.path().join("missing.tmpl"),
+            temp_dir.path().join("target"),
+            HashMap::new(),
+        );
+
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("Failed to read template"));
+    }
+}
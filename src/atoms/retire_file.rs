@@ -0,0 +1,62 @@
+use crate::atoms::Atom;
+use crate::state::State;
+use std::path::PathBuf;
+
+/// Takes back what earlier applies did to a file, for file actions with
+/// `ensure: absent`
+///
+/// Only a path an apply on record wrote is touched: a file or symlink the
+/// apply created is removed, and one it replaced is restored. Anything else at
+/// the path is left alone.
+#[derive(Debug, Clone)]
+pub struct RetireFile {
+    pub path: PathBuf,
+}
+
+impl Atom for RetireFile {
+    fn name(&self) -> &str {
+        "RetireFile"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        self.execute_changed().map(|_| ())
+    }
+
+    fn execute_changed(&self) -> Result<bool, String> {
+        let done = crate::state::retire(&self.path)?;
+        for message in &done {
+            log::info!("{}", message);
+        }
+        Ok(!done.is_empty())
+    }
+
+    fn check(&self) -> Option<bool> {
+        let state = match State::load(&crate::state::state_dir()) {
+            Ok(state) => state,
+            Err(e) => {
+                log::warn!(
+                    "Can't tell whether DHD wrote {}: {}",
+                    self.path.display(),
+                    e
+                );
+                return None;
+            }
+        };
+        let recorded = !state.changes_to(&self.path).is_empty();
+        if !recorded && std::fs::symlink_metadata(&self.path).is_ok() {
+            log::info!(
+                "{} wasn't written by DHD, leaving it alone",
+                self.path.display()
+            );
+        }
+        Some(recorded)
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
+    }
+
+    fn describe(&self) -> String {
+        format!("Remove {} if DHD wrote it", self.path.display())
+    }
+}
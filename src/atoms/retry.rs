@@ -0,0 +1,222 @@
+use crate::atom::Destruction;
+use crate::atoms::Atom;
+use crate::diff::FileChange;
+use std::time::Duration;
+
+/// How often and how patiently a network-bound atom is retried
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct RetryPolicy {
+    /// Attempts after the first one
+    pub retries: u32,
+    /// Wait before the first retry; doubled for each one after it
+    pub delay: Duration,
+}
+
+impl Default for RetryPolicy {
+    fn default() -> Self {
+        Self {
+            retries: 2,
+            delay: Duration::from_secs(1),
+        }
+    }
+}
+
+impl RetryPolicy {
+    /// A policy from the `retries` and `retryDelay` (seconds) of an action
+    pub fn new(retries: Option<u32>, delay_secs: Option<u64>) -> Self {
+        let default = Self::default();
+        Self {
+            retries: retries.unwrap_or(default.retries),
+            delay: delay_secs.map(Duration::from_secs).unwrap_or(default.delay),
+        }
+    }
+}
+
+/// Messages of failures that may go away by themselves
+const TRANSIENT: &[&str] = &[
+    "timed out",
+    "timeout",
+    "temporary failure",
+    "could not resolve",
+    "name resolution",
+    "network is unreachable",
+    "connection refused",
+    "connection reset",
+    "reset by peer",
+    "early eof",
+    "rpc failed",
+    "handshake",
+    "failed retrieving file",
+    "error: 429",
+    "error: 502",
+    "error: 503",
+    "error: 504",
+];
+
+/// Whether an error looks like a flaky network rather than a real problem
+pub fn is_transient(error: &str) -> bool {
+    let error = error.to_lowercase();
+    TRANSIENT.iter().any(|pattern| error.contains(pattern))
+}
+
+/// Retry an atom whose failures look transient, with exponential backoff
+///
+/// Everything but `execute` is forwarded to the wrapped atom.
+pub struct Retry {
+    atom: Box<dyn Atom>,
+    policy: RetryPolicy,
+}
+
+impl Retry {
+    pub fn new(atom: Box<dyn Atom>, policy: RetryPolicy) -> Self {
+        Self { atom, policy }
+    }
+}
+
+impl Atom for Retry {
+    fn name(&self) -> &str {
+        self.atom.name()
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        let mut delay = self.policy.delay;
+        let mut attempt = 1;
+        loop {
+            let error = match self.atom.execute() {
+                Ok(()) => return Ok(()),
+                Err(error) => error,
+            };
+
+            if !is_transient(&error) {
+                return Err(match attempt {
+                    1 => error,
+                    _ => format!("{} (after {} attempts)", error, attempt),
+                });
+            }
+            if attempt > self.policy.retries {
+                return Err(format!("{} (gave up after {} attempts)", error, attempt));
+            }
+
+            log::warn!(
+                "{} failed (attempt {} of {}), retrying in {}s: {}",
+                self.atom.describe(),
+                attempt,
+                self.policy.retries + 1,
+                delay.as_secs_f32(),
+                error.trim()
+            );
+            std::thread::sleep(delay);
+            delay *= 2;
+            attempt += 1;
+        }
+    }
+
+    fn describe(&self) -> String {
+        self.atom.describe()
+    }
+
+    fn check(&self) -> Option<bool> {
+        self.atom.check()
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.atom.audit()
+    }
+
+    fn file_change(&self) -> Option<Result<FileChange, String>> {
+        self.atom.file_change()
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        self.atom.destruction()
+    }
+
+    fn managed_paths(&self) -> Result<Vec<std::path::PathBuf>, String> {
+        self.atom.managed_paths()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::{Arc, Mutex};
+
+    /// Fails with the given errors in turn, then succeeds
+    struct Flaky {
+        errors: Mutex<Vec<&'static str>>,
+        attempts: Arc<Mutex<u32>>,
+    }
+
+    impl Atom for Flaky {
+        fn name(&self) -> &str {
+            "Flaky"
+        }
+
+        fn execute(&self) -> Result<(), String> {
+            *self.attempts.lock().unwrap() += 1;
+            match self.errors.lock().unwrap().pop() {
+                Some(error) => Err(error.to_string()),
+                None => Ok(()),
+            }
+        }
+
+        fn describe(&self) -> String {
+            "Fetch".to_string()
+        }
+    }
+
+    /// Run a flaky atom under retries, returning the result and the number of attempts
+    fn retry(errors: &[&'static str], retries: u32) -> (Result<(), String>, u32) {
+        let attempts = Arc::new(Mutex::new(0));
+        let flaky = Flaky {
+            errors: Mutex::new(errors.iter().rev().copied().collect()),
+            attempts: attempts.clone(),
+        };
+        let policy = RetryPolicy {
+            retries,
+            delay: Duration::ZERO,
+        };
+
+        let result = Retry::new(Box::new(flaky), policy).execute();
+        let attempts = *attempts.lock().unwrap();
+        (result, attempts)
+    }
+
+    #[test]
+    fn test_transient_failures_are_retried() {
+        let (result, attempts) = retry(&["fatal: unable to access: Connection timed out"], 2);
+        assert!(result.is_ok());
+        assert_eq!(attempts, 2);
+    }
+
+    #[test]
+    fn test_retries_run_out() {
+        let error = "curl: (22) The requested URL returned error: 503";
+        let (result, attempts) = retry(&[error, error, error], 2);
+        assert_eq!(attempts, 3);
+        assert_eq!(
+            result.unwrap_err(),
+            format!("{} (gave up after 3 attempts)", error)
+        );
+    }
+
+    #[test]
+    fn test_permanent_failures_are_not_retried() {
+        let (result, attempts) = retry(&["E: Unable to locate package nope"], 2);
+        assert_eq!(attempts, 1);
+        assert_eq!(result.unwrap_err(), "E: Unable to locate package nope");
+
+        let (result, attempts) = retry(&["Connection reset by peer", "404 Not Found"], 2);
+        assert_eq!(attempts, 2);
+        assert_eq!(result.unwrap_err(), "404 Not Found (after 2 attempts)");
+    }
+
+    #[test]
+    fn test_policy_defaults() {
+        assert_eq!(RetryPolicy::new(None, None), RetryPolicy::default());
+        assert_eq!(
+            RetryPolicy::new(Some(0), Some(5)).delay,
+            Duration::from_secs(5)
+        );
+    }
+}
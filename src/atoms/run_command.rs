@@ -1,4 +1,5 @@
 use crate::atoms::Atom;
+use crate::logging::LoggedCommand;
 use std::collections::HashMap;
 use std::process::Command;
 
@@ -58,13 +59,18 @@ impl Atom for RunCommand {
     }
 
     fn execute(&self) -> Result<(), String> {
-        let mut cmd = if self.escalate {
+        log::trace!("Running '{}' with {}", self.command, self.shell);
+        // Root needs no escalation
+        let mut cmd = if self.escalate && !crate::privilege::is_root() {
             let escalation_tool = self.get_escalation_tool()?;
+            if escalation_tool == "sudo" {
+                crate::privilege::ensure_root()?;
+            }
             let mut c = Command::new(&escalation_tool);
             c.arg(&self.shell).arg("-c").arg(&self.command);
             c
         } else {
-            let mut c = Command::new(&self.shell);
+            let mut c = crate::target_user::command(&self.shell);
             c.arg("-c").arg(&self.command);
             c
         };
@@ -77,7 +83,7 @@ impl Atom for RunCommand {
         }
 
         let output = cmd
-            .output()
+            .logged_output()
             .map_err(|e| format!("Failed to execute command: {}", e))?;
 
         if !output.status.success() {
@@ -0,0 +1,306 @@
+use crate::atoms::{Atom, network};
+use crate::logging::LoggedCommand;
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use std::collections::HashMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+
+/// Where the runs of install scripts are remembered
+/// (`$XDG_STATE_HOME/dhd/scripts`, or `$DHD_HOME/state/scripts`)
+pub fn records_dir() -> PathBuf {
+    crate::state::state_dir().join("scripts")
+}
+
+/// The script and version that last ran from a URL
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+struct Ran {
+    sha256: String,
+    version: Option<String>,
+}
+
+/// Download an install script, run it once its sha256 matches, and remember
+/// that it ran, so it only runs again for another script or version
+#[derive(Debug, Clone)]
+pub struct ScriptInstall {
+    pub url: String,
+    pub sha256: String,
+    pub shell: String,
+    pub args: Vec<String>,
+    /// Variables set for the script, over the inherited ones
+    pub env: HashMap<String, String>,
+    pub version: Option<String>,
+    pub escalate: bool,
+    pub records: PathBuf,
+    pub cache: PathBuf,
+}
+
+impl ScriptInstall {
+    pub fn new(url: String, sha256: String, shell: String, version: Option<String>) -> Self {
+        Self {
+            url,
+            sha256: sha256.to_lowercase(),
+            shell,
+            args: Vec::new(),
+            env: HashMap::new(),
+            version,
+            escalate: false,
+            records: records_dir(),
+            cache: crate::atoms::remote_file::cache_dir(),
+        }
+    }
+
+    /// Pass `args` to the script and run it with `env`
+    pub fn with_command(mut self, args: Vec<String>, env: HashMap<String, String>) -> Self {
+        self.args = args;
+        self.env = env;
+        self
+    }
+
+    /// Run the script as root
+    pub fn with_escalate(mut self, escalate: bool) -> Self {
+        self.escalate = escalate;
+        self
+    }
+
+    /// Remember runs in `records` and stage downloads in `cache`
+    pub fn with_dirs(mut self, records: PathBuf, cache: PathBuf) -> Self {
+        self.records = records;
+        self.cache = cache;
+        self
+    }
+
+    /// File name for this URL's record and download
+    fn key(&self) -> String {
+        Sha256::digest(self.url.as_bytes())
+            .iter()
+            .take(8)
+            .map(|b| format!("{:02x}", b))
+            .collect()
+    }
+
+    fn record_file(&self) -> PathBuf {
+        self.records.join(format!("{}.json", self.key()))
+    }
+
+    /// Whether this script and version ran before
+    fn has_run(&self) -> bool {
+        let Ok(content) = fs::read_to_string(self.record_file()) else {
+            return false;
+        };
+        serde_json::from_str::<Ran>(&content)
+            .is_ok_and(|ran| ran.sha256 == self.sha256 && ran.version == self.version)
+    }
+
+    fn remember(&self) -> Result<(), String> {
+        let ran = Ran {
+            sha256: self.sha256.clone(),
+            version: self.version.clone(),
+        };
+        let content = serde_json::to_string_pretty(&ran)
+            .map_err(|e| format!("Failed to serialize the run of {}: {}", self.url, e))?;
+        fs::create_dir_all(&self.records)
+            .map_err(|e| format!("Failed to create {}: {}", self.records.display(), e))?;
+        fs::write(self.record_file(), content).map_err(|e| {
+            format!(
+                "Failed to remember the run of {} in {}: {}",
+                self.url,
+                self.record_file().display(),
+                e
+            )
+        })
+    }
+
+    /// Download the script to `script`, failing unless its sha256 matches
+    fn download(&self, script: &Path) -> Result<(), String> {
+        let _network = network::acquire(&self.describe());
+        let output = Command::new("curl")
+            .arg("-fsSL")
+            .arg("-o")
+            .arg(script)
+            .arg(&self.url)
+            .logged_output()
+            .map_err(|e| format!("Failed to download {}: {}", self.url, e))?;
+        if !output.status.success() {
+            let _ = fs::remove_file(script);
+            return Err(format!(
+                "Failed to download {}: {}",
+                self.url,
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+
+        let actual = crate::atoms::remote_file::sha256_file(script)?;
+        if actual != self.sha256 {
+            let _ = fs::remove_file(script);
+            return Err(format!(
+                "Checksum mismatch for {}: expected {}, got {}; the script was not run",
+                self.url, self.sha256, actual
+            ));
+        }
+        Ok(())
+    }
+
+    fn command(&self, script: &Path) -> Result<Command, String> {
+        log::trace!("Running {} with {}", self.url, self.shell);
+        if !self.escalate {
+            let mut cmd = crate::target_user::command(&self.shell);
+            cmd.arg(script).args(&self.args).envs(&self.env);
+            return Ok(cmd);
+        }
+
+        // sudo resets the environment, so `env` sets it inside
+        let mut cmd = crate::privilege::root_command("env")?;
+        let mut env: Vec<_> = self.env.iter().collect();
+        env.sort();
+        for (name, value) in env {
+            cmd.arg(format!("{}={}", name, value));
+        }
+        cmd.arg(&self.shell).arg(script).args(&self.args);
+        Ok(cmd)
+    }
+
+    fn run(&self, script: &Path) -> Result<(), String> {
+        let output = self
+            .command(script)?
+            .logged_output()
+            .map_err(|e| format!("Failed to run {} with {}: {}", self.url, self.shell, e))?;
+        if !output.status.success() {
+            return Err(format!(
+                "Install script {} failed with exit code {}\nstdout: {}\nstderr: {}",
+                self.url,
+                output
+                    .status
+                    .code()
+                    .map_or("none".to_string(), |code| code.to_string()),
+                String::from_utf8_lossy(&output.stdout).trim(),
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+        Ok(())
+    }
+}
+
+impl Atom for ScriptInstall {
+    fn name(&self) -> &str {
+        "ScriptInstall"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        self.execute_changed().map(|_| ())
+    }
+
+    fn execute_changed(&self) -> Result<bool, String> {
+        if self.has_run() {
+            return Ok(false);
+        }
+        network::require(&self.describe())?;
+
+        fs::create_dir_all(&self.cache)
+            .map_err(|e| format!("Failed to create {}: {}", self.cache.display(), e))?;
+        let script = self.cache.join(format!("{}.sh", self.key()));
+        self.download(&script)?;
+        let ran = self.run(&script);
+        let _ = fs::remove_file(&script);
+        ran?;
+
+        self.remember()?;
+        Ok(true)
+    }
+
+    fn check(&self) -> Option<bool> {
+        Some(!self.has_run())
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
+    }
+
+    fn describe(&self) -> String {
+        match &self.version {
+            Some(version) => format!("Run install script {} ({})", self.url, version),
+            None => format!("Run install script {}", self.url),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    const SCRIPT: &str = "#!/bin/sh\necho \"$GREETING $1\" >> \"$OUT\"\n";
+
+    fn sha256(content: &str) -> String {
+        Sha256::digest(content.as_bytes())
+            .iter()
+            .map(|b| format!("{:02x}", b))
+            .collect()
+    }
+
+    fn script_install(temp_dir: &TempDir, sha256: String, version: Option<&str>) -> ScriptInstall {
+        let source = temp_dir.path().join("install.sh");
+        fs::write(&source, SCRIPT).unwrap();
+        let env = HashMap::from([
+            ("GREETING".to_string(), "hello".to_string()),
+            (
+                "OUT".to_string(),
+                temp_dir.path().join("out").to_string_lossy().into_owned(),
+            ),
+        ]);
+        ScriptInstall::new(
+            format!("file://{}", source.display()),
+            sha256,
+            "sh".to_string(),
+            version.map(String::from),
+        )
+        .with_command(vec!["world".to_string()], env)
+        .with_dirs(
+            temp_dir.path().join("records"),
+            temp_dir.path().join("cache"),
+        )
+    }
+
+    #[test]
+    fn test_script_install_runs_once_per_version() {
+        let temp_dir = TempDir::new().unwrap();
+        let out = temp_dir.path().join("out");
+
+        let atom = script_install(&temp_dir, sha256(SCRIPT), Some("1.0"));
+        assert_eq!(atom.check(), Some(true));
+        assert_eq!(atom.execute_changed(), Ok(true));
+        assert_eq!(fs::read_to_string(&out).unwrap(), "hello world\n");
+        assert_eq!(atom.check(), Some(false));
+        assert_eq!(atom.execute_changed(), Ok(false));
+        assert_eq!(fs::read_to_string(&out).unwrap(), "hello world\n");
+
+        let upgrade = script_install(&temp_dir, sha256(SCRIPT), Some("1.1"));
+        assert_eq!(upgrade.check(), Some(true));
+        assert_eq!(upgrade.execute_changed(), Ok(true));
+        assert_eq!(
+            fs::read_to_string(&out).unwrap(),
+            "hello world\nhello world\n"
+        );
+        assert_eq!(
+            upgrade.describe(),
+            format!("Run install script {} (1.1)", upgrade.url)
+        );
+    }
+
+    #[test]
+    fn test_script_install_refuses_a_mismatched_script() {
+        let temp_dir = TempDir::new().unwrap();
+        let atom = script_install(&temp_dir, sha256("something else"), None);
+
+        let error = atom.execute_changed().unwrap_err();
+        assert!(
+            error.starts_with("Checksum mismatch for file://"),
+            "{}",
+            error
+        );
+        assert!(error.ends_with("the script was not run"), "{}", error);
+        assert!(!temp_dir.path().join("out").exists());
+        assert_eq!(atom.check(), Some(true));
+    }
+}
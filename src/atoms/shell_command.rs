@@ -0,0 +1,320 @@
+use crate::atoms::Atom;
+use crate::logging::LoggedCommand;
+use std::collections::HashMap;
+use std::path::PathBuf;
+use std::process::{Command, Output};
+
+/// Runs a shell command, optionally guarded by `only_if`/`unless` commands
+#[derive(Debug, Clone)]
+pub struct ShellCommand {
+    pub shell: String,
+    pub run: String,
+    /// Only run when this command succeeds
+    pub only_if: Option<String>,
+    /// Skip running when this command succeeds
+    pub unless: Option<String>,
+    pub cwd: Option<PathBuf>,
+    /// Variables set for `run` and its guards, over the inherited ones
+    pub env: HashMap<String, String>,
+    /// Run after `run`; `run` changed something only if this succeeds
+    pub changed_when: Option<String>,
+    /// Run after `run` instead of checking its exit code; `run` failed if
+    /// this succeeds
+    pub failed_when: Option<String>,
+}
+
+impl ShellCommand {
+    pub fn new(
+        shell: String,
+        run: String,
+        only_if: Option<String>,
+        unless: Option<String>,
+        cwd: Option<PathBuf>,
+    ) -> Self {
+        Self {
+            shell,
+            run,
+            only_if,
+            unless,
+            cwd,
+            env: HashMap::new(),
+            changed_when: None,
+            failed_when: None,
+        }
+    }
+
+    /// Decide whether the command changed or failed with commands of its own
+    pub fn with_outcome(
+        mut self,
+        changed_when: Option<String>,
+        failed_when: Option<String>,
+    ) -> Self {
+        self.changed_when = changed_when;
+        self.failed_when = failed_when;
+        self
+    }
+
+    /// Set `env` for the commands, masking inherited variables of the same name
+    pub fn with_env(mut self, env: HashMap<String, String>) -> Self {
+        self.env = env;
+        self
+    }
+
+    fn command(&self, command: &str) -> Command {
+        log::trace!("Running '{}' with {}", command, self.shell);
+        let mut cmd = crate::target_user::command(&self.shell);
+        cmd.arg("-c").arg(command).envs(&self.env);
+        if let Some(cwd) = &self.cwd {
+            cmd.current_dir(cwd);
+        }
+        cmd
+    }
+
+    fn spawn(&self, command: &str) -> Result<Output, String> {
+        self.command(command)
+            .logged_output()
+            .map_err(|e| format!("Failed to run '{}' with {}: {}", command, self.shell, e))
+    }
+
+    /// Run `command` to judge the output of `run`, which it gets in
+    /// `DHD_EXIT_CODE`, `DHD_STDOUT` and `DHD_STDERR`
+    fn judge(&self, command: &str, output: &Output) -> Result<bool, String> {
+        let mut cmd = self.command(command);
+        cmd.env("DHD_EXIT_CODE", exit_code(output))
+            .env("DHD_STDOUT", String::from_utf8_lossy(&output.stdout).trim())
+            .env("DHD_STDERR", String::from_utf8_lossy(&output.stderr).trim());
+        let judged = cmd
+            .logged_output()
+            .map_err(|e| format!("Failed to run '{}' with {}: {}", command, self.shell, e))?;
+        Ok(judged.status.success())
+    }
+
+    fn guard_succeeds(&self, guard: &str) -> Result<bool, String> {
+        Ok(self.spawn(guard)?.status.success())
+    }
+
+    /// Evaluate the guards to decide whether `run` should execute
+    fn should_run(&self) -> Result<bool, String> {
+        if let Some(only_if) = &self.only_if {
+            if !self.guard_succeeds(only_if)? {
+                return Ok(false);
+            }
+        }
+
+        if let Some(unless) = &self.unless {
+            if self.guard_succeeds(unless)? {
+                return Ok(false);
+            }
+        }
+
+        Ok(true)
+    }
+}
+
+impl Atom for ShellCommand {
+    fn name(&self) -> &str {
+        "ShellCommand"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        self.execute_changed().map(|_| ())
+    }
+
+    fn execute_changed(&self) -> Result<bool, String> {
+        if !self.should_run()? {
+            return Ok(false);
+        }
+
+        let output = self.spawn(&self.run)?;
+        let failed = match &self.failed_when {
+            Some(failed_when) => self.judge(failed_when, &output)?,
+            None => !output.status.success(),
+        };
+        if failed {
+            let reason = match &self.failed_when {
+                Some(failed_when) => format!("failed (failed when: {})", failed_when),
+                None => format!("failed with exit code {}", exit_code(&output)),
+            };
+            return Err(format!(
+                "Command '{}' {}\nstdout: {}\nstderr: {}",
+                self.run,
+                reason,
+                String::from_utf8_lossy(&output.stdout).trim(),
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+
+        match &self.changed_when {
+            Some(changed_when) => self.judge(changed_when, &output),
+            None => Ok(true),
+        }
+    }
+
+    fn check(&self) -> Option<bool> {
+        // Without guards there is no way to tell whether the command is needed
+        if self.only_if.is_none() && self.unless.is_none() {
+            return None;
+        }
+
+        // Guards that can't be run are reported when the atom executes
+        Some(self.should_run().unwrap_or(true))
+    }
+
+    fn describe(&self) -> String {
+        let mut description = format!("Run command: {}", self.run);
+        if let Some(only_if) = &self.only_if {
+            description.push_str(&format!(" (only if: {})", only_if));
+        }
+        if let Some(unless) = &self.unless {
+            description.push_str(&format!(" (unless: {})", unless));
+        }
+        if let Some(changed_when) = &self.changed_when {
+            description.push_str(&format!(" (changed when: {})", changed_when));
+        }
+        if let Some(failed_when) = &self.failed_when {
+            description.push_str(&format!(" (failed when: {})", failed_when));
+        }
+        description
+    }
+}
+
+/// The exit code of `output`, or `signal` if a signal ended it
+fn exit_code(output: &Output) -> String {
+    output
+        .status
+        .code()
+        .map_or_else(|| "signal".to_string(), |code| code.to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+    use tempfile::TempDir;
+
+    fn shell_command(run: &str, only_if: Option<&str>, unless: Option<&str>) -> ShellCommand {
+        ShellCommand::new(
+            "sh".to_string(),
+            run.to_string(),
+            only_if.map(String::from),
+            unless.map(String::from),
+            None,
+        )
+    }
+
+    #[test]
+    fn test_shell_command_unless_guard() {
+        let temp_dir = TempDir::new().unwrap();
+        let marker = temp_dir.path().join("marker");
+        let run = format!("touch {}", marker.display());
+        let unless = format!("test -e {}", marker.display());
+
+        let atom = shell_command(&run, None, Some(&unless));
+        assert_eq!(atom.check(), Some(true));
+        assert!(atom.execute().is_ok());
+        assert!(marker.exists());
+        assert_eq!(atom.check(), Some(false));
+    }
+
+    #[test]
+    fn test_shell_command_only_if_guard() {
+        let temp_dir = TempDir::new().unwrap();
+        let marker = temp_dir.path().join("marker");
+        let run = format!("touch {}", marker.display());
+
+        let atom = shell_command(&run, Some("false"), None);
+        assert_eq!(atom.check(), Some(false));
+        assert!(atom.execute().is_ok());
+        assert!(!marker.exists());
+    }
+
+    #[test]
+    fn test_shell_command_without_guards_is_unchecked() {
+        assert_eq!(shell_command("true", None, None).check(), None);
+    }
+
+    #[test]
+    fn test_shell_command_runs_in_cwd() {
+        let temp_dir = TempDir::new().unwrap();
+        let atom = ShellCommand::new(
+            "sh".to_string(),
+            "pwd > out.txt".to_string(),
+            None,
+            None,
+            Some(temp_dir.path().to_path_buf()),
+        );
+
+        assert!(atom.execute().is_ok());
+        let out = fs::read_to_string(temp_dir.path().join("out.txt")).unwrap();
+        assert_eq!(
+            PathBuf::from(out.trim()).canonicalize().unwrap(),
+            temp_dir.path().canonicalize().unwrap()
+        );
+    }
+
+    #[test]
+    fn test_shell_command_env_masks_inherited_variables() {
+        let temp_dir = TempDir::new().unwrap();
+        let out = temp_dir.path().join("out.txt");
+        let env = HashMap::from([
+            ("HOME".to_string(), "/home/example".to_string()),
+            ("GREETING".to_string(), "hi".to_string()),
+        ]);
+        let atom = shell_command(
+            &format!("echo \"$GREETING $HOME\" > {}", out.display()),
+            Some(r#"test "$GREETING" = hi"#),
+            None,
+        )
+        .with_env(env);
+
+        assert_eq!(atom.check(), Some(true));
+        assert!(atom.execute().is_ok());
+        assert_eq!(fs::read_to_string(&out).unwrap(), "hi /home/example\n");
+    }
+
+    #[test]
+    fn test_shell_command_changed_when() {
+        let changed = shell_command("echo Installed", None, None)
+            .with_outcome(Some(r#"test "$DHD_STDOUT" = Installed"#.to_string()), None);
+        assert_eq!(changed.execute_changed(), Ok(true));
+
+        let unchanged = shell_command("echo Nothing to do", None, None)
+            .with_outcome(Some(r#"test "$DHD_STDOUT" = Installed"#.to_string()), None);
+        assert_eq!(unchanged.execute_changed(), Ok(false));
+        assert!(unchanged.describe().contains("(changed when: "));
+
+        assert_eq!(
+            shell_command("true", None, None).execute_changed(),
+            Ok(true)
+        );
+    }
+
+    #[test]
+    fn test_shell_command_failed_when() {
+        // Exit code 1 means "no updates" for this command, 2 an error
+        let atom = shell_command("exit 1", None, None)
+            .with_outcome(None, Some(r#"test "$DHD_EXIT_CODE" -gt 1"#.to_string()));
+        assert!(atom.execute().is_ok());
+
+        let atom = shell_command("echo broken >&2; exit 2", None, None)
+            .with_outcome(None, Some(r#"test "$DHD_EXIT_CODE" -gt 1"#.to_string()));
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("failed (failed when: "));
+        assert!(err.contains("broken"));
+
+        let atom = shell_command("echo ERROR: disk full", None, None).with_outcome(
+            None,
+            Some(r#"echo "$DHD_STDOUT" | grep -q ERROR"#.to_string()),
+        );
+        assert!(atom.execute().is_err());
+    }
+
+    #[test]
+    fn test_shell_command_failure_surfaces_output() {
+        let atom = shell_command("echo to-stdout; echo to-stderr >&2; exit 3", None, None);
+        let err = atom.execute().unwrap_err();
+        assert!(err.contains("exit code 3"));
+        assert!(err.contains("to-stdout"));
+        assert!(err.contains("to-stderr"));
+    }
+}
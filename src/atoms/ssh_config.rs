@@ -0,0 +1,239 @@
+use crate::atom::Destruction;
+use crate::atoms::Atom;
+use crate::atoms::block_in_file::{BlockInFile, file_change, write_file};
+use crate::diff::FileChange;
+use std::fs;
+use std::path::PathBuf;
+
+/// ssh refuses a config that others can write to
+const MODE: u32 = 0o600;
+
+/// Keep `Host` blocks in an SSH client config, each between dhd markers
+///
+/// Blocks dhd didn't write are left as they are. New blocks go before the
+/// first `Host *`, since ssh takes the first value it finds for a keyword.
+#[derive(Debug, Clone)]
+pub struct SshConfig {
+    pub path: PathBuf,
+    /// Each host alias with its block, or `None` to remove the block
+    pub hosts: Vec<(String, Option<String>)>,
+}
+
+impl SshConfig {
+    pub fn new(path: PathBuf, hosts: Vec<(String, Option<String>)>) -> Self {
+        Self { path, hosts }
+    }
+
+    fn blocks(&self) -> impl Iterator<Item = BlockInFile> + '_ {
+        self.hosts.iter().map(|(host, content)| {
+            BlockInFile::new(
+                self.path.clone(),
+                format!("Host {}", host),
+                content.clone(),
+                false,
+            )
+            .with_before("Host *")
+        })
+    }
+
+    fn change(&self) -> Result<FileChange, String> {
+        file_change(&self.path, |current| {
+            self.blocks().fold(current.to_string(), |content, block| {
+                block.desired(&content)
+            })
+        })
+    }
+
+    /// Whether the file has mode 0600, or doesn't exist
+    fn mode_matches(&self) -> bool {
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            fs::metadata(&self.path)
+                .map(|metadata| metadata.permissions().mode() & 0o7777 == MODE)
+                .unwrap_or(true)
+        }
+
+        #[cfg(not(unix))]
+        {
+            true
+        }
+    }
+
+    /// Give an unchanged file mode 0600
+    fn apply_mode(&self) -> Result<(), String> {
+        if self.mode_matches() {
+            return Ok(());
+        }
+
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            fs::set_permissions(&self.path, fs::Permissions::from_mode(MODE)).map_err(|e| {
+                format!(
+                    "Failed to set mode {:o} on {}: {}",
+                    MODE,
+                    self.path.display(),
+                    e
+                )
+            })?;
+        }
+        Ok(())
+    }
+
+    /// Create the directory of a new config private to its owner, like `~/.ssh`
+    fn create_dir(&self) -> Result<(), String> {
+        let Some(parent) = self.path.parent().filter(|parent| !parent.exists()) else {
+            return Ok(());
+        };
+        crate::target_user::create_dir_all(parent)
+            .map_err(|e| format!("Failed to create {}: {}", parent.display(), e))?;
+
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            fs::set_permissions(parent, fs::Permissions::from_mode(0o700))
+                .map_err(|e| format!("Failed to set mode 700 on {}: {}", parent.display(), e))?;
+        }
+        Ok(())
+    }
+}
+
+impl Atom for SshConfig {
+    fn name(&self) -> &str {
+        "SshConfig"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        let change = self.change()?;
+        if !change.is_changed() {
+            return self.apply_mode();
+        }
+        self.create_dir()?;
+        write_file(&change, Some(MODE), false)
+    }
+
+    fn check(&self) -> Option<bool> {
+        self.change()
+            .ok()
+            .map(|change| change.is_changed() || !self.mode_matches())
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
+    }
+
+    fn file_change(&self) -> Option<Result<FileChange, String>> {
+        Some(self.change())
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        // Only the blocks between the markers are managed
+        None
+    }
+
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        Ok(vec![self.path.clone()])
+    }
+
+    fn describe(&self) -> String {
+        let hosts = |present: bool| {
+            self.hosts
+                .iter()
+                .filter(|(_, content)| content.is_some() == present)
+                .map(|(host, _)| host.as_str())
+                .collect::<Vec<_>>()
+                .join(", ")
+        };
+        let (updated, removed) = (hosts(true), hosts(false));
+        let path = self.path.display();
+        match (updated.is_empty(), removed.is_empty()) {
+            (_, true) => format!("Update Host {} in {}", updated, path),
+            (true, false) => format!("Remove Host {} from {}", removed, path),
+            (false, false) => format!(
+                "Update Host {} and remove Host {} in {}",
+                updated, removed, path
+            ),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn host(host: &str, content: Option<&str>) -> (String, Option<String>) {
+        (host.to_string(), content.map(String::from))
+    }
+
+    #[test]
+    fn test_host_blocks_are_added_updated_and_removed() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("config");
+        fs::write(
+            &path,
+            "Host nas\n    User admin\nHost *\n    AddKeysToAgent yes\n",
+        )
+        .unwrap();
+
+        let config = SshConfig::new(
+            path.clone(),
+            vec![
+                host("work", Some("Host work\n    User me\n")),
+                host("old", Some("Host old\n")),
+            ],
+        );
+        assert_eq!(config.check(), Some(true));
+        config.execute().unwrap();
+        assert_eq!(config.check(), Some(false));
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            "Host nas\n    User admin\n\
+             # BEGIN dhd:Host work\nHost work\n    User me\n# END dhd:Host work\n\
+             # BEGIN dhd:Host old\nHost old\n# END dhd:Host old\n\
+             Host *\n    AddKeysToAgent yes\n"
+        );
+
+        let config = SshConfig::new(
+            path.clone(),
+            vec![
+                host("work", Some("Host work\n    User you\n")),
+                host("old", None),
+            ],
+        );
+        assert_eq!(
+            config.describe(),
+            format!("Update Host work and remove Host old in {}", path.display())
+        );
+        config.execute().unwrap();
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            "Host nas\n    User admin\n\
+             # BEGIN dhd:Host work\nHost work\n    User you\n# END dhd:Host work\n\
+             Host *\n    AddKeysToAgent yes\n"
+        );
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_config_is_kept_private() {
+        use std::os::unix::fs::PermissionsExt;
+
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join(".ssh/config");
+        let config = SshConfig::new(path.clone(), vec![host("work", Some("Host work\n"))]);
+        config.execute().unwrap();
+        let mode =
+            |path: &std::path::Path| fs::metadata(path).unwrap().permissions().mode() & 0o777;
+        assert_eq!(mode(&path), 0o600);
+        assert_eq!(mode(path.parent().unwrap()), 0o700);
+
+        // A config others can read is fixed even when its blocks are up to date
+        fs::set_permissions(&path, fs::Permissions::from_mode(0o644)).unwrap();
+        assert_eq!(config.check(), Some(true));
+        config.execute().unwrap();
+        assert_eq!(mode(&path), 0o600);
+        assert_eq!(config.check(), Some(false));
+    }
+}
@@ -0,0 +1,367 @@
+use crate::atom::Destruction;
+use crate::atoms::Atom;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// Entries of a stow package that are never linked
+const IGNORED: &[&str] = &[".git"];
+
+/// What to do with a file already in the way of a link
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum Conflict {
+    /// Leave it and fail
+    Fail,
+    /// Move it into the package, replacing the package's copy, then link it
+    Adopt,
+    /// Replace it with the link
+    Force,
+}
+
+/// Mirror the tree under `source` into `target` with a symlink per file,
+/// or remove those symlinks again with `delete`
+#[derive(Debug, Clone)]
+pub struct Stow {
+    pub source: PathBuf,
+    pub target: PathBuf,
+    pub delete: bool,
+    pub conflict: Conflict,
+}
+
+/// Where a file of the package is linked from
+struct Entry {
+    /// The file inside the package
+    file: PathBuf,
+    /// The symlink in the target tree
+    link: PathBuf,
+}
+
+impl Entry {
+    fn is_linked(&self) -> bool {
+        self.link.is_symlink() && fs::read_link(&self.link).is_ok_and(|to| to == self.file)
+    }
+
+    /// Something other than our link at the link's path
+    fn is_blocked(&self) -> bool {
+        !self.is_linked() && (self.link.exists() || self.link.is_symlink())
+    }
+}
+
+impl Stow {
+    fn entries(&self) -> Result<Vec<Entry>, String> {
+        let mut entries = Vec::new();
+        self.collect(&self.source, &mut entries)?;
+        entries.sort_by(|a, b| a.file.cmp(&b.file));
+        Ok(entries)
+    }
+
+    fn collect(&self, dir: &Path, entries: &mut Vec<Entry>) -> Result<(), String> {
+        let read =
+            fs::read_dir(dir).map_err(|e| format!("Failed to read {}: {}", dir.display(), e))?;
+        for entry in read {
+            let entry = entry.map_err(|e| format!("Failed to read {}: {}", dir.display(), e))?;
+            if IGNORED.iter().any(|ignored| entry.file_name() == *ignored) {
+                continue;
+            }
+            let path = entry.path();
+            // Symlinks inside the package are linked like files
+            if path.is_dir() && !path.is_symlink() {
+                self.collect(&path, entries)?;
+            } else if let Ok(relative) = path.strip_prefix(&self.source) {
+                entries.push(Entry {
+                    link: self.target.join(relative),
+                    file: path,
+                });
+            }
+        }
+        Ok(())
+    }
+
+    fn link(&self, entry: &Entry) -> Result<(), String> {
+        if let Some(parent) = entry.link.parent() {
+            crate::target_user::create_dir_all(parent)
+                .map_err(|e| format!("Failed to create {}: {}", parent.display(), e))?;
+        }
+
+        // Whatever is replaced has to be saved before it is moved or removed
+        let previous = crate::state::preserve(&entry.link);
+        if entry.is_blocked() {
+            match self.conflict {
+                Conflict::Fail => unreachable!("conflicts are refused before linking"),
+                Conflict::Adopt => adopt(&entry.link, &entry.file)?,
+                Conflict::Force => remove(&entry.link)?,
+            }
+        }
+
+        #[cfg(unix)]
+        std::os::unix::fs::symlink(&entry.file, &entry.link).map_err(|e| {
+            format!(
+                "Failed to create symlink at {} pointing to {}: {}",
+                entry.link.display(),
+                entry.file.display(),
+                e
+            )
+        })?;
+        #[cfg(unix)]
+        crate::target_user::give(&entry.link)?;
+        #[cfg(not(unix))]
+        return Err("Symlink creation is only supported on Unix systems".to_string());
+
+        if let Some(previous) = previous {
+            crate::state::record(crate::state::Change::Symlink {
+                path: entry.link.clone(),
+                target: entry.file.clone(),
+                previous,
+            });
+        }
+        Ok(())
+    }
+
+    fn unlink(&self, entry: &Entry) -> Result<(), String> {
+        fs::remove_file(&entry.link)
+            .map_err(|e| format!("Failed to remove symlink {}: {}", entry.link.display(), e))?;
+
+        // Drop directories left empty, up to the target itself
+        let mut dir = entry.link.parent();
+        while let Some(current) = dir {
+            if current == self.target || !current.starts_with(&self.target) {
+                break;
+            }
+            if fs::remove_dir(current).is_err() {
+                break;
+            }
+            dir = current.parent();
+        }
+        Ok(())
+    }
+}
+
+/// Move the file at `link` over the package's `file`
+fn adopt(link: &Path, file: &Path) -> Result<(), String> {
+    if link.is_dir() && !link.is_symlink() {
+        return Err(format!(
+            "Failed to adopt {}: it is a directory",
+            link.display()
+        ));
+    }
+    if fs::rename(link, file).is_err() {
+        // Across filesystems the file has to be copied
+        fs::copy(link, file)
+            .and_then(|_| fs::remove_file(link))
+            .map_err(|e| format!("Failed to adopt {}: {}", link.display(), e))?;
+    }
+    Ok(())
+}
+
+fn remove(path: &Path) -> Result<(), String> {
+    let result = if path.is_dir() && !path.is_symlink() {
+        fs::remove_dir_all(path)
+    } else {
+        fs::remove_file(path)
+    };
+    result.map_err(|e| format!("Failed to remove {}: {}", path.display(), e))
+}
+
+impl Atom for Stow {
+    fn name(&self) -> &str {
+        "Stow"
+    }
+
+    fn execute(&self) -> Result<(), String> {
+        let entries = self.entries()?;
+
+        if self.delete {
+            for entry in entries.iter().filter(|entry| entry.is_linked()) {
+                self.unlink(entry)?;
+                log::info!("Removed {}", entry.link.display());
+            }
+            return Ok(());
+        }
+
+        // Refuse before touching anything, so a conflict leaves no half-linked tree
+        if self.conflict == Conflict::Fail {
+            let blocked: Vec<String> = entries
+                .iter()
+                .filter(|entry| entry.is_blocked())
+                .map(|entry| entry.link.display().to_string())
+                .collect();
+            if !blocked.is_empty() {
+                return Err(format!(
+                    "Failed to stow {}: {} already exist (set adopt or force to replace them)",
+                    self.source.display(),
+                    blocked.join(", ")
+                ));
+            }
+        }
+
+        for entry in entries.iter().filter(|entry| !entry.is_linked()) {
+            self.link(entry)?;
+            log::info!(
+                "Linked {} -> {}",
+                entry.link.display(),
+                entry.file.display()
+            );
+        }
+        Ok(())
+    }
+
+    fn check(&self) -> Option<bool> {
+        let entries = self.entries().ok()?;
+        Some(if self.delete {
+            entries.iter().any(Entry::is_linked)
+        } else {
+            !entries.iter().all(Entry::is_linked)
+        })
+    }
+
+    fn audit(&self) -> Option<bool> {
+        self.check()
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        if self.delete {
+            return None;
+        }
+        let blocked = self.entries().ok()?.into_iter().find(Entry::is_blocked)?;
+        match self.conflict {
+            Conflict::Fail => None,
+            // Adopting replaces the package's copy with the file in the way
+            Conflict::Adopt => Some(Destruction::Overwrite(blocked.file)),
+            Conflict::Force => Some(Destruction::Overwrite(blocked.link)),
+        }
+    }
+
+    fn managed_paths(&self) -> Result<Vec<PathBuf>, String> {
+        Ok(self
+            .entries()?
+            .into_iter()
+            .map(|entry| entry.link)
+            .collect())
+    }
+
+    fn describe(&self) -> String {
+        if self.delete {
+            format!(
+                "Remove links into {} from {}",
+                self.source.display(),
+                self.target.display()
+            )
+        } else {
+            format!(
+                "Link files of {} into {}",
+                self.source.display(),
+                self.target.display()
+            )
+        }
+    }
+}
+
+#[cfg(test)]
+#[cfg(unix)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    fn package(temp_dir: &TempDir) -> Stow {
+        let source = temp_dir.path().join("dotfiles/nvim");
+        fs::create_dir_all(source.join(".config/nvim/lua")).unwrap();
+        fs::create_dir_all(source.join(".git")).unwrap();
+        fs::write(source.join(".config/nvim/init.lua"), "-- init").unwrap();
+        fs::write(source.join(".config/nvim/lua/keys.lua"), "-- keys").unwrap();
+        fs::write(source.join(".git/HEAD"), "ref").unwrap();
+
+        let target = temp_dir.path().join("home");
+        fs::create_dir_all(&target).unwrap();
+        Stow {
+            source,
+            target,
+            delete: false,
+            conflict: Conflict::Fail,
+        }
+    }
+
+    #[test]
+    fn test_stow_links_every_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let stow = package(&temp_dir);
+
+        assert_eq!(stow.check(), Some(true));
+        stow.execute().unwrap();
+        assert_eq!(stow.check(), Some(false));
+
+        let init = stow.target.join(".config/nvim/init.lua");
+        assert_eq!(
+            fs::read_link(&init).unwrap(),
+            stow.source.join(".config/nvim/init.lua")
+        );
+        assert!(stow.target.join(".config/nvim/lua/keys.lua").is_symlink());
+        // Directories are created, not linked
+        assert!(!stow.target.join(".config").is_symlink());
+        assert!(!stow.target.join(".git").exists());
+
+        // A second run has nothing to do
+        stow.execute().unwrap();
+    }
+
+    #[test]
+    fn test_stow_refuses_to_clobber_files() {
+        let temp_dir = TempDir::new().unwrap();
+        let stow = package(&temp_dir);
+        let init = stow.target.join(".config/nvim/init.lua");
+        fs::create_dir_all(init.parent().unwrap()).unwrap();
+        fs::write(&init, "-- mine").unwrap();
+
+        let error = stow.execute().unwrap_err();
+        assert!(error.contains("init.lua"), "{}", error);
+        assert!(error.contains("adopt or force"), "{}", error);
+        assert!(!stow.target.join(".config/nvim/lua").exists());
+        assert_eq!(stow.destruction(), None);
+    }
+
+    #[test]
+    fn test_stow_adopt_and_force() {
+        let temp_dir = TempDir::new().unwrap();
+        let mut stow = package(&temp_dir);
+        let init = stow.target.join(".config/nvim/init.lua");
+        fs::create_dir_all(init.parent().unwrap()).unwrap();
+        fs::write(&init, "-- mine").unwrap();
+
+        stow.conflict = Conflict::Adopt;
+        let file = stow.source.join(".config/nvim/init.lua");
+        assert_eq!(
+            stow.destruction(),
+            Some(Destruction::Overwrite(file.clone()))
+        );
+        stow.execute().unwrap();
+        assert!(init.is_symlink());
+        assert_eq!(fs::read_to_string(&file).unwrap(), "-- mine");
+
+        fs::remove_file(&init).unwrap();
+        fs::write(&init, "-- replaced").unwrap();
+        stow.conflict = Conflict::Force;
+        assert_eq!(
+            stow.destruction(),
+            Some(Destruction::Overwrite(init.clone()))
+        );
+        stow.execute().unwrap();
+        assert!(init.is_symlink());
+        assert_eq!(fs::read_to_string(&file).unwrap(), "-- mine");
+    }
+
+    #[test]
+    fn test_stow_delete_removes_only_its_links() {
+        let temp_dir = TempDir::new().unwrap();
+        let mut stow = package(&temp_dir);
+        stow.execute().unwrap();
+        let other = stow.target.join(".config/other.conf");
+        fs::write(&other, "kept").unwrap();
+
+        stow.delete = true;
+        assert_eq!(stow.check(), Some(true));
+        stow.execute().unwrap();
+        assert_eq!(stow.check(), Some(false));
+
+        assert!(!stow.target.join(".config/nvim").exists());
+        assert!(other.exists());
+        assert!(stow.source.join(".config/nvim/init.lua").exists());
+    }
+}
@@ -1,4 +1,5 @@
 use crate::atoms::Atom;
+use crate::logging::LoggedCommand;
 use std::process::Command;
 
 #[derive(Debug, Clone, PartialEq)]
@@ -8,6 +9,7 @@ pub enum SystemdOperation {
     Start,
     Stop,
     Restart,
+    Reload,
     EnableNow,
     DisableNow,
 }
@@ -56,6 +58,10 @@ impl SystemdManage {
                 args.push("restart");
                 args.push(&self.name);
             }
+            SystemdOperation::Reload => {
+                args.push("reload");
+                args.push(&self.name);
+            }
             SystemdOperation::EnableNow => {
                 args.push("enable");
                 args.push("--now");
@@ -78,11 +84,18 @@ impl Atom for SystemdManage {
     }
 
     fn execute(&self) -> Result<(), String> {
+        crate::platform::require_linux("systemdManage")?;
+
         let args = self.get_systemctl_args();
 
-        let output = Command::new("systemctl")
+        let mut cmd = if self.scope == "user" {
+            crate::target_user::command("systemctl")
+        } else {
+            Command::new("systemctl")
+        };
+        let output = cmd
             .args(&args)
-            .output()
+            .logged_output()
             .map_err(|e| format!("Failed to execute systemctl: {}", e))?;
 
         if !output.status.success() {
@@ -94,6 +107,7 @@ impl Atom for SystemdManage {
                     SystemdOperation::Start => "start",
                     SystemdOperation::Stop => "stop",
                     SystemdOperation::Restart => "restart",
+                    SystemdOperation::Reload => "reload",
                     SystemdOperation::EnableNow => "enable and start",
                     SystemdOperation::DisableNow => "disable and stop",
                 },
@@ -114,6 +128,7 @@ impl Atom for SystemdManage {
                 SystemdOperation::Start => "Start",
                 SystemdOperation::Stop => "Stop",
                 SystemdOperation::Restart => "Restart",
+                SystemdOperation::Reload => "Reload",
                 SystemdOperation::EnableNow => "Enable and start",
                 SystemdOperation::DisableNow => "Disable and stop",
             },
@@ -170,6 +185,10 @@ mod tests {
                 SystemdOperation::Restart,
                 "Restart systemd service: test.service",
             ),
+            (
+                SystemdOperation::Reload,
+                "Reload systemd service: test.service",
+            ),
             (
                 SystemdOperation::EnableNow,
                 "Enable and start systemd service: test.service",
@@ -3,6 +3,32 @@ use std::fs;
 use std::path::PathBuf;
 use std::process::Command;
 
+/// How a running unit picks up a change
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Refresh {
+    /// Restart it when its unit files changed, or when a handler is notified
+    RestartOnChange,
+    /// Restart it every time the action runs
+    Restart,
+    /// Reload it instead of restarting, when it would otherwise restart on change
+    Reload,
+}
+
+impl Refresh {
+    /// The refresh named by an action's `action`, e.g. `"reload"`
+    pub fn parse(name: &str) -> Result<Self, String> {
+        match name {
+            "restart-on-change" => Ok(Refresh::RestartOnChange),
+            "restart" => Ok(Refresh::Restart),
+            "reload" => Ok(Refresh::Reload),
+            other => Err(format!(
+                "'action' must be 'reload', 'restart' or 'restart-on-change', got '{}'",
+                other
+            )),
+        }
+    }
+}
+
 #[derive(Debug, Clone)]
 pub struct SystemdService {
     pub name: String,
@@ -12,6 +38,15 @@ pub struct SystemdService {
     pub scope: String,
     pub restart: Option<String>,
     pub restart_sec: Option<u32>,
+    /// Full unit file content used instead of the generated one
+    pub unit: Option<String>,
+    /// Content of a companion `.timer` unit
+    pub timer: Option<String>,
+    pub enable: bool,
+    pub start: bool,
+    pub refresh: Refresh,
+    /// Run as a handler, so a notifying action changed something
+    pub notified: bool,
 }
 
 impl SystemdService {
@@ -32,12 +67,159 @@ impl SystemdService {
             scope,
             restart,
             restart_sec,
+            unit: None,
+            timer: None,
+            enable: false,
+            start: false,
+            refresh: Refresh::RestartOnChange,
+            notified: false,
+        }
+    }
+
+    /// Use verbatim unit file content instead of generating it, optionally with a timer
+    pub fn with_unit_file(mut self, unit: Option<String>, timer: Option<String>) -> Self {
+        self.unit = unit;
+        self.timer = timer;
+        self
+    }
+
+    /// Enable and/or start the unit (the timer, if there is one) after writing it
+    pub fn with_activation(mut self, enable: bool, start: bool) -> Self {
+        self.enable = enable;
+        self.start = start;
+        self
+    }
+
+    /// Refresh the running unit this way; `notified` for a handler's unit
+    pub fn with_refresh(mut self, refresh: Refresh, notified: bool) -> Self {
+        self.refresh = refresh;
+        self.notified = notified;
+        self
+    }
+
+    /// The unit a refresh acts on: timers can't reload, so a reload goes to
+    /// the service itself
+    fn refresh_unit(&self) -> String {
+        if self.refresh == Refresh::Reload {
+            self.name.clone()
+        } else {
+            self.activation_unit()
+        }
+    }
+
+    /// Whether the running unit is refreshed, given whether its unit files
+    /// `changed`
+    fn refreshes(&self, changed: bool) -> bool {
+        (self.start || self.notified)
+            && (changed || self.notified || self.refresh == Refresh::Restart)
+    }
+
+    fn unit_dir(&self) -> PathBuf {
+        self.get_service_path()
+            .parent()
+            .map(PathBuf::from)
+            .unwrap_or_default()
+    }
+
+    fn timer_name(&self) -> String {
+        let stem = self.name.strip_suffix(".service").unwrap_or(&self.name);
+        format!("{}.timer", stem)
+    }
+
+    /// The unit that enable/start act on: the timer when there is one
+    fn activation_unit(&self) -> String {
+        if self.timer.is_some() {
+            self.timer_name()
+        } else {
+            self.name.clone()
+        }
+    }
+
+    /// Unit files to write with their desired content
+    fn unit_files(&self) -> Vec<(PathBuf, String)> {
+        let service = self
+            .unit
+            .clone()
+            .unwrap_or_else(|| self.generate_service_content());
+        let mut files = vec![(self.get_service_path(), service)];
+        if let Some(timer) = &self.timer {
+            files.push((self.unit_dir().join(self.timer_name()), timer.clone()));
         }
+        files
+    }
+
+    fn systemctl(&self, args: &[&str]) -> Command {
+        let mut cmd = if self.scope == "user" {
+            let mut cmd = crate::target_user::command("systemctl");
+            cmd.arg("--user");
+            cmd
+        } else {
+            Command::new("systemctl")
+        };
+        cmd.args(args);
+        cmd
+    }
+
+    fn run_systemctl(&self, args: &[&str]) -> Result<(), String> {
+        let output = self
+            .systemctl(args)
+            .output()
+            .map_err(|e| format!("Failed to run systemctl {}: {}", args.join(" "), e))?;
+
+        if !output.status.success() {
+            return Err(format!(
+                "systemctl {} failed: {}",
+                args.join(" "),
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+
+        Ok(())
+    }
+
+    /// Whether `systemctl <query> <unit>` succeeds, e.g. `is-enabled` or `is-active`
+    fn query(&self, query: &str, unit: &str) -> bool {
+        self.systemctl(&[query, "--quiet", unit])
+            .output()
+            .map(|output| output.status.success())
+            .unwrap_or(false)
+    }
+
+    /// Fail early when there is no systemd user manager to talk to
+    fn require_user_session(&self) -> Result<(), String> {
+        if self.scope != "user" {
+            return Ok(());
+        }
+
+        let available = self
+            .systemctl(&["show-environment"])
+            .output()
+            .map(|output| output.status.success())
+            .unwrap_or(false);
+
+        if available {
+            Ok(())
+        } else {
+            Err(format!(
+                "Cannot manage {}: no systemd user session is available. \
+                 Log in to a graphical or lingering session \
+                 (`loginctl enable-linger $USER`) and make sure XDG_RUNTIME_DIR is set",
+                self.name
+            ))
+        }
+    }
+
+    fn files_changed(&self) -> bool {
+        self.unit_files()
+            .iter()
+            .any(|(path, content)| fs::read_to_string(path).map_or(true, |c| &c != content))
     }
 
     fn get_service_path(&self) -> PathBuf {
         if self.scope == "user" {
-            let home = std::env::var("HOME").unwrap_or_else(|_| String::from("/home/user"));
+            let home = crate::paths::home_dir()
+                .map(|home| home.display().to_string())
+                .unwrap_or_else(|| String::from("/home/user"));
             PathBuf::from(format!("{}/.config/systemd/user/{}", home, self.name))
         } else {
             PathBuf::from(format!("/etc/systemd/system/{}", self.name))
@@ -69,44 +251,94 @@ impl Atom for SystemdService {
     }
 
     fn execute(&self) -> Result<(), String> {
-        let service_path = self.get_service_path();
+        crate::platform::require_linux("systemdService")?;
+        self.require_user_session()?;
+
+        let mut changed = false;
+        for (path, content) in self.unit_files() {
+            // Only rewrite unit files whose content differs
+            if fs::read_to_string(&path).is_ok_and(|existing| existing == content) {
+                continue;
+            }
 
-        // Create parent directories if needed
-        if let Some(parent) = service_path.parent() {
-            if !parent.exists() {
-                fs::create_dir_all(parent)
-                    .map_err(|e| format!("Failed to create systemd directory: {}", e))?;
+            // Create parent directories if needed
+            if let Some(parent) = path.parent() {
+                if !parent.exists() {
+                    crate::target_user::create_dir_all(parent)
+                        .map_err(|e| format!("Failed to create systemd directory: {}", e))?;
+                }
             }
+
+            fs::write(&path, content)
+                .map_err(|e| format!("Failed to write unit file {}: {}", path.display(), e))?;
+            crate::target_user::give(&path)?;
+            changed = true;
         }
 
-        // Write service file
-        let content = self.generate_service_content();
-        fs::write(&service_path, content)
-            .map_err(|e| format!("Failed to write service file: {}", e))?;
+        if changed {
+            self.run_systemctl(&["daemon-reload"])
+                .map_err(|e| format!("Failed to reload systemd: {}", e))?;
+        }
 
-        // Reload systemd
-        let reload_cmd = if self.scope == "user" {
-            Command::new("systemctl")
-                .args(["--user", "daemon-reload"])
-                .output()
-        } else {
-            Command::new("systemctl").args(["daemon-reload"]).output()
-        };
+        let unit = self.activation_unit();
+        if self.enable && !self.query("is-enabled", &unit) {
+            self.run_systemctl(&["enable", unit.as_str()])?;
+        }
 
-        if let Ok(output) = reload_cmd {
-            if !output.status.success() {
-                return Err(format!(
-                    "Failed to reload systemd: {}",
-                    String::from_utf8_lossy(&output.stderr)
-                ));
+        if self.start && !self.query("is-active", &unit) {
+            self.run_systemctl(&["start", unit.as_str()])?;
+        } else if self.refreshes(changed) {
+            // Pick up the new unit definition, or what notified the handler
+            let target = self.refresh_unit();
+            if self.query("is-active", &target) {
+                if self.refresh == Refresh::Reload {
+                    self.run_systemctl(&["reload", target.as_str()])?;
+                    log::info!("Reloaded {}", target);
+                } else {
+                    self.run_systemctl(&["restart", target.as_str()])?;
+                    log::info!("Restarted {}", target);
+                }
             }
         }
 
         Ok(())
     }
 
+    fn check(&self) -> Option<bool> {
+        let unit = self.activation_unit();
+        let changed = self.files_changed();
+        Some(
+            changed
+                || (self.enable && !self.query("is-enabled", &unit))
+                || (self.start && !self.query("is-active", &unit))
+                || (self.refreshes(changed) && self.query("is-active", &self.refresh_unit())),
+        )
+    }
+
     fn describe(&self) -> String {
-        format!("Create systemd service: {}", self.name)
+        let mut description = format!("Create systemd service: {}", self.name);
+        if self.timer.is_some() {
+            description.push_str(&format!(" with {}", self.timer_name()));
+        }
+        let mut steps = Vec::new();
+        if self.enable {
+            steps.push("enable");
+        }
+        if self.start {
+            steps.push("start");
+        }
+        if self.start || self.notified {
+            match (self.refresh, self.notified) {
+                (Refresh::Reload, true) => steps.push("reload"),
+                (Refresh::Reload, false) => steps.push("reload on change"),
+                (Refresh::Restart, _) | (Refresh::RestartOnChange, true) => steps.push("restart"),
+                (Refresh::RestartOnChange, false) => {}
+            }
+        }
+        if !steps.is_empty() {
+            description.push_str(&format!(" ({})", steps.join(", ")));
+        }
+        description
     }
 }
 
@@ -271,4 +503,88 @@ mod tests {
         assert!(content.contains("Restart=on-failure"));
         assert!(!content.contains("RestartSec="));
     }
+
+    #[test]
+    fn test_unit_file_replaces_generated_content() {
+        let service = SystemdService::new(
+            "syncthing.service".to_string(),
+            String::new(),
+            String::new(),
+            "simple".to_string(),
+            "system".to_string(),
+            None,
+            None,
+        )
+        .with_unit_file(
+            Some("[Service]\nExecStart=/usr/bin/syncthing\n".to_string()),
+            Some("[Timer]\nOnCalendar=daily\n".to_string()),
+        );
+
+        let files = service.unit_files();
+        assert_eq!(files.len(), 2);
+        assert_eq!(files[0].1, "[Service]\nExecStart=/usr/bin/syncthing\n");
+        assert_eq!(
+            files[1].0,
+            std::path::PathBuf::from("/etc/systemd/system/syncthing.timer")
+        );
+        assert_eq!(service.activation_unit(), "syncthing.timer");
+    }
+
+    #[test]
+    fn test_systemd_service_describe_activation() {
+        let service = SystemdService::new(
+            "backup.service".to_string(),
+            "Backup".to_string(),
+            "/bin/backup".to_string(),
+            "oneshot".to_string(),
+            "user".to_string(),
+            None,
+            None,
+        )
+        .with_unit_file(None, Some("[Timer]\nOnCalendar=daily\n".to_string()))
+        .with_activation(true, true);
+
+        assert_eq!(
+            service.describe(),
+            "Create systemd service: backup.service with backup.timer (enable, start)"
+        );
+    }
+
+    #[test]
+    fn test_refresh_depends_on_the_action_and_notifications() {
+        let service = |refresh, notified| {
+            SystemdService::new(
+                "nginx.service".to_string(),
+                "nginx".to_string(),
+                "/usr/sbin/nginx".to_string(),
+                "forking".to_string(),
+                "system".to_string(),
+                None,
+                None,
+            )
+            .with_activation(false, true)
+            .with_refresh(refresh, notified)
+        };
+
+        assert!(!service(Refresh::RestartOnChange, false).refreshes(false));
+        assert!(service(Refresh::RestartOnChange, false).refreshes(true));
+        assert!(service(Refresh::RestartOnChange, true).refreshes(false));
+        assert!(service(Refresh::Restart, false).refreshes(false));
+        assert!(!service(Refresh::Reload, false).refreshes(false));
+        assert!(service(Refresh::Reload, true).refreshes(false));
+
+        assert_eq!(
+            service(Refresh::Reload, false).describe(),
+            "Create systemd service: nginx.service (start, reload on change)"
+        );
+        assert_eq!(
+            service(Refresh::Reload, true).describe(),
+            "Create systemd service: nginx.service (start, reload)"
+        );
+        assert_eq!(
+            service(Refresh::RestartOnChange, true).describe(),
+            "Create systemd service: nginx.service (start, restart)"
+        );
+        assert!(Refresh::parse("bounce").unwrap_err().contains("'bounce'"));
+    }
 }
@@ -23,7 +23,9 @@ impl SystemdSocket {
 
     fn get_socket_path(&self) -> PathBuf {
         if self.scope == "user" {
-            let home = std::env::var("HOME").unwrap_or_else(|_| String::from("/home/user"));
+            let home = crate::paths::home_dir()
+                .map(|home| home.display().to_string())
+                .unwrap_or_else(|| String::from("/home/user"));
             PathBuf::from(format!("{}/.config/systemd/user/{}", home, self.name))
         } else {
             PathBuf::from(format!("/etc/systemd/system/{}", self.name))
@@ -44,12 +46,14 @@ impl Atom for SystemdSocket {
     }
 
     fn execute(&self) -> Result<(), String> {
+        crate::platform::require_linux("systemdSocket")?;
+
         let socket_path = self.get_socket_path();
 
         // Create parent directories if needed
         if let Some(parent) = socket_path.parent() {
             if !parent.exists() {
-                fs::create_dir_all(parent)
+                crate::target_user::create_dir_all(parent)
                     .map_err(|e| format!("Failed to create systemd directory: {}", e))?;
             }
         }
@@ -58,10 +62,11 @@ impl Atom for SystemdSocket {
         let content = self.generate_socket_content();
         fs::write(&socket_path, content)
             .map_err(|e| format!("Failed to write socket file: {}", e))?;
+        crate::target_user::give(&socket_path)?;
 
         // Reload systemd and enable socket
         let reload_cmd = if self.scope == "user" {
-            Command::new("systemctl")
+            crate::target_user::command("systemctl")
                 .args(["--user", "daemon-reload"])
                 .output()
         } else {
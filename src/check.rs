@@ -0,0 +1,252 @@
+//! Validate modules without applying them
+//!
+//! Every module is loaded and checked, and all problems are collected so a
+//! single run reports everything that needs fixing.
+
+use crate::actions::{Action, ActionType};
+use crate::discovery::DiscoveredModule;
+use crate::loader::{LoadedModule, load_module_with_warnings};
+use std::collections::HashSet;
+use std::path::{Path, PathBuf};
+
+/// The problems found in one module file
+#[derive(Debug, Clone, PartialEq)]
+pub struct ModuleCheck {
+    pub source: DiscoveredModule,
+    /// The module's name, if it could be loaded
+    pub name: Option<String>,
+    pub errors: Vec<String>,
+    /// Deprecated fields and actions the module uses, which don't make it
+    /// invalid
+    pub deprecations: Vec<String>,
+}
+
+impl ModuleCheck {
+    pub fn is_valid(&self) -> bool {
+        self.errors.is_empty()
+    }
+}
+
+/// Load and validate every module
+///
+/// Besides load errors, this reports actions the loader had to drop (unknown
+/// types, missing required properties), source files that don't exist and
+/// dependencies that don't name a discovered module. Deprecated fields and
+/// actions are listed apart.
+pub fn check_modules(discovered: &[DiscoveredModule]) -> Vec<ModuleCheck> {
+    let loaded: Vec<(Option<LoadedModule>, Vec<String>, Vec<String>)> = discovered
+        .iter()
+        .map(|module| {
+            let (result, mut errors) = load_module_with_warnings(module);
+            let deprecations = crate::deprecation::found();
+            match result {
+                Ok(loaded) => (Some(loaded), errors, deprecations),
+                Err(e) => {
+                    errors.push(e.to_string());
+                    (None, errors, deprecations)
+                }
+            }
+        })
+        .collect();
+
+    let names: HashSet<&str> = loaded
+        .iter()
+        .filter_map(|(module, _, _)| module.as_ref())
+        .map(|module| module.definition.name.as_str())
+        .collect();
+
+    discovered
+        .iter()
+        .zip(&loaded)
+        .map(|(source, (module, errors, deprecations))| {
+            let mut errors = errors.clone();
+            if let Some(module) = module {
+                errors.extend(check_module(module, &names));
+            }
+            ModuleCheck {
+                source: source.clone(),
+                name: module.as_ref().map(|module| module.definition.name.clone()),
+                errors,
+                deprecations: deprecations.clone(),
+            }
+        })
+        .collect()
+}
+
+fn check_module(module: &LoadedModule, names: &HashSet<&str>) -> Vec<String> {
+    let mut errors = Vec::new();
+
+    for dependency in &module.definition.dependencies {
+        if !names.contains(dependency.as_str()) {
+            errors.push(format!("Unknown dependency '{}'", dependency));
+        }
+    }
+
+    let module_dir = module.source.path.parent().unwrap_or(Path::new("."));
+    let handler_actions = module
+        .definition
+        .handlers
+        .iter()
+        .flat_map(|handler| &handler.actions);
+    for action in module.definition.actions.iter().chain(handler_actions) {
+        for file in module_files(action) {
+            let path = resolve(module_dir, file);
+            // A glob matching nothing is warned about when it's planned
+            if !path.exists() && !crate::paths::is_glob(&path) {
+                errors.push(format!(
+                    "{}: source file not found: {}",
+                    action.name(),
+                    path.display()
+                ));
+            }
+        }
+    }
+
+    errors
+}
+
+/// The files an action reads from the module directory
+fn module_files(action: &ActionType) -> Vec<&str> {
+    let absent = |ensure: &Option<String>| ensure.as_deref() == Some("absent");
+    match action {
+        // Taking a file back doesn't read its source
+        ActionType::CopyFile(action) if absent(&action.ensure) => Vec::new(),
+        ActionType::Symlink(action) if absent(&action.ensure) => Vec::new(),
+        ActionType::Template(action) if absent(&action.ensure) => Vec::new(),
+        ActionType::CopyFile(action) => action.source.iter().map(String::as_str).collect(),
+        ActionType::DconfImport(action) => vec![action.source.as_str()],
+        ActionType::DecryptFile(action) => vec![action.source.as_str()],
+        ActionType::Symlink(action) => vec![action.source.as_str()],
+        ActionType::Template(action) => action.source.iter().map(String::as_str).collect(),
+        ActionType::TemplateDir(action) => vec![action.source.as_str()],
+        // Links are created at `source` and point at `target` in the module
+        ActionType::LinkFile(action) => vec![action.target.as_str()],
+        ActionType::LinkDirectory(action) => vec![action.target.as_str()],
+        ActionType::Conditional(action) => module_files(&action.action),
+        ActionType::Notify(action) => module_files(&action.action),
+        ActionType::Tagged(action) => module_files(&action.action),
+        ActionType::Verified(action) => module_files(&action.action),
+        ActionType::Ordered(action) => module_files(&action.action),
+        ActionType::Reboot(action) => module_files(&action.action),
+        _ => Vec::new(),
+    }
+}
+
+fn resolve(module_dir: &Path, file: &str) -> PathBuf {
+    let path = crate::paths::expand_path(file);
+    if path.is_absolute() {
+        path
+    } else {
+        module_dir.join(path)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+    use std::fs;
+    use tempfile::TempDir;
+
+    fn discovered(dir: &Path, name: &str, content: &str) -> DiscoveredModule {
+        let path = dir.join(format!("{}.ts", name));
+        fs::write(&path, content).unwrap();
+        DiscoveredModule {
+            name: name.to_string(),
+            path,
+            namespace: None,
+            variables: HashMap::new(),
+            package_groups: HashMap::new(),
+        }
+    }
+
+    #[test]
+    fn test_valid_module_has_no_errors() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(temp_dir.path().join("zshrc"), "").unwrap();
+        let module = discovered(
+            temp_dir.path(),
+            "zsh",
+            r#"export default defineModule("zsh")
+                .actions([copyFile({ source: "zshrc", target: "~/.zshrc" })]);"#,
+        );
+
+        let checks = check_modules(&[module]);
+        assert!(checks[0].is_valid(), "{:?}", checks[0].errors);
+        assert_eq!(checks[0].name.as_deref(), Some("zsh"));
+        assert!(checks[0].deprecations.is_empty());
+    }
+
+    #[test]
+    fn test_deprecations_dont_make_a_module_invalid() {
+        let temp_dir = TempDir::new().unwrap();
+        let module = discovered(
+            temp_dir.path(),
+            "legacy",
+            r#"export default defineModule("legacy")
+                .actions([
+                    directory({ path: "/tmp/legacy" }),
+                    ensureDir({ path: "/tmp/current" })
+                ]);"#,
+        );
+
+        let checks = check_modules(&[module]);
+        assert!(checks[0].is_valid(), "{:?}", checks[0].errors);
+        assert_eq!(
+            checks[0].deprecations,
+            vec!["directory is deprecated, use ensureDir instead".to_string()]
+        );
+    }
+
+    #[test]
+    fn test_all_problems_are_reported() {
+        let temp_dir = TempDir::new().unwrap();
+        let module = discovered(
+            temp_dir.path(),
+            "broken",
+            r#"export default defineModule("broken")
+                .dependsOn(["missing"])
+                .actions([
+                    copyFile({ source: "nope.conf", target: "/etc/nope.conf" }),
+                    frobnicate({ level: 11 }),
+                    directory({})
+                ]);"#,
+        );
+
+        let checks = check_modules(&[module]);
+        let errors = &checks[0].errors;
+        assert_eq!(errors.len(), 4, "{:?}", errors);
+        assert!(
+            errors
+                .iter()
+                .any(|e| e.contains("Unknown action type: 'frobnicate'"))
+        );
+        assert!(errors.iter().any(|e| e.contains("requires 'path'")));
+        assert!(errors.iter().any(|e| e == "Unknown dependency 'missing'"));
+        assert!(errors.iter().any(|e| e.contains("nope.conf")));
+    }
+
+    #[test]
+    fn test_dependencies_resolve_across_modules() {
+        let temp_dir = TempDir::new().unwrap();
+        let base = discovered(
+            temp_dir.path(),
+            "base",
+            r#"export default defineModule("base").actions([]);"#,
+        );
+        let app = discovered(
+            temp_dir.path(),
+            "app",
+            r#"export default defineModule("app").dependsOn(["base"]).actions([]);"#,
+        );
+        let empty = discovered(temp_dir.path(), "empty", "");
+
+        let checks = check_modules(&[base, app, empty]);
+        assert!(checks[0].is_valid());
+        assert!(checks[1].is_valid());
+        assert_eq!(
+            checks[2].errors,
+            vec!["Parse error: Empty file".to_string()]
+        );
+    }
+}
@@ -0,0 +1,134 @@
+//! Colored status lines
+//!
+//! Applied actions are printed in green, skipped ones in yellow and failed
+//! ones in red. Colors are only used when stdout is a terminal and `NO_COLOR`
+//! isn't set, unless `--color always` asks for them. `DHD_COLORS` changes
+//! them with SGR codes per status, e.g. `applied=1;32:noop=2:failed=35`.
+
+use crate::module_executor::ActionStatus;
+use std::io::IsTerminal;
+use std::sync::OnceLock;
+use std::sync::atomic::{AtomicU8, Ordering};
+
+/// When to color output, as chosen with `--color`
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ColorChoice {
+    Auto,
+    Always,
+    Never,
+}
+
+static CHOICE: AtomicU8 = AtomicU8::new(ColorChoice::Auto as u8);
+
+/// Use `choice` for everything printed from now on
+pub fn set_choice(choice: ColorChoice) {
+    CHOICE.store(choice as u8, Ordering::Relaxed);
+}
+
+/// Whether status lines are colored
+pub fn enabled() -> bool {
+    static AUTO: OnceLock<bool> = OnceLock::new();
+
+    match CHOICE.load(Ordering::Relaxed) {
+        c if c == ColorChoice::Always as u8 => true,
+        c if c == ColorChoice::Never as u8 => false,
+        _ => *AUTO.get_or_init(|| {
+            let no_color = std::env::var_os("NO_COLOR").is_some_and(|value| !value.is_empty());
+            !no_color && std::io::stdout().is_terminal()
+        }),
+    }
+}
+
+/// The SGR codes each status is printed with
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Theme {
+    pub applied: String,
+    pub noop: String,
+    pub skipped: String,
+    pub failed: String,
+}
+
+impl Default for Theme {
+    fn default() -> Self {
+        Self {
+            applied: "32".to_string(),
+            noop: String::new(),
+            skipped: "33".to_string(),
+            failed: "31".to_string(),
+        }
+    }
+}
+
+impl Theme {
+    /// The default theme with the entries of a `DHD_COLORS` value applied;
+    /// unknown statuses and codes that aren't SGR parameters are ignored
+    pub fn parse(spec: &str) -> Self {
+        let mut theme = Self::default();
+        for (status, code) in spec.split(':').filter_map(|entry| entry.split_once('=')) {
+            if !code.chars().all(|c| c.is_ascii_digit() || c == ';') {
+                continue;
+            }
+            let slot = match status.trim() {
+                "applied" => &mut theme.applied,
+                "noop" => &mut theme.noop,
+                "skipped" => &mut theme.skipped,
+                "failed" => &mut theme.failed,
+                _ => continue,
+            };
+            *slot = code.to_string();
+        }
+        theme
+    }
+
+    fn code(&self, status: ActionStatus) -> &str {
+        match status {
+            ActionStatus::Applied => &self.applied,
+            ActionStatus::Noop => &self.noop,
+            ActionStatus::Skipped => &self.skipped,
+            ActionStatus::Failed => &self.failed,
+        }
+    }
+
+    /// `text` in the color of `status`; an empty code leaves it plain
+    pub fn paint(&self, status: ActionStatus, text: &str) -> String {
+        match self.code(status) {
+            "" => text.to_string(),
+            code => format!("\x1b[{}m{}\x1b[0m", code, text),
+        }
+    }
+}
+
+fn theme() -> &'static Theme {
+    static THEME: OnceLock<Theme> = OnceLock::new();
+    THEME.get_or_init(|| Theme::parse(&std::env::var("DHD_COLORS").unwrap_or_default()))
+}
+
+/// `text` in the color of `status`, if status lines are colored
+pub fn paint(status: ActionStatus, text: &str) -> String {
+    if enabled() {
+        theme().paint(status, text)
+    } else {
+        text.to_string()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_theme_overrides_and_paints() {
+        let theme = Theme::parse("applied=1;32:noop=2:failed=:skipped=bold:unknown=34");
+        assert_eq!(theme.applied, "1;32");
+        assert_eq!(theme.noop, "2");
+        assert_eq!(theme.failed, "");
+        assert_eq!(theme.skipped, "33");
+
+        assert_eq!(
+            theme.paint(ActionStatus::Applied, "✅ zsh"),
+            "\x1b[1;32m✅ zsh\x1b[0m"
+        );
+        assert_eq!(theme.paint(ActionStatus::Failed, "❌ boom"), "❌ boom");
+        assert_eq!(Theme::parse(""), Theme::default());
+    }
+}
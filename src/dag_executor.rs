@@ -1,6 +1,7 @@
 use crate::{
     atom::Atom,
     error::{DhdError, Result},
+    module_executor::ModuleResult,
 };
 use indicatif::{MultiProgress, ProgressBar, ProgressStyle};
 use petgraph::algo::toposort;
@@ -139,6 +140,8 @@ impl DagExecutor {
             completed: completed.lock().unwrap().len(),
             skipped: skipped.lock().unwrap().len(),
             failed: failed.lock().unwrap().clone(),
+            modules: Vec::new(),
+            stopped_by: None,
         })
     }
 
@@ -223,6 +226,21 @@ pub struct ExecutionSummary {
     pub completed: usize,
     pub skipped: usize,
     pub failed: Vec<(String, String)>,
+    /// Per-module results, filled in when modules are executed as a whole
+    pub modules: Vec<ModuleResult>,
+    /// The module whose failure stopped the remaining ones, if any
+    pub stopped_by: Option<String>,
+}
+
+impl ExecutionSummary {
+    /// The modules whose changes require a reboot
+    pub fn reboot_required(&self) -> Vec<&str> {
+        self.modules
+            .iter()
+            .filter(|module| module.reboot_required)
+            .map(|module| module.module.as_str())
+            .collect()
+    }
 }
 
 #[derive(Debug)]
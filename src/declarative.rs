@@ -0,0 +1,588 @@
+//! Modules written in YAML or TOML instead of TypeScript
+//!
+//! A `<name>.dhd.yaml`, `<name>.dhd.yml` or `<name>.dhd.toml` file declares a
+//! module with the keys of a TypeScript module's object form, and actions in
+//! the JSON form, with `type` being either name of the action:
+//!
+//! ```yaml
+//! name: zsh
+//! tags: [shell]
+//! actions:
+//!   - type: packageInstall
+//!     names: [zsh]
+//!   - type: symlink
+//!     source: zshrc
+//!     target: ~/.zshrc
+//! ```
+//!
+//! They're for modules that are plain data. Conditions, handlers and
+//! platform-specific values need a TypeScript module.
+
+use crate::actions::{ActionType, OrderedAction, RebootAction, TaggedAction, VerifiedAction};
+use crate::loader::{
+    LoadError, action_from_json, json_to_variable, json_to_variable_schema, json_to_variables, warn,
+};
+use crate::module::{Hook, ModuleDefinition};
+use serde_json::{Map, Value};
+use std::collections::HashMap;
+use std::path::Path;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Format {
+    Yaml,
+    Toml,
+}
+
+/// File name endings of declarative modules
+const SUFFIXES: &[(&str, Format)] = &[
+    (".dhd.yaml", Format::Yaml),
+    (".dhd.yml", Format::Yaml),
+    (".dhd.toml", Format::Toml),
+];
+
+/// Keys of TypeScript modules that have no declarative form
+const TYPESCRIPT_ONLY: &[&str] = &["when", "handlers"];
+
+/// The module name and format of a declarative module file, e.g. `zsh` for
+/// `zsh.dhd.yaml`
+///
+/// Other YAML and TOML files, like a `starship.toml` copied into place by a
+/// module, aren't modules.
+pub fn module_file(path: &Path) -> Option<(String, Format)> {
+    let file_name = path.file_name()?.to_str()?;
+    SUFFIXES.iter().find_map(|(suffix, format)| {
+        let name = file_name.strip_suffix(suffix)?;
+        (!name.is_empty()).then(|| (name.to_string(), *format))
+    })
+}
+
+/// The values of a declarative module file
+pub(crate) fn parse_value(content: &str, format: Format) -> Result<Value, LoadError> {
+    match format {
+        Format::Yaml => serde_yaml::from_str(content)
+            .map_err(|e| LoadError::ParseError(format!("Failed to parse YAML: {}", e))),
+        Format::Toml => {
+            let value: toml::Value = toml::from_str(content)
+                .map_err(|e| LoadError::ParseError(format!("Failed to parse TOML: {}", e)))?;
+            serde_json::to_value(value)
+                .map_err(|e| LoadError::ParseError(format!("Failed to read TOML: {}", e)))
+        }
+    }
+}
+
+/// Parse a declarative module; `name` is used unless the file sets one
+pub fn parse_module(
+    content: &str,
+    format: Format,
+    name: &str,
+) -> Result<ModuleDefinition, LoadError> {
+    let Value::Object(module) = parse_value(content, format)? else {
+        return Err(LoadError::ValidationError(
+            "Expected the module's properties, like name and actions".to_string(),
+        ));
+    };
+
+    let name = match module.get("name") {
+        Some(Value::String(name)) => name.clone(),
+        Some(_) => {
+            return Err(LoadError::ValidationError(
+                "'name' must be a string".to_string(),
+            ));
+        }
+        None => name.to_string(),
+    };
+    // Before the actions, which a DHD too old for the module may not know
+    match module.get("requiresDhd") {
+        Some(Value::String(required)) => crate::version::require(required)
+            .map_err(|e| LoadError::ValidationError(format!("this module {}", e)))?,
+        Some(_) => {
+            return Err(LoadError::ValidationError(
+                "'requiresDhd' must be a version like 0.2.0".to_string(),
+            ));
+        }
+        None => {}
+    }
+    for key in TYPESCRIPT_ONLY
+        .iter()
+        .filter(|key| module.contains_key(**key))
+    {
+        warn(format!(
+            "module '{}' ignores '{}', which needs a TypeScript module",
+            name, key
+        ));
+    }
+
+    let strings = |key: &str| -> Result<Vec<String>, LoadError> {
+        match module.get(key) {
+            None => Ok(Vec::new()),
+            Some(Value::Array(values)) => values
+                .iter()
+                .map(|value| value.as_str().map(String::from))
+                .collect::<Option<Vec<String>>>()
+                .ok_or_else(|| {
+                    LoadError::ValidationError(format!("'{}' must be a list of strings", key))
+                }),
+            Some(_) => Err(LoadError::ValidationError(format!(
+                "'{}' must be a list of strings",
+                key
+            ))),
+        }
+    };
+    let mut dependencies = strings("dependsOn")?;
+    if module.contains_key("dependencies") {
+        crate::deprecation::check("module", &["dependencies"]);
+    }
+    dependencies.extend(strings("dependencies")?);
+
+    let variables = match module.get("variables") {
+        Some(value) => json_to_variables(value).ok_or_else(|| {
+            LoadError::ValidationError("'variables' must be a mapping".to_string())
+        })?,
+        None => HashMap::new(),
+    };
+    let variable_schema = match module.get("variableSchema") {
+        Some(value) => json_to_variable_schema(value).ok_or_else(|| {
+            LoadError::ValidationError(
+                "'variableSchema' must map names to { type, required, default, values, sensitive }"
+                    .to_string(),
+            )
+        })?,
+        None => HashMap::new(),
+    };
+
+    let enabled = match module.get("enabled") {
+        None => true,
+        Some(Value::Bool(enabled)) => *enabled,
+        Some(_) => {
+            return Err(LoadError::ValidationError(
+                "'enabled' must be true or false".to_string(),
+            ));
+        }
+    };
+
+    let actions = match module.get("actions") {
+        None => Vec::new(),
+        Some(Value::Array(actions)) => actions
+            .iter()
+            .enumerate()
+            .map(|(idx, action)| parse_actions(&name, idx, action))
+            .collect::<Result<Vec<_>, _>>()?
+            .into_iter()
+            .flatten()
+            .collect(),
+        Some(_) => {
+            return Err(LoadError::ValidationError(
+                "'actions' must be a list".to_string(),
+            ));
+        }
+    };
+
+    Ok(ModuleDefinition {
+        description: module
+            .get("description")
+            .and_then(|v| v.as_str())
+            .map(String::from),
+        tags: strings("tags")?,
+        dependencies,
+        actions,
+        when: None,
+        enabled,
+        pre_apply: parse_hook(&module, "preApply")?,
+        post_apply: parse_hook(&module, "postApply")?,
+        handlers: Vec::new(),
+        variables,
+        variable_schema,
+        name,
+    })
+}
+
+/// The actions of an action that sets `forEach` to a list, one per item, or
+/// the action itself
+///
+/// `{{ item }}` in the action's strings is the item, and `{{ item.key }}` a
+/// key of an item that's a mapping. Other tags are left for templates and
+/// module variables.
+pub(crate) fn parse_actions(
+    module: &str,
+    idx: usize,
+    action: &Value,
+) -> Result<Vec<ActionType>, LoadError> {
+    let invalid = |reason: String| {
+        LoadError::ValidationError(format!("action {} of module '{}' {}", idx, module, reason))
+    };
+    let mut action = action.clone();
+    let items = match action
+        .as_object_mut()
+        .and_then(|props| props.remove("forEach"))
+    {
+        None => return Ok(vec![parse_action(module, idx, &action)?]),
+        Some(Value::Array(items)) => items,
+        Some(_) => return Err(invalid("has a 'forEach' that isn't a list".to_string())),
+    };
+    items
+        .iter()
+        .enumerate()
+        .map(|(n, item)| {
+            let expanded = fill_item(&action, item)
+                .map_err(|reason| invalid(format!("has 'forEach' item {}, which {}", n, reason)))?;
+            parse_action(module, idx, &expanded)
+        })
+        .collect()
+}
+
+/// `value` with the `{{ item }}` and `{{ item.key }}` tags of its strings
+/// replaced by `item`
+fn fill_item(value: &Value, item: &Value) -> Result<Value, String> {
+    Ok(match value {
+        Value::String(text) => Value::String(fill_item_tags(text, item)?),
+        Value::Array(values) => Value::Array(
+            values
+                .iter()
+                .map(|value| fill_item(value, item))
+                .collect::<Result<_, _>>()?,
+        ),
+        Value::Object(props) => Value::Object(
+            props
+                .iter()
+                .map(|(key, value)| Ok((key.clone(), fill_item(value, item)?)))
+                .collect::<Result<_, String>>()?,
+        ),
+        _ => value.clone(),
+    })
+}
+
+fn fill_item_tags(text: &str, item: &Value) -> Result<String, String> {
+    let mut filled = String::with_capacity(text.len());
+    let mut rest = text;
+    while let Some(start) = rest.find("{{") {
+        let Some(end) = rest[start..].find("}}").map(|end| start + end + 2) else {
+            break;
+        };
+        let tag = rest[start + 2..end - 2].trim();
+        let value = if tag == "item" {
+            Some(json_to_variable(item).ok_or("is a list or mapping, not a value")?)
+        } else if let Some(key) = tag.strip_prefix("item.") {
+            let value = item.get(key).ok_or_else(|| format!("has no '{}'", key))?;
+            Some(
+                json_to_variable(value)
+                    .ok_or_else(|| format!("has a '{}' that isn't a value", key))?,
+            )
+        } else {
+            None
+        };
+        filled.push_str(&rest[..start]);
+        filled.push_str(value.as_deref().unwrap_or(&rest[start..end]));
+        rest = &rest[end..];
+    }
+    filled.push_str(rest);
+    Ok(filled)
+}
+
+/// An action given as `{ type, ...properties }`, with an optional `become`,
+/// `verify`, `tags`, `requiresReboot`, `id` and `after`
+fn parse_action(module: &str, idx: usize, action: &Value) -> Result<ActionType, LoadError> {
+    let invalid = |reason: &str| {
+        LoadError::ValidationError(format!("action {} of module '{}' {}", idx, module, reason))
+    };
+    let Some(props) = action.as_object() else {
+        return Err(invalid("must be a mapping with a 'type'"));
+    };
+    let Some(action_type) = props.get("type").and_then(|v| v.as_str()) else {
+        return Err(invalid("has no 'type'"));
+    };
+
+    crate::deprecation::check(action_type, &[]);
+
+    let mut props = props.clone();
+    props.remove("type");
+    let become_root = props.remove("become").and_then(|v| v.as_bool()) == Some(true);
+    let verify = match props.remove("verify") {
+        Some(Value::String(verify)) => Some(verify),
+        _ => None,
+    };
+    let tags: Vec<String> = match props.remove("tags") {
+        Some(Value::String(tag)) => vec![tag],
+        Some(Value::Array(tags)) => tags
+            .iter()
+            .filter_map(|tag| tag.as_str().map(String::from))
+            .collect(),
+        _ => Vec::new(),
+    };
+    let requires_reboot = props.remove("requiresReboot").and_then(|v| v.as_bool()) == Some(true);
+    let id = match props.remove("id") {
+        Some(Value::String(id)) => Some(id),
+        _ => None,
+    };
+    let after: Vec<String> = match props.remove("after") {
+        Some(Value::String(id)) => vec![id],
+        Some(Value::Array(ids)) => ids
+            .iter()
+            .filter_map(|id| id.as_str().map(String::from))
+            .collect(),
+        _ => Vec::new(),
+    };
+    let mut action = action_from_json(&json_type(action_type), &props).ok_or_else(|| {
+        invalid(&format!(
+            "has an unknown type '{}' or is missing required properties",
+            action_type
+        ))
+    })?;
+    if become_root && !action.escalate() {
+        warn(format!(
+            "'become' has no effect on {}, it always runs as your user",
+            action.type_name()
+        ));
+    }
+    if let Some(verify) = verify {
+        action = ActionType::Verified(VerifiedAction::new(action, verify));
+    }
+    if !tags.is_empty() {
+        action = ActionType::Tagged(TaggedAction::new(action, tags));
+    }
+    if requires_reboot {
+        action = ActionType::Reboot(RebootAction::new(action));
+    }
+    if id.is_some() || !after.is_empty() {
+        action = ActionType::Ordered(OrderedAction::new(action, id, after));
+    }
+    Ok(action)
+}
+
+/// The JSON form's name of an action type, which declarative modules may also
+/// give as the function name, e.g. `packageInstall` for `PackageInstall`
+fn json_type(action_type: &str) -> String {
+    match action_type {
+        "command" => "ShellCommand".to_string(),
+        "ensureDir" | "directory" => "Directory".to_string(),
+        _ => {
+            let mut chars = action_type.chars();
+            chars
+                .next()
+                .map(|first| first.to_ascii_uppercase().to_string() + chars.as_str())
+                .unwrap_or_default()
+        }
+    }
+}
+
+/// A hook given as a command line or `{ run, shell, env, continueOnError, onlyIfChanged }`
+fn parse_hook(module: &Map<String, Value>, key: &str) -> Result<Option<Hook>, LoadError> {
+    match module.get(key) {
+        None => Ok(None),
+        Some(Value::String(run)) => Ok(Some(Hook::new(run.clone()))),
+        Some(Value::Object(hook)) => {
+            let run = hook.get("run").and_then(|v| v.as_str()).ok_or_else(|| {
+                LoadError::ValidationError(format!("'{}' needs a 'run' command", key))
+            })?;
+            Ok(Some(Hook {
+                run: run.to_string(),
+                shell: hook.get("shell").and_then(|v| v.as_str()).map(String::from),
+                env: hook.get("env").and_then(crate::loader::json_to_variables),
+                continue_on_error: hook.get("continueOnError").and_then(|v| v.as_bool()),
+                only_if_changed: hook.get("onlyIfChanged").and_then(|v| v.as_bool()),
+            }))
+        }
+        Some(_) => Err(LoadError::ValidationError(format!(
+            "'{}' must be a command or {{ run, ... }}",
+            key
+        ))),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_module_file_names() {
+        assert_eq!(
+            module_file(Path::new("shell/zsh.dhd.yaml")),
+            Some(("zsh".to_string(), Format::Yaml))
+        );
+        assert_eq!(
+            module_file(Path::new("git.dhd.toml")),
+            Some(("git".to_string(), Format::Toml))
+        );
+        assert_eq!(module_file(Path::new("starship.toml")), None);
+        assert_eq!(module_file(Path::new(".dhd.yml")), None);
+    }
+
+    #[test]
+    fn test_parse_yaml_module() {
+        let content = r#"
+description: Z shell
+tags: [shell]
+dependsOn: [base]
+preApply: echo starting
+variables:
+  theme: dark
+actions:
+  - type: packageInstall
+    names: [zsh]
+    verify: command -v zsh
+  - type: symlink
+    source: zshrc
+    target: ~/.zshrc
+  - type: CopyFile
+    source: zshenv
+    target: /etc/zsh/zshenv
+    become: true
+    requiresReboot: true
+  - type: command
+    run: chsh -s /bin/zsh
+    tags: [slow]
+    id: shell
+    after: zsh-installed
+"#;
+        let module = parse_module(content, Format::Yaml, "zsh").unwrap();
+        assert_eq!(module.name, "zsh");
+        assert_eq!(module.tags, vec!["shell"]);
+        assert_eq!(module.dependencies, vec!["base"]);
+        assert_eq!(module.pre_apply.unwrap().run, "echo starting");
+        assert_eq!(module.variables["theme"], "dark");
+
+        let types: Vec<&str> = module.actions.iter().map(|a| a.type_name()).collect();
+        assert_eq!(
+            types,
+            vec!["packageInstall", "symlink", "copyFile", "command"]
+        );
+        assert!(matches!(
+            &module.actions[0],
+            ActionType::Verified(verified) if verified.verify == "command -v zsh"
+        ));
+        assert!(module.actions[2].escalates());
+        assert!(matches!(module.actions[2], ActionType::Reboot(_)));
+        assert_eq!(module.actions[3].tags(), ["slow".to_string()]);
+        assert_eq!(module.actions[3].id(), Some("shell"));
+        assert_eq!(module.actions[3].after(), ["zsh-installed".to_string()]);
+    }
+
+    #[test]
+    fn test_parse_toml_module() {
+        let content = r#"
+name = "git"
+tags = ["dev"]
+
+[[actions]]
+type = "packageInstall"
+names = ["git"]
+
+[[actions]]
+type = "copyFile"
+source = "gitconfig"
+target = "~/.gitconfig"
+mode = 0o644
+"#;
+        let module = parse_module(content, Format::Toml, "ignored").unwrap();
+        assert_eq!(module.name, "git");
+        let ActionType::CopyFile(copy) = &module.actions[1] else {
+            panic!("Expected CopyFile action");
+        };
+        assert_eq!(copy.mode, Some(0o644));
+    }
+
+    #[test]
+    fn test_requires_dhd_refuses_newer_versions() {
+        let content = "requiresDhd: \"99.0\"\nactions:\n  - type: futureAction\n";
+        let Err(LoadError::ValidationError(e)) = parse_module(content, Format::Yaml, "zsh") else {
+            panic!("Expected a validation error");
+        };
+        assert!(
+            e.starts_with("this module requires DHD 99.0 or newer"),
+            "{}",
+            e
+        );
+
+        assert!(parse_module("requiresDhd: \"0.1\"\n", Format::Yaml, "zsh").is_ok());
+    }
+
+    #[test]
+    fn test_enabled() {
+        let module = parse_module("enabled: false\nactions: []\n", Format::Yaml, "zsh").unwrap();
+        assert!(!module.enabled);
+        let module = parse_module("actions = []\n", Format::Toml, "zsh").unwrap();
+        assert!(module.enabled);
+        assert!(matches!(
+            parse_module("enabled: \"no\"\n", Format::Yaml, "zsh"),
+            Err(LoadError::ValidationError(_))
+        ));
+    }
+
+    #[test]
+    fn test_for_each_expands_an_action_per_item() {
+        let content = r#"
+actions:
+  - type: symlink
+    forEach: [vimrc, tmux.conf]
+    source: "{{ item }}"
+    target: "~/.{{ item }}"
+  - type: command
+    forEach:
+      - { name: rust, version: 2 }
+    run: "install {{ item.name }} {{ item.version }} for {{ user }}"
+"#;
+        let module = parse_module(content, Format::Yaml, "dots").unwrap();
+        let links: Vec<(&str, &str)> = module.actions[..2]
+            .iter()
+            .map(|action| match action {
+                ActionType::Symlink(link) => (link.source.as_str(), link.target.as_str()),
+                _ => panic!("Expected a Symlink action"),
+            })
+            .collect();
+        assert_eq!(
+            links,
+            vec![("vimrc", "~/.vimrc"), ("tmux.conf", "~/.tmux.conf")]
+        );
+        match &module.actions[2] {
+            ActionType::ShellCommand(command) => {
+                assert_eq!(command.run, "install rust 2 for {{ user }}")
+            }
+            _ => panic!("Expected a ShellCommand action"),
+        }
+        assert_eq!(module.actions.len(), 3);
+
+        let content = "[[actions]]\ntype = \"symlink\"\nforEach = []\nsource = \"{{ item }}\"\ntarget = \"{{ item }}\"\n";
+        assert!(
+            parse_module(content, Format::Toml, "dots")
+                .unwrap()
+                .actions
+                .is_empty()
+        );
+
+        let content = "actions:\n  - type: command\n    forEach: [{ os: linux }]\n    run: \"{{ item.name }}\"\n";
+        let Err(LoadError::ValidationError(error)) = parse_module(content, Format::Yaml, "dots")
+        else {
+            panic!("Expected the item to be rejected");
+        };
+        assert_eq!(
+            error,
+            "action 0 of module 'dots' has 'forEach' item 0, which has no 'name'"
+        );
+        assert!(matches!(
+            parse_module(
+                "actions:\n  - { type: command, forEach: vimrc, run: ls }\n",
+                Format::Yaml,
+                "dots"
+            ),
+            Err(LoadError::ValidationError(_))
+        ));
+    }
+
+    #[test]
+    fn test_invalid_actions_are_errors() {
+        let content = "actions:\n  - type: symlink\n    source: zshrc\n";
+        let Err(LoadError::ValidationError(error)) = parse_module(content, Format::Yaml, "zsh")
+        else {
+            panic!("Expected the action to be rejected");
+        };
+        assert!(error.contains("action 0 of module 'zsh'"), "{}", error);
+
+        assert!(matches!(
+            parse_module("actions: [", Format::Yaml, "zsh"),
+            Err(LoadError::ParseError(_))
+        ));
+        assert!(matches!(
+            parse_module("- just\n- a list\n", Format::Yaml, "zsh"),
+            Err(LoadError::ValidationError(_))
+        ));
+    }
+}
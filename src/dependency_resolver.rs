@@ -1,5 +1,5 @@
 use crate::loader::LoadedModule;
-use std::collections::{HashMap, HashSet, VecDeque};
+use std::collections::{BTreeSet, HashMap, HashSet};
 
 #[derive(Debug, Clone, PartialEq)]
 pub enum DependencyError {
@@ -60,18 +60,19 @@ pub fn resolve_dependencies(
         }
     }
 
-    // Topological sort using Kahn's algorithm
-    let mut queue: VecDeque<String> = VecDeque::new();
+    // Topological sort using Kahn's algorithm. Ready modules are taken in name
+    // order so independent modules always run in the same order.
+    let mut ready: BTreeSet<String> = BTreeSet::new();
     let mut result: Vec<LoadedModule> = Vec::new();
 
     // Find all nodes with no incoming edges
     for (name, &degree) in &in_degree {
         if degree == 0 {
-            queue.push_back(name.clone());
+            ready.insert(name.clone());
         }
     }
 
-    while let Some(current) = queue.pop_front() {
+    while let Some(current) = ready.pop_first() {
         result.push(module_map.get(&current).unwrap().clone());
 
         // For each node that depends on current
@@ -80,7 +81,7 @@ pub fn resolve_dependencies(
                 let degree = in_degree.get_mut(dependent).unwrap();
                 *degree -= 1;
                 if *degree == 0 {
-                    queue.push_back(dependent.clone());
+                    ready.insert(dependent.clone());
                 }
             }
         }
@@ -90,11 +91,12 @@ pub fn resolve_dependencies(
     if result.len() != module_map.len() {
         // Find a cycle for better error reporting
         let processed: HashSet<_> = result.iter().map(|m| &m.definition.name).collect();
-        let unprocessed: Vec<_> = module_map
+        let mut unprocessed: Vec<_> = module_map
             .keys()
             .filter(|name| !processed.contains(name))
             .cloned()
             .collect();
+        unprocessed.sort();
 
         // Find a specific cycle
         if let Some(cycle) = find_cycle(&graph, &unprocessed) {
@@ -108,6 +110,84 @@ pub fn resolve_dependencies(
     Ok(result)
 }
 
+/// Add the modules `selected` depends on from `available`, transitively,
+/// and return them all in execution order
+pub fn resolve_with_dependencies(
+    selected: Vec<LoadedModule>,
+    available: &[LoadedModule],
+) -> Result<Vec<LoadedModule>, DependencyError> {
+    let mut names: HashSet<String> = selected.iter().map(|m| m.definition.name.clone()).collect();
+    let mut modules = selected;
+    let mut next = 0;
+    while next < modules.len() {
+        for dep in modules[next].definition.dependencies.clone() {
+            if names.contains(&dep) {
+                continue;
+            }
+            match available.iter().find(|m| m.definition.name == dep) {
+                Some(module) => {
+                    names.insert(dep);
+                    modules.push(module.clone());
+                }
+                None => {
+                    return Err(DependencyError::MissingDependency {
+                        module: modules[next].definition.name.clone(),
+                        dependency: dep,
+                    });
+                }
+            }
+        }
+        next += 1;
+    }
+
+    resolve_dependencies(modules)
+}
+
+/// The dependencies of the enabled modules in `modules` that `available`
+/// has disabled, as (module, dependency) pairs
+pub fn disabled_dependencies<'a>(
+    modules: &'a [LoadedModule],
+    available: &'a [LoadedModule],
+) -> Vec<(&'a str, &'a str)> {
+    let disabled: HashSet<&str> = available
+        .iter()
+        .filter(|m| !m.definition.enabled)
+        .map(|m| m.definition.name.as_str())
+        .collect();
+    modules
+        .iter()
+        .filter(|m| m.definition.enabled)
+        .flat_map(|m| {
+            m.definition
+                .dependencies
+                .iter()
+                .filter(|dep| disabled.contains(dep.as_str()))
+                .map(|dep| (m.definition.name.as_str(), dep.as_str()))
+        })
+        .collect()
+}
+
+/// Order modules by the dependencies between them, ignoring dependencies outside the set
+///
+/// Used when dependencies of the selected modules should not be pulled in.
+pub fn resolve_dependencies_within(
+    modules: Vec<LoadedModule>,
+) -> Result<Vec<LoadedModule>, DependencyError> {
+    let names: HashSet<String> = modules.iter().map(|m| m.definition.name.clone()).collect();
+    let modules = modules
+        .into_iter()
+        .map(|mut module| {
+            module
+                .definition
+                .dependencies
+                .retain(|dep| names.contains(dep));
+            module
+        })
+        .collect();
+
+    resolve_dependencies(modules)
+}
+
 /// Find a cycle in the graph starting from the given nodes
 fn find_cycle(graph: &HashMap<String, Vec<String>>, start_nodes: &[String]) -> Option<Vec<String>> {
     for start in start_nodes {
@@ -166,6 +246,9 @@ mod tests {
             source: DiscoveredModule {
                 path: PathBuf::from(format!("{}.ts", name)),
                 name: name.to_string(),
+                namespace: None,
+                variables: HashMap::new(),
+                package_groups: HashMap::new(),
             },
             definition: ModuleDefinition {
                 name: name.to_string(),
@@ -173,7 +256,13 @@ mod tests {
                 tags: vec![],
                 dependencies,
                 when: None,
+                enabled: true,
                 actions: vec![],
+                pre_apply: None,
+                post_apply: None,
+                handlers: Vec::new(),
+                variables: HashMap::new(),
+                variable_schema: HashMap::new(),
             },
         }
     }
@@ -258,6 +347,42 @@ mod tests {
         }
     }
 
+    #[test]
+    fn test_independent_modules_are_ordered_by_name() {
+        let modules = vec![
+            create_test_module("zsh", vec![]),
+            create_test_module("git", vec![]),
+            create_test_module("nvim", vec!["git".to_string()]),
+        ];
+
+        let result = resolve_dependencies(modules).unwrap();
+        let names: Vec<_> = result.iter().map(|m| m.definition.name.as_str()).collect();
+        assert_eq!(names, vec!["git", "nvim", "zsh"]);
+    }
+
+    #[test]
+    fn test_cycle_reports_module_names() {
+        let modules = vec![
+            create_test_module("a", vec!["b".to_string()]),
+            create_test_module("b", vec!["a".to_string()]),
+        ];
+
+        let err = resolve_dependencies(modules).unwrap_err().to_string();
+        assert!(err.contains("a -> b") || err.contains("b -> a"), "{}", err);
+    }
+
+    #[test]
+    fn test_resolve_within_ignores_unselected_dependencies() {
+        let modules = vec![
+            create_test_module("app", vec!["lib".to_string(), "missing".to_string()]),
+            create_test_module("lib", vec![]),
+        ];
+
+        let result = resolve_dependencies_within(modules).unwrap();
+        assert_eq!(result[0].definition.name, "lib");
+        assert_eq!(result[1].definition.name, "app");
+    }
+
     #[test]
     fn test_complex_dependency_graph() {
         let modules = vec![
@@ -289,4 +414,48 @@ mod tests {
         assert!(positions["ui"] < positions["app"]);
         assert!(positions["api"] < positions["app"]);
     }
+
+    #[test]
+    fn test_resolve_with_dependencies_pulls_in_transitive_dependencies() {
+        let available = vec![
+            create_test_module("app", vec!["api".to_string()]),
+            create_test_module("api", vec!["db".to_string()]),
+            create_test_module("db", vec![]),
+            create_test_module("other", vec![]),
+        ];
+
+        let result = resolve_with_dependencies(vec![available[0].clone()], &available).unwrap();
+        let names: Vec<_> = result.iter().map(|m| m.definition.name.as_str()).collect();
+        assert_eq!(names, vec!["db", "api", "app"]);
+
+        let broken = create_test_module("broken", vec!["missing".to_string()]);
+        assert_eq!(
+            resolve_with_dependencies(vec![broken], &available).unwrap_err(),
+            DependencyError::MissingDependency {
+                module: "broken".to_string(),
+                dependency: "missing".to_string(),
+            }
+        );
+    }
+
+    #[test]
+    fn test_disabled_dependencies() {
+        let mut available = vec![
+            create_test_module("app", vec!["db".to_string(), "cache".to_string()]),
+            create_test_module("db", vec![]),
+            create_test_module("cache", vec![]),
+        ];
+        assert!(disabled_dependencies(&available, &available).is_empty());
+
+        available[1].definition.enabled = false;
+        assert_eq!(
+            disabled_dependencies(&available[..1], &available),
+            vec![("app", "db")]
+        );
+
+        // A disabled module's own dependencies don't matter
+        available[0].definition.enabled = false;
+        available[2].definition.enabled = false;
+        assert!(disabled_dependencies(&available, &available).is_empty());
+    }
 }
@@ -0,0 +1,182 @@
+//! Fields and action forms that still work but have a replacement
+//!
+//! Loading a module notes each deprecated form it uses, once per module.
+//! `dhd apply` and `dhd plan` list them after their summary, and `dhd check`
+//! under each module, so modules can be migrated at their own pace. With
+//! `--strict`, a module using any of them fails to load instead.
+
+use std::cell::RefCell;
+use std::sync::Mutex;
+use std::sync::atomic::{AtomicBool, Ordering};
+
+/// A deprecated action name or field, and what replaces it
+pub struct Deprecation {
+    /// The action function, like `copyFile`, or `module` for a module's keys
+    pub action: &'static str,
+    /// The deprecated field, or `None` when the action's name is deprecated
+    pub field: Option<&'static str>,
+    /// The action or field to use instead
+    pub replacement: &'static str,
+}
+
+/// Every deprecated form the loaders still accept
+pub const DEPRECATIONS: &[Deprecation] = &[
+    Deprecation {
+        action: "linkDotfile",
+        field: None,
+        replacement: "linkFile",
+    },
+    Deprecation {
+        action: "directory",
+        field: None,
+        replacement: "ensureDir",
+    },
+    Deprecation {
+        action: "copyFile",
+        field: Some("requiresPrivilegeEscalation"),
+        replacement: "escalate",
+    },
+    Deprecation {
+        action: "module",
+        field: Some("dependencies"),
+        replacement: "dependsOn",
+    },
+];
+
+impl Deprecation {
+    /// What to tell the module's author
+    pub fn message(&self) -> String {
+        match (self.action, self.field) {
+            ("module", Some(field)) => format!(
+                "the module key '{}' is deprecated, use '{}' instead",
+                field, self.replacement
+            ),
+            (action, Some(field)) => format!(
+                "{}'s '{}' is deprecated, use '{}' instead",
+                action, field, self.replacement
+            ),
+            (action, None) => format!("{} is deprecated, use {} instead", action, self.replacement),
+        }
+    }
+}
+
+static STRICT: AtomicBool = AtomicBool::new(false);
+
+/// Deprecations of the modules loaded so far, as `module 'name': message`
+static REPORTED: Mutex<Vec<String>> = Mutex::new(Vec::new());
+
+thread_local! {
+    /// Deprecations of the module being (or last) loaded on this thread
+    static FOUND: RefCell<Vec<String>> = const { RefCell::new(Vec::new()) };
+}
+
+/// Make modules using deprecated forms fail to load from now on
+pub fn set_strict(strict: bool) {
+    STRICT.store(strict, Ordering::Relaxed);
+}
+
+/// Whether deprecated forms are errors
+pub fn strict() -> bool {
+    STRICT.load(Ordering::Relaxed)
+}
+
+/// Note the deprecations that `action` called with `fields` runs into
+pub(crate) fn check(action: &str, fields: &[&str]) {
+    // The fields of an old action name are those of its replacement
+    let canonical = DEPRECATIONS
+        .iter()
+        .find(|deprecation| deprecation.action == action && deprecation.field.is_none())
+        .map_or(action, |deprecation| deprecation.replacement);
+    for deprecation in DEPRECATIONS {
+        let applies = match deprecation.field {
+            None => deprecation.action == action,
+            Some(field) => deprecation.action == canonical && fields.contains(&field),
+        };
+        if applies {
+            let message = deprecation.message();
+            FOUND.with(|found| {
+                let mut found = found.borrow_mut();
+                if !found.contains(&message) {
+                    found.push(message);
+                }
+            });
+        }
+    }
+}
+
+/// Start noting the deprecations of another module
+pub(crate) fn begin() {
+    FOUND.with(|found| found.borrow_mut().clear());
+}
+
+/// The deprecations noted since the last module started loading on this thread
+pub fn found() -> Vec<String> {
+    FOUND.with(|found| found.borrow().clone())
+}
+
+/// Keep the deprecations `module` uses for the run's summary, once however
+/// often it's loaded, as with `--watch`
+pub(crate) fn report(module: &str, deprecations: &[String]) {
+    let mut reported = REPORTED.lock().unwrap_or_else(|e| e.into_inner());
+    for deprecation in deprecations {
+        let deprecation = format!("module '{}': {}", module, deprecation);
+        if !reported.contains(&deprecation) {
+            reported.push(deprecation);
+        }
+    }
+}
+
+/// The deprecations of the modules loaded so far
+pub fn reported() -> Vec<String> {
+    REPORTED.lock().unwrap_or_else(|e| e.into_inner()).clone()
+}
+
+/// The lines listing the deprecations of the modules loaded so far, or
+/// nothing when there are none
+pub fn summary() -> String {
+    let reported = reported();
+    if reported.is_empty() {
+        return String::new();
+    }
+    let mut summary = format!("\n⚠️  Deprecated ({}):\n", reported.len());
+    for deprecation in &reported {
+        summary.push_str(&format!("   - {}\n", deprecation));
+    }
+    summary.push_str("   They still work for now; --strict makes them errors.\n");
+    summary
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_deprecations_are_noted_once() {
+        begin();
+        check("linkFile", &["source", "target"]);
+        assert!(found().is_empty());
+
+        check("linkDotfile", &["source", "target"]);
+        check("linkDotfile", &["source", "target"]);
+        check("ensureDir", &["path"]);
+        check("directory", &["path"]);
+        check(
+            "copyFile",
+            &["source", "target", "requiresPrivilegeEscalation"],
+        );
+        check("symlink", &["requiresPrivilegeEscalation"]);
+        check("module", &["dependencies"]);
+        assert_eq!(
+            found(),
+            [
+                "linkDotfile is deprecated, use linkFile instead",
+                "directory is deprecated, use ensureDir instead",
+                "copyFile's 'requiresPrivilegeEscalation' is deprecated, use 'escalate' instead",
+                "the module key 'dependencies' is deprecated, use 'dependsOn' instead",
+            ]
+        );
+
+        begin();
+        assert!(found().is_empty());
+    }
+}
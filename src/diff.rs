@@ -0,0 +1,289 @@
+use std::path::PathBuf;
+
+/// Lines of unchanged context shown around each hunk
+const CONTEXT_LINES: usize = 3;
+
+/// The content a file atom would write next to what is currently on disk
+#[derive(Debug, Clone, PartialEq)]
+pub struct FileChange {
+    pub target: PathBuf,
+    /// Current content of the target, `None` if it does not exist
+    pub current: Option<Vec<u8>>,
+    /// Content the atom would write
+    pub desired: Vec<u8>,
+}
+
+impl FileChange {
+    /// Whether applying the atom would change the target's content
+    pub fn is_changed(&self) -> bool {
+        self.current.as_deref() != Some(self.desired.as_slice())
+    }
+
+    /// Whether applying the atom would replace existing, different content
+    pub fn overwrites(&self) -> bool {
+        self.current.is_some() && self.is_changed()
+    }
+
+    /// Whether either side should be reported as binary instead of diffed
+    pub fn is_binary(&self) -> bool {
+        is_binary(&self.desired) || self.current.as_deref().is_some_and(is_binary)
+    }
+
+    /// Render a unified diff from the current to the desired content
+    ///
+    /// Returns `None` when the content is identical.
+    pub fn render(&self) -> Option<String> {
+        if !self.is_changed() {
+            return None;
+        }
+
+        let target = self.target.display().to_string();
+        if self.is_binary() {
+            return Some(format!("{}: differs (binary)\n", target));
+        }
+
+        let current = self
+            .current
+            .as_deref()
+            .map(String::from_utf8_lossy)
+            .unwrap_or_default();
+        let desired = String::from_utf8_lossy(&self.desired);
+        let old_label = if self.current.is_some() {
+            target.as_str()
+        } else {
+            "/dev/null"
+        };
+
+        Some(unified_diff(&current, &desired, old_label, &target))
+    }
+}
+
+/// Treat content with NUL bytes or invalid UTF-8 as binary
+pub fn is_binary(content: &[u8]) -> bool {
+    content.contains(&0) || std::str::from_utf8(content).is_err()
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Op {
+    Equal,
+    Delete,
+    Insert,
+}
+
+/// Produce a unified diff between two texts, empty if they are identical
+pub fn unified_diff(old: &str, new: &str, old_label: &str, new_label: &str) -> String {
+    let old_lines: Vec<&str> = old.split_inclusive('\n').collect();
+    let new_lines: Vec<&str> = new.split_inclusive('\n').collect();
+    let ops = diff_lines(&old_lines, &new_lines);
+
+    if ops.iter().all(|(op, _)| *op == Op::Equal) {
+        return String::new();
+    }
+
+    let mut output = format!("--- {}\n+++ {}\n", old_label, new_label);
+
+    // Position in the old and new files before each op
+    let mut positions = Vec::with_capacity(ops.len() + 1);
+    let (mut old_pos, mut new_pos) = (0, 0);
+    for (op, _) in &ops {
+        positions.push((old_pos, new_pos));
+        match op {
+            Op::Equal => {
+                old_pos += 1;
+                new_pos += 1;
+            }
+            Op::Delete => old_pos += 1,
+            Op::Insert => new_pos += 1,
+        }
+    }
+    positions.push((old_pos, new_pos));
+
+    for (start, end) in hunk_ranges(&ops) {
+        let (old_start, new_start) = positions[start];
+        let (old_end, new_end) = positions[end];
+        output.push_str(&format!(
+            "@@ -{} +{} @@\n",
+            hunk_range(old_start, old_end - old_start),
+            hunk_range(new_start, new_end - new_start)
+        ));
+
+        for (op, line) in &ops[start..end] {
+            let prefix = match op {
+                Op::Equal => ' ',
+                Op::Delete => '-',
+                Op::Insert => '+',
+            };
+            output.push(prefix);
+            output.push_str(line);
+            if !line.ends_with('\n') {
+                output.push_str("\n\\ No newline at end of file\n");
+            }
+        }
+    }
+
+    output
+}
+
+/// Format one side of a hunk header
+fn hunk_range(start: usize, count: usize) -> String {
+    // An empty range points at the line before it
+    let start = if count == 0 { start } else { start + 1 };
+    if count == 1 {
+        start.to_string()
+    } else {
+        format!("{},{}", start, count)
+    }
+}
+
+/// Group changed ops into hunks with surrounding context, as `[start, end)` ranges
+fn hunk_ranges(ops: &[(Op, &str)]) -> Vec<(usize, usize)> {
+    let mut ranges: Vec<(usize, usize)> = Vec::new();
+
+    for (index, (op, _)) in ops.iter().enumerate() {
+        if *op == Op::Equal {
+            continue;
+        }
+
+        let start = index.saturating_sub(CONTEXT_LINES);
+        let end = (index + 1 + CONTEXT_LINES).min(ops.len());
+        match ranges.last_mut() {
+            Some(last) if start <= last.1 => last.1 = end,
+            _ => ranges.push((start, end)),
+        }
+    }
+
+    ranges
+}
+
+/// Line diff based on the longest common subsequence
+fn diff_lines<'a>(old: &[&'a str], new: &[&'a str]) -> Vec<(Op, &'a str)> {
+    // Common prefix and suffix don't need the quadratic table
+    let prefix = old
+        .iter()
+        .zip(new.iter())
+        .take_while(|(a, b)| a == b)
+        .count();
+    let suffix = old[prefix..]
+        .iter()
+        .rev()
+        .zip(new[prefix..].iter().rev())
+        .take_while(|(a, b)| a == b)
+        .count();
+
+    let old_mid = &old[prefix..old.len() - suffix];
+    let new_mid = &new[prefix..new.len() - suffix];
+
+    // lcs[i][j] is the LCS length of old_mid[i..] and new_mid[j..]
+    let mut lcs = vec![vec![0usize; new_mid.len() + 1]; old_mid.len() + 1];
+    for i in (0..old_mid.len()).rev() {
+        for j in (0..new_mid.len()).rev() {
+            lcs[i][j] = if old_mid[i] == new_mid[j] {
+                lcs[i + 1][j + 1] + 1
+            } else {
+                lcs[i + 1][j].max(lcs[i][j + 1])
+            };
+        }
+    }
+
+    let mut ops: Vec<(Op, &str)> = old[..prefix].iter().map(|l| (Op::Equal, *l)).collect();
+    let (mut i, mut j) = (0, 0);
+    while i < old_mid.len() && j < new_mid.len() {
+        if old_mid[i] == new_mid[j] {
+            ops.push((Op::Equal, old_mid[i]));
+            i += 1;
+            j += 1;
+        } else if lcs[i + 1][j] >= lcs[i][j + 1] {
+            ops.push((Op::Delete, old_mid[i]));
+            i += 1;
+        } else {
+            ops.push((Op::Insert, new_mid[j]));
+            j += 1;
+        }
+    }
+    ops.extend(old_mid[i..].iter().map(|l| (Op::Delete, *l)));
+    ops.extend(new_mid[j..].iter().map(|l| (Op::Insert, *l)));
+    ops.extend(old[old.len() - suffix..].iter().map(|l| (Op::Equal, *l)));
+
+    ops
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_identical_text_has_no_diff() {
+        assert_eq!(unified_diff("a\nb\n", "a\nb\n", "old", "new"), "");
+    }
+
+    #[test]
+    fn test_single_line_change() {
+        let diff = unified_diff("a\nb\nc\n", "a\nx\nc\n", "old", "new");
+        assert_eq!(diff, "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n");
+    }
+
+    #[test]
+    fn test_new_file_diff() {
+        let diff = unified_diff("", "hello\n", "/dev/null", "file");
+        assert_eq!(diff, "--- /dev/null\n+++ file\n@@ -0,0 +1 @@\n+hello\n");
+    }
+
+    #[test]
+    fn test_distant_changes_produce_separate_hunks() {
+        let old: String = (1..=20).map(|n| format!("{}\n", n)).collect();
+        let new: String = (1..=20)
+            .map(|n| match n {
+                2 => "two\n".to_string(),
+                19 => "nineteen\n".to_string(),
+                n => format!("{}\n", n),
+            })
+            .collect();
+
+        let diff = unified_diff(&old, &new, "old", "new");
+        assert_eq!(diff.matches("@@ -").count(), 2);
+        assert!(diff.contains("@@ -1,5 +1,5 @@"));
+        assert!(diff.contains("@@ -16,5 +16,5 @@"));
+    }
+
+    #[test]
+    fn test_missing_trailing_newline_is_marked() {
+        let diff = unified_diff("a\n", "a\nb", "old", "new");
+        assert!(diff.ends_with("+b\n\\ No newline at end of file\n"));
+    }
+
+    #[test]
+    fn test_binary_change_is_not_dumped() {
+        let change = FileChange {
+            target: PathBuf::from("/tmp/image.png"),
+            current: Some(vec![0x89, 0x50, 0x00, 0x01]),
+            desired: vec![0x89, 0x50, 0x00, 0x02],
+        };
+        assert_eq!(
+            change.render().unwrap(),
+            "/tmp/image.png: differs (binary)\n"
+        );
+    }
+
+    #[test]
+    fn test_unchanged_file_renders_nothing() {
+        let change = FileChange {
+            target: PathBuf::from("/tmp/file"),
+            current: Some(b"same\n".to_vec()),
+            desired: b"same\n".to_vec(),
+        };
+        assert!(!change.is_changed());
+        assert_eq!(change.render(), None);
+    }
+
+    #[test]
+    fn test_only_replacing_different_content_overwrites() {
+        let change = |current: Option<&str>| FileChange {
+            target: PathBuf::from("/etc/app.conf"),
+            current: current.map(|c| c.as_bytes().to_vec()),
+            desired: b"new\n".to_vec(),
+        };
+        assert!(change(Some("old\n")).overwrites());
+        assert!(!change(Some("new\n")).overwrites());
+        assert!(!change(None).overwrites());
+    }
+}
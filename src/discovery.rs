@@ -1,4 +1,4 @@
-use std::collections::HashSet;
+use std::collections::{HashMap, HashSet};
 use std::fs;
 use std::path::{Path, PathBuf};
 
@@ -6,6 +6,12 @@ use std::path::{Path, PathBuf};
 pub struct DiscoveredModule {
     pub path: PathBuf,
     pub name: String,
+    /// Set for modules imported from another directory or repository
+    pub namespace: Option<String>,
+    /// Variables of the `dhd.config.ts` the module was discovered under
+    pub variables: HashMap<String, String>,
+    /// Package groups of that `dhd.config.ts`, by name
+    pub package_groups: HashMap<String, Vec<String>>,
 }
 
 impl DiscoveredModule {
@@ -23,7 +29,7 @@ impl DiscoveredModule {
 }
 
 // Directories to exclude from module discovery
-const EXCLUDED_DIRS: &[&str] = &["node_modules", "dist", "build", ".git", "target"];
+pub(crate) const EXCLUDED_DIRS: &[&str] = &["node_modules", "dist", "build", ".git", "target"];
 
 pub fn discover_modules(dir: &Path) -> Result<Vec<DiscoveredModule>, std::io::Error> {
     let mut modules = Vec::new();
@@ -93,30 +99,55 @@ fn discover_modules_recursive(
             // Recursively search subdirectories
             discover_modules_recursive(&path, modules, excluded_dirs)?;
         } else if path.is_file() {
-            // Check if it's a TypeScript file
-            if let Some(extension) = path.extension() {
-                if extension == "ts" {
-                    // Skip generated files
-                    if file_name_str == "types.d.ts" {
-                        continue;
-                    }
-
-                    // Extract the module name (filename without extension)
-                    if let Some(stem) = path.file_stem() {
-                        let name = stem.to_string_lossy().into_owned();
-                        modules.push(DiscoveredModule {
-                            path: path.clone(),
-                            name,
-                        });
-                    }
-                }
-            }
+            modules.extend(module_at(&path));
         }
     }
 
     Ok(())
 }
 
+/// The module in the file at `path`, if it is one
+fn module_at(path: &Path) -> Option<DiscoveredModule> {
+    let module = |name: String| DiscoveredModule {
+        path: path.to_path_buf(),
+        name,
+        namespace: None,
+        variables: HashMap::new(),
+        package_groups: HashMap::new(),
+    };
+
+    // Declarative modules are named after their file, e.g. zsh.dhd.yaml
+    if let Some((name, _)) = crate::declarative::module_file(path) {
+        return Some(module(name));
+    }
+
+    // Skip generated files and the import configuration
+    let file_name = path.file_name()?.to_string_lossy();
+    if path.extension()? != "ts"
+        || file_name == "types.d.ts"
+        || file_name == crate::imports::CONFIG_FILE
+    {
+        return None;
+    }
+
+    // Extract the module name (filename without extension)
+    let stem = path.file_stem()?;
+    Some(module(stem.to_string_lossy().into_owned()))
+}
+
+/// The module of a single file, as `--file` applies it
+pub fn discover_file(path: &Path) -> Result<DiscoveredModule, String> {
+    if !path.is_file() {
+        return Err(format!("Module file {} does not exist", path.display()));
+    }
+    module_at(path).ok_or_else(|| {
+        format!(
+            "{} is not a module file: expected a .ts, .dhd.yaml, .dhd.yml or .dhd.toml file",
+            path.display()
+        )
+    })
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -143,6 +174,28 @@ mod tests {
         assert_eq!(modules[0].path, module_path);
     }
 
+    #[test]
+    fn test_discover_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let module_path = temp_dir.path().join("scratch.ts");
+        File::create(&module_path).unwrap();
+        File::create(temp_dir.path().join("notes.md")).unwrap();
+
+        let module = discover_file(&module_path).unwrap();
+        assert_eq!(module.name, "scratch");
+        assert_eq!(module.path, module_path);
+        assert!(
+            discover_file(&temp_dir.path().join("notes.md"))
+                .unwrap_err()
+                .contains("is not a module file")
+        );
+        assert!(
+            discover_file(&temp_dir.path().join("missing.ts"))
+                .unwrap_err()
+                .contains("does not exist")
+        );
+    }
+
     #[test]
     fn test_discover_modules_multiple_ts_files() {
         let temp_dir = TempDir::new().unwrap();
@@ -175,6 +228,21 @@ mod tests {
         assert_eq!(modules[0].name, "module");
     }
 
+    #[test]
+    fn test_discover_declarative_modules() {
+        let temp_dir = TempDir::new().unwrap();
+
+        File::create(temp_dir.path().join("zsh.dhd.yaml")).unwrap();
+        File::create(temp_dir.path().join("git.dhd.toml")).unwrap();
+        // Plain YAML and TOML files are dotfiles, not modules
+        File::create(temp_dir.path().join("starship.toml")).unwrap();
+        File::create(temp_dir.path().join("alacritty.yml")).unwrap();
+
+        let modules = discover_modules(temp_dir.path()).unwrap();
+        let names: Vec<&str> = modules.iter().map(|m| m.name.as_str()).collect();
+        assert_eq!(names, vec!["git", "zsh"]);
+    }
+
     #[test]
     fn test_discover_modules_nested_directories() {
         let temp_dir = TempDir::new().unwrap();
@@ -278,6 +346,9 @@ mod tests {
         let module = DiscoveredModule {
             path: PathBuf::from("/home/user/project/src/module.ts"),
             name: "module".to_string(),
+            namespace: None,
+            variables: HashMap::new(),
+            package_groups: HashMap::new(),
         };
 
         let relative = module.relative_path(base).unwrap();
@@ -290,6 +361,9 @@ mod tests {
         let module = DiscoveredModule {
             path: PathBuf::from("/home/other/module.ts"),
             name: "module".to_string(),
+            namespace: None,
+            variables: HashMap::new(),
+            package_groups: HashMap::new(),
         };
 
         assert!(module.relative_path(base).is_none());
@@ -302,18 +376,27 @@ mod tests {
         let root_module = DiscoveredModule {
             path: PathBuf::from("/home/user/project/module.ts"),
             name: "module".to_string(),
+            namespace: None,
+            variables: HashMap::new(),
+            package_groups: HashMap::new(),
         };
         assert!(!root_module.is_nested(base));
 
         let nested_module = DiscoveredModule {
             path: PathBuf::from("/home/user/project/src/module.ts"),
             name: "module".to_string(),
+            namespace: None,
+            variables: HashMap::new(),
+            package_groups: HashMap::new(),
         };
         assert!(nested_module.is_nested(base));
 
         let deeply_nested = DiscoveredModule {
             path: PathBuf::from("/home/user/project/src/components/module.ts"),
             name: "module".to_string(),
+            namespace: None,
+            variables: HashMap::new(),
+            package_groups: HashMap::new(),
         };
         assert!(deeply_nested.is_nested(base));
     }
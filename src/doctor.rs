@@ -0,0 +1,455 @@
+//! Diagnose the environment an apply would run in
+//!
+//! Each check reports what it found, how serious a problem is and how to fix
+//! it. Critical findings are the ones that would make an apply fail; warnings
+//! only matter for some modules or limit what DHD can detect.
+
+use crate::actions::ActionType;
+use crate::atoms::package::{PackageManager, command_exists};
+use crate::check::check_modules;
+use crate::loader::{LoadedModule, load_modules};
+use crate::platform::{LinuxDistro, OS_RELEASE_VAR, Platform, current_platform};
+use std::collections::{BTreeMap, BTreeSet};
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// How serious a finding is
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+pub enum Severity {
+    Ok,
+    Warning,
+    /// An apply would fail
+    Critical,
+}
+
+/// The result of one check
+#[derive(Debug, Clone, PartialEq)]
+pub struct Finding {
+    /// What was checked, e.g. `Package manager`
+    pub check: String,
+    pub severity: Severity,
+    pub detail: String,
+    /// How to fix it, for warnings and critical findings
+    pub hint: Option<String>,
+}
+
+impl Finding {
+    fn ok(check: &str, detail: impl Into<String>) -> Self {
+        Finding {
+            check: check.to_string(),
+            severity: Severity::Ok,
+            detail: detail.into(),
+            hint: None,
+        }
+    }
+
+    fn problem(check: &str, severity: Severity, detail: impl Into<String>, hint: &str) -> Self {
+        Finding {
+            check: check.to_string(),
+            severity,
+            detail: detail.into(),
+            hint: Some(hint.to_string()),
+        }
+    }
+}
+
+/// Tools the actions of a type run, besides DHD itself
+fn tools_of(action: &ActionType) -> Vec<&'static str> {
+    match action {
+        ActionType::GitRepo(_) => vec!["git"],
+        ActionType::GitConfig(action) if action.entries.is_some() => vec!["git"],
+        ActionType::HttpDownload(_) | ActionType::RemoteFile(_) => vec!["curl"],
+        ActionType::GpgKey(action) if action.key_url.is_some() => vec!["gpg", "curl"],
+        ActionType::GpgKey(_) => vec!["gpg"],
+        ActionType::Cron(action) => match action.backend.as_deref() {
+            Some("systemd") => vec!["systemctl"],
+            Some(_) => vec!["crontab"],
+            // Either one will do, so neither is required
+            None => Vec::new(),
+        },
+        ActionType::DconfImport(_) => vec!["dconf"],
+        ActionType::InstallGnomeExtensions(_) => vec!["gext"],
+        ActionType::SystemdService(_)
+        | ActionType::SystemdSocket(_)
+        | ActionType::SystemdManage(_) => vec!["systemctl"],
+        ActionType::DecryptFile(_) => vec!["age"],
+        ActionType::Conditional(action) => tools_of(&action.action),
+        ActionType::Notify(action) => tools_of(&action.action),
+        ActionType::Tagged(action) => tools_of(&action.action),
+        ActionType::Verified(action) => tools_of(&action.action),
+        ActionType::Ordered(action) => tools_of(&action.action),
+        ActionType::Reboot(action) => tools_of(&action.action),
+        _ => Vec::new(),
+    }
+}
+
+/// The package manager of a `packageInstall` or `packageRemove`, through
+/// wrappers; `Some(None)` for one using the detected manager
+fn package_manager_of(action: &ActionType) -> Option<Option<PackageManager>> {
+    match action {
+        ActionType::PackageInstall(action) => Some(action.manager.clone()),
+        ActionType::PackageRemove(action) => Some(action.manager.clone()),
+        ActionType::Conditional(action) => package_manager_of(&action.action),
+        ActionType::Notify(action) => package_manager_of(&action.action),
+        ActionType::Tagged(action) => package_manager_of(&action.action),
+        ActionType::Verified(action) => package_manager_of(&action.action),
+        ActionType::Ordered(action) => package_manager_of(&action.action),
+        ActionType::Reboot(action) => package_manager_of(&action.action),
+        _ => None,
+    }
+}
+
+/// Whether a package manager installs system-wide, through sudo
+fn runs_as_root(manager: &PackageManager) -> bool {
+    matches!(
+        manager,
+        PackageManager::Apt
+            | PackageManager::Dnf
+            | PackageManager::Pacman
+            | PackageManager::Snap
+            | PackageManager::Yum
+            | PackageManager::Zypper
+    )
+}
+
+/// Every action of a module, including those of its handlers
+fn module_actions(module: &LoadedModule) -> impl Iterator<Item = &ActionType> {
+    module.definition.actions.iter().chain(
+        module
+            .definition
+            .handlers
+            .iter()
+            .flat_map(|handler| handler.actions.iter()),
+    )
+}
+
+/// Run every check against the modules of `roots`, with the host profile
+/// `host` or the one matching this host
+pub fn diagnose(roots: &[PathBuf], host: Option<&str>) -> Vec<Finding> {
+    let mut findings = vec![platform_finding(), typescript_finding()];
+    let detected = PackageManager::detect();
+    findings.push(match &detected {
+        Some(manager) => Finding::ok(
+            "Package manager",
+            format!("{} detected", manager.get_provider().name()),
+        ),
+        None => Finding::problem(
+            "Package manager",
+            Severity::Warning,
+            "none detected",
+            "Install your system's package manager, or set `manager` on packageInstall actions",
+        ),
+    });
+
+    let mut readable = true;
+    for root in roots {
+        let finding = root_finding(root);
+        readable &= finding.severity != Severity::Critical;
+        findings.push(finding);
+    }
+
+    let mut modules = Vec::new();
+    let mut imports_git = false;
+    let host = if readable {
+        match crate::imports::select_host(roots, host) {
+            Ok(host) => Some(host),
+            Err(e) => {
+                findings.push(Finding::problem(
+                    "Config",
+                    Severity::Critical,
+                    e,
+                    "Fix dhd.config.ts, or pass a host profile it defines to --host",
+                ));
+                None
+            }
+        }
+    } else {
+        None
+    };
+    if let Some(host) = host {
+        let host = host.as_ref().map(|(name, _)| name.as_str());
+        match crate::imports::discover_roots(roots, host) {
+            Ok(discovered) => {
+                let invalid = check_modules(&discovered)
+                    .iter()
+                    .filter(|check| !check.is_valid())
+                    .count();
+                findings.push(if invalid == 0 {
+                    Finding::ok("Modules", format!("{} module(s) load", discovered.len()))
+                } else {
+                    Finding::problem(
+                        "Modules",
+                        Severity::Critical,
+                        format!("{} of {} module(s) are invalid", invalid, discovered.len()),
+                        "Run `dhd check` to see what is wrong with them",
+                    )
+                });
+                modules = load_modules(discovered)
+                    .into_iter()
+                    .filter_map(Result::ok)
+                    .collect();
+            }
+            Err(e) => findings.push(Finding::problem(
+                "Modules",
+                Severity::Critical,
+                e,
+                "Fix the imports in dhd.config.ts",
+            )),
+        }
+        imports_git = roots.iter().any(|root| {
+            crate::imports::load_imports(root)
+                .map(|imports| imports.iter().any(|import| import.git.is_some()))
+                .unwrap_or(false)
+        });
+    }
+
+    findings.extend(package_findings(&modules, detected.as_ref()));
+    findings.push(root_access_finding(&modules, detected.as_ref()));
+    findings.extend(tool_findings(&modules, imports_git));
+    findings.push(state_dir_finding(&crate::platform::dhd_state_dir()));
+    findings
+}
+
+fn platform_finding() -> Finding {
+    match current_platform() {
+        Platform::Linux(LinuxDistro::Other) => Finding::problem(
+            "System",
+            Severity::Warning,
+            "Linux, unknown distribution",
+            &format!(
+                "Package names and os.distro conditions need a known distribution; \
+                 point {} at an os-release file to pick one",
+                OS_RELEASE_VAR
+            ),
+        ),
+        Platform::Linux(distro) => Finding::ok("System", format!("Linux ({})", distro.id())),
+        Platform::Unknown => Finding::problem(
+            "System",
+            Severity::Warning,
+            "unknown operating system",
+            "DHD supports Linux and macOS; os conditions won't match anything here",
+        ),
+        platform => Finding::ok("System", platform.name()),
+    }
+}
+
+fn typescript_finding() -> Finding {
+    Finding::ok(
+        "TypeScript",
+        "modules are parsed by DHD itself; Bun and Node aren't needed",
+    )
+}
+
+fn root_finding(root: &Path) -> Finding {
+    if !root.is_dir() {
+        return Finding::problem(
+            "Modules path",
+            Severity::Critical,
+            format!("{} does not exist", root.display()),
+            "Pass the directory of your modules with --modules-path, or run DHD from it",
+        );
+    }
+    match fs::read_dir(root) {
+        Ok(_) => Finding::ok("Modules path", format!("{} is readable", root.display())),
+        Err(e) => Finding::problem(
+            "Modules path",
+            Severity::Critical,
+            format!("{} can't be read: {}", root.display(), e),
+            "Give your user read access to the directory",
+        ),
+    }
+}
+
+/// Managers named by package actions that aren't installed, and package
+/// actions left without a manager
+fn package_findings(modules: &[LoadedModule], detected: Option<&PackageManager>) -> Vec<Finding> {
+    let mut missing: BTreeMap<String, BTreeSet<&str>> = BTreeMap::new();
+    let mut unmanaged = BTreeSet::new();
+    for module in modules {
+        for manager in module_actions(module).filter_map(package_manager_of) {
+            match manager {
+                Some(manager) => {
+                    let provider = manager.get_provider();
+                    if !provider.is_available() {
+                        missing
+                            .entry(provider.name().to_string())
+                            .or_default()
+                            .insert(module.definition.name.as_str());
+                    }
+                }
+                None if detected.is_none() => {
+                    unmanaged.insert(module.definition.name.as_str());
+                }
+                None => {}
+            }
+        }
+    }
+
+    let mut findings: Vec<Finding> = missing
+        .into_iter()
+        .map(|(manager, users)| {
+            Finding::problem(
+                "Package manager",
+                Severity::Critical,
+                format!(
+                    "{} isn't installed, but {} use(s) it",
+                    manager,
+                    users.into_iter().collect::<Vec<_>>().join(", ")
+                ),
+                &format!("Install {}, or drop the modules that need it", manager),
+            )
+        })
+        .collect();
+    if !unmanaged.is_empty() {
+        findings.push(Finding::problem(
+            "Package manager",
+            Severity::Critical,
+            format!(
+                "{} install(s) packages, but no package manager was detected",
+                unmanaged.into_iter().collect::<Vec<_>>().join(", ")
+            ),
+            "Set `manager` on their packageInstall actions",
+        ));
+    }
+    findings
+}
+
+/// Whether commands can run as root, critical when some action needs it
+fn root_access_finding(modules: &[LoadedModule], detected: Option<&PackageManager>) -> Finding {
+    if crate::privilege::is_root() {
+        return Finding::ok("Root access", "running as root");
+    }
+    if command_exists("sudo") {
+        return Finding::ok("Root access", "sudo is available");
+    }
+
+    let needs_root: BTreeSet<&str> = modules
+        .iter()
+        .filter(|module| {
+            module_actions(module).any(|action| {
+                action.escalates()
+                    || action.type_name() == "packageRepo"
+                    || package_manager_of(action).is_some_and(|manager| {
+                        manager.as_ref().or(detected).is_some_and(runs_as_root)
+                    })
+            })
+        })
+        .map(|module| module.definition.name.as_str())
+        .collect();
+
+    if needs_root.is_empty() {
+        return Finding::problem(
+            "Root access",
+            Severity::Warning,
+            "sudo isn't installed",
+            "Install sudo before adding actions that run as root",
+        );
+    }
+    Finding::problem(
+        "Root access",
+        Severity::Critical,
+        format!(
+            "sudo isn't installed, but {} run(s) commands as root",
+            needs_root.into_iter().collect::<Vec<_>>().join(", ")
+        ),
+        "Install sudo and allow your user to use it, or run DHD as root",
+    )
+}
+
+/// The external tools the modules' actions run, missing ones critical
+fn tool_findings(modules: &[LoadedModule], imports_git: bool) -> Vec<Finding> {
+    let mut users: BTreeMap<&str, BTreeSet<&str>> = BTreeMap::new();
+    if imports_git {
+        users.entry("git").or_default().insert("dhd.config.ts");
+    }
+    for module in modules {
+        for tool in module_actions(module).flat_map(tools_of) {
+            users
+                .entry(tool)
+                .or_default()
+                .insert(module.definition.name.as_str());
+        }
+    }
+
+    users
+        .into_iter()
+        .map(|(tool, users)| {
+            let users = users.into_iter().collect::<Vec<_>>().join(", ");
+            if command_exists(tool) {
+                Finding::ok(tool, format!("installed, used by {}", users))
+            } else {
+                Finding::problem(
+                    tool,
+                    Severity::Critical,
+                    format!("isn't installed, but {} need(s) it", users),
+                    &format!("Install {} with your package manager", tool),
+                )
+            }
+        })
+        .collect()
+}
+
+/// Whether DHD can record what it applied in `dir`, or create it
+fn state_dir_finding(dir: &Path) -> Finding {
+    // The nearest directory that exists is the one that must be writable
+    let existing = dir.ancestors().find(|dir| dir.is_dir()).unwrap_or(dir);
+    let probe = existing.join(format!(".dhd-doctor-{}", std::process::id()));
+    match fs::File::create(&probe) {
+        Ok(_) => {
+            let _ = fs::remove_file(&probe);
+            Finding::ok("State directory", format!("{} is writable", dir.display()))
+        }
+        Err(e) => Finding::problem(
+            "State directory",
+            Severity::Critical,
+            format!("{} isn't writable: {}", dir.display(), e),
+            "Fix its permissions, or point --dhd-home at a writable directory",
+        ),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::conditional::only_if;
+    use crate::actions::git_repo::GitRepo;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_tools_of_sees_through_wrappers() {
+        let git_repo = ActionType::GitRepo(GitRepo {
+            url: "https://example.com/dotfiles.git".to_string(),
+            path: "~/dotfiles".to_string(),
+            r#ref: None,
+            depth: None,
+            update: None,
+            retries: None,
+            retry_delay: None,
+        });
+        let wrapped = only_if(git_repo, Vec::new());
+        assert_eq!(tools_of(&wrapped), vec!["git"]);
+    }
+
+    #[test]
+    fn test_missing_modules_path_is_critical() {
+        let temp_dir = TempDir::new().unwrap();
+        let findings = diagnose(&[temp_dir.path().join("missing")], None);
+        let finding = findings
+            .iter()
+            .find(|finding| finding.check == "Modules path")
+            .unwrap();
+        assert_eq!(finding.severity, Severity::Critical);
+        assert!(finding.hint.is_some());
+        // Modules aren't discovered from a path that doesn't exist
+        assert!(!findings.iter().any(|finding| finding.check == "Modules"));
+    }
+
+    #[test]
+    fn test_state_dir_finding_checks_the_nearest_existing_directory() {
+        let temp_dir = TempDir::new().unwrap();
+        let finding = state_dir_finding(&temp_dir.path().join("state").join("dhd"));
+        assert_eq!(finding.severity, Severity::Ok);
+        assert!(!temp_dir.path().join("state").exists());
+        assert_eq!(fs::read_dir(temp_dir.path()).unwrap().count(), 0);
+    }
+}
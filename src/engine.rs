@@ -0,0 +1,356 @@
+//! DHD as a library: loading, planning and applying modules like `dhd plan`
+//! and `dhd apply`, for tools that embed DHD instead of running the binary
+//!
+//! ```no_run
+//! use dhd::{Engine, ModuleFilter};
+//!
+//! let engine = Engine::new(vec!["/srv/dotfiles".into()]).with_filter(ModuleFilter {
+//!     modules: vec!["zsh".to_string()],
+//!     ..ModuleFilter::default()
+//! });
+//! let plan = engine.plan()?;
+//! if plan.pending_count() > 0 {
+//!     let report = engine.apply()?;
+//!     println!("{}", serde_json::to_string_pretty(&report)?);
+//! }
+//! # Ok::<(), Box<dyn std::error::Error>>(())
+//! ```
+//!
+//! Modules are selected the way the CLI selects them: the host profile's
+//! modules and tags apply when the filter names none, and the modules the
+//! selected ones depend on are pulled in. The settings of `dhd.config.ts`
+//! (secret prefix, package manager flags and shell) are process-wide, so
+//! every engine uses the ones of the module roots it loaded last.
+
+use crate::dependency_resolver::{
+    disabled_dependencies, resolve_dependencies_within, resolve_with_dependencies,
+};
+use crate::error::{DhdError, Result};
+use crate::execution::{ApplyReport, ExecutionEngine, ModulePlan};
+use crate::imports::HostProfile;
+use crate::loader::{LoadedModule, load_modules};
+use crate::module::ModuleFilter;
+use serde::Serialize;
+use std::path::PathBuf;
+use std::time::Instant;
+
+/// Read bare secret names from the variables with the config's
+/// `secretEnvPrefix`, if it sets one, pass its `packageManagers` flags to
+/// the package managers and run commands with its `shell`
+pub fn use_settings(roots: &[PathBuf]) -> std::result::Result<(), String> {
+    use crate::atoms::package::{PackageManager, args::set_extra_args};
+
+    let settings = crate::imports::load_settings(roots)?;
+    if let Some(prefix) = settings.secret_env_prefix {
+        crate::secrets::set_env_prefix(&prefix);
+    }
+    crate::shell::set_default(settings.shell);
+    for (name, config) in settings.package_managers.into_iter().flatten() {
+        // Loading the config refused unknown managers already
+        if let Ok(manager) = name.parse::<PackageManager>() {
+            set_extra_args(manager, config.extra_args.unwrap_or_default());
+        }
+    }
+    Ok(())
+}
+
+/// Disable the modules that `disabled` of the config of `roots`, or of the
+/// host's profile, names, as if they set `enabled: false`
+pub fn disable_configured(
+    modules: &mut [LoadedModule],
+    roots: &[PathBuf],
+    host: Option<&HostProfile>,
+) -> std::result::Result<(), String> {
+    let settings = crate::imports::load_settings(roots)?;
+    let disabled: Vec<&String> = settings
+        .disabled
+        .iter()
+        .chain(host.and_then(|profile| profile.disabled.as_ref()))
+        .flatten()
+        .collect();
+    for module in modules {
+        if disabled.contains(&&module.definition.name) {
+            module.definition.enabled = false;
+        }
+    }
+    Ok(())
+}
+
+/// What applying the selected modules would change, as `dhd plan` shows it
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct Plan {
+    pub modules: Vec<ModulePlan>,
+}
+
+impl Plan {
+    /// Number of atoms that would change the system, across all modules
+    pub fn pending_count(&self) -> usize {
+        self.modules.iter().map(ModulePlan::pending_count).sum()
+    }
+}
+
+/// Loads, plans and applies the modules of some module roots
+pub struct Engine {
+    roots: Vec<PathBuf>,
+    host: Option<String>,
+    filter: ModuleFilter,
+    no_deps: bool,
+    jobs: usize,
+    dry_run: bool,
+    keep_going: bool,
+    backups: bool,
+}
+
+impl Engine {
+    /// An engine for the modules of `roots`, in override order like
+    /// `--modules-path`, selecting all of them
+    pub fn new(roots: Vec<PathBuf>) -> Self {
+        Self {
+            roots,
+            host: None,
+            filter: ModuleFilter::default(),
+            no_deps: false,
+            jobs: std::thread::available_parallelism()
+                .map(|n| n.get())
+                .unwrap_or(4),
+            dry_run: false,
+            keep_going: false,
+            backups: true,
+        }
+    }
+
+    /// Use the host profile `host`, like `--host`, instead of the one
+    /// matching the hostname
+    pub fn with_host(mut self, host: Option<String>) -> Self {
+        self.host = host;
+        self
+    }
+
+    /// Only select the modules `filter` matches
+    pub fn with_filter(mut self, filter: ModuleFilter) -> Self {
+        self.filter = filter;
+        self
+    }
+
+    /// Don't pull in the dependencies of the selected modules
+    pub fn with_no_deps(mut self, no_deps: bool) -> Self {
+        self.no_deps = no_deps;
+        self
+    }
+
+    /// Apply up to `jobs` modules in parallel (default: the number of CPUs)
+    pub fn with_jobs(mut self, jobs: usize) -> Self {
+        self.jobs = jobs.max(1);
+        self
+    }
+
+    /// Only report what `apply` would do
+    pub fn with_dry_run(mut self, dry_run: bool) -> Self {
+        self.dry_run = dry_run;
+        self
+    }
+
+    /// Keep applying the modules that don't depend on a failed one
+    pub fn with_keep_going(mut self, keep_going: bool) -> Self {
+        self.keep_going = keep_going;
+        self
+    }
+
+    /// Back up replaced files next to themselves (on by default)
+    pub fn with_backups(mut self, backups: bool) -> Self {
+        self.backups = backups;
+        self
+    }
+
+    /// The selected modules, in dependency order
+    ///
+    /// Unlike the CLI, which goes on without them, a module that fails to
+    /// load fails the whole selection. Selected modules that depend on a
+    /// disabled one are logged as warnings.
+    pub fn modules(&self) -> Result<Vec<LoadedModule>> {
+        let profile = crate::imports::select_host(&self.roots, self.host.as_deref())
+            .map_err(DhdError::ModuleLoad)?;
+        let host = profile.as_ref().map(|(name, _)| name.as_str());
+        let discovered =
+            crate::imports::discover_roots(&self.roots, host).map_err(DhdError::ModuleLoad)?;
+        use_settings(&self.roots).map_err(DhdError::ModuleLoad)?;
+
+        let mut available = Vec::new();
+        for (module, result) in discovered.iter().zip(load_modules(discovered.clone())) {
+            let loaded = result.map_err(|e| {
+                DhdError::ModuleLoad(format!("Failed to load module {}: {}", module.name, e))
+            })?;
+            available.push(loaded);
+        }
+        disable_configured(
+            &mut available,
+            &self.roots,
+            profile.as_ref().map(|(_, profile)| profile),
+        )
+        .map_err(DhdError::ModuleLoad)?;
+
+        let mut filter = self.filter.clone();
+        if let Some((_, profile)) = profile {
+            if filter.modules.is_empty() && filter.tags.is_empty() {
+                filter.modules = profile.modules.unwrap_or_default();
+                filter.tags = profile.tags.unwrap_or_default();
+            }
+        }
+        if let Some(name) = filter
+            .modules
+            .iter()
+            .find(|name| !available.iter().any(|m| &m.definition.name == *name))
+        {
+            return Err(DhdError::ModuleLoad(format!("Module '{}' not found", name)));
+        }
+
+        let selected: Vec<LoadedModule> = available
+            .iter()
+            .filter(|module| filter.matches(&module.definition))
+            .cloned()
+            .collect();
+        let ordered = if self.no_deps {
+            resolve_dependencies_within(selected)
+        } else {
+            resolve_with_dependencies(selected, &available)
+        }
+        .map_err(|e| DhdError::DependencyResolution(e.to_string()))?;
+        for (module, dependency) in disabled_dependencies(&ordered, &available) {
+            log::warn!(
+                "Module '{}' depends on '{}', which is disabled",
+                module,
+                dependency
+            );
+        }
+        Ok(ordered)
+    }
+
+    /// What applying the selected modules would change, without changing
+    /// anything
+    pub fn plan(&self) -> Result<Plan> {
+        let modules = self.modules()?;
+        let engine = ExecutionEngine::new(self.jobs, true, false).with_quiet(true);
+        Ok(Plan {
+            modules: engine.plan(modules)?,
+        })
+    }
+
+    /// Apply the selected modules, returning the document of `dhd apply
+    /// --output json`
+    ///
+    /// Failed actions are part of the report rather than an error; the error
+    /// is for selections that can't be loaded or planned.
+    pub fn apply(&self) -> Result<ApplyReport> {
+        let start = Instant::now();
+        let modules = self.modules()?;
+        let engine = ExecutionEngine::new(self.jobs, self.dry_run, false)
+            .with_quiet(true)
+            .with_backups(self.backups)
+            .with_keep_going(self.keep_going);
+        let summary = engine.apply(modules)?;
+        Ok(ApplyReport::new(&summary, self.dry_run, start.elapsed()))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+    use tempfile::TempDir;
+
+    fn write_module(dir: &TempDir, name: &str, dependencies: &[&str]) {
+        let dependencies: Vec<String> = dependencies
+            .iter()
+            .map(|dependency| format!("\"{}\"", dependency))
+            .collect();
+        fs::write(
+            dir.path().join(format!("{}.ts", name)),
+            format!(
+                "export default defineModule(\"{}\")\n  .dependsOn([{}])\n  .actions([]);\n",
+                name,
+                dependencies.join(", ")
+            ),
+        )
+        .unwrap();
+    }
+
+    fn names(modules: &[LoadedModule]) -> Vec<&str> {
+        modules
+            .iter()
+            .map(|module| module.definition.name.as_str())
+            .collect()
+    }
+
+    #[test]
+    fn test_engine_selects_modules_with_their_dependencies() {
+        let dir = TempDir::new().unwrap();
+        write_module(&dir, "app", &["base"]);
+        write_module(&dir, "base", &[]);
+        write_module(&dir, "other", &[]);
+
+        let engine = Engine::new(vec![dir.path().to_path_buf()]);
+        assert_eq!(
+            names(&engine.modules().unwrap()),
+            vec!["base", "app", "other"]
+        );
+
+        let engine = engine.with_filter(ModuleFilter {
+            modules: vec!["app".to_string()],
+            ..ModuleFilter::default()
+        });
+        assert_eq!(names(&engine.modules().unwrap()), vec!["base", "app"]);
+        let engine = engine.with_no_deps(true);
+        assert_eq!(names(&engine.modules().unwrap()), vec!["app"]);
+    }
+
+    #[test]
+    fn test_engine_fails_on_unknown_modules() {
+        let dir = TempDir::new().unwrap();
+        write_module(&dir, "base", &[]);
+
+        let engine = Engine::new(vec![dir.path().to_path_buf()]).with_filter(ModuleFilter {
+            modules: vec!["missing".to_string()],
+            ..ModuleFilter::default()
+        });
+        let error = engine.modules().unwrap_err().to_string();
+        assert!(error.contains("Module 'missing' not found"), "{}", error);
+    }
+
+    #[test]
+    fn test_engine_disables_configured_modules() {
+        let dir = TempDir::new().unwrap();
+        write_module(&dir, "app", &["base"]);
+        write_module(&dir, "base", &[]);
+        fs::write(
+            dir.path().join("dhd.config.ts"),
+            "export default defineConfig({ disabled: [\"base\"] });\n",
+        )
+        .unwrap();
+
+        let engine = Engine::new(vec![dir.path().to_path_buf()]);
+        let modules = engine.modules().unwrap();
+        assert_eq!(names(&modules), vec!["base", "app"]);
+        assert!(!modules[0].definition.enabled);
+        assert!(modules[1].definition.enabled);
+
+        let plan = engine.plan().unwrap();
+        assert_eq!(plan.modules[0].skipped.as_deref(), Some("disabled"));
+    }
+
+    #[test]
+    fn test_engine_plan_and_apply_report() {
+        let dir = TempDir::new().unwrap();
+        write_module(&dir, "base", &[]);
+
+        let engine = Engine::new(vec![dir.path().to_path_buf()]).with_dry_run(true);
+        let plan = engine.plan().unwrap();
+        assert_eq!(plan.modules.len(), 1);
+        assert_eq!(plan.pending_count(), 0);
+
+        let report = engine.apply().unwrap();
+        assert!(report.dry_run);
+        assert_eq!(report.modules.len(), 1);
+        assert_eq!(report.summary.failed, 0);
+    }
+}
@@ -1,23 +1,408 @@
 use crate::{
-    actions::{Action, ActionType},
-    dag_executor::{DagExecutor, ExecutionSummary},
+    actions::{Action, ActionType, RebootAction},
+    atom::{AtomStatus, Destruction},
+    dag_executor::ExecutionSummary,
+    diff::FileChange,
     error::{DhdError, Result},
     loader::LoadedModule,
+    module::Hook,
+    module_executor::{
+        ActionStatus, ModuleExecutor, ModuleHandler, ModuleHook, ModuleJob, ModuleResult,
+    },
     secrets::{onepassword::OnePasswordProvider, SecretProvider, SecretResolver},
 };
 use indicatif::{ProgressBar, ProgressStyle};
-use std::time::Instant;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::time::{Duration, Instant};
 use tokio::runtime::Runtime;
 
 thread_local! {
     pub static VERBOSE_MODE: std::cell::RefCell<bool> = std::cell::RefCell::new(false);
 }
 
+/// An atom a module would run and whether it would change anything
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct PlannedAtom {
+    pub description: String,
+    pub status: AtomStatus,
+    /// The file a pending atom would write, by hash before and after
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub file: Option<PlannedFile>,
+}
+
+/// A file change of a plan, by the sha256 of its content
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct PlannedFile {
+    pub path: std::path::PathBuf,
+    /// Hash of what is there now, `None` if the file doesn't exist
+    pub before: Option<String>,
+    /// Hash of what the atom would write
+    pub after: String,
+    /// Unified diff from the current content, for reviewing the plan
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub diff: Option<String>,
+}
+
+impl PlannedFile {
+    fn new(change: &FileChange) -> Self {
+        Self {
+            path: change.target.clone(),
+            before: change.current.as_deref().map(crate::state::sha256),
+            after: crate::state::sha256(&change.desired),
+            diff: change.render(),
+        }
+    }
+}
+
+/// The planned atoms of a module, or the reason the module would be skipped
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ModulePlan {
+    pub name: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub skipped: Option<String>,
+    pub atoms: Vec<PlannedAtom>,
+}
+
+impl ModulePlan {
+    /// Number of atoms that would change the system
+    pub fn pending_count(&self) -> usize {
+        self.atoms
+            .iter()
+            .filter(|atom| atom.status == AtomStatus::Pending)
+            .count()
+    }
+}
+
+/// The files a module would change, or the reason the module would be skipped
+#[derive(Debug, Clone)]
+pub struct ModuleDiff {
+    pub name: String,
+    pub skipped: Option<String>,
+    pub changes: Vec<FileChange>,
+}
+
+/// Version of the document written by `dhd apply --output json`
+///
+/// Bumped when a field is removed or changes meaning; adding fields doesn't bump it.
+pub const OUTPUT_SCHEMA_VERSION: u32 = 1;
+
+/// Machine-readable result of an apply
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ApplyReport {
+    pub schema_version: u32,
+    pub dry_run: bool,
+    pub modules: Vec<ModuleResult>,
+    pub summary: ApplySummary,
+    /// The module whose failure stopped the apply, unless it kept going
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub stopped_by: Option<String>,
+}
+
+/// Action counts by status across all modules
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ApplySummary {
+    pub total: usize,
+    pub applied: usize,
+    pub noop: usize,
+    pub skipped: usize,
+    pub failed: usize,
+    pub duration_ms: u64,
+    /// Whether any module changed something that requires a reboot
+    pub reboot_required: bool,
+}
+
+impl ApplyReport {
+    pub fn new(summary: &ExecutionSummary, dry_run: bool, duration: Duration) -> Self {
+        let count = |status| {
+            summary
+                .modules
+                .iter()
+                .flat_map(|module| &module.actions)
+                .filter(|action| action.status == status)
+                .count()
+        };
+
+        Self {
+            schema_version: OUTPUT_SCHEMA_VERSION,
+            dry_run,
+            modules: summary.modules.clone(),
+            summary: ApplySummary {
+                total: summary.total,
+                applied: count(ActionStatus::Applied),
+                noop: count(ActionStatus::Noop),
+                skipped: count(ActionStatus::Skipped),
+                failed: count(ActionStatus::Failed),
+                duration_ms: duration.as_millis() as u64,
+                reboot_required: !summary.reboot_required().is_empty(),
+            },
+            stopped_by: summary.stopped_by.clone(),
+        }
+    }
+}
+
+/// The module's hooks, set up to run in its directory
+fn module_hooks(module: &LoadedModule) -> (Option<ModuleHook>, Option<ModuleHook>) {
+    let dir = module
+        .source
+        .path
+        .parent()
+        .unwrap_or(std::path::Path::new("."))
+        .to_path_buf();
+    let hook = |hook: &Hook| ModuleHook {
+        run: hook.run.clone(),
+        shell: crate::shell::choose(hook.shell.as_deref()),
+        env: hook.env.clone().unwrap_or_default(),
+        dir: dir.clone(),
+        continue_on_error: hook.continue_on_error.unwrap_or(false),
+        only_if_changed: hook.only_if_changed.unwrap_or(false),
+    };
+
+    (
+        module.definition.pre_apply.as_ref().map(hook),
+        module.definition.post_apply.as_ref().map(hook),
+    )
+}
+
+/// Drop overwrites of files a previous apply wrote, which are DHD's to replace
+fn unmanaged(destructions: Vec<(String, Destruction)>) -> Vec<(String, Destruction)> {
+    let state = crate::state::State::load(&crate::state::state_dir()).unwrap_or_default();
+    destructions
+        .into_iter()
+        .filter(|(_, destruction)| match destruction {
+            Destruction::Overwrite(path) => !state.manages(path),
+            _ => true,
+        })
+        .collect()
+}
+
+/// Evaluate a module's `when` condition, returning why it would be skipped,
+/// or "disabled" for a disabled module
+fn skip_reason(module: &LoadedModule) -> Option<String> {
+    if !module.definition.enabled {
+        return Some("disabled".to_string());
+    }
+    let condition = module.definition.when.as_ref()?;
+    match condition.evaluate() {
+        Ok(true) => None,
+        Ok(false) => Some(format!("condition not met: {}", condition.describe())),
+        Err(e) => Some(format!("error evaluating condition: {}", e)),
+    }
+}
+
+/// The condition of `action` that runs a command, which an audit doesn't
+/// evaluate
+fn command_condition(action: &ActionType) -> Option<&crate::actions::Condition> {
+    match action {
+        ActionType::Conditional(action) => action
+            .conditions
+            .iter()
+            .find(|condition| condition.runs_command())
+            .or_else(|| command_condition(&action.action)),
+        ActionType::Notify(action) => command_condition(&action.action),
+        ActionType::Tagged(action) => command_condition(&action.action),
+        ActionType::Verified(action) => command_condition(&action.action),
+        ActionType::Ordered(action) => command_condition(&action.action),
+        ActionType::Reboot(action) => command_condition(&action.action),
+        _ => None,
+    }
+}
+
+/// An atom whose check already ran before the apply started
+///
+/// Execution takes the earlier result instead of checking again, so drifted
+/// atoms run without a re-check even if an earlier atom fixed them meanwhile.
+struct Prechecked {
+    inner: Box<dyn crate::atom::Atom>,
+    needed: bool,
+}
+
+impl crate::atom::Atom for Prechecked {
+    fn check(&self) -> anyhow::Result<bool> {
+        Ok(self.needed)
+    }
+
+    fn execute(&self) -> anyhow::Result<()> {
+        self.inner.execute()
+    }
+
+    fn execute_changed(&self) -> anyhow::Result<bool> {
+        self.inner.execute_changed()
+    }
+
+    fn verify(&self) -> anyhow::Result<()> {
+        self.inner.verify()
+    }
+
+    fn describe(&self) -> String {
+        self.inner.describe()
+    }
+
+    fn module(&self) -> &str {
+        self.inner.module()
+    }
+
+    fn as_any(&self) -> &dyn std::any::Any {
+        self.inner.as_any()
+    }
+
+    fn id(&self) -> String {
+        self.inner.id()
+    }
+
+    fn file_change(&self) -> Option<std::result::Result<FileChange, String>> {
+        self.inner.file_change()
+    }
+
+    fn destruction(&self) -> Option<Destruction> {
+        // Nothing is destroyed by an atom that won't run
+        self.needed.then(|| self.inner.destruction()).flatten()
+    }
+
+    fn managed_paths(&self) -> std::result::Result<Vec<std::path::PathBuf>, String> {
+        self.inner.managed_paths()
+    }
+
+    fn dependencies(&self) -> Vec<String> {
+        self.inner.dependencies()
+    }
+
+    fn notifies(&self) -> &[String] {
+        self.inner.notifies()
+    }
+
+    fn requires_reboot(&self) -> bool {
+        self.inner.requires_reboot()
+    }
+
+    fn package_batch(&self) -> Option<crate::atoms::install_packages::PackageBatch> {
+        self.needed.then(|| self.inner.package_batch()).flatten()
+    }
+}
+
+/// Check a module's atoms up front on `workers` threads, returning them with
+/// their results attached and whether each would change anything
+///
+/// Atoms without a check, or whose check fails, are returned as they were so
+/// execution checks (and reports) them as usual; they count as changes.
+fn precheck(
+    atoms: Vec<Box<dyn crate::atom::Atom>>,
+    workers: usize,
+) -> (Vec<Box<dyn crate::atom::Atom>>, Vec<bool>) {
+    let chunk_size = atoms.len().div_ceil(workers.max(1)).max(1);
+    let statuses: Vec<Option<AtomStatus>> = std::thread::scope(|scope| {
+        let handles: Vec<_> = atoms
+            .chunks(chunk_size)
+            .map(|chunk| {
+                let handle = scope.spawn(move || {
+                    chunk
+                        .iter()
+                        .map(|atom| atom.status().ok())
+                        .collect::<Vec<_>>()
+                });
+                (handle, chunk.len())
+            })
+            .collect();
+        handles
+            .into_iter()
+            .flat_map(|(handle, len)| handle.join().unwrap_or_else(|_| vec![None; len]))
+            .collect()
+    });
+
+    let changes = statuses
+        .iter()
+        .map(|status| !matches!(status, Some(AtomStatus::Satisfied)))
+        .collect();
+    let atoms = atoms
+        .into_iter()
+        .zip(statuses)
+        .map(|(atom, status)| match status {
+            Some(AtomStatus::Satisfied) => Box::new(Prechecked {
+                inner: atom,
+                needed: false,
+            }) as Box<dyn crate::atom::Atom>,
+            Some(AtomStatus::Pending) => Box::new(Prechecked {
+                inner: atom,
+                needed: true,
+            }),
+            Some(AtomStatus::Unchecked) | None => atom,
+        })
+        .collect();
+    (atoms, changes)
+}
+
+/// Keep a module's atoms up to the `budget` of changes left, taking the
+/// changes kept from it, and return the kept atoms with the descriptions of
+/// those left for the next run
+///
+/// `changes` says which atoms would change anything, as `precheck` returns it.
+fn limit(
+    atoms: Vec<Box<dyn crate::atom::Atom>>,
+    changes: &[bool],
+    budget: &mut usize,
+) -> (Vec<Box<dyn crate::atom::Atom>>, Vec<String>) {
+    let mut kept = Vec::new();
+    let mut left = Vec::new();
+    for (atom, &changes) in atoms.into_iter().zip(changes) {
+        if !changes {
+            kept.push(atom);
+        } else if *budget > 0 {
+            *budget -= 1;
+            kept.push(atom);
+        } else {
+            left.push(atom.describe());
+        }
+    }
+    (kept, left)
+}
+
+/// Keep the input hashes of the modules that applied without failures in the
+/// state file, for the next incremental apply
+fn save_inputs(summary: &ExecutionSummary, mut inputs: BTreeMap<String, Vec<String>>) {
+    let dir = crate::state::state_dir();
+    let mut state = match crate::state::State::load(&dir) {
+        Ok(state) => state,
+        Err(e) => {
+            log::warn!("Failed to record action inputs: {}", e);
+            return;
+        }
+    };
+
+    for module in &summary.modules {
+        if !matches!(module.status, ActionStatus::Applied | ActionStatus::Noop) {
+            continue;
+        }
+        if let Some(hashes) = inputs.remove(&module.module) {
+            state.inputs.insert(module.module.clone(), hashes);
+        }
+    }
+    if let Err(e) = state.save(&dir) {
+        log::warn!("Failed to record action inputs: {}", e);
+    }
+}
+
+/// Decides whether an apply may make the destructive changes it planned
+pub type ConfirmDestruction = Box<dyn Fn(&[(String, Destruction)]) -> bool>;
+
 pub struct ExecutionEngine {
     concurrency: usize,
     dry_run: bool,
     verbose: bool,
+    quiet: bool,
+    changes_only: bool,
+    timings: bool,
+    backups: bool,
+    only_changed: bool,
+    incremental: bool,
+    keep_going: bool,
+    diffs: bool,
+    limit: Option<usize>,
     secret_provider: Option<Box<dyn SecretProvider>>,
+    confirm: Option<ConfirmDestruction>,
 }
 
 impl ExecutionEngine {
@@ -38,7 +423,17 @@ impl ExecutionEngine {
             concurrency,
             dry_run,
             verbose,
+            quiet: false,
+            changes_only: false,
+            timings: false,
+            backups: true,
+            only_changed: false,
+            incremental: false,
+            keep_going: false,
+            diffs: false,
+            limit: None,
             secret_provider,
+            confirm: None,
         }
     }
 
@@ -47,73 +442,213 @@ impl ExecutionEngine {
         self
     }
 
+    /// Don't print anything while applying, e.g. when the caller reports results itself
+    pub fn with_quiet(mut self, quiet: bool) -> Self {
+        self.quiet = quiet;
+        self
+    }
+
+    /// Only print the actions that changed something or failed, and the
+    /// summary: no progress, up-to-date or skipped actions
+    pub fn with_changes_only(mut self, changes_only: bool) -> Self {
+        self.changes_only = changes_only;
+        self
+    }
+
+    /// Print per-module and per-action durations after applying; verbose mode always does
+    pub fn with_timings(mut self, timings: bool) -> Self {
+        self.timings = timings;
+        self
+    }
+
+    /// Back up replaced files next to themselves (on by default); rollback
+    /// keeps its own copy either way
+    pub fn with_backups(mut self, backups: bool) -> Self {
+        self.backups = backups;
+        self
+    }
+
+    /// Check every atom before applying anything, and skip the ones that are
+    /// already up to date without checking them again
+    pub fn with_only_changed(mut self, only_changed: bool) -> Self {
+        self.only_changed = only_changed;
+        self
+    }
+
+    /// Skip the actions whose inputs haven't changed since their module last
+    /// applied without failures, without checking them
+    pub fn with_incremental(mut self, incremental: bool) -> Self {
+        self.incremental = incremental;
+        self
+    }
+
+    /// Keep applying the modules that don't depend on a failed one, instead
+    /// of stopping everything at the first failure
+    pub fn with_keep_going(mut self, keep_going: bool) -> Self {
+        self.keep_going = keep_going;
+        self
+    }
+
+    /// Print the diff of each file an atom changes with the atom's result,
+    /// secrets masked
+    pub fn with_diffs(mut self, diffs: bool) -> Self {
+        self.diffs = diffs;
+        self
+    }
+
+    /// Apply at most `limit` of the changes, in dependency order, leaving the
+    /// rest for the next run; implies checking every atom first, as with
+    /// `with_only_changed`
+    pub fn with_limit(mut self, limit: Option<usize>) -> Self {
+        self.limit = limit;
+        self
+    }
+
+    /// Ask `confirm` before overwriting files DHD didn't write or removing
+    /// packages, aborting the apply unless it returns true
+    pub fn with_confirmation(mut self, confirm: ConfirmDestruction) -> Self {
+        self.confirm = Some(confirm);
+        self
+    }
+
+    /// Apply modules, failing if any atom failed
     pub fn execute(&self, modules: Vec<LoadedModule>) -> Result<()> {
+        let summary = self.apply(modules)?;
+
+        if !summary.failed.is_empty() {
+            return Err(DhdError::AtomExecution(format!(
+                "{} atoms failed",
+                summary.failed.len()
+            )));
+        }
+
+        Ok(())
+    }
+
+    /// Apply modules and report what happened to every action
+    ///
+    /// Failing atoms are part of the summary rather than an error.
+    pub fn apply(&self, modules: Vec<LoadedModule>) -> Result<ExecutionSummary> {
         let start = Instant::now();
+        let verbose = self.verbose && !self.quiet;
+        // Progress lines are left out even when the results aren't
+        let terse = self.quiet || self.changes_only;
 
-        println!("🚀 Starting execution of {} modules", modules.len());
+        if !terse {
+            println!("🚀 Starting execution of {} modules", modules.len());
+        }
 
         // Planning phase
-        let mut dag = DagExecutor::new(self.concurrency);
-        let mut total_atoms = 0;
+        let mut executor = ModuleExecutor::new(self.concurrency)
+            .with_quiet(self.quiet)
+            .with_changes_only(self.changes_only)
+            .with_keep_going(self.keep_going)
+            .with_diffs(self.diffs);
 
         // Set verbose mode for the planning phase
-        VERBOSE_MODE.with(|v| *v.borrow_mut() = self.verbose);
+        VERBOSE_MODE.with(|v| *v.borrow_mut() = verbose);
 
         // Create a runtime for async operations if we have a secret provider
-        let rt = if self.secret_provider.is_some() {
-            Some(tokio::runtime::Builder::new_current_thread()
-                .enable_all()
-                .build()
-                .map_err(|e| DhdError::ExecutionEngine(format!("Failed to create async runtime: {}", e)))?)
+        let rt = self.secret_runtime()?;
+        let mut short_circuited = 0;
+
+        // Inputs of the last applies, and of this one for the next
+        let previous = if self.incremental {
+            crate::state::State::load(&crate::state::state_dir())
+                .unwrap_or_default()
+                .inputs
         } else {
-            None
+            BTreeMap::new()
         };
+        let mut inputs = BTreeMap::new();
+        let mut unchanged = 0;
 
-        if self.verbose {
+        // Changes --limit still lets through, and those it leaves, by module
+        let mut budget = self.limit;
+        let mut left: Vec<(String, String)> = Vec::new();
+        let precheck_all = self.only_changed || self.limit.is_some();
+
+        let escalates = modules.iter().any(|module| {
+            let handlers = module.definition.handlers.iter();
+            module
+                .definition
+                .actions
+                .iter()
+                .chain(handlers.flat_map(|handler| &handler.actions))
+                .any(|action| action.escalates())
+        });
+
+        if verbose {
             println!("📋 Planning modules with verbose output...\n");
             
             for module in modules {
                 println!("● Planning module: {}", module.definition.name);
                 
                 // Check module-level condition if present
-                let should_execute = if let Some(condition) = &module.definition.when {
+                let skipped = if let Some(condition) = &module.definition.when {
                     match condition.evaluate() {
-                        Ok(result) => {
-                            if !result {
-                                println!("  ⏭️  Module skipped due to condition: {}", condition.describe());
-                            }
-                            result
+                        Ok(true) => None,
+                        Ok(false) => {
+                            println!("  ⏭️  Module skipped due to condition: {}", condition.describe());
+                            Some(format!("condition not met: {}", condition.describe()))
                         }
                         Err(e) => {
                             eprintln!("  ❌ Error evaluating module condition: {}", e);
-                            false
+                            Some(format!("error evaluating condition: {}", e))
                         }
                     }
                 } else {
-                    true
+                    None
                 };
 
-                if should_execute {
-                    let module_atoms_before = total_atoms;
-                    for action in module.definition.actions {
-                        let atoms = self.plan_action_with_secrets(&action, &module.source.path.parent().unwrap_or(std::path::Path::new(".")), &rt)?;
-                        for atom in atoms {
-                            total_atoms += 1;
-                            dag.add_atom(atom);
+                let mut atoms = Vec::new();
+                let mut handlers = Vec::new();
+                if skipped.is_none() {
+                    let (planned, hashes, left_out) =
+                        self.plan_actions(&module, previous.get(&module.definition.name), &rt)?;
+                    atoms = planned;
+                    unchanged += left_out;
+                    inputs.insert(module.definition.name.clone(), hashes);
+                    handlers = self.plan_handlers(&module, &rt)?;
+                    if precheck_all {
+                        let (checked, changes) = precheck(atoms, self.concurrency);
+                        short_circuited += changes.iter().filter(|changes| !**changes).count();
+                        atoms = checked;
+                        if let Some(budget) = budget.as_mut() {
+                            let (kept, deferred) = limit(atoms, &changes, budget);
+                            atoms = kept;
+                            let name = &module.definition.name;
+                            left.extend(deferred.into_iter().map(|atom| (name.clone(), atom)));
                         }
                     }
                     
-                    if total_atoms == module_atoms_before {
+                    if atoms.is_empty() {
                         println!("  ⚠️  Module produced no atoms (all actions were skipped)\n");
                     } else {
-                        println!("  ✓ Module produced {} atoms\n", total_atoms - module_atoms_before);
+                        println!("  ✓ Module produced {} atoms\n", atoms.len());
                     }
                 } else {
                     println!("  ⏭️  Module skipped\n");
                 }
+
+                let (pre_apply, post_apply) = module_hooks(&module);
+                executor.add_module(ModuleJob {
+                    name: module.definition.name,
+                    description: module.definition.description,
+                    dependencies: module.definition.dependencies,
+                    atoms,
+                    skipped,
+                    pre_apply,
+                    post_apply,
+                    handlers,
+                });
             }
         } else {
-            let pb = ProgressBar::new(modules.len() as u64);
+            let pb = if terse || crate::logging::is_verbose() {
+                ProgressBar::hidden()
+            } else {
+                ProgressBar::new(modules.len() as u64)
+            };
             pb.set_style(
                 ProgressStyle::default_bar()
                     .template("{spinner:.green} Planning modules... [{bar:40.cyan/blue}] {pos}/{len}")
@@ -124,71 +659,328 @@ impl ExecutionEngine {
                 pb.set_message(format!("Planning {}", module.definition.name));
 
                 // Check module-level condition if present
-                let should_execute = if let Some(condition) = &module.definition.when {
-                    match condition.evaluate() {
-                        Ok(result) => result,
-                        Err(_) => false,
+                let mut atoms = Vec::new();
+                let mut handlers = Vec::new();
+                let skipped = skip_reason(&module);
+                if let Some(reason) = &skipped {
+                    let line = crate::color::paint(
+                        ActionStatus::Skipped,
+                        &format!("⏭️  {} skipped ({})", module.definition.name, reason),
+                    );
+                    // A hidden bar drops printed lines
+                    if !pb.is_hidden() {
+                        pb.println(line);
+                    } else if !terse {
+                        println!("{}", line);
                     }
                 } else {
-                    true
-                };
-
-                if should_execute {
-                    for action in module.definition.actions {
-                        let atoms = self.plan_action_with_secrets(&action, &module.source.path.parent().unwrap_or(std::path::Path::new(".")), &rt)?;
-                        for atom in atoms {
-                            total_atoms += 1;
-                            dag.add_atom(atom);
+                    let (planned, hashes, left_out) =
+                        self.plan_actions(&module, previous.get(&module.definition.name), &rt)?;
+                    atoms = planned;
+                    unchanged += left_out;
+                    inputs.insert(module.definition.name.clone(), hashes);
+                    handlers = self.plan_handlers(&module, &rt)?;
+                    if precheck_all {
+                        let (checked, changes) = precheck(atoms, self.concurrency);
+                        short_circuited += changes.iter().filter(|changes| !**changes).count();
+                        atoms = checked;
+                        if let Some(budget) = budget.as_mut() {
+                            let (kept, deferred) = limit(atoms, &changes, budget);
+                            atoms = kept;
+                            let name = &module.definition.name;
+                            left.extend(deferred.into_iter().map(|atom| (name.clone(), atom)));
                         }
                     }
                 }
 
+                // Skipped modules are still scheduled so their dependents can run
+                let (pre_apply, post_apply) = module_hooks(&module);
+                executor.add_module(ModuleJob {
+                    name: module.definition.name,
+                    description: module.definition.description,
+                    dependencies: module.definition.dependencies,
+                    atoms,
+                    skipped,
+                    pre_apply,
+                    post_apply,
+                    handlers,
+                });
+
                 pb.inc(1);
             }
 
             pb.finish_with_message("Planning complete");
         }
 
-        // Build dependencies
-        println!("📊 Building dependency graph...");
-        dag.build_dependencies()?;
+        if let Some(confirm) = self.confirm.as_ref().filter(|_| !self.dry_run) {
+            let destructions = unmanaged(executor.destructions());
+            if !destructions.is_empty() && !confirm(&destructions) {
+                return Err(DhdError::ExecutionEngine(
+                    "Aborted before making destructive changes".to_string(),
+                ));
+            }
+        }
+
+        // Ask for sudo's password once, before the run's output starts
+        if escalates && !self.dry_run {
+            crate::privilege::ensure_root().map_err(DhdError::ExecutionEngine)?;
+        }
+
+        if self.incremental && !terse {
+            println!(
+                "⏩ {} actions unchanged since the last apply, skipped",
+                unchanged
+            );
+        }
+
+        if precheck_all && !terse {
+            println!(
+                "⏩ {} atoms already up to date, not checked again",
+                short_circuited
+            );
+        }
 
         // Execute
-        println!(
-            "⚡ Executing {} atoms with {} parallel workers",
-            total_atoms, self.concurrency
-        );
+        if !terse {
+            println!(
+                "⚡ Executing {} atoms with {} parallel workers",
+                executor.atom_count(),
+                executor.worker_count()
+            );
+        }
 
-        let summary = dag.execute(self.dry_run)?;
+        // Record what this apply changes so `dhd rollback` can undo it
+        let _recording = (!self.dry_run)
+            .then(|| crate::state::start_recording(crate::state::state_dir(), self.backups));
+        let summary = executor.execute(self.dry_run)?;
+        if !self.dry_run {
+            save_inputs(&summary, inputs);
+        }
 
         // Report results
-        let duration = start.elapsed();
-        self.print_summary(&summary, duration);
+        if !self.quiet {
+            self.print_summary(&summary, start.elapsed());
+            if let Some(limit) = self.limit.filter(|_| !left.is_empty()) {
+                println!(
+                    "\n⏸️  Stopped at --limit {}, {} change(s) left for the next run:",
+                    limit,
+                    left.len()
+                );
+                for (module, atom) in &left {
+                    println!("   - {}: {}", module, atom);
+                }
+            }
+            if self.timings || self.verbose {
+                print_timings(&summary, start.elapsed());
+            }
+        }
 
-        if !summary.failed.is_empty() {
-            return Err(DhdError::AtomExecution(format!(
-                "{} atoms failed",
-                summary.failed.len()
-            )));
+        Ok(summary)
+    }
+
+    /// Plan modules and report which atoms would change the system, without executing anything
+    pub fn plan(&self, modules: Vec<LoadedModule>) -> Result<Vec<ModulePlan>> {
+        VERBOSE_MODE.with(|v| *v.borrow_mut() = self.verbose);
+        let rt = self.secret_runtime()?;
+
+        let mut plans = Vec::new();
+        for module in modules {
+            let name = module.definition.name.clone();
+            let description = module.definition.description.clone();
+
+            let skipped = skip_reason(&module);
+            if skipped.is_some() {
+                plans.push(ModulePlan {
+                    name,
+                    description,
+                    skipped,
+                    atoms: Vec::new(),
+                });
+                continue;
+            }
+
+            let module_dir = module
+                .source
+                .path
+                .parent()
+                .unwrap_or(std::path::Path::new("."));
+            let mut atoms = Vec::new();
+            for action in &module.definition.actions {
+                for atom in self.plan_action_with_secrets(action, module_dir, &rt)? {
+                    let status = atom.status().map_err(|e| {
+                        DhdError::ExecutionEngine(format!(
+                            "Failed to check {}: {}",
+                            atom.describe(),
+                            e
+                        ))
+                    })?;
+                    // A file that can't be read yet, e.g. under a directory
+                    // an earlier atom creates, is left to the apply
+                    let file = match status {
+                        AtomStatus::Pending => atom
+                            .file_change()
+                            .and_then(|change| change.ok())
+                            .map(|change| PlannedFile::new(&change)),
+                        _ => None,
+                    };
+                    atoms.push(PlannedAtom {
+                        description: atom.describe(),
+                        status,
+                        file,
+                    });
+                }
+            }
+
+            plans.push(ModulePlan {
+                name,
+                description,
+                skipped: None,
+                atoms,
+            });
         }
 
-        Ok(())
+        Ok(plans)
     }
 
-    fn print_summary(&self, summary: &ExecutionSummary, duration: std::time::Duration) {
-        println!("\n📋 Execution Summary:");
-        println!("   Total atoms: {}", summary.total);
-        println!("   ✅ Completed: {}", summary.completed);
-        println!("   ⏭️  Skipped: {}", summary.skipped);
-        println!("   ❌ Failed: {}", summary.failed.len());
-        println!("   ⏱️  Duration: {:.2}s", duration.as_secs_f64());
+    /// Check the modules like `plan`, but only from what can be read without
+    /// side effects
+    ///
+    /// No command runs, no secret is looked up and no package index is
+    /// refreshed. Atoms that can't tell their state that way, and actions
+    /// whose condition runs a command, are `Unchecked`; so is a module whose
+    /// `when` runs a command, which is skipped.
+    pub fn audit(&self, modules: Vec<LoadedModule>) -> Vec<ModulePlan> {
+        VERBOSE_MODE.with(|v| *v.borrow_mut() = self.verbose);
 
-        if !summary.failed.is_empty() {
-            println!("\n❌ Failed atoms:");
-            for (id, error) in &summary.failed {
-                println!("   - {}: {}", id, error);
+        let mut plans = Vec::new();
+        for module in modules {
+            let name = module.definition.name.clone();
+            let description = module.definition.description.clone();
+
+            let skipped = match &module.definition.when {
+                Some(condition) if module.definition.enabled && condition.runs_command() => {
+                    Some(format!(
+                        "not audited, its condition runs a command: {}",
+                        condition.describe()
+                    ))
+                }
+                _ => skip_reason(&module),
+            };
+            if skipped.is_some() {
+                plans.push(ModulePlan {
+                    name,
+                    description,
+                    skipped,
+                    atoms: Vec::new(),
+                });
+                continue;
+            }
+
+            let module_dir = module
+                .source
+                .path
+                .parent()
+                .unwrap_or(std::path::Path::new("."));
+            let mut atoms = Vec::new();
+            for action in &module.definition.actions {
+                if let Some(condition) = command_condition(action) {
+                    atoms.push(PlannedAtom {
+                        description: format!(
+                            "{} (its condition runs a command: {})",
+                            action.name(),
+                            condition.describe()
+                        ),
+                        status: AtomStatus::Unchecked,
+                        file: None,
+                    });
+                    continue;
+                }
+                for atom in action.plan(module_dir) {
+                    atoms.push(PlannedAtom {
+                        description: atom.describe(),
+                        status: atom.audit(),
+                        file: None,
+                    });
+                }
+            }
+
+            plans.push(ModulePlan {
+                name,
+                description,
+                skipped: None,
+                atoms,
+            });
+        }
+
+        plans
+    }
+
+    /// Collect the file content changes apply would make, without writing anything
+    pub fn diff(&self, modules: Vec<LoadedModule>) -> Result<Vec<ModuleDiff>> {
+        VERBOSE_MODE.with(|v| *v.borrow_mut() = self.verbose);
+        let rt = self.secret_runtime()?;
+
+        let mut diffs = Vec::new();
+        for module in modules {
+            let name = module.definition.name.clone();
+
+            let skipped = skip_reason(&module);
+            if skipped.is_some() {
+                diffs.push(ModuleDiff {
+                    name,
+                    skipped,
+                    changes: Vec::new(),
+                });
+                continue;
             }
+
+            let module_dir = module
+                .source
+                .path
+                .parent()
+                .unwrap_or(std::path::Path::new("."));
+            let mut changes = Vec::new();
+            for action in &module.definition.actions {
+                for atom in self.plan_action_with_secrets(action, module_dir, &rt)? {
+                    if let Some(change) = atom.file_change() {
+                        let change = change.map_err(|e| {
+                            DhdError::ExecutionEngine(format!(
+                                "Failed to diff {}: {}",
+                                atom.describe(),
+                                e
+                            ))
+                        })?;
+                        if change.is_changed() {
+                            changes.push(change);
+                        }
+                    }
+                }
+            }
+
+            diffs.push(ModuleDiff {
+                name,
+                skipped: None,
+                changes,
+            });
         }
+
+        Ok(diffs)
+    }
+
+    fn secret_runtime(&self) -> Result<Option<Runtime>> {
+        if self.secret_provider.is_none() {
+            return Ok(None);
+        }
+
+        tokio::runtime::Builder::new_current_thread()
+            .enable_all()
+            .build()
+            .map(Some)
+            .map_err(|e| DhdError::ExecutionEngine(format!("Failed to create async runtime: {}", e)))
+    }
+
+    fn print_summary(&self, summary: &ExecutionSummary, duration: std::time::Duration) {
+        print!("{}", summary_report(summary, duration, self.dry_run));
     }
 
     fn plan_action_with_secrets(
@@ -197,6 +989,25 @@ impl ExecutionEngine {
         module_dir: &std::path::Path,
         rt: &Option<Runtime>,
     ) -> Result<Vec<Box<dyn crate::atom::Atom>>> {
+        if let ActionType::Notify(notify) = action {
+            let atoms = self.plan_action_with_secrets(&notify.action, module_dir, rt)?;
+            return Ok(notify.wrap(atoms));
+        }
+        if let ActionType::Tagged(tagged) = action {
+            return self.plan_action_with_secrets(&tagged.action, module_dir, rt);
+        }
+        if let ActionType::Ordered(ordered) = action {
+            return self.plan_action_with_secrets(&ordered.action, module_dir, rt);
+        }
+        if let ActionType::Reboot(reboot) = action {
+            let atoms = self.plan_action_with_secrets(&reboot.action, module_dir, rt)?;
+            return Ok(RebootAction::wrap(atoms));
+        }
+        if let ActionType::Verified(verified) = action {
+            let atoms = self.plan_action_with_secrets(&verified.action, module_dir, rt)?;
+            return Ok(verified.wrap(atoms, module_dir));
+        }
+
         // Check if this is an ExecuteCommand with environment variables that need secret resolution
         if let ActionType::ExecuteCommand(cmd) = action {
             if let Some(env) = &cmd.environment {
@@ -228,4 +1039,237 @@ impl ExecutionEngine {
         // For all other actions or when no secrets need resolution
         Ok(action.plan(module_dir))
     }
+
+    /// Plan a module's actions, leaving out the ones whose input hash is in
+    /// `previous`, and return the atoms, the hashes of all actions that have
+    /// one, and the number left out
+    fn plan_actions(
+        &self,
+        module: &LoadedModule,
+        previous: Option<&Vec<String>>,
+        rt: &Option<Runtime>,
+    ) -> Result<(Vec<Box<dyn crate::atom::Atom>>, Vec<String>, usize)> {
+        let _span = crate::profile::span("plan", module.definition.name.as_str());
+        let module_dir = module
+            .source
+            .path
+            .parent()
+            .unwrap_or(std::path::Path::new("."));
+
+        let mut atoms = Vec::new();
+        let mut hashes = Vec::new();
+        let mut unchanged = 0;
+        for action in &module.definition.actions {
+            if let Some(hash) = crate::incremental::action_hash(action, module_dir) {
+                let seen = previous.is_some_and(|previous| previous.contains(&hash));
+                hashes.push(hash);
+                if seen {
+                    unchanged += 1;
+                    continue;
+                }
+            }
+            atoms.extend(self.plan_action_with_secrets(action, module_dir, rt)?);
+        }
+
+        Ok((atoms, hashes, unchanged))
+    }
+
+    /// Plan the actions of each of the module's handlers
+    fn plan_handlers(
+        &self,
+        module: &LoadedModule,
+        rt: &Option<Runtime>,
+    ) -> Result<Vec<ModuleHandler>> {
+        let module_dir = module
+            .source
+            .path
+            .parent()
+            .unwrap_or(std::path::Path::new("."));
+
+        let mut handlers = Vec::new();
+        for handler in &module.definition.handlers {
+            let mut atoms = Vec::new();
+            for action in &handler.actions {
+                atoms.extend(self.plan_action_with_secrets(action, module_dir, rt)?);
+            }
+            handlers.push(ModuleHandler {
+                name: handler.name.clone(),
+                atoms,
+            });
+        }
+
+        Ok(handlers)
+    }
+}
+
+/// The recap printed at the end of an apply, listing every failed action
+fn summary_report(summary: &ExecutionSummary, duration: Duration, dry_run: bool) -> String {
+    let count = |status| {
+        summary
+            .modules
+            .iter()
+            .map(|module| module.count(status))
+            .sum::<usize>()
+    };
+    let skipped_modules = summary
+        .modules
+        .iter()
+        .filter(|module| module.status == ActionStatus::Skipped)
+        .count();
+
+    // Only the counts that aren't zero are colored, so they stand out
+    let tally = |status, label: &str, n: usize| {
+        let line = format!("{}: {}", label, n);
+        if n == 0 {
+            line
+        } else {
+            crate::color::paint(status, &line)
+        }
+    };
+
+    let mut report = String::from("\n📋 Execution Summary:\n");
+    report.push_str(&format!(
+        "   Modules: {} ({} skipped)\n",
+        summary.modules.len(),
+        skipped_modules
+    ));
+    report.push_str(&format!("   Total atoms: {}\n", summary.total));
+    report.push_str(&format!(
+        "   {}\n",
+        tally(ActionStatus::Applied, "✅ Completed", summary.completed)
+    ));
+    report.push_str(&format!(
+        "   {}\n",
+        tally(
+            ActionStatus::Noop,
+            "💤 Up to date",
+            count(ActionStatus::Noop)
+        )
+    ));
+    report.push_str(&format!(
+        "   {}\n",
+        tally(
+            ActionStatus::Skipped,
+            "⏭️  Skipped",
+            count(ActionStatus::Skipped)
+        )
+    ));
+    report.push_str(&format!(
+        "   {}\n",
+        tally(ActionStatus::Failed, "❌ Failed", summary.failed.len())
+    ));
+    report.push_str(&format!(
+        "   ⏱️  Duration: {:.2}s\n",
+        duration.as_secs_f64()
+    ));
+    let reboot = summary.reboot_required();
+    if !reboot.is_empty() {
+        let required = if dry_run {
+            "would be required"
+        } else {
+            "required"
+        };
+        report.push_str(&format!(
+            "   🔁 Reboot {} by: {}\n",
+            required,
+            reboot.join(", ")
+        ));
+    }
+    report.push_str(&crate::deprecation::summary());
+    report.push_str(&crate::atoms::network::summary());
+
+    if summary.failed.is_empty() {
+        return report;
+    }
+
+    report.push_str(&format!(
+        "\n{}\n",
+        crate::color::paint(ActionStatus::Failed, "❌ Failed actions:")
+    ));
+    let failed: Vec<_> = summary
+        .modules
+        .iter()
+        .flat_map(|module| &module.actions)
+        .filter(|action| action.status == ActionStatus::Failed)
+        .map(|action| {
+            let error = action.error.as_deref().unwrap_or_default();
+            (format!("{} › {}", action.module, action.action), error)
+        })
+        .collect();
+    let failed = if failed.is_empty() {
+        summary
+            .failed
+            .iter()
+            .map(|(id, error)| (id.clone(), error.as_str()))
+            .collect()
+    } else {
+        failed
+    };
+    for (action, error) in failed {
+        report.push_str(&format!(
+            "   - {}\n",
+            crate::color::paint(ActionStatus::Failed, &action)
+        ));
+        for line in error.trim().lines() {
+            report.push_str(&format!("       {}\n", line));
+        }
+    }
+
+    if let Some(module) = &summary.stopped_by {
+        report.push_str(&format!("\n⛔ Stopped after {} failed\n", module));
+        report.push_str("   What already ran is kept; `dhd rollback` undoes it.\n");
+        report.push_str("   Pass --keep-going to run the modules that don't depend on it.\n");
+    }
+    report
+}
+
+/// Print modules and their actions sorted by how long they took, slowest first
+fn print_timings(summary: &ExecutionSummary, wall_clock: Duration) {
+    let seconds = |ms: u64| ms as f64 / 1000.0;
+
+    let mut modules: Vec<&ModuleResult> = summary
+        .modules
+        .iter()
+        .filter(|module| module.status != ActionStatus::Skipped)
+        .collect();
+    modules.sort_by(|a, b| b.duration_ms.cmp(&a.duration_ms));
+
+    println!("\n⏱️  Timings (slowest first):");
+    for module in modules {
+        println!(
+            "   {:>8.2}s  {}",
+            seconds(module.duration_ms),
+            module.module
+        );
+
+        let mut actions: Vec<_> = module
+            .actions
+            .iter()
+            .filter(|action| action.status != ActionStatus::Skipped)
+            .collect();
+        actions.sort_by(|a, b| b.duration_ms.cmp(&a.duration_ms));
+        for action in actions {
+            println!(
+                "     {:>8.2}s  {}",
+                seconds(action.duration_ms),
+                action.action
+            );
+        }
+    }
+
+    let count = |status| {
+        summary
+            .modules
+            .iter()
+            .flat_map(|module| &module.actions)
+            .filter(|action| action.status == status)
+            .count()
+    };
+    println!(
+        "   {} applied, {} up to date, {} skipped in {:.2}s wall clock",
+        count(ActionStatus::Applied),
+        count(ActionStatus::Noop),
+        count(ActionStatus::Skipped),
+        wall_clock.as_secs_f64()
+    );
 }
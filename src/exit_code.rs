@@ -0,0 +1,63 @@
+//! Exit codes of the `dhd` commands
+//!
+//! `apply`, `plan`, `status`, `check` and `verify` share them, so scripts can
+//! tell "nothing to do" from "changed" from "failed". Their meaning won't
+//! change; new codes may be added for cases none of these cover.
+
+/// The command succeeded, and nothing changed or would change
+pub const SUCCESS: i32 = 0;
+
+/// Something failed while running, like an action, a hook or the
+/// confirmation prompt, before anything was changed
+pub const FAILURE: i32 = 1;
+
+/// The command succeeded, and changes are pending (`plan`, `status`), were
+/// made outside DHD (`verify`) or were made (`apply --changed-exit-code 2`)
+pub const CHANGES: i32 = 2;
+
+/// The modules, `dhd.config.ts` or the command line are invalid, so nothing
+/// ran; `check` exits with it for invalid modules
+pub const CONFIG_ERROR: i32 = 3;
+
+/// Some actions failed after others had already changed the system
+pub const PARTIAL_FAILURE: i32 = 4;
+
+/// The command succeeded, but went on without modules named on the command
+/// line that don't exist, as `--ignore-missing` allows; any other code but
+/// success wins over it
+pub const MISSING_MODULES: i32 = 5;
+
+/// The apply succeeded and changed something an action marked as requiring a
+/// reboot, e.g. a kernel; it wins over `--changed-exit-code`
+pub const REBOOT_REQUIRED: i32 = 6;
+
+/// The system or the modules changed since the plan `apply --plan-file` was
+/// given, so nothing ran
+pub const PLAN_DRIFTED: i32 = 7;
+
+/// Stopped with Ctrl-C
+pub const INTERRUPTED: i32 = 130;
+
+/// The exit code of an apply that ran `changed` actions, `failed` of which failed
+pub fn of_apply(changed: usize, failed: usize, changed_exit_code: i32) -> i32 {
+    match (changed, failed) {
+        (0, 0) => SUCCESS,
+        (_, 0) => changed_exit_code,
+        (0, _) => FAILURE,
+        _ => PARTIAL_FAILURE,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_of_apply() {
+        assert_eq!(of_apply(0, 0, CHANGES), SUCCESS);
+        assert_eq!(of_apply(3, 0, SUCCESS), SUCCESS);
+        assert_eq!(of_apply(3, 0, CHANGES), CHANGES);
+        assert_eq!(of_apply(0, 1, CHANGES), FAILURE);
+        assert_eq!(of_apply(3, 1, SUCCESS), PARTIAL_FAILURE);
+    }
+}
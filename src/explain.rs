@@ -0,0 +1,272 @@
+//! `dhd explain`: one module as dhd resolved it
+//!
+//! Shows every action after variables, host profiles and package groups were
+//! applied, the files it reads from the module directory, its input hash for
+//! `--incremental` and the atoms it plans, without checking or changing
+//! anything on the system.
+
+use crate::actions::{Action, ActionType};
+use crate::loader::LoadedModule;
+use crate::module::Hook;
+use std::path::{Path, PathBuf};
+
+/// An action of a module with what dhd derived from it
+#[derive(Debug, Clone)]
+pub struct ExplainedAction {
+    pub action: ActionType,
+    /// Files of the module directory the action reads, as given and resolved
+    pub sources: Vec<(String, PathBuf)>,
+    /// The input hash `--incremental` compares, if the action has one
+    pub hash: Option<String>,
+    /// The ids of the atoms the action plans
+    pub atoms: Vec<String>,
+}
+
+/// Resolve each action of `module`, and of its handlers after them
+pub fn explain_actions(module: &LoadedModule) -> Vec<ExplainedAction> {
+    let module_dir = module.source.path.parent().unwrap_or(Path::new("."));
+    module
+        .definition
+        .actions
+        .iter()
+        .chain(
+            module
+                .definition
+                .handlers
+                .iter()
+                .flat_map(|handler| &handler.actions),
+        )
+        .map(|action| ExplainedAction {
+            action: action.clone(),
+            sources: sources(action, module_dir),
+            hash: crate::incremental::action_hash(action, module_dir),
+            atoms: action
+                .plan(module_dir)
+                .iter()
+                .map(|atom| atom.id())
+                .collect(),
+        })
+        .collect()
+}
+
+/// The paths `action` reads from the module directory, resolved the way its
+/// plan resolves them
+fn sources(action: &ActionType, module_dir: &Path) -> Vec<(String, PathBuf)> {
+    let given = |path: &String| (path.clone(), crate::paths::resolve(module_dir, path));
+    match action {
+        ActionType::CopyFile(copy) => copy.source.iter().map(given).collect(),
+        ActionType::Symlink(symlink) => vec![given(&symlink.source)],
+        ActionType::Template(template) => template.source.iter().map(given).collect(),
+        ActionType::TemplateDir(template) => vec![given(&template.source)],
+        ActionType::DecryptFile(decrypt) => vec![given(&decrypt.source)],
+        ActionType::DconfImport(import) => vec![given(&import.source)],
+        // The module's file is the target, linked to from the XDG location
+        ActionType::LinkFile(link) => vec![given(&link.target)],
+        ActionType::LinkDirectory(link) => vec![given(&link.target)],
+        ActionType::Stow(stow) => vec![given(&stow.source)],
+        ActionType::GpgKey(key) => key.key_file.iter().map(given).collect(),
+        ActionType::PackageRepo(repo) => repo
+            .key
+            .iter()
+            .filter(|key| !key.contains("://"))
+            .map(given)
+            .collect(),
+        ActionType::Notify(notify) => sources(&notify.action, module_dir),
+        ActionType::Tagged(tagged) => sources(&tagged.action, module_dir),
+        ActionType::Verified(verified) => sources(&verified.action, module_dir),
+        ActionType::Ordered(ordered) => sources(&ordered.action, module_dir),
+        ActionType::Reboot(reboot) => sources(&reboot.action, module_dir),
+        ActionType::Conditional(conditional) => sources(&conditional.action, module_dir),
+        ActionType::Plugin(plugin) => plugin
+            .command
+            .iter()
+            .filter(|command| command.contains('/'))
+            .map(given)
+            .collect(),
+        _ => Vec::new(),
+    }
+}
+
+/// `module` as text, with `order` being the modules an apply of it runs, in
+/// order, its dependencies included, and secrets masked
+pub fn render(module: &LoadedModule, order: &[String], cwd: &Path) -> String {
+    let definition = &module.definition;
+    let path = module
+        .source
+        .relative_path(cwd)
+        .unwrap_or_else(|| module.source.path.clone());
+
+    let mut out = String::new();
+    out.push_str(&format!("● {} ({})\n", definition.name, path.display()));
+    if let Some(description) = &definition.description {
+        out.push_str(&format!("  Description: {}\n", description));
+    }
+    if !definition.tags.is_empty() {
+        out.push_str(&format!("  Tags: {}\n", definition.tags.join(", ")));
+    }
+    if !definition.dependencies.is_empty() {
+        out.push_str(&format!(
+            "  Depends on: {}\n",
+            definition.dependencies.join(", ")
+        ));
+    }
+    out.push_str(&format!("  Apply order: {}\n", order.join(" → ")));
+    if let Some(condition) = &definition.when {
+        out.push_str(&format!("  When: {}\n", condition.describe()));
+    }
+
+    let mut variables: Vec<_> = definition.variables.iter().collect();
+    variables.sort();
+    if !variables.is_empty() {
+        out.push_str("  Variables:\n");
+        for (name, value) in variables {
+            out.push_str(&format!("    {} = {}\n", name, value));
+        }
+    }
+    let hook = |hook: &Hook| {
+        let shell = crate::shell::choose(hook.shell.as_deref());
+        let mut flags = Vec::new();
+        if hook.continue_on_error == Some(true) {
+            flags.push(", continue on error");
+        }
+        if hook.only_if_changed == Some(true) {
+            flags.push(", only if changed");
+        }
+        format!("{} ({}{})", hook.run, shell, flags.concat())
+    };
+    if let Some(pre_apply) = &definition.pre_apply {
+        out.push_str(&format!("  Pre-apply: {}\n", hook(pre_apply)));
+    }
+    if let Some(post_apply) = &definition.post_apply {
+        out.push_str(&format!("  Post-apply: {}\n", hook(post_apply)));
+    }
+
+    let explained = explain_actions(module);
+    let (actions, handlers) = explained.split_at(definition.actions.len());
+    out.push_str("  Actions:\n");
+    if actions.is_empty() {
+        out.push_str("    (none)\n");
+    }
+    for (i, action) in actions.iter().enumerate() {
+        render_action(&mut out, &format!("{}.", i + 1), action);
+    }
+
+    let mut handlers = handlers.iter();
+    for handler in &definition.handlers {
+        out.push_str(&format!("  Handler '{}':\n", handler.name));
+        for (i, action) in handlers.by_ref().take(handler.actions.len()).enumerate() {
+            render_action(&mut out, &format!("{}.", i + 1), action);
+        }
+    }
+    // Template variables show up in the actions, sensitive ones included
+    crate::secrets::mask(&out)
+}
+
+fn render_action(out: &mut String, number: &str, explained: &ExplainedAction) {
+    out.push_str(&format!(
+        "    {} {}\n",
+        number,
+        explained.action.type_name()
+    ));
+    let indent = " ".repeat(7);
+    for line in format!("{:#?}", explained.action).lines() {
+        out.push_str(&format!("{}{}\n", indent, line));
+    }
+    for (given, resolved) in &explained.sources {
+        let state = if resolved.exists() { "" } else { " (missing)" };
+        out.push_str(&format!(
+            "{}source: {} → {}{}\n",
+            indent,
+            given,
+            resolved.display(),
+            state
+        ));
+    }
+    match &explained.hash {
+        Some(hash) => out.push_str(&format!("{}input hash: {}\n", indent, hash)),
+        None => out.push_str(&format!("{}input hash: none, runs every time\n", indent)),
+    }
+    for atom in &explained.atoms {
+        out.push_str(&format!("{}atom: {}\n", indent, atom));
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::{CopyFile, ShellCommand};
+    use crate::discovery::DiscoveredModule;
+    use crate::module::ModuleDefinition;
+    use std::collections::HashMap;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_explain_resolves_sources_and_hashes() {
+        let temp_dir = TempDir::new().unwrap();
+        std::fs::write(temp_dir.path().join("zshrc"), "export EDITOR=vim\n").unwrap();
+        let module = LoadedModule {
+            source: DiscoveredModule {
+                path: temp_dir.path().join("zsh.ts"),
+                name: "zsh".to_string(),
+                namespace: None,
+                variables: HashMap::new(),
+                package_groups: HashMap::new(),
+            },
+            definition: ModuleDefinition {
+                name: "zsh".to_string(),
+                actions: vec![
+                    ActionType::CopyFile(CopyFile {
+                        source: Some("zshrc".to_string()),
+                        content: None,
+                        target: "/tmp/dhd-explain-zshrc".to_string(),
+                        escalate: false,
+                        mode: None,
+                        owner: None,
+                        group: None,
+                        create_parents: None,
+                        ensure: None,
+                    }),
+                    ActionType::ShellCommand(ShellCommand {
+                        run: "chsh -s /bin/zsh".to_string(),
+                        shell: None,
+                        env: None,
+                        only_if: None,
+                        unless: None,
+                        cwd: None,
+                        changed_when: None,
+                        failed_when: None,
+                    }),
+                ],
+                description: None,
+                tags: vec![],
+                dependencies: vec![],
+                when: None,
+                enabled: true,
+                pre_apply: None,
+                post_apply: None,
+                handlers: Vec::new(),
+                variables: HashMap::new(),
+                variable_schema: HashMap::new(),
+            },
+        };
+
+        let explained = explain_actions(&module);
+        assert_eq!(
+            explained[0].sources,
+            vec![("zshrc".to_string(), temp_dir.path().join("zshrc"))]
+        );
+        assert!(explained[0].hash.is_some());
+        assert_eq!(explained[0].atoms.len(), 1);
+        assert!(explained[1].sources.is_empty());
+        assert_eq!(explained[1].hash, None);
+
+        let text = render(&module, &["zsh".to_string()], temp_dir.path());
+        assert!(text.starts_with("● zsh (zsh.ts)\n"), "{}", text);
+        assert!(text.contains("    1. copyFile\n"), "{}", text);
+        assert!(
+            text.contains("input hash: none, runs every time"),
+            "{}",
+            text
+        );
+    }
+}
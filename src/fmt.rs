@@ -0,0 +1,288 @@
+//! Rewrite YAML and TOML modules in one canonical style, for `dhd fmt`
+//!
+//! A module's keys come in a fixed order (name, description, tags,
+//! dependencies, variables, hooks, then actions), each action starts with
+//! its `type` and each hook with its `run`, and other keys are sorted. The
+//! indentation and quoting are those of the YAML and TOML writers, so
+//! formatting a formatted file changes nothing. Comment lines at the top of a
+//! file, like the YAML language server's `$schema` line, are kept as they are.
+//! TypeScript modules are left alone, and so are files with comments further
+//! down, which the rewrite would drop.
+
+use crate::declarative::{Format, module_file, parse_value};
+use serde::{Serialize, Serializer};
+use serde_json::{Map, Value};
+use std::fs;
+use std::path::Path;
+
+/// Module keys in the order they're written, before any others
+const MODULE_KEYS: &[&str] = &[
+    "name",
+    "description",
+    "requiresDhd",
+    "enabled",
+    "tags",
+    "dependsOn",
+    "dependencies",
+    "variables",
+    "variableSchema",
+    "preApply",
+    "postApply",
+    "actions",
+];
+
+/// What formatting a module file found
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Outcome {
+    /// The file was formatted already
+    Unchanged,
+    /// The file wasn't formatted; it is unless only checking
+    Changed,
+    /// The file has comments below its first lines, so it was left as it is
+    HasComments,
+}
+
+/// A module's values with their keys in the order they're written
+enum Node {
+    Scalar(Value),
+    List(Vec<Node>),
+    Map(Vec<(String, Node)>),
+}
+
+impl Serialize for Node {
+    fn serialize<S: Serializer>(&self, serializer: S) -> Result<S::Ok, S::Error> {
+        match self {
+            Node::Scalar(value) => value.serialize(serializer),
+            Node::List(items) => serializer.collect_seq(items),
+            Node::Map(entries) => serializer.collect_map(entries.iter().map(|(k, v)| (k, v))),
+        }
+    }
+}
+
+/// `map` with the keys of `first` in that order, then the others sorted,
+/// converting each value with `child`
+fn ordered(map: &Map<String, Value>, first: &[&str], child: impl Fn(&str, &Value) -> Node) -> Node {
+    let mut keys: Vec<&String> = map.keys().collect();
+    keys.sort_by_key(|&key| {
+        let rank = first.iter().position(|first| first == key);
+        (rank.unwrap_or(first.len()), key.as_str())
+    });
+    Node::Map(
+        keys.into_iter()
+            .map(|key| (key.clone(), child(key, &map[key])))
+            .collect(),
+    )
+}
+
+fn node(value: &Value) -> Node {
+    match value {
+        Value::Array(items) => Node::List(items.iter().map(node).collect()),
+        Value::Object(map) => ordered(map, &[], |_, value| node(value)),
+        scalar => Node::Scalar(scalar.clone()),
+    }
+}
+
+fn module_node(module: &Map<String, Value>) -> Node {
+    ordered(module, MODULE_KEYS, |key, value| match (key, value) {
+        ("actions", Value::Array(actions)) => Node::List(
+            actions
+                .iter()
+                .map(|action| match action {
+                    Value::Object(action) => ordered(action, &["type"], |_, value| node(value)),
+                    other => node(other),
+                })
+                .collect(),
+        ),
+        ("preApply" | "postApply", Value::Object(hook)) => {
+            ordered(hook, &["run"], |_, value| node(value))
+        }
+        _ => node(value),
+    })
+}
+
+/// Whether `content` has a `#` comment, as far as telling it from a `#`
+/// inside a quoted value goes; a `#` in a block or multi-line string counts too
+fn has_comments(content: &str) -> bool {
+    content.lines().any(|line| {
+        let mut quote = None;
+        let mut previous = ' ';
+        for c in line.chars() {
+            match quote {
+                Some(open) if c == open => quote = None,
+                Some(_) => {}
+                None if (c == '"' || c == '\'')
+                    && (previous.is_whitespace() || "[{,:=".contains(previous)) =>
+                {
+                    quote = Some(c)
+                }
+                None if c == '#' && previous.is_whitespace() => return true,
+                None => {}
+            }
+            previous = c;
+        }
+        false
+    })
+}
+
+/// `content` in the canonical style of `format`
+///
+/// Fails if the file isn't a module's mapping of keys, or if the formatted
+/// file wouldn't hold the same values.
+pub fn format(content: &str, format: Format) -> Result<String, String> {
+    let value = parse_value(content, format).map_err(|e| e.to_string())?;
+    let Value::Object(module) = &value else {
+        return Err("Expected the module's properties, like name and actions".to_string());
+    };
+
+    let node = module_node(module);
+    let formatted = match format {
+        Format::Yaml => serde_yaml::to_string(&node).map_err(|e| e.to_string())?,
+        Format::Toml => toml::to_string(&node).map_err(|e| e.to_string())?,
+    };
+    if parse_value(&formatted, format).ok().as_ref() != Some(&value) {
+        return Err("Formatting would change the module's values".to_string());
+    }
+    Ok(formatted)
+}
+
+/// The comment and blank lines `content` starts with, and the rest
+fn split_header(content: &str) -> (&str, &str) {
+    let mut end = 0;
+    for line in content.split_inclusive('\n') {
+        let line_start = line.trim_start();
+        if !line_start.is_empty() && !line_start.starts_with('#') {
+            break;
+        }
+        end += line.len();
+    }
+    content.split_at(end)
+}
+
+/// Format the module at `path`, writing it back unless only `checking`
+pub fn format_file(path: &Path, checking: bool) -> Result<Outcome, String> {
+    let Some((_, file_format)) = module_file(path) else {
+        return Err(format!("{} isn't a YAML or TOML module", path.display()));
+    };
+    let content = fs::read_to_string(path)
+        .map_err(|e| format!("Failed to read {}: {}", path.display(), e))?;
+    let (header, body) = split_header(&content);
+    if has_comments(body) {
+        return Ok(Outcome::HasComments);
+    }
+
+    let formatted = format(body, file_format)
+        .map_err(|e| format!("Failed to format {}: {}", path.display(), e))?;
+    let formatted = format!("{}{}", header, formatted);
+    if formatted == content {
+        return Ok(Outcome::Unchanged);
+    }
+    if !checking {
+        fs::write(path, &formatted)
+            .map_err(|e| format!("Failed to write {}: {}", path.display(), e))?;
+    }
+    Ok(Outcome::Changed)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_yaml_keys_are_ordered() {
+        let content = r#"
+actions:
+    -   target: ~/.zshrc
+        type: symlink
+        source: zshrc
+    -   names: [zsh, "fzf"]
+        type: packageInstall
+tags: [shell]
+postApply: { shell: bash, run: "exec zsh" }
+name: zsh
+"#;
+        let formatted = format(content, Format::Yaml).unwrap();
+        assert_eq!(
+            formatted,
+            "name: zsh
+tags:
+- shell
+postApply:
+  run: exec zsh
+  shell: bash
+actions:
+- type: symlink
+  source: zshrc
+  target: ~/.zshrc
+- type: packageInstall
+  names:
+  - zsh
+  - fzf
+"
+        );
+        assert_eq!(format(&formatted, Format::Yaml).unwrap(), formatted);
+    }
+
+    #[test]
+    fn test_toml_is_formatted_idempotently() {
+        let content = r#"
+tags = [ "git" ]
+name = "git"
+
+[[actions]]
+target = "~/.gitconfig"
+type = "copyFile"
+source = "gitconfig"
+"#;
+        let formatted = format(content, Format::Toml).unwrap();
+        assert!(formatted.starts_with("name = \"git\"\n"), "{}", formatted);
+        assert!(
+            formatted.contains("[[actions]]\ntype = \"copyFile\"\nsource = \"gitconfig\"\n"),
+            "{}",
+            formatted
+        );
+        assert_eq!(format(&formatted, Format::Toml).unwrap(), formatted);
+    }
+
+    #[test]
+    fn test_comments_are_told_from_quoted_hashes() {
+        assert!(has_comments("# Shell setup\nname: zsh\n"));
+        assert!(has_comments("name: zsh # the shell\n"));
+        assert!(!has_comments("description: \"not # a comment\"\n"));
+        assert!(!has_comments("description: it's done\nrun: 'a #b'\n"));
+        assert!(!has_comments("color = \"#ff0000\"\n"));
+    }
+
+    #[test]
+    fn test_format_file_leaves_commented_and_formatted_files() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("zsh.dhd.yaml");
+
+        fs::write(&path, "tags: [shell]\nname: zsh\n").unwrap();
+        assert_eq!(format_file(&path, true).unwrap(), Outcome::Changed);
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            "tags: [shell]\nname: zsh\n"
+        );
+        assert_eq!(format_file(&path, false).unwrap(), Outcome::Changed);
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            "name: zsh\ntags:\n- shell\n"
+        );
+        assert_eq!(format_file(&path, false).unwrap(), Outcome::Unchanged);
+
+        let schema = "# yaml-language-server: $schema=./dhd.schema.json\n";
+        fs::write(&path, format!("{}tags: [shell]\nname: zsh\n", schema)).unwrap();
+        assert_eq!(format_file(&path, false).unwrap(), Outcome::Changed);
+        assert_eq!(
+            fs::read_to_string(&path).unwrap(),
+            format!("{}name: zsh\ntags:\n- shell\n", schema)
+        );
+
+        fs::write(&path, "name: zsh\n# The shell\ntags: [shell]\n").unwrap();
+        assert_eq!(format_file(&path, false).unwrap(), Outcome::HasComments);
+
+        fs::write(&path, "- not a module\n").unwrap();
+        assert!(format_file(&path, false).is_err());
+    }
+}
@@ -0,0 +1,101 @@
+//! Files changed since a git commit, for `dhd apply --since-commit`
+//!
+//! Every module root is diffed against the commit in its own repository.
+//! Files the working tree changed since the commit count, committed or not,
+//! and so do untracked files git doesn't ignore.
+
+use crate::logging::LoggedCommand;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+
+/// Files under `roots` that differ from `commit`, sorted by path
+///
+/// Fails when a root isn't in a git repository or the repository has no
+/// `commit`, so that the caller can fall back to every module.
+pub fn changed_files(roots: &[PathBuf], commit: &str) -> Result<Vec<PathBuf>, String> {
+    let mut changed = Vec::new();
+    for root in roots {
+        // --relative limits the diff to the root and names files from there
+        let diff = ["diff", "--name-only", "--no-renames", "--relative", commit];
+        let diffed = git(root, &diff)?;
+        let untracked = git(root, &["ls-files", "--others", "--exclude-standard"])?;
+        changed.extend(
+            diffed
+                .lines()
+                .chain(untracked.lines())
+                .filter(|path| !path.is_empty())
+                .map(|path| root.join(path)),
+        );
+    }
+    changed.sort();
+    changed.dedup();
+    Ok(changed)
+}
+
+/// The output of `git args` run in `dir`
+fn git(dir: &Path, args: &[&str]) -> Result<String, String> {
+    let output = Command::new("git")
+        .arg("-C")
+        .arg(dir)
+        .args(args)
+        .logged_output()
+        .map_err(|e| format!("Failed to run git: {}", e))?;
+    if !output.status.success() {
+        return Err(format!(
+            "git {} failed in {}: {}",
+            args.join(" "),
+            dir.display(),
+            String::from_utf8_lossy(&output.stderr).trim()
+        ));
+    }
+    Ok(String::from_utf8_lossy(&output.stdout).into_owned())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+    use tempfile::TempDir;
+
+    fn run(dir: &Path, args: &[&str]) {
+        let status = Command::new("git")
+            .arg("-C")
+            .arg(dir)
+            .args(["-c", "user.name=DHD", "-c", "user.email=dhd@example.com"])
+            .args(args)
+            .output()
+            .unwrap()
+            .status;
+        assert!(status.success(), "git {:?} failed", args);
+    }
+
+    #[test]
+    fn test_changed_files_since_a_commit() {
+        let repo = TempDir::new().unwrap();
+        let root = repo.path().join("modules");
+        fs::create_dir_all(root.join("zsh")).unwrap();
+        fs::write(root.join("zsh/zsh.ts"), "one").unwrap();
+        fs::write(root.join("git.ts"), "one").unwrap();
+        fs::write(repo.path().join("README.md"), "one").unwrap();
+        run(repo.path(), &["init", "-q"]);
+        run(repo.path(), &["add", "-A"]);
+        run(repo.path(), &["commit", "-q", "-m", "Initial"]);
+
+        assert_eq!(
+            changed_files(&[root.clone()], "HEAD"),
+            Ok(Vec::<PathBuf>::new())
+        );
+
+        fs::write(root.join("zsh/zshrc"), "new").unwrap();
+        fs::write(root.join("git.ts"), "two").unwrap();
+        fs::write(repo.path().join("README.md"), "two").unwrap();
+        assert_eq!(
+            changed_files(&[root.clone()], "HEAD"),
+            Ok(vec![root.join("git.ts"), root.join("zsh/zshrc")])
+        );
+
+        assert!(changed_files(&[root.clone()], "no-such-ref").is_err());
+        let elsewhere = TempDir::new().unwrap();
+        assert!(changed_files(&[elsewhere.path().to_path_buf()], "HEAD").is_err());
+    }
+}
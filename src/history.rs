@@ -0,0 +1,217 @@
+//! History of applies, shown by `dhd history`
+//!
+//! Every apply that isn't a dry run adds an entry to `history.json` in the
+//! state directory when it finishes, failed or not: when it finished, the
+//! DHD version, whether it succeeded and how many actions it changed. Only
+//! the latest entries are kept, 100 unless `historyLimit` of `dhd.config.ts`
+//! says otherwise.
+
+use crate::module_executor::ActionStatus;
+use crate::report::RunReport;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicUsize, Ordering};
+
+/// Entries kept without a `historyLimit`
+pub const DEFAULT_LIMIT: usize = 100;
+
+static LIMIT: AtomicUsize = AtomicUsize::new(DEFAULT_LIMIT);
+
+/// Keep the latest `limit` entries from now on; 0 records nothing
+pub fn set_limit(limit: usize) {
+    LIMIT.store(limit, Ordering::Relaxed);
+}
+
+/// One apply, as `dhd history` shows it
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct HistoryEntry {
+    /// When the apply finished, in seconds since the Unix epoch
+    pub timestamp: u64,
+    pub version: String,
+    pub success: bool,
+    /// Why the apply failed before or instead of running its actions
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+    /// Actions of the apply, and how many changed something or failed
+    pub actions: usize,
+    pub changed: usize,
+    pub failed: usize,
+    pub duration_ms: u64,
+    /// Modules with an action that changed something
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub changed_modules: Vec<String>,
+}
+
+impl HistoryEntry {
+    pub fn new(report: &RunReport) -> Self {
+        let summary = &report.apply.summary;
+        Self {
+            timestamp: report.timestamp,
+            version: report.version.clone(),
+            success: report.success,
+            error: report.error.clone(),
+            actions: summary.total,
+            changed: summary.applied,
+            failed: summary.failed,
+            duration_ms: summary.duration_ms,
+            changed_modules: report
+                .apply
+                .modules
+                .iter()
+                .filter(|module| {
+                    module
+                        .actions
+                        .iter()
+                        .any(|action| action.status == ActionStatus::Applied)
+                })
+                .map(|module| module.module.clone())
+                .collect(),
+        }
+    }
+}
+
+/// The history file of the state directory `dir`
+pub fn path(dir: &Path) -> PathBuf {
+    dir.join("history.json")
+}
+
+/// The entries in `dir`, oldest first; a missing file is an empty history
+pub fn load(dir: &Path) -> Result<Vec<HistoryEntry>, String> {
+    let path = path(dir);
+    let content = match fs::read_to_string(&path) {
+        Ok(content) => content,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(Vec::new()),
+        Err(e) => return Err(format!("Failed to read {}: {}", path.display(), e)),
+    };
+    serde_json::from_str(&content).map_err(|e| format!("Failed to parse {}: {}", path.display(), e))
+}
+
+/// Add `entry` to the history in `dir`, dropping the oldest entries beyond
+/// the limit
+pub fn record(dir: &Path, entry: HistoryEntry) -> Result<(), String> {
+    let limit = LIMIT.load(Ordering::Relaxed);
+    if limit == 0 {
+        return Ok(());
+    }
+    let mut entries = load(dir)?;
+    entries.push(entry);
+    let excess = entries.len().saturating_sub(limit);
+    entries.drain(..excess);
+
+    let json = serde_json::to_string_pretty(&entries)
+        .map_err(|e| format!("Failed to serialize history: {}", e))?;
+    crate::report::write(&path(dir), &(json + "\n"))
+}
+
+/// How long before `now` the time `timestamp` was, like `5 minutes ago`
+pub fn relative(timestamp: u64, now: u64) -> String {
+    let elapsed = now.saturating_sub(timestamp);
+    let (count, unit) = match elapsed {
+        0..60 => return "just now".to_string(),
+        60..3600 => (elapsed / 60, "minute"),
+        3600..86400 => (elapsed / 3600, "hour"),
+        86400..2592000 => (elapsed / 86400, "day"),
+        2592000..31536000 => (elapsed / 2592000, "month"),
+        _ => (elapsed / 31536000, "year"),
+    };
+    format!(
+        "{} {}{} ago",
+        count,
+        unit,
+        if count == 1 { "" } else { "s" }
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::module_executor::{ActionResult, ModuleResult};
+    use std::time::Duration;
+    use tempfile::TempDir;
+
+    fn entry(timestamp: u64) -> HistoryEntry {
+        HistoryEntry {
+            timestamp,
+            version: "0.2.0".to_string(),
+            success: true,
+            error: None,
+            actions: 4,
+            changed: 1,
+            failed: 0,
+            duration_ms: 1200,
+            changed_modules: vec!["zsh".to_string()],
+        }
+    }
+
+    #[test]
+    fn test_entry_summarizes_the_report() {
+        let action = |module: &str, status| ActionResult {
+            module: module.to_string(),
+            action: "Symlink".to_string(),
+            status,
+            error: None,
+            duration_ms: 0,
+        };
+        let module = |name: &str, status| ModuleResult {
+            module: name.to_string(),
+            description: None,
+            status,
+            reason: None,
+            actions: vec![action(name, status), action(name, ActionStatus::Noop)],
+            duration_ms: 0,
+            notified: Vec::new(),
+            reboot_required: false,
+        };
+        let summary = crate::dag_executor::ExecutionSummary {
+            total: 4,
+            completed: 2,
+            skipped: 0,
+            failed: Vec::new(),
+            modules: vec![
+                module("zsh", ActionStatus::Applied),
+                module("git", ActionStatus::Noop),
+            ],
+            stopped_by: None,
+        };
+        let report = RunReport::new(Some(&summary), None, false, Duration::from_secs(1));
+
+        let entry = HistoryEntry::new(&report);
+        assert!(entry.success);
+        assert_eq!((entry.actions, entry.changed, entry.failed), (4, 1, 0));
+        assert_eq!(entry.changed_modules, vec!["zsh"]);
+        assert_eq!(entry.version, env!("CARGO_PKG_VERSION"));
+    }
+
+    #[test]
+    fn test_history_keeps_the_latest_entries() {
+        let dir = TempDir::new().unwrap();
+        assert!(load(dir.path()).unwrap().is_empty());
+
+        set_limit(3);
+        for timestamp in 1..=5 {
+            record(dir.path(), entry(timestamp)).unwrap();
+        }
+        set_limit(DEFAULT_LIMIT);
+
+        let timestamps: Vec<u64> = load(dir.path())
+            .unwrap()
+            .iter()
+            .map(|entry| entry.timestamp)
+            .collect();
+        assert_eq!(timestamps, vec![3, 4, 5]);
+    }
+
+    #[test]
+    fn test_relative_times() {
+        assert_eq!(relative(1000, 1030), "just now");
+        assert_eq!(relative(1000, 1060), "1 minute ago");
+        assert_eq!(relative(0, 2 * 3600 + 59), "2 hours ago");
+        assert_eq!(relative(0, 86400), "1 day ago");
+        assert_eq!(relative(0, 45 * 86400), "1 month ago");
+        assert_eq!(relative(0, 3 * 31536000), "3 years ago");
+        // A clock that went backwards
+        assert_eq!(relative(2000, 1000), "just now");
+    }
+}
@@ -0,0 +1,750 @@
+//! Modules imported from other directories and git repositories
+//!
+//! Imports are declared in a `dhd.config.ts` next to the modules:
+//!
+//! ```typescript
+//! export default defineConfig({
+//!     imports: [
+//!         { path: "../baseline" },
+//!         { git: "https://github.com/acme/dotfiles.git", ref: "v1.2.0", namespace: "team" },
+//!     ],
+//! });
+//! ```
+//!
+//! Imported modules are namespaced, e.g. `team/zsh`, and their dependencies
+//! refer to modules of the same import unless they name a namespace
+//! themselves. Git imports are cloned into the cache once and only refreshed
+//! by `dhd update`, so applies work offline.
+//!
+//! The config can also set `variables` for the templates of every module,
+//! `packageGroups` for their `packageInstall` actions, `packageManagers`
+//! flags for the package managers' commands, the `shell` of commands and
+//! hooks that don't name one, and `backup`, `jobs` and `incremental` defaults
+//! that the matching `apply` flags override.
+
+use crate::atoms::Atom;
+use crate::atoms::git_repo::GitRepo;
+use crate::atoms::retry::{Retry, RetryPolicy};
+use crate::discovery::{DiscoveredModule, discover_modules};
+use dhd_macros::{typescript_fn, typescript_type};
+use sha2::{Digest, Sha256};
+use std::collections::{HashMap, HashSet};
+use std::path::{Path, PathBuf};
+
+/// File declaring the imports of a modules directory
+pub const CONFIG_FILE: &str = "dhd.config.ts";
+
+/// Modules to import from another local directory or a git repository
+#[typescript_type]
+pub struct Import {
+    /// Directory to import, relative to the config file unless absolute
+    pub path: Option<String>,
+    /// Repository URL to import instead of a directory
+    pub git: Option<String>,
+    /// Branch, tag or commit of `git` (default: the remote's default branch)
+    pub r#ref: Option<String>,
+    /// Prefix for the imported module names (default: the directory or repository name)
+    pub namespace: Option<String>,
+}
+
+/// What applies on one machine, picked by `--host` or the hostname
+#[typescript_type]
+#[derive(Default)]
+pub struct HostProfile {
+    /// Modules to apply, unless `--module` or `--tag` is given
+    pub modules: Option<Vec<String>>,
+    /// Tags of the modules to apply, unless `--module` or `--tag` is given
+    pub tags: Option<Vec<String>>,
+    /// Template variables of the host, over those of the config
+    pub variables: Option<HashMap<String, String>>,
+    /// Groups the host is in, besides those listing it in their `hosts`
+    pub groups: Option<Vec<String>>,
+    /// Modules to skip on the host, as if they set `enabled: false`
+    pub disabled: Option<Vec<String>>,
+}
+
+/// What applies on several machines, which each get the group's modules,
+/// tags and variables on top of their own profile's
+#[typescript_type]
+#[derive(Default)]
+pub struct HostGroup {
+    /// Hosts in the group, besides those listing it in their `groups`
+    pub hosts: Option<Vec<String>>,
+    /// Modules to apply on the group's hosts
+    pub modules: Option<Vec<String>>,
+    /// Tags of the modules to apply on the group's hosts
+    pub tags: Option<Vec<String>>,
+    /// Template variables of the group's hosts, over those of the config
+    /// and under those of a host's profile
+    pub variables: Option<HashMap<String, String>>,
+}
+
+/// Settings of one package manager's commands
+#[typescript_type]
+#[derive(Default)]
+pub struct PackageManagerConfig {
+    /// Flags added to the commands that install, remove or upgrade packages,
+    /// after DHD's own and before the packages, e.g. `["-o",
+    /// "Acquire::http::Proxy=http://proxy.example.com:3128"]` for apt
+    pub extra_args: Option<Vec<String>>,
+}
+
+#[typescript_type]
+#[derive(Default)]
+pub struct DhdConfig {
+    pub imports: Option<Vec<Import>>,
+    /// Template variables of every module; a module's own variables win
+    pub variables: Option<HashMap<String, String>>,
+    /// Keep `<file>.dhd-bak-<timestamp>` copies of replaced files (default: true)
+    pub backup: Option<bool>,
+    /// Number of modules to apply in parallel (default: number of CPUs)
+    pub jobs: Option<u32>,
+    /// Skip actions whose inputs haven't changed since the last apply, as
+    /// with `--incremental` (default: false)
+    pub incremental: Option<bool>,
+    /// Host profiles by name, e.g. `{ laptop: { tags: ["desktop"] } }`
+    pub hosts: Option<HashMap<String, HostProfile>>,
+    /// Host groups by name, e.g. `{ web: { hosts: ["web01", "web02"] } }`
+    pub groups: Option<HashMap<String, HostGroup>>,
+    /// Named lists of packages that `packageInstall` can install with `groups`
+    pub package_groups: Option<HashMap<String, Vec<String>>>,
+    /// Package manager settings by manager, e.g. `{ pacman: { extraArgs:
+    /// ["--noconfirm"] } }`
+    pub package_managers: Option<HashMap<String, PackageManagerConfig>>,
+    /// Prefix of the environment variables that bare `secret("name")` names
+    /// are read from first (default: `DHD_SECRET_`)
+    pub secret_env_prefix: Option<String>,
+    /// Applies `dhd history` keeps, 0 to stop recording them (default: 100)
+    pub history_limit: Option<u32>,
+    /// Shell of the commands and hooks that don't name one, like `bash` or
+    /// `fish` (default: sh)
+    pub shell: Option<String>,
+    /// Modules to skip on every host, as if they set `enabled: false`
+    pub disabled: Option<Vec<String>>,
+}
+
+#[typescript_fn]
+pub fn define_config(config: DhdConfig) -> DhdConfig {
+    config
+}
+
+impl DhdConfig {
+    /// Whether the config has a profile for `host` or puts it in a group
+    fn knows_host(&self, host: &str) -> bool {
+        self.hosts
+            .as_ref()
+            .is_some_and(|hosts| hosts.contains_key(host))
+            || self
+                .groups
+                .iter()
+                .flatten()
+                .any(|(_, group)| group.hosts.iter().flatten().any(|member| member == host))
+    }
+
+    /// The profile of `host` with what its groups add to it, or `None` if
+    /// the config doesn't know the host
+    ///
+    /// The groups the profile lists come first, in its order, then those
+    /// listing the host, by name. Modules and tags add up; for variables, a
+    /// later group wins over an earlier one and the host's own win over all.
+    pub fn host(&self, host: &str) -> Option<HostProfile> {
+        if !self.knows_host(host) {
+            return None;
+        }
+        let own = self.hosts.as_ref().and_then(|hosts| hosts.get(host));
+
+        let mut names: Vec<&str> = own
+            .iter()
+            .flat_map(|profile| profile.groups.iter().flatten())
+            .map(String::as_str)
+            .collect();
+        let mut listing: Vec<&str> = self
+            .groups
+            .iter()
+            .flatten()
+            .filter(|(_, group)| group.hosts.iter().flatten().any(|member| member == host))
+            .map(|(name, _)| name.as_str())
+            .collect();
+        listing.sort();
+        for name in listing {
+            if !names.contains(&name) {
+                names.push(name);
+            }
+        }
+
+        fn add(into: &mut Option<Vec<String>>, from: &Option<Vec<String>>) {
+            for value in from.iter().flatten() {
+                let values = into.get_or_insert_with(Vec::new);
+                if !values.contains(value) {
+                    values.push(value.clone());
+                }
+            }
+        }
+
+        // Each group, then the host itself, adds to what came before
+        let groups = names
+            .iter()
+            .filter_map(|name| self.groups.as_ref()?.get(*name))
+            .map(|group| (&group.modules, &group.tags, &group.variables));
+        let own_layer = own.map(|own| (&own.modules, &own.tags, &own.variables));
+        let mut profile = HostProfile::default();
+        for (modules, tags, variables) in groups.chain(own_layer) {
+            add(&mut profile.modules, modules);
+            add(&mut profile.tags, tags);
+            if let Some(variables) = variables {
+                profile
+                    .variables
+                    .get_or_insert_with(HashMap::new)
+                    .extend(variables.clone());
+            }
+        }
+        if let Some(own) = own {
+            add(&mut profile.disabled, &own.disabled);
+        }
+        profile.groups =
+            (!names.is_empty()).then(|| names.iter().map(|name| name.to_string()).collect());
+        Some(profile)
+    }
+}
+
+impl Import {
+    /// The namespace, defaulting to the last component of the path or URL
+    pub fn namespace(&self) -> String {
+        if let Some(namespace) = &self.namespace {
+            return namespace.clone();
+        }
+
+        let source = self.git.as_deref().or(self.path.as_deref()).unwrap_or("");
+        let name = source
+            .trim_end_matches('/')
+            .rsplit(['/', ':'])
+            .next()
+            .unwrap_or(source);
+        name.strip_suffix(".git").unwrap_or(name).to_string()
+    }
+
+    fn describe(&self) -> String {
+        match (&self.git, &self.r#ref) {
+            (Some(url), Some(git_ref)) => format!("{}@{}", url, git_ref),
+            (Some(url), None) => url.clone(),
+            _ => self.path.clone().unwrap_or_default(),
+        }
+    }
+
+    /// The directory holding the imported modules, cloning git imports that
+    /// aren't cached yet (or refreshing them when `update` is set)
+    fn root(&self, config_dir: &Path, update: bool) -> Result<PathBuf, String> {
+        match (&self.path, &self.git) {
+            (Some(path), None) => {
+                let path = crate::paths::expand_path(path);
+                let root = if path.is_absolute() {
+                    path
+                } else {
+                    config_dir.join(path)
+                };
+                if !root.is_dir() {
+                    return Err(format!("Import path {} does not exist", root.display()));
+                }
+                Ok(root)
+            }
+            (None, Some(url)) => {
+                let root = cache_dir().join(checkout_name(url, self.r#ref.as_deref()));
+                let repo = GitRepo::new(url.clone(), root.clone(), self.r#ref.clone(), None, true);
+                if update || !root.join(".git").exists() {
+                    Retry::new(Box::new(repo), RetryPolicy::default()).execute()?;
+                }
+                Ok(root)
+            }
+            _ => Err("An import needs either 'path' or 'git'".to_string()),
+        }
+    }
+}
+
+/// Where git imports are cloned (`$XDG_CACHE_HOME/dhd/imports`, or
+/// `$DHD_HOME/cache/imports`)
+pub fn cache_dir() -> PathBuf {
+    crate::platform::dhd_cache_dir().join("imports")
+}
+
+/// A readable, unique directory name for a checkout of `url` at `git_ref`
+fn checkout_name(url: &str, git_ref: Option<&str>) -> String {
+    let digest = Sha256::digest(format!("{}#{}", url, git_ref.unwrap_or_default()));
+    let hash: String = digest
+        .iter()
+        .take(6)
+        .map(|b| format!("{:02x}", b))
+        .collect();
+    let name = url
+        .trim_end_matches('/')
+        .rsplit(['/', ':'])
+        .next()
+        .unwrap_or("repo");
+    format!("{}-{}", name.strip_suffix(".git").unwrap_or(name), hash)
+}
+
+/// The config file of `dir`, or an empty config if it has none
+pub fn load_dir_config(dir: &Path) -> Result<DhdConfig, String> {
+    let path = dir.join(CONFIG_FILE);
+    if !path.exists() {
+        return Ok(DhdConfig::default());
+    }
+
+    crate::loader::load_config(&path)
+        .map_err(|e| format!("Failed to load {}: {}", path.display(), e))
+}
+
+/// The imports declared in `dir`, if it has a config file
+pub fn load_imports(dir: &Path) -> Result<Vec<Import>, String> {
+    Ok(load_dir_config(dir)?.imports.unwrap_or_default())
+}
+
+/// The settings of several module roots, a later root's overriding an earlier one's
+pub fn load_settings(roots: &[PathBuf]) -> Result<DhdConfig, String> {
+    let mut settings = DhdConfig::default();
+    for root in roots {
+        let config = load_dir_config(root)?;
+        settings.backup = config.backup.or(settings.backup);
+        settings.jobs = config.jobs.or(settings.jobs);
+        settings.incremental = config.incremental.or(settings.incremental);
+        settings.secret_env_prefix = config.secret_env_prefix.or(settings.secret_env_prefix);
+        settings.history_limit = config.history_limit.or(settings.history_limit);
+        settings.shell = config.shell.or(settings.shell);
+        // Every root disables its own modules
+        for name in config.disabled.into_iter().flatten() {
+            let disabled = settings.disabled.get_or_insert_with(Vec::new);
+            if !disabled.contains(&name) {
+                disabled.push(name);
+            }
+        }
+        // A later root's settings of a manager replace an earlier one's
+        if let Some(managers) = config.package_managers {
+            settings
+                .package_managers
+                .get_or_insert_with(HashMap::new)
+                .extend(managers);
+        }
+    }
+    Ok(settings)
+}
+
+/// The host profile to use: `requested`, or else the one named after this host
+///
+/// The profile includes what the host's groups give it. Profiles of later
+/// roots replace those of the same host in earlier ones. Requesting a host
+/// that no root has a profile for or puts in a group is an error.
+pub fn select_host(
+    roots: &[PathBuf],
+    requested: Option<&str>,
+) -> Result<Option<(String, HostProfile)>, String> {
+    let configs = roots
+        .iter()
+        .map(|root| load_dir_config(root))
+        .collect::<Result<Vec<_>, _>>()?;
+    let knows = |name: &str| configs.iter().any(|config| config.knows_host(name));
+
+    let name = match requested {
+        Some(name) => name.to_string(),
+        None => {
+            // `laptop.example.com` uses the `laptop` profile if there's no exact match
+            let hostname = &crate::system_info::facts().host.hostname;
+            let short = hostname.split('.').next().unwrap_or(hostname);
+            match [hostname.as_str(), short]
+                .into_iter()
+                .find(|name| knows(name))
+            {
+                Some(name) => name.to_string(),
+                None => return Ok(None),
+            }
+        }
+    };
+
+    match configs.iter().rev().find_map(|config| config.host(&name)) {
+        Some(profile) => Ok(Some((name, profile))),
+        None => {
+            let mut known: Vec<String> = configs
+                .iter()
+                .flat_map(|config| config.hosts.iter().flatten())
+                .map(|(host, _)| host.clone())
+                .collect();
+            known.extend(
+                configs
+                    .iter()
+                    .flat_map(|config| config.groups.iter().flatten())
+                    .flat_map(|(_, group)| group.hosts.iter().flatten().cloned()),
+            );
+            known.sort();
+            known.dedup();
+            if known.is_empty() {
+                known.push("none".to_string());
+            }
+            Err(format!(
+                "Unknown host profile '{}' (defined: {})",
+                name,
+                known.join(", ")
+            ))
+        }
+    }
+}
+
+/// Discover the modules in `dir` together with the modules it imports
+///
+/// Every module, imported ones included, gets the variables of `dir`'s
+/// config, overridden by those of its `host` profile, and its package groups.
+pub fn discover_all(dir: &Path, host: Option<&str>) -> Result<Vec<DiscoveredModule>, String> {
+    let config = load_dir_config(dir)?;
+    let profile = host.and_then(|host| config.host(host));
+    let imports = config.imports.unwrap_or_default();
+    let mut variables = config.variables.unwrap_or_default();
+    let package_groups = config.package_groups.unwrap_or_default();
+    if let Some(profile) = profile {
+        variables.extend(profile.variables.unwrap_or_default());
+    }
+    let mut roots = Vec::new();
+    let mut namespaces = HashSet::new();
+    for import in &imports {
+        let namespace = import.namespace();
+        if !namespaces.insert(namespace.clone()) {
+            return Err(format!(
+                "Import namespace '{}' is used more than once",
+                namespace
+            ));
+        }
+        let root = import
+            .root(dir, false)
+            .map_err(|e| format!("Failed to import {}: {}", import.describe(), e))?;
+        roots.push((namespace, root));
+    }
+
+    // Imports vendored inside the directory are only picked up under their namespace
+    let mut modules: Vec<DiscoveredModule> = discover_modules(dir)
+        .map_err(|e| e.to_string())?
+        .into_iter()
+        .filter(|module| !roots.iter().any(|(_, root)| module.path.starts_with(root)))
+        .collect();
+
+    for (namespace, root) in roots {
+        let imported = discover_modules(&root).map_err(|e| e.to_string())?;
+        modules.extend(imported.into_iter().map(|module| DiscoveredModule {
+            name: format!("{}/{}", namespace, module.name),
+            namespace: Some(namespace.clone()),
+            ..module
+        }));
+    }
+
+    for module in &mut modules {
+        module.variables = variables.clone();
+        module.package_groups = package_groups.clone();
+    }
+    Ok(modules)
+}
+
+/// The module in the file at `path` alone, wherever it is, for `--file`
+///
+/// It gets the variables and package groups the configs of `roots` give
+/// their modules, a later root's overriding an earlier one's.
+pub fn discover_file(
+    roots: &[PathBuf],
+    path: &Path,
+    host: Option<&str>,
+) -> Result<DiscoveredModule, String> {
+    let mut module = crate::discovery::discover_file(path)?;
+    for root in roots {
+        let config = load_dir_config(root)?;
+        let profile = host.and_then(|host| config.host(host));
+        module
+            .variables
+            .extend(config.variables.unwrap_or_default());
+        if let Some(profile) = profile {
+            module
+                .variables
+                .extend(profile.variables.unwrap_or_default());
+        }
+        module
+            .package_groups
+            .extend(config.package_groups.unwrap_or_default());
+    }
+    Ok(module)
+}
+
+/// Discover the modules of several roots, each with its imports
+///
+/// Roots are given in override order: a module in a later root replaces a
+/// module of the same name from an earlier one, and each override is logged.
+pub fn discover_roots(
+    roots: &[PathBuf],
+    host: Option<&str>,
+) -> Result<Vec<DiscoveredModule>, String> {
+    let mut modules: Vec<DiscoveredModule> = Vec::new();
+    for root in roots {
+        if !root.is_dir() {
+            return Err(format!("Modules path {} does not exist", root.display()));
+        }
+
+        for module in discover_all(root, host)? {
+            if let Some(existing) = modules.iter_mut().find(|m| m.name == module.name) {
+                log::warn!(
+                    "Module '{}' from {} overrides {}",
+                    module.name,
+                    module.path.display(),
+                    existing.path.display()
+                );
+                *existing = module;
+            } else {
+                modules.push(module);
+            }
+        }
+    }
+
+    Ok(modules)
+}
+
+/// Fetch every git import of `dir` again, returning each namespace and its checkout
+pub fn update_imports(dir: &Path) -> Result<Vec<(String, PathBuf)>, String> {
+    let mut updated = Vec::new();
+    for import in load_imports(dir)? {
+        if import.git.is_none() {
+            continue;
+        }
+        let root = import
+            .root(dir, true)
+            .map_err(|e| format!("Failed to update {}: {}", import.describe(), e))?;
+        updated.push((import.namespace(), root));
+    }
+
+    Ok(updated)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+    use tempfile::TempDir;
+
+    fn import(path: Option<&str>, git: Option<&str>) -> Import {
+        Import {
+            path: path.map(String::from),
+            git: git.map(String::from),
+            r#ref: None,
+            namespace: None,
+        }
+    }
+
+    #[test]
+    fn test_namespace_defaults_to_source_name() {
+        assert_eq!(import(Some("../baseline/"), None).namespace(), "baseline");
+        assert_eq!(
+            import(None, Some("https://github.com/acme/dotfiles.git")).namespace(),
+            "dotfiles"
+        );
+        assert_eq!(
+            import(None, Some("git@github.com:dotfiles.git")).namespace(),
+            "dotfiles"
+        );
+
+        let mut named = import(Some("../baseline"), None);
+        named.namespace = Some("team".to_string());
+        assert_eq!(named.namespace(), "team");
+    }
+
+    #[test]
+    fn test_checkout_name_depends_on_ref() {
+        let url = "https://github.com/acme/dotfiles.git";
+        assert!(checkout_name(url, Some("v1")).starts_with("dotfiles-"));
+        assert_ne!(
+            checkout_name(url, Some("v1")),
+            checkout_name(url, Some("v2"))
+        );
+    }
+
+    #[test]
+    fn test_discover_all_namespaces_local_imports() {
+        let temp_dir = TempDir::new().unwrap();
+        let base = temp_dir.path().join("base");
+        let host = temp_dir.path().join("host");
+        fs::create_dir_all(&base).unwrap();
+        fs::create_dir_all(&host).unwrap();
+        fs::write(base.join("zsh.ts"), "").unwrap();
+        fs::write(host.join("laptop.ts"), "").unwrap();
+        fs::write(
+            host.join(CONFIG_FILE),
+            r#"export default defineConfig({ imports: [{ path: "../base" }], variables: { theme: "dark" } });"#,
+        )
+        .unwrap();
+
+        let modules = discover_all(&host, None).unwrap();
+        let names: Vec<&str> = modules.iter().map(|m| m.name.as_str()).collect();
+        assert_eq!(names, vec!["laptop", "base/zsh"]);
+        assert_eq!(modules[1].namespace.as_deref(), Some("base"));
+        for module in &modules {
+            assert_eq!(
+                module.variables.get("theme").map(String::as_str),
+                Some("dark")
+            );
+        }
+    }
+
+    #[test]
+    fn test_host_profiles() {
+        let temp_dir = TempDir::new().unwrap();
+        let dir = temp_dir.path().to_path_buf();
+        fs::write(dir.join("git.ts"), "").unwrap();
+        fs::write(
+            dir.join(CONFIG_FILE),
+            r#"
+export default defineConfig({
+    variables: { theme: "dark", editor: "nvim" },
+    hosts: { "dhd-test-laptop": { tags: ["desktop"], variables: { theme: "light" } } },
+});
+"#,
+        )
+        .unwrap();
+
+        let roots = [dir.clone()];
+        let (name, profile) = select_host(&roots, Some("dhd-test-laptop"))
+            .unwrap()
+            .unwrap();
+        assert_eq!(name, "dhd-test-laptop");
+        assert_eq!(profile.tags, Some(vec!["desktop".to_string()]));
+        assert_eq!(select_host(&roots, None).unwrap(), None);
+
+        let err = select_host(&roots, Some("server")).unwrap_err();
+        assert_eq!(
+            err,
+            "Unknown host profile 'server' (defined: dhd-test-laptop)"
+        );
+
+        let modules = discover_all(&dir, Some("dhd-test-laptop")).unwrap();
+        let variables = &modules[0].variables;
+        assert_eq!(variables.get("theme").map(String::as_str), Some("light"));
+        assert_eq!(variables.get("editor").map(String::as_str), Some("nvim"));
+
+        // A module file outside the root still gets its variables
+        let scratch = TempDir::new().unwrap();
+        let file = scratch.path().join("scratch.ts");
+        fs::write(&file, "").unwrap();
+        let module = discover_file(&roots, &file, Some("dhd-test-laptop")).unwrap();
+        assert_eq!(module.name, "scratch");
+        assert_eq!(
+            module.variables.get("theme").map(String::as_str),
+            Some("light")
+        );
+    }
+
+    #[test]
+    fn test_host_groups() {
+        let temp_dir = TempDir::new().unwrap();
+        let dir = temp_dir.path().to_path_buf();
+        fs::write(dir.join("nginx.ts"), "").unwrap();
+        fs::write(
+            dir.join(CONFIG_FILE),
+            r#"
+export default defineConfig({
+    variables: { region: "us", tier: "dev", port: "80" },
+    groups: {
+        web: { hosts: ["web01", "web02"], modules: ["nginx"], variables: { tier: "web", port: "8080" } },
+        eu: { tags: ["gdpr"], variables: { region: "eu", port: "8443" } },
+        all: { hosts: ["web01", "db01"], modules: ["base"] },
+    },
+    hosts: {
+        web01: { groups: ["eu"], modules: ["certbot"], variables: { port: "443" } },
+    },
+});
+"#,
+        )
+        .unwrap();
+        let roots = [dir.clone()];
+
+        // A host only a group lists still has a profile
+        let (_, web02) = select_host(&roots, Some("web02")).unwrap().unwrap();
+        assert_eq!(web02.modules, Some(vec!["nginx".to_string()]));
+        assert_eq!(web02.groups, Some(vec!["web".to_string()]));
+
+        let (_, web01) = select_host(&roots, Some("web01")).unwrap().unwrap();
+        assert_eq!(
+            web01.groups,
+            Some(vec!["eu".to_string(), "all".to_string(), "web".to_string()])
+        );
+        assert_eq!(
+            web01.modules,
+            Some(vec![
+                "base".to_string(),
+                "nginx".to_string(),
+                "certbot".to_string()
+            ])
+        );
+        assert_eq!(web01.tags, Some(vec!["gdpr".to_string()]));
+
+        // Host over group over config; a later group over an earlier one
+        let modules = discover_all(&dir, Some("web01")).unwrap();
+        let variables = &modules[0].variables;
+        assert_eq!(variables.get("port").map(String::as_str), Some("443"));
+        assert_eq!(variables.get("tier").map(String::as_str), Some("web"));
+        assert_eq!(variables.get("region").map(String::as_str), Some("eu"));
+        let modules = discover_all(&dir, Some("web02")).unwrap();
+        let variables = &modules[0].variables;
+        assert_eq!(variables.get("port").map(String::as_str), Some("8080"));
+        assert_eq!(variables.get("region").map(String::as_str), Some("us"));
+
+        let err = select_host(&roots, Some("mail01")).unwrap_err();
+        assert_eq!(
+            err,
+            "Unknown host profile 'mail01' (defined: db01, web01, web02)"
+        );
+    }
+
+    #[test]
+    fn test_later_roots_override_settings() {
+        let temp_dir = TempDir::new().unwrap();
+        let common = temp_dir.path().join("common");
+        let host = temp_dir.path().join("host");
+        fs::create_dir_all(&common).unwrap();
+        fs::create_dir_all(&host).unwrap();
+        fs::write(
+            common.join(CONFIG_FILE),
+            r#"export default defineConfig({ backup: false, jobs: 2 });"#,
+        )
+        .unwrap();
+        fs::write(
+            host.join(CONFIG_FILE),
+            r#"export default defineConfig({ jobs: 8, incremental: true });"#,
+        )
+        .unwrap();
+
+        let settings = load_settings(&[common, host]).unwrap();
+        assert_eq!(settings.backup, Some(false));
+        assert_eq!(settings.jobs, Some(8));
+        assert_eq!(settings.incremental, Some(true));
+    }
+
+    #[test]
+    fn test_later_roots_override_earlier_ones() {
+        let temp_dir = TempDir::new().unwrap();
+        let common = temp_dir.path().join("common");
+        let host = temp_dir.path().join("host");
+        fs::create_dir_all(&common).unwrap();
+        fs::create_dir_all(&host).unwrap();
+        fs::write(common.join("git.ts"), "").unwrap();
+        fs::write(common.join("zsh.ts"), "").unwrap();
+        fs::write(host.join("zsh.ts"), "").unwrap();
+
+        let modules = discover_roots(&[common.clone(), host.clone()], None).unwrap();
+        let paths: Vec<&Path> = modules.iter().map(|m| m.path.as_path()).collect();
+        assert_eq!(paths, vec![common.join("git.ts"), host.join("zsh.ts")]);
+
+        let err = discover_roots(&[temp_dir.path().join("nope")], None).unwrap_err();
+        assert!(err.contains("does not exist"), "{}", err);
+    }
+
+    #[test]
+    fn test_missing_import_path_is_an_error() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(
+            temp_dir.path().join(CONFIG_FILE),
+            r#"export default defineConfig({ imports: [{ path: "./nope" }] });"#,
+        )
+        .unwrap();
+
+        let err = discover_all(temp_dir.path(), None).unwrap_err();
+        assert!(err.contains("does not exist"), "{}", err);
+    }
+}
@@ -0,0 +1,282 @@
+//! Input hashes behind `dhd apply --incremental`
+//!
+//! An action's inputs are its definition, with the template variables it was
+//! given, and the content of the files it reads from the module directory.
+//! After a module applies without failures, the hashes of its actions are
+//! kept in the state file; an incremental apply skips the actions whose hash
+//! is among them without planning or checking them.
+//!
+//! Actions whose outcome depends on something else that can change, like a
+//! command's output, a download or a condition, have no hash and always run.
+
+use crate::actions::ActionType;
+use crate::discovery::EXCLUDED_DIRS;
+use sha2::{Digest, Sha256};
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// The hash of `action`'s inputs, or `None` if it has to run every time
+pub fn action_hash(action: &ActionType, module_dir: &Path) -> Option<String> {
+    let files = inputs(action, module_dir)?;
+
+    let mut hasher = Sha256::new();
+    // A different dhd may plan the same action differently
+    hasher.update(env!("CARGO_PKG_VERSION").as_bytes());
+    hasher.update(canonical(action).as_bytes());
+    for file in files {
+        hasher.update(file.to_string_lossy().as_bytes());
+        hash_path(&mut hasher, &file);
+    }
+    Some(format!("{:x}", hasher.finalize()))
+}
+
+/// The files `action` reads, or `None` if it depends on more than files
+fn inputs(action: &ActionType, module_dir: &Path) -> Option<Vec<PathBuf>> {
+    let resolve = |path: &str| crate::paths::resolve(module_dir, path);
+    match action {
+        ActionType::ExecuteCommand(_)
+        | ActionType::ShellCommand(_)
+        | ActionType::Conditional(_)
+        | ActionType::HttpDownload(_) => None,
+        // Only the plugin knows what it depends on
+        ActionType::Plugin(_) => None,
+        ActionType::RemoteFile(remote) if remote.selected().1.is_none() => None,
+        ActionType::GitRepo(repo) if repo.update == Some(true) => None,
+        ActionType::PackageInstall(install) if install.ensure.as_deref() == Some("latest") => None,
+        // Restarts on every apply
+        ActionType::SystemdService(service) if service.action.as_deref() == Some("restart") => None,
+        // Groups of the package manager can gain packages
+        ActionType::PackageInstall(install) if install.groups.is_some() => None,
+        ActionType::Notify(notify) => inputs(&notify.action, module_dir),
+        ActionType::Tagged(tagged) => inputs(&tagged.action, module_dir),
+        ActionType::Verified(verified) => inputs(&verified.action, module_dir),
+        ActionType::Ordered(ordered) => inputs(&ordered.action, module_dir),
+        ActionType::Reboot(reboot) => inputs(&reboot.action, module_dir),
+        // Inline content is part of the definition
+        ActionType::CopyFile(copy) => Some(
+            copy.source
+                .iter()
+                .map(|source| glob_input(resolve(source)))
+                .collect(),
+        ),
+        // Only the files a glob matches can change what a symlink does
+        ActionType::Symlink(link) if crate::paths::is_glob(&resolve(&link.source)) => {
+            Some(vec![glob_input(resolve(&link.source))])
+        }
+        ActionType::DecryptFile(decrypt) => Some(vec![resolve(&decrypt.source)]),
+        ActionType::DconfImport(import) => Some(vec![resolve(&import.source)]),
+        ActionType::Stow(stow) => Some(vec![resolve(&stow.source)]),
+        // Templates can include any file of the module directory
+        ActionType::Template(_) | ActionType::TemplateDir(_) => {
+            Some(vec![module_dir.to_path_buf()])
+        }
+        ActionType::GpgKey(key) => Some(key.key_file.iter().map(|file| resolve(file)).collect()),
+        ActionType::PackageRepo(repo) => Some(
+            repo.key
+                .iter()
+                .filter(|key| !key.contains("://"))
+                .map(|key| resolve(key))
+                .collect(),
+        ),
+        _ => Some(Vec::new()),
+    }
+}
+
+/// The input of a source: the file itself, or the directory of a glob, whose
+/// matches can come and go
+fn glob_input(source: PathBuf) -> PathBuf {
+    if crate::paths::is_glob(&source) {
+        crate::paths::glob_dir(&source).to_path_buf()
+    } else {
+        source
+    }
+}
+
+/// `action` as text that doesn't depend on the order of its maps
+fn canonical(action: &ActionType) -> String {
+    fn sorted<K: Ord + std::fmt::Debug, V: std::fmt::Debug>(
+        map: impl IntoIterator<Item = (K, V)>,
+    ) -> String {
+        format!("{:?}", map.into_iter().collect::<BTreeMap<_, _>>())
+    }
+
+    if let ActionType::Notify(notify) = action {
+        return format!("Notify {:?} {}", notify.handlers, canonical(&notify.action));
+    }
+    if let ActionType::Verified(verified) = action {
+        return format!(
+            "Verified {:?} {}",
+            verified.verify,
+            canonical(&verified.action)
+        );
+    }
+    // Tags only select actions, ids only order them and a required reboot
+    // is only reported; none of them changes what's applied
+    if let ActionType::Tagged(tagged) = action {
+        return canonical(&tagged.action);
+    }
+    if let ActionType::Ordered(ordered) = action {
+        return canonical(&ordered.action);
+    }
+    if let ActionType::Reboot(reboot) = action {
+        return canonical(&reboot.action);
+    }
+
+    let mut action = action.clone();
+    let maps = match &mut action {
+        ActionType::Template(template) => template.variables.take().map(sorted),
+        ActionType::TemplateDir(template) => template.variables.take().map(sorted),
+        ActionType::ScriptInstall(script) => script.env.take().map(sorted),
+        ActionType::SshConfig(ssh) => {
+            let options: Vec<_> = ssh
+                .hosts
+                .iter_mut()
+                .map(|host| host.options.take().map(sorted))
+                .collect();
+            Some(format!("{:?}", options))
+        }
+        ActionType::PackageInstall(install) => install
+            .overrides
+            .take()
+            .map(|overrides| sorted(overrides.into_iter().map(|(k, v)| (k, sorted(v))))),
+        ActionType::PackageRemove(remove) => remove
+            .overrides
+            .take()
+            .map(|overrides| sorted(overrides.into_iter().map(|(k, v)| (k, sorted(v))))),
+        _ => None,
+    };
+    format!("{:?} {:?}", action, maps)
+}
+
+/// Feed the content of `path` to `hasher`: a file's bytes, a symlink's target,
+/// or every file of a directory in order
+fn hash_path(hasher: &mut Sha256, path: &Path) {
+    let Ok(metadata) = fs::symlink_metadata(path) else {
+        hasher.update(b"\0missing");
+        return;
+    };
+
+    if metadata.file_type().is_symlink() {
+        hasher.update(b"\0symlink");
+        if let Ok(target) = fs::read_link(path) {
+            hasher.update(target.to_string_lossy().as_bytes());
+        }
+    } else if metadata.is_dir() {
+        hasher.update(b"\0dir");
+        let mut entries: Vec<_> = fs::read_dir(path)
+            .map(|entries| entries.flatten().map(|entry| entry.path()).collect())
+            .unwrap_or_default();
+        entries.sort();
+        for entry in entries {
+            let name = entry.file_name().unwrap_or_default().to_string_lossy();
+            if EXCLUDED_DIRS.contains(&name.as_ref()) {
+                continue;
+            }
+            hasher.update(name.as_bytes());
+            hash_path(hasher, &entry);
+        }
+    } else {
+        hasher.update(b"\0file");
+        match fs::read(path) {
+            Ok(content) => hasher.update(Sha256::digest(&content)),
+            Err(_) => hasher.update(b"\0unreadable"),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::actions::{CopyFile, ExecuteCommand, Template};
+    use std::collections::HashMap;
+    use tempfile::TempDir;
+
+    fn copy(source: &str) -> ActionType {
+        ActionType::CopyFile(CopyFile {
+            source: Some(source.to_string()),
+            content: None,
+            target: "~/.zshrc".to_string(),
+            escalate: false,
+            mode: None,
+            owner: None,
+            group: None,
+            create_parents: None,
+            ensure: None,
+        })
+    }
+
+    #[test]
+    fn test_hash_changes_with_source_content() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(temp_dir.path().join("zshrc"), "export A=1").unwrap();
+
+        let action = copy("zshrc");
+        let first = action_hash(&action, temp_dir.path()).unwrap();
+        assert_eq!(action_hash(&action, temp_dir.path()).unwrap(), first);
+
+        fs::write(temp_dir.path().join("zshrc"), "export A=2").unwrap();
+        assert_ne!(action_hash(&action, temp_dir.path()).unwrap(), first);
+
+        assert_ne!(
+            action_hash(&copy("bashrc"), temp_dir.path()).unwrap(),
+            first
+        );
+    }
+
+    #[test]
+    fn test_hash_changes_with_variables_not_their_order() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(temp_dir.path().join("gitconfig.tmpl"), "{{ email }}").unwrap();
+
+        let template = |pairs: &[(&str, &str)]| {
+            ActionType::Template(Template {
+                source: Some("gitconfig.tmpl".to_string()),
+                content: None,
+                target: "~/.gitconfig".to_string(),
+                variables: Some(
+                    pairs
+                        .iter()
+                        .map(|(k, v)| (k.to_string(), v.to_string()))
+                        .collect::<HashMap<_, _>>(),
+                ),
+                ensure: None,
+            })
+        };
+
+        let pairs = [("email", "user@example.com"), ("name", "User")];
+        let first = action_hash(&template(&pairs), temp_dir.path()).unwrap();
+        let reversed = [pairs[1], pairs[0]];
+        assert_eq!(
+            action_hash(&template(&reversed), temp_dir.path()).unwrap(),
+            first
+        );
+        assert_ne!(
+            action_hash(
+                &template(&[("email", "other@example.com")]),
+                temp_dir.path()
+            )
+            .unwrap(),
+            first
+        );
+
+        // Partials anywhere in the module directory count too
+        fs::write(temp_dir.path().join("signature.tmpl"), "--").unwrap();
+        assert_ne!(
+            action_hash(&template(&pairs), temp_dir.path()).unwrap(),
+            first
+        );
+    }
+
+    #[test]
+    fn test_commands_always_run() {
+        let action = ActionType::ExecuteCommand(ExecuteCommand {
+            shell: None,
+            command: "make install".to_string(),
+            args: None,
+            escalate: None,
+            environment: None,
+        });
+        assert_eq!(action_hash(&action, Path::new(".")), None);
+    }
+}
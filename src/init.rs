@@ -0,0 +1,207 @@
+//! Scaffolding for `dhd init`
+//!
+//! A new modules directory gets a `dhd.config.ts`, a sample `base` module
+//! installing a few packages with the distribution's package manager, and a
+//! `.gitignore` for the generated TypeScript definitions.
+
+use crate::imports::CONFIG_FILE;
+use crate::platform::{LinuxDistro, Platform, current_platform};
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// Distributions `dhd init` can scaffold for, with their package manager
+pub const DISTROS: &[(&str, &str)] = &[
+    ("ubuntu", "apt"),
+    ("debian", "apt"),
+    ("fedora", "dnf"),
+    ("arch", "pacman"),
+    ("nixos", "nix"),
+    ("macos", "brew"),
+];
+
+/// Shells `dhd init` can scaffold for
+pub const SHELLS: &[&str] = &["bash", "zsh", "fish"];
+
+/// Name of the sample module
+const SAMPLE_MODULE: &str = "base.ts";
+
+/// Lines the scaffolded `.gitignore` needs
+const IGNORED: &[&str] = &["node_modules/", "types.d.ts", "tsconfig.json"];
+
+/// The distribution of this machine, if it is one of `DISTROS`
+pub fn detect_distro() -> Option<&'static str> {
+    match current_platform() {
+        Platform::Linux(LinuxDistro::Ubuntu) => Some("ubuntu"),
+        Platform::Linux(LinuxDistro::Debian) => Some("debian"),
+        Platform::Linux(LinuxDistro::Fedora) => Some("fedora"),
+        Platform::Linux(LinuxDistro::Arch) => Some("arch"),
+        Platform::Linux(LinuxDistro::NixOS) => Some("nixos"),
+        Platform::MacOS => Some("macos"),
+        _ => None,
+    }
+}
+
+/// The login shell from `$SHELL`, if it is one of `SHELLS`
+pub fn detect_shell() -> Option<&'static str> {
+    let shell = std::env::var("SHELL").ok()?;
+    let name = Path::new(&shell).file_name()?.to_str()?;
+    SHELLS.iter().copied().find(|known| *known == name)
+}
+
+/// Write the scaffold into `dir`, returning the files written
+///
+/// An existing `dhd.config.ts` is an error unless `force` is set, which also
+/// replaces the sample module. A `.gitignore` already there only gets the
+/// lines it is missing.
+pub fn scaffold(
+    dir: &Path,
+    distro: &str,
+    shell: &str,
+    force: bool,
+) -> Result<Vec<PathBuf>, String> {
+    let manager = DISTROS
+        .iter()
+        .find(|(name, _)| *name == distro)
+        .map(|(_, manager)| *manager)
+        .ok_or_else(|| {
+            format!(
+                "Unknown distribution '{}'. Available distributions: {}",
+                distro,
+                DISTROS
+                    .iter()
+                    .map(|(name, _)| *name)
+                    .collect::<Vec<_>>()
+                    .join(", ")
+            )
+        })?;
+    if !SHELLS.contains(&shell) {
+        return Err(format!(
+            "Unknown shell '{}'. Available shells: {}",
+            shell,
+            SHELLS.join(", ")
+        ));
+    }
+
+    let config = dir.join(CONFIG_FILE);
+    if config.exists() && !force {
+        return Err(format!(
+            "{} already exists; pass --force to overwrite it",
+            config.display()
+        ));
+    }
+
+    fs::create_dir_all(dir).map_err(|e| format!("Failed to create {}: {}", dir.display(), e))?;
+    let mut written = Vec::new();
+
+    write(&config, &config_content(shell))?;
+    written.push(config);
+
+    let module = dir.join(SAMPLE_MODULE);
+    if force || !module.exists() {
+        write(&module, &module_content(manager, shell))?;
+        written.push(module);
+    }
+
+    let gitignore = dir.join(".gitignore");
+    let current = fs::read_to_string(&gitignore).unwrap_or_default();
+    let missing: Vec<&str> = IGNORED
+        .iter()
+        .copied()
+        .filter(|line| !current.lines().any(|existing| existing.trim() == *line))
+        .collect();
+    if !missing.is_empty() {
+        let mut content = current;
+        if !content.is_empty() && !content.ends_with('\n') {
+            content.push('\n');
+        }
+        content.push_str(&(missing.join("\n") + "\n"));
+        write(&gitignore, &content)?;
+        written.push(gitignore);
+    }
+
+    Ok(written)
+}
+
+fn write(path: &Path, content: &str) -> Result<(), String> {
+    fs::write(path, content).map_err(|e| format!("Failed to write {}: {}", path.display(), e))
+}
+
+fn config_content(shell: &str) -> String {
+    format!(
+        r#"export default defineConfig({{
+    // Seen by the templates of every module, e.g. {{{{ shell }}}}
+    variables: {{ shell: "{shell}" }},
+    // Modules of other directories or git repositories to apply too
+    imports: [],
+}});
+"#
+    )
+}
+
+fn module_content(manager: &str, shell: &str) -> String {
+    format!(
+        r#"export default defineModule("base")
+    .description("Command-line basics")
+    .tags("base")
+    .actions([
+        packageInstall({{ names: ["git", "curl", "{shell}"], manager: "{manager}" }}),
+    ]);
+"#
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_scaffold_writes_config_module_and_gitignore() {
+        let temp_dir = TempDir::new().unwrap();
+        let dir = temp_dir.path().join("dotfiles");
+
+        let written = scaffold(&dir, "fedora", "fish", false).unwrap();
+        assert_eq!(written.len(), 3);
+
+        let module = fs::read_to_string(dir.join("base.ts")).unwrap();
+        assert!(module.contains(r#"names: ["git", "curl", "fish"], manager: "dnf""#));
+        let config = fs::read_to_string(dir.join(CONFIG_FILE)).unwrap();
+        assert!(config.contains(r#"variables: { shell: "fish" }"#));
+        assert_eq!(
+            fs::read_to_string(dir.join(".gitignore")).unwrap(),
+            "node_modules/\ntypes.d.ts\ntsconfig.json\n"
+        );
+    }
+
+    #[test]
+    fn test_scaffold_keeps_an_existing_config_unless_forced() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(temp_dir.path().join(CONFIG_FILE), "mine").unwrap();
+        fs::write(temp_dir.path().join(".gitignore"), "target/\ntypes.d.ts").unwrap();
+
+        let error = scaffold(temp_dir.path(), "arch", "zsh", false).unwrap_err();
+        assert!(error.contains("pass --force"), "{}", error);
+        assert_eq!(
+            fs::read_to_string(temp_dir.path().join(CONFIG_FILE)).unwrap(),
+            "mine"
+        );
+
+        scaffold(temp_dir.path(), "arch", "zsh", true).unwrap();
+        assert_ne!(
+            fs::read_to_string(temp_dir.path().join(CONFIG_FILE)).unwrap(),
+            "mine"
+        );
+        assert_eq!(
+            fs::read_to_string(temp_dir.path().join(".gitignore")).unwrap(),
+            "target/\ntypes.d.ts\nnode_modules/\ntsconfig.json\n"
+        );
+    }
+
+    #[test]
+    fn test_scaffold_rejects_unknown_choices() {
+        let temp_dir = TempDir::new().unwrap();
+        assert!(scaffold(temp_dir.path(), "beos", "zsh", false).is_err());
+        assert!(scaffold(temp_dir.path(), "arch", "tcsh", false).is_err());
+        assert!(!temp_dir.path().join(CONFIG_FILE).exists());
+    }
+}
@@ -2,28 +2,65 @@ pub mod action;
 pub mod actions;
 pub mod atom;
 pub mod atoms;
+pub mod check;
+pub mod color;
+pub mod declarative;
 pub mod dag_executor;
 pub mod dependency_resolver;
+pub mod deprecation;
+pub mod diff;
 pub mod discovery;
+pub mod doctor;
+pub mod engine;
 pub mod error;
 pub mod execution;
+pub mod exit_code;
+pub mod explain;
+pub mod fmt;
+pub mod git_diff;
+pub mod history;
+pub mod imports;
+pub mod incremental;
+pub mod init;
 pub mod loader;
+pub mod logging;
 pub mod module;
+pub mod module_executor;
+pub mod overrides;
+pub mod paths;
+pub mod plan_file;
 pub mod platform;
+pub mod privilege;
+pub mod profile;
+pub mod report;
+pub mod schema;
 pub mod secrets;
+pub mod shell;
+pub mod state;
 pub mod system_info;
+pub mod target_user;
+pub mod template;
 pub mod typescript;
 pub mod utils;
+pub mod verify;
+pub mod version;
+pub mod watch;
 
 // Re-export the main types users need
 pub use action::{Action as ActionTrait, PlatformSelect};
 pub use actions::{Action, ActionType, ExecuteCommand, LinkFile, PackageInstall};
-pub use atom::Atom as AtomTrait;
+pub use atom::{Atom as AtomTrait, AtomStatus};
 pub use dag_executor::{DagExecutor, ExecutionSummary};
 pub use dependency_resolver::{DependencyError, resolve_dependencies};
+pub use diff::FileChange;
 pub use discovery::{DiscoveredModule, discover_modules};
+pub use engine::{Engine, Plan};
 pub use error::{DhdError, Result};
-pub use execution::ExecutionEngine;
+pub use execution::{
+    ApplyReport, ApplySummary, ExecutionEngine, ModuleDiff, ModulePlan, OUTPUT_SCHEMA_VERSION,
+    PlannedAtom, PlannedFile,
+};
 pub use loader::{LoadError, LoadedModule, load_module, load_modules};
-pub use module::{Module, ModuleDefinition};
+pub use module::{Module, ModuleDefinition, ModuleFilter};
+pub use module_executor::{ActionResult, ActionStatus, ModuleExecutor, ModuleJob, ModuleResult};
 pub use platform::{LinuxDistro, Platform, current_platform};
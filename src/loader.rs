@@ -1,15 +1,18 @@
 use crate::actions::{
-    ActionType, CopyFile, DconfImport, Directory, ExecuteCommand, GitConfig, HttpDownload,
-    InstallGnomeExtensions, LinkDirectory, LinkFile, PackageInstall, PackageRemove, SystemdManage,
-    SystemdService, SystemdSocket, Condition, ComparisonOperator,
+    ActionType, BlockInFile, CopyFile, Cron, DconfImport, DecryptFile, DesktopEntry, Directory, EnvVar, ExecuteCommand, GitConfig, GitRepo, GpgKey, HttpDownload,
+    InstallGnomeExtensions, LineInFile, LinkDirectory, LinkFile, NotifyAction, PackageInstall, PackageRemove, PackageRepo, Plugin, RemoteFile, RemoteFileVariant, ScriptInstall, ShellSource, SshConfig, SshHost, Stow, Symlink, TaggedAction, VerifiedAction, OrderedAction, RebootAction,
+    ShellCommand, SystemdManage, SystemdService, SystemdSocket, Template, TemplateDir, Condition, ComparisonOperator, HostFacts,
 };
 use crate::atoms::package::PackageManager;
 use crate::discovery::DiscoveredModule;
-use crate::module::ModuleDefinition;
+use crate::imports::{DhdConfig, HostGroup, HostProfile, Import, PackageManagerConfig};
+use crate::module::{Handler, Hook, ModuleDefinition, VariableSpec};
 use oxc_allocator::Allocator;
 use oxc_ast::ast::*;
 use oxc_parser::Parser;
 use oxc_span::SourceType;
+use std::cell::RefCell;
+use std::collections::HashMap;
 use std::fs;
 use std::str::FromStr;
 
@@ -38,7 +41,37 @@ impl std::fmt::Display for LoadError {
 
 impl std::error::Error for LoadError {}
 
+thread_local! {
+    /// Warnings of the module being loaded, while a caller collects them
+    static WARNINGS: RefCell<Option<Vec<String>>> = const { RefCell::new(None) };
+}
+
+/// Report a problem that doesn't stop the module from loading
+pub(crate) fn warn(message: String) {
+    let unclaimed = WARNINGS.with(|warnings| match warnings.borrow_mut().as_mut() {
+        Some(warnings) => {
+            warnings.push(message);
+            None
+        }
+        None => Some(message),
+    });
+    if let Some(message) = unclaimed {
+        eprintln!("⚠️  Warning: {}", message);
+    }
+}
+
+/// Load a module, returning its warnings instead of printing them
+pub fn load_module_with_warnings(
+    discovered: &DiscoveredModule,
+) -> (Result<LoadedModule, LoadError>, Vec<String>) {
+    WARNINGS.with(|warnings| *warnings.borrow_mut() = Some(Vec::new()));
+    let result = load_module(discovered);
+    let warnings = WARNINGS.with(|warnings| warnings.borrow_mut().take().unwrap_or_default());
+    (result, warnings)
+}
+
 pub fn load_module(discovered: &DiscoveredModule) -> Result<LoadedModule, LoadError> {
+    let _span = crate::profile::span("load", discovered.name.as_str());
     // Read the file content
     let content = fs::read_to_string(&discovered.path)
         .map_err(|e| LoadError::IoError(format!("Failed to read file: {}", e)))?;
@@ -47,10 +80,50 @@ pub fn load_module(discovered: &DiscoveredModule) -> Result<LoadedModule, LoadEr
         return Err(LoadError::ParseError("Empty file".to_string()));
     }
 
+    crate::deprecation::begin();
+    let mut module_def = match crate::declarative::module_file(&discovered.path) {
+        Some((name, format)) => crate::declarative::parse_module(&content, format, &name)?,
+        None => parse_typescript_module(&discovered.path, &content)?,
+    };
+    let deprecated = crate::deprecation::found();
+    if !deprecated.is_empty() {
+        if crate::deprecation::strict() {
+            return Err(LoadError::ValidationError(format!(
+                "{} (--strict)",
+                deprecated.join("; ")
+            )));
+        }
+        crate::deprecation::report(&module_def.name, &deprecated);
+    }
+    module_def.actions = crate::actions::order_actions(std::mem::take(&mut module_def.actions))
+        .map_err(LoadError::ValidationError)?;
+    if let Some(namespace) = &discovered.namespace {
+        apply_namespace(&mut module_def, namespace);
+    }
+    apply_variables(
+        &mut module_def,
+        &discovered.variables,
+        crate::overrides::get(),
+    )?;
+    apply_package_groups(&mut module_def, &discovered.package_groups);
+
+    warn_unknown_handlers(&module_def);
+
+    Ok(LoadedModule {
+        source: discovered.clone(),
+        definition: module_def,
+    })
+}
+
+/// The definition a TypeScript module exports
+fn parse_typescript_module(
+    path: &std::path::Path,
+    content: &str,
+) -> Result<ModuleDefinition, LoadError> {
     // Parse with oxc
     let allocator = Allocator::default();
-    let source_type = SourceType::from_path(&discovered.path).unwrap_or_default();
-    let ret = Parser::new(&allocator, &content, source_type).parse();
+    let source_type = SourceType::from_path(path).unwrap_or_default();
+    let ret = Parser::new(&allocator, content, source_type).parse();
 
     if !ret.errors.is_empty() {
         let error_msg = ret
@@ -67,16 +140,396 @@ pub fn load_module(discovered: &DiscoveredModule) -> Result<LoadedModule, LoadEr
 
     let program = ret.program;
 
+    // Before the actions, which a DHD too old for the module may not know
+    if let Some(required) = required_dhd_version(&program) {
+        crate::version::require(&required)
+            .map_err(|e| LoadError::ValidationError(format!("this module {}", e)))?;
+    }
+
     // Look for default export
-    let module_def = extract_module_definition(&program)
-        .ok_or_else(|| LoadError::ValidationError("No valid export default found".to_string()))?;
+    extract_module_definition(&program)
+        .ok_or_else(|| LoadError::ValidationError("No valid export default found".to_string()))
+}
 
-    Ok(LoadedModule {
-        source: discovered.clone(),
-        definition: module_def,
+/// Prefix an imported module's name, and dependencies on modules of the same
+/// import, with the import's namespace
+fn apply_namespace(module_def: &mut ModuleDefinition, namespace: &str) {
+    module_def.name = format!("{}/{}", namespace, module_def.name);
+    for dependency in &mut module_def.dependencies {
+        if !dependency.contains('/') {
+            *dependency = format!("{}/{}", namespace, dependency);
+        }
+    }
+}
+
+/// Load a `dhd.config.ts`, exporting `defineConfig({ imports, variables, hosts, ... })` or a
+/// plain object
+pub fn load_config(path: &std::path::Path) -> Result<DhdConfig, LoadError> {
+    let content = fs::read_to_string(path)
+        .map_err(|e| LoadError::IoError(format!("Failed to read file: {}", e)))?;
+
+    let allocator = Allocator::default();
+    let source_type = SourceType::from_path(path).unwrap_or_default();
+    let ret = Parser::new(&allocator, &content, source_type).parse();
+    if !ret.errors.is_empty() {
+        let error_msg = ret
+            .errors
+            .iter()
+            .map(|e| format!("{:?}", e))
+            .collect::<Vec<_>>()
+            .join(", ");
+        return Err(LoadError::ParseError(format!(
+            "Failed to parse TypeScript: {}",
+            error_msg
+        )));
+    }
+
+    let config = ret.program.body.iter().find_map(|stmt| match stmt {
+        Statement::ExportDefaultDeclaration(export) => export.declaration.as_expression(),
+        _ => None,
+    });
+    let config = match config {
+        Some(Expression::CallExpression(call)) => {
+            call.arguments.first().and_then(|arg| arg.as_expression())
+        }
+        other => other,
+    };
+    let Some(Expression::ObjectExpression(obj)) = config else {
+        return Err(LoadError::ValidationError(
+            "Expected export default defineConfig({ ... })".to_string(),
+        ));
+    };
+
+    let variables = match expression_to_json_from_obj(obj, "variables") {
+        Some(value) => Some(json_to_variables(&value).ok_or_else(|| {
+            LoadError::ValidationError("'variables' must be an object".to_string())
+        })?),
+        None => None,
+    };
+    let jobs = match get_number_prop(obj, "jobs") {
+        Some(jobs) if jobs < 1.0 || jobs.fract() != 0.0 => {
+            return Err(LoadError::ValidationError(format!(
+                "'jobs' must be a whole number of at least 1, got {}",
+                jobs
+            )));
+        }
+        jobs => jobs.map(|n| n as u32),
+    };
+    let history_limit = match get_number_prop(obj, "historyLimit") {
+        Some(limit) if limit < 0.0 || limit.fract() != 0.0 => {
+            return Err(LoadError::ValidationError(format!(
+                "'historyLimit' must be a whole number, got {}",
+                limit
+            )));
+        }
+        limit => limit.map(|n| n as u32),
+    };
+
+    let hosts = match expression_to_json_from_obj(obj, "hosts") {
+        Some(value) => Some(json_to_host_profiles(&value).ok_or_else(|| {
+            LoadError::ValidationError(
+                "'hosts' must map host names to { modules, tags, variables, groups }".to_string(),
+            )
+        })?),
+        None => None,
+    };
+
+    let groups = match expression_to_json_from_obj(obj, "groups") {
+        Some(value) => Some(json_to_host_groups(&value).ok_or_else(|| {
+            LoadError::ValidationError(
+                "'groups' must map group names to { hosts, modules, tags, variables }".to_string(),
+            )
+        })?),
+        None => None,
+    };
+    for (host, profile) in hosts.iter().flatten() {
+        for group in profile.groups.iter().flatten() {
+            if !groups
+                .as_ref()
+                .is_some_and(|groups| groups.contains_key(group))
+            {
+                return Err(LoadError::ValidationError(format!(
+                    "Host '{}' is in group '{}', which 'groups' doesn't define",
+                    host, group
+                )));
+            }
+        }
+    }
+
+    let package_groups = match expression_to_json_from_obj(obj, "packageGroups") {
+        Some(value) => Some(json_to_package_groups(&value).ok_or_else(|| {
+            LoadError::ValidationError(
+                "'packageGroups' must map group names to arrays of package names".to_string(),
+            )
+        })?),
+        None => None,
+    };
+
+    let package_managers = match expression_to_json_from_obj(obj, "packageManagers") {
+        Some(value) => Some(json_to_package_managers(&value).map_err(LoadError::ValidationError)?),
+        None => None,
+    };
+
+    let mut imports = Vec::new();
+    for prop in &obj.properties {
+        let ObjectPropertyKind::ObjectProperty(prop) = prop else {
+            continue;
+        };
+        let key = match &prop.key {
+            PropertyKey::StaticIdentifier(ident) => ident.name.as_str(),
+            PropertyKey::StringLiteral(lit) => lit.value.as_str(),
+            _ => continue,
+        };
+        if key != "imports" {
+            continue;
+        }
+        let Expression::ArrayExpression(arr) = &prop.value else {
+            return Err(LoadError::ValidationError(
+                "'imports' must be an array".to_string(),
+            ));
+        };
+
+        for (idx, elem) in arr.elements.iter().enumerate() {
+            let Some(Expression::ObjectExpression(obj)) = elem.as_expression() else {
+                return Err(LoadError::ValidationError(format!(
+                    "Import at index {} must be an object",
+                    idx
+                )));
+            };
+            let import = Import {
+                path: get_string_prop(obj, "path"),
+                git: get_string_prop(obj, "git"),
+                r#ref: get_string_prop(obj, "ref"),
+                namespace: get_string_prop(obj, "namespace"),
+            };
+            if import.path.is_some() == import.git.is_some() {
+                return Err(LoadError::ValidationError(format!(
+                    "Import at index {} needs exactly one of 'path' or 'git'",
+                    idx
+                )));
+            }
+            imports.push(import);
+        }
+    }
+
+    Ok(DhdConfig {
+        imports: Some(imports),
+        variables,
+        backup: get_bool_prop(obj, "backup"),
+        jobs,
+        incremental: get_bool_prop(obj, "incremental"),
+        hosts,
+        groups,
+        package_groups,
+        package_managers,
+        secret_env_prefix: get_string_prop(obj, "secretEnvPrefix"),
+        history_limit,
+        shell: get_string_prop(obj, "shell"),
+        disabled: get_array_of_strings(obj, "disabled"),
     })
 }
 
+/// Give every template of a module the config's and the module's variables,
+/// after checking them against the module's variable schema
+///
+/// `overrides` from the command line win over a template's own variables,
+/// which win over the module's, which win over the config's, which win over
+/// the schema's defaults. The variables and the
+/// host facts fill in the `{{ ... }}` of source and target paths too, so an
+/// unknown variable in a path fails the module before anything is written.
+fn apply_variables(
+    module_def: &mut ModuleDefinition,
+    config: &HashMap<String, String>,
+    overrides: &HashMap<String, String>,
+) -> Result<(), LoadError> {
+    fn render_path(
+        field: &str,
+        path: &mut String,
+        scope: &HashMap<String, String>,
+    ) -> Result<(), String> {
+        if !path.contains("{{") {
+            return Ok(());
+        }
+        let mut variables = crate::system_info::fact_variables();
+        variables.extend(scope.clone());
+        *path = crate::template::render(path, &variables)
+            .map_err(|e| format!("Failed to render {} '{}': {}", field, path, e))?;
+        Ok(())
+    }
+
+    fn apply(
+        action: &mut ActionType,
+        scope: &HashMap<String, String>,
+        overrides: &HashMap<String, String>,
+    ) -> Result<(), String> {
+        match action {
+            ActionType::Template(template) => {
+                let mut variables = scope.clone();
+                variables.extend(template.variables.take().unwrap_or_default());
+                variables.extend(overrides.clone());
+                if let Some(source) = &mut template.source {
+                    render_path("source", source, &variables)?;
+                }
+                render_path("target", &mut template.target, &variables)?;
+                template.variables = (!variables.is_empty()).then_some(variables);
+            }
+            ActionType::TemplateDir(template) => {
+                let mut variables = scope.clone();
+                variables.extend(template.variables.take().unwrap_or_default());
+                variables.extend(overrides.clone());
+                render_path("source", &mut template.source, &variables)?;
+                render_path("target", &mut template.target, &variables)?;
+                template.variables = (!variables.is_empty()).then_some(variables);
+            }
+            ActionType::CopyFile(copy) => {
+                if let Some(source) = &mut copy.source {
+                    render_path("source", source, scope)?;
+                }
+                render_path("target", &mut copy.target, scope)?;
+            }
+            ActionType::Symlink(symlink) => {
+                render_path("source", &mut symlink.source, scope)?;
+                render_path("target", &mut symlink.target, scope)?;
+            }
+            ActionType::LinkFile(link) => {
+                render_path("source", &mut link.source, scope)?;
+                render_path("target", &mut link.target, scope)?;
+            }
+            ActionType::LinkDirectory(link) => {
+                render_path("source", &mut link.source, scope)?;
+                render_path("target", &mut link.target, scope)?;
+            }
+            ActionType::DecryptFile(decrypt) => {
+                render_path("source", &mut decrypt.source, scope)?;
+                render_path("target", &mut decrypt.target, scope)?;
+            }
+            ActionType::RemoteFile(remote) => render_path("target", &mut remote.target, scope)?,
+            ActionType::Directory(directory) => render_path("path", &mut directory.path, scope)?,
+            ActionType::Conditional(conditional) => {
+                apply(&mut conditional.action, scope, overrides)?
+            }
+            ActionType::Notify(notify) => apply(&mut notify.action, scope, overrides)?,
+            ActionType::Tagged(tagged) => apply(&mut tagged.action, scope, overrides)?,
+            ActionType::Verified(verified) => apply(&mut verified.action, scope, overrides)?,
+            ActionType::Ordered(ordered) => apply(&mut ordered.action, scope, overrides)?,
+            ActionType::Reboot(reboot) => apply(&mut reboot.action, scope, overrides)?,
+            _ => {}
+        }
+        Ok(())
+    }
+
+    let mut scope: HashMap<String, String> = module_def
+        .variable_schema
+        .iter()
+        .filter_map(|(name, spec)| Some((name.clone(), spec.default.clone()?)))
+        .collect();
+    scope.extend(config.clone());
+    scope.extend(module_def.variables.clone());
+    scope.extend(overrides.clone());
+
+    // Masked from here on, wherever the value ends up being printed
+    let sensitive = module_def
+        .variable_schema
+        .iter()
+        .filter(|(_, spec)| spec.sensitive == Some(true))
+        .filter_map(|(name, _)| scope.get(name));
+    for value in sensitive {
+        crate::secrets::reveal(value);
+    }
+
+    let violations = module_def.check_variables(&scope);
+    if !violations.is_empty() {
+        return Err(LoadError::ValidationError(violations.join("; ")));
+    }
+
+    let handler_actions = module_def
+        .handlers
+        .iter_mut()
+        .flat_map(|handler| handler.actions.iter_mut());
+    for action in module_def.actions.iter_mut().chain(handler_actions) {
+        apply(action, &scope, overrides).map_err(LoadError::ValidationError)?;
+    }
+    Ok(())
+}
+
+/// Add the packages of the `groups` of a module's `packageInstall` actions
+/// that the config defines to their `names`
+///
+/// Groups the config doesn't define are left for the package manager.
+fn apply_package_groups(module_def: &mut ModuleDefinition, groups: &HashMap<String, Vec<String>>) {
+    fn apply(action: &mut ActionType, module: &str, groups: &HashMap<String, Vec<String>>) {
+        match action {
+            ActionType::PackageInstall(install) => {
+                let Some(requested) = install.groups.take() else {
+                    return;
+                };
+                let mut native = Vec::new();
+                for group in requested {
+                    let Some(packages) = groups.get(&group) else {
+                        native.push(group);
+                        continue;
+                    };
+                    log::info!(
+                        "{}: package group {} is {}",
+                        module,
+                        group,
+                        packages.join(", ")
+                    );
+                    for package in packages {
+                        if !install.names.contains(package) {
+                            install.names.push(package.clone());
+                        }
+                    }
+                }
+                if install.ensure.as_deref() == Some("absent") && !native.is_empty() {
+                    warn(format!(
+                        "module '{}' can't remove {}: only groups of packageGroups can be removed",
+                        module,
+                        native.join(", ")
+                    ));
+                }
+                install.groups = (!native.is_empty()).then_some(native);
+            }
+            ActionType::Conditional(conditional) => apply(&mut conditional.action, module, groups),
+            ActionType::Notify(notify) => apply(&mut notify.action, module, groups),
+            ActionType::Tagged(tagged) => apply(&mut tagged.action, module, groups),
+            ActionType::Verified(verified) => apply(&mut verified.action, module, groups),
+            ActionType::Ordered(ordered) => apply(&mut ordered.action, module, groups),
+            ActionType::Reboot(reboot) => apply(&mut reboot.action, module, groups),
+            _ => {}
+        }
+    }
+
+    let handler_actions = module_def
+        .handlers
+        .iter_mut()
+        .flat_map(|handler| handler.actions.iter_mut());
+    for action in module_def.actions.iter_mut().chain(handler_actions) {
+        apply(action, &module_def.name, groups);
+    }
+}
+
+/// Warn about `notify` names the module doesn't define a handler for
+fn warn_unknown_handlers(module_def: &ModuleDefinition) {
+    let defined: Vec<&str> = module_def
+        .handlers
+        .iter()
+        .map(|handler| handler.name.as_str())
+        .collect();
+    for action in &module_def.actions {
+        if let ActionType::Notify(notify) = action {
+            for name in &notify.handlers {
+                if !defined.contains(&name.as_str()) {
+                    warn(format!(
+                        "module '{}' notifies unknown handler '{}'",
+                        module_def.name, name
+                    ));
+                }
+            }
+        }
+    }
+}
+
 fn extract_module_definition(program: &Program) -> Option<ModuleDefinition> {
     // Look for two patterns:
     // 1. export default defineModule("name").description("...").actions([...])
@@ -101,6 +554,32 @@ fn extract_module_definition(program: &Program) -> Option<ModuleDefinition> {
     None
 }
 
+/// The DHD version the default export asks for with `.requiresDhd("0.2.0")`
+/// or `requiresDhd: "0.2.0"`
+fn required_dhd_version(program: &Program) -> Option<String> {
+    let export = program.body.iter().find_map(|stmt| match stmt {
+        Statement::ExportDefaultDeclaration(export) => export.declaration.as_expression(),
+        _ => None,
+    })?;
+    if let Expression::ObjectExpression(obj) = export {
+        return get_string_prop(obj, "requiresDhd");
+    }
+
+    let mut current_expr = export;
+    while let Expression::CallExpression(call) = current_expr {
+        let member = call.callee.as_member_expression()?;
+        if get_property_name(member).as_deref() == Some("requiresDhd") {
+            if let Some(Expression::StringLiteral(lit)) =
+                call.arguments.first().and_then(|arg| arg.as_expression())
+            {
+                return Some(lit.value.to_string());
+            }
+        }
+        current_expr = member.object();
+    }
+    None
+}
+
 fn parse_fluent_api(expr: &Expression) -> Option<ModuleDefinition> {
     // Parse defineModule("name").description("...").actions([...])
     // We expect the outermost expression to be the last method call in the chain
@@ -111,7 +590,13 @@ fn parse_fluent_api(expr: &Expression) -> Option<ModuleDefinition> {
         tags: Vec::new(),
         dependencies: Vec::new(),
         when: None,
+        enabled: true,
         actions: Vec::new(),
+        pre_apply: None,
+        post_apply: None,
+        handlers: Vec::new(),
+        variables: HashMap::new(),
+        variable_schema: HashMap::new(),
     };
 
     // Start from the outermost call and work inward
@@ -163,10 +648,20 @@ fn parse_fluent_api(expr: &Expression) -> Option<ModuleDefinition> {
                 }
             }
             "tags" => {
-                // Parse tags - can be multiple string arguments
+                // Parse tags - can be an array or multiple string arguments
                 for arg in args.iter() {
-                    if let Some(Expression::StringLiteral(lit)) = arg.as_expression() {
-                        module_def.tags.push(lit.value.to_string());
+                    match arg.as_expression() {
+                        Some(Expression::StringLiteral(lit)) => {
+                            module_def.tags.push(lit.value.to_string());
+                        }
+                        Some(Expression::ArrayExpression(arr)) => {
+                            for elem in &arr.elements {
+                                if let Some(Expression::StringLiteral(lit)) = elem.as_expression() {
+                                    module_def.tags.push(lit.value.to_string());
+                                }
+                            }
+                        }
+                        _ => {}
                     }
                 }
             }
@@ -195,15 +690,85 @@ fn parse_fluent_api(expr: &Expression) -> Option<ModuleDefinition> {
                     }
                 }
             }
+            "enabled" => match args.first().and_then(|arg| arg.as_expression()) {
+                Some(Expression::BooleanLiteral(lit)) => module_def.enabled = lit.value,
+                _ => warn(format!(
+                    "enabled in module '{}' needs true or false",
+                    module_def.name
+                )),
+            },
+            "preApply" | "postApply" => {
+                let hook = args
+                    .first()
+                    .and_then(|arg| arg.as_expression())
+                    .and_then(parse_hook);
+                if hook.is_none() {
+                    warn(format!(
+                        "{} in module '{}' needs a command or {{ run: ... }}",
+                        method_name, module_def.name
+                    ));
+                }
+                if method_name == "preApply" {
+                    module_def.pre_apply = hook;
+                } else {
+                    module_def.post_apply = hook;
+                }
+            }
+            "handlers" => {
+                if let Some(Expression::ObjectExpression(obj)) =
+                    args.first().and_then(|arg| arg.as_expression())
+                {
+                    module_def.handlers = parse_handlers(obj, &module_def.name);
+                }
+            }
+            "variables" => {
+                let variables = args
+                    .first()
+                    .and_then(|arg| arg.as_expression())
+                    .and_then(expression_to_json)
+                    .and_then(|value| json_to_variables(&value));
+                match variables {
+                    Some(variables) => module_def.variables = variables,
+                    None => warn(format!(
+                        "variables in module '{}' needs an object of values",
+                        module_def.name
+                    )),
+                }
+            }
+            "variableSchema" => {
+                let schema = args
+                    .first()
+                    .and_then(|arg| arg.as_expression())
+                    .and_then(expression_to_json)
+                    .and_then(|value| json_to_variable_schema(&value));
+                match schema {
+                    Some(schema) => module_def.variable_schema = schema,
+                    None => warn(format!(
+                        "variableSchema in module '{}' needs an object of {{ type, required, default, sensitive }}",
+                        module_def.name
+                    )),
+                }
+            }
             "actions" => {
                 if args.len() == 1 {
                     if let Some(Expression::ArrayExpression(arr)) = args[0].as_expression() {
                         for (idx, elem) in arr.elements.iter().enumerate() {
                             if let Some(action_expr) = elem.as_expression() {
+                                if let Some(expanded) =
+                                    for_each_actions(action_expr, &module_def.name, idx)
+                                {
+                                    module_def.actions.extend(expanded);
+                                    continue;
+                                }
                                 match parse_action_call(action_expr) {
-                                    Ok(action) => module_def.actions.push(action),
+                                    Ok(action) => {
+                                        module_def.actions.push(with_options(action, action_expr))
+                                    }
                                     Err(err) => {
-                                        eprintln!("⚠️  Warning: Failed to parse action at index {} in module '{}': {}", idx, module_def.name, err);
+                                        warn(format!(
+                                            "Failed to parse action at index {} in module '{}': {}",
+                                            idx, module_def.name, err
+                                        ));
                                     }
                                 }
                             }
@@ -239,14 +804,58 @@ fn parse_action_call(expr: &Expression) -> Result<ActionType, String> {
 
             if call.arguments.len() == 1 {
                 if let Some(Expression::ObjectExpression(obj)) = call.arguments[0].as_expression() {
+                    crate::deprecation::check(action_name, &prop_names(obj));
                     match action_name {
                         "packageInstall" => {
+                            let groups = get_string_array_prop(obj, "groups");
                             let names = get_string_array_prop(obj, "names")
-                                .ok_or_else(|| format!("packageInstall requires 'names' array property"))?;
+                                .or_else(|| groups.as_ref().map(|_| Vec::new()))
+                                .ok_or_else(|| format!("packageInstall requires 'names' or 'groups' array property"))?;
                             let manager = get_package_manager(obj, "manager");
+                            let aur = get_bool_prop(obj, "aur");
+                            let remote = get_string_prop(obj, "remote");
+                            let scope = get_string_prop(obj, "scope");
+                            let casks = get_string_array_prop(obj, "casks");
+                            let taps = get_string_array_prop(obj, "taps");
+                            let flake = get_string_prop(obj, "flake");
+                            let classic = get_bool_prop(obj, "classic");
+                            let channel = get_string_prop(obj, "channel");
+                            let overrides = expression_to_json_from_obj(obj, "overrides")
+                                .and_then(|v| json_to_package_overrides(&v));
+                            let ensure = get_string_prop(obj, "ensure");
+                            if let Some(ensure) = &ensure {
+                                if !["present", "latest", "absent"].contains(&ensure.as_str()) {
+                                    return Err(format!("packageInstall 'ensure' must be \"present\", \"latest\" or \"absent\", got '{}'", ensure));
+                                }
+                            }
                             return Ok(ActionType::PackageInstall(PackageInstall {
                                 names,
                                 manager,
+                                aur,
+                                remote,
+                                scope,
+                                casks,
+                                taps,
+                                flake,
+                                classic,
+                                channel,
+                                overrides,
+                                ensure,
+                                retries: get_number_prop(obj, "retries").map(|n| n as u32),
+                                retry_delay: get_number_prop(obj, "retryDelay").map(|n| n as u64),
+                                groups,
+                            }));
+                        }
+                        "packageRemove" => {
+                            let names = get_string_array_prop(obj, "names")
+                                .ok_or_else(|| format!("packageRemove requires 'names' array property"))?;
+                            let manager = get_package_manager(obj, "manager");
+                            let overrides = expression_to_json_from_obj(obj, "overrides")
+                                .and_then(|v| json_to_package_overrides(&v));
+                            return Ok(ActionType::PackageRemove(PackageRemove {
+                                names,
+                                manager,
+                                overrides,
                             }));
                         }
                         "linkDotfile" | "linkFile" => {
@@ -264,6 +873,19 @@ fn parse_action_call(expr: &Expression) -> Result<ActionType, String> {
                                 force,
                             }));
                         }
+                        "symlink" => {
+                            let source = get_string_prop(obj, "source")
+                                .ok_or_else(|| format!("symlink requires 'source' property"))?;
+                            let target = get_string_prop(obj, "target")
+                                .ok_or_else(|| format!("symlink requires 'target' property"))?;
+                            let force = get_bool_prop(obj, "force");
+                            return Ok(ActionType::Symlink(Symlink {
+                                source,
+                                target,
+                                force,
+                                ensure: file_ensure(obj, "symlink")?,
+                            }));
+                        }
                         "linkDirectory" => {
                             let source = get_string_prop(obj, "source")
                                 .or_else(|| get_string_prop(obj, "from"))
@@ -294,25 +916,39 @@ fn parse_action_call(expr: &Expression) -> Result<ActionType, String> {
                             }));
                         }
                         "copyFile" => {
-                            let source = get_string_prop(obj, "source")
-                                .ok_or_else(|| format!("copyFile requires 'source' property"))?;
+                            let (source, content) = file_content(obj, "copyFile")?;
                             let target = get_string_prop(obj, "target")
                                 .or_else(|| get_string_prop(obj, "destination"))
                                 .ok_or_else(|| format!("copyFile requires 'target' or 'destination' property"))?;
                             let escalate = get_bool_prop(obj, "escalate")
                                 .or_else(|| get_bool_prop(obj, "requiresPrivilegeEscalation"))
                                 .unwrap_or(false);
+                            let mode = get_number_prop(obj, "mode").map(|n| n as u32);
+                            let owner = get_string_prop(obj, "owner");
+                            let group = get_string_prop(obj, "group");
                             return Ok(ActionType::CopyFile(CopyFile {
                                 source,
+                                content,
                                 target,
                                 escalate,
+                                mode,
+                                owner,
+                                group,
+                                create_parents: get_bool_prop(obj, "createParents"),
+                                ensure: file_ensure(obj, "copyFile")?,
                             }));
                         }
-                        "directory" => {
+                        "directory" | "ensureDir" => {
                             let path = get_string_prop(obj, "path")
-                                .ok_or_else(|| format!("directory requires 'path' property"))?;
-                            let escalate = get_bool_prop(obj, "escalate");
-                            return Ok(ActionType::Directory(Directory { path, escalate }));
+                                .ok_or_else(|| format!("{} requires 'path' property", action_name))?;
+                            return Ok(ActionType::Directory(Directory {
+                                path,
+                                escalate: get_bool_prop(obj, "escalate"),
+                                mode: get_number_prop(obj, "mode").map(|n| n as u32),
+                                recursive: get_bool_prop(obj, "recursive"),
+                                owner: get_string_prop(obj, "owner"),
+                                group: get_string_prop(obj, "group"),
+                            }));
                         }
                         "httpDownload" => {
                             let url = get_string_prop(obj, "url")
@@ -326,29 +962,43 @@ fn parse_action_call(expr: &Expression) -> Result<ActionType, String> {
                                 destination,
                                 checksum,
                                 mode,
+                                retries: get_number_prop(obj, "retries").map(|n| n as u32),
+                                retry_delay: get_number_prop(obj, "retryDelay").map(|n| n as u64),
                             }));
                         }
                         "systemdService" => {
                             let name = get_string_prop(obj, "name")
                                 .ok_or_else(|| format!("systemdService requires 'name' property"))?;
-                            let description = get_string_prop(obj, "description")
-                                .ok_or_else(|| format!("systemdService requires 'description' property"))?;
-                            let exec_start = get_string_prop(obj, "execStart")
-                                .ok_or_else(|| format!("systemdService requires 'execStart' property"))?;
-                            let service_type = get_string_prop(obj, "serviceType")
-                                .ok_or_else(|| format!("systemdService requires 'serviceType' property"))?;
-                            let scope = get_string_prop(obj, "scope")
-                                .ok_or_else(|| format!("systemdService requires 'scope' property"))?;
-                            let restart = get_string_prop(obj, "restart");
-                            let restart_sec = get_number_prop(obj, "restartSec").map(|n| n as u32);
+                            let unit = get_string_prop(obj, "unit");
+                            let exec_start = get_string_prop(obj, "execStart");
+                            if unit.is_none() && exec_start.is_none() {
+                                return Err(format!("systemdService requires 'unit' or 'execStart' property"));
+                            }
+                            let scope = get_string_prop(obj, "scope");
+                            if let Some(scope) = &scope {
+                                if scope != "user" && scope != "system" {
+                                    return Err(format!("systemdService 'scope' must be 'user' or 'system', got '{}'", scope));
+                                }
+                            }
+                            let action = get_string_prop(obj, "action");
+                            if let Some(action) = &action {
+                                crate::atoms::systemd_service::Refresh::parse(action)
+                                    .map_err(|e| format!("systemdService {}", e))?;
+                            }
                             return Ok(ActionType::SystemdService(SystemdService {
                                 name,
-                                description,
+                                description: get_string_prop(obj, "description"),
                                 exec_start,
-                                service_type,
+                                service_type: get_string_prop(obj, "serviceType"),
                                 scope,
-                                restart,
-                                restart_sec,
+                                restart: get_string_prop(obj, "restart"),
+                                restart_sec: get_number_prop(obj, "restartSec").map(|n| n as u32),
+                                unit,
+                                timer: get_string_prop(obj, "timer"),
+                                enable: get_bool_prop(obj, "enable"),
+                                start: get_bool_prop(obj, "start"),
+                                action,
+                                notified: false,
                             }));
                         }
                         "systemdSocket" => {
@@ -384,81 +1034,496 @@ fn parse_action_call(expr: &Expression) -> Result<ActionType, String> {
                             let global = expression_to_json_from_obj(obj, "global");
                             let system = expression_to_json_from_obj(obj, "system");
                             let local = expression_to_json_from_obj(obj, "local");
+                            let entries = expression_to_json_from_obj(obj, "entries")
+                                .or_else(|| expression_to_json_from_obj(obj, "settings"));
                             
                             // Validate that at least one scope is provided
-                            if global.is_none() && system.is_none() && local.is_none() {
-                                return Err(format!("gitConfig requires at least one of 'global', 'system', or 'local' properties"));
+                            if global.is_none() && system.is_none() && local.is_none() && entries.is_none() {
+                                return Err(format!("gitConfig requires 'entries' or at least one of 'global', 'system', or 'local' properties"));
+                            }
+                            let scope = get_string_prop(obj, "scope");
+                            if let Some(scope) = &scope {
+                                crate::actions::git_config::parse_scope(scope)
+                                    .map_err(|e| format!("gitConfig: {}", e))?;
+                            }
+                            let ensure = get_string_prop(obj, "ensure");
+                            if let Some(ensure) = ensure.as_deref().filter(|e| !matches!(*e, "present" | "absent")) {
+                                return Err(format!("gitConfig: 'ensure' must be 'present' or 'absent', got '{}'", ensure));
                             }
                             
                             return Ok(ActionType::GitConfig(GitConfig {
                                 global,
                                 system,
                                 local,
+                                entries,
+                                scope,
+                                path: get_string_prop(obj, "path"),
+                                ensure,
                             }));
                         }
-                        _ => {
-                            return Err(format!("Unknown action type: '{}'. Available actions: packageInstall, linkFile, linkDirectory, executeCommand, copyFile, directory, httpDownload, systemdService, systemdSocket, systemdManage, packageRemove, dconfImport, installGnomeExtensions, gitConfig", action_name));
+                        "template" => {
+                            let (source, content) = file_content(obj, "template")?;
+                            let target = get_string_prop(obj, "target")
+                                .ok_or_else(|| format!("template requires 'target' property"))?;
+                            let variables = expression_to_json_from_obj(obj, "variables")
+                                .and_then(|v| json_to_variables(&v));
+                            return Ok(ActionType::Template(Template {
+                                source,
+                                content,
+                                target,
+                                variables,
+                                ensure: file_ensure(obj, "template")?,
+                            }));
                         }
-                    }
-                } else {
-                    return Err(format!("Action '{}' requires an object as argument", action_name));
-                }
-            } else {
-                return Err(format!("Action '{}' requires exactly one argument", action_name));
-            }
-        } else {
-            return Err(format!("Invalid action call: expected function call"));
-        }
-    } else {
-        return Err(format!("Invalid expression: expected function call"));
-    }
-}
-
-fn get_string_prop(obj: &ObjectExpression, key: &str) -> Option<String> {
-    for prop in &obj.properties {
-        if let ObjectPropertyKind::ObjectProperty(prop) = prop {
-            let prop_key = match &prop.key {
-                PropertyKey::StaticIdentifier(ident) => ident.name.as_str(),
-                PropertyKey::StringLiteral(lit) => lit.value.as_str(),
-                _ => continue,
-            };
-
-            if prop_key == key {
-                if let Expression::StringLiteral(lit) = &prop.value {
-                    return Some(lit.value.to_string());
-                }
-            }
-        }
-    }
-    None
-}
-
-fn get_string_array_prop(obj: &ObjectExpression, key: &str) -> Option<Vec<String>> {
-    for prop in &obj.properties {
-        if let ObjectPropertyKind::ObjectProperty(prop) = prop {
-            let prop_key = match &prop.key {
-                PropertyKey::StaticIdentifier(ident) => ident.name.as_str(),
-                PropertyKey::StringLiteral(lit) => lit.value.as_str(),
-                _ => continue,
-            };
-
-            if prop_key == key {
-                if let Expression::ArrayExpression(arr) = &prop.value {
-                    let mut strings = Vec::new();
-                    for elem in &arr.elements {
-                        if let Some(Expression::StringLiteral(lit)) = elem.as_expression() {
-                            strings.push(lit.value.to_string());
+                        "templateDir" => {
+                            let source = get_string_prop(obj, "source")
+                                .ok_or_else(|| format!("templateDir requires 'source' property"))?;
+                            let target = get_string_prop(obj, "target")
+                                .ok_or_else(|| format!("templateDir requires 'target' property"))?;
+                            let variables = expression_to_json_from_obj(obj, "variables")
+                                .and_then(|v| json_to_variables(&v));
+                            return Ok(ActionType::TemplateDir(TemplateDir {
+                                source,
+                                target,
+                                variables,
+                                extension: get_string_prop(obj, "extension"),
+                            }));
                         }
-                    }
-                    return Some(strings);
-                }
-            }
-        }
-    }
-    None
-}
-
-fn get_array_of_strings(obj: &ObjectExpression, key: &str) -> Option<Vec<String>> {
+                        "decryptFile" => {
+                            let source = get_string_prop(obj, "source")
+                                .ok_or_else(|| format!("decryptFile requires 'source' property"))?;
+                            let target = get_string_prop(obj, "target")
+                                .ok_or_else(|| format!("decryptFile requires 'target' property"))?;
+                            return Ok(ActionType::DecryptFile(DecryptFile {
+                                source,
+                                target,
+                                mode: get_number_prop(obj, "mode").map(|n| n as u32),
+                                identity: get_string_prop(obj, "identity"),
+                            }));
+                        }
+                        "envVar" => {
+                            let name = get_string_prop(obj, "name");
+                            let value = get_string_prop(obj, "value");
+                            let path_prepend = get_string_prop(obj, "pathPrepend");
+                            if name.is_some() != value.is_some() {
+                                return Err(format!("envVar requires both 'name' and 'value' properties"));
+                            }
+                            if name.is_none() && path_prepend.is_none() {
+                                return Err(format!("envVar requires 'name' and 'value', or 'pathPrepend'"));
+                            }
+                            let shells = get_string_array_prop(obj, "shells");
+                            for shell in shells.iter().flatten() {
+                                shell.parse::<crate::atoms::env_var::Shell>()?;
+                            }
+                            return Ok(ActionType::EnvVar(EnvVar {
+                                name,
+                                value,
+                                path_prepend,
+                                shells,
+                                wire: get_bool_prop(obj, "wire"),
+                            }));
+                        }
+                        "shellSource" => {
+                            let shells = get_string_array_prop(obj, "shells");
+                            for shell in shells.iter().flatten() {
+                                shell.parse::<crate::atoms::env_var::Shell>()?;
+                            }
+                            let ensure = get_string_prop(obj, "ensure");
+                            if let Some(ensure) = ensure.as_deref() {
+                                if ensure != "present" && ensure != "absent" {
+                                    return Err(format!("shellSource 'ensure' must be \"present\" or \"absent\", not \"{}\"", ensure));
+                                }
+                            }
+                            return Ok(ActionType::ShellSource(ShellSource {
+                                file: get_string_prop(obj, "file"),
+                                shells,
+                                ensure,
+                            }));
+                        }
+                        "sshConfig" => {
+                            let hosts = expression_to_json_from_obj(obj, "hosts")
+                                .ok_or_else(|| format!("sshConfig requires 'hosts' property"))?;
+                            return Ok(ActionType::SshConfig(SshConfig {
+                                hosts: json_to_ssh_hosts(&hosts)?,
+                                path: get_string_prop(obj, "path"),
+                            }));
+                        }
+                        "blockInFile" => {
+                            let path = get_string_prop(obj, "path")
+                                .ok_or_else(|| format!("blockInFile requires 'path' property"))?;
+                            let name = get_string_prop(obj, "name")
+                                .ok_or_else(|| format!("blockInFile requires 'name' property"))?;
+                            let content = get_string_prop(obj, "content");
+                            let absent = get_bool_prop(obj, "absent");
+                            if content.is_none() && absent != Some(true) {
+                                return Err(format!("blockInFile requires 'content' property unless 'absent' is set"));
+                            }
+                            return Ok(ActionType::BlockInFile(BlockInFile {
+                                path,
+                                name,
+                                content,
+                                absent,
+                                escalate: get_bool_prop(obj, "escalate"),
+                            }));
+                        }
+                        "lineInFile" => {
+                            let path = get_string_prop(obj, "path")
+                                .ok_or_else(|| format!("lineInFile requires 'path' property"))?;
+                            let line = get_string_prop(obj, "line")
+                                .ok_or_else(|| format!("lineInFile requires 'line' property"))?;
+                            if line.contains('\n') {
+                                return Err(format!("lineInFile 'line' must be a single line; use blockInFile for several"));
+                            }
+                            return Ok(ActionType::LineInFile(LineInFile {
+                                path,
+                                line,
+                                absent: get_bool_prop(obj, "absent"),
+                                escalate: get_bool_prop(obj, "escalate"),
+                            }));
+                        }
+                        "remoteFile" => {
+                            let url = get_string_prop(obj, "url")
+                                .ok_or_else(|| format!("remoteFile requires 'url' property"))?;
+                            let target = get_string_prop(obj, "target")
+                                .ok_or_else(|| format!("remoteFile requires 'target' property"))?;
+                            let sha256 = get_string_prop(obj, "sha256");
+                            let arch = match expression_to_json_from_obj(obj, "arch") {
+                                Some(value) => Some(json_to_remote_file_variants(&value).ok_or_else(|| {
+                                    format!("remoteFile 'arch' must map architectures to objects with 'url', 'sha256' or 'extract'")
+                                })?),
+                                None => None,
+                            };
+                            let variants = arch.iter().flat_map(|arch| arch.values());
+                            for sha256 in sha256.iter().chain(variants.filter_map(|v| v.sha256.as_ref())) {
+                                if sha256.len() != 64
+                                    || !sha256.chars().all(|c| c.is_ascii_hexdigit())
+                                {
+                                    return Err(format!(
+                                        "remoteFile 'sha256' must be 64 hex digits, got '{}'",
+                                        sha256
+                                    ));
+                                }
+                            }
+                            return Ok(ActionType::RemoteFile(RemoteFile {
+                                url,
+                                target,
+                                sha256,
+                                mode: get_number_prop(obj, "mode").map(|n| n as u32),
+                                extract: get_string_prop(obj, "extract"),
+                                retries: get_number_prop(obj, "retries").map(|n| n as u32),
+                                retry_delay: get_number_prop(obj, "retryDelay").map(|n| n as u64),
+                                arch,
+                            }));
+                        }
+                        "scriptInstall" => {
+                            let url = get_string_prop(obj, "url")
+                                .ok_or_else(|| format!("scriptInstall requires 'url' property"))?;
+                            let sha256 = get_string_prop(obj, "sha256").ok_or_else(|| {
+                                format!("scriptInstall requires 'sha256' property")
+                            })?;
+                            if sha256.len() != 64 || !sha256.chars().all(|c| c.is_ascii_hexdigit())
+                            {
+                                return Err(format!(
+                                    "scriptInstall 'sha256' must be 64 hex digits, got '{}'",
+                                    sha256
+                                ));
+                            }
+                            return Ok(ActionType::ScriptInstall(ScriptInstall {
+                                url,
+                                sha256,
+                                shell: get_string_prop(obj, "shell"),
+                                args: get_array_of_strings(obj, "args"),
+                                env: get_hashmap_prop(obj, "env"),
+                                version: get_string_prop(obj, "version"),
+                                escalate: get_bool_prop(obj, "escalate"),
+                            }));
+                        }
+                        "cron" => {
+                            let name = get_string_prop(obj, "name")
+                                .ok_or_else(|| format!("cron requires 'name' property"))?;
+                            let schedule = get_string_prop(obj, "schedule")
+                                .ok_or_else(|| format!("cron requires 'schedule' property"))?;
+                            let command = get_string_prop(obj, "command")
+                                .ok_or_else(|| format!("cron requires 'command' property"))?;
+                            crate::atoms::cron::validate_schedule(&schedule)?;
+                            if name.is_empty() || name.contains(char::is_whitespace) {
+                                return Err(format!(
+                                    "cron 'name' must be a single word, got '{}'",
+                                    name
+                                ));
+                            }
+                            let ensure = get_string_prop(obj, "ensure");
+                            if let Some(ensure) = &ensure {
+                                if ensure != "present" && ensure != "absent" {
+                                    return Err(format!(
+                                        "cron 'ensure' must be \"present\" or \"absent\", got '{}'",
+                                        ensure
+                                    ));
+                                }
+                            }
+                            let backend = get_string_prop(obj, "backend");
+                            if let Some(backend) = &backend {
+                                if backend != "crontab" && backend != "systemd" {
+                                    return Err(format!(
+                                        "cron 'backend' must be \"crontab\" or \"systemd\", got '{}'",
+                                        backend
+                                    ));
+                                }
+                            }
+                            return Ok(ActionType::Cron(Cron {
+                                name,
+                                schedule,
+                                command,
+                                user: get_string_prop(obj, "user"),
+                                ensure,
+                                backend,
+                            }));
+                        }
+                        "desktopEntry" => {
+                            let name = get_string_prop(obj, "name")
+                                .ok_or_else(|| format!("desktopEntry requires 'name' property"))?;
+                            let exec = get_string_prop(obj, "exec")
+                                .ok_or_else(|| format!("desktopEntry requires 'exec' property"))?;
+                            let entry = DesktopEntry {
+                                name,
+                                exec,
+                                icon: get_string_prop(obj, "icon"),
+                                categories: get_array_of_strings(obj, "categories"),
+                                comment: get_string_prop(obj, "comment"),
+                                terminal: get_bool_prop(obj, "terminal"),
+                                id: get_string_prop(obj, "id"),
+                                ensure: file_ensure(obj, "desktopEntry")?,
+                            };
+                            let id = entry.file_id();
+                            if id.is_empty() || id.contains('/') {
+                                return Err(format!(
+                                    "desktopEntry 'id' must be a file name without '/', got '{}'",
+                                    id
+                                ));
+                            }
+                            return Ok(ActionType::DesktopEntry(entry));
+                        }
+                        "plugin" => {
+                            let name = get_string_prop(obj, "name")
+                                .ok_or_else(|| format!("plugin requires 'name' property"))?;
+                            return Ok(ActionType::Plugin(Plugin {
+                                name,
+                                command: get_string_prop(obj, "command"),
+                                spec: expression_to_json_from_obj(obj, "spec"),
+                                description: get_string_prop(obj, "description"),
+                            }));
+                        }
+                        "stow" => {
+                            let source = get_string_prop(obj, "source")
+                                .ok_or_else(|| format!("stow requires 'source' property"))?;
+                            let adopt = get_bool_prop(obj, "adopt");
+                            let force = get_bool_prop(obj, "force");
+                            if adopt == Some(true) && force == Some(true) {
+                                return Err(format!("stow takes 'adopt' or 'force', not both"));
+                            }
+                            return Ok(ActionType::Stow(Stow {
+                                source,
+                                target: get_string_prop(obj, "target"),
+                                delete: get_bool_prop(obj, "delete"),
+                                adopt,
+                                force,
+                            }));
+                        }
+                        "gpgKey" => {
+                            let key_id = get_string_prop(obj, "keyId");
+                            let key_url = get_string_prop(obj, "keyUrl");
+                            let key_file = get_string_prop(obj, "keyFile");
+                            let sources = [&key_id, &key_url, &key_file]
+                                .iter()
+                                .filter(|source| source.is_some())
+                                .count();
+                            if sources == 0 {
+                                return Err(format!(
+                                    "gpgKey requires 'keyId', 'keyUrl' or 'keyFile' property"
+                                ));
+                            }
+                            if key_url.is_some() && key_file.is_some() {
+                                return Err(format!(
+                                    "gpgKey takes either 'keyUrl' or 'keyFile', not both"
+                                ));
+                            }
+                            let trust = get_string_prop(obj, "trust");
+                            if let Some(trust) = &trust {
+                                if !crate::atoms::gpg_key::is_trust_level(trust) {
+                                    return Err(format!(
+                                        "gpgKey 'trust' must be one of {}, got '{}'",
+                                        crate::atoms::gpg_key::trust_levels().join(", "),
+                                        trust
+                                    ));
+                                }
+                            }
+                            let keyring = get_string_prop(obj, "keyring");
+                            if trust.is_some() && keyring.is_some() {
+                                return Err(format!(
+                                    "gpgKey 'trust' only applies to your own keyring, not to 'keyring' files"
+                                ));
+                            }
+                            return Ok(ActionType::GpgKey(GpgKey {
+                                key_id,
+                                key_url,
+                                key_file,
+                                keyserver: get_string_prop(obj, "keyserver"),
+                                trust,
+                                keyring,
+                                escalate: get_bool_prop(obj, "escalate"),
+                                retries: get_number_prop(obj, "retries").map(|n| n as u32),
+                                retry_delay: get_number_prop(obj, "retryDelay").map(|n| n as u64),
+                            }));
+                        }
+                        "packageRepo" => {
+                            let name = get_string_prop(obj, "name")
+                                .ok_or_else(|| format!("packageRepo requires 'name' property"))?;
+                            let uri = get_string_prop(obj, "uri")
+                                .ok_or_else(|| format!("packageRepo requires 'uri' property"))?;
+                            if name.is_empty()
+                                || name.contains(|c: char| c.is_whitespace() || c == '/')
+                            {
+                                return Err(format!(
+                                    "packageRepo 'name' must be a single word, got '{}'",
+                                    name
+                                ));
+                            }
+                            let manager = get_string_prop(obj, "manager");
+                            if let Some(manager) = &manager {
+                                if crate::atoms::package_repo::RepoBackend::from_manager(manager)
+                                    .is_none()
+                                {
+                                    return Err(format!(
+                                        "packageRepo 'manager' must be \"apt\", \"dnf\" or \"pacman\", got '{}'",
+                                        manager
+                                    ));
+                                }
+                            }
+                            return Ok(ActionType::PackageRepo(PackageRepo {
+                                name,
+                                uri,
+                                key: get_string_prop(obj, "key"),
+                                components: get_string_array_prop(obj, "components"),
+                                distro: get_string_prop(obj, "distro"),
+                                manager,
+                            }));
+                        }
+                        "gitRepo" => {
+                            let url = get_string_prop(obj, "url")
+                                .ok_or_else(|| format!("gitRepo requires 'url' property"))?;
+                            let path = get_string_prop(obj, "path")
+                                .ok_or_else(|| format!("gitRepo requires 'path' property"))?;
+                            return Ok(ActionType::GitRepo(GitRepo {
+                                url,
+                                path,
+                                r#ref: get_string_prop(obj, "ref"),
+                                depth: get_number_prop(obj, "depth").map(|n| n as u32),
+                                update: get_bool_prop(obj, "update"),
+                                retries: get_number_prop(obj, "retries").map(|n| n as u32),
+                                retry_delay: get_number_prop(obj, "retryDelay").map(|n| n as u64),
+                            }));
+                        }
+                        "command" => {
+                            let run = get_string_prop(obj, "run")
+                                .ok_or_else(|| format!("command requires 'run' property"))?;
+                            return Ok(ActionType::ShellCommand(ShellCommand {
+                                run,
+                                shell: get_string_prop(obj, "shell"),
+                                env: get_hashmap_prop(obj, "env"),
+                                only_if: get_string_prop(obj, "onlyIf"),
+                                unless: get_string_prop(obj, "unless"),
+                                cwd: get_string_prop(obj, "cwd"),
+                                changed_when: get_string_prop(obj, "changedWhen"),
+                                failed_when: get_string_prop(obj, "failedWhen"),
+                            }));
+                        }
+                        _ => {
+                            return Err(format!("Unknown action type: '{}'. Available actions: {}", action_name, crate::actions::ACTION_TYPES.join(", ")));
+                        }
+                    }
+                } else {
+                    return Err(format!("Action '{}' requires an object as argument", action_name));
+                }
+            } else {
+                return Err(format!("Action '{}' requires exactly one argument", action_name));
+            }
+        } else {
+            return Err(format!("Invalid action call: expected function call"));
+        }
+    } else {
+        return Err(format!("Invalid expression: expected function call"));
+    }
+}
+
+fn get_string_prop(obj: &ObjectExpression, key: &str) -> Option<String> {
+    for prop in &obj.properties {
+        if let ObjectPropertyKind::ObjectProperty(prop) = prop {
+            let prop_key = match &prop.key {
+                PropertyKey::StaticIdentifier(ident) => ident.name.as_str(),
+                PropertyKey::StringLiteral(lit) => lit.value.as_str(),
+                _ => continue,
+            };
+
+            if prop_key == key {
+                match &prop.value {
+                    Expression::StringLiteral(lit) => return Some(lit.value.to_string()),
+                    // Template literals without substitutions, e.g. multi-line file contents
+                    Expression::TemplateLiteral(tpl) if tpl.expressions.is_empty() => {
+                        return tpl
+                            .quasis
+                            .first()
+                            .and_then(|quasi| quasi.value.cooked.as_ref())
+                            .map(|cooked| cooked.to_string());
+                    }
+                    _ => {}
+                }
+            }
+        }
+    }
+    None
+}
+
+/// The names of an object's properties
+fn prop_names<'a>(obj: &'a ObjectExpression) -> Vec<&'a str> {
+    obj.properties
+        .iter()
+        .filter_map(|prop| match prop {
+            ObjectPropertyKind::ObjectProperty(prop) => match &prop.key {
+                PropertyKey::StaticIdentifier(ident) => Some(ident.name.as_str()),
+                PropertyKey::StringLiteral(lit) => Some(lit.value.as_str()),
+                _ => None,
+            },
+            _ => None,
+        })
+        .collect()
+}
+
+fn get_string_array_prop(obj: &ObjectExpression, key: &str) -> Option<Vec<String>> {
+    for prop in &obj.properties {
+        if let ObjectPropertyKind::ObjectProperty(prop) = prop {
+            let prop_key = match &prop.key {
+                PropertyKey::StaticIdentifier(ident) => ident.name.as_str(),
+                PropertyKey::StringLiteral(lit) => lit.value.as_str(),
+                _ => continue,
+            };
+
+            if prop_key == key {
+                if let Expression::ArrayExpression(arr) = &prop.value {
+                    let mut strings = Vec::new();
+                    for elem in &arr.elements {
+                        if let Some(Expression::StringLiteral(lit)) = elem.as_expression() {
+                            strings.push(lit.value.to_string());
+                        }
+                    }
+                    return Some(strings);
+                }
+            }
+        }
+    }
+    None
+}
+
+fn get_array_of_strings(obj: &ObjectExpression, key: &str) -> Option<Vec<String>> {
     get_string_array_prop(obj, key)
 }
 
@@ -500,6 +1565,39 @@ fn get_hashmap_prop(obj: &ObjectExpression, key: &str) -> Option<std::collection
     None
 }
 
+/// The `ensure` of a file action, which takes back the file with `"absent"`
+fn file_ensure(obj: &ObjectExpression, action: &str) -> Result<Option<String>, String> {
+    let ensure = get_string_prop(obj, "ensure");
+    match ensure.as_deref() {
+        None | Some("present") | Some("absent") => Ok(ensure),
+        Some(other) => Err(format!(
+            "{} 'ensure' must be \"present\" or \"absent\", got '{}'",
+            action, other
+        )),
+    }
+}
+
+/// The `source` of a file action, or the `content` it writes instead;
+/// exactly one has to be given
+fn file_content(
+    obj: &ObjectExpression,
+    action: &str,
+) -> Result<(Option<String>, Option<String>), String> {
+    let source = get_string_prop(obj, "source");
+    let content = get_string_prop(obj, "content");
+    match (&source, &content) {
+        (None, None) => Err(format!(
+            "{} requires 'source' or 'content' property",
+            action
+        )),
+        (Some(_), Some(_)) => Err(format!(
+            "{} takes either 'source' or 'content', not both",
+            action
+        )),
+        _ => Ok((source, content)),
+    }
+}
+
 fn get_bool_prop(obj: &ObjectExpression, key: &str) -> Option<bool> {
     for prop in &obj.properties {
         if let ObjectPropertyKind::ObjectProperty(prop) = prop {
@@ -543,7 +1641,15 @@ fn parse_object_literal(obj: &ObjectExpression) -> Option<ModuleDefinition> {
     let mut description = None;
     let mut tags = Vec::new();
     let mut dependencies = Vec::new();
+    let mut when = None;
+    let mut enabled = true;
     let mut actions = Vec::new();
+    let mut pre_apply = None;
+    let mut post_apply = None;
+    let mut handlers = Vec::new();
+    let mut variables = HashMap::new();
+    let mut variable_schema = HashMap::new();
+    let mut unparsed = Vec::new();
 
     for prop in &obj.properties {
         if let ObjectPropertyKind::ObjectProperty(prop) = prop {
@@ -573,7 +1679,37 @@ fn parse_object_literal(obj: &ObjectExpression) -> Option<ModuleDefinition> {
                         }
                     }
                 }
-                "dependencies" => {
+                "when" => {
+                    when = parse_condition_expr(&prop.value);
+                }
+                "enabled" => {
+                    if let Expression::BooleanLiteral(lit) = &prop.value {
+                        enabled = lit.value;
+                    }
+                }
+                "preApply" => {
+                    pre_apply = parse_hook(&prop.value);
+                }
+                "postApply" => {
+                    post_apply = parse_hook(&prop.value);
+                }
+                "handlers" => {
+                    if let Expression::ObjectExpression(obj) = &prop.value {
+                        handlers = parse_handlers(obj, name.as_deref().unwrap_or_default());
+                    }
+                }
+                "variables" => {
+                    if let Some(value) = expression_to_json(&prop.value) {
+                        variables = json_to_variables(&value).unwrap_or_default();
+                    }
+                }
+                "variableSchema" => {
+                    if let Some(value) = expression_to_json(&prop.value) {
+                        variable_schema = json_to_variable_schema(&value).unwrap_or_default();
+                    }
+                }
+                "dependencies" | "dependsOn" => {
+                    crate::deprecation::check("module", &[key]);
                     if let Expression::ArrayExpression(arr) = &prop.value {
                         for elem in &arr.elements {
                             if let Some(Expression::StringLiteral(lit)) = elem.as_expression() {
@@ -584,10 +1720,17 @@ fn parse_object_literal(obj: &ObjectExpression) -> Option<ModuleDefinition> {
                 }
                 "actions" => {
                     if let Expression::ArrayExpression(arr) = &prop.value {
-                        for elem in &arr.elements {
+                        for (idx, elem) in arr.elements.iter().enumerate() {
                             if let Some(expr) = elem.as_expression() {
-                                if let Some(action) = parse_action(expr) {
-                                    actions.push(action);
+                                if let Some(expanded) =
+                                    for_each_actions(expr, name.as_deref().unwrap_or_default(), idx)
+                                {
+                                    actions.extend(expanded);
+                                    continue;
+                                }
+                                match parse_action(expr) {
+                                    Some(action) => actions.push(with_options(action, expr)),
+                                    None => unparsed.push(idx),
                                 }
                             }
                         }
@@ -598,16 +1741,234 @@ fn parse_object_literal(obj: &ObjectExpression) -> Option<ModuleDefinition> {
         }
     }
 
+    // The name may come after the actions, so report them once it's known
+    for idx in unparsed {
+        warn(format!(
+            "Failed to parse action at index {} in module '{}': unknown type or missing required properties",
+            idx,
+            name.as_deref().unwrap_or_default()
+        ));
+    }
+
     name.map(|n| ModuleDefinition {
         name: n,
         description,
         tags,
         dependencies,
-        when: None,
+        when,
+        enabled,
         actions,
+        pre_apply,
+        post_apply,
+        handlers,
+        variables,
+        variable_schema,
     })
 }
 
+/// Parse a hook given as a command string or `{ run, shell, env, continueOnError, onlyIfChanged }`
+fn parse_hook(expr: &Expression) -> Option<Hook> {
+    match expr {
+        Expression::StringLiteral(lit) => Some(Hook::new(lit.value.to_string())),
+        Expression::ObjectExpression(obj) => Some(Hook {
+            run: get_string_prop(obj, "run")?,
+            shell: get_string_prop(obj, "shell"),
+            env: get_hashmap_prop(obj, "env"),
+            continue_on_error: get_bool_prop(obj, "continueOnError"),
+            only_if_changed: get_bool_prop(obj, "onlyIfChanged"),
+        }),
+        _ => None,
+    }
+}
+
+/// The object declaring an action: either the argument of an action call or
+/// a `{ type: ... }` object
+fn action_object<'a>(expr: &'a Expression<'a>) -> Option<&'a ObjectExpression<'a>> {
+    match expr {
+        Expression::CallExpression(call) => {
+            match call.arguments.first().and_then(|arg| arg.as_expression()) {
+                Some(Expression::ObjectExpression(obj)) => Some(obj),
+                _ => None,
+            }
+        }
+        Expression::ObjectExpression(obj) => Some(obj),
+        _ => None,
+    }
+}
+
+/// The actions of an action whose object sets `forEach`, one per item, or
+/// `None` for other actions
+///
+/// The object is read like an action of a declarative module, so its
+/// properties must be literals. Actions that fail to parse are warned about
+/// and left out.
+fn for_each_actions(expr: &Expression, module: &str, idx: usize) -> Option<Vec<ActionType>> {
+    let object = match expr {
+        Expression::CallExpression(call) => call.arguments.first()?.as_expression()?,
+        _ => expr,
+    };
+    let mut action = expression_to_json(object)?;
+    let props = action.as_object_mut()?;
+    if !props.contains_key("forEach") {
+        return None;
+    }
+    if let Expression::CallExpression(call) = expr {
+        if let Expression::Identifier(ident) = &call.callee {
+            let action_type = serde_json::Value::String(ident.name.to_string());
+            props.insert("type".to_string(), action_type);
+        }
+    }
+
+    match crate::declarative::parse_actions(module, idx, &action) {
+        Ok(actions) => Some(
+            actions
+                .into_iter()
+                .map(|action| with_notify(action, expr))
+                .collect(),
+        ),
+        Err(err) => {
+            warn(err.to_string());
+            Some(Vec::new())
+        }
+    }
+}
+
+/// Apply the options any action can set: `become`, `verify`, `tags`,
+/// `notify`, `requiresReboot`, `id` and `after`
+fn with_options(action: ActionType, expr: &Expression) -> ActionType {
+    let action = with_tags(with_verify(with_become(action, expr), expr), expr);
+    with_order(with_reboot(with_notify(action, expr), expr), expr)
+}
+
+/// Run an action whose object sets `become: true` as root
+fn with_become(mut action: ActionType, expr: &Expression) -> ActionType {
+    let become_root = action_object(expr).and_then(|obj| get_bool_prop(obj, "become"));
+    if become_root == Some(true) && !action.escalate() {
+        warn(format!(
+            "'become' has no effect on {}, it always runs as your user",
+            action.type_name()
+        ));
+    }
+    action
+}
+
+/// Wrap an action whose object sets `verify` to a command asserting its outcome
+fn with_verify(action: ActionType, expr: &Expression) -> ActionType {
+    match action_object(expr).and_then(|obj| get_string_prop(obj, "verify")) {
+        Some(verify) => ActionType::Verified(VerifiedAction::new(action, verify)),
+        None => action,
+    }
+}
+
+/// Wrap an action whose object sets `tags` to a tag or an array of them
+fn with_tags(action: ActionType, expr: &Expression) -> ActionType {
+    let tags = action_object(expr)
+        .and_then(|obj| {
+            get_string_prop(obj, "tags")
+                .map(|tag| vec![tag])
+                .or_else(|| get_string_array_prop(obj, "tags"))
+        })
+        .unwrap_or_default();
+    if tags.is_empty() {
+        action
+    } else {
+        ActionType::Tagged(TaggedAction::new(action, tags))
+    }
+}
+
+/// Wrap an action whose object sets `notify` to a handler name or an array of them
+fn with_notify(action: ActionType, expr: &Expression) -> ActionType {
+    let Some(obj) = action_object(expr) else {
+        return action;
+    };
+
+    let handlers = get_string_prop(obj, "notify")
+        .map(|name| vec![name])
+        .or_else(|| get_string_array_prop(obj, "notify"))
+        .unwrap_or_default();
+    if handlers.is_empty() {
+        action
+    } else {
+        ActionType::Notify(NotifyAction::new(action, handlers))
+    }
+}
+
+/// Wrap an action whose object sets `requiresReboot: true`
+fn with_reboot(action: ActionType, expr: &Expression) -> ActionType {
+    match action_object(expr).and_then(|obj| get_bool_prop(obj, "requiresReboot")) {
+        Some(true) => ActionType::Reboot(RebootAction::new(action)),
+        _ => action,
+    }
+}
+
+/// Wrap an action whose object sets an `id`, or `after` to an id or an array
+/// of them
+fn with_order(action: ActionType, expr: &Expression) -> ActionType {
+    let Some(obj) = action_object(expr) else {
+        return action;
+    };
+
+    let id = get_string_prop(obj, "id");
+    let after = get_string_prop(obj, "after")
+        .map(|id| vec![id])
+        .or_else(|| get_string_array_prop(obj, "after"))
+        .unwrap_or_default();
+    if id.is_none() && after.is_empty() {
+        action
+    } else {
+        ActionType::Ordered(OrderedAction::new(action, id, after))
+    }
+}
+
+/// Parse `{ "reload-nginx": command({ ... }) }`, where each handler is an action or an array of them
+fn parse_handlers(obj: &ObjectExpression, module_name: &str) -> Vec<Handler> {
+    let mut handlers = Vec::new();
+
+    for prop in &obj.properties {
+        if let ObjectPropertyKind::ObjectProperty(prop) = prop {
+            let name = match &prop.key {
+                PropertyKey::StaticIdentifier(ident) => ident.name.to_string(),
+                PropertyKey::StringLiteral(lit) => lit.value.to_string(),
+                _ => continue,
+            };
+
+            let exprs: Vec<&Expression> = match &prop.value {
+                Expression::ArrayExpression(arr) => arr
+                    .elements
+                    .iter()
+                    .filter_map(|elem| elem.as_expression())
+                    .collect(),
+                value => vec![value],
+            };
+
+            let mut actions = Vec::new();
+            for expr in exprs {
+                // Fluent modules call action functions, object modules use `{ type: ... }`
+                let action = match expr {
+                    Expression::ObjectExpression(_) => parse_action(expr)
+                        .ok_or_else(|| "Unknown or incomplete action object".to_string()),
+                    _ => parse_action_call(expr),
+                };
+                match action {
+                    Ok(mut action) => {
+                        action.as_handler();
+                        actions.push(with_become(action, expr));
+                    }
+                    Err(err) => {
+                        warn(format!(
+                            "Failed to parse handler '{}' in module '{}': {}",
+                            name, module_name, err
+                        ));
+                    }
+                }
+            }
+            handlers.push(Handler { name, actions });
+        }
+    }
+
+    handlers
+}
+
 fn parse_action(expr: &Expression) -> Option<ActionType> {
     // For JSON format: { type: "PackageInstall", names: [...] }
     if let Expression::ObjectExpression(obj) = expr {
@@ -635,276 +1996,777 @@ fn parse_action(expr: &Expression) -> Option<ActionType> {
             }
         }
 
-        // Create action based on type
-        match action_type.as_deref() {
-            Some("PackageInstall") => {
-                if let Some(serde_json::Value::Array(names)) = props.get("names") {
-                    let names: Vec<String> = names
-                        .iter()
-                        .filter_map(|v| v.as_str().map(String::from))
-                        .collect();
-                    let manager = props
-                        .get("manager")
-                        .and_then(|v| v.as_str())
-                        .and_then(|s| crate::atoms::package::PackageManager::from_str(s).ok());
-                    return Some(ActionType::PackageInstall(PackageInstall {
-                        names,
-                        manager,
-                    }));
-                }
-            }
-            Some("LinkFile") => {
-                let source = props
-                    .get("source")
+        return action_from_json(action_type.as_deref()?, &props);
+    }
+
+    None
+}
+
+/// Build an action of the JSON form's `type`, e.g. `PackageInstall`, from its
+/// other properties
+pub(crate) fn action_from_json(
+    action_type: &str,
+    props: &serde_json::Map<String, serde_json::Value>,
+) -> Option<ActionType> {
+    match action_type {
+        "PackageInstall" => {
+            let names = match props.get("names") {
+                Some(serde_json::Value::Array(names)) => Some(names.clone()),
+                None if props.contains_key("groups") => Some(Vec::new()),
+                _ => None,
+            };
+            if let Some(names) = names {
+                let names: Vec<String> = names
+                    .iter()
+                    .filter_map(|v| v.as_str().map(String::from))
+                    .collect();
+                let manager = props
+                    .get("manager")
                     .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let target = props
-                    .get("target")
+                    .and_then(|s| crate::atoms::package::PackageManager::from_str(s).ok());
+                let aur = props.get("aur").and_then(|v| v.as_bool());
+                let remote = props
+                    .get("remote")
                     .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let force = props
-                    .get("force")
-                    .and_then(|v| v.as_bool())
-                    .unwrap_or(false);
-                return Some(ActionType::LinkFile(LinkFile {
-                    source,
-                    target,
-                    force,
-                }));
-            }
-            Some("LinkDirectory") => {
-                let from = props
-                    .get("from")
+                    .map(String::from);
+                let scope = props
+                    .get("scope")
                     .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let to = props.get("to").and_then(|v| v.as_str()).map(String::from)?;
-                let force = props
-                    .get("force")
-                    .and_then(|v| v.as_bool())
-                    .unwrap_or(false);
-                return Some(ActionType::LinkDirectory(LinkDirectory {
-                    source: from,
-                    target: to,
-                    force,
+                    .map(String::from);
+                let string_array = |key: &str| {
+                    props.get(key).and_then(|v| v.as_array()).map(|arr| {
+                        arr.iter()
+                            .filter_map(|v| v.as_str().map(String::from))
+                            .collect::<Vec<String>>()
+                    })
+                };
+                return Some(ActionType::PackageInstall(PackageInstall {
+                    names,
+                    manager,
+                    aur,
+                    remote,
+                    scope,
+                    casks: string_array("casks"),
+                    taps: string_array("taps"),
+                    flake: props
+                        .get("flake")
+                        .and_then(|v| v.as_str())
+                        .map(String::from),
+                    classic: props.get("classic").and_then(|v| v.as_bool()),
+                    channel: props
+                        .get("channel")
+                        .and_then(|v| v.as_str())
+                        .map(String::from),
+                    overrides: props.get("overrides").and_then(json_to_package_overrides),
+                    ensure: props
+                        .get("ensure")
+                        .and_then(|v| v.as_str())
+                        .map(String::from),
+                    retries: props
+                        .get("retries")
+                        .and_then(|v| v.as_u64())
+                        .map(|n| n as u32),
+                    retry_delay: props.get("retryDelay").and_then(|v| v.as_u64()),
+                    groups: string_array("groups"),
                 }));
             }
-            Some("ExecuteCommand") => {
-                let shell = props
-                    .get("shell")
-                    .and_then(|v| v.as_str())
-                    .map(String::from);
-                let command = props
-                    .get("command")
+        }
+        "LinkFile" => {
+            let source = props
+                .get("source")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let target = props
+                .get("target")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let force = props
+                .get("force")
+                .and_then(|v| v.as_bool())
+                .unwrap_or(false);
+            return Some(ActionType::LinkFile(LinkFile {
+                source,
+                target,
+                force,
+            }));
+        }
+        "Symlink" => {
+            let source = props
+                .get("source")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let target = props
+                .get("target")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let force = props.get("force").and_then(|v| v.as_bool());
+            return Some(ActionType::Symlink(Symlink {
+                source,
+                target,
+                force,
+                ensure: json_file_ensure(props)?,
+            }));
+        }
+        "DecryptFile" => {
+            let source = props
+                .get("source")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let target = props
+                .get("target")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let mode = props.get("mode").and_then(|v| v.as_u64()).map(|n| n as u32);
+            let identity = props
+                .get("identity")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            return Some(ActionType::DecryptFile(DecryptFile {
+                source,
+                target,
+                mode,
+                identity,
+            }));
+        }
+        "EnvVar" => {
+            let name = props
+                .get("name")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let value = props
+                .get("value")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let path_prepend = props
+                .get("pathPrepend")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let shells = props.get("shells").and_then(|v| v.as_array()).map(|arr| {
+                arr.iter()
+                    .filter_map(|v| v.as_str().map(String::from))
+                    .collect()
+            });
+            return Some(ActionType::EnvVar(EnvVar {
+                name,
+                value,
+                path_prepend,
+                shells,
+                wire: props.get("wire").and_then(|v| v.as_bool()),
+            }));
+        }
+        "ShellSource" => {
+            let shells = props.get("shells").and_then(|v| v.as_array()).map(|arr| {
+                arr.iter()
+                    .filter_map(|v| v.as_str().map(String::from))
+                    .collect()
+            });
+            return Some(ActionType::ShellSource(ShellSource {
+                file: props.get("file").and_then(|v| v.as_str()).map(String::from),
+                shells,
+                ensure: props
+                    .get("ensure")
                     .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let args = props.get("args").and_then(|v| v.as_array()).map(|arr| {
+                    .map(String::from),
+            }));
+        }
+        "BlockInFile" => {
+            let path = props
+                .get("path")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let name = props
+                .get("name")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let content = props
+                .get("content")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let absent = props.get("absent").and_then(|v| v.as_bool());
+            let escalate = props.get("escalate").and_then(|v| v.as_bool());
+            return Some(ActionType::BlockInFile(BlockInFile {
+                path,
+                name,
+                content,
+                absent,
+                escalate,
+            }));
+        }
+        "SshConfig" => {
+            return Some(ActionType::SshConfig(SshConfig {
+                hosts: json_to_ssh_hosts(props.get("hosts")?).ok()?,
+                path: props.get("path").and_then(|v| v.as_str()).map(String::from),
+            }));
+        }
+        "LineInFile" => {
+            let path = props
+                .get("path")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let line = props
+                .get("line")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let absent = props.get("absent").and_then(|v| v.as_bool());
+            let escalate = props.get("escalate").and_then(|v| v.as_bool());
+            return Some(ActionType::LineInFile(LineInFile {
+                path,
+                line,
+                absent,
+                escalate,
+            }));
+        }
+        "RemoteFile" => {
+            let url = props
+                .get("url")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let target = props
+                .get("target")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let sha256 = props
+                .get("sha256")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let mode = props.get("mode").and_then(|v| v.as_u64()).map(|n| n as u32);
+            let extract = props
+                .get("extract")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let retries = props.get("retries").and_then(|v| v.as_u64()).map(|n| n as u32);
+            let retry_delay = props.get("retryDelay").and_then(|v| v.as_u64());
+            let arch = match props.get("arch") {
+                Some(value) => Some(json_to_remote_file_variants(value)?),
+                None => None,
+            };
+            return Some(ActionType::RemoteFile(RemoteFile {
+                url,
+                target,
+                sha256,
+                mode,
+                extract,
+                retries,
+                retry_delay,
+                arch,
+            }));
+        }
+        "PackageRepo" => {
+            let string = |key: &str| props.get(key).and_then(|v| v.as_str()).map(String::from);
+            let components = props
+                .get("components")
+                .and_then(|v| v.as_array())
+                .map(|arr| {
                     arr.iter()
                         .filter_map(|v| v.as_str().map(String::from))
                         .collect()
                 });
-                let escalate = props
-                    .get("escalate")
-                    .and_then(|v| v.as_bool())
-                    .unwrap_or(false);
-                let environment = props
-                    .get("environment")
-                    .and_then(|v| v.as_object())
-                    .map(|obj| {
-                        let mut map = std::collections::HashMap::new();
-                        for (k, v) in obj {
-                            if let Some(s) = v.as_str() {
-                                map.insert(k.clone(), s.to_string());
-                            }
-                        }
-                        map
-                    })
-                    .filter(|m| !m.is_empty());
-                return Some(ActionType::ExecuteCommand(ExecuteCommand {
-                    shell,
-                    command,
-                    args,
-                    escalate: Some(escalate),
-                    environment,
-                }));
-            }
-            Some("CopyFile") => {
-                let source = props
-                    .get("source")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let target = props
+            return Some(ActionType::PackageRepo(PackageRepo {
+                name: string("name")?,
+                uri: string("uri")?,
+                key: string("key"),
+                components,
+                distro: string("distro"),
+                manager: string("manager"),
+            }));
+        }
+        "GpgKey" => {
+            let string = |key: &str| props.get(key).and_then(|v| v.as_str()).map(String::from);
+            return Some(ActionType::GpgKey(GpgKey {
+                key_id: string("keyId"),
+                key_url: string("keyUrl"),
+                key_file: string("keyFile"),
+                keyserver: string("keyserver"),
+                trust: string("trust"),
+                keyring: string("keyring"),
+                escalate: props.get("escalate").and_then(|v| v.as_bool()),
+                retries: props
+                    .get("retries")
+                    .and_then(|v| v.as_u64())
+                    .map(|n| n as u32),
+                retry_delay: props.get("retryDelay").and_then(|v| v.as_u64()),
+            }));
+        }
+        "Stow" => {
+            let source = props
+                .get("source")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            return Some(ActionType::Stow(Stow {
+                source,
+                target: props
                     .get("target")
                     .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let escalate = props
-                    .get("escalate")
-                    .and_then(|v| v.as_bool())
-                    .unwrap_or(false);
-                return Some(ActionType::CopyFile(CopyFile {
-                    source,
-                    target,
-                    escalate,
-                }));
-            }
-            Some("Directory") => {
-                let path = props
-                    .get("path")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let escalate = props.get("escalate").and_then(|v| v.as_bool());
-                return Some(ActionType::Directory(Directory { path, escalate }));
-            }
-            Some("HttpDownload") => {
-                let url = props
-                    .get("url")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let destination = props
-                    .get("destination")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let checksum = None; // TODO: Parse checksum object if provided
-                let mode = props.get("mode").and_then(|v| v.as_u64()).map(|n| n as u32);
-                return Some(ActionType::HttpDownload(HttpDownload {
-                    url,
-                    destination,
-                    checksum,
-                    mode,
-                }));
-            }
-            Some("SystemdService") => {
-                let name = props
-                    .get("name")
+                    .map(String::from),
+                delete: props.get("delete").and_then(|v| v.as_bool()),
+                adopt: props.get("adopt").and_then(|v| v.as_bool()),
+                force: props.get("force").and_then(|v| v.as_bool()),
+            }));
+        }
+        "Cron" => {
+            let name = props
+                .get("name")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let schedule = props
+                .get("schedule")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let command = props
+                .get("command")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let user = props.get("user").and_then(|v| v.as_str()).map(String::from);
+            let ensure = props
+                .get("ensure")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let backend = props
+                .get("backend")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            return Some(ActionType::Cron(Cron {
+                name,
+                schedule,
+                command,
+                user,
+                ensure,
+                backend,
+            }));
+        }
+        "DesktopEntry" => {
+            let string = |key: &str| props.get(key).and_then(|v| v.as_str()).map(String::from);
+            let categories = props
+                .get("categories")
+                .and_then(|v| v.as_array())
+                .map(|arr| {
+                    arr.iter()
+                        .filter_map(|v| v.as_str().map(String::from))
+                        .collect()
+                });
+            return Some(ActionType::DesktopEntry(DesktopEntry {
+                name: string("name")?,
+                exec: string("exec")?,
+                icon: string("icon"),
+                categories,
+                comment: string("comment"),
+                terminal: props.get("terminal").and_then(|v| v.as_bool()),
+                id: string("id"),
+                ensure: json_file_ensure(props)?,
+            }));
+        }
+        "ScriptInstall" => {
+            let string = |key: &str| props.get(key).and_then(|v| v.as_str()).map(String::from);
+            let args = props.get("args").and_then(|v| v.as_array()).map(|arr| {
+                arr.iter()
+                    .filter_map(|v| v.as_str().map(String::from))
+                    .collect()
+            });
+            return Some(ActionType::ScriptInstall(ScriptInstall {
+                url: string("url")?,
+                sha256: string("sha256")?,
+                shell: string("shell"),
+                args,
+                env: props.get("env").and_then(json_to_variables),
+                version: string("version"),
+                escalate: props.get("escalate").and_then(|v| v.as_bool()),
+            }));
+        }
+        "Plugin" => {
+            let name = props
+                .get("name")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            return Some(ActionType::Plugin(Plugin {
+                name,
+                command: props
+                    .get("command")
                     .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let description = props
+                    .map(String::from),
+                spec: props.get("spec").cloned(),
+                description: props
                     .get("description")
                     .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let exec_start = props
-                    .get("execStart")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let service_type = props
-                    .get("serviceType")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let scope = props
-                    .get("scope")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let restart = props
-                    .get("restart")
+                    .map(String::from),
+            }));
+        }
+        "Template" => {
+            let (source, content) = json_file_content(props)?;
+            let target = props
+                .get("target")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let variables = props.get("variables").and_then(json_to_variables);
+            return Some(ActionType::Template(Template {
+                source,
+                content,
+                target,
+                variables,
+                ensure: json_file_ensure(props)?,
+            }));
+        }
+        "TemplateDir" => {
+            let source = props
+                .get("source")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let target = props
+                .get("target")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            return Some(ActionType::TemplateDir(TemplateDir {
+                source,
+                target,
+                variables: props.get("variables").and_then(json_to_variables),
+                extension: props
+                    .get("extension")
                     .and_then(|v| v.as_str())
-                    .map(String::from);
-                let restart_sec = props
+                    .map(String::from),
+            }));
+        }
+        "LinkDirectory" => {
+            let from = props
+                .get("from")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let to = props.get("to").and_then(|v| v.as_str()).map(String::from)?;
+            let force = props
+                .get("force")
+                .and_then(|v| v.as_bool())
+                .unwrap_or(false);
+            return Some(ActionType::LinkDirectory(LinkDirectory {
+                source: from,
+                target: to,
+                force,
+            }));
+        }
+        "ExecuteCommand" => {
+            let shell = props
+                .get("shell")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let command = props
+                .get("command")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let args = props.get("args").and_then(|v| v.as_array()).map(|arr| {
+                arr.iter()
+                    .filter_map(|v| v.as_str().map(String::from))
+                    .collect()
+            });
+            let escalate = props
+                .get("escalate")
+                .and_then(|v| v.as_bool())
+                .unwrap_or(false);
+            let environment = props
+                .get("environment")
+                .and_then(|v| v.as_object())
+                .map(|obj| {
+                    let mut map = std::collections::HashMap::new();
+                    for (k, v) in obj {
+                        if let Some(s) = v.as_str() {
+                            map.insert(k.clone(), s.to_string());
+                        }
+                    }
+                    map
+                })
+                .filter(|m| !m.is_empty());
+            return Some(ActionType::ExecuteCommand(ExecuteCommand {
+                shell,
+                command,
+                args,
+                escalate: Some(escalate),
+                environment,
+            }));
+        }
+        "GitRepo" => {
+            let url = props.get("url").and_then(|v| v.as_str()).map(String::from)?;
+            let path = props.get("path").and_then(|v| v.as_str()).map(String::from)?;
+            let r#ref = props.get("ref").and_then(|v| v.as_str()).map(String::from);
+            let depth = props.get("depth").and_then(|v| v.as_u64()).map(|n| n as u32);
+            let update = props.get("update").and_then(|v| v.as_bool());
+            let retries = props.get("retries").and_then(|v| v.as_u64()).map(|n| n as u32);
+            let retry_delay = props.get("retryDelay").and_then(|v| v.as_u64());
+            return Some(ActionType::GitRepo(GitRepo {
+                url,
+                path,
+                r#ref,
+                depth,
+                update,
+                retries,
+                retry_delay,
+            }));
+        }
+        "ShellCommand" => {
+            let run = props.get("run").and_then(|v| v.as_str()).map(String::from)?;
+            let shell = props
+                .get("shell")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let only_if = props
+                .get("onlyIf")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let unless = props
+                .get("unless")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let env = props.get("env").and_then(json_to_variables);
+            let cwd = props.get("cwd").and_then(|v| v.as_str()).map(String::from);
+            let changed_when = props
+                .get("changedWhen")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let failed_when = props
+                .get("failedWhen")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            return Some(ActionType::ShellCommand(ShellCommand {
+                run,
+                shell,
+                env,
+                only_if,
+                unless,
+                cwd,
+                changed_when,
+                failed_when,
+            }));
+        }
+        "CopyFile" => {
+            let (source, content) = json_file_content(props)?;
+            let target = props
+                .get("target")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let escalate = props
+                .get("escalate")
+                .and_then(|v| v.as_bool())
+                .unwrap_or(false);
+            let mode = props.get("mode").and_then(|v| v.as_u64()).map(|n| n as u32);
+            let owner = props
+                .get("owner")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let group = props
+                .get("group")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let create_parents = props.get("createParents").and_then(|v| v.as_bool());
+            return Some(ActionType::CopyFile(CopyFile {
+                source,
+                content,
+                target,
+                escalate,
+                mode,
+                owner,
+                group,
+                create_parents,
+                ensure: json_file_ensure(props)?,
+            }));
+        }
+        "Directory" => {
+            let path = props
+                .get("path")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let escalate = props.get("escalate").and_then(|v| v.as_bool());
+            let mode = props.get("mode").and_then(|v| v.as_u64()).map(|n| n as u32);
+            let recursive = props.get("recursive").and_then(|v| v.as_bool());
+            let owner = props
+                .get("owner")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            let group = props
+                .get("group")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            return Some(ActionType::Directory(Directory {
+                path,
+                escalate,
+                mode,
+                recursive,
+                owner,
+                group,
+            }));
+        }
+        "HttpDownload" => {
+            let url = props
+                .get("url")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let destination = props
+                .get("destination")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let checksum = None; // TODO: Parse checksum object if provided
+            let mode = props.get("mode").and_then(|v| v.as_u64()).map(|n| n as u32);
+            let retries = props.get("retries").and_then(|v| v.as_u64()).map(|n| n as u32);
+            let retry_delay = props.get("retryDelay").and_then(|v| v.as_u64());
+            return Some(ActionType::HttpDownload(HttpDownload {
+                url,
+                destination,
+                checksum,
+                mode,
+                retries,
+                retry_delay,
+            }));
+        }
+        "SystemdService" => {
+            let name = props
+                .get("name")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let prop_string = |key: &str| props.get(key).and_then(|v| v.as_str()).map(String::from);
+            let unit = prop_string("unit");
+            let exec_start = prop_string("execStart");
+            if unit.is_none() && exec_start.is_none() {
+                return None;
+            }
+            let action = prop_string("action");
+            if action.as_deref().is_some_and(|action| {
+                crate::atoms::systemd_service::Refresh::parse(action).is_err()
+            }) {
+                return None;
+            }
+            return Some(ActionType::SystemdService(SystemdService {
+                name,
+                description: prop_string("description"),
+                exec_start,
+                service_type: prop_string("serviceType"),
+                scope: prop_string("scope"),
+                restart: prop_string("restart"),
+                restart_sec: props
                     .get("restartSec")
                     .and_then(|v| v.as_u64())
-                    .map(|n| n as u32);
-                return Some(ActionType::SystemdService(SystemdService {
-                    name,
-                    description,
-                    exec_start,
-                    service_type,
-                    scope,
-                    restart,
-                    restart_sec,
+                    .map(|n| n as u32),
+                unit,
+                timer: prop_string("timer"),
+                enable: props.get("enable").and_then(|v| v.as_bool()),
+                start: props.get("start").and_then(|v| v.as_bool()),
+                action,
+                notified: false,
+            }));
+        }
+        "SystemdSocket" => {
+            let name = props
+                .get("name")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let description = props
+                .get("description")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let listen_stream = props
+                .get("listenStream")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let scope = props
+                .get("scope")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            return Some(ActionType::SystemdSocket(SystemdSocket {
+                name,
+                description,
+                listen_stream,
+                scope,
+            }));
+        }
+        "DconfImport" => {
+            let source = props
+                .get("source")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let path = props
+                .get("path")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            return Some(ActionType::DconfImport(DconfImport { source, path }));
+        }
+        "InstallGnomeExtensions" => {
+            if let Some(serde_json::Value::Array(extensions)) = props.get("extensions") {
+                let extensions: Vec<String> = extensions
+                    .iter()
+                    .filter_map(|v| v.as_str().map(String::from))
+                    .collect();
+                return Some(ActionType::InstallGnomeExtensions(InstallGnomeExtensions {
+                    extensions,
                 }));
             }
-            Some("SystemdSocket") => {
-                let name = props
-                    .get("name")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let description = props
-                    .get("description")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let listen_stream = props
-                    .get("listenStream")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let scope = props
-                    .get("scope")
+        }
+        "PackageRemove" => {
+            if let Some(serde_json::Value::Array(names)) = props.get("names") {
+                let names: Vec<String> = names
+                    .iter()
+                    .filter_map(|v| v.as_str().map(String::from))
+                    .collect();
+                let manager = props
+                    .get("manager")
                     .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                return Some(ActionType::SystemdSocket(SystemdSocket {
-                    name,
-                    description,
-                    listen_stream,
-                    scope,
+                    .and_then(|s| crate::atoms::package::PackageManager::from_str(s).ok());
+                let overrides = props.get("overrides").and_then(json_to_package_overrides);
+                return Some(ActionType::PackageRemove(PackageRemove {
+                    names,
+                    manager,
+                    overrides,
                 }));
             }
-            Some("DconfImport") => {
-                let source = props
-                    .get("source")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let path = props
-                    .get("path")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                return Some(ActionType::DconfImport(DconfImport { source, path }));
-            }
-            Some("InstallGnomeExtensions") => {
-                if let Some(serde_json::Value::Array(extensions)) = props.get("extensions") {
-                    let extensions: Vec<String> = extensions
-                        .iter()
-                        .filter_map(|v| v.as_str().map(String::from))
-                        .collect();
-                    return Some(ActionType::InstallGnomeExtensions(InstallGnomeExtensions {
-                        extensions,
-                    }));
-                }
+        }
+        "SystemdManage" => {
+            let name = props
+                .get("name")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let operation = props
+                .get("operation")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            let scope = props
+                .get("scope")
+                .and_then(|v| v.as_str())
+                .map(String::from)?;
+            return Some(ActionType::SystemdManage(SystemdManage {
+                name,
+                operation,
+                scope,
+            }));
+        }
+        "GitConfig" => {
+            let global = props.get("global").map(|v| v.clone());
+            let system = props.get("system").map(|v| v.clone());
+            let local = props.get("local").map(|v| v.clone());
+            let entries = props
+                .get("entries")
+                .or_else(|| props.get("settings"))
+                .cloned();
+            let scope = props.get("scope").and_then(|v| v.as_str()).map(String::from);
+            if let Some(scope) = &scope {
+                crate::actions::git_config::parse_scope(scope).ok()?;
             }
-            Some("PackageRemove") => {
-                if let Some(serde_json::Value::Array(names)) = props.get("names") {
-                    let names: Vec<String> = names
-                        .iter()
-                        .filter_map(|v| v.as_str().map(String::from))
-                        .collect();
-                    let manager = props
-                        .get("manager")
-                        .and_then(|v| v.as_str())
-                        .and_then(|s| crate::atoms::package::PackageManager::from_str(s).ok());
-                    return Some(ActionType::PackageRemove(PackageRemove { names, manager }));
-                }
+            let ensure = props
+                .get("ensure")
+                .and_then(|v| v.as_str())
+                .map(String::from);
+            if ensure
+                .as_deref()
+                .is_some_and(|ensure| !matches!(ensure, "present" | "absent"))
+            {
+                return None;
             }
-            Some("SystemdManage") => {
-                let name = props
-                    .get("name")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let operation = props
-                    .get("operation")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                let scope = props
-                    .get("scope")
-                    .and_then(|v| v.as_str())
-                    .map(String::from)?;
-                return Some(ActionType::SystemdManage(SystemdManage {
-                    name,
-                    operation,
+            
+            // Ensure at least one scope is provided
+            if global.is_some() || system.is_some() || local.is_some() || entries.is_some() {
+                return Some(ActionType::GitConfig(GitConfig {
+                    global,
+                    system,
+                    local,
+                    entries,
                     scope,
+                    path: props.get("path").and_then(|v| v.as_str()).map(String::from),
+                    ensure,
                 }));
             }
-            Some("GitConfig") => {
-                let global = props.get("global").map(|v| v.clone());
-                let system = props.get("system").map(|v| v.clone());
-                let local = props.get("local").map(|v| v.clone());
-                
-                // Ensure at least one scope is provided
-                if global.is_some() || system.is_some() || local.is_some() {
-                    return Some(ActionType::GitConfig(GitConfig {
-                        global,
-                        system,
-                        local,
-                    }));
-                }
-            }
-            _ => {}
         }
+        _ => {}
     }
 
     None
@@ -968,6 +2830,11 @@ fn parse_condition_expr(expr: &Expression) -> Option<Condition> {
         }
     }
 
+    // A bare object is a host-fact predicate: { os: "linux", hasCommand: "docker" }
+    if let Expression::ObjectExpression(obj) = expr {
+        return parse_host_facts(obj).map(HostFacts::into_condition);
+    }
+
     // For now, we don't support parsing string conditions from TypeScript
     // since we're walking the AST and can't evaluate complex expressions
     None
@@ -1071,6 +2938,45 @@ fn parse_condition_builder_method(builder_expr: &Expression, method: &str, args:
     None
 }
 
+/// Parse a host-fact predicate object, warning about facts that don't exist
+fn parse_host_facts(obj: &ObjectExpression) -> Option<HostFacts> {
+    const FACTS: [&str; 6] = ["hostname", "os", "arch", "distro", "family", "hasCommand"];
+
+    for prop in &obj.properties {
+        if let ObjectPropertyKind::ObjectProperty(prop) = prop {
+            let key = match &prop.key {
+                PropertyKey::StaticIdentifier(ident) => ident.name.as_str(),
+                PropertyKey::StringLiteral(lit) => lit.value.as_str(),
+                _ => continue,
+            };
+            if !FACTS.contains(&key) {
+                warn(format!(
+                    "Unknown host fact '{}' in condition. Available facts: {}",
+                    key,
+                    FACTS.join(", ")
+                ));
+            }
+        }
+    }
+
+    let facts = HostFacts {
+        hostname: get_string_prop(obj, "hostname"),
+        os: get_string_prop(obj, "os"),
+        arch: get_string_prop(obj, "arch"),
+        distro: get_string_prop(obj, "distro"),
+        family: get_string_prop(obj, "family"),
+        has_command: get_string_prop(obj, "hasCommand"),
+    };
+
+    let has_any = facts.hostname.is_some()
+        || facts.os.is_some()
+        || facts.arch.is_some()
+        || facts.distro.is_some()
+        || facts.family.is_some()
+        || facts.has_command.is_some();
+    has_any.then_some(facts)
+}
+
 fn parse_condition_function(func_name: &str, args: &[Argument]) -> Option<Condition> {
     match func_name {
         "fileExists" => {
@@ -1138,6 +3044,13 @@ fn parse_condition_function(func_name: &str, args: &[Argument]) -> Option<Condit
                 }
             }
         }
+        "host" => {
+            if args.len() == 1 {
+                if let Some(Expression::ObjectExpression(obj)) = args[0].as_expression() {
+                    return parse_host_facts(obj).map(HostFacts::into_condition);
+                }
+            }
+        }
         "not" => {
             if args.len() == 1 {
                 if let Some(expr) = args[0].as_expression() {
@@ -1164,6 +3077,273 @@ fn parse_json_value(expr: &Expression) -> Option<serde_json::Value> {
     }
 }
 
+/// The `ensure` of a file action, or `None` if it's neither present nor absent
+fn json_file_ensure(props: &serde_json::Map<String, serde_json::Value>) -> Option<Option<String>> {
+    match props.get("ensure") {
+        None => Some(None),
+        Some(ensure) => match ensure.as_str()? {
+            ensure @ ("present" | "absent") => Some(Some(ensure.to_string())),
+            _ => None,
+        },
+    }
+}
+
+/// The `source` and `content` of a file action, or `None` unless exactly one
+/// is a string
+fn json_file_content(
+    props: &serde_json::Map<String, serde_json::Value>,
+) -> Option<(Option<String>, Option<String>)> {
+    let string = |key: &str| props.get(key).and_then(|v| v.as_str()).map(String::from);
+    match (string("source"), string("content")) {
+        (Some(_), Some(_)) | (None, None) => None,
+        files => Some(files),
+    }
+}
+
+/// Convert a JSON object into template variables, stringifying booleans and numbers
+pub(crate) fn json_to_variables(value: &serde_json::Value) -> Option<std::collections::HashMap<String, String>> {
+    let obj = value.as_object()?;
+    let mut variables = std::collections::HashMap::new();
+    for (key, value) in obj {
+        if let Some(value) = json_to_variable(value) {
+            variables.insert(key.clone(), value);
+        }
+    }
+    Some(variables)
+}
+
+/// A string, boolean or number as a template variable
+pub(crate) fn json_to_variable(value: &serde_json::Value) -> Option<String> {
+    match value {
+        serde_json::Value::String(s) => Some(s.clone()),
+        serde_json::Value::Bool(b) => Some(b.to_string()),
+        // Numbers arrive as f64, so render whole numbers without a trailing ".0"
+        serde_json::Value::Number(n) => Some(match n.as_f64() {
+            Some(f) if f.fract() == 0.0 => (f as i64).to_string(),
+            _ => n.to_string(),
+        }),
+        _ => None,
+    }
+}
+
+/// Convert `{ email: { type: "string", required: true }, ... }` into a variable schema
+pub(crate) fn json_to_variable_schema(value: &serde_json::Value) -> Option<HashMap<String, VariableSpec>> {
+    let mut schema = HashMap::new();
+    for (name, spec) in value.as_object()? {
+        let spec = spec.as_object()?;
+        schema.insert(
+            name.clone(),
+            VariableSpec {
+                r#type: spec.get("type")?.as_str()?.to_string(),
+                required: spec.get("required").and_then(|v| v.as_bool()),
+                default: spec.get("default").and_then(json_to_variable),
+                values: spec
+                    .get("values")
+                    .and_then(|v| v.as_array())
+                    .map(|values| values.iter().filter_map(json_to_variable).collect()),
+                sensitive: spec.get("sensitive").and_then(|v| v.as_bool()),
+            },
+        );
+    }
+    Some(schema)
+}
+
+/// Convert `{ laptop: { tags: ["desktop"], variables: { ... } } }` into host profiles
+fn json_to_host_profiles(value: &serde_json::Value) -> Option<HashMap<String, HostProfile>> {
+    let strings = |profile: &serde_json::Map<String, serde_json::Value>, key: &str| {
+        profile.get(key).and_then(|v| v.as_array()).map(|values| {
+            values
+                .iter()
+                .filter_map(|v| v.as_str().map(String::from))
+                .collect::<Vec<String>>()
+        })
+    };
+
+    let mut hosts = HashMap::new();
+    for (name, profile) in value.as_object()? {
+        let profile = profile.as_object()?;
+        hosts.insert(
+            name.clone(),
+            HostProfile {
+                modules: strings(profile, "modules"),
+                tags: strings(profile, "tags"),
+                variables: profile.get("variables").and_then(json_to_variables),
+                groups: strings(profile, "groups"),
+                disabled: strings(profile, "disabled"),
+            },
+        );
+    }
+    Some(hosts)
+}
+
+/// Convert `{ web: { hosts: ["web01"], tags: ["server"] } }` into host groups
+fn json_to_host_groups(value: &serde_json::Value) -> Option<HashMap<String, HostGroup>> {
+    let strings = |group: &serde_json::Map<String, serde_json::Value>, key: &str| {
+        group.get(key).and_then(|v| v.as_array()).map(|values| {
+            values
+                .iter()
+                .filter_map(|v| v.as_str().map(String::from))
+                .collect::<Vec<String>>()
+        })
+    };
+
+    let mut groups = HashMap::new();
+    for (name, group) in value.as_object()? {
+        let group = group.as_object()?;
+        groups.insert(
+            name.clone(),
+            HostGroup {
+                hosts: strings(group, "hosts"),
+                modules: strings(group, "modules"),
+                tags: strings(group, "tags"),
+                variables: group.get("variables").and_then(json_to_variables),
+            },
+        );
+    }
+    Some(groups)
+}
+
+/// Convert `{ "dev-tools": ["ripgrep", "fd"] }` into package groups
+fn json_to_package_groups(value: &serde_json::Value) -> Option<HashMap<String, Vec<String>>> {
+    value
+        .as_object()?
+        .iter()
+        .map(|(name, packages)| {
+            let packages = packages
+                .as_array()?
+                .iter()
+                .map(|package| package.as_str().map(String::from))
+                .collect::<Option<Vec<String>>>()?;
+            Some((name.clone(), packages))
+        })
+        .collect()
+}
+
+/// Convert `{ apt: { extraArgs: ["-o", "..."] } }` into package manager
+/// settings, refusing managers and flags DHD can't pass on
+fn json_to_package_managers(
+    value: &serde_json::Value,
+) -> Result<HashMap<String, PackageManagerConfig>, String> {
+    let invalid = || "'packageManagers' must map package managers to { extraArgs }".to_string();
+
+    let mut managers = HashMap::new();
+    for (name, settings) in value.as_object().ok_or_else(invalid)? {
+        let manager = PackageManager::from_str(name).map_err(|_| {
+            format!(
+                "'packageManagers' has an unknown package manager '{}'",
+                name
+            )
+        })?;
+        let settings = settings.as_object().ok_or_else(invalid)?;
+        let extra_args = match settings.get("extraArgs") {
+            Some(args) => {
+                let args = args
+                    .as_array()
+                    .and_then(|args| {
+                        args.iter()
+                            .map(|arg| arg.as_str().map(String::from))
+                            .collect::<Option<Vec<String>>>()
+                    })
+                    .ok_or_else(|| {
+                        format!(
+                            "'packageManagers.{}.extraArgs' must be an array of strings",
+                            name
+                        )
+                    })?;
+                crate::atoms::package::args::validate_extra_args(&manager, &args)
+                    .map_err(|e| format!("'packageManagers.{}': {}", name, e))?;
+                Some(args)
+            }
+            None => None,
+        };
+        managers.insert(name.clone(), PackageManagerConfig { extra_args });
+    }
+    Ok(managers)
+}
+
+/// Convert `{ fd: { debian: "fd-find", arch: "fd" } }` into per-distro package names
+fn json_to_package_overrides(
+    value: &serde_json::Value,
+) -> Option<std::collections::HashMap<String, std::collections::HashMap<String, String>>> {
+    let obj = value.as_object()?;
+    let mut overrides = std::collections::HashMap::new();
+    for (package, names) in obj {
+        if let Some(names) = names.as_object() {
+            let names = names
+                .iter()
+                .filter_map(|(key, name)| Some((key.clone(), name.as_str()?.to_string())))
+                .collect();
+            overrides.insert(package.clone(), names);
+        }
+    }
+    Some(overrides)
+}
+
+/// Convert `{ aarch64: { url: "...", sha256: "..." } }` into per-architecture
+/// variants of a remote file
+fn json_to_remote_file_variants(
+    value: &serde_json::Value,
+) -> Option<HashMap<String, RemoteFileVariant>> {
+    value
+        .as_object()?
+        .iter()
+        .map(|(arch, variant)| {
+            let variant = variant.as_object()?;
+            let string = |key: &str| variant.get(key).and_then(|v| v.as_str()).map(String::from);
+            Some((
+                arch.clone(),
+                RemoteFileVariant {
+                    url: string("url"),
+                    sha256: string("sha256"),
+                    extract: string("extract"),
+                },
+            ))
+        })
+        .collect()
+}
+
+/// Convert `[{ host: "work", user: "me" }, ...]` into the hosts of `sshConfig`
+fn json_to_ssh_hosts(value: &serde_json::Value) -> Result<Vec<SshHost>, String> {
+    let entries = value
+        .as_array()
+        .ok_or_else(|| format!("sshConfig 'hosts' must be a list of objects with 'host'"))?;
+    let mut hosts: Vec<SshHost> = Vec::new();
+    for entry in entries {
+        let host = entry
+            .as_object()
+            .ok_or_else(|| format!("sshConfig 'hosts' must be a list of objects with 'host'"))?;
+        let string = |key: &str| host.get(key).and_then(json_to_variable);
+        let alias = host
+            .get("host")
+            .and_then(|v| v.as_str())
+            .filter(|alias| !alias.trim().is_empty())
+            .ok_or_else(|| format!("sshConfig hosts require a 'host' alias"))?;
+        if hosts.iter().any(|other| other.host == alias) {
+            return Err(format!("sshConfig has host '{}' more than once", alias));
+        }
+        let options = match host.get("options") {
+            Some(options) => Some(json_to_variables(options).ok_or_else(|| {
+                format!(
+                    "sshConfig 'options' of host '{}' must map keywords to values",
+                    alias
+                )
+            })?),
+            None => None,
+        };
+        hosts.push(SshHost {
+            host: alias.to_string(),
+            hostname: string("hostname"),
+            user: string("user"),
+            port: host.get("port").and_then(|v| v.as_f64()).map(|n| n as u32),
+            identity_file: string("identityFile"),
+            proxy_jump: string("proxyJump"),
+            options,
+            absent: host.get("absent").and_then(|v| v.as_bool()),
+        });
+    }
+    Ok(hosts)
+}
+
 fn expression_to_json_from_obj(obj: &ObjectExpression, key: &str) -> Option<serde_json::Value> {
     for prop in &obj.properties {
         if let ObjectPropertyKind::ObjectProperty(prop) = prop {
@@ -1201,6 +3381,9 @@ mod tests {
         DiscoveredModule {
             path,
             name: name.to_string(),
+            namespace: None,
+            variables: HashMap::new(),
+            package_groups: HashMap::new(),
         }
     }
 
@@ -1277,80 +3460,1929 @@ export default defineModule("multi")
     }
 
     #[test]
-    fn test_load_module_invalid_syntax() {
+    fn test_load_module_symlink_action() {
         let temp_dir = TempDir::new().unwrap();
         let content = r#"
-export default {
-    name: "invalid"
-    description: "Missing comma"
-    actions: []
-};
+export default defineModule("dotfiles")
+    .actions([
+        symlink({ source: "./dotfiles/.zshrc", target: "~/.zshrc", force: true })
+    ]);
 "#;
 
-        let discovered = create_test_module(temp_dir.path(), "invalid", content);
-        let result = load_module(&discovered);
+        let discovered = create_test_module(temp_dir.path(), "dotfiles", content);
+        let loaded = load_module(&discovered).unwrap();
 
-        assert!(result.is_err());
-        match result.unwrap_err() {
-            LoadError::ParseError(msg) => assert!(msg.contains("syntax") || msg.contains("parse")),
-            _ => panic!("Expected ParseError"),
+        assert_eq!(loaded.definition.actions.len(), 1);
+        match &loaded.definition.actions[0] {
+            ActionType::Symlink(link) => {
+                assert_eq!(link.source, "./dotfiles/.zshrc");
+                assert_eq!(link.target, "~/.zshrc");
+                assert_eq!(link.force, Some(true));
+            }
+            other => panic!("Expected Symlink action, got {:?}", other),
         }
     }
 
     #[test]
-    fn test_load_module_no_default_export() {
+    fn test_load_module_aur_package_install() {
         let temp_dir = TempDir::new().unwrap();
         let content = r#"
-import { defineModule } from "./types";
-
-const module = defineModule("noexport")
-    .actions([]);
+export default defineModule("arch")
+    .actions([
+        packageInstall({ names: ["base-devel", "git"], manager: "pacman" }),
+        packageInstall({ names: ["visual-studio-code-bin"], aur: true })
+    ]);
 "#;
 
-        let discovered = create_test_module(temp_dir.path(), "noexport", content);
-        let result = load_module(&discovered);
+        let discovered = create_test_module(temp_dir.path(), "arch", content);
+        let loaded = load_module(&discovered).unwrap();
 
-        assert!(result.is_err());
-        match result.unwrap_err() {
-            LoadError::ValidationError(msg) => assert!(msg.contains("export default")),
-            _ => panic!("Expected ValidationError"),
+        assert_eq!(loaded.definition.actions.len(), 2);
+        match &loaded.definition.actions[1] {
+            ActionType::PackageInstall(pkg) => {
+                assert_eq!(pkg.names, vec!["visual-studio-code-bin".to_string()]);
+                assert_eq!(pkg.aur, Some(true));
+            }
+            other => panic!("Expected PackageInstall action, got {:?}", other),
         }
     }
 
     #[test]
-    fn test_load_module_file_not_found() {
-        let discovered = DiscoveredModule {
-            path: PathBuf::from("/nonexistent/file.ts"),
-            name: "nonexistent".to_string(),
-        };
+    fn test_load_module_flatpak_package_install() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("desktop")
+    .actions([
+        packageInstall({
+            names: ["org.mozilla.firefox"],
+            manager: "flatpak",
+            remote: "flathub",
+            scope: "user"
+        })
+    ]);
+"#;
 
-        let result = load_module(&discovered);
-        assert!(result.is_err());
-        match result.unwrap_err() {
-            LoadError::IoError(msg) => assert!(msg.contains("No such file")),
-            _ => panic!("Expected IoError"),
+        let discovered = create_test_module(temp_dir.path(), "desktop", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        match &loaded.definition.actions[0] {
+            ActionType::PackageInstall(pkg) => {
+                assert_eq!(pkg.manager, Some(PackageManager::Flatpak));
+                assert_eq!(pkg.remote, Some("flathub".to_string()));
+                assert_eq!(pkg.scope, Some("user".to_string()));
+            }
+            other => panic!("Expected PackageInstall action, got {:?}", other),
         }
     }
 
     #[test]
-    fn test_load_module_empty_file() {
+    fn test_load_module_brew_casks_and_taps() {
         let temp_dir = TempDir::new().unwrap();
-        let discovered = create_test_module(temp_dir.path(), "empty", "");
+        let content = r#"
+export default defineModule("mac")
+    .actions([
+        packageInstall({
+            names: ["ripgrep"],
+            manager: "brew",
+            casks: ["firefox", "iterm2"],
+            taps: ["homebrew/cask-fonts"]
+        })
+    ]);
+"#;
 
-        let result = load_module(&discovered);
-        assert!(result.is_err());
+        let discovered = create_test_module(temp_dir.path(), "mac", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        match &loaded.definition.actions[0] {
+            ActionType::PackageInstall(pkg) => {
+                assert_eq!(
+                    pkg.casks,
+                    Some(vec!["firefox".to_string(), "iterm2".to_string()])
+                );
+                assert_eq!(pkg.taps, Some(vec!["homebrew/cask-fonts".to_string()]));
+            }
+            other => panic!("Expected PackageInstall action, got {:?}", other),
+        }
     }
 
     #[test]
-    fn test_load_module_json_format() {
+    fn test_load_module_package_overrides() {
         let temp_dir = TempDir::new().unwrap();
-        // Some users might try to export JSON directly
         let content = r#"
-export default {
-    "name": "jsonmodule",
-    "description": "JSON format",
-    "actions": []
-};
+export default defineModule("cli-tools")
+    .actions([
+        packageInstall({
+            names: ["git", "fd"],
+            overrides: { fd: { debian: "fd-find", arch: "fd" } }
+        })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "cli-tools", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        match &loaded.definition.actions[0] {
+            ActionType::PackageInstall(pkg) => {
+                assert_eq!(pkg.manager, None);
+                let fd = pkg.overrides.as_ref().unwrap().get("fd").unwrap();
+                assert_eq!(fd.get("debian"), Some(&"fd-find".to_string()));
+                assert_eq!(fd.get("arch"), Some(&"fd".to_string()));
+            }
+            other => panic!("Expected PackageInstall action, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_package_removal() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("cleanup")
+    .actions([
+        packageInstall({ names: ["nano"], ensure: "absent" }),
+        packageRemove({ names: ["fd"], manager: "apt", overrides: { fd: { apt: "fd-find" } } })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "cleanup", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        match &loaded.definition.actions[0] {
+            ActionType::PackageInstall(pkg) => assert_eq!(pkg.ensure.as_deref(), Some("absent")),
+            other => panic!("Expected PackageInstall action, got {:?}", other),
+        }
+        match &loaded.definition.actions[1] {
+            ActionType::PackageRemove(pkg) => {
+                assert_eq!(pkg.names, vec!["fd".to_string()]);
+                assert_eq!(pkg.manager, Some(PackageManager::Apt));
+                assert!(pkg.overrides.as_ref().unwrap().contains_key("fd"));
+            }
+            other => panic!("Expected PackageRemove action, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_ensure_latest() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("tools")
+    .actions([
+        packageInstall({ names: ["neovim"], ensure: "latest" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "tools", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        match &loaded.definition.actions[0] {
+            ActionType::PackageInstall(pkg) => assert_eq!(pkg.ensure.as_deref(), Some("latest")),
+            other => panic!("Expected PackageInstall action, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_rejects_unknown_ensure() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("cleanup")
+    .actions([
+        packageInstall({ names: ["nano"], ensure: "gone" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "cleanup", content);
+        assert!(load_module(&discovered).is_err());
+    }
+
+    #[test]
+    fn test_load_module_template_action() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("git")
+    .actions([
+        template({
+            source: "gitconfig.tmpl",
+            target: "~/.gitconfig",
+            variables: { email: "jane@example.com", work: true, port: 22 }
+        })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "git", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 1);
+        match &loaded.definition.actions[0] {
+            ActionType::Template(tmpl) => {
+                assert_eq!(tmpl.source.as_deref(), Some("gitconfig.tmpl"));
+                assert_eq!(tmpl.target, "~/.gitconfig");
+                let variables = tmpl.variables.as_ref().unwrap();
+                assert_eq!(variables.get("email"), Some(&"jane@example.com".to_string()));
+                assert_eq!(variables.get("work"), Some(&"true".to_string()));
+                assert_eq!(variables.get("port"), Some(&"22".to_string()));
+            }
+            other => panic!("Expected Template action, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_template_dir_action() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("app")
+    .variables({ theme: "dark" })
+    .actions([
+        templateDir({
+            source: "config",
+            target: "~/.config/app",
+            variables: { email: "jane@example.com" },
+            extension: ".j2"
+        }),
+        templateDir({ source: "config" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "app", content);
+        let (loaded, warnings) = load_module_with_warnings(&discovered);
+        let loaded = loaded.unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 1);
+        let ActionType::TemplateDir(template) = &loaded.definition.actions[0] else {
+            panic!("Expected TemplateDir action");
+        };
+        assert_eq!(template.source, "config");
+        assert_eq!(template.target, "~/.config/app");
+        assert_eq!(template.extension.as_deref(), Some(".j2"));
+        let variables = template.variables.as_ref().unwrap();
+        assert_eq!(
+            variables.get("email"),
+            Some(&"jane@example.com".to_string())
+        );
+        assert_eq!(variables.get("theme"), Some(&"dark".to_string()));
+        assert_eq!(warnings.len(), 1, "{:?}", warnings);
+        assert!(warnings[0].contains("templateDir requires 'target' property"));
+    }
+
+    #[test]
+    fn test_load_module_desktop_entry_action() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("obsidian")
+    .actions([
+        desktopEntry({
+            name: "Obsidian",
+            exec: "/opt/obsidian/Obsidian.AppImage %U",
+            icon: "obsidian.png",
+            categories: ["Office"]
+        })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "obsidian", content);
+        let loaded = load_module(&discovered).unwrap();
+        let ActionType::DesktopEntry(entry) = &loaded.definition.actions[0] else {
+            panic!("Expected DesktopEntry action");
+        };
+        assert_eq!(entry.exec, "/opt/obsidian/Obsidian.AppImage %U");
+        assert_eq!(entry.categories.clone().unwrap_or_default(), ["Office"]);
+        assert_eq!(entry.file_id(), "obsidian");
+        assert_eq!(entry.ensure, None);
+
+        let content = r#"
+export default defineModule("obsidian")
+    .actions([desktopEntry({ name: "Obsidian", exec: "obsidian", id: "apps/obsidian" })]);
+"#;
+        let discovered = create_test_module(temp_dir.path(), "obsidian", content);
+        let err = load_module(&discovered).unwrap_err().to_string();
+        assert!(err.contains("desktopEntry 'id' must be a file name"));
+    }
+
+    #[test]
+    fn test_load_module_script_install_action() {
+        let temp_dir = TempDir::new().unwrap();
+        let sha256 = "a".repeat(64);
+        let content = format!(
+            r#"
+export default defineModule("rust")
+    .actions([
+        scriptInstall({{
+            url: "https://sh.rustup.rs",
+            sha256: "{}",
+            args: ["-y", "--no-modify-path"],
+            env: {{ RUSTUP_HOME: "/opt/rustup" }},
+            version: "1.28"
+        }})
+    ]);
+"#,
+            sha256
+        );
+
+        let discovered = create_test_module(temp_dir.path(), "rust", &content);
+        let loaded = load_module(&discovered).unwrap();
+        let ActionType::ScriptInstall(script) = &loaded.definition.actions[0] else {
+            panic!("Expected ScriptInstall action");
+        };
+        assert_eq!(script.url, "https://sh.rustup.rs");
+        assert_eq!(script.sha256, sha256);
+        assert_eq!(
+            script.args.clone().unwrap_or_default(),
+            ["-y", "--no-modify-path"]
+        );
+        let env = script.env.clone().unwrap_or_default();
+        assert_eq!(env["RUSTUP_HOME"], "/opt/rustup");
+        assert_eq!(script.version.as_deref(), Some("1.28"));
+
+        let content = r#"
+export default defineModule("rust")
+    .actions([scriptInstall({ url: "https://sh.rustup.rs", sha256: "abc" })]);
+"#;
+        let discovered = create_test_module(temp_dir.path(), "rust", content);
+        let err = load_module(&discovered).unwrap_err().to_string();
+        assert!(err.contains("scriptInstall 'sha256' must be 64 hex digits"));
+    }
+
+    #[test]
+    fn test_load_module_ssh_config_action() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("ssh")
+    .actions([
+        sshConfig({
+            hosts: [
+                { host: "work", hostname: "work.example.com", user: "me", port: 2222, identityFile: "~/.ssh/work" },
+                { host: "nas", options: { ForwardAgent: "yes" } },
+                { host: "old", absent: true }
+            ]
+        })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "ssh", content);
+        let loaded = load_module(&discovered).unwrap();
+        let ActionType::SshConfig(config) = &loaded.definition.actions[0] else {
+            panic!("Expected SshConfig action");
+        };
+        assert_eq!(config.path, None);
+        assert_eq!(config.hosts.len(), 3);
+        assert_eq!(config.hosts[0].port, Some(2222));
+        assert_eq!(
+            config.hosts[0].identity_file.as_deref(),
+            Some("~/.ssh/work")
+        );
+        assert_eq!(config.hosts[1].block(), "Host nas\n    ForwardAgent yes\n");
+        assert_eq!(config.hosts[2].absent, Some(true));
+
+        let content = r#"
+export default defineModule("ssh")
+    .actions([sshConfig({ hosts: [{ host: "work" }, { host: "work", user: "me" }] })]);
+"#;
+        let discovered = create_test_module(temp_dir.path(), "ssh", content);
+        let err = load_module(&discovered).unwrap_err().to_string();
+        assert!(
+            err.contains("sshConfig has host 'work' more than once"),
+            "{}",
+            err
+        );
+    }
+
+    #[test]
+    fn test_load_module_deprecations() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("legacy")
+    .actions([
+        linkDotfile({ source: "vimrc", target: "~/.vimrc" }),
+        copyFile({ source: "hosts", target: "/etc/hosts", requiresPrivilegeEscalation: true }),
+        copyFile({ source: "motd", target: "/etc/motd", requiresPrivilegeEscalation: true })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "legacy", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        // The deprecated forms still work
+        assert!(matches!(
+            loaded.definition.actions[0],
+            ActionType::LinkFile(_)
+        ));
+        assert!(loaded.definition.actions[1].escalates());
+        let deprecations = crate::deprecation::found();
+        assert_eq!(
+            deprecations,
+            [
+                "linkDotfile is deprecated, use linkFile instead",
+                "copyFile's 'requiresPrivilegeEscalation' is deprecated, use 'escalate' instead",
+            ]
+        );
+        assert!(crate::deprecation::reported().contains(
+            &"module 'legacy': linkDotfile is deprecated, use linkFile instead".to_string()
+        ));
+
+        let discovered = create_test_module(
+            temp_dir.path(),
+            "legacy",
+            r#"export default { name: "legacy", dependencies: ["base"], actions: [] };"#,
+        );
+        let loaded = load_module(&discovered).unwrap();
+        assert_eq!(loaded.definition.dependencies, vec!["base"]);
+        assert_eq!(
+            crate::deprecation::found(),
+            ["the module key 'dependencies' is deprecated, use 'dependsOn' instead"]
+        );
+
+        // Loading the next module starts over
+        let discovered = create_test_module(
+            temp_dir.path(),
+            "current",
+            r#"export default defineModule("current").actions([ensureDir({ path: "~/.vim" })]);"#,
+        );
+        load_module(&discovered).unwrap();
+        assert!(crate::deprecation::found().is_empty());
+    }
+
+    #[test]
+    fn test_load_module_inline_content() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("editor")
+    .actions([
+        copyFile({ content: "EDITOR=nvim\n", target: "~/.config/environment.d/editor.conf" }),
+        template({ content: `[user]
+  email = {{ email }}
+`, target: "~/.gitconfig", variables: { email: "jane@example.com" } })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "editor", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        let ActionType::CopyFile(copy) = &loaded.definition.actions[0] else {
+            panic!("Expected CopyFile action");
+        };
+        assert_eq!(copy.source, None);
+        assert_eq!(copy.content.as_deref(), Some("EDITOR=nvim\n"));
+        let ActionType::Template(template) = &loaded.definition.actions[1] else {
+            panic!("Expected Template action");
+        };
+        assert_eq!(
+            template.content.as_deref(),
+            Some("[user]\n  email = {{ email }}\n")
+        );
+
+        // Invalid actions are left out with a warning
+        let content = r#"
+export default defineModule("bad")
+    .actions([
+        copyFile({ target: "~/.zshrc" }),
+        template({ source: "a.tmpl", content: "a", target: "~/a" })
+    ]);
+"#;
+        let discovered = create_test_module(temp_dir.path(), "bad", content);
+        let (loaded, warnings) = load_module_with_warnings(&discovered);
+        assert!(loaded.unwrap().definition.actions.is_empty());
+        assert_eq!(warnings.len(), 2, "{:?}", warnings);
+        assert!(warnings[0].contains("copyFile requires 'source' or 'content' property"));
+        assert!(warnings[1].contains("template takes either 'source' or 'content', not both"));
+    }
+
+    #[test]
+    fn test_load_module_systemd_user_unit() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("services")
+    .actions([
+        systemdService({
+            name: "syncthing.service",
+            unit: `[Unit]
+Description=Syncthing
+
+[Service]
+ExecStart=/usr/bin/syncthing serve --no-browser
+`,
+            enable: true,
+            start: true
+        })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "services", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        match &loaded.definition.actions[0] {
+            ActionType::SystemdService(service) => {
+                assert_eq!(service.name, "syncthing.service");
+                assert!(service.unit.as_ref().unwrap().contains("ExecStart=/usr/bin/syncthing"));
+                assert_eq!(service.exec_start, None);
+                assert_eq!(service.scope, None);
+                assert_eq!(service.enable, Some(true));
+                assert_eq!(service.start, Some(true));
+            }
+            other => panic!("Expected SystemdService action, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_systemd_service_refresh_in_handler() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("nginx")
+    .handlers({
+        "reload-nginx": systemdService({ name: "nginx.service", execStart: "/usr/sbin/nginx", scope: "system", action: "reload" }),
+    })
+    .actions([
+        systemdService({ name: "nginx.service", execStart: "/usr/sbin/nginx", scope: "system", start: true, action: "restart" }),
+        systemdService({ name: "broken.service", execStart: "/bin/true", action: "bounce" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "nginx", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 1);
+        match &loaded.definition.actions[0] {
+            ActionType::SystemdService(service) => {
+                assert_eq!(service.action.as_deref(), Some("restart"));
+                assert!(!service.notified);
+            }
+            other => panic!("Expected SystemdService action, got {:?}", other),
+        }
+        match &loaded.definition.handlers[0].actions[0] {
+            ActionType::SystemdService(service) => {
+                assert_eq!(service.action.as_deref(), Some("reload"));
+                assert!(service.notified);
+            }
+            other => panic!("Expected SystemdService handler, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_decrypt_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("ssh")
+    .actions([
+        decryptFile({
+            source: "./secrets/id_ed25519.age",
+            target: "~/.ssh/id_ed25519",
+            mode: 0o600,
+            identity: "~/.config/age/yubikey.txt"
+        })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "ssh", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 1);
+        match &loaded.definition.actions[0] {
+            ActionType::DecryptFile(decrypt) => {
+                assert_eq!(decrypt.source, "./secrets/id_ed25519.age");
+                assert_eq!(decrypt.target, "~/.ssh/id_ed25519");
+                assert_eq!(decrypt.mode, Some(0o600));
+                assert_eq!(
+                    decrypt.identity.as_deref(),
+                    Some("~/.config/age/yubikey.txt")
+                );
+            }
+            other => panic!("Expected DecryptFile action, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_env_var() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("shell")
+    .actions([
+        envVar({ name: "EDITOR", value: "nvim" }),
+        envVar({ pathPrepend: "~/.local/bin", shells: ["zsh", "fish"] }),
+        envVar({ name: "PAGER" }),
+        envVar({ pathPrepend: "~/bin", shells: ["tcsh"] })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "shell", content);
+        let (loaded, warnings) = load_module_with_warnings(&discovered);
+        let loaded = loaded.unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 2);
+        match &loaded.definition.actions[0] {
+            ActionType::EnvVar(env) => {
+                assert_eq!(env.name.as_deref(), Some("EDITOR"));
+                assert_eq!(env.value.as_deref(), Some("nvim"));
+                assert_eq!(env.shells, None);
+            }
+            other => panic!("Expected EnvVar action, got {:?}", other),
+        }
+        match &loaded.definition.actions[1] {
+            ActionType::EnvVar(env) => {
+                assert_eq!(env.path_prepend.as_deref(), Some("~/.local/bin"));
+                assert_eq!(
+                    env.shells,
+                    Some(vec!["zsh".to_string(), "fish".to_string()])
+                );
+            }
+            other => panic!("Expected EnvVar action, got {:?}", other),
+        }
+        assert_eq!(warnings.len(), 2, "{:?}", warnings);
+        assert!(warnings[0].contains("both 'name' and 'value'"));
+        assert!(warnings[1].contains("Unknown shell 'tcsh'"));
+    }
+
+    #[test]
+    fn test_load_module_shell_source() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("shell")
+    .actions([
+        envVar({ name: "EDITOR", value: "nvim", wire: false }),
+        shellSource({ shells: ["zsh"] }),
+        shellSource({ file: "~/.config/dhd/aliases.sh", ensure: "absent" }),
+        shellSource({ ensure: "gone" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "shell", content);
+        let (loaded, warnings) = load_module_with_warnings(&discovered);
+        let loaded = loaded.unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 3);
+        match &loaded.definition.actions[0] {
+            ActionType::EnvVar(env) => assert_eq!(env.wire, Some(false)),
+            other => panic!("Expected EnvVar action, got {:?}", other),
+        }
+        match &loaded.definition.actions[2] {
+            ActionType::ShellSource(source) => {
+                assert_eq!(source.file.as_deref(), Some("~/.config/dhd/aliases.sh"));
+                assert_eq!(source.shells, None);
+                assert_eq!(source.ensure.as_deref(), Some("absent"));
+            }
+            other => panic!("Expected ShellSource action, got {:?}", other),
+        }
+        assert_eq!(warnings.len(), 1, "{:?}", warnings);
+        assert!(warnings[0].contains("not \"gone\""));
+    }
+
+    #[test]
+    fn test_load_module_block_and_line_in_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("ssh")
+    .actions([
+        blockInFile({
+            path: "~/.ssh/config",
+            name: "work",
+            content: `Host work
+    User me`
+        }),
+        blockInFile({ path: "~/.ssh/config", name: "old", absent: true }),
+        lineInFile({ path: "/etc/hosts", line: "10.0.0.5 nas", escalate: true }),
+        blockInFile({ path: "~/.ssh/config", name: "empty" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "ssh", content);
+        let (loaded, warnings) = load_module_with_warnings(&discovered);
+        let loaded = loaded.unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 3);
+        match &loaded.definition.actions[0] {
+            ActionType::BlockInFile(block) => {
+                assert_eq!(block.path, "~/.ssh/config");
+                assert_eq!(block.name, "work");
+                assert_eq!(block.content.as_deref(), Some("Host work\n    User me"));
+                assert_eq!(block.absent, None);
+            }
+            other => panic!("Expected BlockInFile action, got {:?}", other),
+        }
+        match &loaded.definition.actions[1] {
+            ActionType::BlockInFile(block) => assert_eq!(block.absent, Some(true)),
+            other => panic!("Expected BlockInFile action, got {:?}", other),
+        }
+        match &loaded.definition.actions[2] {
+            ActionType::LineInFile(line) => {
+                assert_eq!(line.line, "10.0.0.5 nas");
+                assert_eq!(line.escalate, Some(true));
+            }
+            other => panic!("Expected LineInFile action, got {:?}", other),
+        }
+        assert_eq!(warnings.len(), 1, "{:?}", warnings);
+        assert!(warnings[0].contains("requires 'content'"));
+    }
+
+    #[test]
+    fn test_load_module_become() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("hosts")
+    .actions([
+        copyFile({ source: "hosts", target: "/etc/hosts", become: true, notify: "flush" }),
+        executeCommand({ command: "update-ca-certificates", become: true }),
+        { type: "Directory", path: "/etc/app", become: true },
+        symlink({ source: "zshrc", target: "~/.zshrc", become: true })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "hosts", content);
+        let (loaded, warnings) = load_module_with_warnings(&discovered);
+        let loaded = loaded.unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 4);
+        let ActionType::Notify(notify) = &loaded.definition.actions[0] else {
+            panic!("Expected Notify action");
+        };
+        assert!(matches!(notify.action.as_ref(), ActionType::CopyFile(copy) if copy.escalate));
+        assert!(
+            loaded.definition.actions[..3]
+                .iter()
+                .all(|action| action.escalates())
+        );
+        assert!(!loaded.definition.actions[3].escalates());
+        assert!(
+            warnings
+                .iter()
+                .any(|warning| warning.contains("'become' has no effect on symlink")),
+            "{:?}",
+            warnings
+        );
+    }
+
+    #[test]
+    fn test_load_module_ensure_dir() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("app")
+    .actions([
+        ensureDir({ path: "~/.config/app", mode: 0o700, recursive: false }),
+        copyFile({ source: "app.conf", target: "~/.config/app/app.conf", createParents: false })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "app", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 2);
+        match &loaded.definition.actions[0] {
+            ActionType::Directory(dir) => {
+                assert_eq!(dir.path, "~/.config/app");
+                assert_eq!(dir.mode, Some(0o700));
+                assert_eq!(dir.recursive, Some(false));
+            }
+            other => panic!("Expected Directory action, got {:?}", other),
+        }
+        match &loaded.definition.actions[1] {
+            ActionType::CopyFile(copy) => assert_eq!(copy.create_parents, Some(false)),
+            other => panic!("Expected CopyFile action, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_remote_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("tools")
+    .actions([
+        remoteFile({
+            url: "https://example.com/tool-1.0.tar.gz",
+            target: "~/.local/bin/tool",
+            sha256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+            mode: 0o755,
+            extract: "tool-1.0/tool",
+            arch: { arm64: { url: "https://example.com/tool-1.0-arm64.tar.gz" } }
+        }),
+        remoteFile({ url: "https://example.com/tool", target: "~/tool", sha256: "abc" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "tools", content);
+        let (loaded, warnings) = load_module_with_warnings(&discovered);
+        let loaded = loaded.unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 1);
+        match &loaded.definition.actions[0] {
+            ActionType::RemoteFile(remote) => {
+                assert_eq!(remote.target, "~/.local/bin/tool");
+                assert_eq!(remote.mode, Some(0o755));
+                assert_eq!(remote.extract.as_deref(), Some("tool-1.0/tool"));
+                let arm64 = &remote.arch.as_ref().unwrap()["arm64"];
+                assert_eq!(
+                    arm64.url.as_deref(),
+                    Some("https://example.com/tool-1.0-arm64.tar.gz")
+                );
+                assert_eq!(arm64.sha256, None);
+            }
+            other => panic!("Expected RemoteFile action, got {:?}", other),
+        }
+        assert_eq!(warnings.len(), 1, "{:?}", warnings);
+        assert!(warnings[0].contains("must be 64 hex digits"));
+    }
+
+    #[test]
+    fn test_load_module_cron() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("backups")
+    .actions([
+        cron({ name: "backup", schedule: "0 3 * * *", command: "restic backup ~" }),
+        cron({ name: "updates", schedule: "@weekly", command: "topgrade", ensure: "absent", backend: "systemd" }),
+        cron({ name: "broken", schedule: "0 3 * *", command: "true" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "backups", content);
+        let (loaded, warnings) = load_module_with_warnings(&discovered);
+        let loaded = loaded.unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 2);
+        match &loaded.definition.actions[0] {
+            ActionType::Cron(cron) => {
+                assert_eq!(cron.name, "backup");
+                assert_eq!(cron.schedule, "0 3 * * *");
+                assert_eq!(cron.command, "restic backup ~");
+                assert_eq!(cron.ensure, None);
+            }
+            other => panic!("Expected Cron action, got {:?}", other),
+        }
+        match &loaded.definition.actions[1] {
+            ActionType::Cron(cron) => {
+                assert_eq!(cron.ensure.as_deref(), Some("absent"));
+                assert_eq!(cron.backend.as_deref(), Some("systemd"));
+            }
+            other => panic!("Expected Cron action, got {:?}", other),
+        }
+        assert_eq!(warnings.len(), 1, "{:?}", warnings);
+        assert!(warnings[0].contains("needs 5 fields"));
+    }
+
+    #[test]
+    fn test_load_module_plugin() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("deploy")
+    .actions([
+        plugin({
+            name: "vpn",
+            command: "./plugins/vpn",
+            spec: { server: "vpn.example.com", routes: ["10.0.0.0/8"] }
+        }),
+        plugin({ name: "audit" }),
+        plugin({ spec: {} })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "deploy", content);
+        let (loaded, warnings) = load_module_with_warnings(&discovered);
+        let loaded = loaded.unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 2);
+        match &loaded.definition.actions[0] {
+            ActionType::Plugin(plugin) => {
+                assert_eq!(plugin.name, "vpn");
+                assert_eq!(plugin.command.as_deref(), Some("./plugins/vpn"));
+                assert_eq!(
+                    plugin.spec,
+                    Some(serde_json::json!({
+                        "server": "vpn.example.com",
+                        "routes": ["10.0.0.0/8"]
+                    }))
+                );
+            }
+            other => panic!("Expected Plugin action, got {:?}", other),
+        }
+        match &loaded.definition.actions[1] {
+            ActionType::Plugin(plugin) => {
+                assert_eq!(plugin.command, None);
+                assert_eq!(plugin.spec, None);
+            }
+            other => panic!("Expected Plugin action, got {:?}", other),
+        }
+        assert_eq!(warnings.len(), 1, "{:?}", warnings);
+        assert!(warnings[0].contains("plugin requires 'name' property"));
+    }
+
+    #[test]
+    fn test_load_module_stow() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("dotfiles")
+    .actions([
+        stow({ source: "nvim" }),
+        stow({ source: "zsh", target: "~/", delete: true }),
+        stow({ source: "git", adopt: true, force: true })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "dotfiles", content);
+        let (loaded, warnings) = load_module_with_warnings(&discovered);
+        let loaded = loaded.unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 2);
+        match &loaded.definition.actions[1] {
+            ActionType::Stow(stow) => {
+                assert_eq!(stow.source, "zsh");
+                assert_eq!(stow.target.as_deref(), Some("~/"));
+                assert_eq!(stow.delete, Some(true));
+                assert_eq!(stow.adopt, None);
+            }
+            other => panic!("Expected Stow action, got {:?}", other),
+        }
+        assert_eq!(warnings.len(), 1, "{:?}", warnings);
+        assert!(warnings[0].contains("not both"));
+    }
+
+    #[test]
+    fn test_load_module_package_repo() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("docker")
+    .actions([
+        packageRepo({
+            name: "docker",
+            uri: "https://download.docker.com/linux/ubuntu",
+            key: "https://download.docker.com/linux/ubuntu/gpg",
+            components: ["stable"],
+            manager: "apt"
+        }),
+        packageRepo({ name: "docker", uri: "https://example.com", manager: "zypper" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "docker", content);
+        let (loaded, warnings) = load_module_with_warnings(&discovered);
+        let loaded = loaded.unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 1);
+        match &loaded.definition.actions[0] {
+            ActionType::PackageRepo(repo) => {
+                assert_eq!(repo.name, "docker");
+                assert_eq!(repo.components, Some(vec!["stable".to_string()]));
+                assert_eq!(repo.manager.as_deref(), Some("apt"));
+                assert_eq!(repo.distro, None);
+            }
+            other => panic!("Expected PackageRepo action, got {:?}", other),
+        }
+        assert_eq!(warnings.len(), 1, "{:?}", warnings);
+        assert!(warnings[0].contains("'manager' must be"));
+    }
+
+    #[test]
+    fn test_load_module_gpg_key() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("signing")
+    .actions([
+        gpgKey({ keyId: "0xABCD1234", trust: "ultimate" }),
+        gpgKey({
+            keyUrl: "https://download.docker.com/linux/ubuntu/gpg",
+            keyring: "/etc/apt/keyrings/docker.gpg",
+            escalate: true
+        }),
+        gpgKey({ keyId: "0xABCD1234", trust: "total" }),
+        gpgKey({ trust: "full" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "signing", content);
+        let (loaded, warnings) = load_module_with_warnings(&discovered);
+        let loaded = loaded.unwrap();
+
+        assert_eq!(loaded.definition.actions.len(), 2);
+        match &loaded.definition.actions[0] {
+            ActionType::GpgKey(key) => {
+                assert_eq!(key.key_id.as_deref(), Some("0xABCD1234"));
+                assert_eq!(key.trust.as_deref(), Some("ultimate"));
+            }
+            other => panic!("Expected GpgKey action, got {:?}", other),
+        }
+        match &loaded.definition.actions[1] {
+            ActionType::GpgKey(key) => {
+                assert_eq!(key.keyring.as_deref(), Some("/etc/apt/keyrings/docker.gpg"));
+                assert_eq!(key.escalate, Some(true));
+            }
+            other => panic!("Expected GpgKey action, got {:?}", other),
+        }
+        assert_eq!(warnings.len(), 2, "{:?}", warnings);
+        assert!(warnings[0].contains("'trust' must be one of"));
+        assert!(warnings[1].contains("requires 'keyId', 'keyUrl' or 'keyFile'"));
+    }
+
+    #[test]
+    fn test_load_module_git_repo() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("zsh")
+    .actions([
+        gitRepo({
+            url: "https://github.com/zsh-users/zsh-autosuggestions",
+            path: "~/.oh-my-zsh/custom/plugins/zsh-autosuggestions",
+            ref: "v0.7.0",
+            depth: 1,
+            update: true,
+            retries: 5,
+            retryDelay: 3
+        })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "zsh", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        match &loaded.definition.actions[0] {
+            ActionType::GitRepo(repo) => {
+                assert_eq!(repo.url, "https://github.com/zsh-users/zsh-autosuggestions");
+                assert_eq!(repo.path, "~/.oh-my-zsh/custom/plugins/zsh-autosuggestions");
+                assert_eq!(repo.r#ref, Some("v0.7.0".to_string()));
+                assert_eq!(repo.depth, Some(1));
+                assert_eq!(repo.update, Some(true));
+                assert_eq!(repo.retries, Some(5));
+                assert_eq!(repo.retry_delay, Some(3));
+            }
+            other => panic!("Expected GitRepo action, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_command_with_guards() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("rust")
+    .actions([
+        command({
+            run: "curl -sSf https://sh.rustup.rs | sh -s -- -y",
+            unless: "command -v rustup",
+            cwd: "~"
+        })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "rust", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        match &loaded.definition.actions[0] {
+            ActionType::ShellCommand(cmd) => {
+                assert_eq!(cmd.run, "curl -sSf https://sh.rustup.rs | sh -s -- -y");
+                assert_eq!(cmd.unless, Some("command -v rustup".to_string()));
+                assert_eq!(cmd.only_if, None);
+                assert_eq!(cmd.shell, None);
+                assert_eq!(cmd.cwd, Some("~".to_string()));
+            }
+            other => panic!("Expected ShellCommand action, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_git_config_entries() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("git")
+    .actions([
+        gitConfig({
+            scope: "global",
+            settings: { "user.name": "Your Name", "init.defaultBranch": "main" }
+        }),
+        gitConfig({ entries: { "user.signingkey": "" }, path: "work.gitconfig", ensure: "absent" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "git", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        match &loaded.definition.actions[..] {
+            [ActionType::GitConfig(settings), ActionType::GitConfig(absent)] => {
+                assert_eq!(settings.scope.as_deref(), Some("global"));
+                assert_eq!(settings.entries.as_ref().unwrap()["user.name"], "Your Name");
+                assert_eq!(absent.path.as_deref(), Some("work.gitconfig"));
+                assert_eq!(absent.ensure.as_deref(), Some("absent"));
+            }
+            other => panic!("Expected two GitConfig actions, got {:?}", other),
+        }
+
+        let content = r#"
+export default defineModule("git")
+    .actions([gitConfig({ scope: "user", entries: { "user.name": "Dev" } })]);
+"#;
+        let discovered = create_test_module(temp_dir.path(), "git", content);
+        let err = load_module(&discovered).unwrap_err().to_string();
+        assert!(err.contains("'scope' must be 'global', 'local' or 'system'"));
+    }
+
+    #[test]
+    fn test_load_module_command_with_outcome() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("flatpak")
+    .actions([
+        command({
+            run: "flatpak update -y",
+            changedWhen: 'echo "$DHD_STDOUT" | grep -q Updating',
+            failedWhen: 'test "$DHD_EXIT_CODE" -gt 1'
+        })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "flatpak", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        match &loaded.definition.actions[0] {
+            ActionType::ShellCommand(cmd) => {
+                assert_eq!(
+                    cmd.changed_when.as_deref(),
+                    Some(r#"echo "$DHD_STDOUT" | grep -q Updating"#)
+                );
+                assert_eq!(
+                    cmd.failed_when.as_deref(),
+                    Some(r#"test "$DHD_EXIT_CODE" -gt 1"#)
+                );
+            }
+            other => panic!("Expected ShellCommand action, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_hooks() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("nginx")
+    .preApply("nginx -t")
+    .postApply({ run: "systemctl reload nginx", onlyIfChanged: true, continueOnError: true })
+    .actions([
+        command({ run: "true" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "nginx", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        let pre = loaded.definition.pre_apply.unwrap();
+        assert_eq!(pre.run, "nginx -t");
+        assert_eq!(pre.only_if_changed, None);
+
+        let post = loaded.definition.post_apply.unwrap();
+        assert_eq!(post.run, "systemctl reload nginx");
+        assert_eq!(post.only_if_changed, Some(true));
+        assert_eq!(post.continue_on_error, Some(true));
+        assert_eq!(post.shell, None);
+    }
+
+    #[test]
+    fn test_load_module_command_and_hook_env() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("fish")
+    .preApply({ run: "echo $GREETING", shell: "fish", env: { GREETING: "hi" } })
+    .actions([
+        command({ run: "set -U EDITOR $CHOICE", shell: "fish", env: { CHOICE: "nvim" } })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "fish", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        let pre = loaded.definition.pre_apply.unwrap();
+        assert_eq!(pre.shell.as_deref(), Some("fish"));
+        assert_eq!(pre.env.unwrap()["GREETING"], "hi");
+
+        match &loaded.definition.actions[0] {
+            ActionType::ShellCommand(cmd) => {
+                assert_eq!(cmd.shell.as_deref(), Some("fish"));
+                assert_eq!(cmd.env.as_ref().unwrap()["CHOICE"], "nvim");
+            }
+            other => panic!("Expected ShellCommand action, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_applies_namespace() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("zsh")
+    .dependsOn(["fonts", "other/git"])
+    .actions([]);
+"#;
+
+        let mut discovered = create_test_module(temp_dir.path(), "zsh", content);
+        discovered.namespace = Some("base".to_string());
+        let loaded = load_module(&discovered).unwrap();
+
+        assert_eq!(loaded.definition.name, "base/zsh");
+        assert_eq!(
+            loaded.definition.dependencies,
+            vec!["base/fonts".to_string(), "other/git".to_string()]
+        );
+    }
+
+    #[test]
+    fn test_load_config() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("dhd.config.ts");
+        fs::write(
+            &path,
+            r#"
+export default defineConfig({
+    imports: [
+        { path: "../baseline" },
+        { git: "https://github.com/acme/dotfiles.git", ref: "v1.2.0", namespace: "team" },
+    ],
+    variables: { email: "jane@example.com", work: true },
+    backup: false,
+    jobs: 4,
+    secretEnvPrefix: "CI_SECRET_",
+    historyLimit: 20,
+});
+"#,
+        )
+        .unwrap();
+
+        let config = load_config(&path).unwrap();
+        let imports = config.imports.unwrap();
+        assert_eq!(imports.len(), 2);
+        assert_eq!(imports[0].path.as_deref(), Some("../baseline"));
+        assert_eq!(imports[1].r#ref.as_deref(), Some("v1.2.0"));
+        assert_eq!(imports[1].namespace.as_deref(), Some("team"));
+        let variables = config.variables.unwrap();
+        assert_eq!(variables.get("work").map(String::as_str), Some("true"));
+        assert_eq!(config.backup, Some(false));
+        assert_eq!(config.jobs, Some(4));
+        assert_eq!(config.secret_env_prefix.as_deref(), Some("CI_SECRET_"));
+        assert_eq!(config.history_limit, Some(20));
+
+        fs::write(
+            &path,
+            r#"
+export default defineConfig({
+    hosts: {
+        laptop: { tags: ["desktop"], variables: { monitor: "eDP-1" } },
+        "build-box": { modules: ["docker"] },
+    },
+});
+"#,
+        )
+        .unwrap();
+        let hosts = load_config(&path).unwrap().hosts.unwrap();
+        assert_eq!(hosts["laptop"].tags, Some(vec!["desktop".to_string()]));
+        assert_eq!(
+            hosts["laptop"].variables.as_ref().unwrap().get("monitor"),
+            Some(&"eDP-1".to_string())
+        );
+        assert_eq!(hosts["build-box"].modules, Some(vec!["docker".to_string()]));
+        assert_eq!(hosts["build-box"].tags, None);
+
+        fs::write(
+            &path,
+            r#"
+export default defineConfig({
+    groups: { web: { hosts: ["web01"], tags: ["server"], variables: { port: 8080 } } },
+    hosts: { web01: { groups: ["web"] } },
+});
+"#,
+        )
+        .unwrap();
+        let config = load_config(&path).unwrap();
+        let web = &config.groups.as_ref().unwrap()["web"];
+        assert_eq!(web.hosts, Some(vec!["web01".to_string()]));
+        assert_eq!(web.tags, Some(vec!["server".to_string()]));
+        assert_eq!(
+            web.variables.as_ref().unwrap().get("port"),
+            Some(&"8080".to_string())
+        );
+        assert_eq!(
+            config.hosts.unwrap()["web01"].groups,
+            Some(vec!["web".to_string()])
+        );
+
+        fs::write(
+            &path,
+            r#"export default defineConfig({ hosts: { web01: { groups: ["web"] } } });"#,
+        )
+        .unwrap();
+        match load_config(&path) {
+            Err(LoadError::ValidationError(msg)) => assert_eq!(
+                msg,
+                "Host 'web01' is in group 'web', which 'groups' doesn't define"
+            ),
+            other => panic!("Expected ValidationError, got {:?}", other.map(|_| ())),
+        }
+
+        fs::write(&path, r#"export default defineConfig({ jobs: 0 });"#).unwrap();
+        assert!(matches!(
+            load_config(&path),
+            Err(LoadError::ValidationError(_))
+        ));
+
+        fs::write(
+            &path,
+            r#"export default { imports: [{ path: "a", git: "b" }] };"#,
+        )
+        .unwrap();
+        assert!(matches!(
+            load_config(&path),
+            Err(LoadError::ValidationError(_))
+        ));
+    }
+
+    #[test]
+    fn test_config_and_module_variables_reach_templates() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("git")
+    .variables({ email: "jane@work.example", signing: true })
+    .handlers({ work: template({ source: "work.tmpl", target: "~/.gitconfig-work" }) })
+    .actions([
+        template({ source: "gitconfig.tmpl", target: "~/.gitconfig", variables: { signing: false }, notify: "work" })
+    ]);
+"#;
+
+        let mut discovered = create_test_module(temp_dir.path(), "git", content);
+        discovered.variables = HashMap::from([
+            ("email".to_string(), "jane@example.com".to_string()),
+            ("editor".to_string(), "nvim".to_string()),
+        ]);
+        let loaded = load_module(&discovered).unwrap();
+
+        let ActionType::Notify(notify) = &loaded.definition.actions[0] else {
+            panic!("Expected Notify action");
+        };
+        let ActionType::Template(template) = notify.action.as_ref() else {
+            panic!("Expected Template action");
+        };
+        let variables = template.variables.as_ref().unwrap();
+        assert_eq!(variables.get("editor").map(String::as_str), Some("nvim"));
+        assert_eq!(
+            variables.get("email").map(String::as_str),
+            Some("jane@work.example")
+        );
+        assert_eq!(variables.get("signing").map(String::as_str), Some("false"));
+
+        let ActionType::Template(template) = &loaded.definition.handlers[0].actions[0] else {
+            panic!("Expected Template action");
+        };
+        let variables = template.variables.as_ref().unwrap();
+        assert_eq!(variables.get("signing").map(String::as_str), Some("true"));
+    }
+
+    #[test]
+    fn test_overrides_win_over_every_other_variable() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("git")
+    .variables({ email: "jane@work.example" })
+    .variableSchema({ signing: { type: "bool" } })
+    .actions([
+        template({ source: "gitconfig.tmpl", target: "~/{{ file }}", variables: { email: "jane@home.example" } })
+    ]);
+"#;
+        let discovered = create_test_module(temp_dir.path(), "git", content);
+        let config = HashMap::from([("file".to_string(), ".gitconfig".to_string())]);
+        let overrides = HashMap::from([
+            ("email".to_string(), "user@example.com".to_string()),
+            ("file".to_string(), ".gitconfig-test".to_string()),
+        ]);
+
+        let mut module_def = parse_typescript_module(&discovered.path, content).unwrap();
+        apply_variables(&mut module_def, &config, &overrides).unwrap();
+        let ActionType::Template(template) = &module_def.actions[0] else {
+            panic!("Expected Template action");
+        };
+        assert_eq!(template.target, "~/.gitconfig-test");
+        let variables = template.variables.as_ref().unwrap();
+        assert_eq!(
+            variables.get("email").map(String::as_str),
+            Some("user@example.com")
+        );
+
+        // Overrides are checked against the schema like any other value
+        let overrides = HashMap::from([("signing".to_string(), "yes".to_string())]);
+        let mut module_def = parse_typescript_module(&discovered.path, content).unwrap();
+        assert!(apply_variables(&mut module_def, &config, &overrides).is_err());
+    }
+
+    #[test]
+    fn test_variables_fill_in_paths() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("apps")
+    .variables({ app: "foot" })
+    .actions([
+        copyFile({ source: "{{ app }}.ini", target: "~/.config/{{ app }}/{{ app }}.ini" }),
+        template({ source: "theme.tmpl", target: "~/.config/{{ app }}/{{ theme }}.ini", variables: { theme: "dark" } }),
+        ensureDir({ path: "~/.cache/{{ host.os }}" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "apps", content);
+        let loaded = load_module(&discovered).unwrap();
+        let ActionType::CopyFile(copy) = &loaded.definition.actions[0] else {
+            panic!("Expected CopyFile action");
+        };
+        assert_eq!(copy.source.as_deref(), Some("foot.ini"));
+        assert_eq!(copy.target, "~/.config/foot/foot.ini");
+        let ActionType::Template(template) = &loaded.definition.actions[1] else {
+            panic!("Expected Template action");
+        };
+        assert_eq!(template.target, "~/.config/foot/dark.ini");
+        let ActionType::Directory(directory) = &loaded.definition.actions[2] else {
+            panic!("Expected Directory action");
+        };
+        assert!(!directory.path.contains("{{"), "{}", directory.path);
+
+        let content = r#"
+export default defineModule("apps")
+    .actions([copyFile({ source: "app.ini", target: "~/.config/{{ app }}/app.ini" })]);
+"#;
+        let discovered = create_test_module(temp_dir.path(), "apps", content);
+        let Err(LoadError::ValidationError(error)) = load_module(&discovered) else {
+            panic!("Expected the path to be rejected");
+        };
+        assert_eq!(
+            error,
+            "Failed to render target '~/.config/{{ app }}/app.ini': Unknown variable 'app'"
+        );
+    }
+
+    #[test]
+    fn test_variable_schema_checks_and_fills_in_variables() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("git")
+    .variableSchema({
+        email: { type: "string", required: true },
+        port: { type: "number", default: 22 },
+        theme: { type: "enum", values: ["dark", "light"], default: "dark" }
+    })
+    .actions([
+        template({ source: "gitconfig.tmpl", target: "~/.gitconfig" })
+    ]);
+"#;
+
+        let mut discovered = create_test_module(temp_dir.path(), "git", content);
+        discovered.variables = HashMap::from([
+            ("port".to_string(), "ssh".to_string()),
+            ("theme".to_string(), "blue".to_string()),
+        ]);
+        let Err(LoadError::ValidationError(error)) = load_module(&discovered) else {
+            panic!("Expected the variables to be rejected");
+        };
+        assert!(error.contains("variable 'email' is required"), "{}", error);
+        assert!(error.contains("must be a number"), "{}", error);
+        assert!(error.contains("must be one of dark, light"), "{}", error);
+
+        discovered.variables =
+            HashMap::from([("email".to_string(), "user@example.com".to_string())]);
+        let loaded = load_module(&discovered).unwrap();
+        let ActionType::Template(template) = &loaded.definition.actions[0] else {
+            panic!("Expected Template action");
+        };
+        let variables = template.variables.as_ref().unwrap();
+        assert_eq!(variables.get("port").map(String::as_str), Some("22"));
+        assert_eq!(variables.get("theme").map(String::as_str), Some("dark"));
+        assert_eq!(
+            loaded.definition.variable_schema["email"].required,
+            Some(true)
+        );
+    }
+
+    #[test]
+    fn test_sensitive_variables_are_masked_once_loaded() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("vpn")
+    .variableSchema({
+        endpoint: { type: "string", sensitive: true },
+        region: { type: "string" }
+    })
+    .actions([
+        template({ source: "vpn.tmpl", target: "~/.config/vpn.conf" })
+    ]);
+"#;
+
+        let mut discovered = create_test_module(temp_dir.path(), "vpn", content);
+        discovered.variables = HashMap::from([
+            (
+                "endpoint".to_string(),
+                "https://vpn.internal.example.com".to_string(),
+            ),
+            ("region".to_string(), "eu-west".to_string()),
+        ]);
+        let loaded = load_module(&discovered).unwrap();
+        assert_eq!(
+            loaded.definition.variable_schema["endpoint"].sensitive,
+            Some(true)
+        );
+
+        // Templates still get the value; only output hides it
+        let ActionType::Template(template) = &loaded.definition.actions[0] else {
+            panic!("Expected Template action");
+        };
+        let variables = template.variables.as_ref().unwrap();
+        assert_eq!(variables["endpoint"], "https://vpn.internal.example.com");
+        assert_eq!(
+            crate::secrets::mask("endpoint https://vpn.internal.example.com in eu-west"),
+            format!("endpoint {} in eu-west", crate::secrets::MASK)
+        );
+    }
+
+    #[test]
+    fn test_package_manager_extra_args() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("dhd.config.ts");
+        fs::write(
+            &path,
+            r#"export default defineConfig({
+    packageManagers: {
+        apt: { extraArgs: ["-o", "Acquire::http::Proxy=http://proxy.example.com:3128"] },
+        pacman: {},
+    },
+});"#,
+        )
+        .unwrap();
+        let managers = load_config(&path).unwrap().package_managers.unwrap();
+        assert_eq!(
+            managers["apt"].extra_args.as_ref().unwrap(),
+            &["-o", "Acquire::http::Proxy=http://proxy.example.com:3128"]
+        );
+        assert_eq!(managers["pacman"].extra_args, None);
+
+        for (config, error) in [
+            (
+                r#"{ apt: { extraArgs: ["--simulate"] } }"#,
+                "'packageManagers.apt': extraArgs can't include '--simulate'",
+            ),
+            (
+                r#"{ apt: { extraArgs: "-q" } }"#,
+                "must be an array of strings",
+            ),
+            (r#"{ aptitude: {} }"#, "unknown package manager 'aptitude'"),
+            (
+                r#"{ npm: { extraArgs: ["--global"] } }"#,
+                "runs no npm command",
+            ),
+        ] {
+            fs::write(
+                &path,
+                format!(
+                    "export default defineConfig({{ packageManagers: {} }});",
+                    config
+                ),
+            )
+            .unwrap();
+            let Err(LoadError::ValidationError(e)) = load_config(&path) else {
+                panic!("Expected a validation error for {}", config);
+            };
+            assert!(e.contains(error), "{}", e);
+        }
+    }
+
+    #[test]
+    fn test_package_groups_expand_into_names() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("dhd.config.ts");
+        fs::write(
+            &path,
+            r#"export default defineConfig({ packageGroups: { "dev-tools": ["ripgrep", "fd"] } });"#,
+        )
+        .unwrap();
+        let groups = load_config(&path).unwrap().package_groups.unwrap();
+        assert_eq!(groups["dev-tools"], vec!["ripgrep", "fd"]);
+
+        fs::write(
+            &path,
+            r#"export default defineConfig({ packageGroups: { x: "fd" } });"#,
+        )
+        .unwrap();
+        assert!(matches!(
+            load_config(&path),
+            Err(LoadError::ValidationError(_))
+        ));
+
+        let content = r#"
+export default defineModule("dev")
+    .actions([
+        packageInstall({ names: ["fd", "git"], groups: ["dev-tools", "xorg"] }),
+        packageInstall({ groups: ["dev-tools"] })
+    ]);
+"#;
+        let mut discovered = create_test_module(temp_dir.path(), "dev", content);
+        discovered.package_groups = groups;
+        let loaded = load_module(&discovered).unwrap();
+
+        let ActionType::PackageInstall(install) = &loaded.definition.actions[0] else {
+            panic!("Expected PackageInstall action");
+        };
+        assert_eq!(install.names, vec!["fd", "git", "ripgrep"]);
+        // Left for the package manager
+        assert_eq!(install.groups, Some(vec!["xorg".to_string()]));
+        let ActionType::PackageInstall(install) = &loaded.definition.actions[1] else {
+            panic!("Expected PackageInstall action");
+        };
+        assert_eq!(install.names, vec!["ripgrep", "fd"]);
+        assert_eq!(install.groups, None);
+    }
+
+    #[test]
+    fn test_load_declarative_module() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("git.dhd.yaml");
+        fs::write(
+            &path,
+            r#"
+tags: [dev]
+actions:
+  - type: template
+    source: gitconfig.tmpl
+    target: ~/.gitconfig
+"#,
+        )
+        .unwrap();
+        let discovered = DiscoveredModule {
+            path,
+            name: "git".to_string(),
+            namespace: Some("team".to_string()),
+            variables: HashMap::from([("email".to_string(), "user@example.com".to_string())]),
+            package_groups: HashMap::new(),
+        };
+
+        let loaded = load_module(&discovered).unwrap();
+        assert_eq!(loaded.definition.name, "team/git");
+        assert_eq!(loaded.definition.tags, vec!["dev"]);
+        let ActionType::Template(template) = &loaded.definition.actions[0] else {
+            panic!("Expected Template action");
+        };
+        let variables = template.variables.as_ref().unwrap();
+        assert_eq!(
+            variables.get("email").map(String::as_str),
+            Some("user@example.com")
+        );
+    }
+
+    #[test]
+    fn test_load_module_notify_and_handlers() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("nginx")
+    .handlers({
+        "reload-nginx": command({ run: "systemctl reload nginx" }),
+        restart: [command({ run: "systemctl stop nginx" }), command({ run: "systemctl start nginx" })]
+    })
+    .actions([
+        copyFile({ source: "nginx.conf", target: "/etc/nginx/nginx.conf", notify: "reload-nginx" }),
+        copyFile({ source: "site.conf", target: "/etc/nginx/sites-enabled/site.conf", notify: ["reload-nginx", "restart"] }),
+        directory({ path: "/var/www" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "nginx", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        let handlers = &loaded.definition.handlers;
+        assert_eq!(handlers.len(), 2);
+        assert_eq!(handlers[0].name, "reload-nginx");
+        assert_eq!(handlers[1].name, "restart");
+        assert_eq!(handlers[1].actions.len(), 2);
+
+        let actions = &loaded.definition.actions;
+        match &actions[1] {
+            ActionType::Notify(notify) => {
+                assert_eq!(notify.handlers, vec!["reload-nginx", "restart"]);
+                assert!(matches!(*notify.action, ActionType::CopyFile(_)));
+            }
+            other => panic!("Expected Notify action, got {:?}", other),
+        }
+        assert!(matches!(actions[2], ActionType::Directory(_)));
+    }
+
+    #[test]
+    fn test_load_module_object_notify_and_handlers() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default {
+    name: "nginx",
+    handlers: {
+        "reload-nginx": { type: "Directory", path: "/run/nginx" }
+    },
+    actions: [
+        { type: "Directory", path: "/etc/nginx", notify: "reload-nginx" }
+    ]
+};
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "nginx", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        assert_eq!(loaded.definition.handlers[0].name, "reload-nginx");
+        assert_eq!(loaded.definition.handlers[0].actions.len(), 1);
+        assert!(matches!(
+            loaded.definition.actions[0],
+            ActionType::Notify(_)
+        ));
+    }
+
+    #[test]
+    fn test_load_module_action_tags() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("rust")
+    .handlers({ rebuild: command({ run: "cargo install-update -a" }) })
+    .actions([
+        packageInstall({ names: ["rustup"], tags: "quick" }),
+        command({ run: "cargo install ripgrep", tags: ["heavy", "network"], notify: "rebuild" }),
+        { type: "Directory", path: "~/.cargo/bin", tags: ["quick"] },
+        directory({ path: "~/src" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "rust", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        let tags: Vec<&[String]> = loaded.definition.actions.iter().map(|a| a.tags()).collect();
+        assert_eq!(
+            tags,
+            vec![
+                &["quick".to_string()][..],
+                &["heavy".to_string(), "network".to_string()],
+                &["quick".to_string()],
+                &[],
+            ]
+        );
+        // Tagged actions still notify their handlers
+        let ActionType::Notify(notify) = &loaded.definition.actions[1] else {
+            panic!("Expected Notify action");
+        };
+        assert!(matches!(*notify.action, ActionType::Tagged(_)));
+        assert_eq!(loaded.definition.actions[1].type_name(), "command");
+    }
+
+    #[test]
+    fn test_load_module_action_order() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("nvim")
+    .actions([
+        linkFile({ source: "init.lua", target: "~/.config/nvim/init.lua", after: "config-dir" }),
+        command({ run: "nvim --headless +Lazy! sync +qa", after: ["config-dir", "plugins"] }),
+        directory({ path: "~/.config/nvim", id: "config-dir" }),
+        gitRepo({ url: "https://example.com/lazy.nvim.git", path: "~/.local/share/nvim/lazy", id: "plugins" })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "nvim", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        let types: Vec<&str> = loaded
+            .definition
+            .actions
+            .iter()
+            .map(|a| a.type_name())
+            .collect();
+        assert_eq!(types, vec!["directory", "linkFile", "gitRepo", "command"]);
+        assert_eq!(loaded.definition.actions[0].id(), Some("config-dir"));
+
+        let content = r#"
+export default defineModule("loop")
+    .actions([
+        directory({ path: "~/a", id: "a", after: "b" }),
+        directory({ path: "~/b", id: "b", after: "a" })
+    ]);
+"#;
+        let discovered = create_test_module(temp_dir.path(), "loop", content);
+        match load_module(&discovered) {
+            Err(LoadError::ValidationError(msg)) => {
+                assert_eq!(msg, "Actions run after each other: 'b' -> 'a' -> 'b'")
+            }
+            other => panic!("Expected ValidationError, got {:?}", other.map(|_| ())),
+        }
+    }
+
+    #[test]
+    fn test_load_module_requires_dhd() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("future")
+    .requiresDhd("99.0.0")
+    .actions([teleport({ to: "mars" })]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "future", content);
+        match load_module(&discovered) {
+            Err(LoadError::ValidationError(msg)) => assert_eq!(
+                msg,
+                format!(
+                    "this module requires DHD 99.0.0 or newer, but this is DHD {}; upgrade dhd to use it",
+                    env!("CARGO_PKG_VERSION")
+                )
+            ),
+            other => panic!("Expected ValidationError, got {:?}", other.map(|_| ())),
+        }
+
+        let content = r#"export default { name: "current", requiresDhd: "0.1", actions: [] };"#;
+        let discovered = create_test_module(temp_dir.path(), "current", content);
+        assert!(load_module(&discovered).is_ok());
+    }
+
+    #[test]
+    fn test_load_module_verify() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("search")
+    .actions([
+        packageInstall({ names: ["ripgrep"], verify: "command -v rg", tags: ["cli"] }),
+        packageInstall({ names: ["fd"] })
+    ]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "search", content);
+        let loaded = load_module(&discovered).unwrap();
+
+        let ActionType::Tagged(tagged) = &loaded.definition.actions[0] else {
+            panic!("Expected Tagged action");
+        };
+        let ActionType::Verified(verified) = tagged.action.as_ref() else {
+            panic!("Expected Verified action");
+        };
+        assert_eq!(verified.verify, "command -v rg");
+        assert_eq!(loaded.definition.actions[0].type_name(), "packageInstall");
+        assert!(matches!(
+            loaded.definition.actions[1],
+            ActionType::PackageInstall(_)
+        ));
+    }
+
+    #[test]
+    fn test_load_module_invalid_syntax() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default {
+    name: "invalid"
+    description: "Missing comma"
+    actions: []
+};
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "invalid", content);
+        let result = load_module(&discovered);
+
+        assert!(result.is_err());
+        match result.unwrap_err() {
+            LoadError::ParseError(msg) => assert!(msg.contains("syntax") || msg.contains("parse")),
+            _ => panic!("Expected ParseError"),
+        }
+    }
+
+    #[test]
+    fn test_load_module_no_default_export() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+import { defineModule } from "./types";
+
+const module = defineModule("noexport")
+    .actions([]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "noexport", content);
+        let result = load_module(&discovered);
+
+        assert!(result.is_err());
+        match result.unwrap_err() {
+            LoadError::ValidationError(msg) => assert!(msg.contains("export default")),
+            _ => panic!("Expected ValidationError"),
+        }
+    }
+
+    #[test]
+    fn test_load_module_file_not_found() {
+        let discovered = DiscoveredModule {
+            path: PathBuf::from("/nonexistent/file.ts"),
+            name: "nonexistent".to_string(),
+            namespace: None,
+            variables: HashMap::new(),
+            package_groups: HashMap::new(),
+        };
+
+        let result = load_module(&discovered);
+        assert!(result.is_err());
+        match result.unwrap_err() {
+            LoadError::IoError(msg) => assert!(msg.contains("No such file")),
+            _ => panic!("Expected IoError"),
+        }
+    }
+
+    #[test]
+    fn test_load_module_empty_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let discovered = create_test_module(temp_dir.path(), "empty", "");
+
+        let result = load_module(&discovered);
+        assert!(result.is_err());
+    }
+
+    #[test]
+    fn test_load_module_json_format() {
+        let temp_dir = TempDir::new().unwrap();
+        // Some users might try to export JSON directly
+        let content = r#"
+export default {
+    "name": "jsonmodule",
+    "description": "JSON format",
+    "actions": []
+};
 "#;
 
         let discovered = create_test_module(temp_dir.path(), "json", content);
@@ -1366,6 +5398,159 @@ export default {
         );
     }
 
+    #[test]
+    fn test_load_module_when_host_facts() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("docker")
+    .when(host({ os: "linux", hasCommand: "docker" }))
+    .actions([]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "docker", content);
+        let loaded = load_module(&discovered).unwrap();
+        match loaded.definition.when {
+            Some(Condition::AllOf { conditions }) => {
+                assert_eq!(
+                    conditions[0],
+                    Condition::SystemProperty {
+                        path: "host.os".to_string(),
+                        operator: ComparisonOperator::Equals,
+                        value: "linux".to_string(),
+                    }
+                );
+                assert_eq!(
+                    conditions[1],
+                    Condition::CommandExists {
+                        command: "docker".to_string()
+                    }
+                );
+            }
+            other => panic!("Expected AllOf condition, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_load_module_object_when() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default {
+    name: "mac",
+    when: { os: "macos" },
+    actions: []
+};
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "mac", content);
+        let loaded = load_module(&discovered).unwrap();
+        assert_eq!(
+            loaded.definition.when,
+            Some(Condition::SystemProperty {
+                path: "host.os".to_string(),
+                operator: ComparisonOperator::Equals,
+                value: "macos".to_string(),
+            })
+        );
+    }
+
+    #[test]
+    fn test_load_module_enabled() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("ollama")
+    .enabled(false)
+    .actions([]);
+"#;
+        let discovered = create_test_module(temp_dir.path(), "ollama", content);
+        assert!(!load_module(&discovered).unwrap().definition.enabled);
+
+        let content = r#"export default { name: "ollama", enabled: false, actions: [] };"#;
+        let discovered = create_test_module(temp_dir.path(), "ollama", content);
+        assert!(!load_module(&discovered).unwrap().definition.enabled);
+
+        let content = r#"export default defineModule("ollama").actions([]);"#;
+        let discovered = create_test_module(temp_dir.path(), "ollama", content);
+        assert!(load_module(&discovered).unwrap().definition.enabled);
+    }
+
+    #[test]
+    fn test_load_module_requires_reboot() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("kernel")
+    .actions([
+        packageInstall({ names: ["linux-lts"], requiresReboot: true, tags: "boot" }),
+        packageInstall({ names: ["htop"], requiresReboot: false }),
+    ]);
+"#;
+        let discovered = create_test_module(temp_dir.path(), "kernel", content);
+        let actions = load_module(&discovered).unwrap().definition.actions;
+        let ActionType::Reboot(reboot) = &actions[0] else {
+            panic!("Expected Reboot action");
+        };
+        assert!(matches!(*reboot.action, ActionType::Tagged(_)));
+        assert_eq!(actions[0].type_name(), "packageInstall");
+        assert!(matches!(actions[1], ActionType::PackageInstall(_)));
+    }
+
+    #[test]
+    fn test_load_module_for_each() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("dots")
+    .actions([
+        symlink({ forEach: ["vimrc", "tmux.conf"], source: "{{ item }}", target: "~/.{{ item }}", tags: "editor" }),
+        { type: "Symlink", forEach: [{ file: "zshrc" }], source: "{{ item.file }}", target: "~/.{{ item.file }}" },
+    ]);
+"#;
+        let discovered = create_test_module(temp_dir.path(), "dots", content);
+        let actions = load_module(&discovered).unwrap().definition.actions;
+        assert_eq!(actions.len(), 3);
+        assert_eq!(actions[1].tags(), ["editor"]);
+        let targets: Vec<&str> = actions
+            .iter()
+            .map(|action| match action {
+                ActionType::Symlink(link) => link.target.as_str(),
+                ActionType::Tagged(tagged) => match &*tagged.action {
+                    ActionType::Symlink(link) => link.target.as_str(),
+                    _ => panic!("Expected a Symlink action"),
+                },
+                _ => panic!("Expected a Symlink action"),
+            })
+            .collect();
+        assert_eq!(targets, vec!["~/.vimrc", "~/.tmux.conf", "~/.zshrc"]);
+    }
+
+    #[test]
+    fn test_load_module_tags_array() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default defineModule("desktop")
+    .tags(["desktop", "work"])
+    .actions([]);
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "desktop", content);
+        let loaded = load_module(&discovered).unwrap();
+        assert_eq!(loaded.definition.tags, vec!["desktop", "work"]);
+    }
+
+    #[test]
+    fn test_load_module_object_depends_on() {
+        let temp_dir = TempDir::new().unwrap();
+        let content = r#"
+export default {
+    name: "nvim",
+    dependsOn: ["git", "fonts"],
+    actions: []
+};
+"#;
+
+        let discovered = create_test_module(temp_dir.path(), "nvim", content);
+        let loaded = load_module(&discovered).unwrap();
+        assert_eq!(loaded.definition.dependencies, vec!["git", "fonts"]);
+    }
+
     #[test]
     fn test_load_modules_batch() {
         let temp_dir = TempDir::new().unwrap();
@@ -0,0 +1,203 @@
+//! Leveled diagnostics on stderr
+//!
+//! Regular progress output is printed directly; the logger is for the details
+//! behind it: commands being executed, idempotency checks and skip reasons.
+//! Only warnings and errors are shown unless the level is raised.
+
+use log::{Level, LevelFilter, Log, Metadata, Record};
+use std::collections::BTreeMap;
+use std::io;
+use std::process::{Command, ExitStatus, Output};
+
+struct StderrLogger;
+
+impl Log for StderrLogger {
+    fn enabled(&self, metadata: &Metadata) -> bool {
+        metadata.level() <= log::max_level()
+    }
+
+    fn log(&self, record: &Record) {
+        if self.enabled(record.metadata()) {
+            // Commands and their environment can carry resolved secrets
+            let message = crate::secrets::mask(&record.args().to_string());
+            eprintln!("[{:<5}] {}", record.level(), message);
+        }
+    }
+
+    fn flush(&self) {}
+}
+
+static LOGGER: StderrLogger = StderrLogger;
+
+/// Install the stderr logger, showing messages at `level` and above
+pub fn init(level: LevelFilter) {
+    // Only the first call installs the logger, later calls just change the level
+    let _ = log::set_logger(&LOGGER);
+    log::set_max_level(level);
+}
+
+/// Map the number of `-v` flags to a level
+pub fn level_for_verbosity(verbosity: u8) -> LevelFilter {
+    match verbosity {
+        0 => LevelFilter::Warn,
+        1 => LevelFilter::Info,
+        2 => LevelFilter::Debug,
+        _ => LevelFilter::Trace,
+    }
+}
+
+/// Whether log lines are expected, in which case progress bars would garble them
+pub fn is_verbose() -> bool {
+    log::max_level() >= LevelFilter::Info
+}
+
+/// Run a command, logging it at debug level and its full argv and
+/// environment at trace level
+pub trait LoggedCommand {
+    fn logged_output(&mut self) -> io::Result<Output>;
+    fn logged_status(&mut self) -> io::Result<ExitStatus>;
+    /// Like `logged_output`, with `input` written to the command's stdin
+    fn logged_output_with_input(&mut self, input: &[u8]) -> io::Result<Output>;
+}
+
+impl LoggedCommand for Command {
+    fn logged_output(&mut self) -> io::Result<Output> {
+        log_exec(self);
+        let output = self.output();
+        log_exit(self, output.as_ref().map(|output| output.status));
+        output
+    }
+
+    fn logged_status(&mut self) -> io::Result<ExitStatus> {
+        log_exec(self);
+        let status = self.status();
+        log_exit(self, status.as_ref().copied());
+        status
+    }
+
+    fn logged_output_with_input(&mut self, input: &[u8]) -> io::Result<Output> {
+        use std::io::Write;
+        use std::process::Stdio;
+
+        log_exec(self);
+        let output = self
+            .stdin(Stdio::piped())
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .spawn()
+            .and_then(|mut child| {
+                // A command that exits without reading its input closes the pipe
+                if let Some(mut stdin) = child.stdin.take() {
+                    match stdin.write_all(input) {
+                        Err(e) if e.kind() != io::ErrorKind::BrokenPipe => return Err(e),
+                        _ => {}
+                    }
+                }
+                child.wait_with_output()
+            });
+        log_exit(self, output.as_ref().map(|output| output.status));
+        output
+    }
+}
+
+fn log_exec(cmd: &Command) {
+    log::debug!("exec: {}", command_line(cmd));
+    if !log::log_enabled!(Level::Trace) {
+        return;
+    }
+
+    log::trace!("argv: {:?}", argv(cmd));
+    if let Some(dir) = cmd.get_current_dir() {
+        log::trace!("cwd: {}", dir.display());
+    }
+    for (key, value) in command_env(cmd) {
+        log::trace!("env: {}={}", key, value);
+    }
+}
+
+fn log_exit(cmd: &Command, status: Result<ExitStatus, &io::Error>) {
+    let program = cmd.get_program().to_string_lossy();
+    match status {
+        Ok(status) => log::debug!("{} exited with {}", program, status),
+        Err(e) => log::debug!("{} could not be started: {}", program, e),
+    }
+}
+
+fn argv(cmd: &Command) -> Vec<String> {
+    std::iter::once(cmd.get_program())
+        .chain(cmd.get_args())
+        .map(|arg| arg.to_string_lossy().into_owned())
+        .collect()
+}
+
+/// The command line as it would be typed, quoting arguments that need it
+fn command_line(cmd: &Command) -> String {
+    argv(cmd)
+        .iter()
+        .map(|arg| {
+            if !arg.is_empty()
+                && arg
+                    .chars()
+                    .all(|c| c.is_ascii_alphanumeric() || "-_./=:@+,%".contains(c))
+            {
+                arg.clone()
+            } else {
+                format!("'{}'", arg.replace('\'', r"'\''"))
+            }
+        })
+        .collect::<Vec<_>>()
+        .join(" ")
+}
+
+/// The environment the command will see: ours, plus its overrides
+fn command_env(cmd: &Command) -> BTreeMap<String, String> {
+    let mut env: BTreeMap<String, String> = std::env::vars_os()
+        .map(|(key, value)| {
+            (
+                key.to_string_lossy().into_owned(),
+                value.to_string_lossy().into_owned(),
+            )
+        })
+        .collect();
+
+    for (key, value) in cmd.get_envs() {
+        let key = key.to_string_lossy().into_owned();
+        match value {
+            Some(value) => env.insert(key, value.to_string_lossy().into_owned()),
+            None => env.remove(&key),
+        };
+    }
+
+    env
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_level_for_verbosity() {
+        assert_eq!(level_for_verbosity(0), LevelFilter::Warn);
+        assert_eq!(level_for_verbosity(1), LevelFilter::Info);
+        assert_eq!(level_for_verbosity(2), LevelFilter::Debug);
+        assert_eq!(level_for_verbosity(5), LevelFilter::Trace);
+    }
+
+    #[test]
+    fn test_command_line_quotes_arguments() {
+        let mut cmd = Command::new("sh");
+        cmd.args(["-c", "echo 'hi there'", ""]);
+
+        assert_eq!(command_line(&cmd), r#"sh -c 'echo '\''hi there'\''' ''"#);
+    }
+
+    #[test]
+    fn test_command_env_applies_overrides() {
+        let mut cmd = Command::new("pacman");
+        cmd.env("DHD_LOGGING_TEST", "1").env_remove("PATH");
+
+        let env = command_env(&cmd);
+        assert_eq!(env.get("DHD_LOGGING_TEST"), Some(&"1".to_string()));
+        assert!(!env.contains_key("PATH"));
+    }
+}
@@ -1,16 +1,176 @@
-use clap::{Parser, Subcommand};
+use clap::{Args, CommandFactory, Parser, Subcommand, ValueEnum};
 use serde_json::{Map, Value};
+use std::path::PathBuf;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::{Mutex, OnceLock};
+
+/// Set when stdout carries machine-readable output, so progress goes to stderr
+static PROGRESS_TO_STDERR: AtomicBool = AtomicBool::new(false);
+
+/// Set by `apply --quiet`, which only prints changes, failures and the summary
+static QUIET: AtomicBool = AtomicBool::new(false);
+
+/// Set when `--ignore-missing` went on without modules that don't exist
+static MISSING_MODULES: AtomicBool = AtomicBool::new(false);
+
+/// The modules whose changes require a reboot, over every apply of the run
+static REBOOT_REQUIRED: Mutex<Vec<String>> = Mutex::new(Vec::new());
+
+/// The module roots from `--modules-path`, in override order
+static MODULE_ROOTS: OnceLock<Vec<PathBuf>> = OnceLock::new();
+
+/// The host profile named with `--host`
+static HOST: OnceLock<Option<String>> = OnceLock::new();
+
+/// Print a progress line to stdout, or to stderr while writing JSON, unless quiet
+macro_rules! progress {
+    ($($arg:tt)*) => {
+        if QUIET.load(Ordering::Relaxed) {
+            // apply --quiet only prints changes, failures and the summary
+        } else if PROGRESS_TO_STDERR.load(Ordering::Relaxed) {
+            eprintln!($($arg)*);
+        } else {
+            println!($($arg)*);
+        }
+    };
+}
 
 #[derive(Parser)]
 #[command(name = "dhd")]
 #[command(about = "Module Discovery and Execution")]
+#[command(version, long_version = dhd::version::LONG)]
 struct Cli {
     #[command(subcommand)]
     command: Commands,
+    #[command(flatten)]
+    logging: LoggingArgs,
+    /// Directory to discover modules in (default: the current directory).
+    /// Repeat it or separate directories with ':'; modules in later
+    /// directories override modules of the same name in earlier ones
+    #[arg(long, value_name = "DIR", value_delimiter = ':', global = true)]
+    modules_path: Vec<PathBuf>,
+    /// Host profile of dhd.config.ts to use (default: the profile named
+    /// after this machine's hostname, if there is one)
+    #[arg(long, value_name = "NAME", global = true)]
+    host: Option<String>,
+    /// When to color status lines: auto (when stdout is a terminal and
+    /// NO_COLOR isn't set), always or never
+    #[arg(long, value_enum, value_name = "WHEN", default_value_t = ColorWhen::Auto, global = true)]
+    color: ColorWhen,
+    /// Don't color status lines, like --color never
+    #[arg(long, global = true)]
+    no_color: bool,
+    /// Detect the distribution from this file instead of /etc/os-release,
+    /// like setting DHD_OS_RELEASE; for testing
+    #[arg(long, value_name = "PATH", global = true, hide = true)]
+    os_release_file: Option<PathBuf>,
+    /// Keep DHD's state, backups and caches in this directory instead of
+    /// under the XDG directories, like setting DHD_HOME
+    #[arg(long, value_name = "DIR", global = true)]
+    dhd_home: Option<PathBuf>,
+    /// Seconds to wait for a package database another process has locked,
+    /// like an unattended upgrade, before failing
+    #[arg(long, value_name = "SECS", default_value_t = dhd::atoms::package::lock::DEFAULT_TIMEOUT_SECS, global = true)]
+    lock_timeout: u64,
+    /// Number of network operations, like clones, downloads and package
+    /// installs, to run at once, however many modules apply in parallel
+    #[arg(long, value_name = "N", default_value_t = std::num::NonZeroUsize::new(dhd::atoms::network::DEFAULT_JOBS).unwrap(), global = true)]
+    net_jobs: std::num::NonZeroUsize,
+    /// Apply for this user instead: `~`, file ownership and systemd --user
+    /// are theirs, except for actions with `become`. Needs root; when not
+    /// root, dhd runs itself again through sudo
+    #[arg(long, value_name = "NAME", global = true)]
+    target_user: Option<String>,
+    /// Fail to load modules that use deprecated fields or actions, instead
+    /// of listing them after the summary; for CI
+    #[arg(long, global = true)]
+    strict: bool,
+    /// Don't go over the network: fetches of existing clones, downloads that
+    /// are there already and package index refreshes are skipped and listed
+    /// after the summary, and work that needs the network fails right away
+    #[arg(long, global = true)]
+    no_network: bool,
+    /// Set a variable for this run, over every other value of it, e.g.
+    /// `--set email=user@example.com` (repeatable)
+    #[arg(long = "set", value_name = "NAME=VALUE", value_parser = dhd::overrides::parse_set, global = true)]
+    set: Vec<(String, String)>,
+    /// Set a variable to a JSON value like `--set`; an object sets
+    /// NAME.key for each of its keys (repeatable)
+    #[arg(long, value_name = "NAME=JSON", value_parser = dhd::overrides::parse_set_json, global = true)]
+    set_json: Vec<dhd::overrides::JsonVariables>,
+}
+
+impl Cli {
+    fn color(&self) -> dhd::color::ColorChoice {
+        use dhd::color::ColorChoice;
+
+        match self.color {
+            _ if self.no_color => ColorChoice::Never,
+            ColorWhen::Auto => ColorChoice::Auto,
+            ColorWhen::Always => ColorChoice::Always,
+            ColorWhen::Never => ColorChoice::Never,
+        }
+    }
+}
+
+#[derive(Clone, Copy, ValueEnum)]
+enum ColorWhen {
+    Auto,
+    Always,
+    Never,
+}
+
+/// Log verbosity flags, accepted by every subcommand
+#[derive(Args)]
+struct LoggingArgs {
+    /// Show more detail: -v for condition evaluations and skip reasons,
+    /// -vv for the commands being run, -vvv for their argv and environment
+    #[arg(short, long, action = clap::ArgAction::Count, global = true)]
+    verbose: u8,
+    /// Log level, overriding -v
+    #[arg(long, value_enum, value_name = "LEVEL", global = true)]
+    log_level: Option<LogLevel>,
+}
+
+impl LoggingArgs {
+    fn level(&self) -> log::LevelFilter {
+        match self.log_level {
+            Some(LogLevel::Error) => log::LevelFilter::Error,
+            Some(LogLevel::Warn) => log::LevelFilter::Warn,
+            Some(LogLevel::Info) => log::LevelFilter::Info,
+            Some(LogLevel::Debug) => log::LevelFilter::Debug,
+            Some(LogLevel::Trace) => log::LevelFilter::Trace,
+            None => dhd::logging::level_for_verbosity(self.verbose),
+        }
+    }
+}
+
+#[derive(Clone, Copy, ValueEnum)]
+enum LogLevel {
+    Error,
+    Warn,
+    Info,
+    Debug,
+    Trace,
 }
 
 #[derive(Subcommand)]
 enum Commands {
+    /// Scaffold a modules directory with a dhd.config.ts, a sample module and a .gitignore
+    Init {
+        /// Directory to scaffold (default: the current directory)
+        #[arg(value_name = "DIR", default_value = ".")]
+        dir: PathBuf,
+        /// Distribution whose package manager the sample module uses (default: ask, or detect)
+        #[arg(long, value_name = "NAME", value_parser = clap::builder::PossibleValuesParser::new(dhd::init::DISTROS.iter().map(|(name, _)| *name)))]
+        distro: Option<String>,
+        /// Shell the sample module installs (default: ask, or `$SHELL`)
+        #[arg(long, value_name = "SHELL", value_parser = clap::builder::PossibleValuesParser::new(dhd::init::SHELLS))]
+        shell: Option<String>,
+        /// Overwrite an existing dhd.config.ts and sample module
+        #[arg(long)]
+        force: bool,
+    },
     Generate {
         #[command(subcommand)]
         generate_command: GenerateCommands,
@@ -21,26 +181,452 @@ enum Commands {
         #[command(subcommand)]
         context_command: ContextCommands,
     },
-    /// List all discovered TypeScript modules
-    List,
+    /// List the discovered modules with their tags, dependencies and
+    /// descriptions, without running anything
+    #[command(alias = "modules")]
+    List {
+        /// List modules with any of these tags (repeatable or comma-separated)
+        #[arg(long, alias = "tags", value_name = "TAG", value_delimiter = ',')]
+        tag: Vec<String>,
+        /// Require modules to have ALL specified tags
+        #[arg(long)]
+        all_tags: bool,
+        /// Leave out modules with any of these tags (repeatable or comma-separated)
+        #[arg(long, alias = "exclude-tag", value_name = "TAG", value_delimiter = ',')]
+        exclude_tags: Vec<String>,
+        /// Only list modules this expression matches, e.g. 'os == "arch"'
+        #[arg(long, value_name = "EXPR", value_parser = parse_filter)]
+        filter: Option<(String, dhd::module::FilterExpression)>,
+        /// Output format
+        #[arg(long, alias = "format", value_enum, default_value_t = OutputFormat::Text)]
+        output: OutputFormat,
+        /// Print the modules as JSON, like --output json
+        #[arg(long)]
+        json: bool,
+    },
+    /// List all tags declared by modules
+    Tags,
+    /// Validate every module without applying anything, reporting all problems
+    /// and exiting non-zero if any module is invalid
+    Check,
+    /// Rewrite the YAML and TOML modules in one canonical style: a fixed key
+    /// order and the indentation of the YAML and TOML writers
+    Fmt {
+        /// Only list the modules that aren't formatted, exiting non-zero if any
+        #[arg(long)]
+        check: bool,
+    },
+    /// Diagnose the environment: the tools and package manager the modules
+    /// need, the modules path and anything else that would make an apply fail,
+    /// exiting non-zero if something critical is missing
+    Doctor,
+    /// Fetch the git imports declared in dhd.config.ts again
+    Update,
+    /// Print DHD's version, the commit and date it was built from and the
+    /// module API version it implements, like --version
+    Version,
+    /// Print the JSON Schema of YAML and TOML modules, for editors to validate
+    /// and complete them
+    Schema,
+    /// Show what apply would change without modifying anything
+    Plan {
+        #[command(flatten)]
+        selection: SelectionArgs,
+        /// Exit code to use when changes are pending (0 disables drift detection)
+        #[arg(long, value_name = "CODE", default_value_t = dhd::exit_code::CHANGES)]
+        pending_exit_code: i32,
+        /// Output format; json prints the plan as a document `apply
+        /// --plan-file` can apply
+        #[arg(long, alias = "format", value_enum, default_value_t = OutputFormat::Text)]
+        output: OutputFormat,
+    },
+    /// Check whether the system still matches the modules, without changing anything
+    Status {
+        #[command(flatten)]
+        selection: SelectionArgs,
+        /// Exit code to use when anything has drifted (0 always exits successfully)
+        #[arg(long, value_name = "CODE", default_value_t = dhd::exit_code::CHANGES)]
+        drift_exit_code: i32,
+    },
+    /// Check whether the system still matches the modules from what can be
+    /// read without side effects: no commands, package index refreshes or
+    /// secret lookups
+    Audit {
+        #[command(flatten)]
+        selection: SelectionArgs,
+        /// Exit code to use when anything has drifted (0 always exits successfully)
+        #[arg(long, value_name = "CODE", default_value_t = dhd::exit_code::CHANGES)]
+        drift_exit_code: i32,
+    },
+    /// Check that the files copyFile and template wrote haven't changed since,
+    /// by their hashes in DHD's state rather than the modules
+    Verify {
+        /// Exit code to use when a file changed (0 always exits successfully)
+        #[arg(long, value_name = "CODE", default_value_t = dhd::exit_code::CHANGES)]
+        tampered_exit_code: i32,
+    },
+    /// Show a unified diff of the files apply would change
+    Diff {
+        #[command(flatten)]
+        selection: SelectionArgs,
+    },
+    /// Show a module as it would be applied: every action with its final
+    /// parameters, source files, input hash and atoms, without changing anything
+    Explain {
+        /// Name of the module
+        #[arg(value_name = "MODULE")]
+        module: String,
+    },
     /// Apply (execute) discovered modules
     Apply {
         /// Run in dry-run mode to preview what would be executed
         #[arg(long)]
         dry_run: bool,
-        /// Filter to specific modules by name (can be used multiple times)
-        #[arg(long, value_name = "MODULE")]
+        #[command(flatten)]
+        selection: SelectionArgs,
+        /// Number of modules to apply in parallel (default: `jobs` of dhd.config.ts, or the number of CPUs)
+        #[arg(short, long, alias = "concurrency", value_name = "N")]
+        jobs: Option<std::num::NonZeroUsize>,
+        /// Output format; json prints one document describing every action
+        #[arg(long, alias = "format", value_enum, default_value_t = OutputFormat::Text)]
+        output: OutputFormat,
+        /// Print how long each module and action took, slowest first (always on with -v)
+        #[arg(long)]
+        timings: bool,
+        /// Write a Chrome trace of loading, checking and running every
+        /// module and action to this file, for chrome://tracing, Perfetto or speedscope
+        #[arg(long, value_name = "FILE", conflicts_with = "watch")]
+        profile: Option<PathBuf>,
+        /// Don't ask before overwriting files DHD didn't write or removing packages
+        #[arg(short, long)]
+        yes: bool,
+        /// Don't keep `<file>.dhd-bak-<timestamp>` copies of replaced files
+        #[arg(long)]
+        no_backup: bool,
+        /// Check every action first and only run the ones that have drifted
+        #[arg(long)]
+        only_changed: bool,
+        /// Apply at most this many changed actions, in dependency order, and
+        /// list the rest; running again continues where this run stopped
+        #[arg(long, value_name = "N", conflicts_with_all = ["output", "watch"])]
+        limit: Option<usize>,
+        /// Skip actions whose definition, variables and source files haven't
+        /// changed since their module last applied without failures
+        #[arg(long)]
+        incremental: bool,
+        /// Run every action, even those --incremental (or `incremental` of
+        /// dhd.config.ts) would skip
+        #[arg(long)]
+        force: bool,
+        /// Keep applying the modules that don't depend on a failed one
+        /// instead of stopping at the first failure
+        #[arg(short, long)]
+        keep_going: bool,
+        /// Print the diff of every file an action changes, as it writes it
+        #[arg(long, conflicts_with = "output")]
+        diff: bool,
+        /// Only print the actions that changed something or failed, and the
+        /// summary, e.g. for applies from cron; warnings and errors still show
+        #[arg(short, long, conflicts_with_all = ["output", "verbose"])]
+        quiet: bool,
+        /// Keep running and re-apply modules when their files change, until Ctrl-C
+        #[arg(long, conflicts_with = "output")]
+        watch: bool,
+        /// Only apply the selected modules whose files changed since this
+        /// git commit, and the modules depending on them
+        #[arg(long, value_name = "REF", conflicts_with_all = ["watch", "file"])]
+        since_commit: Option<String>,
+        /// Apply the modules of a plan saved with `dhd plan --output json`,
+        /// refusing if anything changed since it was made
+        #[arg(
+            long,
+            value_name = "PATH",
+            conflicts_with_all = [
+                "watch", "since_commit", "limit", "module", "tag", "exclude_tags", "filter",
+                "file", "action", "only_tags", "skip_tags"
+            ]
+        )]
+        plan_file: Option<PathBuf>,
+        /// Apply the plan of --plan-file even if the system or the modules
+        /// changed since; what is applied is what the modules declare now
+        #[arg(long, requires = "plan_file")]
+        allow_drift: bool,
+        /// Write a report of the apply to this file when it ends, even if it fails
+        #[arg(long, value_name = "PATH")]
+        report_file: Option<PathBuf>,
+        /// Format of --report-file; prometheus suits node_exporter's textfile collector
+        #[arg(long, value_enum, default_value_t = ReportFormat::Json, requires = "report_file")]
+        report_format: ReportFormat,
+        /// Exit code to use when actions changed something, e.g. 2 to notice
+        /// drift in CI (default: 0)
+        #[arg(long, value_name = "CODE", default_value_t = dhd::exit_code::SUCCESS)]
+        changed_exit_code: i32,
+        /// Schedule a reboot when the apply succeeded and changed something
+        /// that requires one
+        #[arg(long, conflicts_with_all = ["dry_run", "watch"])]
+        reboot_if_required: bool,
+        /// Minutes until the reboot --reboot-if-required schedules
+        #[arg(
+            long,
+            value_name = "MINUTES",
+            default_value_t = 1,
+            requires = "reboot_if_required"
+        )]
+        reboot_delay: u32,
+        /// Afterwards, undo what earlier applies recorded for symlinks and
+        /// files no module declares any more, after listing them
+        #[arg(long, conflicts_with_all = ["output", "watch", "file"])]
+        prune: bool,
+        /// Also uninstall the recorded packages no module declares any more
+        #[arg(long, requires = "prune")]
+        prune_packages: bool,
+    },
+    /// Show the latest applies, newest first: when they finished, whether
+    /// they succeeded and how many actions they changed
+    History {
+        /// Show only the last N applies
+        #[arg(long, value_name = "N")]
+        last: Option<usize>,
+        /// Print the history as JSON
+        #[arg(long)]
+        json: bool,
+    },
+    /// Undo the most recent apply: remove the symlinks and files it created
+    /// and restore the files it replaced
+    Rollback {
+        /// Also uninstall the packages it installed
+        #[arg(long)]
+        packages: bool,
+    },
+    /// Undo what applies of the given modules recorded: remove the symlinks
+    /// and files they created and restore the files they replaced
+    Uninstall {
+        /// Modules to uninstall (repeatable or comma-separated)
+        #[arg(
+            long,
+            alias = "modules",
+            value_name = "MODULE",
+            value_delimiter = ',',
+            required = true
+        )]
         module: Vec<String>,
-        /// Filter to modules with specific tags (can be used multiple times)
-        #[arg(long, value_name = "TAG")]
-        tag: Vec<String>,
-        /// Filter to modules with ALL specified tags
+        /// Also uninstall the packages they installed
         #[arg(long)]
-        all_tags: bool,
-        /// Enable verbose output including condition evaluations
+        packages: bool,
+        /// Show what would be undone without changing anything
+        #[arg(long)]
+        dry_run: bool,
+        /// Don't ask before uninstalling packages
         #[arg(short, long)]
-        verbose: bool,
+        yes: bool,
+    },
+    /// Remove the blocks sourcing DHD-managed files from shell rc files,
+    /// e.g. before removing DHD
+    Unwire {
+        /// Shells whose rc file to clean up (default: bash, zsh and fish)
+        #[arg(long, alias = "shell", value_name = "SHELL", value_delimiter = ',')]
+        shells: Vec<String>,
+        /// Show which rc files would change without changing them
+        #[arg(long)]
+        dry_run: bool,
     },
+    /// Print a shell completion script, e.g. `dhd completions fish | source`
+    Completions {
+        #[arg(value_enum)]
+        shell: clap_complete::Shell,
+    },
+    /// Print module names or tags for completion scripts, one per line
+    #[command(name = "__complete", hide = true)]
+    Complete {
+        #[arg(value_enum)]
+        candidates: CompletionCandidates,
+    },
+}
+
+impl Commands {
+    /// The module selection of the commands that have one
+    fn selection_mut(&mut self) -> Option<&mut SelectionArgs> {
+        match self {
+            Commands::Plan { selection, .. }
+            | Commands::Status { selection, .. }
+            | Commands::Audit { selection, .. }
+            | Commands::Diff { selection }
+            | Commands::Apply { selection, .. } => Some(selection),
+            _ => None,
+        }
+    }
+}
+
+#[derive(Clone, Copy, ValueEnum)]
+enum CompletionCandidates {
+    Modules,
+    Tags,
+}
+
+#[derive(Clone, Copy, PartialEq, ValueEnum)]
+enum OutputFormat {
+    /// Human-readable progress and summary
+    Text,
+    /// A JSON document on stdout, with progress on stderr
+    Json,
+}
+
+#[derive(Clone, Copy, PartialEq, ValueEnum)]
+enum ReportFormat {
+    /// The JSON document of `--output json` with the version, a timestamp and
+    /// whether the apply succeeded
+    Json,
+    /// Prometheus text format
+    Prometheus,
+}
+
+/// Where `--report-file` writes the report of an apply
+struct ReportTarget {
+    path: PathBuf,
+    format: ReportFormat,
+}
+
+impl ReportTarget {
+    /// Write `report`; a report that can't be written only warns
+    fn write(&self, report: &dhd::report::RunReport) {
+        let content = match self.format {
+            ReportFormat::Json => report
+                .to_json()
+                .map(|json| dhd::secrets::mask(&json) + "\n"),
+            ReportFormat::Prometheus => Ok(report.to_prometheus()),
+        };
+        if let Err(e) = content.and_then(|content| dhd::report::write(&self.path, &content)) {
+            eprintln!("Warning: {}", e);
+        }
+    }
+}
+
+/// Add an apply that produced `summary`, or failed with `error`, to the
+/// history unless it was a dry run, and write its `--report-file` report
+fn finish_apply(
+    report: Option<&ReportTarget>,
+    summary: Option<&dhd::ExecutionSummary>,
+    error: Option<&str>,
+    dry_run: bool,
+    start: std::time::Instant,
+) {
+    let run =
+        dhd::report::RunReport::new(summary, error.map(str::to_string), dry_run, start.elapsed());
+    if !dry_run {
+        let mut reboot = REBOOT_REQUIRED.lock().unwrap_or_else(|e| e.into_inner());
+        for module in summary.iter().flat_map(|summary| summary.reboot_required()) {
+            if !reboot.iter().any(|name| name == module) {
+                reboot.push(module.to_string());
+            }
+        }
+        let entry = dhd::history::HistoryEntry::new(&run);
+        if let Err(e) = dhd::history::record(&dhd::state::state_dir(), entry) {
+            eprintln!("Warning: {}", e);
+        }
+    }
+    if let Some(report) = report {
+        report.write(&run);
+    }
+}
+
+/// Module selection flags shared by plan, status, diff and apply
+#[derive(Args, Clone, Default)]
+struct SelectionArgs {
+    /// Select modules by name (repeatable or comma-separated); `-` reads
+    /// the names from stdin, one per line
+    #[arg(long, alias = "modules", value_name = "MODULE", value_delimiter = ',')]
+    module: Vec<String>,
+    /// Select modules with any of these tags (repeatable or comma-separated)
+    #[arg(long, alias = "tags", value_name = "TAG", value_delimiter = ',')]
+    tag: Vec<String>,
+    /// Require modules to have ALL specified tags
+    #[arg(long)]
+    all_tags: bool,
+    /// Drop modules with any of these tags (repeatable or comma-separated)
+    #[arg(long, alias = "exclude-tag", value_name = "TAG", value_delimiter = ',')]
+    exclude_tags: Vec<String>,
+    /// Only select modules this expression matches, e.g. 'tag == "desktop" and os == "arch"'
+    #[arg(long, value_name = "EXPR", value_parser = parse_filter)]
+    filter: Option<(String, dhd::module::FilterExpression)>,
+    /// Don't pull in the dependencies of selected modules
+    #[arg(long)]
+    no_deps: bool,
+    /// Select only the module in this file, wherever it is, instead of
+    /// discovering modules; implies --no-deps
+    #[arg(
+        long,
+        value_name = "PATH",
+        conflicts_with_all = ["module", "tag", "exclude_tags", "filter"]
+    )]
+    file: Option<PathBuf>,
+    /// Only run actions of these types, e.g. packageInstall (repeatable or comma-separated)
+    #[arg(long, alias = "actions", value_name = "TYPE", value_delimiter = ',')]
+    action: Vec<String>,
+    /// Only run actions with any of these action tags (repeatable or comma-separated)
+    #[arg(long, alias = "only-tag", value_name = "TAG", value_delimiter = ',')]
+    only_tags: Vec<String>,
+    /// Skip actions with any of these action tags (repeatable or comma-separated)
+    #[arg(long, alias = "skip-tag", value_name = "TAG", value_delimiter = ',')]
+    skip_tags: Vec<String>,
+    /// Warn about --module names no module has and go on with the others,
+    /// exiting with 5 if nothing else went wrong
+    #[arg(long)]
+    ignore_missing: bool,
+    /// Why nothing is selected rather than every module, when `--module -`
+    /// read no names, no module changed since `--since-commit` or the plan of
+    /// `--plan-file` has no modules
+    #[arg(skip)]
+    nothing: Option<String>,
+}
+
+impl SelectionArgs {
+    /// Replace `--module -` with the module names on stdin, one per line
+    fn read_stdin(&mut self) -> Result<(), String> {
+        use std::io::Read;
+
+        if !self.module.iter().any(|name| name == "-") {
+            return Ok(());
+        }
+        let mut input = String::new();
+        std::io::stdin()
+            .read_to_string(&mut input)
+            .map_err(|e| format!("Failed to read module names from stdin: {}", e))?;
+        let names: Vec<String> = input
+            .lines()
+            .map(str::trim)
+            .filter(|name| !name.is_empty())
+            .map(String::from)
+            .collect();
+
+        let mut modules = Vec::new();
+        for name in self.module.drain(..) {
+            if name == "-" {
+                modules.extend(names.iter().cloned());
+            } else {
+                modules.push(name);
+            }
+        }
+        if modules.is_empty() {
+            self.nothing = Some("No modules were named on stdin".to_string());
+        }
+        self.module = modules;
+        Ok(())
+    }
+
+    fn filter(&self) -> dhd::ModuleFilter {
+        dhd::ModuleFilter {
+            modules: self.module.clone(),
+            tags: self.tag.clone(),
+            all_tags: self.all_tags,
+            exclude_tags: self.exclude_tags.clone(),
+            expression: self.filter.clone(),
+        }
+    }
+}
+
+/// Parse `--filter` while the arguments are, failing before any module loads
+fn parse_filter(filter: &str) -> Result<(String, dhd::module::FilterExpression), String> {
+    dhd::module::FilterExpression::parse(filter).map(|expression| (filter.to_string(), expression))
 }
 
 #[derive(Subcommand)]
@@ -55,6 +641,67 @@ enum ContextCommands {
     List,
 }
 
+/// Scaffold `dir`, asking for the distribution and shell when not given
+fn init_modules(
+    dir: &std::path::Path,
+    distro: Option<String>,
+    shell: Option<String>,
+    force: bool,
+) -> Result<(), String> {
+    use dhd::init::{DISTROS, SHELLS, detect_distro, detect_shell};
+
+    let distros: Vec<&str> = DISTROS.iter().map(|(name, _)| *name).collect();
+    let distro = match distro {
+        Some(distro) => distro,
+        None => choose("Distribution", &distros, detect_distro())
+            .ok_or("Couldn't detect the distribution; pass --distro")?,
+    };
+    let shell = match shell {
+        Some(shell) => shell,
+        None => choose("Shell", SHELLS, detect_shell())
+            .ok_or("Couldn't detect the shell; pass --shell")?,
+    };
+
+    let written = dhd::init::scaffold(dir, &distro, &shell, force)?;
+    println!("● Initialized {}", dir.display());
+    for path in written {
+        println!("  - {}", path.display());
+    }
+    println!(
+        "\nNext: `dhd generate types` for editor completion, then `dhd plan` to see what base.ts would do"
+    );
+    Ok(())
+}
+
+/// Ask which of `choices` to use, or take `default` when not running interactively
+fn choose(what: &str, choices: &[&str], default: Option<&str>) -> Option<String> {
+    use std::io::{BufRead, IsTerminal, Write};
+
+    if !std::io::stdin().is_terminal() {
+        return default.map(String::from);
+    }
+
+    loop {
+        match default {
+            Some(default) => eprint!("{} [{}] ({}): ", what, choices.join("/"), default),
+            None => eprint!("{} [{}]: ", what, choices.join("/")),
+        }
+        std::io::stderr().flush().ok();
+        let mut answer = String::new();
+        if std::io::stdin().lock().read_line(&mut answer).ok()? == 0 {
+            return default.map(String::from);
+        }
+        let answer = answer.trim().to_lowercase();
+        if answer.is_empty() && default.is_some() {
+            return default.map(String::from);
+        }
+        if choices.contains(&answer.as_str()) {
+            return Some(answer);
+        }
+        eprintln!("Pick one of {}", choices.join(", "));
+    }
+}
+
 fn generate_types() -> Result<(), String> {
     use std::fs;
 
@@ -159,27 +806,31 @@ fn print_properties(map: &Map<String, Value>, prefix: &str, _category: Option<&s
     }
 }
 
-fn list_modules() -> Result<(), String> {
-    use dhd::{discover_modules, load_modules};
+/// List the discovered modules `filter` selects, with their tags,
+/// dependencies and descriptions
+fn list_modules(filter: dhd::ModuleFilter, output: OutputFormat) -> Result<(), String> {
+    use dhd::load_modules;
     use std::env;
 
     let current_dir =
         env::current_dir().map_err(|e| format!("Failed to get current directory: {}", e))?;
+    if output == OutputFormat::Json {
+        PROGRESS_TO_STDERR.store(true, Ordering::Relaxed);
+    }
 
-    // Discover modules with progress
-    print!("● Discovering TypeScript modules...");
-    std::io::Write::flush(&mut std::io::stdout()).unwrap();
-    let discovered =
-        discover_modules(&current_dir).map_err(|e| format!("Failed to discover modules: {}", e))?;
-    println!(" found {}", discovered.len());
+    let discovered = discover()?;
+    progress!(
+        "● Discovering TypeScript modules... found {}",
+        discovered.len()
+    );
 
-    if discovered.is_empty() {
+    if discovered.is_empty() && output == OutputFormat::Text {
         println!("No TypeScript modules found");
         return Ok(());
     }
 
     // Load modules to get their actual names with progress
-    println!("● Loading module definitions...");
+    progress!("● Loading module definitions...");
 
     let load_results = load_modules(discovered.clone());
     let mut loaded_modules = Vec::new();
@@ -188,35 +839,96 @@ fn list_modules() -> Result<(), String> {
     for (i, result) in load_results.into_iter().enumerate() {
         match result {
             Ok(loaded) => loaded_modules.push(loaded),
-            Err(_) => failed_modules.push(&discovered[i]),
+            Err(e) => failed_modules.push((&discovered[i], e.to_string())),
         }
     }
+    let total = loaded_modules.len() + failed_modules.len();
+    disable_configured(&mut loaded_modules)?;
+    loaded_modules.retain(|module| filter.matches(&module.definition));
+
+    // Imported modules live outside the current directory
+    let display_path = |source: &dhd::DiscoveredModule| {
+        source
+            .relative_path(&current_dir)
+            .unwrap_or_else(|| source.path.clone())
+            .display()
+            .to_string()
+    };
+
+    if output == OutputFormat::Json {
+        let modules: Vec<Value> = loaded_modules
+            .iter()
+            .map(|module| {
+                serde_json::json!({
+                    "name": module.definition.name,
+                    "path": display_path(&module.source),
+                    "description": module.definition.description,
+                    "tags": module.definition.tags,
+                    "dependsOn": module.definition.dependencies,
+                    "enabled": module.definition.enabled,
+                })
+            })
+            .collect();
+        let failed: Vec<Value> = failed_modules
+            .iter()
+            .map(|(module, error)| {
+                serde_json::json!({
+                    "name": module.name,
+                    "path": display_path(module),
+                    "error": error,
+                })
+            })
+            .collect();
+        let document = serde_json::json!({ "modules": modules, "failed": failed });
+        let json = serde_json::to_string_pretty(&document)
+            .map_err(|e| format!("Failed to serialize modules: {}", e))?;
+        println!("{}", dhd::secrets::mask(&json));
+        return Ok(());
+    }
 
     // Show loaded modules with their actual names
-    if !loaded_modules.is_empty() {
-        println!(
-            "Found {} TypeScript module(s):",
-            loaded_modules.len() + failed_modules.len()
-        );
+    if !filter.is_empty() && loaded_modules.is_empty() {
+        println!("No modules match {}", filter.describe());
+    } else if !loaded_modules.is_empty() {
+        if filter.is_empty() {
+            println!("Found {} TypeScript module(s):", total);
+        } else {
+            println!(
+                "{} of {} module(s) match {}:",
+                loaded_modules.len(),
+                total,
+                filter.describe()
+            );
+        }
         for module in &loaded_modules {
-            if let Some(relative) = module.source.relative_path(&current_dir) {
-                let description = module
-                    .definition
-                    .description
-                    .as_ref()
-                    .map(|d| format!(" - {}", d))
-                    .unwrap_or_default();
-                let tags = if module.definition.tags.is_empty() {
-                    String::new()
-                } else {
-                    format!(" [{}]", module.definition.tags.join(", "))
-                };
+            let description = module
+                .definition
+                .description
+                .as_ref()
+                .map(|d| format!(" - {}", d))
+                .unwrap_or_default();
+            let tags = if module.definition.tags.is_empty() {
+                String::new()
+            } else {
+                format!(" [{}]", module.definition.tags.join(", "))
+            };
+            let disabled = if module.definition.enabled {
+                ""
+            } else {
+                " (disabled)"
+            };
+            println!(
+                "  - {} ({}){}{}{}",
+                module.definition.name,
+                display_path(&module.source),
+                disabled,
+                tags,
+                description
+            );
+            if !module.definition.dependencies.is_empty() {
                 println!(
-                    "  - {} ({}){}{}",
-                    module.definition.name,
-                    relative.display(),
-                    tags,
-                    description
+                    "      depends on: {}",
+                    module.definition.dependencies.join(", ")
                 );
             }
         }
@@ -228,150 +940,762 @@ fn list_modules() -> Result<(), String> {
             println!();
         }
         println!("Failed to load {} module(s):", failed_modules.len());
-        for module in &failed_modules {
-            if let Some(relative) = module.relative_path(&current_dir) {
-                println!("  - {} ({})", module.name, relative.display());
-            }
+        for (module, _) in &failed_modules {
+            println!("  - {} ({})", module.name, display_path(module));
         }
     }
 
     Ok(())
 }
 
-fn apply_modules(
-    dry_run: bool,
-    module_filters: Vec<String>,
-    tag_filters: Vec<String>,
-    all_tags: bool,
-    verbose: bool,
-) -> Result<(), String> {
-    use dhd::{ExecutionEngine, discover_modules, load_modules, dependency_resolver::resolve_dependencies};
+/// List every tag used by the discovered modules with the modules carrying it
+fn list_tags() -> Result<(), String> {
+    use std::collections::BTreeMap;
+
+    let loaded_modules = load_all_modules()?;
+    if loaded_modules.is_empty() {
+        return Ok(());
+    }
+
+    let mut tags: BTreeMap<&str, Vec<&str>> = BTreeMap::new();
+    for module in &loaded_modules {
+        for tag in &module.definition.tags {
+            tags.entry(tag.as_str())
+                .or_default()
+                .push(module.definition.name.as_str());
+        }
+    }
+
+    if tags.is_empty() {
+        println!("No modules declare tags");
+        return Ok(());
+    }
+
+    println!("Found {} tag(s):", tags.len());
+    for (tag, mut modules) in tags {
+        modules.sort();
+        println!("  - {} ({}): {}", tag, modules.len(), modules.join(", "));
+    }
+
+    Ok(())
+}
+
+/// Validate every discovered module, returning how many are invalid
+fn check_modules() -> Result<usize, String> {
     use std::env;
 
     let current_dir =
         env::current_dir().map_err(|e| format!("Failed to get current directory: {}", e))?;
+    let discovered = discover()?;
+    if discovered.is_empty() {
+        println!("No TypeScript modules found");
+        return Ok(0);
+    }
 
-    // Discover all modules with progress
-    print!("● Discovering TypeScript modules...");
-    std::io::Write::flush(&mut std::io::stdout()).unwrap();
-    let discovered =
-        discover_modules(&current_dir).map_err(|e| format!("Failed to discover modules: {}", e))?;
-    println!(" found {}", discovered.len());
+    println!("● Checking {} module(s)...", discovered.len());
+    let checks = dhd::check::check_modules(&discovered);
+    for check in &checks {
+        let path = check
+            .source
+            .relative_path(&current_dir)
+            .unwrap_or_else(|| check.source.path.clone());
+        let name = check.name.as_deref().unwrap_or(&check.source.name);
+        if check.is_valid() {
+            println!("  ✅ {} ({})", name, path.display());
+        } else {
+            println!("  ❌ {} ({})", name, path.display());
+            for error in &check.errors {
+                println!("     - {}", error);
+            }
+        }
+        for deprecation in &check.deprecations {
+            println!("     ⚠️  {}", deprecation);
+        }
+    }
 
-    if discovered.is_empty() {
-        println!("No TypeScript modules found in current directory");
-        return Ok(());
+    let invalid = checks.iter().filter(|check| !check.is_valid()).count();
+    if invalid == 0 {
+        println!("\n✅ All {} module(s) are valid", checks.len());
+    } else {
+        println!("\n❌ {} of {} module(s) are invalid", invalid, checks.len());
     }
 
-    // Load all modules first to get their actual names with progress
-    println!("● Loading and validating modules...");
+    Ok(invalid)
+}
 
-    let load_results = load_modules(discovered.clone());
-    let mut loaded_modules = Vec::new();
-    let mut failed_count = 0;
+/// Format the YAML and TOML modules of every module root, returning how many
+/// weren't formatted
+fn fmt_modules(checking: bool) -> Result<usize, String> {
+    use dhd::fmt::Outcome;
 
-    for (i, result) in load_results.into_iter().enumerate() {
-        match result {
-            Ok(loaded) => loaded_modules.push(loaded),
+    let current_dir =
+        std::env::current_dir().map_err(|e| format!("Failed to get current directory: {}", e))?;
+    let mut modules = Vec::new();
+    for root in module_roots()? {
+        let discovered = dhd::discovery::discover_modules(&root)
+            .map_err(|e| format!("Failed to discover modules: {}", e))?;
+        modules.extend(
+            discovered
+                .into_iter()
+                .filter(|module| dhd::declarative::module_file(&module.path).is_some()),
+        );
+    }
+    if modules.is_empty() {
+        println!("No YAML or TOML modules found");
+        return Ok(0);
+    }
+
+    let mut unformatted = 0;
+    let mut failed = 0;
+    for module in &modules {
+        let path = module
+            .relative_path(&current_dir)
+            .unwrap_or_else(|| module.path.clone());
+        match dhd::fmt::format_file(&module.path, checking) {
+            Ok(Outcome::Unchanged) => {}
+            Ok(Outcome::Changed) if checking => {
+                unformatted += 1;
+                println!("  ✗ {} isn't formatted", path.display());
+            }
+            Ok(Outcome::Changed) => {
+                unformatted += 1;
+                println!("  ✓ Formatted {}", path.display());
+            }
+            Ok(Outcome::HasComments) => {
+                println!(
+                    "  - Skipped {}: formatting would drop its comments",
+                    path.display()
+                )
+            }
             Err(e) => {
-                eprintln!("  ⎿ ✗ Failed to load module {}: {}", discovered[i].name, e);
-                failed_count += 1;
+                failed += 1;
+                eprintln!("  ✗ {}", e);
             }
         }
     }
 
-    if loaded_modules.is_empty() {
-        return Err(format!(
-            "No modules could be loaded ({} failed)",
-            failed_count
-        ));
+    if failed > 0 {
+        return Err(format!("{} module(s) couldn't be formatted", failed));
     }
+    match (checking, unformatted) {
+        (_, 0) => println!("All {} module(s) are formatted", modules.len()),
+        (true, n) => println!("\n{} of {} module(s) aren't formatted", n, modules.len()),
+        (false, n) => println!("\nFormatted {} of {} module(s)", n, modules.len()),
+    }
+    // Formatting them is what was asked for, not a failure
+    Ok(if checking { unformatted } else { 0 })
+}
 
-    if failed_count > 0 {
-        println!("\n● Warning: {} modules failed to load", failed_count);
+/// Diagnose the environment, returning how many critical problems were found
+fn doctor() -> Result<usize, String> {
+    use dhd::doctor::Severity;
+
+    println!("● Diagnosing the environment...");
+    let requested = HOST.get().and_then(|host| host.as_deref());
+    let findings = dhd::doctor::diagnose(&module_roots()?, requested);
+    for finding in &findings {
+        let icon = match finding.severity {
+            Severity::Ok => "✅",
+            Severity::Warning => "⚠️ ",
+            Severity::Critical => "❌",
+        };
+        println!("  {} {}: {}", icon, finding.check, finding.detail);
+        if let Some(hint) = &finding.hint {
+            println!("     → {}", hint);
+        }
     }
 
-    // Filter modules by their actual names and tags
-    let filtered_modules: Vec<_> = loaded_modules
+    let critical = findings
         .iter()
-        .filter(|module| {
-            // Check module name filter
-            let name_match =
-                module_filters.is_empty() || module_filters.contains(&module.definition.name);
-
-            // Check tag filter
-            let tag_match = if tag_filters.is_empty() {
-                true
-            } else if all_tags {
-                // Module must have ALL specified tags
-                tag_filters
-                    .iter()
-                    .all(|tag| module.definition.tags.contains(tag))
-            } else {
-                // Module must have AT LEAST ONE of the specified tags
-                tag_filters
-                    .iter()
-                    .any(|tag| module.definition.tags.contains(tag))
-            };
+        .filter(|finding| finding.severity == Severity::Critical)
+        .count();
+    if critical == 0 {
+        println!("\n✅ Nothing found that would make an apply fail");
+    } else {
+        println!("\n❌ {} problem(s) would make an apply fail", critical);
+    }
 
-            name_match && tag_match
-        })
-        .cloned()
-        .collect();
+    Ok(critical)
+}
 
-    if filtered_modules.is_empty() {
-        if module_filters.is_empty() && tag_filters.is_empty() {
-            println!("ℹ️  No modules to execute");
-        } else {
-            let mut filters = Vec::new();
-            if !module_filters.is_empty() {
-                filters.push(format!("modules: {}", module_filters.join(", ")));
-            }
-            if !tag_filters.is_empty() {
-                let tag_op = if all_tags { "all of" } else { "any of" };
-                filters.push(format!("tags ({}): {}", tag_op, tag_filters.join(", ")));
-            }
-            println!(
-                "ℹ️  No modules matched the specified filters: {}",
-                filters.join("; ")
-            );
-        }
+/// Refresh the cached checkouts of the git imports
+fn update_imports() -> Result<(), String> {
+    let mut updated = Vec::new();
+    for root in module_roots()? {
+        updated.extend(dhd::imports::update_imports(&root)?);
+    }
+    if updated.is_empty() {
+        println!("No git imports to update");
         return Ok(());
     }
 
-    // Include dependencies of selected modules
-    let mut modules_with_deps = filtered_modules.clone();
-    let mut added_deps = true;
-    
-    while added_deps {
-        added_deps = false;
-        let current_names: Vec<String> = modules_with_deps.iter()
-            .map(|m| m.definition.name.clone())
-            .collect();
-        
-        for module in modules_with_deps.clone() {
-            for dep in &module.definition.dependencies {
-                if !current_names.contains(dep) {
-                    if let Some(dep_module) = loaded_modules.iter().find(|m| &m.definition.name == dep) {
-                        modules_with_deps.push(dep_module.clone());
-                        added_deps = true;
-                    } else {
-                        return Err(format!(
-                            "Module '{}' depends on '{}' which was not found",
-                            module.definition.name, dep
-                        ));
-                    }
-                }
+    for (namespace, root) in &updated {
+        println!("  ✅ {} ({})", namespace, root.display());
+    }
+    println!("\n✅ Updated {} import(s)", updated.len());
+
+    Ok(())
+}
+
+/// Generate the completion script for `shell`
+///
+/// The options taking module names or tags complete the modules found in the
+/// current directory, listed by `dhd __complete`, in bash, zsh and fish.
+fn completion_script(shell: clap_complete::Shell) -> String {
+    use clap_complete::Shell;
+
+    let mut script = Vec::new();
+    clap_complete::generate(shell, &mut Cli::command(), "dhd", &mut script);
+    let script = String::from_utf8_lossy(&script).into_owned();
+
+    match shell {
+        Shell::Bash => bash_dynamic_completions(&script),
+        Shell::Zsh => zsh_dynamic_completions(&script),
+        Shell::Fish => script + FISH_DYNAMIC_COMPLETIONS,
+        _ => script,
+    }
+}
+
+const FISH_DYNAMIC_COMPLETIONS: &str = r#"
+set -l __dhd_selecting "__fish_seen_subcommand_from plan status diff apply"
+complete -c dhd -n $__dhd_selecting -l module -f -r -a '(dhd __complete modules 2>/dev/null)'
+complete -c dhd -n $__dhd_selecting -l tag -l exclude-tags -f -r -a '(dhd __complete tags 2>/dev/null)'
+complete -c dhd -n "__fish_seen_subcommand_from uninstall" -l module -f -r -a '(dhd __complete modules 2>/dev/null)'
+complete -c dhd -n "__fish_seen_subcommand_from explain" -f -a '(dhd __complete modules 2>/dev/null)'
+"#;
+
+const ZSH_DYNAMIC_COMPLETIONS: &str = r#"
+_dhd_modules() {
+    local -a modules
+    modules=(${(f)"$(dhd __complete modules 2>/dev/null)"})
+    (( $#modules )) && _values -s , 'module' $modules
+}
+
+_dhd_tags() {
+    local -a tags
+    tags=(${(f)"$(dhd __complete tags 2>/dev/null)"})
+    (( $#tags )) && _values -s , 'tag' $tags
+}
+"#;
+
+/// Complete `MODULE` and `TAG` values with the helpers instead of file names
+fn zsh_dynamic_completions(script: &str) -> String {
+    let script = script
+        .replace(":MODULE:_default'", ":MODULE:_dhd_modules'")
+        .replace(":TAG:_default'", ":TAG:_dhd_tags'");
+
+    // The helpers go right after `#compdef` so they exist before `_dhd` runs
+    match script.split_once('\n') {
+        Some((compdef, rest)) => format!("{}\n{}{}", compdef, ZSH_DYNAMIC_COMPLETIONS, rest),
+        None => script,
+    }
+}
+
+/// Replace the file completion offered after `--module`, `--tag` and
+/// `--exclude-tags` with the discovered module names or tags
+fn bash_dynamic_completions(script: &str) -> String {
+    let mut output = String::with_capacity(script.len());
+    let mut candidates = None;
+
+    for line in script.lines() {
+        match (candidates.take(), line.trim()) {
+            (Some(kind), trimmed) if trimmed.starts_with("COMPREPLY=($(compgen -f") => {
+                let indent = &line[..line.len() - line.trim_start().len()];
+                output.push_str(&format!(
+                    "{}COMPREPLY=($(compgen -W \"$(dhd __complete {} 2>/dev/null)\" -- \"${{cur}}\"))",
+                    indent, kind
+                ));
+            }
+            (_, "--module)") => {
+                candidates = Some("modules");
+                output.push_str(line);
+            }
+            (_, "--tag)" | "--exclude-tags)") => {
+                candidates = Some("tags");
+                output.push_str(line);
             }
+            _ => output.push_str(line),
         }
+        output.push('\n');
+    }
+
+    output
+}
+
+/// Print the names or tags of the modules in the current directory
+///
+/// Runs on every tab press, so it stays quiet about modules that fail to load.
+fn print_completion_candidates(candidates: CompletionCandidates) -> Result<(), String> {
+    use dhd::load_modules;
+    use std::collections::BTreeSet;
+
+    let discovered = discover()?;
+
+    let mut values = BTreeSet::new();
+    for module in load_modules(discovered).into_iter().flatten() {
+        match candidates {
+            CompletionCandidates::Modules => {
+                values.insert(module.definition.name);
+            }
+            CompletionCandidates::Tags => values.extend(module.definition.tags),
+        }
+    }
+
+    for value in values {
+        println!("{}", value);
+    }
+    Ok(())
+}
+
+/// The directories to discover modules in, defaulting to the current one
+fn module_roots() -> Result<Vec<PathBuf>, String> {
+    match MODULE_ROOTS.get() {
+        // `~` isn't expanded by the shell after a ':'
+        Some(roots) if !roots.is_empty() => Ok(roots
+            .iter()
+            .map(|root| dhd::paths::expand_path(&root.to_string_lossy()))
+            .collect()),
+        _ => std::env::current_dir()
+            .map(|dir| vec![dir])
+            .map_err(|e| format!("Failed to get current directory: {}", e)),
+    }
+}
+
+/// The host profile from `--host`, or the one matching the hostname
+fn host_profile() -> Result<Option<(String, dhd::imports::HostProfile)>, String> {
+    let requested = HOST.get().and_then(|host| host.as_deref());
+    dhd::imports::select_host(&module_roots()?, requested)
+}
+
+/// Discover the modules of every module root, with their imports
+fn discover() -> Result<Vec<dhd::DiscoveredModule>, String> {
+    let host = host_profile()?;
+    let host = host.as_ref().map(|(name, _)| name.as_str());
+    let roots = module_roots()?;
+    let discovered = dhd::imports::discover_roots(&roots, host)
+        .map_err(|e| format!("Failed to discover modules: {}", e))?;
+    dhd::engine::use_settings(&roots)?;
+    Ok(discovered)
+}
+
+/// Disable the modules the config or the host profile disables
+fn disable_configured(modules: &mut [dhd::LoadedModule]) -> Result<(), String> {
+    let profile = host_profile()?;
+    dhd::engine::disable_configured(
+        modules,
+        &module_roots()?,
+        profile.as_ref().map(|(_, profile)| profile),
+    )
+}
+
+/// Discover and load every module in the module roots
+///
+/// Returns an empty list (after printing why) when no modules were found.
+fn load_all_modules() -> Result<Vec<dhd::LoadedModule>, String> {
+    use dhd::load_modules;
+
+    // Discover all modules with progress
+    let discovered = discover()?;
+    progress!(
+        "● Discovering TypeScript modules... found {}",
+        discovered.len()
+    );
+
+    if discovered.is_empty() {
+        progress!("No TypeScript modules found");
+        return Ok(Vec::new());
+    }
+
+    // Load all modules first to get their actual names with progress
+    progress!("● Loading and validating modules...");
+
+    let load_results = load_modules(discovered.clone());
+    let mut loaded_modules = Vec::new();
+    let mut failed_count = 0;
+
+    for (i, result) in load_results.into_iter().enumerate() {
+        match result {
+            Ok(loaded) => loaded_modules.push(loaded),
+            Err(e) => {
+                let e = dhd::secrets::mask(&e.to_string());
+                eprintln!("  ⎿ ✗ Failed to load module {}: {}", discovered[i].name, e);
+                failed_count += 1;
+            }
+        }
+    }
+
+    warn_undeclared_overrides(&loaded_modules);
+    disable_configured(&mut loaded_modules)?;
+
+    if loaded_modules.is_empty() {
+        return Err(format!(
+            "No modules could be loaded ({} failed)",
+            failed_count
+        ));
+    }
+
+    if failed_count > 0 {
+        progress!("\n● Warning: {} modules failed to load", failed_count);
+    }
+
+    Ok(loaded_modules)
+}
+
+/// Discover, load and filter modules, returning them in dependency order
+///
+/// Returns an empty list (after printing why) when there is nothing to run.
+/// Only the actions of the types given with `--action` are kept, and of those
+/// only the ones `--only-tags` and `--skip-tags` select.
+fn select_modules(selection: &SelectionArgs) -> Result<Vec<dhd::LoadedModule>, String> {
+    use dhd::actions::ACTION_TYPES;
+
+    if let Some(unknown) = selection
+        .action
+        .iter()
+        .find(|name| !ACTION_TYPES.contains(&name.as_str()))
+    {
+        return Err(format!(
+            "Unknown action type '{}'. Available actions: {}",
+            unknown,
+            ACTION_TYPES.join(", ")
+        ));
+    }
+
+    let mut modules = resolve_selection(selection)?;
+    if !selection.action.is_empty() {
+        modules = only_action_types(modules, &selection.action);
+    }
+    if !selection.only_tags.is_empty() || !selection.skip_tags.is_empty() {
+        modules = filter_action_tags(modules, &selection.only_tags, &selection.skip_tags);
+    }
+    Ok(modules)
+}
+
+/// Keep only the actions of `types`, dropping modules left without any
+fn only_action_types(modules: Vec<dhd::LoadedModule>, types: &[String]) -> Vec<dhd::LoadedModule> {
+    let mut left_out = Vec::new();
+    let modules: Vec<_> = modules
+        .into_iter()
+        .filter_map(|mut module| {
+            let mut skipped: std::collections::BTreeMap<&str, usize> = Default::default();
+            module.definition.actions.retain(|action| {
+                let keep = types.iter().any(|name| action.is_type(name));
+                if !keep {
+                    *skipped.entry(action.type_name()).or_default() += 1;
+                }
+                keep
+            });
+
+            if !skipped.is_empty() {
+                let counts: Vec<String> = skipped
+                    .iter()
+                    .map(|(name, count)| format!("{} {}", count, name))
+                    .collect();
+                left_out.push(format!("{}: {}", module.definition.name, counts.join(", ")));
+            }
+            (!module.definition.actions.is_empty()).then_some(module)
+        })
+        .collect();
+
+    if left_out.is_empty() {
+        progress!("● Only running {} actions", types.join(", "));
+    } else {
+        progress!("● Only running {} actions; left out:", types.join(", "));
+        for line in left_out {
+            progress!("  - {}", line);
+        }
+    }
+    if modules.is_empty() {
+        progress!("ℹ️  No modules have {} actions", types.join(", "));
+    }
+
+    modules
+}
+
+/// Keep only the actions with any of the tags in `only` (all of them if
+/// empty) and none of the tags in `skip`, dropping modules left without any
+fn filter_action_tags(
+    modules: Vec<dhd::LoadedModule>,
+    only: &[String],
+    skip: &[String],
+) -> Vec<dhd::LoadedModule> {
+    let mut filtered = 0;
+    let mut left_out = Vec::new();
+    let modules: Vec<_> = modules
+        .into_iter()
+        .filter_map(|mut module| {
+            let before = module.definition.actions.len();
+            module.definition.actions.retain(|action| {
+                let tags = action.tags();
+                (only.is_empty() || only.iter().any(|tag| tags.contains(tag)))
+                    && !skip.iter().any(|tag| tags.contains(tag))
+            });
+
+            let skipped = before - module.definition.actions.len();
+            if skipped > 0 {
+                filtered += skipped;
+                left_out.push(format!("{}: {}", module.definition.name, skipped));
+            }
+            (!module.definition.actions.is_empty()).then_some(module)
+        })
+        .collect();
+
+    let mut filters = Vec::new();
+    if !only.is_empty() {
+        filters.push(format!("only {}", only.join(", ")));
+    }
+    if !skip.is_empty() {
+        filters.push(format!("skipping {}", skip.join(", ")));
+    }
+    if left_out.is_empty() {
+        progress!(
+            "● Action tags ({}) filtered out no actions",
+            filters.join("; ")
+        );
+    } else {
+        progress!(
+            "● Action tags ({}) filtered out {} action{}:",
+            filters.join("; "),
+            filtered,
+            if filtered == 1 { "" } else { "s" }
+        );
+        for line in left_out {
+            progress!("  - {}", line);
+        }
+    }
+    if modules.is_empty() {
+        progress!("ℹ️  No actions are left after filtering by action tags");
+    }
+
+    modules
+}
+
+/// Modules matching the name, tag and host profile filters, with their dependencies
+fn resolve_selection(selection: &SelectionArgs) -> Result<Vec<dhd::LoadedModule>, String> {
+    use dhd::dependency_resolver::{
+        DependencyError, disabled_dependencies, resolve_dependencies_within,
+        resolve_with_dependencies,
+    };
+
+    if let Some(path) = &selection.file {
+        return load_file_module(path).map(|module| vec![module]);
+    }
+    if let Some(reason) = &selection.nothing {
+        progress!("ℹ️  {}", reason);
+        return Ok(Vec::new());
+    }
+
+    let loaded_modules = load_all_modules()?;
+    check_missing_modules(selection, &loaded_modules)?;
+    if loaded_modules.is_empty() {
+        return Ok(Vec::new());
+    }
+
+    // Filter modules by their actual names and tags, or by the host profile's
+    let mut filter = selection.filter();
+    if let Some((name, profile)) = host_profile()? {
+        match &profile.groups {
+            Some(groups) => progress!(
+                "● Using host profile '{}' (groups: {})",
+                name,
+                groups.join(", ")
+            ),
+            None => progress!("● Using host profile '{}'", name),
+        }
+        if filter.modules.is_empty() && filter.tags.is_empty() {
+            filter.modules = profile.modules.unwrap_or_default();
+            filter.tags = profile.tags.unwrap_or_default();
+        }
+    }
+    let filtered_modules: Vec<_> = loaded_modules
+        .iter()
+        .filter(|module| filter.matches(&module.definition))
+        .cloned()
+        .collect();
+
+    if filtered_modules.is_empty() {
+        if filter.is_empty() {
+            progress!("ℹ️  No modules to execute");
+        } else {
+            progress!(
+                "ℹ️  No modules matched the specified filters: {}",
+                filter.describe()
+            );
+        }
+        return Ok(Vec::new());
+    }
+
+    let ordered = if selection.no_deps {
+        resolve_dependencies_within(filtered_modules)
+            .map_err(|e| format!("Failed to resolve dependencies: {}", e))?
+    } else {
+        // Include dependencies of selected modules, in execution order
+        resolve_with_dependencies(filtered_modules, &loaded_modules).map_err(|e| match e {
+            DependencyError::MissingDependency { .. } => e.to_string(),
+            _ => format!("Failed to resolve dependencies: {}", e),
+        })?
+    };
+    for (module, dependency) in disabled_dependencies(&ordered, &loaded_modules) {
+        log::warn!(
+            "Module '{}' depends on '{}', which is disabled",
+            module,
+            dependency
+        );
+    }
+    Ok(ordered)
+}
+
+/// Fail if a module named with `--module` doesn't exist, or only warn with
+/// `--ignore-missing`
+fn check_missing_modules(
+    selection: &SelectionArgs,
+    loaded_modules: &[dhd::LoadedModule],
+) -> Result<(), String> {
+    let missing: Vec<String> = selection
+        .module
+        .iter()
+        .filter(|name| !loaded_modules.iter().any(|m| &m.definition.name == *name))
+        .map(|name| format!("'{}'", name))
+        .collect();
+    if missing.is_empty() {
+        return Ok(());
+    }
+
+    let names = missing.join(", ");
+    let plural = if missing.len() == 1 { "" } else { "s" };
+    if !selection.ignore_missing {
+        return Err(format!("Module{} {} not found", plural, names));
+    }
+    log::warn!("Module{} {} not found, going on without", plural, names);
+    MISSING_MODULES.store(true, Ordering::Relaxed);
+    Ok(())
+}
+
+/// Exit with `code`, or with `MISSING_MODULES` instead of success if
+/// `--ignore-missing` went on without modules
+fn exit_with(code: i32) -> ! {
+    if code == dhd::exit_code::SUCCESS && MISSING_MODULES.load(Ordering::Relaxed) {
+        std::process::exit(dhd::exit_code::MISSING_MODULES);
+    }
+    std::process::exit(code)
+}
+
+/// Load the module in `path` for `--file`, without the modules it depends on
+fn load_file_module(path: &std::path::Path) -> Result<dhd::LoadedModule, String> {
+    let host = host_profile()?;
+    let host = host.as_ref().map(|(name, _)| name.as_str());
+    let roots = module_roots()?;
+    let discovered = dhd::imports::discover_file(&roots, path, host)?;
+    dhd::engine::use_settings(&roots)?;
+    progress!(
+        "● Loading module {} from {}",
+        discovered.name,
+        path.display()
+    );
+
+    let mut module = dhd::load_module(&discovered)
+        .map_err(|e| format!("Failed to load module {}: {}", path.display(), e))?;
+    if !module.definition.dependencies.is_empty() {
+        log::warn!(
+            "Not applying {}, which {} depends on: --file only applies its own module",
+            module.definition.dependencies.join(", "),
+            module.definition.name
+        );
+        module.definition.dependencies.clear();
+    }
+    warn_undeclared_overrides(std::slice::from_ref(&module));
+    disable_configured(std::slice::from_mut(&mut module))?;
+    Ok(module)
+}
+
+/// Warn about the `--set` variables no module uses, likely typos
+fn warn_undeclared_overrides(modules: &[dhd::LoadedModule]) {
+    for name in dhd::overrides::undeclared(modules) {
+        log::warn!(
+            "--set {}: no module declares this variable in its variableSchema or sets it",
+            name
+        );
+    }
+}
+
+/// Default number of parallel workers (number of CPUs)
+fn default_concurrency() -> usize {
+    std::thread::available_parallelism()
+        .map(|n| n.get())
+        .unwrap_or(4) // Default to 4 if we can't determine CPU count
+}
+
+/// An error ending a command, with the code of `dhd::exit_code` it exits with
+struct Failure {
+    code: i32,
+    message: String,
+}
+
+impl Failure {
+    /// The modules, `dhd.config.ts` or the command line are invalid
+    fn config(message: String) -> Self {
+        Self {
+            code: dhd::exit_code::CONFIG_ERROR,
+            message,
+        }
+    }
+}
+
+impl From<String> for Failure {
+    fn from(message: String) -> Self {
+        Self {
+            code: dhd::exit_code::FAILURE,
+            message,
+        }
+    }
+}
+
+impl std::fmt::Display for Failure {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(&dhd::secrets::mask(&self.message))
+    }
+}
+
+/// The failure of an apply that had `summary`'s failed actions, if any
+fn apply_failure(summary: &dhd::ExecutionSummary) -> Option<Failure> {
+    (!summary.failed.is_empty()).then(|| Failure {
+        code: dhd::exit_code::of_apply(summary.completed, summary.failed.len(), 0),
+        message: format!("Execution failed: {} atoms failed", summary.failed.len()),
+    })
+}
+
+fn apply_modules(
+    dry_run: bool,
+    selection: SelectionArgs,
+    jobs: usize,
+    verbose: bool,
+    timings: bool,
+    yes: bool,
+    no_backup: bool,
+    only_changed: bool,
+    limit: Option<usize>,
+    incremental: bool,
+    keep_going: bool,
+    diff: bool,
+    report: Option<&ReportTarget>,
+) -> Result<usize, Failure> {
+    use dhd::ExecutionEngine;
+
+    let start = std::time::Instant::now();
+    let finish = |summary: Option<&dhd::ExecutionSummary>, error: Option<&str>| {
+        finish_apply(report, summary, error, dry_run, start);
+    };
+
+    let resolved_modules = select_modules(&selection)
+        .inspect_err(|e| finish(None, Some(e.as_str())))
+        .map_err(Failure::config)?;
+    if resolved_modules.is_empty() {
+        finish(None, None);
+        return Ok(0);
     }
-    
-    // Resolve dependencies to get correct execution order
-    let resolved_modules = resolve_dependencies(modules_with_deps)
-        .map_err(|e| format!("Failed to resolve dependencies: {}", e))?;
 
     // Show selected modules
-    println!(
+    progress!(
         "\n● Selected modules for {}:",
         if dry_run { "dry run" } else { "execution" }
     );
@@ -393,7 +1717,7 @@ fn apply_modules(
         } else {
             "  ├"
         };
-        println!(
+        progress!(
             "{} {} ({} action{}){}{}",
             prefix,
             module.definition.name,
@@ -405,55 +1729,1505 @@ fn apply_modules(
     }
 
     // Execute modules
-    println!(); // Add spacing before execution
+    progress!(); // Add spacing before execution
 
-    // Use default concurrency (number of CPUs) for execution
-    let concurrency = std::thread::available_parallelism()
-        .map(|n| n.get())
-        .unwrap_or(4); // Default to 4 if we can't determine CPU count
-    let engine = ExecutionEngine::new(concurrency, dry_run, verbose);
+    let mut engine = ExecutionEngine::new(jobs, dry_run, verbose)
+        .with_changes_only(QUIET.load(Ordering::Relaxed))
+        .with_timings(timings)
+        .with_backups(!no_backup)
+        .with_only_changed(only_changed)
+        .with_limit(limit)
+        .with_incremental(incremental)
+        .with_keep_going(keep_going)
+        .with_diffs(diff);
+    if !yes {
+        engine = engine.with_confirmation(Box::new(confirm_destruction));
+    }
 
     // Execute the modules
-    match engine.execute(resolved_modules) {
-        Ok(_) => Ok(()),
-        Err(e) => Err(format!("Execution failed: {}", e)),
+    let summary = engine.apply(resolved_modules).map_err(|e| {
+        let e = format!("Execution failed: {}", e);
+        finish(None, Some(e.as_str()));
+        e
+    })?;
+    finish(Some(&summary), None);
+
+    match apply_failure(&summary) {
+        Some(failure) => Err(failure),
+        None => Ok(summary.completed),
     }
 }
 
-fn main() {
-    let cli = Cli::parse();
+/// Apply the selected modules, then re-apply the ones whose files change
+/// (with their dependents) until Ctrl-C
+fn watch_modules(
+    selection: SelectionArgs,
+    apply: impl Fn(SelectionArgs) -> Result<usize, Failure>,
+) -> Result<usize, Failure> {
+    use std::time::Duration;
 
-    match cli.command {
-        Commands::Generate { generate_command } => match generate_command {
-            GenerateCommands::Types => {
-                if let Err(e) = generate_types() {
-                    eprintln!("Error: {}", e);
-                    std::process::exit(1);
-                }
+    static STOP: AtomicBool = AtomicBool::new(false);
+    std::thread::spawn(|| {
+        let Ok(runtime) = tokio::runtime::Builder::new_current_thread()
+            .enable_all()
+            .build()
+        else {
+            return;
+        };
+        runtime.block_on(async {
+            if tokio::signal::ctrl_c().await.is_ok() {
+                STOP.store(true, Ordering::Relaxed);
+                eprintln!("\n● Stopping after the current apply (Ctrl-C again to quit now)");
             }
-        },
-        Commands::Context { context_command } => match context_command {
-            ContextCommands::List => {
-                if let Err(e) = list_contexts() {
-                    eprintln!("Error: {}", e);
-                    std::process::exit(1);
-                }
+            if tokio::signal::ctrl_c().await.is_ok() {
+                std::process::exit(dhd::exit_code::INTERRUPTED);
             }
-        },
-        Commands::List => {
-            if let Err(e) = list_modules() {
+        });
+    });
+
+    let roots = match &selection.file {
+        // What a single module file reads lives next to it
+        Some(path) => vec![
+            path.parent()
+                .filter(|dir| !dir.as_os_str().is_empty())
+                .unwrap_or(std::path::Path::new("."))
+                .to_path_buf(),
+        ],
+        None => module_roots()?,
+    };
+    if let Err(e) = apply(selection.clone()) {
+        eprintln!("Error: {}", e);
+    }
+
+    loop {
+        progress!(
+            "\n👀 Watching {} for changes (Ctrl-C to stop)",
+            roots
+                .iter()
+                .map(|root| root.display().to_string())
+                .collect::<Vec<_>>()
+                .join(", ")
+        );
+        let Some(changed) = dhd::watch::wait_for_changes(
+            &roots,
+            Duration::from_millis(250),
+            Duration::from_millis(300),
+            &STOP,
+        ) else {
+            break;
+        };
+
+        progress!("\n● Changed:");
+        for path in &changed {
+            progress!("  - {}", path.display());
+        }
+
+        // Modules are reloaded so edits to them take effect
+        let selected = match resolve_selection(&selection) {
+            Ok(selected) => selected,
+            Err(e) => {
                 eprintln!("Error: {}", e);
-                std::process::exit(1);
+                continue;
             }
+        };
+        let affected = dhd::watch::affected_modules(&selected, &changed);
+        if affected.is_empty() {
+            progress!("ℹ️  No selected modules are affected");
+            continue;
         }
-        Commands::Apply {
-            dry_run,
-            module,
-            tag,
-            all_tags,
-            verbose,
-        } => {
-            if let Err(e) = apply_modules(dry_run, module, tag, all_tags, verbose) {
+
+        progress!("● Re-applying {}", affected.join(", "));
+        let rerun = SelectionArgs {
+            module: affected,
+            no_deps: true,
+            file: selection.file.clone(),
+            action: selection.action.clone(),
+            only_tags: selection.only_tags.clone(),
+            skip_tags: selection.skip_tags.clone(),
+            ..Default::default()
+        };
+        if let Err(e) = apply(rerun) {
+            eprintln!("Error: {}", e);
+        }
+        if STOP.load(Ordering::Relaxed) {
+            break;
+        }
+    }
+
+    progress!("● Stopped watching");
+    Ok(0)
+}
+
+/// Narrow `selection` to the modules whose files changed since `commit` and
+/// the modules depending on them, or leave it as it is when git can't tell
+fn select_changed(selection: SelectionArgs, commit: &str) -> Result<SelectionArgs, String> {
+    let changed = match dhd::git_diff::changed_files(&module_roots()?, commit) {
+        Ok(changed) => changed,
+        Err(e) => {
+            log::warn!(
+                "Can't tell what changed since {}, applying every selected module: {}",
+                commit,
+                e
+            );
+            return Ok(selection);
+        }
+    };
+
+    let selected = resolve_selection(&selection)?;
+    let affected = dhd::watch::affected_modules(&selected, &changed);
+    if affected.is_empty() {
+        return Ok(SelectionArgs {
+            nothing: Some(format!("No selected modules changed since {}", commit)),
+            ..selection
+        });
+    }
+    progress!("● Changed since {}: {}", commit, affected.join(", "));
+    Ok(SelectionArgs {
+        module: affected,
+        no_deps: true,
+        action: selection.action,
+        only_tags: selection.only_tags,
+        skip_tags: selection.skip_tags,
+        ..Default::default()
+    })
+}
+
+/// Apply modules and print an `ApplyReport` as JSON on stdout
+fn apply_modules_json(
+    dry_run: bool,
+    selection: SelectionArgs,
+    jobs: usize,
+    yes: bool,
+    no_backup: bool,
+    only_changed: bool,
+    incremental: bool,
+    keep_going: bool,
+    report: Option<&ReportTarget>,
+) -> Result<usize, Failure> {
+    use dhd::{ApplyReport, ExecutionEngine, ExecutionSummary};
+
+    PROGRESS_TO_STDERR.store(true, Ordering::Relaxed);
+    let start = std::time::Instant::now();
+    let finish = |summary: Option<&ExecutionSummary>, error: Option<&str>| {
+        finish_apply(report, summary, error, dry_run, start);
+    };
+
+    let resolved_modules = select_modules(&selection)
+        .inspect_err(|e| finish(None, Some(e.as_str())))
+        .map_err(Failure::config)?;
+    let summary = if resolved_modules.is_empty() {
+        ExecutionSummary {
+            total: 0,
+            completed: 0,
+            skipped: 0,
+            failed: Vec::new(),
+            modules: Vec::new(),
+            stopped_by: None,
+        }
+    } else {
+        let mut engine = ExecutionEngine::new(jobs, dry_run, false)
+            .with_quiet(true)
+            .with_backups(!no_backup)
+            .with_only_changed(only_changed)
+            .with_incremental(incremental)
+            .with_keep_going(keep_going);
+        if !yes {
+            engine = engine.with_confirmation(Box::new(confirm_destruction));
+        }
+        engine.apply(resolved_modules).map_err(|e| {
+            let e = format!("Execution failed: {}", e);
+            finish(None, Some(e.as_str()));
+            e
+        })?
+    };
+    finish(Some(&summary), None);
+
+    let report = ApplyReport::new(&summary, dry_run, start.elapsed());
+    let json = serde_json::to_string_pretty(&report)
+        .map_err(|e| format!("Failed to serialize results: {}", e))?;
+    println!("{}", dhd::secrets::mask(&json));
+
+    match apply_failure(&summary) {
+        Some(failure) => Err(failure),
+        None => Ok(summary.completed),
+    }
+}
+
+/// List the destructive changes an apply would make and ask whether to go on
+///
+/// The prompt goes to stderr so JSON output stays clean. Without a terminal to
+/// answer on, the apply is aborted instead of waiting for input.
+fn confirm_destruction(destructions: &[(String, dhd::atom::Destruction)]) -> bool {
+    let items: Vec<String> = destructions
+        .iter()
+        .map(|(module, destruction)| format!("{} ({})", destruction.describe(), module))
+        .collect();
+    confirm("This apply would:", &items)
+}
+
+/// List `items` under `heading` on stderr and ask whether to go ahead
+fn confirm(heading: &str, items: &[String]) -> bool {
+    use std::io::{BufRead, IsTerminal, Write};
+
+    eprintln!("\n⚠️  {}", heading);
+    for item in items {
+        eprintln!("  - {}", item);
+    }
+
+    if !std::io::stdin().is_terminal() {
+        eprintln!("Not running interactively; pass --yes to make these changes");
+        return false;
+    }
+
+    eprint!("Continue? [y/N] ");
+    std::io::stderr().flush().ok();
+    let mut answer = String::new();
+    if std::io::stdin().lock().read_line(&mut answer).is_err() {
+        return false;
+    }
+    matches!(answer.trim().to_lowercase().as_str(), "y" | "yes")
+}
+
+/// Undo the changes recorded for the most recent apply, newest first
+/// Print the `last` latest applies of the history, newest first
+fn show_history(last: Option<usize>, json: bool) -> Result<(), String> {
+    use dhd::ActionStatus::{Applied, Failed, Noop};
+    use dhd::color::paint;
+    use dhd::history::relative;
+
+    let mut entries = dhd::history::load(&dhd::state::state_dir())?;
+    entries.reverse();
+    let last_success = entries
+        .iter()
+        .find(|entry| entry.success)
+        .map(|entry| entry.timestamp);
+    entries.truncate(last.unwrap_or(entries.len()));
+
+    if json {
+        let history = serde_json::json!({
+            "lastSuccess": last_success,
+            "applies": entries,
+        });
+        let json = serde_json::to_string_pretty(&history)
+            .map_err(|e| format!("Failed to serialize history: {}", e))?;
+        println!("{}", json);
+        return Ok(());
+    }
+
+    if entries.is_empty() {
+        println!("ℹ️  No applies recorded yet");
+        return Ok(());
+    }
+    let now = std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .map(|elapsed| elapsed.as_secs())
+        .unwrap_or_default();
+    match last_success {
+        Some(timestamp) => println!("● Last successful apply: {}", relative(timestamp, now)),
+        None => println!("● No apply has succeeded yet"),
+    }
+    for entry in &entries {
+        let mut result = match entry.changed {
+            0 => "nothing changed".to_string(),
+            changed => format!("{} of {} actions changed", changed, entry.actions),
+        };
+        if !entry.changed_modules.is_empty() {
+            result.push_str(&format!(" ({})", entry.changed_modules.join(", ")));
+        }
+        if entry.failed > 0 {
+            result = format!("{} failed, {}", entry.failed, result);
+        }
+        if let Some(error) = &entry.error {
+            result = error.clone();
+        }
+        let (status, icon) = if !entry.success {
+            (Failed, "❌")
+        } else if entry.changed > 0 {
+            (Applied, "✅")
+        } else {
+            (Noop, "⏭️ ")
+        };
+        println!(
+            "  {} {} · {} · dhd {} · {:.1}s",
+            paint(status, icon),
+            relative(entry.timestamp, now),
+            result,
+            entry.version,
+            entry.duration_ms as f64 / 1000.0
+        );
+    }
+    Ok(())
+}
+
+fn rollback_last_apply(uninstall_packages: bool) -> Result<(), String> {
+    use dhd::ActionStatus::{Applied, Failed, Skipped};
+    use dhd::color::paint;
+    use dhd::state::{State, Undo, state_dir};
+
+    let dir = state_dir();
+    let mut state = State::load(&dir)?;
+    let Some(apply) = state.applies.last().cloned() else {
+        println!("ℹ️  Nothing to roll back");
+        return Ok(());
+    };
+
+    println!(
+        "● Rolling back apply {} ({} change{})",
+        apply.id,
+        apply.changes.len(),
+        if apply.changes.len() == 1 { "" } else { "s" }
+    );
+    let mut failed = 0;
+    for recorded in apply.changes.iter().rev() {
+        match recorded.change.undo(uninstall_packages) {
+            Ok(Undo::Done(message)) => println!("  {}", paint(Applied, &format!("✅ {}", message))),
+            Ok(Undo::Skipped(message)) => {
+                println!("  {}", paint(Skipped, &format!("⏭️  {}", message)))
+            }
+            Err(e) => {
+                failed += 1;
+                println!("  {}", paint(Failed, &format!("❌ {}", e)));
+            }
+        }
+    }
+
+    // Keep the record so the rollback can be retried once the failures are fixed
+    if failed > 0 {
+        return Err(format!("{} change(s) could not be rolled back", failed));
+    }
+
+    state.applies.pop();
+    // What was undone has to be applied again, even by an incremental apply
+    state.inputs.clear();
+    state.save(&dir)?;
+    apply.remove_backups(&dir)
+}
+
+/// Undo the changes recorded for `modules`, newest first, except those to
+/// paths or packages another module recorded or still declares
+fn uninstall_modules(
+    modules: &[String],
+    uninstall_packages: bool,
+    dry_run: bool,
+    yes: bool,
+) -> Result<(), String> {
+    use dhd::ActionStatus::{Applied, Failed, Skipped};
+    use dhd::color::paint;
+    use dhd::state::{Change, State, Undo, state_dir};
+
+    let dir = state_dir();
+    let mut state = State::load(&dir)?;
+    let (owned, kept) = state.changes_of(modules);
+    let declared = declared_packages(modules);
+    let change_at = |&(a, c): &(usize, usize)| state.applies[a].changes[c].change.clone();
+    let (owned, mut kept): (Vec<_>, Vec<_>) = owned.into_iter().partition(|position| {
+        !matches!(change_at(position), Change::Package { name, .. } if declared.contains(&name))
+    });
+    kept.sort();
+    kept.dedup();
+
+    if owned.is_empty() && kept.is_empty() {
+        println!("ℹ️  Nothing recorded for {}", modules.join(", "));
+        return Ok(());
+    }
+
+    println!(
+        "● {} {} ({} change{})",
+        if dry_run {
+            "Would uninstall"
+        } else {
+            "Uninstalling"
+        },
+        modules.join(", "),
+        owned.len(),
+        if owned.len() == 1 { "" } else { "s" }
+    );
+    for position in &kept {
+        println!(
+            "  ⏭️  {} is shared with another module, leaving it alone",
+            change_at(position).describe()
+        );
+    }
+
+    if dry_run {
+        for position in &owned {
+            match change_at(position) {
+                Change::Package { .. } if !uninstall_packages => println!(
+                    "  ⏭️  {} left installed (pass --packages to remove it)",
+                    change_at(position).describe()
+                ),
+                other => println!("  - undo {}", other.describe()),
+            }
+        }
+        return Ok(());
+    }
+
+    if uninstall_packages && !yes {
+        let packages: Vec<String> = owned
+            .iter()
+            .map(change_at)
+            .filter(|change| matches!(change, Change::Package { .. }))
+            .map(|change| change.describe())
+            .collect();
+        if !packages.is_empty() && !confirm("This uninstall would remove:", &packages) {
+            return Err("Aborted before uninstalling packages".to_string());
+        }
+    }
+
+    let mut failed = 0;
+    let mut undone = Vec::new();
+    for position in &owned {
+        let change = change_at(position);
+        match change.undo(uninstall_packages) {
+            Ok(Undo::Done(message)) => {
+                println!("  {}", paint(Applied, &format!("✅ {}", message)));
+                change.remove_backup();
+                undone.push(*position);
+            }
+            Ok(Undo::Skipped(message)) => {
+                println!("  {}", paint(Skipped, &format!("⏭️  {}", message)));
+                // Left installed, so a later uninstall with --packages can remove it
+                if !matches!(change, Change::Package { .. }) {
+                    undone.push(*position);
+                }
+            }
+            Err(e) => {
+                failed += 1;
+                println!("  {}", paint(Failed, &format!("❌ {}", e)));
+            }
+        }
+    }
+
+    // Failed changes stay recorded so the uninstall can be retried
+    state.forget(&undone);
+    for module in modules {
+        state.inputs.remove(module);
+    }
+    state.save(&dir)?;
+
+    if failed > 0 {
+        return Err(format!("{} change(s) could not be undone", failed));
+    }
+    Ok(())
+}
+
+/// Remove what wires DHD-managed files into the rc files of `shells`
+fn unwire_shells(shells: &[String], dry_run: bool) -> Result<(), String> {
+    use dhd::atoms::env_var::{Shell, unwire};
+
+    let shells = if shells.is_empty() {
+        vec![Shell::Bash, Shell::Zsh, Shell::Fish]
+    } else {
+        shells
+            .iter()
+            .map(|shell| shell.parse())
+            .collect::<Result<_, _>>()?
+    };
+
+    let home = dhd::paths::expand_path("~");
+    let mut unwired = 0;
+    for shell in shells {
+        let rc_file = home.join(shell.rc_file());
+        if unwire(&rc_file, dry_run)? {
+            unwired += 1;
+            let verb = if dry_run { "Would unwire" } else { "Unwired" };
+            println!("● {} {}", verb, rc_file.display());
+        }
+    }
+    if unwired == 0 {
+        println!("ℹ️  No shell rc file sources DHD-managed files");
+    }
+    Ok(())
+}
+
+/// Add the packages `action` installs to `names`
+fn collect_packages(action: &dhd::ActionType, names: &mut std::collections::HashSet<String>) {
+    use dhd::ActionType;
+    use dhd::atoms::package::split_version;
+
+    match action {
+        ActionType::PackageInstall(install) if install.ensure.as_deref() != Some("absent") => {
+            let unpinned = install.names.iter().map(|name| split_version(name).0);
+            names.extend(unpinned.map(String::from));
+        }
+        ActionType::Notify(notify) => collect_packages(&notify.action, names),
+        ActionType::Tagged(tagged) => collect_packages(&tagged.action, names),
+        ActionType::Verified(verified) => collect_packages(&verified.action, names),
+        ActionType::Ordered(ordered) => collect_packages(&ordered.action, names),
+        ActionType::Reboot(reboot) => collect_packages(&reboot.action, names),
+        ActionType::Conditional(conditional) => collect_packages(&conditional.action, names),
+        _ => {}
+    }
+}
+
+/// Packages that modules other than `modules` still declare, if they load
+fn declared_packages(modules: &[String]) -> std::collections::HashSet<String> {
+    let mut names = std::collections::HashSet::new();
+    for module in load_all_modules().unwrap_or_default() {
+        if modules.contains(&module.definition.name) {
+            continue;
+        }
+        for action in &module.definition.actions {
+            collect_packages(action, &mut names);
+        }
+    }
+    names
+}
+
+/// The paths and packages any module declares, whatever its conditions
+///
+/// Fails when a module doesn't load or can't tell what it manages, since
+/// its resources would then look undeclared.
+fn declared_resources() -> Result<
+    (
+        std::collections::HashSet<PathBuf>,
+        std::collections::HashSet<String>,
+    ),
+    String,
+> {
+    use dhd::{Action, ActionType};
+
+    fn unwrap(action: &ActionType) -> &ActionType {
+        match action {
+            ActionType::Notify(notify) => unwrap(&notify.action),
+            ActionType::Tagged(tagged) => unwrap(&tagged.action),
+            ActionType::Verified(verified) => unwrap(&verified.action),
+            ActionType::Ordered(ordered) => unwrap(&ordered.action),
+            ActionType::Reboot(reboot) => unwrap(&reboot.action),
+            ActionType::Conditional(conditional) => unwrap(&conditional.action),
+            action => action,
+        }
+    }
+
+    let discovered = discover()?;
+    let mut modules = Vec::new();
+    for (module, result) in discovered.iter().zip(dhd::load_modules(discovered.clone())) {
+        modules.push(result.map_err(|e| {
+            format!(
+                "Not pruning: module {} failed to load ({}), so what it declares is unknown",
+                module.name, e
+            )
+        })?);
+    }
+
+    let mut paths = std::collections::HashSet::new();
+    let mut packages = std::collections::HashSet::new();
+    for module in &modules {
+        let module_dir = module
+            .source
+            .path
+            .parent()
+            .unwrap_or(std::path::Path::new("."));
+        let handlers = module
+            .definition
+            .handlers
+            .iter()
+            .flat_map(|handler| &handler.actions);
+        for action in module.definition.actions.iter().chain(handlers) {
+            collect_packages(action, &mut packages);
+            for atom in unwrap(action).plan(module_dir) {
+                let managed = atom.managed_paths().map_err(|e| {
+                    format!(
+                        "Not pruning: can't tell what {} manages in module {}: {}",
+                        atom.describe(),
+                        module.definition.name,
+                        e
+                    )
+                })?;
+                paths.extend(managed);
+            }
+        }
+    }
+    Ok((paths, packages))
+}
+
+/// Undo the recorded changes to paths and packages no module declares any
+/// more, newest first, after listing them; returns how many were undone
+fn prune_undeclared(uninstall_packages: bool, dry_run: bool, yes: bool) -> Result<usize, String> {
+    use dhd::ActionStatus::{Applied, Failed, Skipped};
+    use dhd::color::paint;
+    use dhd::state::{Change, State, Undo, state_dir};
+
+    let (paths, packages) = declared_resources()?;
+    let dir = state_dir();
+    let mut state = State::load(&dir)?;
+    let change_at = |&(a, c): &(usize, usize)| state.applies[a].changes[c].change.clone();
+    let (undeclared, installed): (Vec<_>, Vec<_>) = state
+        .undeclared(&paths, &packages)
+        .into_iter()
+        .partition(|position| {
+            uninstall_packages || !matches!(change_at(position), Change::Package { .. })
+        });
+
+    for position in &installed {
+        println!(
+            "  ⏭️  {} left installed (pass --prune-packages to remove it)",
+            change_at(position).describe()
+        );
+    }
+    if undeclared.is_empty() {
+        println!("ℹ️  Nothing to prune");
+        return Ok(0);
+    }
+
+    println!(
+        "\n● {} {} change{} no module declares any more:",
+        if dry_run { "Would prune" } else { "Pruning" },
+        undeclared.len(),
+        if undeclared.len() == 1 { "" } else { "s" }
+    );
+    for position in &undeclared {
+        println!("  - undo {}", change_at(position).describe());
+    }
+    if dry_run {
+        return Ok(0);
+    }
+    if !yes && !confirm("Pruning undoes the changes listed above", &[]) {
+        return Err("Aborted before pruning".to_string());
+    }
+
+    let mut failed = 0;
+    let mut undone = Vec::new();
+    for position in &undeclared {
+        let change = change_at(position);
+        match change.undo(uninstall_packages) {
+            Ok(Undo::Done(message)) => {
+                println!("  {}", paint(Applied, &format!("✅ {}", message)));
+                change.remove_backup();
+                undone.push(*position);
+            }
+            Ok(Undo::Skipped(message)) => {
+                println!("  {}", paint(Skipped, &format!("⏭️  {}", message)));
+                undone.push(*position);
+            }
+            Err(e) => {
+                failed += 1;
+                println!("  {}", paint(Failed, &format!("❌ {}", e)));
+            }
+        }
+    }
+
+    // Failed changes stay recorded so the next prune retries them
+    state.forget(&undone);
+    state.save(&dir)?;
+
+    if failed > 0 {
+        return Err(format!("{} change(s) could not be pruned", failed));
+    }
+    Ok(undone.len())
+}
+
+/// Print what `apply` would change and return the number of pending atoms
+fn plan_modules(selection: SelectionArgs, verbose: bool) -> Result<usize, Failure> {
+    use dhd::{AtomStatus, ExecutionEngine};
+
+    let resolved_modules = select_modules(&selection).map_err(Failure::config)?;
+    if resolved_modules.is_empty() {
+        return Ok(0);
+    }
+
+    let engine = ExecutionEngine::new(default_concurrency(), true, verbose);
+    let plans = engine
+        .plan(resolved_modules)
+        .map_err(|e| format!("Planning failed: {}", e))?;
+
+    let mut pending = 0;
+    let mut satisfied = 0;
+    let mut unchecked = 0;
+
+    println!("\n● Plan:");
+    for plan in &plans {
+        if let Some(reason) = &plan.skipped {
+            println!("  {} - skipped ({})", plan.name, reason);
+            continue;
+        }
+
+        match &plan.description {
+            Some(description) => println!("  {} - {}", plan.name, description),
+            None => println!("  {}", plan.name),
+        }
+        for atom in &plan.atoms {
+            let marker = match atom.status {
+                AtomStatus::Pending => {
+                    pending += 1;
+                    "+ pending  "
+                }
+                AtomStatus::Satisfied => {
+                    satisfied += 1;
+                    "✓ no-op    "
+                }
+                AtomStatus::Unchecked => {
+                    unchecked += 1;
+                    "~ will run "
+                }
+            };
+            println!("    {} {}", marker, atom.description);
+        }
+    }
+
+    println!(
+        "\n{} pending, {} already satisfied, {} without an idempotency check",
+        pending, satisfied, unchecked
+    );
+    print!("{}", dhd::deprecation::summary());
+    print!("{}", dhd::atoms::network::summary());
+
+    Ok(pending)
+}
+
+/// Plan the modules `selection` selects like `plan_modules`, as a document
+/// `apply --plan-file` can apply
+fn plan_document(
+    selection: &SelectionArgs,
+    verbose: bool,
+) -> Result<dhd::plan_file::PlanDocument, Failure> {
+    use dhd::ExecutionEngine;
+    use dhd::plan_file::PlanDocument;
+
+    let resolved_modules = select_modules(selection).map_err(Failure::config)?;
+    let modules = if resolved_modules.is_empty() {
+        Vec::new()
+    } else {
+        ExecutionEngine::new(default_concurrency(), true, verbose)
+            .plan(resolved_modules)
+            .map_err(|e| format!("Planning failed: {}", e))?
+    };
+    Ok(PlanDocument {
+        actions: selection.action.clone(),
+        only_tags: selection.only_tags.clone(),
+        skip_tags: selection.skip_tags.clone(),
+        ..PlanDocument::new(modules)
+    })
+}
+
+/// Print the plan as JSON on stdout and return the number of pending atoms
+fn plan_modules_json(selection: SelectionArgs, verbose: bool) -> Result<usize, Failure> {
+    PROGRESS_TO_STDERR.store(true, Ordering::Relaxed);
+    let plan = plan_document(&selection, verbose)?;
+    let json = serde_json::to_string_pretty(&plan)
+        .map_err(|e| format!("Failed to serialize the plan: {}", e))?;
+    println!("{}", dhd::secrets::mask(&json));
+    Ok(plan.pending_count())
+}
+
+/// Select the modules of the plan in `path`, after planning them again to
+/// check nothing drifted since it was made
+fn select_planned(
+    path: &std::path::Path,
+    allow_drift: bool,
+    verbose: bool,
+) -> Result<SelectionArgs, Failure> {
+    let plan = dhd::plan_file::PlanDocument::load(path).map_err(Failure::config)?;
+    let selection = SelectionArgs {
+        module: plan
+            .modules
+            .iter()
+            .map(|module| module.name.clone())
+            .collect(),
+        no_deps: true,
+        action: plan.actions.clone(),
+        only_tags: plan.only_tags.clone(),
+        skip_tags: plan.skip_tags.clone(),
+        // A module removed since is drift rather than a mistyped name
+        ignore_missing: true,
+        nothing: plan
+            .modules
+            .is_empty()
+            .then(|| format!("The plan in {} has no modules", path.display())),
+        ..Default::default()
+    };
+    if plan.modules.is_empty() {
+        return Ok(selection);
+    }
+
+    let drift = plan.drift(&plan_document(&selection, verbose)?);
+    if drift.is_empty() {
+        progress!(
+            "● Applying the plan in {}: {} pending",
+            path.display(),
+            plan.pending_count()
+        );
+        return Ok(selection);
+    }
+    let drift: String = drift.iter().map(|line| format!("\n  {}", line)).collect();
+    if !allow_drift {
+        return Err(Failure {
+            code: dhd::exit_code::PLAN_DRIFTED,
+            message: format!(
+                "The plan in {} no longer matches:{}\nRun `dhd plan` again to review the \
+                 changes, or pass --allow-drift to apply what the modules declare now",
+                path.display(),
+                drift
+            ),
+        });
+    }
+    eprintln!(
+        "Warning: the plan in {} no longer matches, applying anyway:{}",
+        path.display(),
+        drift
+    );
+    Ok(selection)
+}
+
+/// Report which actions match the system, grouped by module, and return the
+/// number that have drifted
+fn status_modules(selection: SelectionArgs, verbose: bool) -> Result<usize, Failure> {
+    use dhd::{AtomStatus, ExecutionEngine};
+
+    let resolved_modules = select_modules(&selection).map_err(Failure::config)?;
+    if resolved_modules.is_empty() {
+        return Ok(0);
+    }
+
+    let engine = ExecutionEngine::new(default_concurrency(), true, verbose);
+    let plans = engine
+        .plan(resolved_modules)
+        .map_err(|e| format!("Checking status failed: {}", e))?;
+
+    let mut in_sync = 0;
+    let mut drifted = 0;
+    let mut unknown = 0;
+    let mut skipped = 0;
+
+    for plan in &plans {
+        if let Some(reason) = &plan.skipped {
+            skipped += 1;
+            println!("\n● {} - skipped ({})", plan.name, reason);
+            continue;
+        }
+
+        let module_drift = plan.pending_count();
+        if module_drift == 0 {
+            println!("\n● {} - in sync", plan.name);
+        } else {
+            println!("\n● {} - {} drifted", plan.name, module_drift);
+        }
+        for atom in &plan.atoms {
+            let marker = match atom.status {
+                AtomStatus::Satisfied => {
+                    in_sync += 1;
+                    "✓ in sync "
+                }
+                AtomStatus::Pending => {
+                    drifted += 1;
+                    "✗ drifted "
+                }
+                AtomStatus::Unchecked => {
+                    unknown += 1;
+                    "? unknown "
+                }
+            };
+            println!("  {} {}", marker, atom.description);
+        }
+    }
+
+    println!(
+        "\n{} in sync, {} drifted, {} unknown (no idempotency check), {} module(s) skipped",
+        in_sync, drifted, unknown, skipped
+    );
+
+    Ok(drifted)
+}
+
+/// Report which files DHD wrote have changed since, and return their number
+fn verify_files() -> Result<usize, String> {
+    use dhd::ActionStatus::{Applied, Failed, Skipped};
+    use dhd::color::paint;
+    use dhd::verify::{Integrity, written_files};
+
+    let state = dhd::state::State::load(&dhd::state::state_dir())?;
+    let files = written_files(&state);
+    if files.is_empty() {
+        println!("ℹ️  No files written by DHD on record");
+        return Ok(0);
+    }
+
+    println!(
+        "● Verifying {} file{} written by DHD",
+        files.len(),
+        if files.len() == 1 { "" } else { "s" }
+    );
+    let mut tampered = 0;
+    let mut unreadable = 0;
+    for file in &files {
+        let path = file.path.display();
+        let line = match file.verify() {
+            Integrity::Intact => paint(Applied, &format!("✅ {}", path)),
+            Integrity::Modified => {
+                tampered += 1;
+                paint(Failed, &format!("❌ {} changed since DHD wrote it", path))
+            }
+            Integrity::Missing => {
+                tampered += 1;
+                paint(Failed, &format!("❌ {} was removed", path))
+            }
+            Integrity::Unreadable(e) => {
+                unreadable += 1;
+                paint(Skipped, &format!("⚠️  {} can't be read: {}", path, e))
+            }
+        };
+        match &file.module {
+            Some(module) => println!("  {} ({})", line, module),
+            None => println!("  {}", line),
+        }
+    }
+
+    println!(
+        "\n{} intact, {} changed, {} unreadable",
+        files.len() - tampered - unreadable,
+        tampered,
+        unreadable
+    );
+    Ok(tampered)
+}
+
+/// Report which actions match the system as far as can be told without side
+/// effects, and return the number that have drifted
+fn audit_modules(selection: SelectionArgs, verbose: bool) -> Result<usize, Failure> {
+    use dhd::{AtomStatus, ExecutionEngine};
+
+    let resolved_modules = select_modules(&selection).map_err(Failure::config)?;
+    if resolved_modules.is_empty() {
+        return Ok(0);
+    }
+
+    let engine = ExecutionEngine::new(default_concurrency(), true, verbose);
+    let plans = engine.audit(resolved_modules);
+
+    let mut in_sync = 0;
+    let mut drifted = 0;
+    let mut not_audited = 0;
+    let mut skipped = 0;
+
+    for plan in &plans {
+        if let Some(reason) = &plan.skipped {
+            skipped += 1;
+            println!("\n● {} - skipped ({})", plan.name, reason);
+            continue;
+        }
+
+        let module_drift = plan.pending_count();
+        if module_drift == 0 {
+            println!("\n● {} - in sync", plan.name);
+        } else {
+            println!("\n● {} - {} drifted", plan.name, module_drift);
+        }
+        for atom in &plan.atoms {
+            let marker = match atom.status {
+                AtomStatus::Satisfied => {
+                    in_sync += 1;
+                    "✓ in sync    "
+                }
+                AtomStatus::Pending => {
+                    drifted += 1;
+                    "✗ drifted    "
+                }
+                AtomStatus::Unchecked => {
+                    not_audited += 1;
+                    "? not audited"
+                }
+            };
+            println!("  {} {}", marker, atom.description);
+        }
+    }
+
+    println!(
+        "\n{} in sync, {} drifted, {} not audited (no side-effect-free check), {} module(s) skipped",
+        in_sync, drifted, not_audited, skipped
+    );
+
+    Ok(drifted)
+}
+
+/// Print a unified diff for every file that `apply` would change
+fn diff_modules(selection: SelectionArgs, verbose: bool) -> Result<(), String> {
+    use dhd::ExecutionEngine;
+
+    let resolved_modules = select_modules(&selection)?;
+    if resolved_modules.is_empty() {
+        return Ok(());
+    }
+
+    let engine = ExecutionEngine::new(default_concurrency(), true, verbose);
+    let diffs = engine
+        .diff(resolved_modules)
+        .map_err(|e| format!("Diff failed: {}", e))?;
+
+    let mut changed = 0;
+    for diff in &diffs {
+        if let Some(reason) = &diff.skipped {
+            if verbose {
+                println!("\n● {} - skipped ({})", diff.name, reason);
+            }
+            continue;
+        }
+
+        if diff.changes.is_empty() {
+            continue;
+        }
+
+        println!("\n● {}", diff.name);
+        for change in &diff.changes {
+            if let Some(rendered) = change.render() {
+                changed += 1;
+                print!("{}", dhd::secrets::mask(&rendered));
+            }
+        }
+    }
+
+    if changed == 0 {
+        println!("\nNo file changes");
+    } else {
+        println!("\n{} file(s) would change", changed);
+    }
+
+    Ok(())
+}
+
+/// Print how `name` would be applied, after the modules it depends on
+fn explain_module(name: &str) -> Result<(), String> {
+    PROGRESS_TO_STDERR.store(true, Ordering::Relaxed);
+    let selection = SelectionArgs {
+        module: vec![name.to_string()],
+        ..Default::default()
+    };
+    let modules = resolve_selection(&selection)?;
+    let Some(module) = modules.iter().find(|m| m.definition.name == name) else {
+        return Err(format!("Module '{}' not found", name));
+    };
+
+    let current_dir =
+        std::env::current_dir().map_err(|e| format!("Failed to get current directory: {}", e))?;
+    let order: Vec<String> = modules.iter().map(|m| m.definition.name.clone()).collect();
+    print!("{}", dhd::explain::render(module, &order, &current_dir));
+    Ok(())
+}
+
+/// Schedule a reboot in `delay` minutes for `--reboot-if-required`,
+/// through sudo unless DHD is root
+fn schedule_reboot(delay: u32, modules: &[String]) -> Result<(), String> {
+    let message = format!("Reboot required by {}", modules.join(", "));
+    let status = dhd::privilege::root_command("shutdown")
+        .map_err(|e| format!("Can't schedule a reboot: {}", e))?
+        .arg("-r")
+        .arg(format!("+{}", delay))
+        .arg(&message)
+        .status()
+        .map_err(|e| format!("Failed to run shutdown: {}", e))?;
+    if !status.success() {
+        return Err(format!("shutdown -r +{} failed with {}", delay, status));
+    }
+    println!(
+        "🔁 Rebooting in {} minute{}",
+        delay,
+        if delay == 1 { "" } else { "s" }
+    );
+    Ok(())
+}
+
+/// Apply for `user`, running dhd again through sudo unless it's root
+fn use_target_user(user: &str) -> Result<(), String> {
+    if dhd::privilege::is_root() {
+        return dhd::target_user::set_target(user)
+            .map_err(|e| format!("--target-user {}: {}", user, e));
+    }
+    dhd::privilege::ensure_root()
+        .map_err(|e| format!("--target-user {}: needs root: {}", user, e))?;
+    let exe =
+        std::env::current_exe().map_err(|e| format!("Failed to find the dhd executable: {}", e))?;
+    let status = std::process::Command::new("sudo")
+        .arg("--")
+        .arg(exe)
+        .args(std::env::args_os().skip(1))
+        .status()
+        .map_err(|e| format!("Failed to run dhd through sudo: {}", e))?;
+    std::process::exit(status.code().unwrap_or(1));
+}
+
+fn main() {
+    // Usage errors exit like invalid modules, not like pending changes
+    let mut cli = Cli::try_parse().unwrap_or_else(|e| {
+        if !e.use_stderr() {
+            e.exit();
+        }
+        let _ = e.print();
+        std::process::exit(dhd::exit_code::CONFIG_ERROR);
+    });
+    if let Some(path) = &cli.os_release_file {
+        // Before anything detects the platform, and before any thread starts
+        unsafe { std::env::set_var(dhd::platform::OS_RELEASE_VAR, path) };
+    }
+    if let Some(dir) = &cli.dhd_home {
+        unsafe { std::env::set_var(dhd::platform::HOME_VAR, dir) };
+    }
+    if let Some(user) = &cli.target_user {
+        if let Err(e) = use_target_user(user) {
+            eprintln!("Error: {}", e);
+            std::process::exit(dhd::exit_code::CONFIG_ERROR);
+        }
+    }
+    MODULE_ROOTS.set(cli.modules_path.clone()).ok();
+    HOST.set(cli.host.clone()).ok();
+    dhd::logging::init(cli.logging.level());
+    dhd::color::set_choice(cli.color());
+    dhd::atoms::package::lock::set_timeout(std::time::Duration::from_secs(cli.lock_timeout));
+    dhd::atoms::network::set_jobs(cli.net_jobs.get());
+    dhd::deprecation::set_strict(cli.strict);
+    dhd::atoms::network::set_offline(cli.no_network);
+    // --set wins over --set-json for the same name
+    let json = cli.set_json.iter().flat_map(|json| json.0.iter().cloned());
+    dhd::overrides::set(json.chain(cli.set.iter().cloned()).collect());
+    if let Some(selection) = cli.command.selection_mut() {
+        if let Err(e) = selection.read_stdin() {
+            eprintln!("Error: {}", e);
+            std::process::exit(dhd::exit_code::CONFIG_ERROR);
+        }
+    }
+    let verbose = cli.logging.verbose > 0;
+
+    match cli.command {
+        Commands::Init {
+            dir,
+            distro,
+            shell,
+            force,
+        } => {
+            if let Err(e) = init_modules(&dir, distro, shell, force) {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        }
+        Commands::Generate { generate_command } => match generate_command {
+            GenerateCommands::Types => {
+                if let Err(e) = generate_types() {
+                    eprintln!("Error: {}", e);
+                    std::process::exit(1);
+                }
+            }
+        },
+        Commands::Context { context_command } => match context_command {
+            ContextCommands::List => {
+                if let Err(e) = list_contexts() {
+                    eprintln!("Error: {}", e);
+                    std::process::exit(1);
+                }
+            }
+        },
+        Commands::List {
+            tag,
+            all_tags,
+            exclude_tags,
+            filter,
+            output,
+            json,
+        } => {
+            let filter = dhd::ModuleFilter {
+                modules: Vec::new(),
+                tags: tag,
+                all_tags,
+                exclude_tags,
+                expression: filter,
+            };
+            let output = if json { OutputFormat::Json } else { output };
+            if let Err(e) = list_modules(filter, output) {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        }
+        Commands::Tags => {
+            if let Err(e) = list_tags() {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        }
+        Commands::Check => match check_modules() {
+            Ok(0) => {}
+            Ok(_) => std::process::exit(dhd::exit_code::CONFIG_ERROR),
+            Err(e) => {
+                eprintln!("Error: {}", e);
+                std::process::exit(dhd::exit_code::CONFIG_ERROR);
+            }
+        },
+        Commands::Fmt { check } => match fmt_modules(check) {
+            Ok(0) => {}
+            Ok(_) => std::process::exit(dhd::exit_code::CHANGES),
+            Err(e) => {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        },
+        Commands::Doctor => match doctor() {
+            Ok(0) => {}
+            Ok(_) => std::process::exit(1),
+            Err(e) => {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        },
+        Commands::Update => {
+            if let Err(e) = update_imports() {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        }
+        Commands::Version => println!("dhd {}", dhd::version::LONG),
+        Commands::Schema => match serde_json::to_string_pretty(&dhd::schema::module_schema()) {
+            Ok(schema) => println!("{}", schema),
+            Err(e) => {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        },
+        Commands::Plan {
+            selection,
+            pending_exit_code,
+            output,
+        } => {
+            let result = match output {
+                OutputFormat::Text => plan_modules(selection, verbose),
+                OutputFormat::Json => plan_modules_json(selection, verbose),
+            };
+            match result {
+                Ok(0) => exit_with(dhd::exit_code::SUCCESS),
+                Ok(_) => exit_with(pending_exit_code),
+                Err(e) => {
+                    eprintln!("Error: {}", e);
+                    std::process::exit(e.code);
+                }
+            }
+        }
+        Commands::Status {
+            selection,
+            drift_exit_code,
+        } => match status_modules(selection, verbose) {
+            Ok(0) => exit_with(dhd::exit_code::SUCCESS),
+            Ok(_) => exit_with(drift_exit_code),
+            Err(e) => {
+                eprintln!("Error: {}", e);
+                std::process::exit(e.code);
+            }
+        },
+        Commands::Audit {
+            selection,
+            drift_exit_code,
+        } => match audit_modules(selection, verbose) {
+            Ok(0) => exit_with(dhd::exit_code::SUCCESS),
+            Ok(_) => exit_with(drift_exit_code),
+            Err(e) => {
+                eprintln!("Error: {}", e);
+                std::process::exit(e.code);
+            }
+        },
+        Commands::Verify { tampered_exit_code } => match verify_files() {
+            Ok(0) => exit_with(dhd::exit_code::SUCCESS),
+            Ok(_) => exit_with(tampered_exit_code),
+            Err(e) => {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        },
+        Commands::Diff { selection } => {
+            if let Err(e) = diff_modules(selection, verbose) {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+            exit_with(dhd::exit_code::SUCCESS);
+        }
+        Commands::Explain { module } => {
+            if let Err(e) = explain_module(&module) {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        }
+        Commands::Apply {
+            dry_run,
+            selection,
+            jobs,
+            output,
+            timings,
+            profile,
+            yes,
+            no_backup,
+            only_changed,
+            limit,
+            incremental,
+            force,
+            keep_going,
+            diff,
+            quiet,
+            watch,
+            since_commit,
+            plan_file,
+            allow_drift,
+            report_file,
+            report_format,
+            changed_exit_code,
+            reboot_if_required,
+            reboot_delay,
+            prune,
+            prune_packages,
+        } => {
+            // Flags win over dhd.config.ts, which wins over the built-in defaults
+            let settings =
+                match module_roots().and_then(|roots| dhd::imports::load_settings(&roots)) {
+                    Ok(settings) => settings,
+                    Err(e) => {
+                        eprintln!("Error: {}", e);
+                        std::process::exit(dhd::exit_code::CONFIG_ERROR);
+                    }
+                };
+            let jobs = jobs
+                .map(|jobs| jobs.get())
+                .or(settings.jobs.map(|jobs| jobs as usize))
+                .unwrap_or_else(default_concurrency);
+            let no_backup = no_backup || settings.backup == Some(false);
+            dhd::history::set_limit(
+                settings
+                    .history_limit
+                    .map_or(dhd::history::DEFAULT_LIMIT, |limit| limit as usize),
+            );
+            let incremental = (incremental || settings.incremental == Some(true)) && !force;
+            let report = report_file.map(|path| ReportTarget {
+                path,
+                format: report_format,
+            });
+            if profile.is_some() {
+                dhd::profile::start();
+            }
+            QUIET.store(quiet, Ordering::Relaxed);
+            if output == OutputFormat::Json && (since_commit.is_some() || plan_file.is_some()) {
+                PROGRESS_TO_STDERR.store(true, Ordering::Relaxed);
+            }
+            let selection = match (&since_commit, &plan_file) {
+                (Some(commit), _) => match select_changed(selection, commit) {
+                    Ok(selection) => selection,
+                    Err(e) => {
+                        eprintln!("Error: {}", e);
+                        std::process::exit(dhd::exit_code::CONFIG_ERROR);
+                    }
+                },
+                (None, Some(path)) => match select_planned(path, allow_drift, verbose) {
+                    Ok(selection) => selection,
+                    Err(e) => {
+                        eprintln!("Error: {}", e);
+                        std::process::exit(e.code);
+                    }
+                },
+                (None, None) => selection,
+            };
+            let result = match output {
+                OutputFormat::Text if watch => watch_modules(selection, |selection| {
+                    apply_modules(
+                        dry_run,
+                        selection,
+                        jobs,
+                        verbose,
+                        timings,
+                        yes,
+                        no_backup,
+                        only_changed,
+                        limit,
+                        incremental,
+                        keep_going,
+                        diff,
+                        report.as_ref(),
+                    )
+                }),
+                OutputFormat::Text => apply_modules(
+                    dry_run,
+                    selection,
+                    jobs,
+                    verbose,
+                    timings,
+                    yes,
+                    no_backup,
+                    only_changed,
+                    limit,
+                    incremental,
+                    keep_going,
+                    diff,
+                    report.as_ref(),
+                ),
+                OutputFormat::Json => apply_modules_json(
+                    dry_run,
+                    selection,
+                    jobs,
+                    yes,
+                    no_backup,
+                    only_changed,
+                    incremental,
+                    keep_going,
+                    report.as_ref(),
+                ),
+            };
+            // Only a successful apply leaves the system as declared
+            let result = match result {
+                Ok(changed) if prune => prune_undeclared(prune_packages, dry_run, yes)
+                    .map(|pruned| changed + pruned)
+                    .map_err(Failure::from),
+                result => result,
+            };
+            if let Some(path) = &profile {
+                if let Err(e) = dhd::profile::write(path) {
+                    eprintln!("Warning: {}", e);
+                }
+            }
+            let reboot = REBOOT_REQUIRED
+                .lock()
+                .unwrap_or_else(|e| e.into_inner())
+                .clone();
+            match result {
+                Ok(_) if !reboot.is_empty() => {
+                    if reboot_if_required {
+                        if let Err(e) = schedule_reboot(reboot_delay, &reboot) {
+                            eprintln!("Error: {}", e);
+                            std::process::exit(dhd::exit_code::FAILURE);
+                        }
+                    }
+                    exit_with(dhd::exit_code::REBOOT_REQUIRED)
+                }
+                Ok(0) => exit_with(dhd::exit_code::SUCCESS),
+                Ok(_) => exit_with(changed_exit_code),
+                Err(e) => {
+                    eprintln!("Error: {}", e);
+                    std::process::exit(e.code);
+                }
+            }
+        }
+        Commands::History { last, json } => {
+            if let Err(e) = show_history(last, json) {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        }
+        Commands::Rollback { packages } => {
+            if let Err(e) = rollback_last_apply(packages) {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        }
+        Commands::Uninstall {
+            module,
+            packages,
+            dry_run,
+            yes,
+        } => {
+            if let Err(e) = uninstall_modules(&module, packages, dry_run, yes) {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        }
+        Commands::Unwire { shells, dry_run } => {
+            if let Err(e) = unwire_shells(&shells, dry_run) {
+                eprintln!("Error: {}", e);
+                std::process::exit(1);
+            }
+        }
+        Commands::Completions { shell } => {
+            print!("{}", completion_script(shell));
+        }
+        Commands::Complete { candidates } => {
+            if let Err(e) = print_completion_candidates(candidates) {
                 eprintln!("Error: {}", e);
                 std::process::exit(1);
             }
@@ -0,0 +1,472 @@
+//! `--filter` expressions, selecting modules by their metadata and host facts
+//!
+//! ```text
+//! tag == "desktop" and os == "arch"
+//! not ("work" in tags or name == "slack")
+//! distro in ["fedora", "arch"] and host.hostname != "build"
+//! ```
+//!
+//! Fields are `name`, `tags` (or `tag`), `os`, `distro`, `family`, `arch`,
+//! `hostname` and the dotted host facts of `dhd context list`, like
+//! `os.version`. `tags` and `os` hold several values: the module's tags, and
+//! the OS (`linux`) along with the distribution (`arch`). `==` and `in` are
+//! true when any value on one side is among the values on the other, so
+//! `tag == "desktop"` asks whether the module has that tag.
+
+use super::ModuleDefinition;
+use std::collections::HashMap;
+
+/// A parsed `--filter` expression
+#[derive(Debug, Clone, PartialEq)]
+pub enum FilterExpression {
+    And(Box<FilterExpression>, Box<FilterExpression>),
+    Or(Box<FilterExpression>, Box<FilterExpression>),
+    Not(Box<FilterExpression>),
+    /// `==` and `in`, which mean the same
+    Equals(Operand, Operand),
+}
+
+/// One side of a comparison
+#[derive(Debug, Clone, PartialEq)]
+pub enum Operand {
+    Name,
+    Tags,
+    Os,
+    /// A host fact by its dotted name, e.g. `os.distro`
+    Fact(String),
+    Values(Vec<String>),
+}
+
+impl FilterExpression {
+    /// Parse `input`, failing with the column of the offending token
+    pub fn parse(input: &str) -> Result<Self, String> {
+        let tokens =
+            tokenize(input).map_err(|(column, message)| error(input, column, 1, &message))?;
+        let mut parser = Parser {
+            input,
+            tokens,
+            position: 0,
+        };
+        let expression = parser.or()?;
+        match parser.peek() {
+            None => Ok(expression),
+            Some(token) => Err(parser.error_at(token, &format!("unexpected {}", token.kind))),
+        }
+    }
+
+    /// Whether `module` is selected on this host
+    pub fn matches(&self, module: &ModuleDefinition) -> bool {
+        self.matches_with(module, &crate::system_info::fact_variables())
+    }
+
+    /// Whether `module` is selected on a host with `facts`
+    pub fn matches_with(&self, module: &ModuleDefinition, facts: &HashMap<String, String>) -> bool {
+        match self {
+            FilterExpression::And(left, right) => {
+                left.matches_with(module, facts) && right.matches_with(module, facts)
+            }
+            FilterExpression::Or(left, right) => {
+                left.matches_with(module, facts) || right.matches_with(module, facts)
+            }
+            FilterExpression::Not(inner) => !inner.matches_with(module, facts),
+            FilterExpression::Equals(left, right) => {
+                let right = right.values(module, facts);
+                left.values(module, facts)
+                    .iter()
+                    .any(|value| right.contains(value))
+            }
+        }
+    }
+}
+
+impl Operand {
+    fn values(&self, module: &ModuleDefinition, facts: &HashMap<String, String>) -> Vec<String> {
+        let fact = |name: &str| facts.get(name).cloned().into_iter().collect();
+        match self {
+            Operand::Name => vec![module.name.clone()],
+            Operand::Tags => module.tags.clone(),
+            Operand::Os => ["host.os", "os.distro"]
+                .iter()
+                .filter_map(|name| facts.get(*name).cloned())
+                .filter(|value| !value.is_empty())
+                .collect(),
+            Operand::Fact(name) => fact(name),
+            Operand::Values(values) => values.clone(),
+        }
+    }
+
+    /// The operand a field name stands for, if there is such a field
+    fn field(name: &str) -> Option<Self> {
+        let fact = match name {
+            "name" => return Some(Operand::Name),
+            "tag" | "tags" => return Some(Operand::Tags),
+            "os" => return Some(Operand::Os),
+            "distro" => "os.distro",
+            "family" => "os.family",
+            "arch" => "host.arch",
+            "hostname" => "host.hostname",
+            name => name,
+        };
+        crate::system_info::fact_names()
+            .contains(&fact.to_string())
+            .then(|| Operand::Fact(fact.to_string()))
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum TokenKind {
+    Word(String),
+    Text(String),
+    Equals,
+    NotEquals,
+    Open,
+    Close,
+    OpenList,
+    CloseList,
+    Comma,
+}
+
+impl std::fmt::Display for TokenKind {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        match self {
+            TokenKind::Word(word) => write!(f, "'{}'", word),
+            TokenKind::Text(text) => write!(f, "\"{}\"", text),
+            TokenKind::Equals => write!(f, "'=='"),
+            TokenKind::NotEquals => write!(f, "'!='"),
+            TokenKind::Open => write!(f, "'('"),
+            TokenKind::Close => write!(f, "')'"),
+            TokenKind::OpenList => write!(f, "'['"),
+            TokenKind::CloseList => write!(f, "']'"),
+            TokenKind::Comma => write!(f, "','"),
+        }
+    }
+}
+
+#[derive(Debug, Clone)]
+struct Token {
+    kind: TokenKind,
+    /// Column of the first character, counting from 1
+    column: usize,
+    width: usize,
+}
+
+/// Split `input` into tokens, or fail with the column and a message
+fn tokenize(input: &str) -> Result<Vec<Token>, (usize, String)> {
+    let chars: Vec<char> = input.chars().collect();
+    let mut tokens = Vec::new();
+    let mut i = 0;
+    while i < chars.len() {
+        let start = i;
+        let c = chars[i];
+        let kind = match c {
+            c if c.is_whitespace() => {
+                i += 1;
+                continue;
+            }
+            '(' => TokenKind::Open,
+            ')' => TokenKind::Close,
+            '[' => TokenKind::OpenList,
+            ']' => TokenKind::CloseList,
+            ',' => TokenKind::Comma,
+            '=' | '!' if chars.get(i + 1) == Some(&'=') => {
+                i += 1;
+                if c == '=' {
+                    TokenKind::Equals
+                } else {
+                    TokenKind::NotEquals
+                }
+            }
+            '"' | '\'' => {
+                let mut text = String::new();
+                loop {
+                    i += 1;
+                    match chars.get(i) {
+                        None => return Err((start + 1, "unterminated string".to_string())),
+                        Some(&end) if end == c => break,
+                        Some('\\') if i + 1 < chars.len() => {
+                            i += 1;
+                            text.push(chars[i]);
+                        }
+                        Some(&other) => text.push(other),
+                    }
+                }
+                TokenKind::Text(text)
+            }
+            c if c.is_alphanumeric() || c == '_' => {
+                while chars
+                    .get(i + 1)
+                    .is_some_and(|c| c.is_alphanumeric() || matches!(c, '_' | '.' | '-'))
+                {
+                    i += 1;
+                }
+                TokenKind::Word(chars[start..=i].iter().collect())
+            }
+            other => return Err((start + 1, format!("unexpected '{}'", other))),
+        };
+        i += 1;
+        tokens.push(Token {
+            kind,
+            column: start + 1,
+            width: i - start,
+        });
+    }
+    Ok(tokens)
+}
+
+/// The error message for the token at `column`, pointing at it under the input
+fn error(input: &str, column: usize, width: usize, message: &str) -> String {
+    format!(
+        "Invalid filter at column {}: {}\n  {}\n  {}{}",
+        column,
+        message,
+        input,
+        " ".repeat(column - 1),
+        "^".repeat(width.max(1))
+    )
+}
+
+struct Parser<'a> {
+    input: &'a str,
+    tokens: Vec<Token>,
+    position: usize,
+}
+
+impl Parser<'_> {
+    fn peek(&self) -> Option<&Token> {
+        self.tokens.get(self.position)
+    }
+
+    fn peek_kind(&self) -> Option<&TokenKind> {
+        self.peek().map(|token| &token.kind)
+    }
+
+    fn next(&mut self) -> Option<Token> {
+        let token = self.tokens.get(self.position).cloned();
+        self.position += 1;
+        token
+    }
+
+    /// Whether the next token is the keyword `word`, consuming it if so
+    fn keyword(&mut self, word: &str) -> bool {
+        let found = matches!(self.peek_kind(), Some(TokenKind::Word(w)) if w == word);
+        if found {
+            self.position += 1;
+        }
+        found
+    }
+
+    fn error_at(&self, token: &Token, message: &str) -> String {
+        error(self.input, token.column, token.width, message)
+    }
+
+    /// An error about the next token, or the end of the input
+    fn expected(&self, what: &str) -> String {
+        match self.peek() {
+            Some(token) => {
+                self.error_at(token, &format!("expected {}, found {}", what, token.kind))
+            }
+            None => error(
+                self.input,
+                self.input.chars().count() + 1,
+                1,
+                &format!("expected {}, found the end", what),
+            ),
+        }
+    }
+
+    fn or(&mut self) -> Result<FilterExpression, String> {
+        let mut expression = self.and()?;
+        while self.keyword("or") {
+            expression = FilterExpression::Or(Box::new(expression), Box::new(self.and()?));
+        }
+        Ok(expression)
+    }
+
+    fn and(&mut self) -> Result<FilterExpression, String> {
+        let mut expression = self.not()?;
+        while self.keyword("and") {
+            expression = FilterExpression::And(Box::new(expression), Box::new(self.not()?));
+        }
+        Ok(expression)
+    }
+
+    fn not(&mut self) -> Result<FilterExpression, String> {
+        if self.keyword("not") {
+            return Ok(FilterExpression::Not(Box::new(self.not()?)));
+        }
+        if self.peek_kind() == Some(&TokenKind::Open) {
+            self.position += 1;
+            let expression = self.or()?;
+            if self.peek_kind() != Some(&TokenKind::Close) {
+                return Err(self.expected("')'"));
+            }
+            self.position += 1;
+            return Ok(expression);
+        }
+        self.comparison()
+    }
+
+    fn comparison(&mut self) -> Result<FilterExpression, String> {
+        let left = self.operand()?;
+        let negated = match self.peek_kind() {
+            Some(TokenKind::Equals) => false,
+            Some(TokenKind::NotEquals) => true,
+            Some(TokenKind::Word(word)) if word == "in" => false,
+            Some(TokenKind::Word(word)) if word == "not" => {
+                self.position += 1;
+                if !matches!(self.peek_kind(), Some(TokenKind::Word(w)) if w == "in") {
+                    return Err(self.expected("'in' after 'not'"));
+                }
+                true
+            }
+            _ => return Err(self.expected("'==', '!=' or 'in'")),
+        };
+        self.position += 1;
+        let comparison = FilterExpression::Equals(left, self.operand()?);
+        Ok(if negated {
+            FilterExpression::Not(Box::new(comparison))
+        } else {
+            comparison
+        })
+    }
+
+    fn operand(&mut self) -> Result<Operand, String> {
+        let Some(token) = self.next() else {
+            return Err(self.expected("a field, string or list"));
+        };
+        match &token.kind {
+            TokenKind::Text(text) => Ok(Operand::Values(vec![text.clone()])),
+            TokenKind::Word(word) if !matches!(word.as_str(), "and" | "or" | "not" | "in") => {
+                Operand::field(word).ok_or_else(|| {
+                    self.error_at(
+                        &token,
+                        &format!(
+                            "unknown field '{}'; fields are name, tags, os, distro, family, arch, hostname and the facts of `dhd context list`",
+                            word
+                        ),
+                    )
+                })
+            }
+            TokenKind::OpenList => {
+                let mut values = Vec::new();
+                loop {
+                    match self.next().map(|token| token.kind) {
+                        Some(TokenKind::Text(text)) => values.push(text),
+                        Some(TokenKind::CloseList) if values.is_empty() => break,
+                        _ => {
+                            self.position -= 1;
+                            return Err(self.expected("a string"));
+                        }
+                    }
+                    match self.next().map(|token| token.kind) {
+                        Some(TokenKind::Comma) => {}
+                        Some(TokenKind::CloseList) => break,
+                        _ => {
+                            self.position -= 1;
+                            return Err(self.expected("',' or ']'"));
+                        }
+                    }
+                }
+                Ok(Operand::Values(values))
+            }
+            _ => {
+                self.position -= 1;
+                Err(self.expected("a field, string or list"))
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn module(name: &str, tags: &[&str]) -> ModuleDefinition {
+        super::super::define_module(name.to_string())
+            .tags(tags.iter().map(|tag| tag.to_string()).collect())
+            .actions(vec![])
+    }
+
+    fn arch_host() -> HashMap<String, String> {
+        HashMap::from([
+            ("host.os".to_string(), "linux".to_string()),
+            ("host.hostname".to_string(), "desk".to_string()),
+            ("os.distro".to_string(), "arch".to_string()),
+            ("os.family".to_string(), "arch".to_string()),
+        ])
+    }
+
+    fn selects(filter: &str, module: &ModuleDefinition) -> bool {
+        FilterExpression::parse(filter)
+            .unwrap()
+            .matches_with(module, &arch_host())
+    }
+
+    #[test]
+    fn test_filter_on_tags_and_host() {
+        let niri = module("niri", &["desktop", "wayland"]);
+        let server = module("caddy", &["server"]);
+
+        let filter = r#"tag == "desktop" and os == "arch""#;
+        assert!(selects(filter, &niri));
+        assert!(!selects(filter, &server));
+        assert!(selects(r#"os == "linux" and "server" in tags"#, &server));
+        assert!(selects(
+            r#"not ("desktop" in tags or name == "git")"#,
+            &server
+        ));
+        assert!(!selects(r#"tags != "wayland""#, &niri));
+        assert!(selects(
+            r#"name in ["niri", 'git'] or distro == "fedora""#,
+            &niri
+        ));
+        assert!(selects(
+            r#"tags not in ["work"] and host.hostname == "desk""#,
+            &niri
+        ));
+    }
+
+    #[test]
+    fn test_and_binds_tighter_than_or() {
+        let git = module("git", &["cli"]);
+        assert!(selects(
+            r#"name == "git" or tag == "x" and tag == "y""#,
+            &git
+        ));
+        assert!(!selects(
+            r#"(name == "git" or tag == "x") and tag == "y""#,
+            &git
+        ));
+    }
+
+    #[test]
+    fn test_invalid_filters_point_at_the_token() {
+        let err = FilterExpression::parse(r#"tag == "desktop" and oss == "arch""#).unwrap_err();
+        assert!(
+            err.starts_with("Invalid filter at column 22: unknown field 'oss'"),
+            "{}",
+            err
+        );
+        assert!(err.ends_with("\n                       ^^^"), "{}", err);
+
+        let err = FilterExpression::parse(r#"tag == and"#).unwrap_err();
+        assert!(
+            err.contains("column 8: expected a field, string or list, found 'and'"),
+            "{}",
+            err
+        );
+
+        let err = FilterExpression::parse(r#"(tag == "a""#).unwrap_err();
+        assert!(
+            err.contains("column 12: expected ')', found the end"),
+            "{}",
+            err
+        );
+
+        let err = FilterExpression::parse(r#"tag = "a""#).unwrap_err();
+        assert!(err.contains("column 5: unexpected '='"), "{}", err);
+
+        let err = FilterExpression::parse(r#"name == "git" tag == "a""#).unwrap_err();
+        assert!(err.contains("column 15: unexpected 'tag'"), "{}", err);
+    }
+}
@@ -1,5 +1,9 @@
 use crate::actions::{Action, ActionType, Condition};
 use dhd_macros::{typescript_fn, typescript_impl, typescript_type};
+use std::collections::HashMap;
+
+pub mod filter;
+pub use filter::FilterExpression;
 
 #[typescript_type]
 pub struct ModuleDefinition {
@@ -8,7 +12,164 @@ pub struct ModuleDefinition {
     pub tags: Vec<String>,
     pub dependencies: Vec<String>,
     pub when: Option<Condition>,  // Module-level condition
+    /// Disabled modules are skipped and reported as "disabled"
+    pub enabled: bool,
     pub actions: Vec<ActionType>,
+    /// Runs before the module's actions; if it fails, they don't run
+    pub pre_apply: Option<Hook>,
+    /// Runs after the module's actions have all succeeded
+    pub post_apply: Option<Hook>,
+    /// Run at the end of the apply when an action notifies them
+    pub handlers: Vec<Handler>,
+    /// Variables of every template in the module, over those of `dhd.config.ts`
+    pub variables: HashMap<String, String>,
+    /// The variables the module's templates expect, checked when it loads
+    pub variable_schema: HashMap<String, VariableSpec>,
+}
+
+impl ModuleDefinition {
+    /// Everything wrong with the variables in `scope` according to the
+    /// schema, or with the schema itself, sorted by variable name
+    pub fn check_variables(&self, scope: &HashMap<String, String>) -> Vec<String> {
+        let mut names: Vec<&String> = self.variable_schema.keys().collect();
+        names.sort();
+
+        let mut violations = Vec::new();
+        for name in names {
+            let spec = &self.variable_schema[name];
+            if let Err(e) = spec.kind() {
+                violations.push(format!("variable '{}' {}", name, e));
+                continue;
+            }
+            let default = spec.default.as_deref();
+            if let Some(e) = default.and_then(|value| spec.violation(value)) {
+                violations.push(format!("default of variable '{}' {}", name, e));
+                continue;
+            }
+            match scope.get(name) {
+                Some(value) => {
+                    if let Some(e) = spec.violation(value) {
+                        violations.push(format!("variable '{}' {}", name, e));
+                    }
+                }
+                None if spec.required == Some(true) => {
+                    violations.push(format!("variable '{}' is required but not set", name));
+                }
+                None => {}
+            }
+        }
+        violations
+    }
+}
+
+/// A variable the module's templates expect
+///
+/// * `type` - `"string"`, `"number"`, `"bool"` or `"enum"`
+/// * `required` - Fail to load the module when nothing sets it (default: false)
+/// * `default` - Used when neither the module nor `dhd.config.ts` sets it
+/// * `values` - The values an enum allows
+/// * `sensitive` - Mask the value in everything DHD prints (default: false)
+#[typescript_type]
+pub struct VariableSpec {
+    pub r#type: String,
+    pub required: Option<bool>,
+    pub default: Option<String>,
+    pub values: Option<Vec<String>>,
+    pub sensitive: Option<bool>,
+}
+
+/// What a variable's value has to be
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum VariableKind {
+    String,
+    Number,
+    Bool,
+    Enum,
+}
+
+impl VariableSpec {
+    fn kind(&self) -> Result<VariableKind, String> {
+        match self.r#type.as_str() {
+            "string" => Ok(VariableKind::String),
+            "number" => Ok(VariableKind::Number),
+            "bool" | "boolean" => Ok(VariableKind::Bool),
+            "enum" => match &self.values {
+                Some(values) if !values.is_empty() => Ok(VariableKind::Enum),
+                _ => Err("is an enum without 'values'".to_string()),
+            },
+            other => Err(format!(
+                "has unknown type '{}' (expected string, number, bool or enum)",
+                other
+            )),
+        }
+    }
+
+    /// `value` as errors may show it
+    fn shown<'a>(&self, value: &'a str) -> &'a str {
+        if self.sensitive == Some(true) {
+            crate::secrets::MASK
+        } else {
+            value
+        }
+    }
+
+    /// Why `value` doesn't fit the spec, if it doesn't
+    fn violation(&self, value: &str) -> Option<String> {
+        let shown = self.shown(value);
+        match self.kind().ok()? {
+            VariableKind::String => None,
+            VariableKind::Number => value
+                .parse::<f64>()
+                .is_err()
+                .then(|| format!("must be a number, got '{}'", shown)),
+            VariableKind::Bool => (!matches!(value, "true" | "false"))
+                .then(|| format!("must be true or false, got '{}'", shown)),
+            VariableKind::Enum => {
+                let values = self.values.as_deref().unwrap_or_default();
+                (!values.iter().any(|allowed| allowed == value))
+                    .then(|| format!("must be one of {}, got '{}'", values.join(", "), shown))
+            }
+        }
+    }
+}
+
+/// Named actions that run once at the end of the apply, and only if an
+/// action with a matching `notify` changed something
+#[typescript_type]
+pub struct Handler {
+    pub name: String,
+    pub actions: Vec<ActionType>,
+}
+
+/// A shell command run before or after a module's actions
+///
+/// It runs in the module directory with `DHD_MODULE` and `DHD_MODULE_DIR`
+/// set; post-apply hooks also get `DHD_CHANGED` (`true` or `false`).
+#[typescript_type]
+pub struct Hook {
+    /// Command line passed to the shell
+    pub run: String,
+    /// Shell used for `run` (default: `shell` of dhd.config.ts, or sh)
+    pub shell: Option<String>,
+    /// Environment variables for `run`, added to the inherited ones and
+    /// replacing those of the same name
+    pub env: Option<HashMap<String, String>>,
+    /// Keep going when the command fails instead of failing the module (default: false)
+    pub continue_on_error: Option<bool>,
+    /// Post-apply only: skip the hook when no action changed anything (default: false)
+    pub only_if_changed: Option<bool>,
+}
+
+impl Hook {
+    pub fn new(run: String) -> Self {
+        Self {
+            run,
+            shell: None,
+            env: None,
+            continue_on_error: None,
+            only_if_changed: None,
+        }
+    }
 }
 
 pub struct Module {
@@ -23,6 +184,12 @@ pub struct ModuleBuilder {
     tags: Vec<String>,
     dependencies: Vec<String>,
     when: Option<Condition>,
+    enabled: bool,
+    pre_apply: Option<Hook>,
+    post_apply: Option<Hook>,
+    handlers: Vec<Handler>,
+    variables: HashMap<String, String>,
+    variable_schema: HashMap<String, VariableSpec>,
 }
 
 #[typescript_impl]
@@ -34,6 +201,12 @@ impl ModuleBuilder {
             tags: Vec::new(),
             dependencies: Vec::new(),
             when: None,
+            enabled: true,
+            pre_apply: None,
+            post_apply: None,
+            handlers: Vec::new(),
+            variables: HashMap::new(),
+            variable_schema: HashMap::new(),
         }
     }
 
@@ -63,6 +236,42 @@ impl ModuleBuilder {
         self
     }
 
+    /// Skip the module, without deleting it, unless `enabled`
+    pub fn enabled(mut self, enabled: bool) -> Self {
+        self.enabled = enabled;
+        self
+    }
+
+    pub fn pre_apply(mut self, hook: Hook) -> Self {
+        self.pre_apply = Some(hook);
+        self
+    }
+
+    pub fn post_apply(mut self, hook: Hook) -> Self {
+        self.post_apply = Some(hook);
+        self
+    }
+
+    pub fn handlers(mut self, handlers: HashMap<String, Vec<ActionType>>) -> Self {
+        let mut handlers: Vec<Handler> = handlers
+            .into_iter()
+            .map(|(name, actions)| Handler { name, actions })
+            .collect();
+        handlers.sort_by(|a, b| a.name.cmp(&b.name));
+        self.handlers = handlers;
+        self
+    }
+
+    pub fn variables(mut self, variables: HashMap<String, String>) -> Self {
+        self.variables = variables;
+        self
+    }
+
+    pub fn variable_schema(mut self, schema: HashMap<String, VariableSpec>) -> Self {
+        self.variable_schema = schema;
+        self
+    }
+
     pub fn actions(self, actions: Vec<ActionType>) -> ModuleDefinition {
         ModuleDefinition {
             name: self.name,
@@ -70,11 +279,93 @@ impl ModuleBuilder {
             tags: self.tags,
             dependencies: self.dependencies,
             when: self.when,
+            enabled: self.enabled,
             actions,
+            pre_apply: self.pre_apply,
+            post_apply: self.post_apply,
+            handlers: self.handlers,
+            variables: self.variables,
+            variable_schema: self.variable_schema,
         }
     }
 }
 
+/// Selects modules by name and tag
+///
+/// Modules named explicitly and modules matching the tag filter are both
+/// selected; modules carrying any excluded tag are dropped from either set,
+/// as are modules the filter expression doesn't select.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct ModuleFilter {
+    pub modules: Vec<String>,
+    pub tags: Vec<String>,
+    /// Require all of `tags` instead of any of them
+    pub all_tags: bool,
+    pub exclude_tags: Vec<String>,
+    /// `--filter`, as given and parsed
+    pub expression: Option<(String, FilterExpression)>,
+}
+
+impl ModuleFilter {
+    /// Whether the filter selects every module
+    pub fn is_empty(&self) -> bool {
+        self.modules.is_empty()
+            && self.tags.is_empty()
+            && self.exclude_tags.is_empty()
+            && self.expression.is_none()
+    }
+
+    pub fn matches(&self, module: &ModuleDefinition) -> bool {
+        let has_tag = |tag: &String| module.tags.contains(tag);
+
+        if self.exclude_tags.iter().any(has_tag) {
+            return false;
+        }
+
+        let expression_match = self
+            .expression
+            .as_ref()
+            .is_none_or(|(_, expression)| expression.matches(module));
+        if !expression_match {
+            return false;
+        }
+
+        if self.modules.is_empty() && self.tags.is_empty() {
+            return true;
+        }
+
+        let name_match = self.modules.contains(&module.name);
+        let tag_match = if self.tags.is_empty() {
+            false
+        } else if self.all_tags {
+            self.tags.iter().all(has_tag)
+        } else {
+            self.tags.iter().any(has_tag)
+        };
+
+        name_match || tag_match
+    }
+
+    /// Human-readable summary of the active filters
+    pub fn describe(&self) -> String {
+        let mut filters = Vec::new();
+        if !self.modules.is_empty() {
+            filters.push(format!("modules: {}", self.modules.join(", ")));
+        }
+        if !self.tags.is_empty() {
+            let tag_op = if self.all_tags { "all of" } else { "any of" };
+            filters.push(format!("tags ({}): {}", tag_op, self.tags.join(", ")));
+        }
+        if !self.exclude_tags.is_empty() {
+            filters.push(format!("excluding tags: {}", self.exclude_tags.join(", ")));
+        }
+        if let Some((filter, _)) = &self.expression {
+            filters.push(format!("filter: {}", filter));
+        }
+        filters.join("; ")
+    }
+}
+
 #[typescript_fn]
 pub fn define_module(name: String) -> ModuleBuilder {
     ModuleBuilder::new(name)
@@ -111,6 +402,19 @@ mod tests {
         let action = package_install(PackageInstall {
             names: vec!["vim".to_string()],
             manager: None,
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
         });
 
         let module = define_module("test".to_string())
@@ -160,4 +464,135 @@ mod tests {
         );
     }
 
+    fn tagged(name: &str, tags: &[&str]) -> ModuleDefinition {
+        define_module(name.to_string())
+            .tags(tags.iter().map(|t| t.to_string()).collect())
+            .actions(vec![])
+    }
+
+    #[test]
+    fn test_module_filter_empty_selects_everything() {
+        assert!(ModuleFilter::default().matches(&tagged("any", &[])));
+    }
+
+    #[test]
+    fn test_module_filter_names_and_tags_are_a_union() {
+        let filter = ModuleFilter {
+            modules: vec!["git".to_string()],
+            tags: vec!["desktop".to_string()],
+            ..Default::default()
+        };
+
+        assert!(filter.matches(&tagged("git", &["cli"])));
+        assert!(filter.matches(&tagged("niri", &["desktop"])));
+        assert!(!filter.matches(&tagged("server", &["server"])));
+    }
+
+    #[test]
+    fn test_module_filter_exclude_tags() {
+        let filter = ModuleFilter {
+            tags: vec!["desktop".to_string()],
+            exclude_tags: vec!["work".to_string()],
+            ..Default::default()
+        };
+
+        assert!(filter.matches(&tagged("niri", &["desktop"])));
+        assert!(!filter.matches(&tagged("slack", &["desktop", "work"])));
+
+        let exclude_only = ModuleFilter {
+            exclude_tags: vec!["work".to_string()],
+            ..Default::default()
+        };
+        assert!(exclude_only.matches(&tagged("git", &["cli"])));
+        assert!(!exclude_only.matches(&tagged("slack", &["work"])));
+    }
+
+    #[test]
+    fn test_module_filter_all_tags() {
+        let filter = ModuleFilter {
+            tags: vec!["desktop".to_string(), "work".to_string()],
+            all_tags: true,
+            ..Default::default()
+        };
+
+        assert!(filter.matches(&tagged("slack", &["desktop", "work"])));
+        assert!(!filter.matches(&tagged("niri", &["desktop"])));
+    }
+
+    #[test]
+    fn test_check_variables_reports_every_violation() {
+        let spec = |kind: &str, required: Option<bool>, values: Option<&[&str]>| VariableSpec {
+            r#type: kind.to_string(),
+            required,
+            default: None,
+            values: values.map(|values| values.iter().map(|v| v.to_string()).collect()),
+            sensitive: None,
+        };
+        let mut schema = HashMap::new();
+        schema.insert("email".to_string(), spec("string", Some(true), None));
+        schema.insert("port".to_string(), spec("number", None, None));
+        schema.insert("gpg".to_string(), spec("bool", None, None));
+        schema.insert(
+            "theme".to_string(),
+            spec("enum", None, Some(&["dark", "light"])),
+        );
+        schema.insert("font".to_string(), spec("color", None, None));
+        let module = define_module("git".to_string())
+            .variable_schema(schema)
+            .actions(vec![]);
+
+        let scope: HashMap<String, String> = [("port", "ssh"), ("gpg", "yes"), ("theme", "blue")]
+            .into_iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect();
+        assert_eq!(
+            module.check_variables(&scope),
+            vec![
+                "variable 'email' is required but not set",
+                "variable 'font' has unknown type 'color' (expected string, number, bool or enum)",
+                "variable 'gpg' must be true or false, got 'yes'",
+                "variable 'port' must be a number, got 'ssh'",
+                "variable 'theme' must be one of dark, light, got 'blue'",
+            ]
+        );
+
+        let scope: HashMap<String, String> = [
+            ("email", "user@example.com"),
+            ("port", "22"),
+            ("gpg", "true"),
+            ("theme", "dark"),
+        ]
+        .into_iter()
+        .map(|(k, v)| (k.to_string(), v.to_string()))
+        .collect();
+        // Only the schema's own mistake is left
+        assert_eq!(module.check_variables(&scope).len(), 1);
+    }
+
+    #[test]
+    fn test_check_variables_hides_sensitive_values() {
+        let mut schema = HashMap::new();
+        schema.insert(
+            "port".to_string(),
+            VariableSpec {
+                r#type: "number".to_string(),
+                required: None,
+                default: None,
+                values: None,
+                sensitive: Some(true),
+            },
+        );
+        let module = define_module("vpn".to_string())
+            .variable_schema(schema)
+            .actions(vec![]);
+
+        let scope = HashMap::from([("port".to_string(), "hunter2".to_string())]);
+        assert_eq!(
+            module.check_variables(&scope),
+            vec![format!(
+                "variable 'port' must be a number, got '{}'",
+                crate::secrets::MASK
+            )]
+        );
+    }
 }
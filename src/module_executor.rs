@@ -0,0 +1,1581 @@
+use crate::{
+    atom::{Atom, Destruction},
+    atoms::install_packages::install_batch,
+    atoms::package::PackageManager,
+    color::paint,
+    dag_executor::ExecutionSummary,
+    error::{DhdError, Result},
+    logging::LoggedCommand,
+};
+use indicatif::{ProgressBar, ProgressDrawTarget, ProgressStyle};
+use serde::Serialize;
+use std::collections::{HashMap, VecDeque};
+use std::path::PathBuf;
+use std::process::Command;
+use std::sync::{Condvar, Mutex, OnceLock};
+use std::time::Instant;
+
+/// The planned atoms of one module and the modules it has to wait for
+pub struct ModuleJob {
+    pub name: String,
+    /// Shown after the name in the module's output
+    pub description: Option<String>,
+    pub dependencies: Vec<String>,
+    pub atoms: Vec<Box<dyn Atom>>,
+    /// Why the module won't run (e.g. its condition is not met)
+    pub skipped: Option<String>,
+    pub pre_apply: Option<ModuleHook>,
+    pub post_apply: Option<ModuleHook>,
+    /// Run after every module has finished, if one of the module's atoms notified them
+    pub handlers: Vec<ModuleHandler>,
+}
+
+/// The planned atoms of a named handler
+pub struct ModuleHandler {
+    pub name: String,
+    pub atoms: Vec<Box<dyn Atom>>,
+}
+
+/// A shell command run before or after a module's atoms
+#[derive(Debug, Clone)]
+pub struct ModuleHook {
+    pub run: String,
+    pub shell: String,
+    /// Variables set for `run`, over the inherited ones
+    pub env: HashMap<String, String>,
+    /// The module directory, also exported as `DHD_MODULE_DIR`
+    pub dir: PathBuf,
+    /// Report a failure without failing the module
+    pub continue_on_error: bool,
+    /// Skip a post-apply hook when no atom changed anything
+    pub only_if_changed: bool,
+}
+
+impl ModuleHook {
+    /// Run the command; `changed` is exported as `DHD_CHANGED` for post-apply hooks
+    fn execute(&self, module: &str, changed: Option<bool>) -> std::result::Result<(), String> {
+        log::trace!("Running hook '{}' with {}", self.run, self.shell);
+        let mut cmd = Command::new(&self.shell);
+        cmd.arg("-c")
+            .arg(&self.run)
+            .envs(&self.env)
+            .current_dir(&self.dir)
+            .env("DHD_MODULE", module)
+            .env("DHD_MODULE_DIR", &self.dir);
+        if let Some(changed) = changed {
+            cmd.env("DHD_CHANGED", changed.to_string());
+        }
+
+        let output = cmd
+            .logged_output()
+            .map_err(|e| format!("Failed to run '{}' with {}: {}", self.run, self.shell, e))?;
+        if !output.status.success() {
+            let code = output
+                .status
+                .code()
+                .map_or_else(|| "signal".to_string(), |code| code.to_string());
+            return Err(format!(
+                "Hook '{}' failed with exit code {}\nstdout: {}\nstderr: {}",
+                self.run,
+                code,
+                String::from_utf8_lossy(&output.stdout).trim(),
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+
+        Ok(())
+    }
+}
+
+/// What happened to an action (or a whole module) during an apply
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum ActionStatus {
+    /// The action changed the system (or would have, in a dry run)
+    Applied,
+    /// The action did not run because its module was skipped or stopped early
+    Skipped,
+    Failed,
+    /// The system already matched, nothing had to be done
+    Noop,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ActionResult {
+    pub module: String,
+    pub action: String,
+    pub status: ActionStatus,
+    pub error: Option<String>,
+    /// Time spent checking and running the action; zero if it didn't run
+    #[serde(rename = "durationMs")]
+    pub duration_ms: u64,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ModuleResult {
+    pub module: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+    pub status: ActionStatus,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub reason: Option<String>,
+    pub actions: Vec<ActionResult>,
+    /// Wall-clock time of the whole module
+    #[serde(rename = "durationMs")]
+    pub duration_ms: u64,
+    /// Handlers notified by atoms that changed something
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub notified: Vec<String>,
+    /// Whether an atom that changed something requires a reboot
+    #[serde(rename = "rebootRequired", skip_serializing_if = "std::ops::Not::not")]
+    pub reboot_required: bool,
+}
+
+impl ModuleResult {
+    fn new(
+        job: &ModuleJob,
+        reason: Option<String>,
+        actions: Vec<ActionResult>,
+        duration_ms: u64,
+    ) -> Self {
+        let mut result = Self {
+            module: job.name.clone(),
+            description: job.description.clone(),
+            status: ActionStatus::Noop,
+            reason,
+            actions,
+            duration_ms,
+            notified: Vec::new(),
+            reboot_required: false,
+        };
+        result.update_status();
+        result
+    }
+
+    /// Derive the module's status from its actions
+    fn update_status(&mut self) {
+        let has = |status| self.actions.iter().any(|action| action.status == status);
+        self.status = if has(ActionStatus::Failed) {
+            ActionStatus::Failed
+        } else if self.reason.is_some() {
+            ActionStatus::Skipped
+        } else if has(ActionStatus::Applied) {
+            ActionStatus::Applied
+        } else {
+            ActionStatus::Noop
+        };
+    }
+
+    /// Mark every atom of a module that won't run as skipped
+    fn skipped(job: &ModuleJob, reason: String) -> Self {
+        let actions = job
+            .atoms
+            .iter()
+            .map(|atom| ActionResult {
+                module: job.name.clone(),
+                action: atom.describe(),
+                status: ActionStatus::Skipped,
+                error: None,
+                duration_ms: 0,
+            })
+            .collect();
+        Self::new(job, Some(reason), actions, 0)
+    }
+
+    /// Number of the module's actions with `status`
+    pub fn count(&self, status: ActionStatus) -> usize {
+        self.actions
+            .iter()
+            .filter(|action| action.status == status)
+            .count()
+    }
+}
+
+#[derive(Default)]
+struct SchedulerState {
+    /// Modules whose dependencies have all finished, in submission order
+    ready: VecDeque<usize>,
+    /// Number of unfinished dependencies per module
+    waiting_on: Vec<usize>,
+    /// The first failed dependency of each module, if any
+    blocked_by: Vec<Option<String>>,
+    finished: usize,
+    results: Vec<Option<ModuleResult>>,
+}
+
+/// Runs modules on a bounded pool of workers
+///
+/// Atoms within a module run in order. Modules run as soon as every module
+/// they depend on has finished, so independent modules run in parallel.
+/// Dependents of a failed module are skipped. Unless told to keep going, the
+/// first failure also stops every other module at its next atom.
+pub struct ModuleExecutor {
+    jobs: Vec<ModuleJob>,
+    workers: usize,
+    quiet: bool,
+    changes_only: bool,
+    keep_going: bool,
+    diffs: bool,
+}
+
+impl ModuleExecutor {
+    pub fn new(workers: usize) -> Self {
+        Self {
+            jobs: Vec::new(),
+            workers: workers.max(1),
+            quiet: false,
+            changes_only: false,
+            keep_going: false,
+            diffs: false,
+        }
+    }
+
+    /// Don't print progress or per-module output
+    pub fn with_quiet(mut self, quiet: bool) -> Self {
+        self.quiet = quiet;
+        self
+    }
+
+    /// Only print the actions that changed something or failed, leaving out
+    /// up-to-date and skipped ones, and modules left without any
+    pub fn with_changes_only(mut self, changes_only: bool) -> Self {
+        self.changes_only = changes_only;
+        self
+    }
+
+    /// Keep running the modules that don't depend on a failed one
+    pub fn with_keep_going(mut self, keep_going: bool) -> Self {
+        self.keep_going = keep_going;
+        self
+    }
+
+    /// Show the diff of each file an atom changes under the atom's line
+    pub fn with_diffs(mut self, diffs: bool) -> Self {
+        self.diffs = diffs;
+        self
+    }
+
+    /// Add a module, after the modules it depends on
+    pub fn add_module(&mut self, job: ModuleJob) {
+        self.jobs.push(job);
+    }
+
+    /// What the planned atoms, including handlers, would destroy, by module
+    pub fn destructions(&self) -> Vec<(String, Destruction)> {
+        self.jobs
+            .iter()
+            .flat_map(|job| {
+                let handler_atoms = job.handlers.iter().flat_map(|handler| &handler.atoms);
+                job.atoms
+                    .iter()
+                    .chain(handler_atoms)
+                    .filter_map(|atom| atom.destruction())
+                    .map(move |destruction| (job.name.clone(), destruction))
+            })
+            .collect()
+    }
+
+    pub fn atom_count(&self) -> usize {
+        self.jobs.iter().map(|job| job.atoms.len()).sum()
+    }
+
+    /// Number of workers that will actually be used
+    pub fn worker_count(&self) -> usize {
+        self.workers.min(self.jobs.len()).max(1)
+    }
+
+    /// The package installs that can go first, by package manager, with the
+    /// modules asking for them
+    ///
+    /// Only the package installs a module begins with are batched, and only
+    /// when the modules it depends on do nothing but install packages, since
+    /// anything else, like adding a package repository, could be what the
+    /// packages need.
+    fn package_batches(
+        &self,
+        index: &HashMap<&str, usize>,
+    ) -> Vec<(PackageManager, Vec<(String, Vec<String>)>)> {
+        // Skipped modules change nothing
+        let only_packages: Vec<bool> = self
+            .jobs
+            .iter()
+            .map(|job| {
+                job.skipped.is_some()
+                    || (job.pre_apply.is_none()
+                        && job.atoms.iter().all(|atom| atom.package_batch().is_some()))
+            })
+            .collect();
+        fn deps_only_packages(
+            idx: usize,
+            jobs: &[ModuleJob],
+            index: &HashMap<&str, usize>,
+            only_packages: &[bool],
+        ) -> bool {
+            jobs[idx]
+                .dependencies
+                .iter()
+                .all(|dep| match index.get(dep.as_str()) {
+                    Some(&dep_idx) => {
+                        only_packages[dep_idx]
+                            && deps_only_packages(dep_idx, jobs, index, only_packages)
+                    }
+                    None => true,
+                })
+        }
+
+        let mut batches: Vec<(PackageManager, Vec<(String, Vec<String>)>)> = Vec::new();
+        for (idx, job) in self.jobs.iter().enumerate() {
+            if job.skipped.is_some()
+                || job.pre_apply.is_some()
+                || !deps_only_packages(idx, &self.jobs, index, &only_packages)
+            {
+                continue;
+            }
+            for batch in job.atoms.iter().map_while(|atom| atom.package_batch()) {
+                let request = (job.name.clone(), batch.packages);
+                match batches
+                    .iter_mut()
+                    .find(|(manager, _)| *manager == batch.manager)
+                {
+                    Some((_, requests)) => requests.push(request),
+                    None => batches.push((batch.manager, vec![request])),
+                }
+            }
+        }
+
+        // A single install gains nothing from going first
+        batches.retain(|(_, requests)| requests.len() > 1);
+        batches
+    }
+
+    /// Install the packages of each batch in one command, before any module
+    /// runs; a batch that fails leaves its packages to the atoms
+    fn install_batches(&self, index: &HashMap<&str, usize>) {
+        for (manager, requests) in self.package_batches(index) {
+            let _span = crate::profile::span(
+                "execute",
+                format!("Install {} packages at once", manager.as_str()),
+            );
+            match install_batch(&manager, &requests) {
+                Ok(installed) if installed.is_empty() => {}
+                Ok(installed) => {
+                    log::info!(
+                        "batch-installed {} packages: {}",
+                        manager.as_str(),
+                        installed.join(", ")
+                    );
+                    let modules: Vec<_> =
+                        requests.iter().map(|(module, _)| module.as_str()).collect();
+                    if !self.quiet {
+                        println!(
+                            "📦 Installed {} {} package(s) at once for {}",
+                            installed.len(),
+                            manager.as_str(),
+                            modules.join(", ")
+                        );
+                    }
+                }
+                Err(e) => log::warn!(
+                    "Installing {} packages at once failed, installing them one module at a time: {}",
+                    manager.as_str(),
+                    e
+                ),
+            }
+        }
+    }
+
+    pub fn execute(&self, dry_run: bool) -> Result<ExecutionSummary> {
+        let index: HashMap<&str, usize> = self
+            .jobs
+            .iter()
+            .enumerate()
+            .map(|(idx, job)| (job.name.as_str(), idx))
+            .collect();
+
+        // Dependencies outside the selected modules don't hold anything up
+        let mut dependents = vec![Vec::new(); self.jobs.len()];
+        let mut state = SchedulerState {
+            waiting_on: vec![0; self.jobs.len()],
+            blocked_by: vec![None; self.jobs.len()],
+            results: vec![None; self.jobs.len()],
+            ..Default::default()
+        };
+        for (idx, job) in self.jobs.iter().enumerate() {
+            for dep in &job.dependencies {
+                if let Some(&dep_idx) = index.get(dep.as_str()) {
+                    dependents[dep_idx].push(idx);
+                    state.waiting_on[idx] += 1;
+                }
+            }
+        }
+        state.ready = (0..self.jobs.len())
+            .filter(|&idx| state.waiting_on[idx] == 0)
+            .collect();
+        check_acyclic(&state.ready, &state.waiting_on, &dependents).map_err(|idx| {
+            DhdError::DependencyResolution(format!(
+                "Circular dependency detected involving module: {}",
+                self.jobs[idx].name
+            ))
+        })?;
+
+        if !dry_run {
+            self.install_batches(&index);
+        }
+
+        // Log lines would be garbled by a progress bar redrawing underneath them;
+        // the bar also hides itself when stderr isn't a terminal
+        let target = if self.quiet || crate::logging::is_verbose() {
+            ProgressDrawTarget::hidden()
+        } else {
+            ProgressDrawTarget::stderr()
+        };
+        let pb = ProgressBar::with_draw_target(Some(self.atom_count() as u64), target);
+        pb.set_style(
+            ProgressStyle::default_bar()
+                .template("{spinner:.green} [{pos}/{len}] {wide_msg}")
+                .unwrap(),
+        );
+        if !pb.is_hidden() {
+            // Keep the spinner moving through long package installs
+            pb.enable_steady_tick(std::time::Duration::from_millis(100));
+        }
+
+        let state = Mutex::new(state);
+        let wakeup = Condvar::new();
+        let stopped_by = OnceLock::new();
+
+        std::thread::scope(|scope| {
+            for _ in 0..self.worker_count() {
+                scope
+                    .spawn(|| self.worker(&state, &wakeup, &dependents, &stopped_by, &pb, dry_run));
+            }
+        });
+
+        let mut state = state.into_inner().unwrap_or_else(|e| e.into_inner());
+        for (job, result) in self.jobs.iter().zip(state.results.iter_mut()) {
+            if let Some(result) = result {
+                let output = run_handlers(job, result, dry_run, self.diffs, self.changes_only);
+                if !self.quiet {
+                    print_block(&pb, &output);
+                }
+            }
+        }
+
+        pb.finish_and_clear();
+
+        let modules: Vec<ModuleResult> = state.results.into_iter().flatten().collect();
+        let count = |status| modules.iter().map(|m| m.count(status)).sum::<usize>();
+        let failed = modules
+            .iter()
+            .flat_map(|module| &module.actions)
+            .filter(|action| action.status == ActionStatus::Failed)
+            .map(|action| {
+                (
+                    action.module.clone(),
+                    action.error.clone().unwrap_or_default(),
+                )
+            })
+            .collect();
+
+        Ok(ExecutionSummary {
+            total: self.atom_count(),
+            completed: count(ActionStatus::Applied),
+            skipped: count(ActionStatus::Noop) + count(ActionStatus::Skipped),
+            failed,
+            modules,
+            stopped_by: stopped_by.into_inner(),
+        })
+    }
+
+    fn worker(
+        &self,
+        state: &Mutex<SchedulerState>,
+        wakeup: &Condvar,
+        dependents: &[Vec<usize>],
+        stopped_by: &OnceLock<String>,
+        pb: &ProgressBar,
+        dry_run: bool,
+    ) {
+        loop {
+            let (idx, blocked_by) = {
+                let mut guard = state.lock().unwrap_or_else(|e| e.into_inner());
+                loop {
+                    if guard.finished == self.jobs.len() {
+                        return;
+                    }
+                    if let Some(idx) = guard.ready.pop_front() {
+                        break (idx, guard.blocked_by[idx].clone());
+                    }
+                    guard = wakeup.wait(guard).unwrap_or_else(|e| e.into_inner());
+                }
+            };
+
+            let job = &self.jobs[idx];
+            let (result, output) = match (&job.skipped, &blocked_by) {
+                (Some(reason), _) => {
+                    log::info!("{} skipped: {}", job.name, reason);
+                    pb.inc(job.atoms.len() as u64);
+                    (ModuleResult::skipped(job, reason.clone()), String::new())
+                }
+                (None, Some(dep)) => {
+                    let reason = format!("dependency {} did not complete", dep);
+                    log::info!("{} skipped: {}", job.name, reason);
+                    pb.inc(job.atoms.len() as u64);
+                    let output = paint(
+                        ActionStatus::Skipped,
+                        &format!("⏭️  {} skipped ({})", job.name, reason),
+                    );
+                    (ModuleResult::skipped(job, reason), output)
+                }
+                (None, None) => match stopped_by.get() {
+                    Some(failed) => {
+                        let reason = format!("stopped after {} failed", failed);
+                        log::info!("{} skipped: {}", job.name, reason);
+                        pb.inc(job.atoms.len() as u64);
+                        let output = paint(
+                            ActionStatus::Skipped,
+                            &format!("⏭️  {} skipped ({})", job.name, reason),
+                        );
+                        (ModuleResult::skipped(job, reason), output)
+                    }
+                    None => run_module(job, pb, stopped_by, dry_run, self.diffs, self.changes_only),
+                },
+            };
+            let output = match result.status {
+                ActionStatus::Skipped if self.changes_only => String::new(),
+                _ => output,
+            };
+
+            if !self.keep_going && result.status == ActionStatus::Failed {
+                let _ = stopped_by.set(job.name.clone());
+            }
+
+            if !self.quiet {
+                print_block(pb, &output);
+            }
+
+            let mut guard = state.lock().unwrap_or_else(|e| e.into_inner());
+            guard.finished += 1;
+            // Modules skipped because of a failed dependency block their own dependents too
+            let failed = result.status == ActionStatus::Failed || blocked_by.is_some();
+            for &dependent in &dependents[idx] {
+                if failed && guard.blocked_by[dependent].is_none() {
+                    guard.blocked_by[dependent] = Some(job.name.clone());
+                }
+                guard.waiting_on[dependent] -= 1;
+                if guard.waiting_on[dependent] == 0 {
+                    guard.ready.push_back(dependent);
+                }
+            }
+            guard.results[idx] = Some(result);
+            wakeup.notify_all();
+        }
+    }
+}
+
+/// Make sure every module can eventually run, returning one stuck on a cycle
+fn check_acyclic(
+    ready: &VecDeque<usize>,
+    waiting_on: &[usize],
+    dependents: &[Vec<usize>],
+) -> std::result::Result<(), usize> {
+    let mut waiting_on = waiting_on.to_vec();
+    let mut queue = ready.clone();
+    while let Some(idx) = queue.pop_front() {
+        for &dependent in &dependents[idx] {
+            waiting_on[dependent] -= 1;
+            if waiting_on[dependent] == 0 {
+                queue.push_back(dependent);
+            }
+        }
+    }
+
+    match waiting_on.iter().position(|&count| count > 0) {
+        Some(idx) => Err(idx),
+        None => Ok(()),
+    }
+}
+
+/// Run a module's atoms in order, stopping at the first failure, or once
+/// `stopped_by` names another module that failed
+///
+/// Returns the module's result and its output, printed as a single block.
+/// `pb` advances by one for every atom and shows the one currently running.
+fn run_module(
+    job: &ModuleJob,
+    pb: &ProgressBar,
+    stopped_by: &OnceLock<String>,
+    dry_run: bool,
+    diffs: bool,
+    changes_only: bool,
+) -> (ModuleResult, String) {
+    log::info!("applying {} ({} atoms)", job.name, job.atoms.len());
+    let module_start = Instant::now();
+    let _span = crate::profile::span("module", job.name.as_str());
+    let _attribution = crate::state::attribute_to(&job.name);
+
+    // Modules without atoms or hooks print nothing
+    let has_hooks = job.pre_apply.is_some() || job.post_apply.is_some();
+    let mut output = if job.atoms.is_empty() && !has_hooks {
+        String::new()
+    } else {
+        match &job.description {
+            Some(description) => format!("● {} - {}", job.name, description),
+            None => format!("● {}", job.name),
+        }
+    };
+    let mut actions = Vec::new();
+    let mut failed = false;
+    let mut shown = false;
+
+    if let Some(hook) = &job.pre_apply {
+        let action = format!("preApply hook: {}", hook.run);
+        let start = Instant::now();
+        let (status, error, line) = run_hook(&action, hook, &job.name, None, dry_run);
+        // A failing pre-apply hook keeps the atoms from running
+        failed = status == ActionStatus::Failed;
+
+        shown |= push_line(&mut output, &line, status, changes_only);
+        actions.push(ActionResult {
+            module: job.name.clone(),
+            action,
+            status,
+            error,
+            duration_ms: start.elapsed().as_millis() as u64,
+        });
+    }
+
+    let mut notified = Vec::new();
+    let mut reboot_required = false;
+    let mut reason = None;
+    for atom in &job.atoms {
+        let action = atom.describe();
+        if let Some(other) = stopped_by.get().filter(|_| !failed) {
+            reason = Some(format!("stopped after {} failed", other));
+            failed = true;
+        }
+        if !failed {
+            // Plain lines under -v, where the bar is hidden
+            log::info!(
+                "[{}/{}] {}: {}",
+                pb.position() + 1,
+                pb.length().unwrap_or_default(),
+                job.name,
+                action
+            );
+            pb.set_message(format!("{}: {}", job.name, action));
+        }
+        let (result, line) = run_step(
+            &job.name,
+            atom.as_ref(),
+            action,
+            &mut failed,
+            dry_run,
+            diffs,
+        );
+        pb.inc(1);
+        if result.status == ActionStatus::Applied {
+            for handler in atom.notifies() {
+                if !notified.contains(handler) {
+                    notified.push(handler.clone());
+                }
+            }
+            reboot_required |= atom.requires_reboot();
+        }
+
+        shown |= push_line(&mut output, &line, result.status, changes_only);
+        actions.push(result);
+    }
+
+    if let Some(hook) = &job.post_apply {
+        let action = format!("postApply hook: {}", hook.run);
+        let changed = actions
+            .iter()
+            .skip(job.pre_apply.is_some() as usize)
+            .any(|action| action.status == ActionStatus::Applied);
+        let start = Instant::now();
+        let (status, error, line) = if failed {
+            (
+                ActionStatus::Skipped,
+                None,
+                paint(
+                    ActionStatus::Skipped,
+                    &format!("  ⏭️  {} (not run)", action),
+                ),
+            )
+        } else if hook.only_if_changed && !changed {
+            (
+                ActionStatus::Noop,
+                None,
+                paint(
+                    ActionStatus::Noop,
+                    &format!("  ⏭️  {} (nothing changed)", action),
+                ),
+            )
+        } else {
+            run_hook(&action, hook, &job.name, Some(changed), dry_run)
+        };
+
+        shown |= push_line(&mut output, &line, status, changes_only);
+        actions.push(ActionResult {
+            module: job.name.clone(),
+            action,
+            status,
+            error,
+            duration_ms: start.elapsed().as_millis() as u64,
+        });
+    }
+
+    if changes_only && !shown {
+        output.clear();
+    }
+
+    let duration_ms = module_start.elapsed().as_millis() as u64;
+    let mut result = ModuleResult::new(job, reason, actions, duration_ms);
+    result.notified = notified;
+    result.reboot_required = reboot_required;
+    (result, output)
+}
+
+/// Check and run one atom unless an earlier one failed, returning its result
+/// and output line, followed by its diff with `diffs`
+fn run_step(
+    module: &str,
+    atom: &dyn Atom,
+    action: String,
+    failed: &mut bool,
+    dry_run: bool,
+    diffs: bool,
+) -> (ActionResult, String) {
+    let start = Instant::now();
+    let mut diff = None;
+    let (status, error, line) = if *failed {
+        (
+            ActionStatus::Skipped,
+            None,
+            format!("  ⏭️  {} (not run)", action),
+        )
+    } else {
+        match run_atom(atom, dry_run, diffs.then_some(&mut diff)) {
+            Ok(true) if dry_run => (
+                ActionStatus::Applied,
+                None,
+                format!("  📝 Would execute: {}", action),
+            ),
+            Ok(true) => (ActionStatus::Applied, None, format!("  ✅ {}", action)),
+            Ok(false) => (
+                ActionStatus::Noop,
+                None,
+                format!("  ⏭️  {} (up to date)", action),
+            ),
+            Err(e) => {
+                *failed = true;
+                // Errors can quote command output or rendered content
+                let e = crate::secrets::mask(&e);
+                let line = format!("  ❌ {}", e);
+                (ActionStatus::Failed, Some(e), line)
+            }
+        }
+    };
+
+    let result = ActionResult {
+        module: module.to_string(),
+        action,
+        status,
+        error,
+        duration_ms: start.elapsed().as_millis() as u64,
+    };
+    let line = paint(status, &line);
+    match diff {
+        Some(diff) => (result, format!("{}\n{}", line, diff)),
+        None => (result, line),
+    }
+}
+
+/// Run the handlers a module's atoms notified, once each and in the order
+/// they are defined, adding their results to the module's
+///
+/// Handlers of a module that failed don't run.
+fn run_handlers(
+    job: &ModuleJob,
+    result: &mut ModuleResult,
+    dry_run: bool,
+    diffs: bool,
+    changes_only: bool,
+) -> String {
+    let handlers: Vec<&ModuleHandler> = job
+        .handlers
+        .iter()
+        .filter(|handler| result.notified.contains(&handler.name))
+        .collect();
+    if handlers.is_empty() {
+        return String::new();
+    }
+
+    let start = Instant::now();
+    let _span = crate::profile::span("module", format!("{} handlers", job.name));
+    let _attribution = crate::state::attribute_to(&job.name);
+    let mut output = format!("● {} handlers", job.name);
+    let mut failed = result.status == ActionStatus::Failed;
+    let mut shown = false;
+    for handler in handlers {
+        log::info!("running handler {} of {}", handler.name, job.name);
+        for atom in &handler.atoms {
+            let action = format!("handler {}: {}", handler.name, atom.describe());
+            let (action, line) = run_step(
+                &job.name,
+                atom.as_ref(),
+                action,
+                &mut failed,
+                dry_run,
+                diffs,
+            );
+            if action.status == ActionStatus::Applied {
+                result.reboot_required |= atom.requires_reboot();
+            }
+            shown |= push_line(&mut output, &line, action.status, changes_only);
+            result.actions.push(action);
+        }
+    }
+    if changes_only && !shown {
+        output.clear();
+    }
+
+    result.duration_ms += start.elapsed().as_millis() as u64;
+    result.update_status();
+    output
+}
+
+/// Add an action's output line to its module's, unless `changes_only` leaves
+/// out actions that neither changed anything nor failed; returns whether it did
+fn push_line(output: &mut String, line: &str, status: ActionStatus, changes_only: bool) -> bool {
+    if changes_only && !matches!(status, ActionStatus::Applied | ActionStatus::Failed) {
+        return false;
+    }
+    output.push('\n');
+    output.push_str(line);
+    true
+}
+
+/// Run a module hook, returning its status, error and output line
+fn run_hook(
+    action: &str,
+    hook: &ModuleHook,
+    module: &str,
+    changed: Option<bool>,
+    dry_run: bool,
+) -> (ActionStatus, Option<String>, String) {
+    if dry_run {
+        return (
+            ActionStatus::Applied,
+            None,
+            paint(ActionStatus::Applied, &format!("  📝 Would run {}", action)),
+        );
+    }
+
+    let _span = crate::profile::span("hook", action);
+    match hook.execute(module, changed) {
+        Ok(()) => (
+            ActionStatus::Applied,
+            None,
+            paint(ActionStatus::Applied, &format!("  ✅ {}", action)),
+        ),
+        Err(e) => {
+            let e = crate::secrets::mask(&e);
+            if hook.continue_on_error {
+                let line = format!("  ⚠️  {} failed, continuing: {}", action, e);
+                (ActionStatus::Applied, Some(e), line)
+            } else {
+                let line = paint(ActionStatus::Failed, &format!("  ❌ {}", e));
+                (ActionStatus::Failed, Some(e), line)
+            }
+        }
+    }
+}
+
+/// Check and run a single atom, returning whether it did (or would do) anything
+///
+/// With `diff`, the change the atom is about to make to a file is rendered
+/// into it, indented and with secrets masked, before the atom writes.
+fn run_atom(
+    atom: &dyn Atom,
+    dry_run: bool,
+    diff: Option<&mut Option<String>>,
+) -> std::result::Result<bool, String> {
+    let span = crate::profile::span("check", atom.describe());
+    let needed = atom
+        .check()
+        .map_err(|e| format!("Check failed for {}: {}", atom.describe(), e))?;
+    drop(span);
+    let state = if needed {
+        "changes needed"
+    } else {
+        "up to date"
+    };
+    log::debug!("check {}: {}", atom.describe(), state);
+    // Verified actions assert their outcome after their last atom, whether
+    // or not that one had work to do
+    let verify = || {
+        let _span = crate::profile::span("verify", atom.describe());
+        atom.verify()
+            .map_err(|e| format!("Verification failed for {}: {}", atom.describe(), e))
+    };
+    if !needed {
+        if !dry_run {
+            verify()?;
+        }
+        return Ok(false);
+    }
+    if let Some(diff) = diff {
+        *diff = render_diff(atom);
+    }
+    if dry_run {
+        return Ok(true);
+    }
+
+    let span = crate::profile::span("execute", atom.describe());
+    let changed = atom
+        .execute_changed()
+        .map_err(|e| format!("Execution failed for {}: {}", atom.describe(), e))?;
+    drop(span);
+    verify()?;
+    Ok(changed)
+}
+
+/// The diff of the file `atom` would change, indented to sit under its line
+fn render_diff(atom: &dyn Atom) -> Option<String> {
+    let rendered = match atom.file_change()? {
+        Ok(change) => change.render()?,
+        Err(e) => {
+            log::debug!("no diff for {}: {}", atom.describe(), e);
+            return None;
+        }
+    };
+    let masked = crate::secrets::mask(&rendered);
+    Some(
+        masked
+            .lines()
+            .map(|line| format!("      {}", line))
+            .collect::<Vec<_>>()
+            .join("\n"),
+    )
+}
+
+/// Print a module's output in one piece so parallel modules don't interleave
+fn print_block(pb: &ProgressBar, block: &str) {
+    if block.is_empty() {
+        return;
+    }
+
+    if pb.is_hidden() {
+        println!("{}", block);
+    } else {
+        pb.println(block);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::any::Any;
+    use std::sync::Arc;
+    use std::sync::atomic::{AtomicUsize, Ordering};
+    use std::time::Duration;
+
+    /// Records when it runs and how many test atoms were running at once
+    struct TestAtom {
+        module: String,
+        fail: bool,
+        log: Arc<Mutex<Vec<String>>>,
+        running: Arc<AtomicUsize>,
+        max_running: Arc<AtomicUsize>,
+        notifies: Vec<String>,
+    }
+
+    impl Atom for TestAtom {
+        fn check(&self) -> anyhow::Result<bool> {
+            Ok(true)
+        }
+
+        fn execute(&self) -> anyhow::Result<()> {
+            let now = self.running.fetch_add(1, Ordering::SeqCst) + 1;
+            self.max_running.fetch_max(now, Ordering::SeqCst);
+            std::thread::sleep(Duration::from_millis(50));
+            self.log.lock().unwrap().push(self.module.clone());
+            self.running.fetch_sub(1, Ordering::SeqCst);
+
+            if self.fail {
+                anyhow::bail!("boom");
+            }
+            Ok(())
+        }
+
+        fn describe(&self) -> String {
+            format!("test atom in {}", self.module)
+        }
+
+        fn module(&self) -> &str {
+            &self.module
+        }
+
+        fn as_any(&self) -> &dyn Any {
+            self
+        }
+
+        fn notifies(&self) -> &[String] {
+            &self.notifies
+        }
+    }
+
+    #[derive(Default)]
+    struct Recorder {
+        log: Arc<Mutex<Vec<String>>>,
+        running: Arc<AtomicUsize>,
+        max_running: Arc<AtomicUsize>,
+    }
+
+    impl Recorder {
+        fn atom(&self, name: &str, fail: bool, notifies: &[&str]) -> Box<dyn Atom> {
+            Box::new(TestAtom {
+                module: name.to_string(),
+                fail,
+                log: self.log.clone(),
+                running: self.running.clone(),
+                max_running: self.max_running.clone(),
+                notifies: notifies.iter().map(|n| n.to_string()).collect(),
+            })
+        }
+
+        fn job(&self, name: &str, dependencies: &[&str], fail: bool) -> ModuleJob {
+            ModuleJob {
+                name: name.to_string(),
+                description: None,
+                dependencies: dependencies.iter().map(|d| d.to_string()).collect(),
+                atoms: vec![self.atom(name, fail, &[])],
+                skipped: None,
+                pre_apply: None,
+                post_apply: None,
+                handlers: Vec::new(),
+            }
+        }
+
+        fn log(&self) -> Vec<String> {
+            self.log.lock().unwrap().clone()
+        }
+    }
+
+    #[test]
+    fn test_independent_modules_run_in_parallel() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(4);
+        for name in ["a", "b", "c"] {
+            executor.add_module(recorder.job(name, &[], false));
+        }
+
+        let summary = executor.execute(false).unwrap();
+        assert_eq!(summary.completed, 3);
+        assert!(recorder.max_running.load(Ordering::SeqCst) > 1);
+    }
+
+    #[test]
+    fn test_single_worker_runs_serially() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(1);
+        for name in ["a", "b", "c"] {
+            executor.add_module(recorder.job(name, &[], false));
+        }
+
+        executor.execute(false).unwrap();
+        assert_eq!(recorder.max_running.load(Ordering::SeqCst), 1);
+        assert_eq!(recorder.log(), vec!["a", "b", "c"]);
+    }
+
+    #[test]
+    fn test_dependents_wait_for_dependencies() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(4);
+        executor.add_module(recorder.job("base", &[], false));
+        executor.add_module(recorder.job("tools", &["base"], false));
+        executor.add_module(recorder.job("shell", &["base"], false));
+        executor.add_module(recorder.job("desktop", &["tools", "shell"], false));
+
+        executor.execute(false).unwrap();
+        let log = recorder.log();
+        let position = |name: &str| log.iter().position(|m| m == name).unwrap();
+        assert_eq!(position("base"), 0);
+        assert_eq!(position("desktop"), 3);
+    }
+
+    #[test]
+    fn test_failed_module_skips_dependents() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(2);
+        executor.add_module(recorder.job("base", &[], true));
+        executor.add_module(recorder.job("tools", &["base"], false));
+        executor.add_module(recorder.job("other", &[], false));
+
+        let summary = executor.execute(false).unwrap();
+        assert_eq!(summary.failed.len(), 1);
+        assert!(summary.failed[0].1.contains("boom"));
+        assert_eq!(summary.completed, 1);
+        assert_eq!(summary.skipped, 1);
+        assert!(!recorder.log().contains(&"tools".to_string()));
+    }
+
+    #[test]
+    fn test_first_failure_stops_other_modules() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(1);
+        executor.add_module(recorder.job("base", &[], true));
+        executor.add_module(recorder.job("other", &[], false));
+
+        let summary = executor.execute(false).unwrap();
+        assert_eq!(summary.stopped_by.as_deref(), Some("base"));
+        assert_eq!(summary.completed, 0);
+        assert_eq!(summary.modules[1].status, ActionStatus::Skipped);
+        assert_eq!(
+            summary.modules[1].reason.as_deref(),
+            Some("stopped after base failed")
+        );
+        assert_eq!(recorder.log(), vec!["base"]);
+    }
+
+    #[test]
+    fn test_keep_going_runs_independent_modules() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(1).with_keep_going(true);
+        executor.add_module(recorder.job("base", &[], true));
+        executor.add_module(recorder.job("tools", &["base"], false));
+        executor.add_module(recorder.job("other", &[], false));
+
+        let summary = executor.execute(false).unwrap();
+        assert_eq!(summary.stopped_by, None);
+        assert_eq!(summary.completed, 1);
+        assert_eq!(recorder.log(), vec!["base", "other"]);
+    }
+
+    #[test]
+    fn test_results_report_each_action() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(2);
+        executor.add_module(recorder.job("base", &[], true));
+        executor.add_module(recorder.job("tools", &["base"], false));
+        executor.add_module(ModuleJob {
+            skipped: Some("condition not met: os is macos".to_string()),
+            ..recorder.job("mac", &[], false)
+        });
+
+        let summary = executor.execute(false).unwrap();
+        let statuses: Vec<_> = summary
+            .modules
+            .iter()
+            .map(|m| (m.module.as_str(), m.status))
+            .collect();
+        assert_eq!(
+            statuses,
+            vec![
+                ("base", ActionStatus::Failed),
+                ("tools", ActionStatus::Skipped),
+                ("mac", ActionStatus::Skipped),
+            ]
+        );
+        assert_eq!(
+            summary.modules[0].actions[0].error.as_deref(),
+            Some("Execution failed for test atom in base: boom")
+        );
+        assert_eq!(
+            summary.modules[1].reason.as_deref(),
+            Some("dependency base did not complete")
+        );
+        assert!(!recorder.log().contains(&"mac".to_string()));
+    }
+
+    #[test]
+    fn test_results_include_durations() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(2);
+        executor.add_module(recorder.job("base", &[], false));
+        executor.add_module(ModuleJob {
+            skipped: Some("condition not met: os is macos".to_string()),
+            ..recorder.job("mac", &[], false)
+        });
+
+        let summary = executor.execute(false).unwrap();
+        let base = &summary.modules[0];
+        assert!(base.actions[0].duration_ms >= 50);
+        assert!(base.duration_ms >= base.actions[0].duration_ms);
+        assert_eq!(summary.modules[1].duration_ms, 0);
+        assert_eq!(summary.modules[1].actions[0].duration_ms, 0);
+    }
+
+    fn hook(run: &str, dir: &std::path::Path) -> ModuleHook {
+        ModuleHook {
+            run: run.to_string(),
+            shell: "sh".to_string(),
+            env: HashMap::new(),
+            dir: dir.to_path_buf(),
+            continue_on_error: false,
+            only_if_changed: false,
+        }
+    }
+
+    #[test]
+    fn test_failing_pre_apply_hook_stops_the_module() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(1).with_quiet(true);
+        executor.add_module(ModuleJob {
+            pre_apply: Some(hook("exit 3", temp_dir.path())),
+            post_apply: Some(hook("touch post", temp_dir.path())),
+            ..recorder.job("nginx", &[], false)
+        });
+
+        let summary = executor.execute(false).unwrap();
+        let statuses: Vec<_> = summary.modules[0]
+            .actions
+            .iter()
+            .map(|action| action.status)
+            .collect();
+        assert_eq!(
+            statuses,
+            vec![
+                ActionStatus::Failed,
+                ActionStatus::Skipped,
+                ActionStatus::Skipped
+            ]
+        );
+        assert!(recorder.log().is_empty());
+        assert!(!temp_dir.path().join("post").exists());
+    }
+
+    #[test]
+    fn test_pre_apply_hook_can_continue_on_error() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(1).with_quiet(true);
+        executor.add_module(ModuleJob {
+            pre_apply: Some(ModuleHook {
+                continue_on_error: true,
+                ..hook("exit 1", temp_dir.path())
+            }),
+            ..recorder.job("nginx", &[], false)
+        });
+
+        let summary = executor.execute(false).unwrap();
+        let module = &summary.modules[0];
+        assert_eq!(module.status, ActionStatus::Applied);
+        assert!(
+            module.actions[0]
+                .error
+                .as_deref()
+                .unwrap()
+                .contains("exit code 1")
+        );
+        assert_eq!(recorder.log(), vec!["nginx"]);
+    }
+
+    #[test]
+    fn test_post_apply_hook_sees_whether_anything_changed() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(1).with_quiet(true);
+        executor.add_module(ModuleJob {
+            post_apply: Some(hook(
+                "echo $DHD_MODULE $DHD_CHANGED > changed",
+                temp_dir.path(),
+            )),
+            ..recorder.job("nginx", &[], false)
+        });
+        executor.add_module(ModuleJob {
+            atoms: Vec::new(),
+            post_apply: Some(hook("echo $DHD_CHANGED > unchanged", temp_dir.path())),
+            ..recorder.job("idle", &[], false)
+        });
+        executor.add_module(ModuleJob {
+            atoms: Vec::new(),
+            post_apply: Some(ModuleHook {
+                only_if_changed: true,
+                ..hook("touch never", temp_dir.path())
+            }),
+            ..recorder.job("quiet", &[], false)
+        });
+
+        let summary = executor.execute(false).unwrap();
+        let read = |name| std::fs::read_to_string(temp_dir.path().join(name)).unwrap();
+        assert_eq!(read("changed"), "nginx true\n");
+        assert_eq!(read("unchanged"), "false\n");
+        assert!(!temp_dir.path().join("never").exists());
+        assert_eq!(summary.modules[2].actions[0].status, ActionStatus::Noop);
+    }
+
+    #[test]
+    fn test_notified_handlers_run_once_at_the_end() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(1).with_quiet(true);
+        executor.add_module(ModuleJob {
+            atoms: vec![
+                recorder.atom("web", false, &["reload"]),
+                recorder.atom("web", false, &["reload"]),
+            ],
+            handlers: vec![
+                ModuleHandler {
+                    name: "restart".to_string(),
+                    atoms: vec![recorder.atom("restart", false, &[])],
+                },
+                ModuleHandler {
+                    name: "reload".to_string(),
+                    atoms: vec![recorder.atom("reload", false, &[])],
+                },
+            ],
+            ..recorder.job("web", &[], false)
+        });
+        executor.add_module(recorder.job("app", &["web"], false));
+
+        let summary = executor.execute(false).unwrap();
+        assert_eq!(recorder.log(), vec!["web", "web", "app", "reload"]);
+
+        let web = &summary.modules[0];
+        assert_eq!(web.notified, vec!["reload".to_string()]);
+        assert_eq!(
+            web.actions.last().unwrap().action,
+            "handler reload: test atom in reload"
+        );
+    }
+
+    #[test]
+    fn test_changes_requiring_a_reboot_are_reported() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(1)
+            .with_quiet(true)
+            .with_keep_going(true);
+        executor.add_module(ModuleJob {
+            atoms: crate::actions::RebootAction::wrap(vec![recorder.atom("kernel", false, &[])]),
+            ..recorder.job("kernel", &[], false)
+        });
+        executor.add_module(ModuleJob {
+            atoms: crate::actions::RebootAction::wrap(vec![recorder.atom("driver", true, &[])]),
+            ..recorder.job("driver", &[], false)
+        });
+        executor.add_module(recorder.job("zsh", &[], false));
+
+        let summary = executor.execute(false).unwrap();
+        assert_eq!(summary.reboot_required(), vec!["kernel"]);
+    }
+
+    #[test]
+    fn test_handlers_of_failed_modules_do_not_run() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(1).with_quiet(true);
+        executor.add_module(ModuleJob {
+            atoms: vec![
+                recorder.atom("web", false, &["reload"]),
+                recorder.atom("web", true, &[]),
+            ],
+            handlers: vec![ModuleHandler {
+                name: "reload".to_string(),
+                atoms: vec![recorder.atom("reload", false, &[])],
+            }],
+            ..recorder.job("web", &[], false)
+        });
+
+        let summary = executor.execute(false).unwrap();
+        assert_eq!(recorder.log(), vec!["web", "web"]);
+        assert_eq!(
+            summary.modules[0].actions.last().unwrap().status,
+            ActionStatus::Skipped
+        );
+    }
+
+    #[test]
+    fn test_circular_dependencies_are_rejected() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(2);
+        executor.add_module(recorder.job("a", &["b"], false));
+        executor.add_module(recorder.job("b", &["a"], false));
+
+        assert!(executor.execute(false).is_err());
+        assert!(recorder.log().is_empty());
+    }
+
+    #[test]
+    fn test_dry_run_does_not_execute() {
+        let recorder = Recorder::default();
+        let mut executor = ModuleExecutor::new(2);
+        executor.add_module(recorder.job("a", &[], false));
+
+        let summary = executor.execute(true).unwrap();
+        assert_eq!(summary.completed, 1);
+        assert!(recorder.log().is_empty());
+    }
+
+    /// Installs apt packages, batchable with other package atoms
+    struct PackageAtom(Vec<String>);
+
+    impl Atom for PackageAtom {
+        fn check(&self) -> anyhow::Result<bool> {
+            Ok(true)
+        }
+
+        fn execute(&self) -> anyhow::Result<()> {
+            Ok(())
+        }
+
+        fn describe(&self) -> String {
+            format!("Install packages: {}", self.0.join(", "))
+        }
+
+        fn module(&self) -> &str {
+            "test"
+        }
+
+        fn as_any(&self) -> &dyn Any {
+            self
+        }
+
+        fn package_batch(&self) -> Option<crate::atoms::install_packages::PackageBatch> {
+            Some(crate::atoms::install_packages::PackageBatch {
+                manager: PackageManager::Apt,
+                packages: self.0.clone(),
+            })
+        }
+    }
+
+    #[test]
+    fn test_leading_package_installs_are_batched() {
+        let recorder = Recorder::default();
+        let packages = |names: &[&str]| -> Box<dyn Atom> {
+            Box::new(PackageAtom(names.iter().map(|n| n.to_string()).collect()))
+        };
+        let mut executor = ModuleExecutor::new(2);
+        let mut base = recorder.job("base", &[], false);
+        base.atoms = vec![packages(&["curl"])];
+        executor.add_module(base);
+        // Its git install comes after another atom
+        let mut shell = recorder.job("shell", &["base"], false);
+        shell.atoms = vec![
+            packages(&["zsh"]),
+            recorder.atom("shell", false, &[]),
+            packages(&["git"]),
+        ];
+        executor.add_module(shell);
+        // Depends on a module that does more than install packages
+        let mut editor = recorder.job("editor", &["shell"], false);
+        editor.atoms = vec![packages(&["vim"])];
+        executor.add_module(editor);
+        let mut tools = recorder.job("tools", &[], false);
+        tools.atoms = vec![packages(&["jq"])];
+        tools.pre_apply = Some(hook("true", std::path::Path::new(".")));
+        executor.add_module(tools);
+
+        let index = executor
+            .jobs
+            .iter()
+            .enumerate()
+            .map(|(idx, job)| (job.name.as_str(), idx))
+            .collect();
+        let batches = executor.package_batches(&index);
+        assert_eq!(
+            batches,
+            vec![(
+                PackageManager::Apt,
+                vec![
+                    ("base".to_string(), vec!["curl".to_string()]),
+                    ("shell".to_string(), vec!["zsh".to_string()]),
+                ]
+            )]
+        );
+    }
+
+    /// Writes `desired` over `current` unless they're already equal
+    struct FileAtom {
+        current: &'static str,
+        desired: &'static str,
+    }
+
+    impl Atom for FileAtom {
+        fn check(&self) -> anyhow::Result<bool> {
+            Ok(self.current != self.desired)
+        }
+
+        fn execute(&self) -> anyhow::Result<()> {
+            Ok(())
+        }
+
+        fn describe(&self) -> String {
+            "Copy netrc".to_string()
+        }
+
+        fn module(&self) -> &str {
+            "test"
+        }
+
+        fn as_any(&self) -> &dyn Any {
+            self
+        }
+
+        fn file_change(&self) -> Option<std::result::Result<crate::diff::FileChange, String>> {
+            Some(Ok(crate::diff::FileChange {
+                target: PathBuf::from("/home/user/.netrc"),
+                current: Some(self.current.as_bytes().to_vec()),
+                desired: self.desired.as_bytes().to_vec(),
+            }))
+        }
+    }
+
+    #[test]
+    fn test_diffs_are_shown_for_changed_files_only() {
+        crate::secrets::reveal("diff-test-token");
+        let changed = FileAtom {
+            current: "login user\n",
+            desired: "login user\npassword diff-test-token\n",
+        };
+        let action = changed.describe();
+        let (result, line) = run_step("test", &changed, action, &mut false, false, true);
+        assert_eq!(result.status, ActionStatus::Applied);
+        assert!(line.contains("\n      --- /home/user/.netrc\n"), "{}", line);
+        assert!(line.contains("      +password ********"), "{}", line);
+        assert!(!line.contains("diff-test-token"), "{}", line);
+
+        let action = changed.describe();
+        let (_, line) = run_step("test", &changed, action, &mut false, false, false);
+        assert!(!line.contains("---"), "{}", line);
+
+        let unchanged = FileAtom {
+            current: "login user\n",
+            desired: "login user\n",
+        };
+        let action = unchanged.describe();
+        let (result, line) = run_step("test", &unchanged, action, &mut false, false, true);
+        assert_eq!(result.status, ActionStatus::Noop);
+        assert!(!line.contains('\n'), "{}", line);
+    }
+
+    #[test]
+    fn test_changes_only_leaves_out_up_to_date_actions() {
+        let recorder = Recorder::default();
+        let unchanged = || {
+            Box::new(FileAtom {
+                current: "login user\n",
+                desired: "login user\n",
+            }) as Box<dyn Atom>
+        };
+        let job = ModuleJob {
+            atoms: vec![recorder.atom("zsh", false, &[]), unchanged()],
+            ..recorder.job("zsh", &[], false)
+        };
+        let pb = ProgressBar::hidden();
+
+        let (_, output) = run_module(&job, &pb, &OnceLock::new(), false, false, false);
+        assert!(output.contains("Copy netrc"), "{}", output);
+        let (_, output) = run_module(&job, &pb, &OnceLock::new(), false, false, true);
+        assert!(output.contains("test atom in zsh"), "{}", output);
+        assert!(!output.contains("Copy netrc"), "{}", output);
+
+        let job = ModuleJob {
+            atoms: vec![unchanged()],
+            ..recorder.job("git", &[], false)
+        };
+        let (_, output) = run_module(&job, &pb, &OnceLock::new(), false, false, true);
+        assert_eq!(output, "");
+    }
+}
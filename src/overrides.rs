@@ -0,0 +1,134 @@
+//! Variables set on the command line with `--set` and `--set-json`
+//!
+//! They win over every other value of a variable: a template's own
+//! `variables`, the module's, the host profile's and the config's. Like
+//! those, they fill in templates, their `{{#if}}` blocks and the `{{ ... }}`
+//! of paths, and are checked against the modules' variable schemas.
+
+use crate::loader::{LoadedModule, json_to_variable};
+use serde_json::Value;
+use std::collections::HashMap;
+use std::sync::OnceLock;
+
+static OVERRIDES: OnceLock<HashMap<String, String>> = OnceLock::new();
+
+/// The variables of one `--set-json`, an object giving several
+#[derive(Debug, Clone, PartialEq)]
+pub struct JsonVariables(pub Vec<(String, String)>);
+
+/// Give every module loaded from now on `variables`, over any other value
+pub fn set(variables: HashMap<String, String>) {
+    OVERRIDES.set(variables).ok();
+}
+
+/// The variables set on the command line
+pub fn get() -> &'static HashMap<String, String> {
+    OVERRIDES.get_or_init(HashMap::new)
+}
+
+/// Parse `name=value` for `--set`
+///
+/// The value is taken as it is, so `true` and `8080` are the same strings a
+/// config's `true` and `8080` become, which `bool` and `number` schemas accept.
+pub fn parse_set(arg: &str) -> Result<(String, String), String> {
+    let Some((name, value)) = arg.split_once('=') else {
+        return Err(format!("'{}' isn't NAME=VALUE", arg));
+    };
+    let name = name.trim();
+    if name.is_empty() {
+        return Err(format!("'{}' has no variable name", arg));
+    }
+    Ok((name.to_string(), value.to_string()))
+}
+
+/// Parse `name=<json>` for `--set-json`
+///
+/// A string, number or boolean sets `name`, and an object sets `name.key`
+/// for each of its keys, like the dotted names of host facts.
+pub fn parse_set_json(arg: &str) -> Result<JsonVariables, String> {
+    let (name, json) = parse_set(arg)?;
+    let value: Value =
+        serde_json::from_str(&json).map_err(|e| format!("'{}' isn't JSON: {}", json, e))?;
+    let mut variables = Vec::new();
+    flatten(&name, &value, &mut variables)?;
+    Ok(JsonVariables(variables))
+}
+
+fn flatten(name: &str, value: &Value, out: &mut Vec<(String, String)>) -> Result<(), String> {
+    if let Value::Object(map) = value {
+        for (key, value) in map {
+            flatten(&format!("{}.{}", name, key), value, out)?;
+        }
+        return Ok(());
+    }
+    let variable = json_to_variable(value).ok_or_else(|| {
+        format!(
+            "'{}' is {}, but variables are strings, numbers, booleans or objects of them",
+            name, value
+        )
+    })?;
+    out.push((name.to_string(), variable));
+    Ok(())
+}
+
+/// The overrides, sorted, that no module of `modules` declares in its
+/// variable schema or sets, and neither does its config or host profile
+pub fn undeclared(modules: &[LoadedModule]) -> Vec<String> {
+    let mut names: Vec<String> = get()
+        .keys()
+        .filter(|name| {
+            !modules.iter().any(|module| {
+                module.definition.variable_schema.contains_key(*name)
+                    || module.definition.variables.contains_key(*name)
+                    || module.source.variables.contains_key(*name)
+            })
+        })
+        .cloned()
+        .collect();
+    names.sort();
+    names
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_set() {
+        assert_eq!(
+            parse_set("email=user@example.com"),
+            Ok(("email".to_string(), "user@example.com".to_string()))
+        );
+        assert_eq!(
+            parse_set("args=--a=b"),
+            Ok(("args".to_string(), "--a=b".to_string()))
+        );
+        assert_eq!(
+            parse_set("empty="),
+            Ok(("empty".to_string(), String::new()))
+        );
+        assert!(parse_set("email").is_err());
+        assert!(parse_set("=value").is_err());
+    }
+
+    #[test]
+    fn test_parse_set_json() {
+        assert_eq!(
+            parse_set_json("port=8080"),
+            Ok(JsonVariables(vec![(
+                "port".to_string(),
+                "8080".to_string()
+            )]))
+        );
+        assert_eq!(
+            parse_set_json(r#"git={"email": "user@example.com", "sign": {"enabled": true}}"#),
+            Ok(JsonVariables(vec![
+                ("git.email".to_string(), "user@example.com".to_string()),
+                ("git.sign.enabled".to_string(), "true".to_string()),
+            ]))
+        );
+        assert!(parse_set_json("editor=nvim").is_err());
+        assert!(parse_set_json(r#"editors=["nvim"]"#).is_err());
+        assert!(parse_set_json("editor=null").is_err());
+    }
+}
@@ -0,0 +1,389 @@
+//! Expanding the paths actions are given
+//!
+//! Every path of an action (`source`, `target`, `path`, `cwd`, ...) goes
+//! through [`expand`], the way a shell would expand it unquoted:
+//!
+//! * `~` and `~/...` are the home directory of the user DHD applies for
+//! * `~name/...` is the home directory of the user `name`
+//! * `$VAR` and `${VAR}` are environment variables; unset ones are left as
+//!   they are, and `$HOME` is the same home directory as `~`
+//! * `$XDG_CONFIG_HOME`, `$XDG_DATA_HOME`, `$XDG_STATE_HOME` and
+//!   `$XDG_CACHE_HOME` are always expanded, to their defaults under that home
+//!   directory when unset (see [`xdg_dir`])
+//!
+//! The user DHD applies for is the one running it, also for actions using
+//! `become`, which only run their commands through sudo. When DHD itself runs
+//! through sudo, it's the user who ran sudo (`$SUDO_USER`), not root. With
+//! `--target-user`, it's that user, whose XDG directories are always the
+//! defaults since the environment is root's.
+
+use std::ffi::OsString;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+
+/// The XDG base directories, with their defaults under the home directory
+const XDG_BASE_DIRS: [(&str, &str); 4] = [
+    ("XDG_CONFIG_HOME", ".config"),
+    ("XDG_DATA_HOME", ".local/share"),
+    ("XDG_STATE_HOME", ".local/state"),
+    ("XDG_CACHE_HOME", ".cache"),
+];
+
+/// `path` with `~`, `~name` and environment variables expanded
+pub fn expand(path: &str) -> String {
+    let (home, rest) = match split_tilde(path) {
+        Some((user, rest)) => match home_of(user) {
+            Some(home) => (Some(home), rest),
+            // An unknown user's `~name` stays as it is, like in a shell
+            None => (None, path),
+        },
+        None => (None, path),
+    };
+    let rest = shellexpand::env_with_context_no_errors(rest, |var| match var {
+        "HOME" => home_dir().map(|home| home.to_string_lossy().into_owned()),
+        _ => match xdg_dir(var) {
+            Some(dir) => Some(dir.to_string_lossy().into_owned()),
+            None => std::env::var(var).ok(),
+        },
+    });
+    match home {
+        Some(home) => format!("{}{}", home.display(), rest),
+        None => rest.into_owned(),
+    }
+}
+
+/// `path` expanded, as a path
+pub fn expand_path(path: &str) -> PathBuf {
+    PathBuf::from(expand(path))
+}
+
+/// `path` expanded, relative to `dir` unless it's absolute afterwards
+pub fn resolve(dir: &Path, path: &str) -> PathBuf {
+    dir.join(expand(path))
+}
+
+/// The paths matching `pattern` in sorted order, or `None` if it isn't a glob
+///
+/// A pattern is a path with `*`, `?` or `[...]` in its file names, like
+/// `./bin/*` or `themes/*.toml`, that isn't the name of an existing file
+/// itself. As in a shell, `*` and `?` don't match a leading `.`, and neither
+/// matches `/`; `**` is the same as `*`.
+pub fn glob(pattern: &Path) -> Option<Vec<PathBuf>> {
+    if !is_glob(pattern) {
+        return None;
+    }
+    let mut matches = vec![PathBuf::new()];
+    for component in pattern.components() {
+        let name = component.as_os_str().to_string_lossy();
+        if !name.contains(['*', '?', '[']) {
+            for path in &mut matches {
+                path.push(component);
+            }
+            continue;
+        }
+        let wildcards: Vec<char> = name.chars().collect();
+        matches = matches
+            .iter()
+            .flat_map(|dir| {
+                let listed = if dir.as_os_str().is_empty() {
+                    Path::new(".")
+                } else {
+                    dir.as_path()
+                };
+                let mut found: Vec<PathBuf> = std::fs::read_dir(listed)
+                    .into_iter()
+                    .flatten()
+                    .flatten()
+                    .filter(|entry| {
+                        let name: Vec<char> = entry.file_name().to_string_lossy().chars().collect();
+                        (name.first() != Some(&'.') || wildcards.first() == Some(&'.'))
+                            && wildcard_match(&wildcards, &name)
+                    })
+                    .map(|entry| dir.join(entry.file_name()))
+                    .collect();
+                found.sort();
+                found
+            })
+            .collect();
+    }
+    Some(matches.into_iter().filter(|path| path.exists()).collect())
+}
+
+/// Whether `path` is a pattern for [`glob`]
+pub fn is_glob(path: &Path) -> bool {
+    has_wildcards(path) && !path.exists()
+}
+
+/// The deepest directory of the glob `pattern` without wildcards, which all
+/// its matches are in
+pub fn glob_dir(pattern: &Path) -> &Path {
+    pattern
+        .ancestors()
+        .find(|dir| !has_wildcards(dir))
+        .unwrap_or(Path::new(""))
+}
+
+fn has_wildcards(path: &Path) -> bool {
+    path.to_string_lossy().contains(['*', '?', '['])
+}
+
+/// Whether the file name `name` matches the glob `pattern`
+fn wildcard_match(pattern: &[char], name: &[char]) -> bool {
+    match pattern.first() {
+        None => name.is_empty(),
+        Some('*') => (0..=name.len()).any(|skip| wildcard_match(&pattern[1..], &name[skip..])),
+        Some('?') => !name.is_empty() && wildcard_match(&pattern[1..], &name[1..]),
+        Some('[') => match (pattern.iter().position(|c| *c == ']'), name.first()) {
+            (Some(end), Some(c)) if end > 1 => {
+                let (negated, set) = match pattern[1] {
+                    '!' | '^' => (true, &pattern[2..end]),
+                    _ => (false, &pattern[1..end]),
+                };
+                let mut in_set = false;
+                let mut i = 0;
+                while i < set.len() {
+                    if i + 2 < set.len() && set[i + 1] == '-' {
+                        in_set |= (set[i]..=set[i + 2]).contains(c);
+                        i += 3;
+                    } else {
+                        in_set |= set[i] == *c;
+                        i += 1;
+                    }
+                }
+                in_set != negated && wildcard_match(&pattern[end + 1..], &name[1..])
+            }
+            // An unclosed `[` is just a `[`
+            _ => name.first() == Some(&'[') && wildcard_match(&pattern[1..], &name[1..]),
+        },
+        Some(c) => name.first() == Some(c) && wildcard_match(&pattern[1..], &name[1..]),
+    }
+}
+
+/// The XDG base directory `var` names, like `XDG_CONFIG_HOME`, or `None` if
+/// `var` isn't one
+///
+/// As the XDG spec says, the variable only counts if it's an absolute path;
+/// otherwise it's the directory's default under [`home_dir`], e.g.
+/// `~/.local/share` for `XDG_DATA_HOME`.
+pub fn xdg_dir(var: &str) -> Option<PathBuf> {
+    // Root's own XDG directories aren't the target user's
+    let value = match crate::target_user::target() {
+        Some(_) => None,
+        None => std::env::var_os(var),
+    };
+    base_dir(var, value, home_dir)
+}
+
+fn base_dir(
+    var: &str,
+    value: Option<OsString>,
+    home: impl FnOnce() -> Option<PathBuf>,
+) -> Option<PathBuf> {
+    let (_, default) = XDG_BASE_DIRS.iter().find(|(name, _)| *name == var)?;
+    value
+        .map(PathBuf::from)
+        .filter(|dir| dir.is_absolute())
+        .or_else(|| home().map(|home| home.join(default)))
+}
+
+/// The home directory of the user DHD applies for
+pub fn home_dir() -> Option<PathBuf> {
+    if let Some(user) = crate::target_user::target() {
+        return Some(user.home.clone());
+    }
+    if crate::privilege::is_root() {
+        if let Some(user) = std::env::var("SUDO_USER")
+            .ok()
+            .filter(|user| user != "root")
+        {
+            if let Some(home) = user_home(&user) {
+                return Some(home);
+            }
+        }
+    }
+    std::env::var_os("HOME")
+        .filter(|home| !home.is_empty())
+        .map(PathBuf::from)
+        .or_else(|| directories::BaseDirs::new().map(|dirs| dirs.home_dir().to_path_buf()))
+}
+
+/// The user of a leading `~` or `~name` (empty for `~`), and what follows it
+fn split_tilde(path: &str) -> Option<(&str, &str)> {
+    let rest = path.strip_prefix('~')?;
+    let end = rest.find('/').unwrap_or(rest.len());
+    let user = &rest[..end];
+    let valid = user
+        .chars()
+        .all(|c| c.is_ascii_alphanumeric() || matches!(c, '_' | '-' | '.'));
+    valid.then(|| (user, &rest[end..]))
+}
+
+/// The home directory of `user`, or of the user DHD applies for if empty
+fn home_of(user: &str) -> Option<PathBuf> {
+    if user.is_empty() {
+        home_dir()
+    } else {
+        user_home(user)
+    }
+}
+
+/// The home directory the user database has for `user`
+fn user_home(user: &str) -> Option<PathBuf> {
+    passwd_home(&passwd_entry(user)?)
+}
+
+/// The `name:password:uid:gid:gecos:home:shell` line of `user`
+pub(crate) fn passwd_entry(user: &str) -> Option<String> {
+    // getent also asks LDAP and other NSS sources; /etc/passwd is the fallback
+    Command::new("getent")
+        .args(["passwd", user])
+        .output()
+        .ok()
+        .filter(|output| output.status.success())
+        .map(|output| String::from_utf8_lossy(&output.stdout).into_owned())
+        .or_else(|| {
+            let passwd = std::fs::read_to_string("/etc/passwd").ok()?;
+            passwd
+                .lines()
+                .find(|line| line.split(':').next() == Some(user))
+                .map(String::from)
+        })
+}
+
+/// The home directory field of a `name:password:uid:gid:gecos:home:shell` line
+fn passwd_home(entry: &str) -> Option<PathBuf> {
+    entry
+        .trim()
+        .split(':')
+        .nth(5)
+        .filter(|home| !home.is_empty())
+        .map(PathBuf::from)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn home() -> String {
+        home_dir().unwrap().display().to_string()
+    }
+
+    #[test]
+    fn test_tilde_is_the_home_directory() {
+        assert_eq!(expand("~"), home());
+        assert_eq!(expand("~/.zshrc"), format!("{}/.zshrc", home()));
+        assert_eq!(expand("$HOME/.zshrc"), format!("{}/.zshrc", home()));
+        assert_eq!(expand("${HOME}/.zshrc"), format!("{}/.zshrc", home()));
+        // Only a leading tilde is expanded
+        assert_eq!(expand("/srv/~/backup"), "/srv/~/backup");
+    }
+
+    #[test]
+    fn test_tilde_user_is_their_home_directory() {
+        assert_eq!(expand("~root/.profile"), "/root/.profile");
+        assert_eq!(expand("~dhd-no-such-user/file"), "~dhd-no-such-user/file");
+    }
+
+    #[test]
+    fn test_environment_variables_are_expanded() {
+        unsafe {
+            std::env::set_var("DHD_PATHS_TEST_DIR", "/opt/tools");
+        }
+        assert_eq!(expand("$DHD_PATHS_TEST_DIR/bin"), "/opt/tools/bin");
+        assert_eq!(expand("${DHD_PATHS_TEST_DIR}bin"), "/opt/toolsbin");
+        // Unset variables are left alone
+        assert_eq!(
+            expand("/srv/$DHD_PATHS_TEST_UNSET/x"),
+            "/srv/$DHD_PATHS_TEST_UNSET/x"
+        );
+    }
+
+    #[test]
+    fn test_glob_matches_file_names() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let bin = temp_dir.path().join("bin");
+        std::fs::create_dir(&bin).unwrap();
+        for name in ["deploy", "dev-shell", "lint.sh", ".hidden"] {
+            std::fs::write(bin.join(name), "").unwrap();
+        }
+
+        assert_eq!(
+            glob(&bin.join("*")).unwrap(),
+            vec![
+                bin.join("deploy"),
+                bin.join("dev-shell"),
+                bin.join("lint.sh")
+            ]
+        );
+        assert_eq!(glob(&bin.join("*.sh")).unwrap(), vec![bin.join("lint.sh")]);
+        assert_eq!(glob(&bin.join("de?loy")).unwrap(), vec![bin.join("deploy")]);
+        assert_eq!(glob(&bin.join("[a-e]*")).unwrap().len(), 2);
+        assert_eq!(glob(&bin.join("[!d]*")).unwrap(), vec![bin.join("lint.sh")]);
+        assert_eq!(glob(&bin.join(".*")).unwrap(), vec![bin.join(".hidden")]);
+        assert_eq!(
+            glob(&temp_dir.path().join("*/lint.sh")).unwrap(),
+            vec![bin.join("lint.sh")]
+        );
+        assert!(glob(&bin.join("*.py")).unwrap().is_empty());
+        assert_eq!(glob_dir(&bin.join("*.sh")), bin);
+        // Paths without wildcards aren't globs
+        assert_eq!(glob(&bin.join("deploy")), None);
+    }
+
+    #[test]
+    fn test_xdg_base_dirs() {
+        let home = || Some(PathBuf::from("/home/dev"));
+        assert_eq!(
+            base_dir("XDG_CONFIG_HOME", Some("/srv/config".into()), home),
+            Some(PathBuf::from("/srv/config"))
+        );
+        // Unset, empty and relative values fall back to the defaults
+        assert_eq!(
+            base_dir("XDG_CONFIG_HOME", None, home),
+            Some(PathBuf::from("/home/dev/.config"))
+        );
+        assert_eq!(
+            base_dir("XDG_DATA_HOME", Some("".into()), home),
+            Some(PathBuf::from("/home/dev/.local/share"))
+        );
+        assert_eq!(
+            base_dir("XDG_STATE_HOME", Some("state".into()), home),
+            Some(PathBuf::from("/home/dev/.local/state"))
+        );
+        assert_eq!(
+            base_dir("XDG_CACHE_HOME", None, home),
+            Some(PathBuf::from("/home/dev/.cache"))
+        );
+        assert_eq!(base_dir("XDG_RUNTIME_DIR", None, home), None);
+
+        let config = xdg_dir("XDG_CONFIG_HOME").unwrap();
+        assert_eq!(
+            expand("$XDG_CONFIG_HOME/nvim/init.lua"),
+            format!("{}/nvim/init.lua", config.display())
+        );
+        assert_eq!(
+            expand("${XDG_CONFIG_HOME}/nvim"),
+            format!("{}/nvim", config.display())
+        );
+    }
+
+    #[test]
+    fn test_resolve_is_relative_to_the_directory() {
+        let dir = Path::new("/dotfiles");
+        assert_eq!(resolve(dir, "zshrc"), Path::new("/dotfiles/zshrc"));
+        assert_eq!(resolve(dir, "/etc/hosts"), Path::new("/etc/hosts"));
+        assert_eq!(
+            resolve(dir, "~/.zshrc"),
+            PathBuf::from(format!("{}/.zshrc", home()))
+        );
+    }
+
+    #[test]
+    fn test_passwd_home() {
+        assert_eq!(
+            passwd_home("dev:x:1000:1000:Dev:/home/dev:/bin/zsh\n"),
+            Some(PathBuf::from("/home/dev"))
+        );
+        assert_eq!(passwd_home("broken"), None);
+    }
+}
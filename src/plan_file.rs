@@ -0,0 +1,276 @@
+//! Plans saved with `dhd plan --output json` and applied with `apply --plan-file`
+//!
+//! The document lists every planned atom of the selected modules, with the
+//! hash of each file change before and after, so it can be reviewed and
+//! approved before anything runs. Applying it plans the same modules again
+//! and compares: anything that changed since, on the system or in the
+//! modules, is drift, which stops the apply unless `--allow-drift` is given.
+
+use crate::atom::AtomStatus;
+use crate::execution::ModulePlan;
+use serde::{Deserialize, Serialize};
+use std::fs;
+use std::path::Path;
+
+/// Version of the plan document
+///
+/// Bumped when a field is removed or changes meaning, after which older plans
+/// are refused; adding fields doesn't bump it.
+pub const PLAN_FORMAT: u32 = 1;
+
+/// A plan as `dhd plan --output json` prints it
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct PlanDocument {
+    pub format: u32,
+    pub dhd_version: String,
+    /// Host the plan was made on
+    pub hostname: String,
+    /// The `--action` types the plan was narrowed down to
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub actions: Vec<String>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub only_tags: Vec<String>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub skip_tags: Vec<String>,
+    pub modules: Vec<ModulePlan>,
+}
+
+impl PlanDocument {
+    /// A plan of `modules` on this host
+    pub fn new(modules: Vec<ModulePlan>) -> Self {
+        Self {
+            format: PLAN_FORMAT,
+            dhd_version: crate::version::VERSION.to_string(),
+            hostname: crate::system_info::facts().host.hostname.clone(),
+            actions: Vec::new(),
+            only_tags: Vec::new(),
+            skip_tags: Vec::new(),
+            modules,
+        }
+    }
+
+    /// Read a plan written by `dhd plan --output json`
+    pub fn load(path: &Path) -> Result<Self, String> {
+        let content = fs::read_to_string(path)
+            .map_err(|e| format!("Failed to read plan {}: {}", path.display(), e))?;
+        let plan: Self = serde_json::from_str(&content)
+            .map_err(|e| format!("{} isn't a DHD plan: {}", path.display(), e))?;
+        if plan.format != PLAN_FORMAT {
+            return Err(format!(
+                "{} is a plan of format {}, but this DHD reads format {}; run `dhd plan` again",
+                path.display(),
+                plan.format,
+                PLAN_FORMAT
+            ));
+        }
+        Ok(plan)
+    }
+
+    /// Number of atoms that would change the system
+    pub fn pending_count(&self) -> usize {
+        self.modules.iter().map(ModulePlan::pending_count).sum()
+    }
+
+    /// How `live`, the same modules planned again, differs from this plan
+    ///
+    /// Names the modules whose actions or skipping changed, the atoms whose
+    /// status changed, and the files that changed since the plan or would get
+    /// different content. Satisfied atoms that still are don't count, and
+    /// neither do atoms that are pending in both without a file to compare.
+    pub fn drift(&self, live: &PlanDocument) -> Vec<String> {
+        let mut drift = Vec::new();
+        if live.hostname != self.hostname {
+            drift.push(format!(
+                "the plan was made on {}, this is {}",
+                self.hostname, live.hostname
+            ));
+        }
+
+        for planned in &self.modules {
+            let Some(current) = live.modules.iter().find(|m| m.name == planned.name) else {
+                drift.push(format!("{}: no longer selected", planned.name));
+                continue;
+            };
+            match (&planned.skipped, &current.skipped) {
+                (None, Some(reason)) => {
+                    drift.push(format!("{}: now skipped ({})", planned.name, reason));
+                    continue;
+                }
+                (Some(_), None) => {
+                    drift.push(format!("{}: no longer skipped", planned.name));
+                    continue;
+                }
+                _ => {}
+            }
+
+            let same_atoms = planned.atoms.len() == current.atoms.len()
+                && planned
+                    .atoms
+                    .iter()
+                    .zip(&current.atoms)
+                    .all(|(planned, current)| planned.description == current.description);
+            if !same_atoms {
+                drift.push(format!("{}: its actions changed", planned.name));
+                continue;
+            }
+
+            for (atom, now) in planned.atoms.iter().zip(&current.atoms) {
+                if atom.status != now.status {
+                    drift.push(format!(
+                        "{}: {} was {}, now {}",
+                        planned.name,
+                        atom.description,
+                        status_name(atom.status),
+                        status_name(now.status)
+                    ));
+                    continue;
+                }
+                let (Some(file), Some(now)) = (&atom.file, &now.file) else {
+                    continue;
+                };
+                if file.before != now.before {
+                    drift.push(format!(
+                        "{}: {} changed since the plan",
+                        planned.name,
+                        file.path.display()
+                    ));
+                } else if file.after != now.after {
+                    drift.push(format!(
+                        "{}: would write different content to {}",
+                        planned.name,
+                        file.path.display()
+                    ));
+                }
+            }
+        }
+
+        drift
+    }
+}
+
+fn status_name(status: AtomStatus) -> &'static str {
+    match status {
+        AtomStatus::Satisfied => "satisfied",
+        AtomStatus::Pending => "pending",
+        AtomStatus::Unchecked => "unchecked",
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::execution::{PlannedAtom, PlannedFile};
+    use std::path::PathBuf;
+    use tempfile::TempDir;
+
+    fn atom(description: &str, status: AtomStatus, file: Option<(&str, &str)>) -> PlannedAtom {
+        PlannedAtom {
+            description: description.to_string(),
+            status,
+            file: file.map(|(before, after)| PlannedFile {
+                path: PathBuf::from("/home/user/.zshrc"),
+                before: Some(before.to_string()),
+                after: after.to_string(),
+                diff: None,
+            }),
+        }
+    }
+
+    fn plan(atoms: Vec<PlannedAtom>) -> PlanDocument {
+        PlanDocument {
+            format: PLAN_FORMAT,
+            dhd_version: "1.0.0".to_string(),
+            hostname: "laptop".to_string(),
+            actions: Vec::new(),
+            only_tags: Vec::new(),
+            skip_tags: Vec::new(),
+            modules: vec![ModulePlan {
+                name: "zsh".to_string(),
+                description: None,
+                skipped: None,
+                atoms,
+            }],
+        }
+    }
+
+    #[test]
+    fn test_drift() {
+        let planned = plan(vec![
+            atom("Install zsh", AtomStatus::Satisfied, None),
+            atom("Copy .zshrc", AtomStatus::Pending, Some(("a", "b"))),
+        ]);
+        assert_eq!(planned.pending_count(), 1);
+        assert_eq!(planned.drift(&planned.clone()), Vec::<String>::new());
+
+        let live = plan(vec![
+            atom("Install zsh", AtomStatus::Pending, None),
+            atom("Copy .zshrc", AtomStatus::Pending, Some(("c", "b"))),
+        ]);
+        assert_eq!(
+            planned.drift(&live),
+            vec![
+                "zsh: Install zsh was satisfied, now pending".to_string(),
+                "zsh: /home/user/.zshrc changed since the plan".to_string(),
+            ]
+        );
+
+        let live = plan(vec![
+            atom("Install zsh", AtomStatus::Satisfied, None),
+            atom("Copy .zshrc", AtomStatus::Pending, Some(("a", "d"))),
+        ]);
+        assert_eq!(
+            planned.drift(&live),
+            vec!["zsh: would write different content to /home/user/.zshrc".to_string()]
+        );
+
+        let live = PlanDocument {
+            hostname: "desktop".to_string(),
+            ..plan(vec![atom("Install zsh", AtomStatus::Satisfied, None)])
+        };
+        assert_eq!(
+            planned.drift(&live),
+            vec![
+                "the plan was made on laptop, this is desktop".to_string(),
+                "zsh: its actions changed".to_string(),
+            ]
+        );
+
+        let live = PlanDocument {
+            modules: Vec::new(),
+            ..planned.clone()
+        };
+        assert_eq!(
+            planned.drift(&live),
+            vec!["zsh: no longer selected".to_string()]
+        );
+    }
+
+    #[test]
+    fn test_load_round_trips_and_checks_the_format() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("plan.json");
+        let planned = plan(vec![atom(
+            "Copy .zshrc",
+            AtomStatus::Pending,
+            Some(("a", "b")),
+        )]);
+        fs::write(&path, serde_json::to_string(&planned).unwrap()).unwrap();
+        let loaded = PlanDocument::load(&path).unwrap();
+        assert_eq!(loaded.drift(&planned), Vec::<String>::new());
+        assert_eq!(
+            loaded.modules[0].atoms[0].file,
+            planned.modules[0].atoms[0].file
+        );
+
+        let newer = PlanDocument {
+            format: PLAN_FORMAT + 1,
+            ..planned
+        };
+        fs::write(&path, serde_json::to_string(&newer).unwrap()).unwrap();
+        assert!(PlanDocument::load(&path).unwrap_err().contains("format"));
+
+        fs::write(&path, "{}").unwrap();
+        assert!(PlanDocument::load(&path).is_err());
+    }
+}
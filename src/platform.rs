@@ -1,5 +1,23 @@
+use directories::BaseDirs;
 use once_cell::sync::Lazy;
 use std::env;
+use std::path::{Path, PathBuf};
+
+/// Environment variable naming an os-release file to detect the distribution
+/// from instead of `/etc/os-release`, for trying out other distributions
+pub const OS_RELEASE_VAR: &str = "DHD_OS_RELEASE";
+
+/// Environment variable naming a CPU architecture to select variants for
+/// instead of the one DHD runs on, for trying out the config of other machines
+pub const ARCH_VAR: &str = "DHD_ARCH";
+
+/// Other common names of CPU architectures, as in Debian packages or Go releases
+const ARCH_ALIASES: [(&str, &str); 3] =
+    [("amd64", "x86_64"), ("arm64", "aarch64"), ("i386", "x86")];
+
+/// Environment variable naming a directory for all of DHD's own state and
+/// caches, instead of its directories under the XDG ones
+pub const HOME_VAR: &str = "DHD_HOME";
 
 #[derive(Debug, Clone, PartialEq)]
 pub enum Platform {
@@ -26,6 +44,10 @@ pub fn current_platform() -> Platform {
 }
 
 fn detect_platform() -> Platform {
+    // A stand-in os-release file makes any host look like that distribution
+    if let Some(content) = os_release_override() {
+        return Platform::Linux(parse_os_release(&content));
+    }
     match env::consts::OS {
         "linux" => Platform::Linux(detect_linux_distro()),
         "macos" => Platform::MacOS,
@@ -34,24 +56,274 @@ fn detect_platform() -> Platform {
     }
 }
 
-fn detect_linux_distro() -> LinuxDistro {
-    // Try to read /etc/os-release
-    if let Ok(content) = std::fs::read_to_string("/etc/os-release") {
-        let id = content
-            .lines()
-            .find(|line| line.starts_with("ID="))
-            .and_then(|line| line.split('=').nth(1))
-            .map(|s| s.trim_matches('"'));
-
-        match id {
-            Some("ubuntu") => LinuxDistro::Ubuntu,
-            Some("debian") => LinuxDistro::Debian,
-            Some("fedora") => LinuxDistro::Fedora,
-            Some("arch") => LinuxDistro::Arch,
-            Some("nixos") => LinuxDistro::NixOS,
-            _ => LinuxDistro::Other,
+impl Platform {
+    /// The name of the operating system, as shown in error messages
+    pub fn name(&self) -> &'static str {
+        match self {
+            Platform::Linux(_) => "Linux",
+            Platform::MacOS => "macOS",
+            Platform::Windows => "Windows",
+            Platform::Unknown => "unknown OS",
         }
+    }
+
+    pub fn is_linux(&self) -> bool {
+        matches!(self, Platform::Linux(_))
+    }
+}
+
+/// Fail with a clear error when a Linux-only `feature` is used on another OS
+pub fn require_linux(feature: &str) -> Result<(), String> {
+    require_linux_on(&current_platform(), feature)
+}
+
+fn require_linux_on(platform: &Platform, feature: &str) -> Result<(), String> {
+    if platform.is_linux() {
+        Ok(())
     } else {
-        LinuxDistro::Other
+        Err(format!(
+            "{} is unsupported on this OS ({}); it needs Linux",
+            feature,
+            platform.name()
+        ))
+    }
+}
+
+/// The CPU architecture to select variants for, e.g. `x86_64` or `aarch64`:
+/// that of `$DHD_ARCH` if it's set, else the one DHD runs on
+pub fn current_arch() -> String {
+    env::var(ARCH_VAR)
+        .ok()
+        .filter(|arch| !arch.is_empty())
+        .map(|arch| normalize_arch(&arch))
+        .unwrap_or_else(|| env::consts::ARCH.to_string())
+}
+
+/// The name Rust gives architecture `arch`, so `amd64` is `x86_64` and
+/// `arm64` is `aarch64`
+pub fn normalize_arch(arch: &str) -> String {
+    let arch = arch.to_lowercase();
+    ARCH_ALIASES
+        .iter()
+        .find(|(alias, _)| *alias == arch)
+        .map(|(_, name)| name.to_string())
+        .unwrap_or(arch)
+}
+
+/// Where configuration files live (`$XDG_CONFIG_HOME`, usually `~/.config`)
+pub fn config_dir() -> PathBuf {
+    xdg_dir("XDG_CONFIG_HOME", ".config", |dirs| {
+        Some(dirs.config_dir().to_path_buf())
+    })
+}
+
+/// Where caches live (`$XDG_CACHE_HOME`, usually `~/.cache`)
+pub fn cache_dir() -> PathBuf {
+    xdg_dir("XDG_CACHE_HOME", ".cache", |dirs| {
+        Some(dirs.cache_dir().to_path_buf())
+    })
+}
+
+/// Where state lives (`$XDG_STATE_HOME`, usually `~/.local/state`)
+pub fn state_dir() -> PathBuf {
+    xdg_dir("XDG_STATE_HOME", ".local/state", |dirs| {
+        dirs.state_dir().map(|dir| dir.to_path_buf())
+    })
+}
+
+/// The directory `DHD_HOME` names, if set, made absolute
+pub fn dhd_home() -> Option<PathBuf> {
+    let home = env::var_os(HOME_VAR).filter(|home| !home.is_empty())?;
+    let home = crate::paths::expand_path(&home.to_string_lossy());
+    Some(std::path::absolute(&home).unwrap_or(home))
+}
+
+/// Where DHD keeps its state file and backups: `$DHD_HOME/state`, or
+/// `$XDG_STATE_HOME/dhd`
+pub fn dhd_state_dir() -> PathBuf {
+    match dhd_home() {
+        Some(home) => home.join("state"),
+        None => state_dir().join("dhd"),
+    }
+}
+
+/// Where DHD keeps downloads and git imports: `$DHD_HOME/cache`, or
+/// `$XDG_CACHE_HOME/dhd`
+pub fn dhd_cache_dir() -> PathBuf {
+    match dhd_home() {
+        Some(home) => home.join("cache"),
+        None => cache_dir().join("dhd"),
+    }
+}
+
+// On macOS the native locations are under ~/Library, but dotfiles and the
+// tools reading them expect the XDG layout there as well
+fn xdg_dir(var: &str, fallback: &str, native: impl Fn(&BaseDirs) -> Option<PathBuf>) -> PathBuf {
+    let base = BaseDirs::new();
+    if current_platform() != Platform::MacOS {
+        if let Some(dir) = base.as_ref().and_then(&native) {
+            return dir;
+        }
+    }
+
+    env::var_os(var)
+        .map(PathBuf::from)
+        .filter(|dir| dir.is_absolute())
+        .or_else(|| base.map(|dirs| dirs.home_dir().join(fallback)))
+        .unwrap_or_else(|| PathBuf::from(fallback))
+}
+
+impl LinuxDistro {
+    /// The `/etc/os-release` style identifier for this distribution
+    pub fn id(&self) -> &'static str {
+        match self {
+            LinuxDistro::Ubuntu => "ubuntu",
+            LinuxDistro::Debian => "debian",
+            LinuxDistro::Fedora => "fedora",
+            LinuxDistro::Arch => "arch",
+            LinuxDistro::NixOS => "nixos",
+            LinuxDistro::Other => "other",
+        }
+    }
+
+    /// The distribution this one derives from, if any
+    pub fn family(&self) -> Option<LinuxDistro> {
+        match self {
+            LinuxDistro::Ubuntu => Some(LinuxDistro::Debian),
+            _ => None,
+        }
+    }
+}
+
+/// The content of the os-release file `$DHD_OS_RELEASE` names, if it's set
+/// and can be read
+pub fn os_release_override() -> Option<String> {
+    let path = env::var_os(OS_RELEASE_VAR).filter(|path| !path.is_empty())?;
+    let path = PathBuf::from(path);
+    match std::fs::read_to_string(&path) {
+        Ok(content) => Some(content),
+        Err(e) => {
+            log::warn!("can't read {} of {}: {}", path.display(), OS_RELEASE_VAR, e);
+            None
+        }
+    }
+}
+
+fn detect_linux_distro() -> LinuxDistro {
+    detect_linux_distro_from(Path::new("/etc/os-release"))
+}
+
+/// The distribution an os-release file describes, `Other` if it's unreadable
+pub fn detect_linux_distro_from(path: &Path) -> LinuxDistro {
+    match std::fs::read_to_string(path) {
+        Ok(content) => parse_os_release(&content),
+        Err(e) => {
+            log::debug!("can't read {}: {}", path.display(), e);
+            LinuxDistro::Other
+        }
+    }
+}
+
+/// The value of `key` in os-release `content`, unquoted
+pub fn os_release_field(content: &str, key: &str) -> Option<String> {
+    content
+        .lines()
+        .find_map(|line| line.strip_prefix(key)?.strip_prefix('='))
+        .map(|value| value.trim().trim_matches('"').to_string())
+}
+
+fn parse_os_release(content: &str) -> LinuxDistro {
+    let field = |key: &str| os_release_field(content, key);
+
+    let from_id = |id: &str| match id {
+        "ubuntu" => Some(LinuxDistro::Ubuntu),
+        "debian" => Some(LinuxDistro::Debian),
+        "fedora" => Some(LinuxDistro::Fedora),
+        "arch" => Some(LinuxDistro::Arch),
+        "nixos" => Some(LinuxDistro::NixOS),
+        _ => None,
+    };
+
+    // Derivatives such as Pop!_OS or Manjaro name their parent in ID_LIKE
+    field("ID")
+        .and_then(|id| from_id(&id))
+        .or_else(|| {
+            field("ID_LIKE")?
+                .split_whitespace()
+                .find_map(|id| from_id(id))
+        })
+        .unwrap_or(LinuxDistro::Other)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_normalize_arch() {
+        assert_eq!(normalize_arch("x86_64"), "x86_64");
+        assert_eq!(normalize_arch("amd64"), "x86_64");
+        assert_eq!(normalize_arch("ARM64"), "aarch64");
+        assert_eq!(normalize_arch("riscv64"), "riscv64");
+    }
+
+    #[test]
+    fn test_parse_os_release_id() {
+        let content = "NAME=\"Arch Linux\"\nID=arch\nBUILD_ID=rolling\n";
+        assert_eq!(parse_os_release(content), LinuxDistro::Arch);
+    }
+
+    #[test]
+    fn test_parse_os_release_quoted_id() {
+        let content = "NAME=\"Ubuntu\"\nID=\"ubuntu\"\nID_LIKE=debian\n";
+        assert_eq!(parse_os_release(content), LinuxDistro::Ubuntu);
+    }
+
+    #[test]
+    fn test_parse_os_release_falls_back_to_id_like() {
+        let pop = "NAME=\"Pop!_OS\"\nID=pop\nID_LIKE=\"ubuntu debian\"\n";
+        assert_eq!(parse_os_release(pop), LinuxDistro::Ubuntu);
+
+        let manjaro = "NAME=\"Manjaro Linux\"\nID=manjaro\nID_LIKE=arch\n";
+        assert_eq!(parse_os_release(manjaro), LinuxDistro::Arch);
+    }
+
+    #[test]
+    fn test_parse_os_release_unknown() {
+        assert_eq!(parse_os_release("ID=gentoo\n"), LinuxDistro::Other);
+        assert_eq!(parse_os_release(""), LinuxDistro::Other);
+    }
+
+    #[test]
+    fn test_detect_linux_distro_from_file() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let path = temp_dir.path().join("os-release");
+        std::fs::write(&path, "ID=fedora\nVERSION_ID=40\n").unwrap();
+        assert_eq!(detect_linux_distro_from(&path), LinuxDistro::Fedora);
+        assert_eq!(
+            os_release_field("ID=fedora\nVERSION_ID=\"40\"\n", "VERSION_ID").as_deref(),
+            Some("40")
+        );
+        assert_eq!(
+            detect_linux_distro_from(&temp_dir.path().join("missing")),
+            LinuxDistro::Other
+        );
+    }
+
+    #[test]
+    fn test_require_linux() {
+        assert!(require_linux_on(&Platform::Linux(LinuxDistro::Arch), "systemdService").is_ok());
+
+        let error = require_linux_on(&Platform::MacOS, "systemdService").unwrap_err();
+        assert_eq!(
+            error,
+            "systemdService is unsupported on this OS (macOS); it needs Linux"
+        );
+    }
+
+    #[test]
+    fn test_distro_family() {
+        assert_eq!(LinuxDistro::Ubuntu.family(), Some(LinuxDistro::Debian));
+        assert_eq!(LinuxDistro::Arch.family(), None);
     }
 }
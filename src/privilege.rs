@@ -0,0 +1,132 @@
+//! Running commands as root for actions that set `become` or `escalate`
+//!
+//! When DHD already runs as root, commands run directly. Otherwise they run
+//! through sudo: the first one checks that sudo can be used, asking for the
+//! password once when DHD runs in a terminal, and sudo's cached credentials
+//! are then refreshed in the background so no later command asks again.
+
+use std::io::IsTerminal;
+use std::process::{Command, Stdio};
+use std::sync::{Mutex, OnceLock};
+use std::time::Duration;
+
+/// How often the cached credentials are refreshed; sudo forgets them after
+/// five minutes by default
+const REFRESH_INTERVAL: Duration = Duration::from_secs(60);
+
+/// Whether sudo can be used, once that has been checked
+static SUDO: Mutex<Option<Result<(), String>>> = Mutex::new(None);
+
+/// Whether DHD runs as root
+pub fn is_root() -> bool {
+    static ROOT: OnceLock<bool> = OnceLock::new();
+    *ROOT.get_or_init(|| {
+        Command::new("id")
+            .arg("-u")
+            .output()
+            .map(|output| String::from_utf8_lossy(&output.stdout).trim() == "0")
+            .unwrap_or(false)
+    })
+}
+
+/// Make sure commands can run as root, asking for sudo's password if needed
+///
+/// Only the first call checks; later calls return its result.
+pub fn ensure_root() -> Result<(), String> {
+    if is_root() {
+        return Ok(());
+    }
+
+    let mut sudo = SUDO.lock().unwrap_or_else(|e| e.into_inner());
+    if let Some(result) = sudo.as_ref() {
+        return result.clone();
+    }
+    let result = validate_sudo();
+    if result.is_ok() {
+        keep_alive();
+    }
+    *sudo = Some(result.clone());
+    result
+}
+
+/// A command running `program` as root
+pub fn root_command(program: &str) -> Result<Command, String> {
+    if is_root() {
+        return Ok(Command::new(program));
+    }
+    ensure_root()?;
+    let mut cmd = Command::new("sudo");
+    cmd.arg(program);
+    Ok(cmd)
+}
+
+fn validate_sudo() -> Result<(), String> {
+    // Cached credentials or NOPASSWD rules need no password
+    let quiet = Command::new("sudo")
+        .args(["-n", "true"])
+        .stdin(Stdio::null())
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .status();
+    match quiet {
+        Ok(status) if status.success() => return Ok(()),
+        Ok(_) => {}
+        Err(_) => {
+            return Err(
+                "Running as root needs sudo, which isn't installed; run dhd as root instead"
+                    .to_string(),
+            );
+        }
+    }
+
+    if !std::io::stdin().is_terminal() {
+        return Err(
+            "sudo needs a password, but dhd isn't running in a terminal to ask for it; \
+             run `sudo -v` first or run dhd as root"
+                .to_string(),
+        );
+    }
+
+    let status = Command::new("sudo")
+        .arg("-v")
+        .status()
+        .map_err(|e| format!("Failed to run sudo: {}", e))?;
+    if !status.success() {
+        return Err("sudo refused to run commands as root".to_string());
+    }
+    Ok(())
+}
+
+/// Refresh sudo's cached credentials until DHD exits
+fn keep_alive() {
+    std::thread::spawn(|| {
+        loop {
+            std::thread::sleep(REFRESH_INTERVAL);
+            let refreshed = Command::new("sudo")
+                .args(["-n", "-v"])
+                .stdin(Stdio::null())
+                .stdout(Stdio::null())
+                .stderr(Stdio::null())
+                .status()
+                .is_ok_and(|status| status.success());
+            if !refreshed {
+                break;
+            }
+        }
+    });
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_root_command_skips_sudo_as_root() {
+        if !is_root() {
+            return;
+        }
+        let cmd = root_command("apt-get").unwrap();
+        assert_eq!(cmd.get_program(), "apt-get");
+        assert_eq!(ensure_root(), Ok(()));
+    }
+}
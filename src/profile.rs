@@ -0,0 +1,175 @@
+//! Timing trace of an apply, written by `dhd apply --profile FILE`
+//!
+//! While a profile is being recorded, loading each module, planning it,
+//! checking, running and verifying each atom, hooks and batched package
+//! installs are recorded as spans with the thread they ran on. The trace is
+//! Chrome trace JSON, which chrome://tracing, Perfetto (ui.perfetto.dev) and
+//! speedscope open as a flame graph per thread. `--timings` sums up the same
+//! run per action; the trace shows where inside it the time went.
+
+use serde_json::{Value, json};
+use std::cell::Cell;
+use std::path::Path;
+use std::sync::Mutex;
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::time::Instant;
+
+struct Profile {
+    start: Instant,
+    events: Vec<Value>,
+}
+
+static PROFILE: Mutex<Option<Profile>> = Mutex::new(None);
+
+static NEXT_THREAD: AtomicU64 = AtomicU64::new(1);
+
+thread_local! {
+    /// The trace's id of this thread, 0 until it records a span
+    static THREAD: Cell<u64> = const { Cell::new(0) };
+}
+
+/// Start recording spans, dropping any recorded before
+pub fn start() {
+    *PROFILE.lock().unwrap_or_else(|e| e.into_inner()) = Some(Profile {
+        start: Instant::now(),
+        events: Vec::new(),
+    });
+}
+
+/// Whether spans are being recorded
+pub fn enabled() -> bool {
+    PROFILE.lock().unwrap_or_else(|e| e.into_inner()).is_some()
+}
+
+/// A span that's recorded when it's dropped, if a profile is being recorded
+#[must_use]
+pub struct Span(Option<(String, &'static str, Instant)>);
+
+/// Start a span `name` of the kind `category`, like `check`, ending when the
+/// returned span is dropped
+pub fn span(category: &'static str, name: impl Into<String>) -> Span {
+    if !enabled() {
+        return Span(None);
+    }
+    Span(Some((name.into(), category, Instant::now())))
+}
+
+impl Drop for Span {
+    fn drop(&mut self) {
+        let Some((name, category, start)) = self.0.take() else {
+            return;
+        };
+        let duration = start.elapsed();
+        let mut guard = PROFILE.lock().unwrap_or_else(|e| e.into_inner());
+        let Some(profile) = guard.as_mut() else {
+            return;
+        };
+
+        // Threads get an id, and a name in the trace, with their first span
+        let mut tid = THREAD.with(|thread| thread.get());
+        if tid == 0 {
+            tid = NEXT_THREAD.fetch_add(1, Ordering::Relaxed);
+            THREAD.with(|thread| thread.set(tid));
+            let thread_name = match std::thread::current().name() {
+                Some(thread_name) => thread_name.to_string(),
+                None => format!("worker {}", tid),
+            };
+            profile.events.push(json!({
+                "name": "thread_name",
+                "ph": "M",
+                "pid": std::process::id(),
+                "tid": tid,
+                "args": { "name": thread_name },
+            }));
+        }
+
+        let ts = start.saturating_duration_since(profile.start);
+        profile.events.push(json!({
+            "name": name,
+            "cat": category,
+            "ph": "X",
+            "ts": ts.as_micros() as u64,
+            "dur": duration.as_micros() as u64,
+            "pid": std::process::id(),
+            "tid": tid,
+        }));
+    }
+}
+
+/// The spans recorded so far as Chrome trace JSON
+pub fn trace() -> Value {
+    let guard = PROFILE.lock().unwrap_or_else(|e| e.into_inner());
+    let events = guard
+        .as_ref()
+        .map(|profile| profile.events.clone())
+        .unwrap_or_default();
+    json!({
+        "traceEvents": events,
+        "displayTimeUnit": "ms",
+        "otherData": { "version": env!("CARGO_PKG_VERSION") },
+    })
+}
+
+/// Write the spans recorded so far to `path`
+pub fn write(path: &Path) -> Result<(), String> {
+    let json = serde_json::to_string(&trace())
+        .map_err(|e| format!("Failed to serialize profile: {}", e))?;
+    crate::report::write(path, &(json + "\n"))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_spans_are_recorded_once_started() {
+        drop(span("check", "Not recorded"));
+        start();
+        {
+            let _outer = span("module", "zsh");
+            drop(span("check", "Create directory ~/.zsh"));
+        }
+        std::thread::spawn(|| drop(span("execute", "Run command: true")))
+            .join()
+            .unwrap();
+        let trace = trace();
+        *PROFILE.lock().unwrap_or_else(|e| e.into_inner()) = None;
+
+        // Other tests can record spans meanwhile
+        let ours = [
+            "Not recorded",
+            "zsh",
+            "Create directory ~/.zsh",
+            "Run command: true",
+        ];
+        let events = trace["traceEvents"].as_array().unwrap();
+        let spans: Vec<&Value> = events
+            .iter()
+            .filter(|event| event["ph"] == "X")
+            .filter(|event| ours.contains(&event["name"].as_str().unwrap()))
+            .collect();
+        let names: Vec<&str> = spans
+            .iter()
+            .map(|event| event["name"].as_str().unwrap())
+            .collect();
+        // Spans are recorded when they end, inner ones first
+        assert_eq!(
+            names,
+            ["Create directory ~/.zsh", "zsh", "Run command: true"]
+        );
+        assert_eq!(spans[1]["cat"], "module");
+        assert!(spans[0]["ts"].as_u64() >= spans[1]["ts"].as_u64());
+        assert!(spans[0]["dur"].as_u64() <= spans[1]["dur"].as_u64());
+        assert_eq!(spans[0]["tid"], spans[1]["tid"]);
+        assert_ne!(spans[0]["tid"], spans[2]["tid"]);
+
+        // Each thread is named once
+        for span in [spans[0], spans[2]] {
+            let names = events
+                .iter()
+                .filter(|event| event["ph"] == "M" && event["tid"] == span["tid"])
+                .count();
+            assert_eq!(names, 1);
+        }
+    }
+}
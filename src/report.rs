@@ -0,0 +1,305 @@
+//! Run reports written by `dhd apply --report-file` for monitoring
+//!
+//! A report is written after every apply, including failed ones, either as
+//! JSON or in the Prometheus text format that node_exporter's textfile
+//! collector reads.
+
+use crate::dag_executor::ExecutionSummary;
+use crate::execution::ApplyReport;
+use crate::module_executor::ActionStatus;
+use serde::Serialize;
+use std::fmt::Write as _;
+use std::fs;
+use std::io::Write as _;
+use std::path::Path;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+const STATUSES: [(ActionStatus, &str); 4] = [
+    (ActionStatus::Applied, "applied"),
+    (ActionStatus::Noop, "noop"),
+    (ActionStatus::Skipped, "skipped"),
+    (ActionStatus::Failed, "failed"),
+];
+
+/// How an apply went, with what DHD version and when it finished
+#[derive(Debug, Clone, Serialize)]
+#[serde(rename_all = "camelCase")]
+pub struct RunReport {
+    pub version: String,
+    /// Seconds since the Unix epoch
+    pub timestamp: u64,
+    pub success: bool,
+    /// Why the apply failed before or instead of running its actions
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+    #[serde(flatten)]
+    pub apply: ApplyReport,
+}
+
+impl RunReport {
+    /// Report an apply; `error` is set when it failed as a whole
+    pub fn new(
+        summary: Option<&ExecutionSummary>,
+        error: Option<String>,
+        dry_run: bool,
+        duration: Duration,
+    ) -> Self {
+        let empty = ExecutionSummary {
+            total: 0,
+            completed: 0,
+            skipped: 0,
+            failed: Vec::new(),
+            modules: Vec::new(),
+            stopped_by: None,
+        };
+        let apply = ApplyReport::new(summary.unwrap_or(&empty), dry_run, duration);
+
+        Self {
+            version: env!("CARGO_PKG_VERSION").to_string(),
+            timestamp: SystemTime::now()
+                .duration_since(UNIX_EPOCH)
+                .map(|elapsed| elapsed.as_secs())
+                .unwrap_or_default(),
+            success: error.is_none() && apply.summary.failed == 0,
+            error,
+            apply,
+        }
+    }
+
+    pub fn to_json(&self) -> Result<String, String> {
+        serde_json::to_string_pretty(self).map_err(|e| format!("Failed to serialize report: {}", e))
+    }
+
+    /// The report as Prometheus metrics, one-hot for the module statuses
+    pub fn to_prometheus(&self) -> String {
+        let summary = &self.apply.summary;
+        let mut out = String::new();
+        let mut metric = |name: &str, kind: &str, help: &str, samples: Vec<(String, String)>| {
+            let _ = writeln!(out, "# HELP {} {}", name, help);
+            let _ = writeln!(out, "# TYPE {} {}", name, kind);
+            for (labels, value) in samples {
+                let _ = writeln!(out, "{}{} {}", name, labels, value);
+            }
+        };
+
+        metric(
+            "dhd_build_info",
+            "gauge",
+            "Version of dhd that ran the apply",
+            vec![(label("version", &self.version), "1".to_string())],
+        );
+        metric(
+            "dhd_apply_success",
+            "gauge",
+            "Whether the last apply finished without failures",
+            vec![(String::new(), u8::from(self.success).to_string())],
+        );
+        metric(
+            "dhd_apply_dry_run",
+            "gauge",
+            "Whether the last apply was a dry run",
+            vec![(String::new(), u8::from(self.apply.dry_run).to_string())],
+        );
+        metric(
+            "dhd_apply_timestamp_seconds",
+            "gauge",
+            "When the last apply finished, in seconds since the Unix epoch",
+            vec![(String::new(), self.timestamp.to_string())],
+        );
+        metric(
+            "dhd_apply_duration_seconds",
+            "gauge",
+            "How long the last apply took",
+            vec![(String::new(), seconds(summary.duration_ms))],
+        );
+        metric(
+            "dhd_apply_actions",
+            "gauge",
+            "Actions of the last apply by status",
+            vec![
+                (label("status", "applied"), summary.applied.to_string()),
+                (label("status", "noop"), summary.noop.to_string()),
+                (label("status", "skipped"), summary.skipped.to_string()),
+                (label("status", "failed"), summary.failed.to_string()),
+            ],
+        );
+        metric(
+            "dhd_apply_reboot_required",
+            "gauge",
+            "Whether the last apply changed something that requires a reboot",
+            vec![(String::new(), u8::from(summary.reboot_required).to_string())],
+        );
+        metric(
+            "dhd_module_status",
+            "gauge",
+            "Status of each module in the last apply",
+            self.apply
+                .modules
+                .iter()
+                .flat_map(|module| {
+                    STATUSES.iter().map(move |(status, name)| {
+                        (
+                            format!(
+                                "{{module=\"{}\",status=\"{}\"}}",
+                                escape(&module.module),
+                                name
+                            ),
+                            u8::from(module.status == *status).to_string(),
+                        )
+                    })
+                })
+                .collect(),
+        );
+        metric(
+            "dhd_module_duration_seconds",
+            "gauge",
+            "How long each module took in the last apply",
+            self.apply
+                .modules
+                .iter()
+                .map(|module| (label("module", &module.module), seconds(module.duration_ms)))
+                .collect(),
+        );
+        out
+    }
+}
+
+/// Write a report to `path` through a temporary file, so readers never see
+/// half of it
+pub fn write(path: &Path, content: &str) -> Result<(), String> {
+    if let Some(parent) = path
+        .parent()
+        .filter(|parent| !parent.as_os_str().is_empty())
+    {
+        fs::create_dir_all(parent)
+            .map_err(|e| format!("Failed to create {}: {}", parent.display(), e))?;
+    }
+    let mut tmp = path.as_os_str().to_owned();
+    tmp.push(format!(".{}.tmp", std::process::id()));
+    let tmp = Path::new(&tmp);
+
+    let write = || -> std::io::Result<()> {
+        let mut file = fs::File::create(tmp)?;
+        file.write_all(content.as_bytes())?;
+        file.sync_all()?;
+        fs::rename(tmp, path)
+    };
+    write().map_err(|e| {
+        let _ = fs::remove_file(tmp);
+        format!("Failed to write {}: {}", path.display(), e)
+    })
+}
+
+fn label(name: &str, value: &str) -> String {
+    format!("{{{}=\"{}\"}}", name, escape(value))
+}
+
+/// Escape a label value as the text format requires
+fn escape(value: &str) -> String {
+    value
+        .replace('\\', "\\\\")
+        .replace('"', "\\\"")
+        .replace('\n', "\\n")
+}
+
+fn seconds(ms: u64) -> String {
+    format!("{:.3}", ms as f64 / 1000.0)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::module_executor::{ActionResult, ModuleResult};
+    use tempfile::TempDir;
+
+    fn summary() -> ExecutionSummary {
+        let module = |name: &str, status| ModuleResult {
+            module: name.to_string(),
+            description: None,
+            status,
+            reason: None,
+            actions: vec![ActionResult {
+                module: name.to_string(),
+                action: format!("Configure {}", name),
+                status,
+                error: None,
+                duration_ms: 250,
+            }],
+            duration_ms: 1500,
+            notified: Vec::new(),
+            reboot_required: false,
+        };
+        ExecutionSummary {
+            total: 2,
+            completed: 1,
+            skipped: 0,
+            failed: vec![("Configure git".to_string(), "exit code 1".to_string())],
+            modules: vec![
+                module("zsh", ActionStatus::Applied),
+                module("git", ActionStatus::Failed),
+            ],
+            stopped_by: None,
+        }
+    }
+
+    #[test]
+    fn test_report_counts_failures() {
+        let report = RunReport::new(Some(&summary()), None, false, Duration::from_secs(2));
+        assert!(!report.success);
+        assert_eq!(report.apply.summary.applied, 1);
+        assert_eq!(report.apply.summary.failed, 1);
+
+        let json: serde_json::Value = serde_json::from_str(&report.to_json().unwrap()).unwrap();
+        assert_eq!(json["version"], env!("CARGO_PKG_VERSION"));
+        assert_eq!(json["success"], false);
+        assert_eq!(json["modules"][1]["status"], "failed");
+        assert!(json["timestamp"].as_u64().unwrap() > 0);
+        assert!(json.get("error").is_none());
+    }
+
+    #[test]
+    fn test_report_of_an_apply_that_did_not_run() {
+        let report = RunReport::new(
+            None,
+            Some("Unknown module 'vim'".to_string()),
+            false,
+            Duration::ZERO,
+        );
+        assert!(!report.success);
+        assert_eq!(report.apply.summary.total, 0);
+        assert!(report.to_prometheus().contains("dhd_apply_success 0\n"));
+    }
+
+    #[test]
+    fn test_prometheus_metrics() {
+        let mut summary = summary();
+        summary.modules[0].reboot_required = true;
+        let report = RunReport::new(Some(&summary), None, false, Duration::from_secs(2));
+        let metrics = report.to_prometheus();
+
+        assert!(metrics.contains("# TYPE dhd_apply_success gauge\ndhd_apply_success 0\n"));
+        assert!(metrics.contains("dhd_apply_duration_seconds 2.000\n"));
+        assert!(metrics.contains("dhd_apply_actions{status=\"applied\"} 1\n"));
+        assert!(metrics.contains("dhd_apply_reboot_required 1\n"));
+        assert!(metrics.contains("dhd_module_status{module=\"git\",status=\"failed\"} 1\n"));
+        assert!(metrics.contains("dhd_module_status{module=\"git\",status=\"applied\"} 0\n"));
+        assert!(metrics.contains("dhd_module_duration_seconds{module=\"zsh\"} 1.500\n"));
+        assert_eq!(escape("a \"b\"\\"), "a \\\"b\\\"\\\\");
+    }
+
+    #[test]
+    fn test_write_replaces_the_file() {
+        let temp_dir = TempDir::new().unwrap();
+        let path = temp_dir.path().join("textfile/dhd.prom");
+        let report = RunReport::new(Some(&summary()), None, false, Duration::from_secs(2));
+
+        write(&path, &report.to_prometheus()).unwrap();
+        write(&path, &report.to_prometheus()).unwrap();
+        assert!(
+            fs::read_to_string(&path)
+                .unwrap()
+                .starts_with("# HELP dhd_build_info")
+        );
+        assert_eq!(fs::read_dir(path.parent().unwrap()).unwrap().count(), 1);
+    }
+}
@@ -0,0 +1,341 @@
+//! The JSON Schema of YAML and TOML modules, which `dhd schema` prints
+//!
+//! It comes from the same types as the TypeScript definitions: each action
+//! is the config type its function takes, with the fields `#[typescript_type]`
+//! records, and the module keys are the ones [`crate::declarative`] reads.
+//! Fields that aren't optional are required, except flags, which default to
+//! `false`. The schema's version is the module API version.
+
+use crate::typescript::{JSON_SCHEMAS, TYPESCRIPT_FUNCTIONS};
+use serde_json::{Map, Value, json};
+use std::collections::{BTreeMap, HashMap};
+
+/// The JSON Schema dialect the schema is written in
+const DIALECT: &str = "https://json-schema.org/draft/2020-12/schema";
+
+/// The schema of a module file, e.g. `zsh.dhd.yaml`
+pub fn module_schema() -> Value {
+    let fragments: HashMap<&str, Value> = JSON_SCHEMAS
+        .iter()
+        .filter_map(|(name, schema)| Some((*name, serde_json::from_str(schema).ok()?)))
+        .collect();
+
+    let actions: Vec<Value> = action_types()
+        .into_iter()
+        .filter_map(|(config, names)| {
+            Some(action_schema(
+                &config,
+                names,
+                fragments.get(config.as_str())?,
+            ))
+        })
+        .collect();
+    let mut defs = BTreeMap::new();
+    defs.insert("action".to_string(), json!({ "oneOf": actions }));
+    defs.insert(
+        "hook".to_string(),
+        json!({
+            "description": "A shell command run before or after the module's actions",
+            "anyOf": [{ "type": "string" }, { "$ref": "#/$defs/Hook" }],
+        }),
+    );
+    let strings = json!({ "type": "array", "items": { "type": "string" } });
+    let mut schema = json!({
+        "$schema": DIALECT,
+        "title": "DHD module",
+        "description": format!(
+            "A DHD module in YAML or TOML, for module API {}",
+            crate::version::MODULE_API
+        ),
+        "x-dhd-module-api": crate::version::MODULE_API,
+        "type": "object",
+        "properties": {
+            "name": { "type": "string", "description": "Name of the module (default: the file name)" },
+            "description": { "type": "string" },
+            "enabled": {
+                "type": "boolean",
+                "description": "Skip the module when false (default: true)",
+            },
+            "tags": strings,
+            "dependsOn": strings,
+            "dependencies": {
+                "type": "array",
+                "items": { "type": "string" },
+                "description": "Deprecated, use dependsOn",
+                "deprecated": true,
+            },
+            "requiresDhd": {
+                "type": "string",
+                "description": "The oldest DHD version that can apply the module, like 0.2.0",
+                "pattern": "^v?[0-9]+(\\.[0-9]+)*$",
+            },
+            "variables": {
+                "type": "object",
+                "description": "Variables of every template in the module",
+            },
+            "variableSchema": {
+                "type": "object",
+                "additionalProperties": { "$ref": "#/$defs/VariableSpec" },
+            },
+            "preApply": { "$ref": "#/$defs/hook" },
+            "postApply": { "$ref": "#/$defs/hook" },
+            "actions": { "type": "array", "items": { "$ref": "#/$defs/action" } },
+        },
+        "$defs": {},
+    });
+
+    // Pull in the types the actions and module keys refer to
+    let mut pending = Vec::new();
+    collect_refs(&mut schema, &fragments, &mut defs, &mut pending);
+    for (_, def) in defs.iter_mut() {
+        collect_refs(def, &fragments, &mut BTreeMap::new(), &mut pending);
+    }
+    while let Some(name) = pending.pop() {
+        if defs.contains_key(&name) {
+            continue;
+        }
+        let (properties, required) = fields(&fragments[name.as_str()]);
+        let mut def = match &fragments[name.as_str()]["fields"] {
+            Value::Array(_) => json!({
+                "description": fragments[name.as_str()]["description"],
+                "type": "object",
+                "properties": properties,
+                "required": required,
+            }),
+            _ => fragments[name.as_str()].clone(),
+        };
+        collect_refs(&mut def, &fragments, &mut defs, &mut pending);
+        defs.insert(name, def);
+    }
+    schema["$defs"] = Value::Object(defs.into_iter().collect());
+    schema
+}
+
+/// The config type of each action function, with the names modules can
+/// give as the action's `type`: the functions and the type itself
+fn action_types() -> BTreeMap<String, Vec<String>> {
+    let mut types: BTreeMap<String, Vec<String>> = BTreeMap::new();
+    for (_, signature) in TYPESCRIPT_FUNCTIONS {
+        // `export function copyFile(config: CopyFile): ActionType;`
+        let Some((name, rest)) = signature
+            .strip_prefix("export function ")
+            .and_then(|rest| rest.split_once('('))
+        else {
+            continue;
+        };
+        let Some((params, returns)) = rest.split_once("): ") else {
+            continue;
+        };
+        if returns.trim_end_matches(';') != "ActionType" {
+            continue;
+        }
+        if let Some((_, config)) = params.split_once(": ") {
+            types
+                .entry(config.to_string())
+                .or_default()
+                .push(name.to_string());
+        }
+    }
+    for (config, names) in types.iter_mut() {
+        names.sort();
+        names.push(config.clone());
+    }
+    types
+}
+
+/// The schema of an action whose config type has the schema `fragment`
+fn action_schema(config: &str, names: Vec<String>, fragment: &Value) -> Value {
+    let (mut properties, mut required) = fields(fragment);
+    properties.insert("type".to_string(), json!({ "enum": names }));
+    required.insert(0, "type".to_string());
+    // The options every action takes, see `parse_action`
+    properties.insert(
+        "become".to_string(),
+        json!({ "type": "boolean", "description": "Run the action as root, through sudo unless DHD is root" }),
+    );
+    properties.insert(
+        "verify".to_string(),
+        json!({ "type": "string", "description": "A command that has to succeed after the action ran" }),
+    );
+    properties.insert(
+        "tags".to_string(),
+        json!({
+            "description": "Action tags for --only-tags and --skip-tags",
+            "anyOf": [{ "type": "string" }, { "type": "array", "items": { "type": "string" } }],
+        }),
+    );
+    properties.insert(
+        "requiresReboot".to_string(),
+        json!({ "type": "boolean", "description": "Report that the system needs a reboot once the action changed something" }),
+    );
+    properties.insert(
+        "id".to_string(),
+        json!({ "type": "string", "description": "The name other actions of the module give in `after`" }),
+    );
+    properties.insert(
+        "after".to_string(),
+        json!({
+            "description": "Ids of the actions of the module this one runs after",
+            "anyOf": [{ "type": "string" }, { "type": "array", "items": { "type": "string" } }],
+        }),
+    );
+    properties.insert(
+        "forEach".to_string(),
+        json!({
+            "description": "Items to repeat the action for, filling in {{ item }} and {{ item.key }}",
+            "type": "array",
+        }),
+    );
+    let mut schema = json!({
+        "title": config,
+        "type": "object",
+        "properties": properties,
+        "required": required,
+    });
+    if let Some(description) = fragment["description"].as_str().filter(|d| !d.is_empty()) {
+        schema["description"] = json!(description);
+    }
+    // File actions take a `source` or their `content`, see `file_content`
+    if schema["properties"].get("source").is_some() && schema["properties"].get("content").is_some()
+    {
+        schema["oneOf"] = json!([{ "required": ["source"] }, { "required": ["content"] }]);
+    }
+    schema
+}
+
+/// The properties of a struct's schema fragment, and which are required
+fn fields(fragment: &Value) -> (Map<String, Value>, Vec<String>) {
+    let mut properties = Map::new();
+    let mut required = Vec::new();
+    for field in fragment["fields"].as_array().into_iter().flatten() {
+        let Some(name) = field["name"].as_str() else {
+            continue;
+        };
+        let mut schema = field["schema"].clone();
+        if let Some(description) = field["description"].as_str().filter(|d| !d.is_empty()) {
+            schema["description"] = json!(description);
+        }
+        if field["optional"] != json!(true) && schema["type"] != json!("boolean") {
+            required.push(name.to_string());
+        }
+        properties.insert(name.to_string(), schema);
+    }
+    (properties, required)
+}
+
+/// Queue the types `value` refers to that aren't in `defs` yet, and drop
+/// references to types that have no schema, which then allow anything
+fn collect_refs(
+    value: &mut Value,
+    fragments: &HashMap<&str, Value>,
+    defs: &mut BTreeMap<String, Value>,
+    pending: &mut Vec<String>,
+) {
+    match value {
+        Value::Object(object) => {
+            if let Some(Value::String(reference)) = object.get("$ref") {
+                let name = reference.trim_start_matches("#/$defs/").to_string();
+                if name == "action" || name == "hook" {
+                } else if fragments.contains_key(name.as_str()) {
+                    if !defs.contains_key(&name) {
+                        pending.push(name);
+                    }
+                } else {
+                    object.remove("$ref");
+                }
+            }
+            for (_, child) in object.iter_mut() {
+                collect_refs(child, fragments, defs, pending);
+            }
+        }
+        Value::Array(items) => {
+            for item in items {
+                collect_refs(item, fragments, defs, pending);
+            }
+        }
+        _ => {}
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn action<'a>(schema: &'a Value, config: &str) -> &'a Value {
+        schema["$defs"]["action"]["oneOf"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|action| action["title"] == json!(config))
+            .unwrap_or_else(|| panic!("no schema for {}", config))
+    }
+
+    #[test]
+    fn test_schema_has_every_action() {
+        let schema = module_schema();
+        assert_eq!(
+            schema["x-dhd-module-api"],
+            json!(crate::version::MODULE_API)
+        );
+        assert_eq!(
+            schema["properties"]["dependencies"]["deprecated"],
+            json!(true)
+        );
+        let types: Vec<&str> = schema["$defs"]["action"]["oneOf"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .flat_map(|action| action["properties"]["type"]["enum"].as_array().unwrap())
+            .filter_map(|name| name.as_str())
+            .collect();
+        for name in crate::actions::ACTION_TYPES {
+            assert!(types.contains(name), "{} is missing", name);
+        }
+    }
+
+    #[test]
+    fn test_action_fields_come_from_its_type() {
+        let schema = module_schema();
+        let copy = action(&schema, "CopyFile");
+        assert_eq!(
+            copy["properties"]["type"]["enum"],
+            json!(["copyFile", "CopyFile"])
+        );
+        assert_eq!(copy["properties"]["source"]["type"], json!("string"));
+        assert_eq!(copy["properties"]["mode"]["type"], json!("integer"));
+        assert_eq!(
+            copy["properties"]["createParents"]["type"],
+            json!("boolean")
+        );
+        assert_eq!(copy["properties"]["become"]["type"], json!("boolean"));
+        assert_eq!(copy["properties"]["id"]["type"], json!("string"));
+        assert_eq!(copy["required"], json!(["type", "target"]));
+        assert_eq!(copy["properties"]["content"]["type"], json!("string"));
+        assert_eq!(
+            copy["oneOf"],
+            json!([{ "required": ["source"] }, { "required": ["content"] }])
+        );
+
+        let directory = action(&schema, "Directory");
+        assert_eq!(
+            directory["properties"]["type"]["enum"],
+            json!(["directory", "ensureDir", "Directory"])
+        );
+    }
+
+    #[test]
+    fn test_referenced_types_are_defined() {
+        let schema = module_schema();
+        let text = schema.to_string();
+        for reference in text.split("\"$ref\":\"#/$defs/").skip(1) {
+            let name = &reference[..reference.find('"').unwrap()];
+            assert!(
+                schema["$defs"].get(name).is_some(),
+                "{} isn't defined",
+                name
+            );
+        }
+        assert_eq!(schema["$defs"]["PackageManager"]["enum"][0], json!("apt"));
+        assert_eq!(schema["$defs"]["Hook"]["required"], json!(["run"]));
+    }
+}
@@ -0,0 +1,160 @@
+use super::{SecretError, SecretProvider};
+use async_trait::async_trait;
+use std::path::{Path, PathBuf};
+use std::process::Stdio;
+use tokio::process::Command;
+
+/// Environment variable naming the age identity file to decrypt with
+pub const IDENTITY_ENV: &str = "DHD_AGE_IDENTITY";
+
+/// Decrypts `age`-encrypted files, referenced as `age://path/to/file.age`
+pub struct AgeProvider {
+    identity: Option<PathBuf>,
+}
+
+impl AgeProvider {
+    pub fn new(identity: Option<PathBuf>) -> Self {
+        Self { identity }
+    }
+
+    /// The identity to decrypt with: the configured one, `$DHD_AGE_IDENTITY`,
+    /// or `~/.config/age/keys.txt`
+    pub fn identity_path(&self) -> Option<PathBuf> {
+        if let Some(identity) = &self.identity {
+            return Some(identity.clone());
+        }
+        if let Some(identity) = std::env::var_os(IDENTITY_ENV) {
+            return Some(PathBuf::from(identity));
+        }
+
+        let default = crate::paths::expand_path("~/.config/age/keys.txt");
+        default.exists().then_some(default)
+    }
+
+    /// Decrypt a file, returning the plaintext without writing it anywhere
+    pub async fn decrypt(&self, file: &Path) -> Result<Vec<u8>, SecretError> {
+        let identity = self.identity_path().ok_or_else(|| {
+            SecretError::ProviderError(format!(
+                "No age identity found; set {} or create ~/.config/age/keys.txt",
+                IDENTITY_ENV
+            ))
+        })?;
+
+        let output = Command::new("age")
+            .arg("--decrypt")
+            .arg("--identity")
+            .arg(&identity)
+            .arg(file)
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .output()
+            .await
+            .map_err(|e| {
+                SecretError::ProviderError(format!("Failed to execute age command: {}", e))
+            })?;
+
+        if output.status.success() {
+            Ok(output.stdout)
+        } else {
+            let stderr = String::from_utf8_lossy(&output.stderr);
+            Err(SecretError::CommandFailed(format!(
+                "age failed to decrypt {}: {}",
+                file.display(),
+                stderr.trim()
+            )))
+        }
+    }
+
+    /// [`decrypt`](Self::decrypt) for callers outside an async runtime
+    pub fn decrypt_blocking(&self, file: &Path) -> Result<Vec<u8>, SecretError> {
+        tokio::runtime::Builder::new_current_thread()
+            .enable_all()
+            .build()
+            .map_err(|e| {
+                SecretError::ProviderError(format!("Failed to create async runtime: {}", e))
+            })?
+            .block_on(self.decrypt(file))
+    }
+
+    fn file_path(reference: &str) -> &str {
+        reference.strip_prefix("age://").unwrap_or(reference)
+    }
+}
+
+#[async_trait]
+impl SecretProvider for AgeProvider {
+    async fn get_secret(&self, reference: &str) -> Result<String, SecretError> {
+        self.validate_reference(reference)?;
+
+        let path = Self::file_path(reference);
+        if !Path::new(path).exists() {
+            return Err(SecretError::NotFound(path.to_string()));
+        }
+
+        let plaintext = self.decrypt(Path::new(path)).await?;
+        let secret = String::from_utf8(plaintext)
+            .map_err(|e| SecretError::ProviderError(format!("Invalid UTF-8 in {}: {}", path, e)))?;
+
+        // Files written by an editor end in a newline that isn't part of the secret
+        Ok(secret.trim_end_matches(['\n', '\r']).to_string())
+    }
+
+    async fn secret_exists(&self, reference: &str) -> Result<bool, SecretError> {
+        self.validate_reference(reference)?;
+
+        Ok(Path::new(Self::file_path(reference)).exists())
+    }
+
+    fn validate_reference(&self, reference: &str) -> Result<(), SecretError> {
+        if Self::file_path(reference).is_empty() {
+            return Err(SecretError::InvalidReference(format!(
+                "Expected age://path/to/file.age: {}",
+                reference
+            )));
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_validate_reference() {
+        let provider = AgeProvider::new(None);
+
+        assert!(
+            provider
+                .validate_reference("age://secrets/token.age")
+                .is_ok()
+        );
+        assert!(provider.validate_reference("age://").is_err());
+    }
+
+    #[test]
+    fn test_configured_identity_wins() {
+        let provider = AgeProvider::new(Some(PathBuf::from("/keys/me.txt")));
+
+        assert_eq!(
+            provider.identity_path(),
+            Some(PathBuf::from("/keys/me.txt"))
+        );
+    }
+
+    #[tokio::test]
+    async fn test_missing_file_is_not_found() {
+        let provider = AgeProvider::new(Some(PathBuf::from("/keys/me.txt")));
+
+        assert!(matches!(
+            provider.get_secret("age:///nonexistent/token.age").await,
+            Err(SecretError::NotFound(_))
+        ));
+        assert!(
+            !provider
+                .secret_exists("age:///nonexistent/token.age")
+                .await
+                .unwrap()
+        );
+    }
+}
@@ -0,0 +1,78 @@
+use super::{SecretError, SecretProvider};
+use async_trait::async_trait;
+
+/// Reads secrets from environment variables, referenced as `env://NAME`
+pub struct EnvProvider;
+
+impl EnvProvider {
+    fn variable_name(reference: &str) -> &str {
+        reference.strip_prefix("env://").unwrap_or(reference)
+    }
+}
+
+#[async_trait]
+impl SecretProvider for EnvProvider {
+    async fn get_secret(&self, reference: &str) -> Result<String, SecretError> {
+        self.validate_reference(reference)?;
+
+        let name = Self::variable_name(reference);
+        std::env::var(name).map_err(|_| SecretError::NotFound(name.to_string()))
+    }
+
+    async fn secret_exists(&self, reference: &str) -> Result<bool, SecretError> {
+        self.validate_reference(reference)?;
+
+        Ok(std::env::var_os(Self::variable_name(reference)).is_some())
+    }
+
+    fn validate_reference(&self, reference: &str) -> Result<(), SecretError> {
+        let name = Self::variable_name(reference);
+        if name.is_empty() || name.contains('=') || name.contains('\0') {
+            return Err(SecretError::InvalidReference(format!(
+                "Invalid environment variable name: {}",
+                reference
+            )));
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn test_get_secret_from_environment() {
+        unsafe {
+            std::env::set_var("DHD_ENV_PROVIDER_TEST", "s3cret");
+        }
+
+        let provider = EnvProvider;
+        assert_eq!(
+            provider
+                .get_secret("env://DHD_ENV_PROVIDER_TEST")
+                .await
+                .unwrap(),
+            "s3cret"
+        );
+        assert!(
+            provider
+                .secret_exists("DHD_ENV_PROVIDER_TEST")
+                .await
+                .unwrap()
+        );
+        assert!(matches!(
+            provider.get_secret("env://DHD_ENV_PROVIDER_MISSING").await,
+            Err(SecretError::NotFound(_))
+        ));
+    }
+
+    #[test]
+    fn test_validate_reference() {
+        let provider = EnvProvider;
+
+        assert!(provider.validate_reference("env://GITHUB_TOKEN").is_ok());
+        assert!(provider.validate_reference("env://").is_err());
+        assert!(provider.validate_reference("env://A=B").is_err());
+    }
+}
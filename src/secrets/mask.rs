@@ -0,0 +1,62 @@
+//! Keeps resolved secret values out of everything dhd prints
+//!
+//! Every value a provider returns is registered here, and output that could
+//! contain one (log lines, error messages, diffs) is passed through [`mask`].
+
+use std::sync::Mutex;
+
+/// What a secret value is replaced with in output
+pub const MASK: &str = "********";
+
+static REVEALED: Mutex<Vec<String>> = Mutex::new(Vec::new());
+
+/// Register a resolved secret value so it is masked from now on
+pub fn reveal(value: &str) {
+    // Masking an empty string would replace between every character
+    if value.is_empty() {
+        return;
+    }
+
+    let mut revealed = REVEALED.lock().unwrap_or_else(|e| e.into_inner());
+    if !revealed.iter().any(|known| known == value) {
+        revealed.push(value.to_string());
+        // Longest first, so a secret containing another is masked as a whole
+        revealed.sort_by(|a, b| b.len().cmp(&a.len()));
+    }
+}
+
+/// Replace every revealed secret value in `text`
+pub fn mask(text: &str) -> String {
+    let revealed = REVEALED.lock().unwrap_or_else(|e| e.into_inner());
+    revealed.iter().fold(text.to_string(), |text, value| {
+        text.replace(value.as_str(), MASK)
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_mask_replaces_revealed_values() {
+        reveal("hunter2-mask-test");
+        assert_eq!(
+            mask("password=hunter2-mask-test\n"),
+            format!("password={}\n", MASK)
+        );
+        assert_eq!(mask("nothing secret"), "nothing secret");
+    }
+
+    #[test]
+    fn test_mask_prefers_longer_values() {
+        reveal("abc-mask-test");
+        reveal("abc-mask-test-longer");
+        assert_eq!(mask("abc-mask-test-longer"), MASK);
+    }
+
+    #[test]
+    fn test_empty_values_are_not_masked() {
+        reveal("");
+        assert_eq!(mask("unchanged"), "unchanged");
+    }
+}
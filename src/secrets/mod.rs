@@ -1,9 +1,16 @@
 use async_trait::async_trait;
 use std::collections::HashMap;
+use std::path::Path;
+use std::sync::{LazyLock, Mutex};
 use thiserror::Error;
 
+pub mod age;
+pub mod env;
+mod mask;
 pub mod onepassword;
 
+pub use mask::{MASK, mask, reveal};
+
 #[derive(Debug, Error)]
 pub enum SecretError {
     #[error("Secret not found: {0}")]
@@ -27,6 +34,7 @@ pub trait SecretProvider: Send + Sync {
 pub enum SecretReference {
     OnePassword(String),
     Environment(String),
+    Age(String),
     Literal(String),
 }
 
@@ -37,12 +45,15 @@ impl SecretReference {
         } else if value.starts_with("env://") {
             let env_var = value.strip_prefix("env://").unwrap();
             Ok(SecretReference::Environment(env_var.to_string()))
+        } else if value.starts_with("age://") {
+            let path = value.strip_prefix("age://").unwrap();
+            Ok(SecretReference::Age(path.to_string()))
         } else if value.starts_with("literal://") {
             let literal = value.strip_prefix("literal://").unwrap();
             Ok(SecretReference::Literal(literal.to_string()))
         } else {
             Err(SecretError::InvalidReference(format!(
-                "Secret reference must start with op://, env://, or literal://: {}",
+                "Secret reference must start with op://, env://, age://, or literal://: {}",
                 value
             )))
         }
@@ -51,9 +62,8 @@ impl SecretReference {
     pub async fn resolve(&self, provider: &dyn SecretProvider) -> Result<String, SecretError> {
         match self {
             SecretReference::OnePassword(reference) => provider.get_secret(reference).await,
-            SecretReference::Environment(var_name) => {
-                std::env::var(var_name).map_err(|_| SecretError::NotFound(var_name.clone()))
-            }
+            SecretReference::Environment(var_name) => env::EnvProvider.get_secret(var_name).await,
+            SecretReference::Age(path) => age::AgeProvider::new(None).get_secret(path).await,
             SecretReference::Literal(value) => Ok(value.clone()),
         }
     }
@@ -62,6 +72,7 @@ impl SecretReference {
         match self {
             SecretReference::OnePassword(s) => s,
             SecretReference::Environment(s) => s,
+            SecretReference::Age(s) => s,
             SecretReference::Literal(s) => s,
         }
     }
@@ -85,7 +96,8 @@ impl SecretResolver {
 
         let secret_ref = SecretReference::parse(reference)?;
         let value = secret_ref.resolve(provider).await?;
-        
+        reveal(&value);
+
         self.cache.insert(reference.to_string(), value.clone());
         Ok(value)
     }
@@ -100,4 +112,167 @@ impl SecretResolver {
         
         Ok(resolved)
     }
-}
\ No newline at end of file
+}
+
+/// Prefix of the environment variables bare secret names are read from first
+pub const DEFAULT_ENV_PREFIX: &str = "DHD_SECRET_";
+
+static ENV_PREFIX: Mutex<Option<String>> = Mutex::new(None);
+
+/// Read bare secret names from environment variables starting with `prefix`
+/// first, as `secretEnvPrefix` of `dhd.config.ts` asks
+pub fn set_env_prefix(prefix: &str) {
+    *ENV_PREFIX.lock().unwrap_or_else(|e| e.into_inner()) = Some(prefix.to_string());
+}
+
+fn env_prefix() -> String {
+    ENV_PREFIX
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .clone()
+        .unwrap_or_else(|| DEFAULT_ENV_PREFIX.to_string())
+}
+
+/// Secrets resolved for templates, by reference
+static RESOLVED: LazyLock<Mutex<HashMap<String, String>>> =
+    LazyLock::new(|| Mutex::new(HashMap::new()));
+
+/// Resolve the argument of a `{{ secret("...") }}` template tag
+///
+/// The argument is either a reference (`env://VAR`, `age://file.age`,
+/// `op://vault/item/field`) or a bare name. A bare name is looked up as the
+/// upper-cased environment variable with the prefix of [`set_env_prefix`],
+/// then without it, then as `secrets/<name>.age` under `base_dir`. Relative
+/// age paths are relative to `base_dir` too.
+///
+/// Resolved values are cached and registered with [`reveal`].
+pub fn resolve_blocking(name: &str, base_dir: &Path) -> Result<String, SecretError> {
+    let reference = if name.contains("://") {
+        SecretReference::parse(name)?
+    } else {
+        named_reference(name, base_dir)?
+    };
+    let reference = match reference {
+        SecretReference::Age(path) => {
+            let path = crate::paths::resolve(base_dir, &path);
+            SecretReference::Age(path.to_string_lossy().into_owned())
+        }
+        other => other,
+    };
+
+    let key = format!("{:?}", reference);
+    if let Some(value) = RESOLVED.lock().unwrap_or_else(|e| e.into_inner()).get(&key) {
+        return Ok(value.clone());
+    }
+
+    let runtime = tokio::runtime::Builder::new_current_thread()
+        .enable_all()
+        .build()
+        .map_err(|e| {
+            SecretError::ProviderError(format!("Failed to create async runtime: {}", e))
+        })?;
+    let provider = onepassword::OnePasswordProvider::new(None);
+    let value = runtime.block_on(reference.resolve(&provider))?;
+
+    reveal(&value);
+    RESOLVED
+        .lock()
+        .unwrap_or_else(|e| e.into_inner())
+        .insert(key, value.clone());
+    Ok(value)
+}
+
+/// Find where a bare secret name is stored
+fn named_reference(name: &str, base_dir: &Path) -> Result<SecretReference, SecretError> {
+    if name.is_empty() {
+        return Err(SecretError::InvalidReference(
+            "Empty secret name".to_string(),
+        ));
+    }
+
+    let var_name = name.to_uppercase().replace(['-', '.'], "_");
+    let mut var_names = vec![format!("{}{}", env_prefix(), var_name)];
+    if var_names[0] != var_name {
+        var_names.push(var_name);
+    }
+    if let Some(var_name) = var_names.iter().find(|var| std::env::var_os(var).is_some()) {
+        return Ok(SecretReference::Environment(var_name.clone()));
+    }
+
+    let file = base_dir.join("secrets").join(format!("{}.age", name));
+    if file.exists() {
+        return Ok(SecretReference::Age(file.to_string_lossy().into_owned()));
+    }
+
+    let vars: Vec<String> = var_names.iter().map(|var| format!("${}", var)).collect();
+    Err(SecretError::NotFound(format!(
+        "{} (set {}, or add {})",
+        name,
+        vars.join(" or "),
+        file.display()
+    )))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    #[test]
+    fn test_parse_age_reference() {
+        assert_eq!(
+            SecretReference::parse("age://secrets/token.age").unwrap(),
+            SecretReference::Age("secrets/token.age".to_string())
+        );
+    }
+
+    #[test]
+    fn test_bare_name_resolves_from_environment() {
+        unsafe {
+            std::env::set_var("DHD_RESOLVE_TEST_TOKEN", "from-env");
+        }
+
+        let value = resolve_blocking("dhd_resolve_test_token", Path::new(".")).unwrap();
+        assert_eq!(value, "from-env");
+        assert_eq!(mask("token=from-env"), format!("token={}", MASK));
+    }
+
+    #[test]
+    fn test_bare_name_prefers_the_prefixed_variable() {
+        unsafe {
+            std::env::set_var("DHD_PREFIX_TEST_TOKEN", "unprefixed");
+            std::env::set_var("DHD_SECRET_DHD_PREFIX_TEST_TOKEN", "prefixed");
+        }
+
+        assert_eq!(
+            named_reference("dhd_prefix_test_token", Path::new(".")).unwrap(),
+            SecretReference::Environment("DHD_SECRET_DHD_PREFIX_TEST_TOKEN".to_string())
+        );
+    }
+
+    #[test]
+    fn test_bare_name_falls_back_to_age_file() {
+        let temp_dir = TempDir::new().unwrap();
+
+        assert_eq!(
+            named_reference("dhd_missing_secret", temp_dir.path())
+                .err()
+                .map(|e| e.to_string()),
+            Some(format!(
+                "Secret not found: dhd_missing_secret (set $DHD_SECRET_DHD_MISSING_SECRET or $DHD_MISSING_SECRET, or add {})",
+                temp_dir
+                    .path()
+                    .join("secrets/dhd_missing_secret.age")
+                    .display()
+            ))
+        );
+
+        std::fs::create_dir(temp_dir.path().join("secrets")).unwrap();
+        let file = temp_dir.path().join("secrets/dhd_missing_secret.age");
+        std::fs::write(&file, "encrypted").unwrap();
+        assert_eq!(
+            named_reference("dhd_missing_secret", temp_dir.path()).unwrap(),
+            SecretReference::Age(file.to_string_lossy().into_owned())
+        );
+    }
+}
@@ -0,0 +1,32 @@
+//! The shell that runs command lines of modules
+//!
+//! `command` actions with their `onlyIf`, `unless`, `changedWhen` and
+//! `failedWhen` commands, `executeCommand` and module hooks all run their
+//! command line with `<shell> -c`. Each can name its shell, like `bash`, `zsh`
+//! or `fish`; the others get `shell` of `dhd.config.ts`, or `sh`.
+
+use std::sync::Mutex;
+
+/// The shell of command lines that don't name one, without a config setting
+pub const DEFAULT_SHELL: &str = "sh";
+
+static SHELL: Mutex<Option<String>> = Mutex::new(None);
+
+/// Run command lines that don't name a shell with `shell` from now on, or
+/// with `sh` again when `None`
+pub fn set_default(shell: Option<String>) {
+    *SHELL.lock().unwrap_or_else(|e| e.into_inner()) = shell;
+}
+
+/// The shell a command line runs with: its own, if it names one, or the
+/// default
+pub fn choose(shell: Option<&str>) -> String {
+    match shell {
+        Some(shell) => shell.to_string(),
+        None => SHELL
+            .lock()
+            .unwrap_or_else(|e| e.into_inner())
+            .clone()
+            .unwrap_or_else(|| DEFAULT_SHELL.to_string()),
+    }
+}
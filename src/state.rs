@@ -0,0 +1,1007 @@
+//! Record of what each apply changed, so `dhd rollback` can undo it
+//!
+//! While an apply runs, atoms report the symlinks, files and packages they
+//! create. The state file is rewritten after every change, always through a
+//! temporary file and a rename, so an interrupted apply leaves a complete
+//! record of what it did up to that point.
+
+use crate::atoms::package::PackageManager;
+use crate::logging::LoggedCommand;
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+use std::collections::{BTreeMap, HashSet};
+use std::fs;
+use std::io::Write;
+use std::path::{Path, PathBuf};
+use std::sync::Mutex;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+/// Version of the state file layout
+pub const STATE_VERSION: u32 = 1;
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct State {
+    pub version: u32,
+    /// Applies that changed something, oldest first
+    pub applies: Vec<ApplyRecord>,
+    /// Input hashes of each module's actions at its last apply without
+    /// failures, for `apply --incremental`
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub inputs: BTreeMap<String, Vec<String>>,
+}
+
+impl Default for State {
+    fn default() -> Self {
+        Self {
+            version: STATE_VERSION,
+            applies: Vec::new(),
+            inputs: BTreeMap::new(),
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "camelCase")]
+pub struct ApplyRecord {
+    pub id: String,
+    /// Seconds since the Unix epoch
+    pub started_at: u64,
+    /// Changes in the order they were made
+    pub changes: Vec<RecordedChange>,
+}
+
+/// A change along with the module whose apply made it
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct RecordedChange {
+    #[serde(flatten)]
+    pub change: Change,
+    /// Unknown for changes recorded before changes were attributed
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub module: Option<String>,
+}
+
+/// Something an apply did that can be undone
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(tag = "kind", rename_all = "camelCase")]
+pub enum Change {
+    Symlink {
+        path: PathBuf,
+        target: PathBuf,
+        previous: Previous,
+    },
+    File {
+        path: PathBuf,
+        previous: Previous,
+        #[serde(default)]
+        escalate: bool,
+        /// sha256 of the content written, for `dhd verify`; unset for actions
+        /// that only edit part of a file
+        #[serde(default, skip_serializing_if = "Option::is_none")]
+        written: Option<String>,
+    },
+    Package {
+        manager: String,
+        name: String,
+        #[serde(default)]
+        cask: bool,
+    },
+}
+
+/// What was at a path before an apply replaced it
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+#[serde(tag = "type", rename_all = "camelCase")]
+pub enum Previous {
+    /// Nothing; undoing the change removes the path
+    Absent,
+    /// A regular file, copied to `backup` before it was replaced: next to it as
+    /// `<name>.dhd-bak-<timestamp>`, or in the state directory
+    File { backup: PathBuf, sha256: String },
+    /// A symlink pointing at `target`
+    Symlink { target: PathBuf },
+    /// Something that could not be saved, such as a directory; left alone on rollback
+    NotBackedUp { reason: String },
+}
+
+/// Outcome of undoing a single change
+#[derive(Debug, Clone, PartialEq)]
+pub enum Undo {
+    Done(String),
+    Skipped(String),
+}
+
+/// Directory holding the state file and backups (`$XDG_STATE_HOME/dhd`, or
+/// `$DHD_HOME/state`)
+pub fn state_dir() -> PathBuf {
+    crate::platform::dhd_state_dir()
+}
+
+impl State {
+    pub fn path(dir: &Path) -> PathBuf {
+        dir.join("state.json")
+    }
+
+    /// Load the state file, treating a missing file as an empty history
+    pub fn load(dir: &Path) -> Result<Self, String> {
+        let path = Self::path(dir);
+        let content = match fs::read_to_string(&path) {
+            Ok(content) => content,
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(Self::default()),
+            Err(e) => return Err(format!("Failed to read {}: {}", path.display(), e)),
+        };
+
+        let state: Self = serde_json::from_str(&content)
+            .map_err(|e| format!("Failed to parse {}: {}", path.display(), e))?;
+        if state.version > STATE_VERSION {
+            return Err(format!(
+                "{} was written by a newer dhd (state version {})",
+                path.display(),
+                state.version
+            ));
+        }
+        Ok(state)
+    }
+
+    /// Whether an apply still on record wrote `path`, making it DHD's to replace
+    pub fn manages(&self, path: &Path) -> bool {
+        self.applies
+            .iter()
+            .flat_map(|apply| &apply.changes)
+            .any(|recorded| match &recorded.change {
+                Change::Symlink { path: changed, .. } | Change::File { path: changed, .. } => {
+                    changed == path
+                }
+                Change::Package { .. } => false,
+            })
+    }
+
+    /// Write the state file atomically: a temporary file is renamed over it
+    pub fn save(&self, dir: &Path) -> Result<(), String> {
+        fs::create_dir_all(dir)
+            .map_err(|e| format!("Failed to create {}: {}", dir.display(), e))?;
+
+        let path = Self::path(dir);
+        let tmp = dir.join(format!(".state.json.{}.tmp", std::process::id()));
+        let json = serde_json::to_string_pretty(self)
+            .map_err(|e| format!("Failed to serialize state: {}", e))?;
+
+        let write = || -> std::io::Result<()> {
+            let mut file = fs::File::create(&tmp)?;
+            file.write_all(json.as_bytes())?;
+            file.sync_all()?;
+            fs::rename(&tmp, &path)
+        };
+        write().map_err(|e| {
+            let _ = fs::remove_file(&tmp);
+            format!("Failed to write {}: {}", path.display(), e)
+        })
+    }
+}
+
+/// The apply currently being recorded
+struct Journal {
+    dir: PathBuf,
+    id: String,
+    started_at: u64,
+    backups: usize,
+    /// Keep backups next to the files they replace rather than only in `dir`
+    beside: bool,
+}
+
+impl Journal {
+    fn backup_dir(&self) -> PathBuf {
+        backup_dir(&self.dir, &self.id)
+    }
+
+    /// The timestamped backup next to `path`, if backups go there and it's free
+    fn beside_path(&self, path: &Path, name: &str) -> Option<PathBuf> {
+        if !self.beside {
+            return None;
+        }
+        let backup =
+            path.with_file_name(format!("{}.dhd-bak-{}", name, timestamp(self.started_at)));
+        // The same file replaced twice in one apply keeps the first backup
+        fs::symlink_metadata(&backup).is_err().then_some(backup)
+    }
+
+    fn record(&self, change: Change) -> Result<(), String> {
+        let change = RecordedChange {
+            change,
+            module: MODULE.with(|module| module.borrow().clone()),
+        };
+        let mut state = State::load(&self.dir)?;
+        match state.applies.last_mut() {
+            Some(apply) if apply.id == self.id => apply.changes.push(change),
+            _ => state.applies.push(ApplyRecord {
+                id: self.id.clone(),
+                started_at: self.started_at,
+                changes: vec![change],
+            }),
+        }
+        state.save(&self.dir)
+    }
+
+    fn preserve(&mut self, path: &Path) -> Previous {
+        let Ok(metadata) = fs::symlink_metadata(path) else {
+            return Previous::Absent;
+        };
+
+        if metadata.file_type().is_symlink() {
+            return match fs::read_link(path) {
+                Ok(target) => Previous::Symlink { target },
+                Err(e) => Previous::NotBackedUp {
+                    reason: format!("failed to read symlink: {}", e),
+                },
+            };
+        }
+        if !metadata.is_file() {
+            return Previous::NotBackedUp {
+                reason: "not a regular file".to_string(),
+            };
+        }
+
+        self.backups += 1;
+        let name = path
+            .file_name()
+            .map(|name| name.to_string_lossy().into_owned())
+            .unwrap_or_default();
+
+        let copy = || -> Result<(PathBuf, String), String> {
+            let content = fs::read(path).map_err(|e| format!("failed to read: {}", e))?;
+            if let Some(backup) = self.beside_path(path, &name) {
+                match fs::write(&backup, &content) {
+                    Ok(()) => return Ok((backup, sha256(&content))),
+                    // e.g. files in /etc that are written with sudo
+                    Err(e) => log::info!(
+                        "Can't back up {} next to it ({}), keeping the backup in {}",
+                        path.display(),
+                        e,
+                        self.backup_dir().display()
+                    ),
+                }
+            }
+
+            let backup = self.backup_dir().join(format!("{}-{}", self.backups, name));
+            fs::create_dir_all(self.backup_dir())
+                .map_err(|e| format!("failed to create backup directory: {}", e))?;
+            fs::write(&backup, &content).map_err(|e| format!("failed to write backup: {}", e))?;
+            Ok((backup, sha256(&content)))
+        };
+        match copy() {
+            Ok((backup, sha256)) => Previous::File { backup, sha256 },
+            Err(reason) => {
+                log::warn!(
+                    "{} can't be restored by rollback: {}",
+                    path.display(),
+                    reason
+                );
+                Previous::NotBackedUp { reason }
+            }
+        }
+    }
+}
+
+static JOURNAL: Mutex<Option<Journal>> = Mutex::new(None);
+
+thread_local! {
+    /// Module whose atoms this thread is running
+    static MODULE: std::cell::RefCell<Option<String>> = const { std::cell::RefCell::new(None) };
+}
+
+/// Attributes the changes recorded on this thread to a module until dropped
+pub struct Attribution(Option<String>);
+
+impl Drop for Attribution {
+    fn drop(&mut self) {
+        MODULE.with(|module| *module.borrow_mut() = self.0.take());
+    }
+}
+
+/// Attribute the changes this thread records to `module`, for `dhd uninstall`
+pub fn attribute_to(module: &str) -> Attribution {
+    Attribution(MODULE.with(|current| current.borrow_mut().replace(module.to_string())))
+}
+
+/// Records changes until dropped
+pub struct Recording(());
+
+impl Drop for Recording {
+    fn drop(&mut self) {
+        *JOURNAL.lock().unwrap_or_else(|e| e.into_inner()) = None;
+    }
+}
+
+/// Start recording changes as a new apply in the state directory `dir`
+///
+/// With `backup_beside`, replaced files are also backed up next to themselves
+/// with a timestamp, where they're easy to find without `dhd rollback`.
+pub fn start_recording(dir: PathBuf, backup_beside: bool) -> Recording {
+    let now = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .unwrap_or_default();
+    *JOURNAL.lock().unwrap_or_else(|e| e.into_inner()) = Some(Journal {
+        dir,
+        id: format!("{}-{}", now.as_millis(), std::process::id()),
+        started_at: now.as_secs(),
+        backups: 0,
+        beside: backup_beside,
+    });
+    Recording(())
+}
+
+/// Save whatever is at `path` before it is replaced
+///
+/// Returns `None` when no apply is being recorded.
+pub fn preserve(path: &Path) -> Option<Previous> {
+    let mut guard = JOURNAL.lock().unwrap_or_else(|e| e.into_inner());
+    guard.as_mut().map(|journal| journal.preserve(path))
+}
+
+/// Add a change to the apply being recorded, if any
+pub fn record(change: Change) {
+    let guard = JOURNAL.lock().unwrap_or_else(|e| e.into_inner());
+    if let Some(journal) = guard.as_ref() {
+        if let Err(e) = journal.record(change) {
+            log::warn!("Failed to record change for rollback: {}", e);
+        }
+    }
+}
+
+/// Undo every recorded change to `path`, newest first, and forget them,
+/// returning what was done
+///
+/// What an earlier apply replaced is restored, and what it created is
+/// removed. Changes that fail to undo stay recorded. Holds the journal while
+/// it runs, so changes recorded meanwhile aren't lost.
+pub fn retire(path: &Path) -> Result<Vec<String>, String> {
+    let guard = JOURNAL.lock().unwrap_or_else(|e| e.into_inner());
+    let dir = guard
+        .as_ref()
+        .map(|journal| journal.dir.clone())
+        .unwrap_or_else(state_dir);
+    let mut state = State::load(&dir)?;
+
+    let mut done = Vec::new();
+    let mut undone = Vec::new();
+    let mut result = Ok(());
+    for (a, c) in state.changes_to(path) {
+        let change = &state.applies[a].changes[c].change;
+        match change.undo(false) {
+            Ok(Undo::Done(message)) => {
+                change.remove_backup();
+                done.push(message);
+            }
+            Ok(Undo::Skipped(message)) => done.push(message),
+            Err(e) => {
+                result = Err(e);
+                break;
+            }
+        }
+        undone.push((a, c));
+    }
+
+    state.forget(&undone);
+    state.save(&dir)?;
+    result.map(|()| done)
+}
+
+fn backup_dir(dir: &Path, id: &str) -> PathBuf {
+    dir.join("backups").join(id)
+}
+
+/// Format seconds since the Unix epoch as a compact UTC timestamp, `20240101T120000`
+fn timestamp(secs: u64) -> String {
+    let days = (secs / 86400) as i64;
+    let time = secs % 86400;
+
+    // Civil date from days since the epoch (Howard Hinnant's algorithm)
+    let z = days + 719_468;
+    let era = z.div_euclid(146_097);
+    let doe = z.rem_euclid(146_097);
+    let yoe = (doe - doe / 1460 + doe / 36_524 - doe / 146_096) / 365;
+    let doy = doe - (365 * yoe + yoe / 4 - yoe / 100);
+    let mp = (5 * doy + 2) / 153;
+    let day = doy - (153 * mp + 2) / 5 + 1;
+    let month = if mp < 10 { mp + 3 } else { mp - 9 };
+    let year = yoe + era * 400 + i64::from(month <= 2);
+
+    format!(
+        "{:04}{:02}{:02}T{:02}{:02}{:02}",
+        year,
+        month,
+        day,
+        time / 3600,
+        time % 3600 / 60,
+        time % 60
+    )
+}
+
+pub(crate) fn sha256(content: &[u8]) -> String {
+    format!("{:x}", Sha256::digest(content))
+}
+
+impl ApplyRecord {
+    /// Delete the backups taken during this apply, once it has been rolled back
+    pub fn remove_backups(&self, dir: &Path) -> Result<(), String> {
+        // Backups kept next to the restored files
+        for recorded in &self.changes {
+            recorded.change.remove_backup();
+        }
+
+        let backups = backup_dir(dir, &self.id);
+        match fs::remove_dir_all(&backups) {
+            Err(e) if e.kind() != std::io::ErrorKind::NotFound => {
+                Err(format!("Failed to remove {}: {}", backups.display(), e))
+            }
+            _ => Ok(()),
+        }
+    }
+}
+
+impl State {
+    /// Positions (apply, change) of the changes recorded for `modules`, newest
+    /// first, and of those left out because another module recorded a change
+    /// to the same path or package
+    pub fn changes_of(&self, modules: &[String]) -> (Vec<(usize, usize)>, Vec<(usize, usize)>) {
+        let ours = |recorded: &RecordedChange| {
+            recorded
+                .module
+                .as_ref()
+                .is_some_and(|module| modules.contains(module))
+        };
+        let shared: HashSet<String> = self
+            .applies
+            .iter()
+            .flat_map(|apply| &apply.changes)
+            .filter(|recorded| !ours(recorded))
+            .map(|recorded| recorded.change.resource())
+            .collect();
+
+        let mut owned = Vec::new();
+        let mut kept = Vec::new();
+        for (a, apply) in self.applies.iter().enumerate().rev() {
+            for (c, recorded) in apply.changes.iter().enumerate().rev() {
+                if !ours(recorded) {
+                    continue;
+                }
+                if shared.contains(&recorded.change.resource()) {
+                    kept.push((a, c));
+                } else {
+                    owned.push((a, c));
+                }
+            }
+        }
+        (owned, kept)
+    }
+
+    /// Positions (apply, change) of the changes to paths outside `paths` and
+    /// packages outside `packages`, newest first: what no module declares
+    /// any more
+    pub fn undeclared(
+        &self,
+        paths: &HashSet<PathBuf>,
+        packages: &HashSet<String>,
+    ) -> Vec<(usize, usize)> {
+        let mut positions = Vec::new();
+        for (a, apply) in self.applies.iter().enumerate().rev() {
+            for (c, recorded) in apply.changes.iter().enumerate().rev() {
+                let declared = match &recorded.change {
+                    Change::Symlink { path, .. } | Change::File { path, .. } => {
+                        paths.contains(path)
+                    }
+                    Change::Package { name, .. } => packages.contains(name),
+                };
+                if !declared {
+                    positions.push((a, c));
+                }
+            }
+        }
+        positions
+    }
+
+    /// Positions (apply, change) of the changes to `path`, newest first
+    pub fn changes_to(&self, path: &Path) -> Vec<(usize, usize)> {
+        let mut positions = Vec::new();
+        for (a, apply) in self.applies.iter().enumerate().rev() {
+            for (c, recorded) in apply.changes.iter().enumerate().rev() {
+                if let Change::Symlink { path: changed, .. } | Change::File { path: changed, .. } =
+                    &recorded.change
+                {
+                    if changed == path {
+                        positions.push((a, c));
+                    }
+                }
+            }
+        }
+        positions
+    }
+
+    /// Forget the changes at `positions`, dropping applies left without any
+    pub fn forget(&mut self, positions: &[(usize, usize)]) {
+        for (a, apply) in self.applies.iter_mut().enumerate() {
+            let mut c = 0;
+            apply.changes.retain(|_| {
+                c += 1;
+                !positions.contains(&(a, c - 1))
+            });
+        }
+        self.applies.retain(|apply| !apply.changes.is_empty());
+    }
+}
+
+impl Change {
+    /// The path or package this change is to
+    fn resource(&self) -> String {
+        match self {
+            Change::Symlink { path, .. } | Change::File { path, .. } => {
+                path.to_string_lossy().into_owned()
+            }
+            Change::Package { manager, name, .. } => format!("{}:{}", manager, name),
+        }
+    }
+
+    /// Delete the backup of what this change replaced, once it has been restored
+    pub fn remove_backup(&self) {
+        if let Change::Symlink {
+            previous: Previous::File { backup, .. },
+            ..
+        }
+        | Change::File {
+            previous: Previous::File { backup, .. },
+            ..
+        } = self
+        {
+            let _ = fs::remove_file(backup);
+        }
+    }
+
+    pub fn describe(&self) -> String {
+        match self {
+            Change::Symlink { path, target, .. } => {
+                format!("symlink {} -> {}", path.display(), target.display())
+            }
+            Change::File { path, .. } => format!("file {}", path.display()),
+            Change::Package {
+                manager,
+                name,
+                cask: true,
+            } => format!("{} cask {}", manager, name),
+            Change::Package { manager, name, .. } => format!("{} package {}", manager, name),
+        }
+    }
+
+    /// Reverse this change; packages are only uninstalled when asked to
+    pub fn undo(&self, uninstall_packages: bool) -> Result<Undo, String> {
+        match self {
+            Change::Symlink {
+                path,
+                target,
+                previous,
+            } => {
+                if let Ok(current) = fs::read_link(path) {
+                    if &current != target {
+                        return Ok(Undo::Skipped(format!(
+                            "{} now points to {}, leaving it alone",
+                            path.display(),
+                            current.display()
+                        )));
+                    }
+                    fs::remove_file(path)
+                        .map_err(|e| format!("Failed to remove {}: {}", path.display(), e))?;
+                }
+                restore(path, previous, false)
+            }
+            Change::File {
+                path,
+                previous,
+                escalate,
+                ..
+            } => restore(path, previous, *escalate),
+            Change::Package {
+                manager,
+                name,
+                cask,
+            } => {
+                if !uninstall_packages {
+                    return Ok(Undo::Skipped(format!(
+                        "{} left installed (pass --packages to remove it)",
+                        name
+                    )));
+                }
+
+                let manager: PackageManager = manager.parse()?;
+                let _backend_lock = manager.lock()?;
+                let provider: Box<dyn crate::atoms::package::PackageProvider> = if *cask {
+                    Box::new(crate::atoms::package::brew::BrewProvider::new(
+                        true,
+                        Vec::new(),
+                    ))
+                } else {
+                    manager.get_provider()
+                };
+                provider.uninstall_package(name)?;
+                Ok(Undo::Done(format!("Uninstalled {}", self.describe())))
+            }
+        }
+    }
+}
+
+/// Put back what was at `path` before the apply
+fn restore(path: &Path, previous: &Previous, escalate: bool) -> Result<Undo, String> {
+    match previous {
+        Previous::Absent => {
+            if fs::symlink_metadata(path).is_err() {
+                return Ok(Undo::Done(format!("{} already removed", path.display())));
+            }
+            if escalate {
+                run_escalated(&["rm", "-f", &path.to_string_lossy()])?;
+            } else {
+                fs::remove_file(path)
+                    .map_err(|e| format!("Failed to remove {}: {}", path.display(), e))?;
+            }
+            Ok(Undo::Done(format!("Removed {}", path.display())))
+        }
+        Previous::File {
+            backup,
+            sha256: expected,
+        } => {
+            let content = fs::read(backup)
+                .map_err(|e| format!("Failed to read backup {}: {}", backup.display(), e))?;
+            if &sha256(&content) != expected {
+                return Err(format!(
+                    "Backup {} does not match its recorded hash",
+                    backup.display()
+                ));
+            }
+
+            if escalate {
+                run_escalated(&["cp", &backup.to_string_lossy(), &path.to_string_lossy()])?;
+            } else {
+                // Don't write through a symlink that has since replaced the file
+                if path.is_symlink() {
+                    let _ = fs::remove_file(path);
+                }
+                fs::write(path, &content)
+                    .map_err(|e| format!("Failed to restore {}: {}", path.display(), e))?;
+            }
+            Ok(Undo::Done(format!("Restored {}", path.display())))
+        }
+        Previous::Symlink { target } => {
+            if fs::read_link(path).is_ok_and(|current| &current == target) {
+                return Ok(Undo::Done(format!("{} already restored", path.display())));
+            }
+            if fs::symlink_metadata(path).is_ok() {
+                fs::remove_file(path)
+                    .map_err(|e| format!("Failed to remove {}: {}", path.display(), e))?;
+            }
+            symlink(target, path)?;
+            Ok(Undo::Done(format!(
+                "Restored symlink {} -> {}",
+                path.display(),
+                target.display()
+            )))
+        }
+        Previous::NotBackedUp { reason } => Ok(Undo::Skipped(format!(
+            "{} was not backed up ({}), leaving it alone",
+            path.display(),
+            reason
+        ))),
+    }
+}
+
+fn symlink(target: &Path, path: &Path) -> Result<(), String> {
+    #[cfg(unix)]
+    {
+        std::os::unix::fs::symlink(target, path).map_err(|e| {
+            format!(
+                "Failed to restore symlink {} -> {}: {}",
+                path.display(),
+                target.display(),
+                e
+            )
+        })
+    }
+
+    #[cfg(not(unix))]
+    {
+        let _ = (target, path);
+        Err("Symlink creation is only supported on Unix systems".to_string())
+    }
+}
+
+fn run_escalated(args: &[&str]) -> Result<(), String> {
+    let output = crate::privilege::root_command(args[0])?
+        .args(&args[1..])
+        .logged_output()
+        .map_err(|e| format!("Failed to run {}: {}", args.join(" "), e))?;
+
+    if !output.status.success() {
+        return Err(format!(
+            "{} failed: {}",
+            args.join(" "),
+            String::from_utf8_lossy(&output.stderr).trim()
+        ));
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tempfile::TempDir;
+
+    // The journal is global, so tests that record share this lock
+    static SERIAL: Mutex<()> = Mutex::new(());
+
+    #[test]
+    fn test_missing_state_is_empty() {
+        let temp_dir = TempDir::new().unwrap();
+        assert_eq!(State::load(temp_dir.path()).unwrap(), State::default());
+    }
+
+    #[test]
+    fn test_save_replaces_state_atomically() {
+        let temp_dir = TempDir::new().unwrap();
+        let mut state = State::default();
+        state.applies.push(ApplyRecord {
+            id: "1".to_string(),
+            started_at: 0,
+            changes: vec![RecordedChange {
+                change: Change::Package {
+                    manager: "apt".to_string(),
+                    name: "ripgrep".to_string(),
+                    cask: false,
+                },
+                module: Some("tools".to_string()),
+            }],
+        });
+
+        state.save(temp_dir.path()).unwrap();
+        state.save(temp_dir.path()).unwrap();
+
+        assert_eq!(State::load(temp_dir.path()).unwrap(), state);
+        let entries: Vec<_> = fs::read_dir(temp_dir.path()).unwrap().collect();
+        assert_eq!(entries.len(), 1, "temporary files should be renamed away");
+    }
+
+    #[test]
+    fn test_corrupt_state_is_an_error() {
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(State::path(temp_dir.path()), "{ not json").unwrap();
+        assert!(State::load(temp_dir.path()).is_err());
+    }
+
+    #[test]
+    fn test_nothing_is_recorded_outside_an_apply() {
+        let _serial = SERIAL.lock().unwrap_or_else(|e| e.into_inner());
+        let temp_dir = TempDir::new().unwrap();
+        fs::write(temp_dir.path().join("file"), "content").unwrap();
+
+        assert_eq!(preserve(&temp_dir.path().join("file")), None);
+    }
+
+    #[test]
+    #[cfg(unix)]
+    fn test_record_and_undo_file_and_symlink() {
+        let _serial = SERIAL.lock().unwrap_or_else(|e| e.into_inner());
+        let temp_dir = TempDir::new().unwrap();
+        let state_dir = temp_dir.path().join("state");
+        let config = temp_dir.path().join("config");
+        let link = temp_dir.path().join("link");
+        fs::write(&config, "original").unwrap();
+
+        {
+            let _recording = start_recording(state_dir.clone(), false);
+
+            let previous = preserve(&config).unwrap();
+            fs::write(&config, "managed").unwrap();
+            record(Change::File {
+                path: config.clone(),
+                previous,
+                escalate: false,
+                written: None,
+            });
+
+            let _module = attribute_to("dotfiles");
+            let previous = preserve(&link).unwrap();
+            std::os::unix::fs::symlink(&config, &link).unwrap();
+            record(Change::Symlink {
+                path: link.clone(),
+                target: config.clone(),
+                previous,
+            });
+        }
+
+        let state = State::load(&state_dir).unwrap();
+        assert_eq!(state.applies.len(), 1);
+        let changes = &state.applies[0].changes;
+        assert_eq!(changes.len(), 2);
+        assert_eq!(changes[0].module, None);
+        assert_eq!(changes[1].module.as_deref(), Some("dotfiles"));
+        assert!(matches!(
+            &changes[1].change,
+            Change::Symlink {
+                previous: Previous::Absent,
+                ..
+            }
+        ));
+
+        for recorded in changes.iter().rev() {
+            assert!(matches!(recorded.change.undo(false), Ok(Undo::Done(_))));
+        }
+        assert!(fs::symlink_metadata(&link).is_err());
+        assert_eq!(fs::read_to_string(&config).unwrap(), "original");
+
+        // Undoing twice is harmless
+        assert!(matches!(changes[1].change.undo(false), Ok(Undo::Done(_))));
+    }
+
+    #[test]
+    fn test_replaced_files_are_backed_up_beside_themselves() {
+        let _serial = SERIAL.lock().unwrap_or_else(|e| e.into_inner());
+        let temp_dir = TempDir::new().unwrap();
+        let state_dir = temp_dir.path().join("state");
+        let config = temp_dir.path().join("config");
+        fs::write(&config, "original").unwrap();
+
+        let previous = {
+            let _recording = start_recording(state_dir.clone(), true);
+            let previous = preserve(&config).unwrap();
+            fs::write(&config, "managed").unwrap();
+            previous
+        };
+
+        let Previous::File { backup, .. } = &previous else {
+            panic!("expected a backup, got {:?}", previous);
+        };
+        assert_eq!(backup.parent(), Some(temp_dir.path()));
+        let name = backup.file_name().unwrap().to_string_lossy().into_owned();
+        assert!(name.starts_with("config.dhd-bak-"), "{}", name);
+        assert_eq!(fs::read_to_string(backup).unwrap(), "original");
+
+        let change = Change::File {
+            path: config.clone(),
+            previous: previous.clone(),
+            escalate: false,
+            written: None,
+        };
+        assert!(matches!(change.undo(false), Ok(Undo::Done(_))));
+        assert_eq!(fs::read_to_string(&config).unwrap(), "original");
+
+        let apply = ApplyRecord {
+            id: "1".to_string(),
+            started_at: 0,
+            changes: vec![RecordedChange {
+                change,
+                module: None,
+            }],
+        };
+        apply.remove_backups(&state_dir).unwrap();
+        assert!(!backup.exists());
+    }
+
+    #[test]
+    fn test_changes_of_a_module_leave_shared_ones_out() {
+        let package = |name: &str, module: &str| RecordedChange {
+            change: Change::Package {
+                manager: "apt".to_string(),
+                name: name.to_string(),
+                cask: false,
+            },
+            module: Some(module.to_string()),
+        };
+        let apply = |id: &str, changes| ApplyRecord {
+            id: id.to_string(),
+            started_at: 0,
+            changes,
+        };
+        let mut state = State::default();
+        state.applies.push(apply(
+            "1",
+            vec![package("git", "base"), package("ripgrep", "tools")],
+        ));
+        state.applies.push(apply(
+            "2",
+            vec![package("fd", "tools"), package("git", "tools")],
+        ));
+
+        let (owned, kept) = state.changes_of(&["tools".to_string()]);
+        assert_eq!(owned, vec![(1, 0), (0, 1)]);
+        assert_eq!(kept, vec![(1, 1)]);
+
+        state.forget(&[(1, 0), (1, 1)]);
+        assert_eq!(state.applies.len(), 1);
+        assert_eq!(state.applies[0].changes.len(), 2);
+    }
+
+    #[test]
+    fn test_undeclared_changes_are_the_ones_to_prune() {
+        let symlink = |path: &str| RecordedChange {
+            change: Change::Symlink {
+                path: PathBuf::from(path),
+                target: PathBuf::from("/dotfiles/file"),
+                previous: Previous::Absent,
+            },
+            module: None,
+        };
+        let package = RecordedChange {
+            change: Change::Package {
+                manager: "apt".to_string(),
+                name: "ripgrep".to_string(),
+                cask: false,
+            },
+            module: Some("tools".to_string()),
+        };
+        let mut state = State::default();
+        state.applies.push(ApplyRecord {
+            id: "1".to_string(),
+            started_at: 0,
+            changes: vec![symlink("/home/me/.vimrc"), package],
+        });
+        state.applies.push(ApplyRecord {
+            id: "2".to_string(),
+            started_at: 0,
+            changes: vec![symlink("/home/me/.zshrc")],
+        });
+
+        let paths = HashSet::from([PathBuf::from("/home/me/.zshrc")]);
+        assert_eq!(
+            state.undeclared(&paths, &HashSet::new()),
+            vec![(0, 1), (0, 0)]
+        );
+        let packages = HashSet::from(["ripgrep".to_string()]);
+        assert_eq!(state.undeclared(&paths, &packages), vec![(0, 0)]);
+
+        assert_eq!(state.changes_to(Path::new("/home/me/.zshrc")), vec![(1, 0)]);
+        assert!(state.changes_to(Path::new("/home/me/.bashrc")).is_empty());
+    }
+
+    #[test]
+    fn test_timestamp_is_compact_utc() {
+        assert_eq!(timestamp(0), "19700101T000000");
+        assert_eq!(timestamp(1_709_210_096), "20240229T123456");
+    }
+
+    #[test]
+    #[cfg(unix)]
+    fn test_undo_leaves_relinked_symlink_alone() {
+        let temp_dir = TempDir::new().unwrap();
+        let link = temp_dir.path().join("link");
+        std::os::unix::fs::symlink("/somewhere/else", &link).unwrap();
+
+        let change = Change::Symlink {
+            path: link.clone(),
+            target: PathBuf::from("/dotfiles/config"),
+            previous: Previous::Absent,
+        };
+        assert!(matches!(change.undo(false), Ok(Undo::Skipped(_))));
+        assert!(link.is_symlink());
+    }
+
+    #[test]
+    fn test_undo_rejects_tampered_backup() {
+        let temp_dir = TempDir::new().unwrap();
+        let backup = temp_dir.path().join("backup");
+        fs::write(&backup, "changed").unwrap();
+
+        let change = Change::File {
+            path: temp_dir.path().join("config"),
+            previous: Previous::File {
+                backup,
+                sha256: sha256(b"original"),
+            },
+            escalate: false,
+            written: None,
+        };
+        assert!(change.undo(false).is_err());
+    }
+
+    #[test]
+    fn test_packages_are_kept_unless_requested() {
+        let change = Change::Package {
+            manager: "apt".to_string(),
+            name: "ripgrep".to_string(),
+            cask: false,
+        };
+        assert!(matches!(change.undo(false), Ok(Undo::Skipped(_))));
+    }
+}
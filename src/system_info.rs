@@ -1,17 +1,28 @@
 use dhd_macros::typescript_type;
 use serde::Serialize;
+use std::collections::HashMap;
+use std::sync::OnceLock;
 use sysinfo::System;
 
 /// System information available to conditions
 #[derive(Default, Serialize)]
 #[typescript_type]
 pub struct SystemInfo {
+    pub host: HostInfo,
     pub os: OsInfo,
     pub hardware: HardwareInfo,
     pub auth: AuthInfo,
     pub user: UserInfo,
 }
 
+#[derive(Default, Serialize)]
+#[typescript_type]
+pub struct HostInfo {
+    pub hostname: String,
+    pub os: String,          // "linux", "macos", etc.
+    pub arch: String,        // "x86_64", "aarch64", etc.
+}
+
 #[derive(Default, Serialize)]
 #[typescript_type]
 pub struct OsInfo {
@@ -44,9 +55,64 @@ pub struct UserInfo {
     pub home: String,
 }
 
+/// Host facts gathered once per run and shared by conditions and templates
+pub fn facts() -> &'static SystemInfo {
+    static FACTS: OnceLock<SystemInfo> = OnceLock::new();
+    FACTS.get_or_init(get_system_info)
+}
+
+/// Facts flattened to dotted names such as `host.hostname` or `os.family`
+pub fn fact_variables() -> HashMap<String, String> {
+    let mut variables = HashMap::new();
+    if let Ok(value) = serde_json::to_value(facts()) {
+        flatten_facts(&value, "", &mut variables);
+    }
+    variables
+}
+
+/// The dotted names of every fact, whether or not this host has a value
+pub fn fact_names() -> Vec<String> {
+    let mut variables = HashMap::new();
+    if let Ok(value) = serde_json::to_value(SystemInfo::default()) {
+        flatten_facts(&value, "", &mut variables);
+    }
+    variables.into_keys().collect()
+}
+
+fn flatten_facts(value: &serde_json::Value, prefix: &str, out: &mut HashMap<String, String>) {
+    match value {
+        serde_json::Value::Object(map) => {
+            for (key, value) in map {
+                let path = if prefix.is_empty() {
+                    key.clone()
+                } else {
+                    format!("{}.{}", prefix, key)
+                };
+                flatten_facts(value, &path, out);
+            }
+        }
+        serde_json::Value::String(s) => {
+            out.insert(prefix.to_string(), s.clone());
+        }
+        other => {
+            out.insert(prefix.to_string(), other.to_string());
+        }
+    }
+}
+
 /// Get current system information
 pub fn get_system_info() -> SystemInfo {
     let mut info = SystemInfo::default();
+
+    info.host.hostname = System::host_name()
+        .or_else(|| {
+            std::fs::read_to_string("/etc/hostname")
+                .ok()
+                .map(|name| name.trim().to_string())
+        })
+        .unwrap_or_default();
+    info.host.os = std::env::consts::OS.to_string();
+    info.host.arch = crate::platform::current_arch();
     
     // Use os_info for better OS detection
     let os = os_info::get();
@@ -80,6 +146,19 @@ pub fn get_system_info() -> SystemInfo {
     
     info.os.version = os.version().to_string();
     info.os.codename = os.codename().unwrap_or_default().to_string();
+
+    // A stand-in os-release file wins over what the host says
+    if let Some(content) = crate::platform::os_release_override() {
+        let field = |key| crate::platform::os_release_field(&content, key).unwrap_or_default();
+        info.os.distro = field("ID");
+        info.os.family = field("ID_LIKE")
+            .split_whitespace()
+            .last()
+            .map(String::from)
+            .unwrap_or_else(|| info.os.distro.clone());
+        info.os.version = field("VERSION_ID");
+        info.os.codename = field("VERSION_CODENAME");
+    }
     
     // Detect hardware
     // Check for TPM
@@ -123,9 +202,33 @@ pub fn get_system_info() -> SystemInfo {
     info.user.name = std::env::var("USER").unwrap_or_default();
     info.user.home = std::env::var("HOME").unwrap_or_default();
     info.user.shell = std::env::var("SHELL").unwrap_or_default();
+    if let Some(user) = crate::target_user::target() {
+        info.user.name = user.name.clone();
+        info.user.home = user.home.display().to_string();
+    }
     
     // Use sysinfo for additional system information if needed in the future
     let mut _sys = System::new();
     
     info
-}
\ No newline at end of file
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_fact_variables_are_flattened() {
+        let variables = fact_variables();
+        assert_eq!(
+            variables.get("host.os").map(String::as_str),
+            Some(std::env::consts::OS)
+        );
+        assert_eq!(
+            variables.get("host.arch").map(String::as_str),
+            Some(std::env::consts::ARCH)
+        );
+        assert!(variables.contains_key("os.family"));
+        assert!(variables.contains_key("hardware.tpm"));
+    }
+}
@@ -0,0 +1,214 @@
+//! Applying modules for another user, with `--target-user`
+//!
+//! DHD runs as root and acts as the target user for every action that doesn't
+//! set `become`: `~` and the XDG directories are the target's, commands and
+//! systemd `--user` operations run as them, and the files, directories and
+//! symlinks created in their home are theirs. Actions with `become` still act
+//! as root, so one run can set up both the system and the user's home.
+
+use std::ffi::OsStr;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+use std::sync::OnceLock;
+
+static TARGET: OnceLock<TargetUser> = OnceLock::new();
+
+/// The user that `--target-user` names
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TargetUser {
+    pub name: String,
+    pub uid: u32,
+    pub gid: u32,
+    pub home: PathBuf,
+}
+
+impl TargetUser {
+    /// The user `name` as the user database has them
+    pub fn lookup(name: &str) -> Result<Self, String> {
+        let entry = crate::paths::passwd_entry(name)
+            .ok_or_else(|| format!("there is no user named '{}'", name))?;
+        Self::from_passwd(&entry)
+            .ok_or_else(|| format!("the user database has no uid, gid and home for '{}'", name))
+    }
+
+    /// The user of a `name:password:uid:gid:gecos:home:shell` line
+    fn from_passwd(entry: &str) -> Option<Self> {
+        let fields: Vec<&str> = entry.trim().split(':').collect();
+        let home = fields.get(5).filter(|home| !home.is_empty())?;
+        Some(Self {
+            name: fields.first()?.to_string(),
+            uid: fields.get(2)?.parse().ok()?,
+            gid: fields.get(3)?.parse().ok()?,
+            home: PathBuf::from(home),
+        })
+    }
+
+    /// Make `cmd` run as this user, with their environment
+    fn apply(&self, cmd: &mut Command) {
+        #[cfg(unix)]
+        {
+            use std::os::unix::process::CommandExt;
+            cmd.uid(self.uid).gid(self.gid);
+        }
+        let runtime_dir = format!("/run/user/{}", self.uid);
+        cmd.env("HOME", &self.home)
+            .env("USER", &self.name)
+            .env("LOGNAME", &self.name)
+            .env(
+                "DBUS_SESSION_BUS_ADDRESS",
+                format!("unix:path={}/bus", runtime_dir),
+            )
+            .env("XDG_RUNTIME_DIR", runtime_dir);
+        for var in [
+            "XDG_CONFIG_HOME",
+            "XDG_DATA_HOME",
+            "XDG_STATE_HOME",
+            "XDG_CACHE_HOME",
+        ] {
+            cmd.env_remove(var);
+        }
+    }
+
+    /// Whether `path` is in this user's home
+    fn owns(&self, path: &Path) -> bool {
+        path.starts_with(&self.home)
+    }
+}
+
+/// Apply for the user `name`, which needs DHD to run as root
+pub fn set_target(name: &str) -> Result<(), String> {
+    if !crate::privilege::is_root() {
+        return Err("applying for another user needs root".to_string());
+    }
+    let user = TargetUser::lookup(name)?;
+    TARGET
+        .set(user)
+        .map_err(|_| "the target user is already set".to_string())
+}
+
+/// The user `--target-user` names, if given
+pub fn target() -> Option<&'static TargetUser> {
+    TARGET.get()
+}
+
+/// A command running `program` as the target user, or as DHD's user without
+/// one
+pub fn command(program: impl AsRef<OsStr>) -> Command {
+    let mut cmd = Command::new(program);
+    if let Some(user) = target() {
+        user.apply(&mut cmd);
+    }
+    cmd
+}
+
+/// Give `path`, which DHD just created, to the target user if it's in
+/// their home; a symlink itself is given, not what it points to
+pub fn give(path: &Path) -> Result<(), String> {
+    let Some(user) = target() else {
+        return Ok(());
+    };
+    if !user.owns(path) {
+        return Ok(());
+    }
+    #[cfg(unix)]
+    std::os::unix::fs::lchown(path, Some(user.uid), Some(user.gid))
+        .map_err(|e| format!("Failed to give {} to {}: {}", path.display(), user.name, e))?;
+    Ok(())
+}
+
+/// [`give`] for `path` and, if it's a directory, everything in it
+pub fn give_all(path: &Path) -> Result<(), String> {
+    if target().is_none() {
+        return Ok(());
+    }
+    give(path)?;
+    if path.is_dir() && !path.is_symlink() {
+        let entries = std::fs::read_dir(path)
+            .map_err(|e| format!("Failed to read {}: {}", path.display(), e))?;
+        for entry in entries.flatten() {
+            give_all(&entry.path())?;
+        }
+    }
+    Ok(())
+}
+
+/// `fs::create_dir_all`, giving the directories it creates to the target user
+pub fn create_dir_all(path: &Path) -> std::io::Result<()> {
+    let Some(user) = target() else {
+        return std::fs::create_dir_all(path);
+    };
+    let missing: Vec<PathBuf> = path
+        .ancestors()
+        .take_while(|dir| !dir.as_os_str().is_empty() && !dir.exists())
+        .filter(|dir| user.owns(dir))
+        .map(Path::to_path_buf)
+        .collect();
+    std::fs::create_dir_all(path)?;
+    for dir in missing {
+        #[cfg(unix)]
+        std::os::unix::fs::lchown(&dir, Some(user.uid), Some(user.gid))?;
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn deploy() -> TargetUser {
+        TargetUser {
+            name: "deploy".to_string(),
+            uid: 1001,
+            gid: 1001,
+            home: PathBuf::from("/home/deploy"),
+        }
+    }
+
+    #[test]
+    fn test_target_user_from_passwd() {
+        assert_eq!(
+            TargetUser::from_passwd("deploy:x:1001:1001:Deploy:/home/deploy:/bin/sh\n"),
+            Some(deploy())
+        );
+        assert_eq!(
+            TargetUser::from_passwd("deploy:x:1001:1001:Deploy::/bin/sh"),
+            None
+        );
+        assert_eq!(
+            TargetUser::from_passwd("deploy:x:none:1001:Deploy:/home/deploy:/bin/sh"),
+            None
+        );
+        assert!(TargetUser::lookup("dhd-no-such-user").is_err());
+    }
+
+    #[test]
+    fn test_only_paths_in_the_home_are_given_to_the_user() {
+        let user = deploy();
+        assert!(user.owns(Path::new("/home/deploy/.config/app.toml")));
+        assert!(user.owns(Path::new("/home/deploy")));
+        assert!(!user.owns(Path::new("/home/deployer/.profile")));
+        assert!(!user.owns(Path::new("/etc/app.toml")));
+    }
+
+    #[test]
+    fn test_commands_run_with_the_users_environment() {
+        let mut cmd = Command::new("env");
+        deploy().apply(&mut cmd);
+        let envs: Vec<(String, Option<String>)> = cmd
+            .get_envs()
+            .map(|(key, value)| {
+                (
+                    key.to_string_lossy().into_owned(),
+                    value.map(|value| value.to_string_lossy().into_owned()),
+                )
+            })
+            .collect();
+        assert!(envs.contains(&("HOME".to_string(), Some("/home/deploy".to_string()))));
+        assert!(envs.contains(&("USER".to_string(), Some("deploy".to_string()))));
+        assert!(envs.contains(&(
+            "XDG_RUNTIME_DIR".to_string(),
+            Some("/run/user/1001".to_string())
+        )));
+        assert!(envs.contains(&("XDG_CONFIG_HOME".to_string(), None)));
+    }
+}
@@ -0,0 +1,485 @@
+//! Minimal handlebars-like template rendering
+//!
+//! Supports `{{ var }}` substitution, `{{ secret("name") }}` lookups,
+//! `{{#if var}}...{{else}}...{{/if}}` blocks and `{{ include "file" }}` of
+//! other templates, which see the same variables.
+//! Substituting an unknown variable is an error; an unknown variable in an `#if`
+//! condition is treated as false so optional flags can be left undeclared.
+
+use std::collections::HashMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+#[derive(Debug, Clone, PartialEq)]
+enum Token {
+    Text(String),
+    Variable(String),
+    Secret(String),
+    Include(String),
+    If(String),
+    Else,
+    EndIf,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Node {
+    Text(String),
+    Variable(String),
+    Secret(String),
+    Include(String),
+    If {
+        condition: String,
+        then: Vec<Node>,
+        otherwise: Vec<Node>,
+    },
+}
+
+/// Render a template string using the provided variables
+///
+/// Templates that use `secret(...)` fail; see [`render_with_secrets`].
+pub fn render(template: &str, variables: &HashMap<String, String>) -> Result<String, String> {
+    render_with_secrets(template, variables, &|name| {
+        Err(format!("Secret '{}' is not available here", name))
+    })
+}
+
+/// Render a template string, looking up `secret("name")` tags with `secret`
+///
+/// Templates that use `include` fail; see [`render_with_includes`].
+pub fn render_with_secrets(
+    template: &str,
+    variables: &HashMap<String, String>,
+    secret: &dyn Fn(&str) -> Result<String, String>,
+) -> Result<String, String> {
+    let mut context = Context {
+        variables,
+        secret,
+        dir: None,
+        including: Vec::new(),
+    };
+    render_template(template, &mut context)
+}
+
+/// Render a template like [`render_with_secrets`], reading the files of
+/// `{{ include "file" }}` relative to `dir`
+///
+/// `source` is the template's own file, if it has one, so that a file
+/// including it back is reported as a cycle.
+pub fn render_with_includes(
+    template: &str,
+    variables: &HashMap<String, String>,
+    secret: &dyn Fn(&str) -> Result<String, String>,
+    dir: &Path,
+    source: Option<&Path>,
+) -> Result<String, String> {
+    let mut context = Context {
+        variables,
+        secret,
+        dir: Some(dir),
+        including: source.map(canonical).into_iter().collect(),
+    };
+    render_template(template, &mut context)
+}
+
+/// What rendering a template and the ones it includes share
+struct Context<'a> {
+    variables: &'a HashMap<String, String>,
+    secret: &'a dyn Fn(&str) -> Result<String, String>,
+    /// Where included files are read from, if anywhere
+    dir: Option<&'a Path>,
+    /// The files being rendered, outermost first
+    including: Vec<PathBuf>,
+}
+
+fn render_template(template: &str, context: &mut Context) -> Result<String, String> {
+    let tokens = tokenize(template)?;
+    let mut iter = tokens.into_iter();
+    let (nodes, terminator) = parse_block(&mut iter)?;
+
+    match terminator {
+        Some(Token::Else) => return Err("Unexpected 'else' outside of an 'if' block".to_string()),
+        Some(Token::EndIf) => return Err("Unexpected '/if' without a matching '#if'".to_string()),
+        _ => {}
+    }
+
+    let mut output = String::with_capacity(template.len());
+    render_nodes(&nodes, context, &mut output)?;
+    Ok(output)
+}
+
+/// `path` with symlinks and `..` resolved, so each file has one name
+fn canonical(path: &Path) -> PathBuf {
+    fs::canonicalize(path).unwrap_or_else(|_| path.to_path_buf())
+}
+
+fn tokenize(template: &str) -> Result<Vec<Token>, String> {
+    let mut tokens = Vec::new();
+    let mut rest = template;
+
+    while let Some(start) = rest.find("{{") {
+        if start > 0 {
+            tokens.push(Token::Text(rest[..start].to_string()));
+        }
+
+        let after = &rest[start + 2..];
+        let end = after
+            .find("}}")
+            .ok_or_else(|| "Unclosed '{{' in template".to_string())?;
+        let tag = after[..end].trim();
+
+        if tag == "#if" || tag.starts_with("#if ") {
+            let condition = tag["#if".len()..].trim();
+            if condition.is_empty() {
+                return Err("'#if' requires a variable name".to_string());
+            }
+            tokens.push(Token::If(condition.to_string()));
+        } else if tag == "else" {
+            tokens.push(Token::Else);
+        } else if tag == "/if" {
+            tokens.push(Token::EndIf);
+        } else if tag.is_empty() {
+            return Err("Empty '{{ }}' tag in template".to_string());
+        } else if let Some(args) = tag.strip_prefix("secret(") {
+            tokens.push(Token::Secret(parse_secret_name(args)?));
+        } else if tag == "include" || tag.starts_with("include ") {
+            let file = unquote(tag["include".len()..].trim())
+                .filter(|file| !file.is_empty())
+                .ok_or_else(|| format!("Expected include \"file\", got '{}'", tag))?;
+            tokens.push(Token::Include(file.to_string()));
+        } else {
+            tokens.push(Token::Variable(tag.to_string()));
+        }
+
+        rest = &after[end + 2..];
+    }
+
+    if !rest.is_empty() {
+        tokens.push(Token::Text(rest.to_string()));
+    }
+
+    Ok(tokens)
+}
+
+/// Parse the quoted name in `secret("name")`, given everything after the `(`
+fn parse_secret_name(args: &str) -> Result<String, String> {
+    let name = args
+        .strip_suffix(')')
+        .map(str::trim)
+        .and_then(unquote)
+        .ok_or_else(|| format!("Expected secret(\"name\"), got 'secret({}'", args))?;
+
+    if name.is_empty() {
+        return Err("secret() requires a name".to_string());
+    }
+    Ok(name.to_string())
+}
+
+/// The text between the double or single quotes around `quoted`
+fn unquote(quoted: &str) -> Option<&str> {
+    quoted
+        .strip_prefix('"')
+        .and_then(|rest| rest.strip_suffix('"'))
+        .or_else(|| {
+            quoted
+                .strip_prefix('\'')
+                .and_then(|rest| rest.strip_suffix('\''))
+        })
+}
+
+/// Parse tokens until the end of input or an `else`/`/if` terminator
+fn parse_block<I: Iterator<Item = Token>>(
+    tokens: &mut I,
+) -> Result<(Vec<Node>, Option<Token>), String> {
+    let mut nodes = Vec::new();
+
+    while let Some(token) = tokens.next() {
+        match token {
+            Token::Text(text) => nodes.push(Node::Text(text)),
+            Token::Variable(name) => nodes.push(Node::Variable(name)),
+            Token::Secret(name) => nodes.push(Node::Secret(name)),
+            Token::Include(file) => nodes.push(Node::Include(file)),
+            Token::If(condition) => {
+                let (then, terminator) = parse_block(tokens)?;
+                let otherwise = match terminator {
+                    Some(Token::EndIf) => Vec::new(),
+                    Some(Token::Else) => {
+                        let (otherwise, terminator) = parse_block(tokens)?;
+                        if terminator != Some(Token::EndIf) {
+                            return Err(format!(
+                                "Missing '/if' for condition '{}'",
+                                condition
+                            ));
+                        }
+                        otherwise
+                    }
+                    _ => {
+                        return Err(format!("Missing '/if' for condition '{}'", condition));
+                    }
+                };
+                nodes.push(Node::If {
+                    condition,
+                    then,
+                    otherwise,
+                });
+            }
+            other @ (Token::Else | Token::EndIf) => return Ok((nodes, Some(other))),
+        }
+    }
+
+    Ok((nodes, None))
+}
+
+fn render_nodes(nodes: &[Node], context: &mut Context, output: &mut String) -> Result<(), String> {
+    for node in nodes {
+        match node {
+            Node::Text(text) => output.push_str(text),
+            Node::Variable(name) => {
+                let value = context
+                    .variables
+                    .get(name)
+                    .ok_or_else(|| format!("Unknown variable '{}'", name))?;
+                output.push_str(value);
+            }
+            Node::Secret(name) => output.push_str(&(context.secret)(name)?),
+            Node::Include(file) => output.push_str(&render_include(file, context)?),
+            Node::If {
+                condition,
+                then,
+                otherwise,
+            } => {
+                if is_truthy(context.variables.get(condition)) {
+                    render_nodes(then, context, output)?;
+                } else {
+                    render_nodes(otherwise, context, output)?;
+                }
+            }
+        }
+    }
+
+    Ok(())
+}
+
+/// Render the template `file` includes, with the same variables
+fn render_include(file: &str, context: &mut Context) -> Result<String, String> {
+    let Some(dir) = context.dir else {
+        return Err(format!("Can't include '{}' here", file));
+    };
+    let path = dir.join(file);
+    let resolved = canonical(&path);
+    if context.including.contains(&resolved) {
+        let cycle: Vec<String> = context
+            .including
+            .iter()
+            .skip_while(|including| **including != resolved)
+            .chain(std::iter::once(&resolved))
+            .map(|including| including.display().to_string())
+            .collect();
+        return Err(format!("Cyclic include: {}", cycle.join(" -> ")));
+    }
+
+    let template = fs::read_to_string(&path)
+        .map_err(|e| format!("Failed to read included {}: {}", path.display(), e))?;
+    context.including.push(resolved);
+    let rendered = render_template(&template, context);
+    context.including.pop();
+    rendered.map_err(|e| format!("in {}: {}", path.display(), e))
+}
+
+fn is_truthy(value: Option<&String>) -> bool {
+    matches!(value, Some(v) if !v.is_empty() && v != "false" && v != "0")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn vars(pairs: &[(&str, &str)]) -> HashMap<String, String> {
+        pairs
+            .iter()
+            .map(|(k, v)| (k.to_string(), v.to_string()))
+            .collect()
+    }
+
+    #[test]
+    fn test_render_plain_text() {
+        assert_eq!(render("no tags here", &HashMap::new()).unwrap(), "no tags here");
+    }
+
+    #[test]
+    fn test_render_variables() {
+        let variables = vars(&[("name", "Jane"), ("email", "jane@example.com")]);
+        let output = render("name = {{ name }}\nemail = {{email}}\n", &variables).unwrap();
+        assert_eq!(output, "name = Jane\nemail = jane@example.com\n");
+    }
+
+    #[test]
+    fn test_render_unknown_variable_fails() {
+        let err = render("{{ missing }}", &HashMap::new()).unwrap_err();
+        assert!(err.contains("missing"));
+    }
+
+    #[test]
+    fn test_render_if_block() {
+        let template = "a{{#if work}} signingkey = {{ key }}{{/if}}b";
+        let enabled = vars(&[("work", "true"), ("key", "ABC")]);
+        assert_eq!(render(template, &enabled).unwrap(), "a signingkey = ABCb");
+
+        let disabled = vars(&[("work", "false")]);
+        assert_eq!(render(template, &disabled).unwrap(), "ab");
+
+        // Undeclared condition variables are treated as false
+        assert_eq!(render(template, &HashMap::new()).unwrap(), "ab");
+    }
+
+    #[test]
+    fn test_render_if_else_block() {
+        let template = "{{#if work}}work{{else}}home{{/if}}";
+        assert_eq!(render(template, &vars(&[("work", "1")])).unwrap(), "work");
+        assert_eq!(render(template, &vars(&[("work", "")])).unwrap(), "home");
+    }
+
+    #[test]
+    fn test_render_nested_if_blocks() {
+        let template = "{{#if a}}A{{#if b}}B{{/if}}{{/if}}";
+        assert_eq!(render(template, &vars(&[("a", "yes"), ("b", "yes")])).unwrap(), "AB");
+        assert_eq!(render(template, &vars(&[("a", "yes")])).unwrap(), "A");
+    }
+
+    #[test]
+    fn test_render_secrets() {
+        let lookup = |name: &str| match name {
+            "github_token" => Ok("ghp_123".to_string()),
+            other => Err(format!("no secret {}", other)),
+        };
+
+        let output = render_with_secrets(
+            "token = {{ secret(\"github_token\") }}\nalt = {{secret('github_token')}}\n",
+            &HashMap::new(),
+            &lookup,
+        )
+        .unwrap();
+        assert_eq!(output, "token = ghp_123\nalt = ghp_123\n");
+
+        let err =
+            render_with_secrets("{{ secret(\"missing\") }}", &HashMap::new(), &lookup).unwrap_err();
+        assert_eq!(err, "no secret missing");
+
+        // Without a lookup, secrets are an error rather than left in place
+        assert!(render("{{ secret(\"github_token\") }}", &HashMap::new()).is_err());
+        assert!(render("{{ secret(github_token) }}", &HashMap::new()).is_err());
+    }
+
+    #[test]
+    fn test_render_unbalanced_blocks_fail() {
+        assert!(render("{{#if a}}never closed", &HashMap::new()).is_err());
+        assert!(render("stray {{/if}}", &HashMap::new()).is_err());
+        assert!(render("stray {{else}}", &HashMap::new()).is_err());
+        assert!(render("unclosed {{ tag", &HashMap::new()).is_err());
+    }
+
+    fn no_secrets(name: &str) -> Result<String, String> {
+        Err(format!("no secret {}", name))
+    }
+
+    #[test]
+    fn test_render_includes() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let dir = temp_dir.path();
+        fs::create_dir(dir.join("partials")).unwrap();
+        fs::write(dir.join("partials/prelude.sh"), "# {{ name }}\n").unwrap();
+        fs::write(
+            dir.join("partials/header.tmpl"),
+            "{{#if work}}work {{/if}}{{ include 'partials/prelude.sh' }}",
+        )
+        .unwrap();
+
+        let template = "{{ include \"partials/header.tmpl\" }}export EDITOR=nvim\n";
+        let variables = vars(&[("name", "zsh"), ("work", "true")]);
+        assert_eq!(
+            render_with_includes(template, &variables, &no_secrets, dir, None).unwrap(),
+            "work # zsh\nexport EDITOR=nvim\n"
+        );
+
+        let err =
+            render_with_includes(template, &HashMap::new(), &no_secrets, dir, None).unwrap_err();
+        assert!(err.contains("header.tmpl: in "), "{}", err);
+        assert!(
+            err.contains("prelude.sh: Unknown variable 'name'"),
+            "{}",
+            err
+        );
+
+        let err = render_with_includes(
+            "{{ include \"missing\" }}",
+            &variables,
+            &no_secrets,
+            dir,
+            None,
+        )
+        .unwrap_err();
+        assert!(err.contains("Failed to read included"), "{}", err);
+
+        // Without a directory to read them from, includes are an error
+        assert!(render(template, &variables).is_err());
+        assert!(render("{{ include header }}", &variables).is_err());
+    }
+
+    #[test]
+    fn test_render_cyclic_includes_fail() {
+        let temp_dir = tempfile::TempDir::new().unwrap();
+        let dir = temp_dir.path();
+        fs::write(dir.join("zshrc.tmpl"), "{{ include \"a.tmpl\" }}").unwrap();
+        fs::write(dir.join("a.tmpl"), "{{ include \"b.tmpl\" }}").unwrap();
+        fs::write(dir.join("b.tmpl"), "{{ include \"a.tmpl\" }}").unwrap();
+        fs::write(dir.join("self.tmpl"), "{{ include \"zshrc.tmpl\" }}").unwrap();
+
+        let err = render_with_includes(
+            "{{ include \"a.tmpl\" }}",
+            &HashMap::new(),
+            &no_secrets,
+            dir,
+            None,
+        )
+        .unwrap_err();
+        let real = fs::canonicalize(dir).unwrap();
+        let (a, b) = (real.join("a.tmpl"), real.join("b.tmpl"));
+        let cycle = format!(
+            "Cyclic include: {} -> {} -> {}",
+            a.display(),
+            b.display(),
+            a.display()
+        );
+        assert!(err.ends_with(&cycle), "{}", err);
+
+        // Including the template's own file is a cycle too
+        let source = dir.join("zshrc.tmpl");
+        let err = render_with_includes(
+            "{{ include \"self.tmpl\" }}",
+            &HashMap::new(),
+            &no_secrets,
+            dir,
+            Some(&source),
+        )
+        .unwrap_err();
+        assert!(
+            err.contains(&format!(
+                "Cyclic include: {}",
+                real.join("zshrc.tmpl").display()
+            )),
+            "{}",
+            err
+        );
+
+        // The same file included twice side by side is fine
+        fs::write(dir.join("line"), "x").unwrap();
+        let output = render_with_includes(
+            "{{ include \"line\" }}{{ include \"line\" }}",
+            &HashMap::new(),
+            &no_secrets,
+            dir,
+            None,
+        )
+        .unwrap();
+        assert_eq!(output, "xx");
+    }
+}
@@ -11,6 +11,10 @@ pub static TYPESCRIPT_TYPES: [(&'static str, &'static str)] = [..];
 #[linkme::distributed_slice]
 pub static TYPESCRIPT_METHODS: [(&'static str, &'static str)] = [..];
 
+// JSON Schema fragments of the same types, for `dhd schema`
+#[linkme::distributed_slice]
+pub static JSON_SCHEMAS: [(&'static str, &'static str)] = [..];
+
 pub fn generate_typescript_definitions() -> String {
     let mut output = String::new();
 
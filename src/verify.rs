@@ -0,0 +1,158 @@
+//! Whether the files DHD wrote are still what it wrote, for `dhd verify`
+//!
+//! `copyFile` and `template` record the sha256 of what they write in the
+//! state file. Verifying hashes the files again and compares, without loading
+//! any module, so only edits made outside DHD show up.
+
+use crate::state::{Change, State, sha256};
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::PathBuf;
+
+/// A file an apply wrote, as recorded in the state file
+#[derive(Debug, Clone, PartialEq)]
+pub struct WrittenFile {
+    pub path: PathBuf,
+    /// sha256 of what was written
+    pub sha256: String,
+    /// Module whose apply wrote it, if recorded
+    pub module: Option<String>,
+}
+
+/// How a written file compares with what DHD wrote
+#[derive(Debug, Clone, PartialEq)]
+pub enum Integrity {
+    Intact,
+    Modified,
+    Missing,
+    /// The file couldn't be read, e.g. because only root can
+    Unreadable(String),
+}
+
+/// The files whose latest recorded change wrote them whole, sorted by path
+///
+/// A path is left out once a later change replaced it with a symlink or only
+/// edited part of it.
+pub fn written_files(state: &State) -> Vec<WrittenFile> {
+    let mut latest = BTreeMap::new();
+    for recorded in state.applies.iter().flat_map(|apply| &apply.changes) {
+        match &recorded.change {
+            Change::File { path, written, .. } => {
+                let file = written.as_ref().map(|sha256| WrittenFile {
+                    path: path.clone(),
+                    sha256: sha256.clone(),
+                    module: recorded.module.clone(),
+                });
+                latest.insert(path.clone(), file);
+            }
+            Change::Symlink { path, .. } => {
+                latest.insert(path.clone(), None);
+            }
+            Change::Package { .. } => {}
+        }
+    }
+    latest.into_values().flatten().collect()
+}
+
+impl WrittenFile {
+    /// Hash the file again and compare it with what was written
+    pub fn verify(&self) -> Integrity {
+        match fs::read(&self.path) {
+            Ok(content) if sha256(&content) == self.sha256 => Integrity::Intact,
+            Ok(_) => Integrity::Modified,
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => Integrity::Missing,
+            Err(e) => Integrity::Unreadable(e.to_string()),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::state::{ApplyRecord, Previous, RecordedChange};
+    use std::path::Path;
+    use tempfile::TempDir;
+
+    fn file(path: &Path, content: &[u8]) -> RecordedChange {
+        RecordedChange {
+            change: Change::File {
+                path: path.to_path_buf(),
+                previous: Previous::Absent,
+                escalate: false,
+                written: Some(sha256(content)),
+            },
+            module: Some("dotfiles".to_string()),
+        }
+    }
+
+    fn state(changes: Vec<RecordedChange>) -> State {
+        State {
+            applies: vec![ApplyRecord {
+                id: "1".to_string(),
+                started_at: 0,
+                changes,
+            }],
+            ..State::default()
+        }
+    }
+
+    #[test]
+    fn test_the_latest_write_of_each_file_is_verified() {
+        let temp_dir = TempDir::new().unwrap();
+        let zshrc = temp_dir.path().join(".zshrc");
+        let gitconfig = temp_dir.path().join(".gitconfig");
+        let linked = temp_dir.path().join("linked");
+        let state = state(vec![
+            file(&zshrc, b"old"),
+            file(&gitconfig, b"[user]"),
+            file(&linked, b"content"),
+            RecordedChange {
+                change: Change::Symlink {
+                    path: linked.clone(),
+                    target: zshrc.clone(),
+                    previous: Previous::Absent,
+                },
+                module: None,
+            },
+            file(&zshrc, b"new"),
+        ]);
+
+        let files = written_files(&state);
+        assert_eq!(
+            files,
+            vec![
+                WrittenFile {
+                    path: gitconfig.clone(),
+                    sha256: sha256(b"[user]"),
+                    module: Some("dotfiles".to_string()),
+                },
+                WrittenFile {
+                    path: zshrc.clone(),
+                    sha256: sha256(b"new"),
+                    module: Some("dotfiles".to_string()),
+                },
+            ]
+        );
+
+        fs::write(&zshrc, "new").unwrap();
+        assert_eq!(files[1].verify(), Integrity::Intact);
+        fs::write(&zshrc, "edited").unwrap();
+        assert_eq!(files[1].verify(), Integrity::Modified);
+        assert_eq!(files[0].verify(), Integrity::Missing);
+    }
+
+    #[test]
+    fn test_partial_edits_are_not_verified() {
+        let path = PathBuf::from("/etc/hosts");
+        let state = state(vec![RecordedChange {
+            change: Change::File {
+                path,
+                previous: Previous::Absent,
+                escalate: true,
+                written: None,
+            },
+            module: None,
+        }]);
+        assert_eq!(written_files(&state), Vec::new());
+    }
+}
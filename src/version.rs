@@ -0,0 +1,92 @@
+//! The version of DHD, how it was built, and the module API it implements
+
+/// The module API version as a literal, for `concat!`
+macro_rules! module_api {
+    () => {
+        "1"
+    };
+}
+
+/// DHD's version
+pub const VERSION: &str = env!("CARGO_PKG_VERSION");
+
+/// The git commit DHD was built from, or `unknown`
+pub const COMMIT: &str = env!("DHD_GIT_COMMIT");
+
+/// The day DHD was built, as YYYY-MM-DD
+pub const BUILD_DATE: &str = env!("DHD_BUILD_DATE");
+
+/// Version of the module API: the actions, options and module keys that
+/// modules can use. It goes up whenever modules gain something new.
+pub const MODULE_API: &str = module_api!();
+
+/// What `dhd version` and `dhd --version` print after the program name
+pub const LONG: &str = concat!(
+    env!("CARGO_PKG_VERSION"),
+    "\ncommit: ",
+    env!("DHD_GIT_COMMIT"),
+    "\nbuilt: ",
+    env!("DHD_BUILD_DATE"),
+    "\nmodule API: ",
+    module_api!()
+);
+
+/// The numbers of a version like `0.2` or `v0.2.1`
+fn parse(version: &str) -> Result<Vec<u64>, String> {
+    let numbers = version.trim().strip_prefix('v').unwrap_or(version.trim());
+    numbers
+        .split('.')
+        .map(|number| number.parse::<u64>())
+        .collect::<Result<Vec<_>, _>>()
+        .map_err(|_| format!("'{}' is not a version like 0.2.0", version))
+}
+
+/// Check that this DHD is at least version `required`
+pub fn require(required: &str) -> Result<(), String> {
+    let wanted = parse(required)?;
+    let running = parse(VERSION)?;
+    let len = wanted.len().max(running.len());
+    let padded = |numbers: &[u64]| {
+        let mut numbers = numbers.to_vec();
+        numbers.resize(len, 0);
+        numbers
+    };
+    if padded(&running) >= padded(&wanted) {
+        Ok(())
+    } else {
+        Err(format!(
+            "requires DHD {} or newer, but this is DHD {}; upgrade dhd to use it",
+            required.trim(),
+            VERSION
+        ))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_require_compares_numerically() {
+        assert!(require("0.0.1").is_ok());
+        assert!(require(VERSION).is_ok());
+        assert!(require(&format!("v{}", VERSION)).is_ok());
+        assert!(require("0.1").is_ok());
+
+        let error = require("0.10.0").unwrap_err();
+        assert!(error.contains("requires DHD 0.10.0 or newer"), "{}", error);
+        assert!(require("99").is_err());
+        assert_eq!(
+            require("latest").unwrap_err(),
+            "'latest' is not a version like 0.2.0"
+        );
+    }
+
+    #[test]
+    fn test_long_version_lists_the_build() {
+        assert!(LONG.starts_with(VERSION));
+        assert!(LONG.contains(&format!("commit: {}", COMMIT)));
+        assert!(LONG.contains(&format!("built: {}", BUILD_DATE)));
+        assert!(LONG.ends_with(&format!("module API: {}", MODULE_API)));
+    }
+}
@@ -0,0 +1,272 @@
+//! Polling file watcher behind `dhd apply --watch`
+//!
+//! The module roots are scanned every interval for files that were added,
+//! modified or removed. Changed files map to the modules whose directory
+//! holds them, and those are re-applied together with their dependents.
+
+use crate::discovery::EXCLUDED_DIRS;
+use crate::imports::CONFIG_FILE;
+use crate::loader::LoadedModule;
+use std::collections::{HashMap, HashSet};
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::time::{Duration, Instant, SystemTime};
+
+/// Modification time and size of every file under the module roots
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Snapshot {
+    files: HashMap<PathBuf, (Option<SystemTime>, u64)>,
+}
+
+impl Snapshot {
+    pub fn take(roots: &[PathBuf]) -> Self {
+        let mut snapshot = Snapshot::default();
+        for root in roots {
+            snapshot.scan(root);
+        }
+        snapshot
+    }
+
+    fn scan(&mut self, dir: &Path) {
+        let Ok(entries) = fs::read_dir(dir) else {
+            return;
+        };
+
+        for entry in entries.flatten() {
+            let name = entry.file_name();
+            let name = name.to_string_lossy();
+            // Editors keep their swap files hidden
+            if name.starts_with('.') || EXCLUDED_DIRS.contains(&name.as_ref()) {
+                continue;
+            }
+
+            let Ok(metadata) = entry.metadata() else {
+                continue;
+            };
+            if metadata.is_dir() {
+                self.scan(&entry.path());
+            } else {
+                self.files
+                    .insert(entry.path(), (metadata.modified().ok(), metadata.len()));
+            }
+        }
+    }
+
+    /// Files added, modified or removed since `earlier`, sorted by path
+    pub fn changes_since(&self, earlier: &Snapshot) -> Vec<PathBuf> {
+        let modified = self
+            .files
+            .iter()
+            .filter(|(path, stamp)| earlier.files.get(*path) != Some(stamp))
+            .map(|(path, _)| path.clone());
+        let removed = earlier
+            .files
+            .keys()
+            .filter(|path| !self.files.contains_key(*path))
+            .cloned();
+
+        let mut changed: Vec<PathBuf> = modified.chain(removed).collect();
+        changed.sort();
+        changed
+    }
+}
+
+/// Wait until files under `roots` change and then stay untouched for `debounce`
+///
+/// Returns the changed files, or `None` as soon as `stop` is set.
+pub fn wait_for_changes(
+    roots: &[PathBuf],
+    interval: Duration,
+    debounce: Duration,
+    stop: &AtomicBool,
+) -> Option<Vec<PathBuf>> {
+    let start = Snapshot::take(roots);
+    let mut last = start.clone();
+    let mut changed_at: Option<Instant> = None;
+
+    loop {
+        if stop.load(Ordering::Relaxed) {
+            return None;
+        }
+        std::thread::sleep(interval);
+
+        let current = Snapshot::take(roots);
+        if current != last {
+            last = current;
+            changed_at = Some(Instant::now());
+            continue;
+        }
+
+        if changed_at.is_some_and(|at| at.elapsed() >= debounce) {
+            let changed = last.changes_since(&start);
+            if !changed.is_empty() {
+                return Some(changed);
+            }
+            // The edits were undone again
+            changed_at = None;
+        }
+    }
+}
+
+/// Names of the modules affected by `changed` files, in the order of `modules`
+///
+/// A module is affected when its own file changed, or another file in the
+/// nearest directory holding modules did. Modules depending on an affected
+/// module are affected too, and a changed `dhd.config.ts` affects them all.
+pub fn affected_modules(modules: &[LoadedModule], changed: &[PathBuf]) -> Vec<String> {
+    let config_changed = changed
+        .iter()
+        .any(|path| path.file_name().is_some_and(|name| name == CONFIG_FILE));
+    let module_dirs: HashSet<&Path> = modules
+        .iter()
+        .filter_map(|module| module.source.path.parent())
+        .collect();
+
+    let mut affected: HashSet<&str> = HashSet::new();
+    for module in modules {
+        let dir = module.source.path.parent();
+        let touched = changed.iter().any(|path| {
+            if path == &module.source.path {
+                return true;
+            }
+            // Other module files only affect their own module
+            if path.extension().is_some_and(|ext| ext == "ts")
+                || crate::declarative::module_file(path).is_some()
+            {
+                return false;
+            }
+            let nearest = path
+                .ancestors()
+                .skip(1)
+                .find(|ancestor| module_dirs.contains(ancestor));
+            nearest.is_some() && nearest == dir
+        });
+        if config_changed || touched {
+            affected.insert(&module.definition.name);
+        }
+    }
+
+    // Pull in dependents until nothing more is added
+    loop {
+        let before = affected.len();
+        for module in modules {
+            let dependencies = &module.definition.dependencies;
+            if dependencies
+                .iter()
+                .any(|dep| affected.contains(dep.as_str()))
+            {
+                affected.insert(&module.definition.name);
+            }
+        }
+        if affected.len() == before {
+            break;
+        }
+    }
+
+    modules
+        .iter()
+        .map(|module| module.definition.name.clone())
+        .filter(|name| affected.contains(name.as_str()))
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::discovery::DiscoveredModule;
+    use crate::module::ModuleDefinition;
+    use tempfile::TempDir;
+
+    fn module(path: &str, name: &str, dependencies: &[&str]) -> LoadedModule {
+        LoadedModule {
+            source: DiscoveredModule {
+                path: PathBuf::from(path),
+                name: name.to_string(),
+                namespace: None,
+                variables: HashMap::new(),
+                package_groups: HashMap::new(),
+            },
+            definition: ModuleDefinition {
+                name: name.to_string(),
+                description: None,
+                tags: vec![],
+                dependencies: dependencies.iter().map(|d| d.to_string()).collect(),
+                actions: vec![],
+                when: None,
+                enabled: true,
+                pre_apply: None,
+                post_apply: None,
+                handlers: Vec::new(),
+                variables: HashMap::new(),
+                variable_schema: HashMap::new(),
+            },
+        }
+    }
+
+    #[test]
+    fn test_snapshot_reports_added_modified_and_removed_files() {
+        let temp_dir = TempDir::new().unwrap();
+        let roots = vec![temp_dir.path().to_path_buf()];
+        fs::write(temp_dir.path().join("kept.ts"), "a").unwrap();
+        fs::write(temp_dir.path().join("edited.ts"), "a").unwrap();
+        fs::write(temp_dir.path().join("removed.ts"), "a").unwrap();
+        fs::create_dir(temp_dir.path().join("node_modules")).unwrap();
+        let before = Snapshot::take(&roots);
+
+        fs::write(temp_dir.path().join("edited.ts"), "longer").unwrap();
+        fs::remove_file(temp_dir.path().join("removed.ts")).unwrap();
+        fs::write(temp_dir.path().join("added.ts"), "a").unwrap();
+        fs::write(temp_dir.path().join(".edited.ts.swp"), "a").unwrap();
+        fs::write(temp_dir.path().join("node_modules/dep.js"), "a").unwrap();
+
+        let changed = Snapshot::take(&roots).changes_since(&before);
+        let names: Vec<_> = changed
+            .iter()
+            .map(|path| path.file_name().unwrap().to_string_lossy().into_owned())
+            .collect();
+        assert_eq!(names, vec!["added.ts", "edited.ts", "removed.ts"]);
+    }
+
+    #[test]
+    fn test_affected_modules_include_dependents() {
+        let modules = vec![
+            module("/m/base/base.ts", "base", &[]),
+            module("/m/shell/zsh.ts", "zsh", &["base"]),
+            module("/m/shell/fish.ts", "fish", &[]),
+            module("/m/desktop.ts", "desktop", &["zsh"]),
+        ];
+
+        let changed = [PathBuf::from("/m/base/base.ts")];
+        assert_eq!(
+            affected_modules(&modules, &changed),
+            vec!["base", "zsh", "desktop"]
+        );
+
+        // A source file affects the modules of its directory, not the ones above it
+        let changed = [PathBuf::from("/m/shell/configs/fish.conf")];
+        assert_eq!(
+            affected_modules(&modules, &changed),
+            vec!["zsh", "fish", "desktop"]
+        );
+
+        let changed = [PathBuf::from("/m/shell/fish.ts")];
+        assert_eq!(affected_modules(&modules, &changed), vec!["fish"]);
+
+        let changed = [PathBuf::from("/m/dhd.config.ts")];
+        assert_eq!(affected_modules(&modules, &changed).len(), 4);
+    }
+
+    #[test]
+    fn test_wait_for_changes_returns_when_stopped() {
+        let temp_dir = TempDir::new().unwrap();
+        let stop = AtomicBool::new(true);
+        let changed = wait_for_changes(
+            &[temp_dir.path().to_path_buf()],
+            Duration::from_millis(10),
+            Duration::from_millis(10),
+            &stop,
+        );
+        assert_eq!(changed, None);
+    }
+}
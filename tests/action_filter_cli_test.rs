@@ -0,0 +1,114 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_action_filter_runs_only_matching_actions() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let marker = home.path().join("marker");
+    let target = home.path().join(".zshrc");
+    fs::write(
+        temp_dir.path().join("dotfiles.ts"),
+        format!(
+            r#"export default defineModule("dotfiles")
+  .actions([
+    command({{ run: "touch {}" }}),
+    copyFile({{ source: "./zshrc", target: "{}" }})
+  ]);"#,
+            marker.display(),
+            target.display()
+        ),
+    )
+    .unwrap();
+    fs::write(temp_dir.path().join("zshrc"), "zsh\n").unwrap();
+    fs::write(
+        temp_dir.path().join("fonts.ts"),
+        r#"export default defineModule("fonts")
+  .actions([ensureDir({ path: "/nonexistent/dhd-test/fonts" })]);"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--action", "command"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "Only running command actions; left out:",
+        ))
+        .stdout(predicate::str::contains("- dotfiles: 1 copyFile"))
+        .stdout(predicate::str::contains("- fonts: 1 ensureDir"));
+
+    assert!(marker.exists());
+    assert!(!target.exists());
+}
+
+#[test]
+fn test_action_filter_rejects_unknown_types() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("tools.ts"),
+        r#"export default defineModule("tools").actions([]);"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--action", "packageInstal"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains(
+            "Unknown action type 'packageInstal'",
+        ));
+}
+
+#[test]
+fn test_action_tags_skip_and_only_select_actions() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let quick = home.path().join("quick");
+    let heavy = home.path().join("heavy");
+    fs::write(
+        temp_dir.path().join("dev.ts"),
+        format!(
+            r#"export default defineModule("dev")
+  .actions([
+    command({{ run: "touch {}", tags: "quick" }}),
+    command({{ run: "touch {}", tags: ["heavy"] }})
+  ]);"#,
+            quick.display(),
+            heavy.display()
+        ),
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--skip-tags", "heavy"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "Action tags (skipping heavy) filtered out 1 action:",
+        ))
+        .stdout(predicate::str::contains("- dev: 1"));
+    assert!(quick.exists());
+    assert!(!heavy.exists());
+
+    fs::remove_file(&quick).unwrap();
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--only-tags", "heavy", "--module", "dev"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "Action tags (only heavy) filtered out 1 action:",
+        ));
+    assert!(!quick.exists());
+    assert!(heavy.exists());
+}
@@ -72,6 +72,19 @@ fn test_package_install_with_different_managers() {
         let action = PackageInstall {
             names: vec!["neovim".to_string()],
             manager: Some(manager.clone()),
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
         };
 
         let atoms = action.plan(std::path::Path::new("."));
@@ -88,6 +101,19 @@ fn test_package_install_auto_detect() {
     let action = PackageInstall {
         names: vec!["ripgrep".to_string(), "fd-find".to_string(), "bat".to_string()],
         manager: None, // Should auto-detect
+        aur: None,
+        remote: None,
+        scope: None,
+        casks: None,
+        taps: None,
+        flake: None,
+        classic: None,
+        channel: None,
+        overrides: None,
+        ensure: None,
+        retries: None,
+        retry_delay: None,
+        groups: None,
     };
 
     let atoms = action.plan(std::path::Path::new("."));
@@ -215,6 +241,19 @@ fn test_complex_action_combination() {
         ActionType::PackageInstall(PackageInstall {
             names: vec!["docker".to_string(), "docker-compose".to_string()],
             manager: Some(PackageManager::Apt),
+            aur: None,
+            remote: None,
+            scope: None,
+            casks: None,
+            taps: None,
+            flake: None,
+            classic: None,
+            channel: None,
+            overrides: None,
+            ensure: None,
+            retries: None,
+            retry_delay: None,
+            groups: None,
         }),
         ActionType::ExecuteCommand(ExecuteCommand {
             shell: None,
@@ -248,6 +287,30 @@ fn test_complex_action_combination() {
             ActionType::PackageRemove(a) => a.plan(std::path::Path::new(".")),
             ActionType::SystemdManage(a) => a.plan(std::path::Path::new(".")),
             ActionType::GitConfig(a) => a.plan(std::path::Path::new(".")),
+            ActionType::Symlink(a) => a.plan(std::path::Path::new(".")),
+            ActionType::Template(a) => a.plan(std::path::Path::new(".")),
+            ActionType::ShellCommand(a) => a.plan(std::path::Path::new(".")),
+            ActionType::GitRepo(a) => a.plan(std::path::Path::new(".")),
+            ActionType::DecryptFile(a) => a.plan(std::path::Path::new(".")),
+            ActionType::EnvVar(a) => a.plan(std::path::Path::new(".")),
+            ActionType::BlockInFile(a) => a.plan(std::path::Path::new(".")),
+            ActionType::LineInFile(a) => a.plan(std::path::Path::new(".")),
+            ActionType::RemoteFile(a) => a.plan(std::path::Path::new(".")),
+            ActionType::Cron(a) => a.plan(std::path::Path::new(".")),
+            ActionType::GpgKey(a) => a.plan(std::path::Path::new(".")),
+            ActionType::PackageRepo(a) => a.plan(std::path::Path::new(".")),
+            ActionType::Stow(a) => a.plan(std::path::Path::new(".")),
+            ActionType::Notify(a) => a.plan(std::path::Path::new(".")),
+            ActionType::Plugin(a) => a.plan(std::path::Path::new(".")),
+            ActionType::Tagged(a) => a.plan(std::path::Path::new(".")),
+            ActionType::ShellSource(a) => a.plan(std::path::Path::new(".")),
+            ActionType::Verified(a) => a.plan(std::path::Path::new(".")),
+            ActionType::Ordered(a) => a.plan(std::path::Path::new(".")),
+            ActionType::Reboot(a) => a.plan(std::path::Path::new(".")),
+            ActionType::TemplateDir(a) => a.plan(std::path::Path::new(".")),
+            ActionType::DesktopEntry(a) => a.plan(std::path::Path::new(".")),
+            ActionType::ScriptInstall(a) => a.plan(std::path::Path::new(".")),
+            ActionType::SshConfig(a) => a.plan(std::path::Path::new(".")),
         };
         assert!(!atoms.is_empty());
     }
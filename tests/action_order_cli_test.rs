@@ -0,0 +1,80 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_actions_run_after_the_ids_they_name() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("order.ts"),
+        r#"
+export default defineModule("order")
+  .actions([
+    command({ run: "echo reload >> log", after: "write" }),
+    command({ run: "echo write >> log", id: "write", after: ["mkdir"] }),
+    command({ run: "echo other >> log" }),
+    command({ run: "echo mkdir >> log", id: "mkdir" })
+  ]);
+"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("apply")
+        .assert()
+        .success();
+
+    let log = fs::read_to_string(temp_dir.path().join("log")).unwrap();
+    assert_eq!(log, "other\nmkdir\nwrite\nreload\n");
+}
+
+#[test]
+fn test_actions_after_each_other_fail_to_load() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("order.ts"),
+        r#"
+export default defineModule("order")
+  .actions([
+    command({ run: "touch a", id: "a", after: "b" }),
+    command({ run: "touch b", id: "b", after: "a" })
+  ]);
+"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("apply")
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains(
+            "Actions run after each other: 'b' -> 'a' -> 'b'",
+        ));
+
+    assert!(!temp_dir.path().join("a").exists());
+}
+
+#[test]
+fn test_after_an_unknown_id_fails_to_load() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("order.yaml"),
+        "actions:\n  - type: command\n    run: touch a\n    after: missing\n",
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("plan")
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains(
+            "runs after 'missing', but no action has that id",
+        ));
+}
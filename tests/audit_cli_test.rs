@@ -0,0 +1,82 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_modules(temp_dir: &TempDir) {
+    let out = temp_dir.path().display();
+    fs::write(
+        temp_dir.path().join("files.ts"),
+        format!(
+            r#"
+export default defineModule("files")
+  .actions([
+    ensureDir({{ path: "{out}/present" }}),
+    copyFile({{ source: "./zshrc", target: "{out}/.zshrc" }}),
+    command({{ run: "touch {out}/command-ran" }})
+  ]);
+"#
+        ),
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("probe.ts"),
+        format!(
+            r#"
+export default defineModule("probe")
+  .when(command("touch {out}/probed").succeeds())
+  .actions([
+    ensureDir({{ path: "{out}/probe" }})
+  ]);
+"#
+        ),
+    )
+    .unwrap();
+    fs::write(temp_dir.path().join("zshrc"), "export EDITOR=vim\n").unwrap();
+    fs::create_dir(temp_dir.path().join("present")).unwrap();
+}
+
+#[test]
+fn test_audit_reports_drift_without_side_effects() {
+    let temp_dir = TempDir::new().unwrap();
+    write_modules(&temp_dir);
+    fs::write(temp_dir.path().join(".zshrc"), "export EDITOR=nano\n").unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("audit")
+        .assert()
+        .code(2)
+        .stdout(predicate::str::contains("● files - 1 drifted"))
+        .stdout(predicate::str::contains("? not audited Run command"))
+        .stdout(predicate::str::contains(
+            "● probe - skipped (not audited, its condition runs a command",
+        ))
+        .stdout(predicate::str::contains(
+            "1 in sync, 1 drifted, 1 not audited",
+        ));
+
+    assert_eq!(
+        fs::read_to_string(temp_dir.path().join(".zshrc")).unwrap(),
+        "export EDITOR=nano\n",
+        "audit must not modify the system"
+    );
+    assert!(!temp_dir.path().join("command-ran").exists());
+    assert!(!temp_dir.path().join("probed").exists());
+}
+
+#[test]
+fn test_audit_exits_zero_when_in_sync() {
+    let temp_dir = TempDir::new().unwrap();
+    write_modules(&temp_dir);
+    fs::write(temp_dir.path().join(".zshrc"), "export EDITOR=vim\n").unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["audit", "--modules", "files"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("2 in sync, 0 drifted"));
+}
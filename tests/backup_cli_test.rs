@@ -0,0 +1,83 @@
+use assert_cmd::Command;
+use std::fs;
+use std::path::{Path, PathBuf};
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, state_dir: &Path) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir).env("XDG_STATE_HOME", state_dir);
+    cmd
+}
+
+fn write_module(temp_dir: &TempDir, target: &Path) {
+    fs::write(
+        temp_dir.path().join("dotfiles.ts"),
+        format!(
+            r#"export default defineModule("dotfiles")
+  .actions([copyFile({{ source: "./zshrc", target: "{}" }})]);"#,
+            target.display()
+        ),
+    )
+    .unwrap();
+    fs::write(temp_dir.path().join("zshrc"), "managed\n").unwrap();
+}
+
+fn backups(dir: &Path) -> Vec<PathBuf> {
+    let mut backups: Vec<PathBuf> = fs::read_dir(dir)
+        .unwrap()
+        .map(|entry| entry.unwrap().path())
+        .filter(|path| path.to_string_lossy().contains(".dhd-bak-"))
+        .collect();
+    backups.sort();
+    backups
+}
+
+#[test]
+fn test_replaced_file_is_backed_up_and_restored_by_rollback() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let target = home.path().join(".zshrc");
+    write_module(&temp_dir, &target);
+    fs::write(&target, "mine\n").unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--yes"])
+        .assert()
+        .success();
+    let backups_after_apply = backups(home.path());
+    assert_eq!(backups_after_apply.len(), 1);
+    assert_eq!(
+        fs::read_to_string(&backups_after_apply[0]).unwrap(),
+        "mine\n"
+    );
+
+    // Nothing differs on the second apply, so nothing more is backed up
+    dhd(&temp_dir, state.path()).arg("apply").assert().success();
+    assert_eq!(backups(home.path()), backups_after_apply);
+
+    // The no-op apply recorded nothing, so this undoes the first one
+    dhd(&temp_dir, state.path())
+        .arg("rollback")
+        .assert()
+        .success();
+    assert_eq!(fs::read_to_string(&target).unwrap(), "mine\n");
+    assert!(backups(home.path()).is_empty());
+}
+
+#[test]
+fn test_no_backup_leaves_no_copy_beside_the_file() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let target = home.path().join(".zshrc");
+    write_module(&temp_dir, &target);
+    fs::write(&target, "mine\n").unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--yes", "--no-backup"])
+        .assert()
+        .success();
+    assert!(backups(home.path()).is_empty());
+    assert_eq!(fs::read_to_string(&target).unwrap(), "managed\n");
+}
@@ -0,0 +1,57 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_check_passes_for_valid_modules() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(temp_dir.path().join("zshrc"), "").unwrap();
+    fs::write(
+        temp_dir.path().join("zsh.ts"),
+        r#"export default defineModule("zsh")
+  .actions([copyFile({ source: "zshrc", target: "~/.zshrc" })]);"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("check")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("All 1 module(s) are valid"));
+}
+
+#[test]
+fn test_check_reports_every_invalid_module() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("web.ts"),
+        r#"export default defineModule("web")
+  .dependsOn(["base"])
+  .actions([
+    copyFile({ source: "missing.conf", target: "/etc/web.conf" }),
+    frobnicate({ level: 11 })
+  ]);"#,
+    )
+    .unwrap();
+    fs::write(temp_dir.path().join("broken.ts"), "export default {").unwrap();
+    fs::write(
+        temp_dir.path().join("ok.ts"),
+        r#"export default defineModule("ok").actions([]);"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("check")
+        .assert()
+        .code(3)
+        .stdout(predicate::str::contains("Unknown dependency 'base'"))
+        .stdout(predicate::str::contains("missing.conf"))
+        .stdout(predicate::str::contains("'frobnicate'"))
+        .stdout(predicate::str::contains("Parse error"))
+        .stdout(predicate::str::contains("2 of 3 module(s) are invalid"));
+}
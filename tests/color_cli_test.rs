@@ -0,0 +1,70 @@
+use assert_cmd::Command;
+use std::fs;
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, state: &TempDir) -> Command {
+    fs::write(
+        temp_dir.path().join("app.ts"),
+        r#"
+export default defineModule("app")
+  .actions([
+    command({ run: "true" }),
+    command({ run: "exit 3" })
+  ]);
+"#,
+    )
+    .unwrap();
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir)
+        .env("XDG_STATE_HOME", state.path())
+        .env_remove("NO_COLOR")
+        .env_remove("DHD_COLORS");
+    cmd
+}
+
+fn stdout(cmd: &mut Command) -> String {
+    String::from_utf8(cmd.assert().failure().get_output().stdout.clone()).unwrap()
+}
+
+#[test]
+fn test_color_always_colors_status_lines() {
+    let temp_dir = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+
+    let out = stdout(dhd(&temp_dir, &state).args(["apply", "--color", "always"]));
+    assert!(out.contains("\x1b[32m  ✅ "), "{}", out);
+    assert!(out.contains("\x1b[31m  ❌ "), "{}", out);
+    assert!(out.contains("\x1b[31m❌ Failed: 1\x1b[0m"), "{}", out);
+    // A count of zero isn't colored
+    assert!(out.contains("   ⏭️  Skipped: 0\n"), "{}", out);
+}
+
+#[test]
+fn test_output_is_plain_when_piped_or_turned_off() {
+    let temp_dir = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+
+    let piped = stdout(dhd(&temp_dir, &state).arg("apply"));
+    assert!(!piped.contains('\x1b'), "{}", piped);
+    let no_color =
+        stdout(dhd(&temp_dir, &state).args(["apply", "--color", "always", "--no-color"]));
+    assert!(!no_color.contains('\x1b'), "{}", no_color);
+}
+
+#[test]
+fn test_themes_and_json_output() {
+    let temp_dir = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+
+    let themed = stdout(
+        dhd(&temp_dir, &state)
+            .env("DHD_COLORS", "applied=1;34")
+            .args(["apply", "--color", "always"]),
+    );
+    assert!(themed.contains("\x1b[1;34m  ✅ "), "{}", themed);
+
+    let json =
+        stdout(dhd(&temp_dir, &state).args(["apply", "--color", "always", "--output", "json"]));
+    assert!(!json.contains('\x1b'), "{}", json);
+    serde_json::from_str::<serde_json::Value>(&json).unwrap();
+}
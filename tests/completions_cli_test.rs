@@ -0,0 +1,79 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn completions(shell: &str) -> String {
+    let output = Command::cargo_bin("dhd")
+        .unwrap()
+        .args(["completions", shell])
+        .output()
+        .unwrap();
+    assert!(output.status.success());
+    String::from_utf8(output.stdout).unwrap()
+}
+
+#[test]
+fn test_completions_cover_subcommands() {
+    for shell in ["bash", "zsh", "fish"] {
+        let script = completions(shell);
+        assert!(script.contains("apply"), "{} script lacks apply", shell);
+        assert!(
+            script.contains("rollback"),
+            "{} script lacks rollback",
+            shell
+        );
+    }
+}
+
+#[test]
+fn test_completions_complete_module_names() {
+    assert!(completions("fish").contains("-l module -f -r -a '(dhd __complete modules"));
+    assert!(completions("bash").contains("$(dhd __complete modules 2>/dev/null)"));
+
+    let zsh = completions("zsh");
+    assert!(zsh.starts_with("#compdef dhd\n"));
+    assert!(zsh.contains(":MODULE:_dhd_modules'"));
+    assert!(zsh.contains(":TAG:_dhd_tags'"));
+}
+
+#[test]
+fn test_complete_lists_modules_and_tags() {
+    let temp_dir = TempDir::new().unwrap();
+    for (name, tags) in [("zsh", r#"["shell"]"#), ("git", r#"["dev", "shell"]"#)] {
+        let module = format!(
+            r#"
+export default defineModule("{name}")
+  .tags({tags})
+  .actions([]);
+"#
+        );
+        fs::write(temp_dir.path().join(format!("{}.ts", name)), module).unwrap();
+    }
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["__complete", "modules"])
+        .assert()
+        .success()
+        .stdout("git\nzsh\n");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["__complete", "tags"])
+        .assert()
+        .success()
+        .stdout("dev\nshell\n");
+}
+
+#[test]
+fn test_unknown_shell_is_rejected() {
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .args(["completions", "tcsh"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("invalid value"));
+}
@@ -1,6 +1,7 @@
 use dhd::loader::load_module;
 use dhd::discovery::DiscoveredModule;
 use dhd::actions::{Condition, ComparisonOperator};
+use std::collections::HashMap;
 use std::fs;
 use std::path::PathBuf;
 use tempfile::TempDir;
@@ -12,6 +13,9 @@ fn create_test_module(dir: &std::path::Path, name: &str, content: &str) -> Disco
     DiscoveredModule {
         path,
         name: name.to_string(),
+        namespace: None,
+        variables: HashMap::new(),
+        package_groups: HashMap::new(),
     }
 }
 
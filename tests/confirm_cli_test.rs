@@ -0,0 +1,86 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, state_dir: &Path) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir).env("XDG_STATE_HOME", state_dir);
+    cmd
+}
+
+fn write_module(temp_dir: &TempDir, target: &Path) {
+    fs::write(
+        temp_dir.path().join("dotfiles.ts"),
+        format!(
+            r#"export default defineModule("dotfiles")
+  .actions([copyFile({{ source: "./config.txt", target: "{}" }})]);"#,
+            target.display()
+        ),
+    )
+    .unwrap();
+    fs::write(temp_dir.path().join("config.txt"), "managed\n").unwrap();
+}
+
+#[test]
+fn test_overwriting_a_file_without_a_terminal_aborts() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let target = home.path().join("config.txt");
+    write_module(&temp_dir, &target);
+    fs::write(&target, "mine\n").unwrap();
+
+    dhd(&temp_dir, state.path())
+        .arg("apply")
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains(format!(
+            "overwrite {} (dotfiles)",
+            target.display()
+        )))
+        .stderr(predicate::str::contains("pass --yes"));
+    assert_eq!(fs::read_to_string(&target).unwrap(), "mine\n");
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--yes"])
+        .assert()
+        .success();
+    assert_eq!(fs::read_to_string(&target).unwrap(), "managed\n");
+}
+
+#[test]
+fn test_files_written_by_dhd_are_replaced_without_asking() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let target = home.path().join("config.txt");
+    write_module(&temp_dir, &target);
+
+    // Creating the file is not destructive
+    dhd(&temp_dir, state.path()).arg("apply").assert().success();
+
+    fs::write(temp_dir.path().join("config.txt"), "updated\n").unwrap();
+    dhd(&temp_dir, state.path())
+        .arg("apply")
+        .assert()
+        .success()
+        .stderr(predicate::str::contains("This apply would").not());
+    assert_eq!(fs::read_to_string(&target).unwrap(), "updated\n");
+}
+
+#[test]
+fn test_dry_run_does_not_ask() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let target = home.path().join("config.txt");
+    write_module(&temp_dir, &target);
+    fs::write(&target, "mine\n").unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--dry-run"])
+        .assert()
+        .success();
+}
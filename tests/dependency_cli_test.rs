@@ -146,4 +146,48 @@ export default defineModule("module-b")
     let stderr = String::from_utf8(output.stderr).unwrap();
     assert!(stderr.contains("Cyclic dependency") || stderr.contains("circular"), 
             "Error message should mention circular/cyclic dependency");
-}
\ No newline at end of file
+}
+#[test]
+fn test_no_deps_skips_dependencies() {
+    let temp_dir = TempDir::new().unwrap();
+    let modules_dir = temp_dir.path().join("modules");
+    fs::create_dir(&modules_dir).unwrap();
+
+    let base_module = r#"
+export default defineModule("base-module")
+  .actions([
+    executeCommand({
+      command: "echo",
+      arguments: ["base"],
+    })
+  ]);
+"#;
+    fs::write(modules_dir.join("base_module.ts"), base_module).unwrap();
+
+    let dependent_module = r#"
+export default defineModule("dependent-module")
+  .dependsOn(["base-module"])
+  .actions([
+    executeCommand({
+      command: "echo",
+      arguments: ["dependent"],
+    })
+  ]);
+"#;
+    fs::write(modules_dir.join("dependent_module.ts"), dependent_module).unwrap();
+
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(&temp_dir)
+        .arg("apply")
+        .arg("--module")
+        .arg("dependent-module")
+        .arg("--no-deps")
+        .arg("--dry-run");
+
+    let output = cmd.output().unwrap();
+    assert!(output.status.success(), "Missing dependencies are ignored with --no-deps");
+
+    let stdout = String::from_utf8(output.stdout).unwrap();
+    assert!(stdout.contains("dependent-module"));
+    assert!(!stdout.contains("base-module"), "base-module should not be pulled in");
+}
@@ -0,0 +1,72 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn legacy_module(temp_dir: &TempDir) {
+    let module = format!(
+        r#"export default defineModule("legacy").actions([directory({{ path: "{}" }})]);"#,
+        temp_dir.path().join("made").display()
+    );
+    fs::write(temp_dir.path().join("legacy.ts"), module).unwrap();
+}
+
+#[test]
+fn test_deprecations_are_listed_after_the_summary() {
+    let temp_dir = TempDir::new().unwrap();
+    legacy_module(&temp_dir);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("apply")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("⚠️  Deprecated (1):"))
+        .stdout(predicate::str::contains(
+            "module 'legacy': directory is deprecated, use ensureDir instead",
+        ));
+
+    assert!(temp_dir.path().join("made").is_dir());
+}
+
+#[test]
+fn test_check_lists_deprecations_without_failing() {
+    let temp_dir = TempDir::new().unwrap();
+    legacy_module(&temp_dir);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("check")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "⚠️  directory is deprecated, use ensureDir instead",
+        ))
+        .stdout(predicate::str::contains("All 1 module(s) are valid"));
+}
+
+#[test]
+fn test_strict_makes_deprecations_errors() {
+    let temp_dir = TempDir::new().unwrap();
+    legacy_module(&temp_dir);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["check", "--strict"])
+        .assert()
+        .code(3)
+        .stdout(predicate::str::contains(
+            "directory is deprecated, use ensureDir instead (--strict)",
+        ));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--strict"])
+        .assert()
+        .failure();
+    assert!(!temp_dir.path().join("made").exists());
+}
@@ -0,0 +1,78 @@
+use assert_cmd::Command;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, data_dir: &Path, state_dir: &Path) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir)
+        .env("XDG_DATA_HOME", data_dir)
+        .env("XDG_STATE_HOME", state_dir);
+    cmd
+}
+
+fn write_module(temp_dir: &TempDir, ensure: &str) {
+    fs::write(
+        temp_dir.path().join("obsidian.ts"),
+        format!(
+            r#"
+export default defineModule("obsidian")
+  .actions([
+    desktopEntry({{
+      name: "Obsidian",
+      exec: "/opt/obsidian/Obsidian.AppImage %U",
+      categories: ["Office", "Utility"],
+      ensure: "{}"
+    }})
+  ]);
+"#,
+            ensure
+        ),
+    )
+    .unwrap();
+}
+
+#[test]
+fn test_desktop_entry_is_written_and_removed() {
+    let temp_dir = TempDir::new().unwrap();
+    let data = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let entry = data.path().join("applications/obsidian.desktop");
+
+    write_module(&temp_dir, "present");
+    dhd(&temp_dir, data.path(), state.path())
+        .args(["apply", "--yes"])
+        .assert()
+        .success();
+    let content = fs::read_to_string(&entry).unwrap();
+    assert!(content.starts_with("# Written by dhd\n[Desktop Entry]\n"));
+    assert!(content.contains("\nExec=/opt/obsidian/Obsidian.AppImage %U\n"));
+    assert!(content.contains("\nCategories=Office;Utility;\n"));
+
+    write_module(&temp_dir, "absent");
+    dhd(&temp_dir, data.path(), state.path())
+        .args(["apply", "--yes"])
+        .assert()
+        .success();
+    assert!(!entry.exists());
+}
+
+#[test]
+fn test_desktop_entry_absent_keeps_foreign_launchers() {
+    let temp_dir = TempDir::new().unwrap();
+    let data = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let entry = data.path().join("applications/obsidian.desktop");
+    fs::create_dir_all(entry.parent().unwrap()).unwrap();
+    fs::write(&entry, "[Desktop Entry]\nName=Obsidian\n").unwrap();
+
+    write_module(&temp_dir, "absent");
+    dhd(&temp_dir, data.path(), state.path())
+        .args(["apply", "--yes"])
+        .assert()
+        .success();
+    assert_eq!(
+        fs::read_to_string(&entry).unwrap(),
+        "[Desktop Entry]\nName=Obsidian\n"
+    );
+}
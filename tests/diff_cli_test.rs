@@ -0,0 +1,183 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_copy_module(temp_dir: &TempDir, target: &std::path::Path) {
+    let module = format!(
+        r#"
+export default defineModule("files")
+  .description("Copy a config file")
+  .actions([
+    copyFile({{ source: "./config.txt", target: "{}" }})
+  ]);
+"#,
+        target.display()
+    );
+    fs::write(temp_dir.path().join("files.ts"), module).unwrap();
+}
+
+#[test]
+fn test_diff_shows_unified_diff_without_writing() {
+    let temp_dir = TempDir::new().unwrap();
+    let target = temp_dir.path().join("installed.txt");
+    fs::write(temp_dir.path().join("config.txt"), "theme = dark\n").unwrap();
+    fs::write(&target, "theme = light\n").unwrap();
+    write_copy_module(&temp_dir, &target);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("diff")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("-theme = light"))
+        .stdout(predicate::str::contains("+theme = dark"));
+
+    assert_eq!(fs::read_to_string(&target).unwrap(), "theme = light\n");
+}
+
+#[test]
+fn test_diff_reports_no_changes_when_in_sync() {
+    let temp_dir = TempDir::new().unwrap();
+    let target = temp_dir.path().join("installed.txt");
+    fs::write(temp_dir.path().join("config.txt"), "same\n").unwrap();
+    fs::write(&target, "same\n").unwrap();
+    write_copy_module(&temp_dir, &target);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("diff")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("No file changes"));
+}
+
+#[test]
+fn test_diff_reports_binary_files() {
+    let temp_dir = TempDir::new().unwrap();
+    let target = temp_dir.path().join("installed.txt");
+    fs::write(temp_dir.path().join("config.txt"), [0u8, 159, 146, 150]).unwrap();
+    write_copy_module(&temp_dir, &target);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("diff")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("differs (binary)"));
+}
+
+#[test]
+fn test_diff_masks_secrets() {
+    let temp_dir = TempDir::new().unwrap();
+    let target = temp_dir.path().join("netrc");
+    fs::write(
+        temp_dir.path().join("netrc.tmpl"),
+        "password {{ secret(\"github_token\") }}\n",
+    )
+    .unwrap();
+    let module = format!(
+        r#"
+export default defineModule("netrc")
+  .actions([
+    template({{ source: "./netrc.tmpl", target: "{}" }})
+  ]);
+"#,
+        target.display()
+    );
+    fs::write(temp_dir.path().join("netrc.ts"), module).unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("GITHUB_TOKEN", "ghp_diff_test_token")
+        .arg("diff")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("+password ********"))
+        .stdout(predicate::str::contains("ghp_diff_test_token").not());
+}
+
+#[test]
+fn test_sensitive_variables_are_masked_but_rendered() {
+    let temp_dir = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let target = temp_dir.path().join("vpn.conf");
+    fs::write(
+        temp_dir.path().join("dhd.config.ts"),
+        r#"export default defineConfig({ variables: { endpoint: "https://vpn.internal.example.com" } });"#,
+    )
+    .unwrap();
+    fs::write(temp_dir.path().join("vpn.tmpl"), "remote {{ endpoint }}\n").unwrap();
+    let module = format!(
+        r#"
+export default defineModule("vpn")
+  .variableSchema({{ endpoint: {{ type: "string", sensitive: true }} }})
+  .actions([
+    template({{ source: "./vpn.tmpl", target: "{}" }})
+  ]);
+"#,
+        target.display()
+    );
+    fs::write(temp_dir.path().join("vpn.ts"), module).unwrap();
+
+    let dhd = |args: &[&str]| {
+        let mut cmd = Command::cargo_bin("dhd").unwrap();
+        cmd.current_dir(&temp_dir)
+            .env("XDG_STATE_HOME", state.path())
+            .args(args);
+        cmd
+    };
+    dhd(&["diff"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("+remote ********"))
+        .stdout(predicate::str::contains("vpn.internal").not());
+    dhd(&["explain", "vpn"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("********"))
+        .stdout(predicate::str::contains("vpn.internal").not());
+    dhd(&["apply", "--diff", "--yes"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("vpn.internal").not());
+
+    assert_eq!(
+        fs::read_to_string(&target).unwrap(),
+        "remote https://vpn.internal.example.com\n"
+    );
+}
+
+#[test]
+fn test_apply_diff_shows_changes_as_they_are_written() {
+    let temp_dir = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let target = temp_dir.path().join("installed.txt");
+    fs::write(temp_dir.path().join("config.txt"), "theme = dark\n").unwrap();
+    fs::write(&target, "theme = light\n").unwrap();
+    write_copy_module(&temp_dir, &target);
+
+    let apply = || {
+        let mut cmd = Command::cargo_bin("dhd").unwrap();
+        cmd.current_dir(&temp_dir)
+            .env("XDG_STATE_HOME", state.path())
+            .args(["apply", "--diff", "--yes"]);
+        cmd
+    };
+    apply()
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("      -theme = light"))
+        .stdout(predicate::str::contains("      +theme = dark"));
+    assert_eq!(fs::read_to_string(&target).unwrap(), "theme = dark\n");
+
+    // Up-to-date files print no diff
+    apply()
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("+++").not());
+}
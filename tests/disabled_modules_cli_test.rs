@@ -0,0 +1,89 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn modules() -> TempDir {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("ollama.ts"),
+        r#"export default defineModule("ollama").enabled(false).actions([command({ run: "touch ollama-ran" })]);"#,
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("base.ts"),
+        r#"export default defineModule("base").actions([command({ run: "touch base-ran" })]);"#,
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("app.ts"),
+        r#"export default defineModule("app").dependsOn(["base"]).actions([command({ run: "touch app-ran" })]);"#,
+    )
+    .unwrap();
+    temp_dir
+}
+
+#[test]
+fn test_disabled_module_is_skipped() {
+    let temp_dir = modules();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", temp_dir.path())
+        .args(["apply", "--yes"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("ollama skipped (disabled)"));
+
+    assert!(!temp_dir.path().join("ollama-ran").exists());
+    assert!(temp_dir.path().join("base-ran").exists());
+    assert!(temp_dir.path().join("app-ran").exists());
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["list"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("- ollama (ollama.ts) (disabled)"));
+}
+
+#[test]
+fn test_config_disables_modules_and_warns_about_dependents() {
+    let temp_dir = modules();
+    fs::write(
+        temp_dir.path().join("dhd.config.ts"),
+        r#"
+export default defineConfig({
+    disabled: ["base"],
+    hosts: { laptop: { disabled: ["app"] } },
+});
+"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", temp_dir.path())
+        .args(["plan", "--modules", "app", "--pending-exit-code", "0"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("base - skipped (disabled)"))
+        .stderr(predicate::str::contains(
+            "Module 'app' depends on 'base', which is disabled",
+        ));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", temp_dir.path())
+        .args(["apply", "--yes", "--host", "laptop"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("app skipped (disabled)"));
+
+    assert!(!temp_dir.path().join("base-ran").exists());
+    assert!(!temp_dir.path().join("app-ran").exists());
+}
@@ -0,0 +1,68 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_doctor_passes_for_valid_modules() {
+    let temp_dir = TempDir::new().unwrap();
+    let dhd_home = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("ok.ts"),
+        r#"export default defineModule("ok").actions([]);"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", dhd_home.path())
+        .arg("doctor")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("✅ Modules: 1 module(s) load"))
+        .stdout(predicate::str::contains(
+            "Nothing found that would make an apply fail",
+        ));
+}
+
+#[test]
+fn test_doctor_fails_for_a_missing_modules_path() {
+    let temp_dir = TempDir::new().unwrap();
+    let missing = temp_dir.path().join("dotfiles");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .env("DHD_HOME", temp_dir.path())
+        .arg("--modules-path")
+        .arg(&missing)
+        .arg("doctor")
+        .assert()
+        .code(1)
+        .stdout(predicate::str::contains("❌ Modules path:"))
+        .stdout(predicate::str::contains(
+            "→ Pass the directory of your modules",
+        ))
+        .stdout(predicate::str::contains(
+            "problem(s) would make an apply fail",
+        ));
+}
+
+#[test]
+fn test_doctor_flags_invalid_modules() {
+    let temp_dir = TempDir::new().unwrap();
+    let dhd_home = TempDir::new().unwrap();
+    fs::write(temp_dir.path().join("broken.ts"), "export default {").unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", dhd_home.path())
+        .arg("doctor")
+        .assert()
+        .code(1)
+        .stdout(predicate::str::contains(
+            "❌ Modules: 1 of 1 module(s) are invalid",
+        ))
+        .stdout(predicate::str::contains("→ Run `dhd check`"));
+}
@@ -0,0 +1,89 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, home: &Path, ensure: &str) {
+    let module = format!(
+        r#"
+export default defineModule("dotfiles")
+  .actions([
+    symlink({{ source: "./vimrc", target: "{home}/.vimrc", ensure: "{ensure}" }}),
+    copyFile({{ source: "./tool.conf", target: "{home}/tool.conf", ensure: "{ensure}" }}),
+    template({{ source: "./gitconfig.tmpl", target: "{home}/.gitconfig", ensure: "{ensure}" }}),
+    symlink({{ source: "./bashrc", target: "{home}/.bashrc", ensure: "absent" }})
+  ]);
+"#,
+        home = home.display()
+    );
+    fs::write(temp_dir.path().join("dotfiles.ts"), module).unwrap();
+}
+
+#[test]
+#[cfg(unix)]
+fn test_absent_takes_back_only_what_dhd_wrote() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    fs::write(temp_dir.path().join("vimrc"), "set number\n").unwrap();
+    fs::write(temp_dir.path().join("tool.conf"), "managed\n").unwrap();
+    fs::write(temp_dir.path().join("gitconfig.tmpl"), "[user]\n").unwrap();
+    fs::write(home.path().join(".gitconfig"), "mine\n").unwrap();
+    fs::write(home.path().join(".bashrc"), "# not DHD's\n").unwrap();
+    let apply = || {
+        let mut cmd = Command::cargo_bin("dhd").unwrap();
+        cmd.current_dir(&temp_dir)
+            .env("XDG_STATE_HOME", state.path())
+            .args(["apply", "--yes", "--changed-exit-code", "2"]);
+        cmd
+    };
+
+    write_module(&temp_dir, home.path(), "present");
+    apply().assert().code(2);
+    assert!(home.path().join(".vimrc").is_symlink());
+    assert_eq!(
+        fs::read_to_string(home.path().join(".gitconfig")).unwrap(),
+        "[user]\n"
+    );
+
+    write_module(&temp_dir, home.path(), "absent");
+    apply().assert().code(2);
+    assert!(!home.path().join(".vimrc").exists());
+    assert!(!home.path().join("tool.conf").exists());
+    // What the apply replaced is put back
+    assert_eq!(
+        fs::read_to_string(home.path().join(".gitconfig")).unwrap(),
+        "mine\n"
+    );
+    assert_eq!(
+        fs::read_to_string(home.path().join(".bashrc")).unwrap(),
+        "# not DHD's\n"
+    );
+
+    // Once taken back, there's nothing left to do
+    apply()
+        .assert()
+        .code(0)
+        .stdout(predicate::str::contains("up to date"));
+}
+
+#[test]
+fn test_ensure_must_be_present_or_absent() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("vim.ts"),
+        r#"export default defineModule("vim").actions([symlink({ source: "./vimrc", target: "~/.vimrc", ensure: "gone" })]);"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("check")
+        .assert()
+        .failure()
+        .stdout(predicate::str::contains(
+            "symlink 'ensure' must be \"present\" or \"absent\", got 'gone'",
+        ));
+}
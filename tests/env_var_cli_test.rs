@@ -0,0 +1,91 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, home: &TempDir) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir)
+        .env("HOME", home.path())
+        .env("XDG_STATE_HOME", home.path().join(".local/state"));
+    cmd
+}
+
+#[test]
+fn test_repeated_applies_keep_env_file_and_rc_block_unique() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("shell.ts"),
+        r#"export default defineModule("shell")
+  .actions([
+    envVar({ name: "EDITOR", value: "nvim" }),
+    envVar({ pathPrepend: "~/.local/bin", shells: ["zsh", "fish"] }),
+  ]);"#,
+    )
+    .unwrap();
+    fs::write(home.path().join(".zshrc"), "setopt autocd\n").unwrap();
+
+    for _ in 0..2 {
+        dhd(&temp_dir, &home)
+            .args(["apply", "--yes"])
+            .assert()
+            .success();
+    }
+
+    let env = fs::read_to_string(home.path().join(".config/dhd/env.sh")).unwrap();
+    assert_eq!(env.matches("export EDITOR=\"nvim\"").count(), 1, "{}", env);
+    assert_eq!(env.matches("$HOME/.local/bin:$PATH").count(), 1, "{}", env);
+
+    let fish = fs::read_to_string(home.path().join(".config/dhd/env.fish")).unwrap();
+    assert_eq!(fish.lines().filter(|l| l.contains("PATH")).count(), 1);
+    assert!(!fish.contains("EDITOR"), "{}", fish);
+
+    let zshrc = fs::read_to_string(home.path().join(".zshrc")).unwrap();
+    assert!(zshrc.starts_with("setopt autocd\n"));
+    assert_eq!(zshrc.matches("# >>> dhd env >>>").count(), 1, "{}", zshrc);
+    assert!(home.path().join(".bashrc").exists());
+    assert!(home.path().join(".config/fish/config.fish").exists());
+}
+
+#[test]
+fn test_shell_source_wires_declared_shells_and_unwire_removes_it() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("shell.ts"),
+        r#"export default defineModule("shell")
+  .actions([
+    envVar({ name: "EDITOR", value: "nvim", wire: false }),
+    shellSource({ shells: ["zsh"] }),
+    shellSource({ file: "~/.config/dhd/aliases.sh", shells: ["zsh"] }),
+  ]);"#,
+    )
+    .unwrap();
+    fs::write(home.path().join(".zshrc"), "setopt autocd\n").unwrap();
+
+    dhd(&temp_dir, &home)
+        .args(["apply", "--yes"])
+        .assert()
+        .success();
+
+    let zshrc = fs::read_to_string(home.path().join(".zshrc")).unwrap();
+    assert_eq!(zshrc.matches("# >>> dhd env >>>").count(), 1, "{}", zshrc);
+    assert!(
+        zshrc.contains("# >>> dhd source ~/.config/dhd/aliases.sh >>>"),
+        "{}",
+        zshrc
+    );
+    assert!(!home.path().join(".bashrc").exists());
+
+    dhd(&temp_dir, &home)
+        .args(["unwire", "--dry-run"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Would unwire"));
+    dhd(&temp_dir, &home).arg("unwire").assert().success();
+    assert_eq!(
+        fs::read_to_string(home.path().join(".zshrc")).unwrap(),
+        "setopt autocd\n"
+    );
+}
@@ -0,0 +1,63 @@
+use assert_cmd::Command;
+use std::fs;
+use tempfile::TempDir;
+
+fn apply(temp_dir: &TempDir, module: &str, args: &[&str]) -> assert_cmd::assert::Assert {
+    fs::write(temp_dir.path().join("web.ts"), module).unwrap();
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(temp_dir)
+        .env("DHD_HOME", temp_dir.path().join(".dhd"))
+        .arg("apply")
+        .args(args)
+        .assert()
+}
+
+#[test]
+fn test_changed_exit_code() {
+    let temp_dir = TempDir::new().unwrap();
+    let module = r#"
+export default defineModule("web")
+  .actions([command({ run: "touch a", unless: "test -f a" })]);
+"#;
+
+    apply(&temp_dir, module, &["--changed-exit-code", "2"]).code(2);
+    apply(&temp_dir, module, &["--changed-exit-code", "2"]).code(0);
+}
+
+#[test]
+fn test_failure_and_partial_failure() {
+    let temp_dir = TempDir::new().unwrap();
+    apply(
+        &temp_dir,
+        r#"export default defineModule("web").actions([command({ run: "false" })]);"#,
+        &[],
+    )
+    .code(1);
+
+    apply(
+        &temp_dir,
+        r#"
+export default defineModule("web")
+  .actions([command({ run: "touch a" }), command({ run: "false" })]);
+"#,
+        &[],
+    )
+    .code(4);
+}
+
+#[test]
+fn test_config_errors() {
+    let temp_dir = TempDir::new().unwrap();
+    let module = r#"export default defineModule("web").actions([]);"#;
+
+    apply(&temp_dir, module, &["--action", "frobnicate"]).code(3);
+    apply(&temp_dir, module, &["--frobnicate"]).code(3);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["plan", "--modules-path", "nope"])
+        .assert()
+        .code(3);
+}
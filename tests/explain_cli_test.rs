@@ -0,0 +1,77 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_explain_shows_resolved_module_without_applying() {
+    let temp_dir = TempDir::new().unwrap();
+    let target = temp_dir.path().join("out/gitconfig");
+    fs::write(
+        temp_dir.path().join("dhd.config.ts"),
+        r#"export default defineConfig({ variables: { email: "user@example.com" } });"#,
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("base.ts"),
+        r#"export default defineModule("base").actions([]);"#,
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("gitconfig.tmpl"),
+        "email = {{ email }}\n",
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("git.ts"),
+        format!(
+            r#"
+export default defineModule("git")
+  .dependsOn(["base"])
+  .actions([
+    template({{ source: "gitconfig.tmpl", target: "{}" }}),
+    command({{ run: "git --version" }})
+  ]);
+"#,
+            target.display()
+        ),
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["explain", "git"])
+        .assert()
+        .success()
+        .stdout(predicate::str::starts_with("● git (git.ts)\n"))
+        .stdout(predicate::str::contains("Apply order: base → git"))
+        .stdout(predicate::str::contains("\"user@example.com\""))
+        .stdout(predicate::str::contains(format!(
+            "source: gitconfig.tmpl → {}",
+            temp_dir.path().join("gitconfig.tmpl").display()
+        )))
+        .stdout(predicate::str::contains(
+            "input hash: none, runs every time",
+        ));
+
+    assert!(!target.exists());
+}
+
+#[test]
+fn test_explain_unknown_module_fails() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("base.ts"),
+        r#"export default defineModule("base").actions([]);"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["explain", "missing"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("Module 'missing' not found"));
+}
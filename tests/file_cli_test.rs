@@ -0,0 +1,82 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_file_applies_one_module_with_the_config_variables() {
+    let modules = TempDir::new().unwrap();
+    let scratch = TempDir::new().unwrap();
+    let dhd_home = TempDir::new().unwrap();
+    let target = scratch.path().join("out/gitconfig");
+    fs::write(
+        modules.path().join("dhd.config.ts"),
+        r#"export default defineConfig({ variables: { email: "user@example.com" } });"#,
+    )
+    .unwrap();
+    fs::write(
+        modules.path().join("base.ts"),
+        r#"export default defineModule("base").actions([command({ run: "touch base-ran" })]);"#,
+    )
+    .unwrap();
+    fs::write(
+        scratch.path().join("gitconfig.tmpl"),
+        "email = {{ email }}\n",
+    )
+    .unwrap();
+    fs::write(
+        scratch.path().join("git.ts"),
+        format!(
+            r#"
+export default defineModule("git")
+  .dependsOn(["base", "missing"])
+  .actions([
+    template({{ source: "gitconfig.tmpl", target: "{}" }})
+  ]);
+"#,
+            target.display()
+        ),
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&modules)
+        .env("DHD_HOME", dhd_home.path())
+        .args(["apply", "--yes", "--file"])
+        .arg(scratch.path().join("git.ts"))
+        .assert()
+        .success()
+        .stderr(predicate::str::contains(
+            "Not applying base, missing, which git depends on",
+        ));
+
+    assert_eq!(
+        fs::read_to_string(&target).unwrap(),
+        "email = user@example.com\n"
+    );
+    assert!(!modules.path().join("base-ran").exists());
+}
+
+#[test]
+fn test_file_must_be_a_module_file() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(temp_dir.path().join("notes.md"), "").unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", temp_dir.path())
+        .args(["plan", "--file", "notes.md"])
+        .assert()
+        .code(3)
+        .stderr(predicate::str::contains("notes.md is not a module file"));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--file", "notes.md", "--modules", "zsh"])
+        .assert()
+        .code(3)
+        .stderr(predicate::str::contains("cannot be used with"));
+}
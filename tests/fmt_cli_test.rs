@@ -0,0 +1,70 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_fmt_check_fails_until_modules_are_formatted() {
+    let temp_dir = TempDir::new().unwrap();
+    let module = temp_dir.path().join("zsh.dhd.yaml");
+    fs::write(
+        &module,
+        "actions:\n  - target: ~/.zshrc\n    type: symlink\n    source: zshrc\nname: zsh\n",
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["fmt", "--check"])
+        .assert()
+        .code(2)
+        .stdout(predicate::str::contains("zsh.dhd.yaml isn't formatted"));
+    assert!(fs::read_to_string(&module).unwrap().starts_with("actions:"));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("fmt")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Formatted zsh.dhd.yaml"));
+    assert_eq!(
+        fs::read_to_string(&module).unwrap(),
+        "name: zsh\nactions:\n- type: symlink\n  source: zshrc\n  target: ~/.zshrc\n"
+    );
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["fmt", "--check"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("All 1 module(s) are formatted"));
+}
+
+#[test]
+fn test_fmt_only_touches_module_files() {
+    let temp_dir = TempDir::new().unwrap();
+    let typescript = "export default defineModule(\"git\").actions([]);\n";
+    let settings = "b: 1\na: 2\n";
+    let commented = "name: zsh\n# The shell\ntags: [shell]\n";
+    fs::write(temp_dir.path().join("git.ts"), typescript).unwrap();
+    fs::write(temp_dir.path().join("settings.yaml"), settings).unwrap();
+    fs::write(temp_dir.path().join("zsh.dhd.yml"), commented).unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("fmt")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "Skipped zsh.dhd.yml: formatting would drop its comments",
+        ));
+
+    let read = |name: &str| fs::read_to_string(temp_dir.path().join(name)).unwrap();
+    assert_eq!(read("git.ts"), typescript);
+    assert_eq!(read("settings.yaml"), settings);
+    assert_eq!(read("zsh.dhd.yml"), commented);
+}
@@ -0,0 +1,81 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, home: &Path, source: &str) {
+    let module = format!(
+        r#"
+export default defineModule("tools")
+  .actions([
+    symlink({{ source: "{source}", target: "{home}/bin" }}),
+    copyFile({{ source: "./themes/*.toml", target: "{home}/themes" }})
+  ]);
+"#,
+        home = home.display()
+    );
+    fs::write(temp_dir.path().join("tools.ts"), module).unwrap();
+}
+
+#[test]
+#[cfg(unix)]
+fn test_glob_sources_apply_to_each_match() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    fs::create_dir(temp_dir.path().join("bin")).unwrap();
+    fs::write(temp_dir.path().join("bin/deploy"), "#!/bin/sh\n").unwrap();
+    fs::write(temp_dir.path().join("bin/lint"), "#!/bin/sh\n").unwrap();
+    fs::create_dir(temp_dir.path().join("themes")).unwrap();
+    fs::write(temp_dir.path().join("themes/dark.toml"), "bg = 0\n").unwrap();
+    fs::write(temp_dir.path().join("themes/README.md"), "themes\n").unwrap();
+    fs::create_dir(home.path().join("bin")).unwrap();
+    write_module(&temp_dir, home.path(), "./bin/*");
+    let apply = || {
+        let mut cmd = Command::cargo_bin("dhd").unwrap();
+        cmd.current_dir(&temp_dir)
+            .env("XDG_STATE_HOME", state.path())
+            .args(["apply", "--yes", "--changed-exit-code", "2"]);
+        cmd
+    };
+
+    apply().assert().code(2);
+    for name in ["deploy", "lint"] {
+        let link = home.path().join("bin").join(name);
+        assert_eq!(
+            fs::read_link(&link).unwrap(),
+            temp_dir.path().join("bin").join(name)
+        );
+    }
+    assert_eq!(
+        fs::read_to_string(home.path().join("themes/dark.toml")).unwrap(),
+        "bg = 0\n"
+    );
+    assert!(!home.path().join("themes/README.md").exists());
+
+    // Each match is up to date on its own
+    apply()
+        .assert()
+        .code(0)
+        .stdout(predicate::str::contains("up to date"));
+}
+
+#[test]
+fn test_glob_matching_nothing_warns() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    fs::create_dir(temp_dir.path().join("themes")).unwrap();
+    write_module(&temp_dir, home.path(), "./scripts/*");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("XDG_STATE_HOME", state.path())
+        .args(["apply", "--yes"])
+        .assert()
+        .success()
+        .stderr(predicate::str::contains("symlink: no files match"))
+        .stderr(predicate::str::contains("copyFile: no files match"));
+}
@@ -0,0 +1,80 @@
+use assert_cmd::Command;
+use std::fs;
+use tempfile::TempDir;
+
+const MODULE: &str = r#"
+export default defineModule("web")
+  .handlers({
+    reload: command({ run: "echo reload >> log" }),
+    restart: command({ run: "touch restarted" })
+  })
+  .actions([
+    command({ run: "touch a", unless: "test -f a", notify: "reload" }),
+    command({ run: "touch b", unless: "test -f b", notify: "reload" })
+  ]);
+"#;
+
+fn apply(temp_dir: &TempDir) {
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(temp_dir)
+        .arg("apply")
+        .assert()
+        .success();
+}
+
+#[test]
+fn test_notified_handler_runs_once() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(temp_dir.path().join("web.ts"), MODULE).unwrap();
+
+    apply(&temp_dir);
+
+    let log = fs::read_to_string(temp_dir.path().join("log")).unwrap();
+    assert_eq!(log, "reload\n");
+    assert!(!temp_dir.path().join("restarted").exists());
+}
+
+#[test]
+fn test_handlers_do_not_run_when_nothing_changed() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(temp_dir.path().join("web.ts"), MODULE).unwrap();
+
+    apply(&temp_dir);
+    apply(&temp_dir);
+
+    let log = fs::read_to_string(temp_dir.path().join("log")).unwrap();
+    assert_eq!(log, "reload\n");
+}
+
+#[test]
+fn test_changed_when_decides_whether_handlers_are_notified() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("web.ts"),
+        r#"
+export default defineModule("web")
+  .handlers({
+    reload: command({ run: "echo reload >> log" })
+  })
+  .actions([
+    command({
+      run: "echo 'nothing to do'",
+      changedWhen: 'test "$DHD_STDOUT" != "nothing to do"',
+      notify: "reload"
+    })
+  ]);
+"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("apply")
+        .assert()
+        .success()
+        .stdout(predicates::str::contains("(up to date)"));
+
+    assert!(!temp_dir.path().join("log").exists());
+}
@@ -0,0 +1,102 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use serde_json::Value;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, target: &TempDir) {
+    fs::write(temp_dir.path().join("zshrc"), "export EDITOR=vim\n").unwrap();
+    let module = format!(
+        r#"export default defineModule("shell").actions([symlink({{ source: "./zshrc", target: "{}" }})]);"#,
+        target.path().join(".zshrc").display()
+    );
+    fs::write(temp_dir.path().join("shell.ts"), module).unwrap();
+}
+
+fn dhd(temp_dir: &TempDir, state: &TempDir, args: &[&str]) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir)
+        .env("XDG_STATE_HOME", state.path())
+        .args(args);
+    cmd
+}
+
+#[test]
+fn test_history_lists_applies_newest_first() {
+    let temp_dir = TempDir::new().unwrap();
+    let target = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_module(&temp_dir, &target);
+
+    dhd(&temp_dir, &state, &["history"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("No applies recorded yet"));
+
+    dhd(&temp_dir, &state, &["apply", "--yes"])
+        .assert()
+        .success();
+    dhd(&temp_dir, &state, &["apply", "--yes"])
+        .assert()
+        .success();
+    // Dry runs aren't applies
+    dhd(&temp_dir, &state, &["apply", "--dry-run"])
+        .assert()
+        .success();
+
+    dhd(&temp_dir, &state, &["history"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Last successful apply: just now"))
+        .stdout(predicate::str::contains("1 of 1 actions changed (shell)"))
+        .stdout(predicate::str::contains("nothing changed"));
+
+    let output = dhd(&temp_dir, &state, &["history", "--json"])
+        .assert()
+        .success()
+        .get_output()
+        .stdout
+        .clone();
+    let history: Value = serde_json::from_slice(&output).unwrap();
+    let applies = history["applies"].as_array().unwrap();
+    assert_eq!(applies.len(), 2);
+    assert_eq!(applies[0]["changed"], 0);
+    assert_eq!(applies[1]["changed"], 1);
+    assert_eq!(applies[1]["changedModules"][0], "shell");
+    assert_eq!(history["lastSuccess"], applies[0]["timestamp"]);
+
+    let output = dhd(&temp_dir, &state, &["history", "--json", "--last", "1"])
+        .assert()
+        .success()
+        .get_output()
+        .stdout
+        .clone();
+    let history: Value = serde_json::from_slice(&output).unwrap();
+    assert_eq!(history["applies"].as_array().unwrap().len(), 1);
+}
+
+#[test]
+fn test_history_limit_keeps_the_latest_applies() {
+    let temp_dir = TempDir::new().unwrap();
+    let target = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_module(&temp_dir, &target);
+    fs::write(
+        temp_dir.path().join("dhd.config.ts"),
+        "export default defineConfig({ historyLimit: 1 });",
+    )
+    .unwrap();
+
+    for _ in 0..3 {
+        dhd(&temp_dir, &state, &["apply", "--yes"])
+            .assert()
+            .success();
+    }
+
+    let history: Value =
+        serde_json::from_str(&fs::read_to_string(state.path().join("dhd/history.json")).unwrap())
+            .unwrap();
+    let applies = history.as_array().unwrap();
+    assert_eq!(applies.len(), 1);
+    assert_eq!(applies[0]["changed"], 0);
+}
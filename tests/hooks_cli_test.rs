@@ -0,0 +1,70 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, name: &str, hooks: &str, run: &str) {
+    let module = format!(
+        r#"
+export default defineModule("{name}")
+  {hooks}
+  .actions([
+    command({{ run: "{run}" }})
+  ]);
+"#
+    );
+    fs::write(temp_dir.path().join(format!("{}.ts", name)), module).unwrap();
+}
+
+#[test]
+fn test_hooks_run_around_actions() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(
+        &temp_dir,
+        "web",
+        r#".preApply("echo pre >> log").postApply("echo post $DHD_CHANGED >> log")"#,
+        "echo action >> log",
+    );
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("apply")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("postApply hook"));
+
+    let log = fs::read_to_string(temp_dir.path().join("log")).unwrap();
+    assert_eq!(log, "pre\naction\npost true\n");
+}
+
+#[test]
+fn test_failing_pre_apply_hook_skips_module() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "web", r#".preApply("exit 1")"#, "touch ran");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("apply")
+        .assert()
+        .failure();
+
+    assert!(!temp_dir.path().join("ran").exists());
+}
+
+#[test]
+fn test_hooks_are_not_run_in_dry_run() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "web", r#".preApply("touch pre")"#, "true");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--dry-run"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Would run"));
+
+    assert!(!temp_dir.path().join("pre").exists());
+}
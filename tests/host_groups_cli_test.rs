@@ -0,0 +1,115 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn fleet() -> TempDir {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("dhd.config.ts"),
+        r#"
+export default defineConfig({
+    variables: { region: "eu", port: "80", owner: "ops" },
+    groups: {
+        web: { hosts: ["web01", "web02"], tags: ["web"], variables: { port: "8080", role: "web" } },
+        monitored: { hosts: ["web01"], modules: ["agent"], variables: { port: "9090" } },
+    },
+    hosts: {
+        web01: { variables: { role: "primary" } },
+    },
+});
+"#,
+    )
+    .unwrap();
+    let out = temp_dir.path().display();
+    fs::write(
+        temp_dir.path().join("nginx.ts"),
+        format!(
+            r#"
+export default defineModule("nginx")
+  .tags(["web"])
+  .actions([
+    template({{ content: "{{{{ region }}}} {{{{ port }}}} {{{{ role }}}} {{{{ owner }}}}\n", target: "{out}/nginx.conf" }})
+  ]);
+"#
+        ),
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("agent.ts"),
+        r#"export default defineModule("agent").actions([command({ run: "touch agent-ran" })]);"#,
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("db.ts"),
+        r#"export default defineModule("db").actions([command({ run: "touch db-ran" })]);"#,
+    )
+    .unwrap();
+    temp_dir
+}
+
+#[test]
+fn test_host_inherits_from_its_groups() {
+    let temp_dir = fleet();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--host", "web01"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "Using host profile 'web01' (groups: monitored, web)",
+        ));
+
+    // Host variables beat group variables, which beat the config's; of the
+    // groups listing the host, the later by name wins
+    let conf = fs::read_to_string(temp_dir.path().join("nginx.conf")).unwrap();
+    assert_eq!(conf, "eu 8080 primary ops\n");
+    assert!(temp_dir.path().join("agent-ran").exists());
+    assert!(!temp_dir.path().join("db-ran").exists());
+}
+
+#[test]
+fn test_group_members_need_no_profile_of_their_own() {
+    let temp_dir = fleet();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--host", "web02"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "Using host profile 'web02' (groups: web)",
+        ));
+
+    let conf = fs::read_to_string(temp_dir.path().join("nginx.conf")).unwrap();
+    assert_eq!(conf, "eu 8080 web ops\n");
+    assert!(!temp_dir.path().join("agent-ran").exists());
+}
+
+#[test]
+fn test_unknown_group_fails() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("dhd.config.ts"),
+        r#"export default defineConfig({ hosts: { web01: { groups: ["web"] } } });"#,
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("base.ts"),
+        r#"export default defineModule("base").actions([]);"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["plan", "--host", "web01"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains(
+            "Host 'web01' is in group 'web', which 'groups' doesn't define",
+        ));
+}
@@ -0,0 +1,69 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, home: &TempDir) {
+    fs::write(temp_dir.path().join("zshrc"), "export EDITOR=vim\n").unwrap();
+    let module = format!(
+        r#"export default defineModule("shell").actions([symlink({{ source: "./zshrc", target: "{}" }})]);"#,
+        home.path().join(".zshrc").display()
+    );
+    fs::write(temp_dir.path().join("shell.ts"), module).unwrap();
+}
+
+fn dhd(temp_dir: &TempDir, state: &TempDir) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir)
+        .env("XDG_STATE_HOME", state.path());
+    cmd
+}
+
+#[test]
+fn test_missing_modules_fail_the_run() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_module(&temp_dir, &home);
+
+    dhd(&temp_dir, &state)
+        .args(["apply", "--yes", "--modules", "shell,vim,tmux"])
+        .assert()
+        .code(3)
+        .stderr(predicate::str::contains("Modules 'vim', 'tmux' not found"));
+    assert!(!home.path().join(".zshrc").exists());
+}
+
+#[test]
+fn test_ignore_missing_goes_on_without_them() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_module(&temp_dir, &home);
+
+    dhd(&temp_dir, &state)
+        .args([
+            "apply",
+            "--yes",
+            "--modules",
+            "shell,vim",
+            "--ignore-missing",
+        ])
+        .assert()
+        .code(5)
+        .stderr(predicate::str::contains(
+            "Module 'vim' not found, going on without",
+        ));
+    assert!(home.path().join(".zshrc").is_symlink());
+
+    // Other exit codes win
+    dhd(&temp_dir, &state)
+        .args(["plan", "--modules", "shell,vim", "--ignore-missing"])
+        .assert()
+        .code(5);
+    fs::remove_file(home.path().join(".zshrc")).unwrap();
+    dhd(&temp_dir, &state)
+        .args(["plan", "--modules", "shell,vim", "--ignore-missing"])
+        .assert()
+        .code(2);
+}
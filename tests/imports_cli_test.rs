@@ -0,0 +1,132 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn git(dir: &Path, args: &[&str]) {
+    let status = std::process::Command::new("git")
+        .args(["-c", "user.name=dhd", "-c", "user.email=dhd@example.com"])
+        .args(args)
+        .current_dir(dir)
+        .status()
+        .unwrap();
+    assert!(status.success());
+}
+
+#[test]
+fn test_local_imports_are_namespaced() {
+    let temp_dir = TempDir::new().unwrap();
+    let base = temp_dir.path().join("base");
+    let host = temp_dir.path().join("host");
+    fs::create_dir_all(&base).unwrap();
+    fs::create_dir_all(&host).unwrap();
+    fs::write(
+        base.join("zsh.ts"),
+        r#"export default defineModule("zsh").dependsOn(["fonts"]).actions([]);"#,
+    )
+    .unwrap();
+    fs::write(
+        base.join("fonts.ts"),
+        r#"export default defineModule("fonts").actions([]);"#,
+    )
+    .unwrap();
+    fs::write(
+        host.join("laptop.ts"),
+        r#"export default defineModule("laptop").dependsOn(["base/zsh"]).actions([]);"#,
+    )
+    .unwrap();
+    fs::write(
+        host.join("dhd.config.ts"),
+        r#"export default defineConfig({ imports: [{ path: "../base" }] });"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&host)
+        .arg("list")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("base/zsh"))
+        .stdout(predicate::str::contains("base/fonts"));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&host)
+        .arg("check")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("All 3 module(s) are valid"));
+}
+
+#[test]
+fn test_git_imports_are_cached_until_update() {
+    let temp_dir = TempDir::new().unwrap();
+    let upstream = temp_dir.path().join("upstream");
+    let host = temp_dir.path().join("host");
+    let cache = temp_dir.path().join("cache");
+    fs::create_dir_all(&upstream).unwrap();
+    fs::create_dir_all(&host).unwrap();
+    git(&upstream, &["init", "-q"]);
+    fs::write(
+        upstream.join("git.ts"),
+        r#"export default defineModule("git").actions([]);"#,
+    )
+    .unwrap();
+    git(&upstream, &["add", "."]);
+    git(&upstream, &["commit", "-q", "-m", "git"]);
+    fs::write(
+        host.join("dhd.config.ts"),
+        format!(
+            r#"export default defineConfig({{ imports: [{{ git: "{}", namespace: "team" }}] }});"#,
+            upstream.display()
+        ),
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&host)
+        .env("XDG_CACHE_HOME", &cache)
+        .arg("list")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("team/git"));
+
+    // New upstream modules only show up after an update
+    fs::write(
+        upstream.join("vim.ts"),
+        r#"export default defineModule("vim").actions([]);"#,
+    )
+    .unwrap();
+    git(&upstream, &["add", "."]);
+    git(&upstream, &["commit", "-q", "-m", "vim"]);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&host)
+        .env("XDG_CACHE_HOME", &cache)
+        .arg("list")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("team/vim").not());
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&host)
+        .env("XDG_CACHE_HOME", &cache)
+        .arg("update")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Updated 1 import(s)"));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&host)
+        .env("XDG_CACHE_HOME", &cache)
+        .arg("list")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("team/vim"));
+}
@@ -0,0 +1,94 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_init_scaffolds_a_loadable_modules_directory() {
+    let temp_dir = TempDir::new().unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["init", "--distro", "debian", "--shell", "zsh"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("base.ts"));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("list")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("base"));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("check")
+        .assert()
+        .success();
+}
+
+#[test]
+fn test_init_refuses_to_overwrite_a_config() {
+    let temp_dir = TempDir::new().unwrap();
+    let config = temp_dir.path().join("dhd.config.ts");
+    fs::write(&config, "export default defineConfig({ jobs: 2 });\n").unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["init", "--distro", "arch", "--shell", "fish"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("--force"));
+    assert_eq!(
+        fs::read_to_string(&config).unwrap(),
+        "export default defineConfig({ jobs: 2 });\n"
+    );
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["init", "--distro", "arch", "--shell", "fish", "--force"])
+        .assert()
+        .success();
+    assert!(
+        fs::read_to_string(&config)
+            .unwrap()
+            .contains(r#"shell: "fish""#)
+    );
+}
+
+#[test]
+fn test_init_detects_the_distro_of_an_os_release_file() {
+    let temp_dir = TempDir::new().unwrap();
+    let os_release = temp_dir.path().join("os-release");
+    fs::write(&os_release, "NAME=\"Arch Linux\"\nID=arch\n").unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("--os-release-file")
+        .arg(&os_release)
+        .args(["init", "--shell", "zsh"])
+        .assert()
+        .success();
+    let module = fs::read_to_string(temp_dir.path().join("base.ts")).unwrap();
+    assert!(module.contains(r#"manager: "pacman""#), "{}", module);
+
+    // The environment variable does the same
+    let fedora = TempDir::new().unwrap();
+    fs::write(fedora.path().join("os-release"), "ID=fedora\n").unwrap();
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&fedora)
+        .env("DHD_OS_RELEASE", fedora.path().join("os-release"))
+        .args(["init", "--shell", "zsh"])
+        .assert()
+        .success();
+    let module = fs::read_to_string(fedora.path().join("base.ts")).unwrap();
+    assert!(module.contains(r#"manager: "dnf""#), "{}", module);
+}
@@ -0,0 +1,133 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn apply(temp_dir: &TempDir) -> assert_cmd::assert::Assert {
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(temp_dir)
+        .env("DHD_HOME", temp_dir.path().join("home"))
+        .args(["apply", "--yes"])
+        .assert()
+}
+
+#[test]
+fn test_inline_content_is_written_and_rendered() {
+    let temp_dir = TempDir::new().unwrap();
+    let out = temp_dir.path().join("out");
+    fs::write(
+        temp_dir.path().join("editor.ts"),
+        format!(
+            r#"
+export default defineModule("editor")
+  .actions([
+    copyFile({{ content: "EDITOR=nvim\n", target: "{out}/editor.conf", mode: 0o600 }}),
+    template({{ content: `[user]
+  email = {{{{ email }}}}
+`, target: "{out}/gitconfig", variables: {{ email: "user@example.com" }} }})
+  ]);
+"#,
+            out = out.display()
+        ),
+    )
+    .unwrap();
+
+    apply(&temp_dir).success();
+    assert_eq!(
+        fs::read_to_string(out.join("editor.conf")).unwrap(),
+        "EDITOR=nvim\n"
+    );
+    assert_eq!(
+        fs::read_to_string(out.join("gitconfig")).unwrap(),
+        "[user]\n  email = user@example.com\n"
+    );
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::PermissionsExt;
+        let mode = fs::metadata(out.join("editor.conf"))
+            .unwrap()
+            .permissions()
+            .mode();
+        assert_eq!(mode & 0o777, 0o600);
+    }
+
+    // Nothing to do the second time
+    apply(&temp_dir)
+        .success()
+        .stdout(predicate::str::contains("Write inline content -> "))
+        .stdout(predicate::str::contains("(up to date)"));
+}
+
+#[test]
+fn test_inline_content_is_written_and_rendered() {
+    let temp_dir = TempDir::new().unwrap();
+    let out = temp_dir.path().join("out");
+    fs::write(
+        temp_dir.path().join("editor.ts"),
+        format!(
+            r#"
+export default defineModule("editor")
+  .actions([
+    copyFile({{ content: "EDITOR=nvim\n", target: "{out}/editor.conf", mode: 0o600 }}),
+    template({{ content: `[user]
+  email = {{{{ email }}}}
+`, target: "{out}/gitconfig", variables: {{ email: "user@example.com" }} }})
+  ]);
+"#,
+            out = out.display()
+        ),
+    )
+    .unwrap();
+
+    apply(&temp_dir).success();
+    assert_eq!(
+        fs::read_to_string(out.join("editor.conf")).unwrap(),
+        "EDITOR=nvim\n"
+    );
+    assert_eq!(
+        fs::read_to_string(out.join("gitconfig")).unwrap(),
+        "[user]\n  email = user@example.com\n"
+    );
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::PermissionsExt;
+        let mode = fs::metadata(out.join("editor.conf"))
+            .unwrap()
+            .permissions()
+            .mode();
+        assert_eq!(mode & 0o777, 0o600);
+    }
+
+    // Nothing to do the second time
+    apply(&temp_dir)
+        .success()
+        .stdout(
+            predicate::str::contains("Write inline content -> ")
+                .and(predicate::str::contains("(up to date)")),
+        )
+        .stdout(predicate::str::contains("✅").not());
+}
+
+#[test]
+fn test_source_and_content_are_not_both_allowed() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("editor.ts"),
+        r#"
+export default defineModule("editor")
+  .actions([copyFile({ source: "editor.conf", content: "EDITOR=nvim", target: "/tmp/editor.conf" })]);
+"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("check")
+        .assert()
+        .code(3)
+        .stdout(predicate::str::contains(
+            "copyFile takes either 'source' or 'content', not both",
+        ));
+}
@@ -0,0 +1,101 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, name: &str, depends_on: &[&str], run: &str) {
+    let depends_on = depends_on
+        .iter()
+        .map(|dep| format!("\"{}\"", dep))
+        .collect::<Vec<_>>()
+        .join(", ");
+    let module = format!(
+        r#"
+export default defineModule("{name}")
+  .dependsOn([{depends_on}])
+  .actions([
+    command({{ run: "{run}" }})
+  ]);
+"#
+    );
+    fs::write(temp_dir.path().join(format!("{}.ts", name)), module).unwrap();
+}
+
+#[test]
+fn test_parallel_apply_respects_dependencies() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(
+        &temp_dir,
+        "base",
+        &[],
+        "sleep 0.2 && echo base >> order.txt",
+    );
+    write_module(&temp_dir, "tools", &["base"], "echo tools >> order.txt");
+    write_module(&temp_dir, "fonts", &[], "echo fonts > fonts.txt");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--jobs", "4"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("with 3 parallel workers"))
+        .stdout(predicate::str::contains("● tools"));
+
+    let order = fs::read_to_string(temp_dir.path().join("order.txt")).unwrap();
+    assert_eq!(order, "base\ntools\n");
+    assert!(temp_dir.path().join("fonts.txt").exists());
+}
+
+#[test]
+fn test_failed_module_skips_its_dependents() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "base", &[], "exit 1");
+    write_module(&temp_dir, "tools", &["base"], "touch tools.txt");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "-j", "2"])
+        .assert()
+        .failure()
+        .stdout(predicate::str::contains(
+            "tools skipped (dependency base did not complete)",
+        ));
+
+    assert!(!temp_dir.path().join("tools.txt").exists());
+}
+
+#[test]
+fn test_jobs_must_be_positive() {
+    let temp_dir = TempDir::new().unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--jobs", "0"])
+        .assert()
+        .failure();
+}
+
+#[test]
+fn test_net_jobs_must_be_positive() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "base", &[], "touch base.txt");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--net-jobs", "0"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("--net-jobs"));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--net-jobs", "1", "--jobs", "8"])
+        .assert()
+        .success();
+    assert!(temp_dir.path().join("base.txt").exists());
+}
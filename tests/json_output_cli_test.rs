@@ -0,0 +1,109 @@
+use assert_cmd::Command;
+use serde_json::Value;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, name: &str, depends_on: &[&str], run: &str) {
+    let depends_on = depends_on
+        .iter()
+        .map(|dep| format!("\"{}\"", dep))
+        .collect::<Vec<_>>()
+        .join(", ");
+    let module = format!(
+        r#"
+export default defineModule("{name}")
+  .dependsOn([{depends_on}])
+  .actions([
+    command({{ run: "{run}" }})
+  ]);
+"#
+    );
+    fs::write(temp_dir.path().join(format!("{}.ts", name)), module).unwrap();
+}
+
+fn apply_json(temp_dir: &TempDir) -> (bool, Value) {
+    let output = Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(temp_dir)
+        .args(["apply", "--output", "json", "-j", "1"])
+        .output()
+        .unwrap();
+    let report = serde_json::from_slice(&output.stdout).expect("stdout should be JSON");
+    (output.status.success(), report)
+}
+
+fn module<'a>(report: &'a Value, name: &str) -> &'a Value {
+    report["modules"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .find(|m| m["module"] == name)
+        .unwrap_or_else(|| panic!("module {} missing from report", name))
+}
+
+#[test]
+fn test_json_output_reports_applied_actions() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "hello", &[], "touch hello.txt");
+
+    let (success, report) = apply_json(&temp_dir);
+    assert!(success);
+    assert_eq!(report["schemaVersion"], 1);
+    assert_eq!(report["dryRun"], false);
+
+    let hello = module(&report, "hello");
+    assert_eq!(hello["status"], "applied");
+    assert_eq!(hello["actions"][0]["module"], "hello");
+    assert_eq!(hello["actions"][0]["status"], "applied");
+    assert!(hello["actions"][0]["error"].is_null());
+
+    assert_eq!(report["summary"]["applied"], 1);
+    assert_eq!(report["summary"]["failed"], 0);
+    assert!(temp_dir.path().join("hello.txt").exists());
+}
+
+#[test]
+fn test_json_output_reports_failures() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "base", &[], "exit 3");
+    write_module(&temp_dir, "tools", &["base"], "touch tools.txt");
+
+    let (success, report) = apply_json(&temp_dir);
+    assert!(!success);
+
+    let base = module(&report, "base");
+    assert_eq!(base["status"], "failed");
+    assert_eq!(base["actions"][0]["status"], "failed");
+    assert!(
+        base["actions"][0]["error"]
+            .as_str()
+            .unwrap()
+            .contains("exit code 3")
+    );
+
+    let tools = module(&report, "tools");
+    assert_eq!(tools["status"], "skipped");
+    assert!(tools["reason"].as_str().unwrap().contains("base"));
+    assert_eq!(tools["actions"][0]["status"], "skipped");
+
+    assert_eq!(report["summary"]["failed"], 1);
+    assert!(!temp_dir.path().join("tools.txt").exists());
+}
+
+#[test]
+fn test_json_output_keeps_logs_off_stdout() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "hello", &[], "touch hello.txt");
+
+    let output = Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--output", "json", "-vv"])
+        .output()
+        .unwrap();
+    assert!(output.status.success());
+
+    let report: Value = serde_json::from_slice(&output.stdout).expect("stdout should be JSON");
+    assert_eq!(module(&report, "hello")["status"], "applied");
+    assert!(String::from_utf8_lossy(&output.stderr).contains("exec: sh -c 'touch hello.txt'"));
+}
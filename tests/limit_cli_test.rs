@@ -0,0 +1,93 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, state_dir: &Path) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir).env("XDG_STATE_HOME", state_dir);
+    cmd
+}
+
+#[test]
+fn test_limit_applies_changes_in_dependency_order_across_runs() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let dir = |name: &str| home.path().join(name);
+    // "app" sorts first, but depends on "base"
+    fs::write(
+        temp_dir.path().join("app.ts"),
+        format!(
+            r#"export default defineModule("app")
+  .dependsOn(["base"])
+  .actions([ensureDir({{ path: "{}" }})]);"#,
+            dir("app").display()
+        ),
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("base.ts"),
+        format!(
+            r#"export default defineModule("base")
+  .actions([
+    ensureDir({{ path: "{}" }}),
+    ensureDir({{ path: "{}" }})
+  ]);"#,
+            dir("one").display(),
+            dir("two").display()
+        ),
+    )
+    .unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--limit", "2"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("✅ Completed: 2"))
+        .stdout(predicate::str::contains(
+            "Stopped at --limit 2, 1 change(s) left for the next run:",
+        ))
+        .stdout(predicate::str::contains(format!(
+            "   - app: Create directory {}",
+            dir("app").display()
+        )));
+    assert!(dir("one").is_dir());
+    assert!(dir("two").is_dir());
+    assert!(!dir("app").exists());
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--limit", "2"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("2 atoms already up to date"))
+        .stdout(predicate::str::contains("✅ Completed: 1"))
+        .stdout(predicate::str::contains("Stopped at --limit").not());
+    assert!(dir("app").is_dir());
+}
+
+#[test]
+fn test_limit_zero_applies_nothing() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let target = home.path().join("dir");
+    fs::write(
+        temp_dir.path().join("base.ts"),
+        format!(
+            r#"export default defineModule("base").actions([ensureDir({{ path: "{}" }})]);"#,
+            target.display()
+        ),
+    )
+    .unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--limit", "0"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "Stopped at --limit 0, 1 change(s) left for the next run:",
+        ));
+    assert!(!target.exists());
+}
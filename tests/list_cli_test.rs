@@ -0,0 +1,81 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn modules_dir() -> TempDir {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("base.ts"),
+        r#"export default defineModule("base")
+    .description("Base packages")
+    .tags("core")
+    .actions([]);"#,
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("zsh.ts"),
+        r#"export default defineModule("zsh")
+    .description("Shell setup")
+    .tags("shell", "desktop")
+    .dependsOn(["base"])
+    .actions([]);"#,
+    )
+    .unwrap();
+    temp_dir
+}
+
+#[test]
+fn test_list_shows_dependencies() {
+    let temp_dir = modules_dir();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("list")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Found 2 TypeScript module(s):"))
+        .stdout(predicate::str::contains(
+            "zsh (zsh.ts) [shell, desktop] - Shell setup",
+        ))
+        .stdout(predicate::str::contains("      depends on: base"));
+}
+
+#[test]
+fn test_list_filters_by_tag() {
+    let temp_dir = modules_dir();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["modules", "--tag", "shell"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("1 of 2 module(s) match"))
+        .stdout(predicate::str::contains("zsh (zsh.ts)"))
+        .stdout(predicate::str::contains("base (base.ts)").not());
+}
+
+#[test]
+fn test_list_json() {
+    let temp_dir = modules_dir();
+
+    let output = Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["list", "--json", "--exclude-tags", "core"])
+        .output()
+        .unwrap();
+    assert!(output.status.success());
+
+    let document: serde_json::Value = serde_json::from_slice(&output.stdout).unwrap();
+    let modules = document["modules"].as_array().unwrap();
+    assert_eq!(modules.len(), 1);
+    assert_eq!(modules[0]["name"], "zsh");
+    assert_eq!(modules[0]["path"], "zsh.ts");
+    assert_eq!(modules[0]["description"], "Shell setup");
+    assert_eq!(modules[0]["tags"], serde_json::json!(["shell", "desktop"]));
+    assert_eq!(modules[0]["dependsOn"], serde_json::json!(["base"]));
+    assert!(document["failed"].as_array().unwrap().is_empty());
+}
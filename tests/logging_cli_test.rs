@@ -0,0 +1,117 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn setup(temp_dir: &TempDir) {
+    fs::write(
+        temp_dir.path().join("hello.ts"),
+        r#"
+export default defineModule("hello")
+  .actions([
+    command({ run: "touch hello.txt", unless: "test -f hello.txt" })
+  ]);
+"#,
+    )
+    .unwrap();
+}
+
+#[test]
+fn test_default_output_has_no_logs() {
+    let temp_dir = TempDir::new().unwrap();
+    setup(&temp_dir);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("apply")
+        .assert()
+        .success()
+        .stderr(predicate::str::contains("[DEBUG]").not())
+        .stderr(predicate::str::contains("[INFO ]").not());
+}
+
+#[test]
+fn test_verbose_logs_progress_as_plain_lines() {
+    let temp_dir = TempDir::new().unwrap();
+    setup(&temp_dir);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "-v"])
+        .assert()
+        .success()
+        .stderr(predicate::str::contains("[INFO ] [1/1] hello: "));
+
+    // Without a terminal there is no progress display at all
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("apply")
+        .assert()
+        .success()
+        .stderr(predicate::str::contains("[1/1]").not());
+}
+
+#[test]
+fn test_debug_logs_commands_and_checks() {
+    let temp_dir = TempDir::new().unwrap();
+    setup(&temp_dir);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "-vv"])
+        .assert()
+        .success()
+        .stderr(predicate::str::contains("[INFO ] applying hello"))
+        .stderr(predicate::str::contains(
+            "[DEBUG] exec: sh -c 'test -f hello.txt'",
+        ))
+        .stderr(predicate::str::contains("changes needed"))
+        .stderr(predicate::str::contains("argv:").not());
+}
+
+#[test]
+fn test_trace_logs_argv_and_environment() {
+    let temp_dir = TempDir::new().unwrap();
+    setup(&temp_dir);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_TRACE_MARKER", "present")
+        .args(["--log-level", "trace", "apply"])
+        .assert()
+        .success()
+        .stderr(predicate::str::contains(
+            r#"[TRACE] argv: ["sh", "-c", "touch hello.txt"]"#,
+        ))
+        .stderr(predicate::str::contains(
+            "[TRACE] env: DHD_TRACE_MARKER=present",
+        ));
+}
+
+#[test]
+fn test_log_level_overrides_verbosity() {
+    let temp_dir = TempDir::new().unwrap();
+    setup(&temp_dir);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "-vv", "--log-level", "warn"])
+        .assert()
+        .success()
+        .stderr(predicate::str::contains("[DEBUG]").not());
+}
+
+#[test]
+fn test_unknown_log_level_is_rejected() {
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .args(["list", "--log-level", "loud"])
+        .assert()
+        .failure();
+}
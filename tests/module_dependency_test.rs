@@ -164,6 +164,7 @@ fn test_missing_dependency_error() {
     use dhd::discovery::DiscoveredModule;
     use dhd::module::ModuleDefinition;
     use dhd::dependency_resolver::DependencyError;
+    use std::collections::HashMap;
     use std::path::PathBuf;
     
     let modules = vec![
@@ -171,6 +172,9 @@ fn test_missing_dependency_error() {
             source: DiscoveredModule {
                 path: PathBuf::from("module_with_missing_dep.ts"),
                 name: "module-with-missing-dep".to_string(),
+                namespace: None,
+                variables: HashMap::new(),
+                package_groups: HashMap::new(),
             },
             definition: ModuleDefinition {
                 name: "module-with-missing-dep".to_string(),
@@ -178,7 +182,13 @@ fn test_missing_dependency_error() {
                 tags: vec![],
                 dependencies: vec!["non-existent-module".to_string()],
                 when: None,
+                enabled: true,
                 actions: vec![],
+                pre_apply: None,
+                post_apply: None,
+                handlers: Vec::new(),
+                variables: HashMap::new(),
+                variable_schema: HashMap::new(),
             },
         }
     ];
@@ -94,6 +94,7 @@ export default defineModule("postgresql-dev")
 #[test]
 fn test_module_dependency_ordering() {
     use dhd::{DiscoveredModule, LoadedModule, ModuleDefinition};
+    use std::collections::HashMap;
     use std::path::PathBuf;
 
     // Create modules with dependencies
@@ -102,6 +103,9 @@ fn test_module_dependency_ordering() {
             source: DiscoveredModule {
                 path: PathBuf::from("app.ts"),
                 name: "app".to_string(),
+                namespace: None,
+                variables: HashMap::new(),
+                package_groups: HashMap::new(),
             },
             definition: ModuleDefinition {
                 name: "app".to_string(),
@@ -110,12 +114,21 @@ fn test_module_dependency_ordering() {
                 dependencies: vec!["lib1".to_string(), "lib2".to_string()],
                 actions: vec![],
                 when: None,
+                enabled: true,
+                pre_apply: None,
+                post_apply: None,
+                handlers: Vec::new(),
+                variables: HashMap::new(),
+                variable_schema: HashMap::new(),
             },
         },
         LoadedModule {
             source: DiscoveredModule {
                 path: PathBuf::from("lib1.ts"),
                 name: "lib1".to_string(),
+                namespace: None,
+                variables: HashMap::new(),
+                package_groups: HashMap::new(),
             },
             definition: ModuleDefinition {
                 name: "lib1".to_string(),
@@ -124,12 +137,21 @@ fn test_module_dependency_ordering() {
                 dependencies: vec!["base".to_string()],
                 actions: vec![],
                 when: None,
+                enabled: true,
+                pre_apply: None,
+                post_apply: None,
+                handlers: Vec::new(),
+                variables: HashMap::new(),
+                variable_schema: HashMap::new(),
             },
         },
         LoadedModule {
             source: DiscoveredModule {
                 path: PathBuf::from("lib2.ts"),
                 name: "lib2".to_string(),
+                namespace: None,
+                variables: HashMap::new(),
+                package_groups: HashMap::new(),
             },
             definition: ModuleDefinition {
                 name: "lib2".to_string(),
@@ -138,12 +160,21 @@ fn test_module_dependency_ordering() {
                 dependencies: vec!["base".to_string()],
                 actions: vec![],
                 when: None,
+                enabled: true,
+                pre_apply: None,
+                post_apply: None,
+                handlers: Vec::new(),
+                variables: HashMap::new(),
+                variable_schema: HashMap::new(),
             },
         },
         LoadedModule {
             source: DiscoveredModule {
                 path: PathBuf::from("base.ts"),
                 name: "base".to_string(),
+                namespace: None,
+                variables: HashMap::new(),
+                package_groups: HashMap::new(),
             },
             definition: ModuleDefinition {
                 name: "base".to_string(),
@@ -152,6 +183,12 @@ fn test_module_dependency_ordering() {
                 dependencies: vec![],
                 actions: vec![],
                 when: None,
+                enabled: true,
+                pre_apply: None,
+                post_apply: None,
+                handlers: Vec::new(),
+                variables: HashMap::new(),
+                variable_schema: HashMap::new(),
             },
         },
     ];
@@ -203,6 +240,7 @@ export default defineModule("rust-dev")
 #[test]
 fn test_filter_modules_by_tags() {
     use dhd::{DiscoveredModule, LoadedModule, ModuleDefinition};
+    use std::collections::HashMap;
     use std::path::PathBuf;
 
     let modules = vec![
@@ -210,6 +248,9 @@ fn test_filter_modules_by_tags() {
             source: DiscoveredModule {
                 path: PathBuf::from("desktop-app.ts"),
                 name: "desktop-app".to_string(),
+                namespace: None,
+                variables: HashMap::new(),
+                package_groups: HashMap::new(),
             },
             definition: ModuleDefinition {
                 name: "desktop-app".to_string(),
@@ -218,12 +259,21 @@ fn test_filter_modules_by_tags() {
                 dependencies: vec![],
                 actions: vec![],
                 when: None,
+                enabled: true,
+                pre_apply: None,
+                post_apply: None,
+                handlers: Vec::new(),
+                variables: HashMap::new(),
+                variable_schema: HashMap::new(),
             },
         },
         LoadedModule {
             source: DiscoveredModule {
                 path: PathBuf::from("cli-tool.ts"),
                 name: "cli-tool".to_string(),
+                namespace: None,
+                variables: HashMap::new(),
+                package_groups: HashMap::new(),
             },
             definition: ModuleDefinition {
                 name: "cli-tool".to_string(),
@@ -232,12 +282,21 @@ fn test_filter_modules_by_tags() {
                 dependencies: vec![],
                 actions: vec![],
                 when: None,
+                enabled: true,
+                pre_apply: None,
+                post_apply: None,
+                handlers: Vec::new(),
+                variables: HashMap::new(),
+                variable_schema: HashMap::new(),
             },
         },
         LoadedModule {
             source: DiscoveredModule {
                 path: PathBuf::from("dev-tool.ts"),
                 name: "dev-tool".to_string(),
+                namespace: None,
+                variables: HashMap::new(),
+                package_groups: HashMap::new(),
             },
             definition: ModuleDefinition {
                 name: "dev-tool".to_string(),
@@ -246,6 +305,12 @@ fn test_filter_modules_by_tags() {
                 dependencies: vec![],
                 actions: vec![],
                 when: None,
+                enabled: true,
+                pre_apply: None,
+                post_apply: None,
+                handlers: Vec::new(),
+                variables: HashMap::new(),
+                variable_schema: HashMap::new(),
             },
         },
     ];
@@ -0,0 +1,63 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_module(dir: &std::path::Path, name: &str, description: &str) {
+    fs::create_dir_all(dir).unwrap();
+    fs::write(
+        dir.join(format!("{}.ts", name)),
+        format!(
+            r#"export default defineModule("{}").description("{}").actions([]);"#,
+            name, description
+        ),
+    )
+    .unwrap();
+}
+
+#[test]
+fn test_later_modules_paths_override_earlier_ones() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir.path().join("common"), "git", "common git");
+    write_module(&temp_dir.path().join("common"), "zsh", "common zsh");
+    write_module(&temp_dir.path().join("host"), "zsh", "host zsh");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["list", "--modules-path", "common", "--modules-path", "host"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("git (common/git.ts) - common git"))
+        .stdout(predicate::str::contains("zsh (host/zsh.ts) - host zsh"))
+        .stdout(predicate::str::contains("common zsh").not())
+        .stderr(predicate::str::contains("overrides"));
+}
+
+#[test]
+fn test_modules_path_accepts_a_colon_separated_list() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir.path().join("common"), "zsh", "common zsh");
+    write_module(&temp_dir.path().join("host"), "zsh", "host zsh");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["list", "--modules-path", "host:common"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("zsh (common/zsh.ts) - common zsh"));
+}
+
+#[test]
+fn test_missing_modules_path_is_an_error() {
+    let temp_dir = TempDir::new().unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["check", "--modules-path", "nope"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("does not exist"));
+}
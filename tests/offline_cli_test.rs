@@ -0,0 +1,74 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, state_dir: &Path) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir).env("XDG_STATE_HOME", state_dir);
+    cmd
+}
+
+#[test]
+fn test_no_network_keeps_downloads_and_applies_local_actions() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let downloaded = home.path().join("tool.sh");
+    let dir = home.path().join("cache");
+    fs::write(&downloaded, "#!/bin/sh\n").unwrap();
+    fs::write(
+        temp_dir.path().join("tools.ts"),
+        format!(
+            r#"export default defineModule("tools")
+  .actions([
+    httpDownload({{ url: "https://example.com/tool.sh", destination: "{}" }}),
+    ensureDir({{ path: "{}" }})
+  ]);"#,
+            downloaded.display(),
+            dir.display()
+        ),
+    )
+    .unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--no-network"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("📴 Skipped offline (1):"))
+        .stdout(predicate::str::contains(
+            "   - Download https://example.com/tool.sh",
+        ));
+    assert_eq!(fs::read_to_string(&downloaded).unwrap(), "#!/bin/sh\n");
+    assert!(dir.is_dir());
+}
+
+#[test]
+fn test_no_network_fails_fast_on_missing_downloads() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let missing = home.path().join("tool.sh");
+    fs::write(
+        temp_dir.path().join("tools.ts"),
+        format!(
+            r#"export default defineModule("tools")
+  .actions([
+    httpDownload({{ url: "https://example.com/tool.sh", destination: "{}" }})
+  ]);"#,
+            missing.display()
+        ),
+    )
+    .unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--no-network"])
+        .assert()
+        .failure()
+        .stdout(predicate::str::contains(
+            "needs the network, which --no-network turns off",
+        ))
+        .stdout(predicate::str::contains("Skipped offline").not());
+    assert!(!missing.exists());
+}
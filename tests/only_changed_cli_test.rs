@@ -0,0 +1,52 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, state_dir: &Path) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir).env("XDG_STATE_HOME", state_dir);
+    cmd
+}
+
+#[test]
+fn test_only_changed_runs_drifted_actions_only() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let zshrc = home.path().join(".zshrc");
+    let vimrc = home.path().join(".vimrc");
+    fs::write(
+        temp_dir.path().join("dotfiles.ts"),
+        format!(
+            r#"export default defineModule("dotfiles")
+  .actions([
+    copyFile({{ source: "./zshrc", target: "{}" }}),
+    copyFile({{ source: "./vimrc", target: "{}" }})
+  ]);"#,
+            zshrc.display(),
+            vimrc.display()
+        ),
+    )
+    .unwrap();
+    fs::write(temp_dir.path().join("zshrc"), "zsh\n").unwrap();
+    fs::write(temp_dir.path().join("vimrc"), "vim\n").unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--only-changed"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("0 atoms already up to date"))
+        .stdout(predicate::str::contains("✅ Completed: 2"));
+
+    fs::write(&vimrc, "drifted\n").unwrap();
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--only-changed", "--yes"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("1 atoms already up to date"))
+        .stdout(predicate::str::contains("✅ Completed: 1"));
+    assert_eq!(fs::read_to_string(&vimrc).unwrap(), "vim\n");
+    assert_eq!(fs::read_to_string(&zshrc).unwrap(), "zsh\n");
+}
@@ -0,0 +1,29 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_reserved_package_manager_flags_fail_the_config() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("dhd.config.ts"),
+        r#"export default defineConfig({ packageManagers: { apt: { extraArgs: ["--download-only"] } } });"#,
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("base.ts"),
+        r#"export default defineModule("base").actions([]);"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("plan")
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains(
+            "'packageManagers.apt': extraArgs can't include '--download-only'",
+        ));
+}
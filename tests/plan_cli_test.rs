@@ -0,0 +1,89 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_directory_module(temp_dir: &TempDir, target: &std::path::Path) {
+    let module = format!(
+        r#"
+export default defineModule("dirs")
+  .description("Create a directory")
+  .actions([
+    directory({{ path: "{}" }})
+  ]);
+"#,
+        target.display()
+    );
+    fs::write(temp_dir.path().join("dirs.ts"), module).unwrap();
+}
+
+#[test]
+fn test_plan_reports_pending_changes_without_applying() {
+    let temp_dir = TempDir::new().unwrap();
+    let target = temp_dir.path().join("to-create");
+    write_directory_module(&temp_dir, &target);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("plan")
+        .assert()
+        .code(2)
+        .stdout(predicate::str::contains("pending"))
+        .stdout(predicate::str::contains("to-create"));
+
+    assert!(!target.exists(), "plan must not modify the system");
+}
+
+#[test]
+fn test_module_description_follows_its_name() {
+    let temp_dir = TempDir::new().unwrap();
+    let target = temp_dir.path().join("to-create");
+    write_directory_module(&temp_dir, &target);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("plan")
+        .assert()
+        .stdout(predicate::str::contains("  dirs - Create a directory\n"));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("XDG_STATE_HOME", temp_dir.path().join("state"))
+        .arg("apply")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("● dirs - Create a directory\n"));
+}
+
+#[test]
+fn test_plan_exits_zero_when_satisfied() {
+    let temp_dir = TempDir::new().unwrap();
+    let target = temp_dir.path().join("already-there");
+    fs::create_dir(&target).unwrap();
+    write_directory_module(&temp_dir, &target);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("plan")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("no-op"));
+}
+
+#[test]
+fn test_plan_pending_exit_code_override() {
+    let temp_dir = TempDir::new().unwrap();
+    let target = temp_dir.path().join("to-create");
+    write_directory_module(&temp_dir, &target);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["plan", "--pending-exit-code", "0"])
+        .assert()
+        .success();
+}
@@ -0,0 +1,120 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, target: &Path) {
+    fs::write(
+        temp_dir.path().join("dotfiles.ts"),
+        format!(
+            r#"export default defineModule("dotfiles").actions([
+    copyFile({{ source: "./zshrc", target: "{}" }}),
+]);"#,
+            target.display()
+        ),
+    )
+    .unwrap();
+    fs::write(temp_dir.path().join("zshrc"), "managed\n").unwrap();
+}
+
+/// Save the plan of the modules in `modules` to `plan`
+fn save_plan(modules: &TempDir, home: &TempDir, plan: &Path) {
+    let output = Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(modules)
+        .env("DHD_HOME", home.path())
+        .args(["plan", "--output", "json"])
+        .assert()
+        .code(2)
+        .get_output()
+        .stdout
+        .clone();
+    fs::write(plan, output).unwrap();
+}
+
+#[test]
+fn test_plan_json_lists_file_changes_by_hash() {
+    let modules = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let target = home.path().join(".zshrc");
+    write_module(&modules, &target);
+    let plan = home.path().join("plan.json");
+    save_plan(&modules, &home, &plan);
+
+    let plan: serde_json::Value = serde_json::from_slice(&fs::read(&plan).unwrap()).unwrap();
+    assert_eq!(plan["format"], 1);
+    let atom = &plan["modules"][0]["atoms"][0];
+    assert_eq!(plan["modules"][0]["name"], "dotfiles");
+    assert_eq!(atom["status"], "pending");
+    assert_eq!(atom["file"]["path"], target.display().to_string());
+    assert!(atom["file"]["before"].is_null());
+    assert!(atom["file"]["diff"].as_str().unwrap().contains("+managed"));
+    assert!(!target.exists());
+}
+
+#[test]
+fn test_apply_plan_file() {
+    let modules = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let target = home.path().join(".zshrc");
+    write_module(&modules, &target);
+    let plan = home.path().join("plan.json");
+    save_plan(&modules, &home, &plan);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&modules)
+        .env("DHD_HOME", home.path())
+        .args(["apply", "--yes", "--plan-file"])
+        .arg(&plan)
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Applying the plan"));
+    assert_eq!(fs::read_to_string(&target).unwrap(), "managed\n");
+}
+
+#[test]
+fn test_apply_plan_file_refuses_drift_unless_allowed() {
+    let modules = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let target = home.path().join(".zshrc");
+    write_module(&modules, &target);
+    let plan = home.path().join("plan.json");
+    save_plan(&modules, &home, &plan);
+    fs::write(&target, "edited\n").unwrap();
+
+    let dhd = || {
+        let mut cmd = Command::cargo_bin("dhd").unwrap();
+        cmd.current_dir(&modules)
+            .env("DHD_HOME", home.path())
+            .args(["apply", "--yes", "--plan-file"])
+            .arg(&plan);
+        cmd
+    };
+    dhd()
+        .assert()
+        .code(7)
+        .stderr(predicate::str::contains("no longer matches"))
+        .stderr(predicate::str::contains(".zshrc changed since the plan"));
+    assert_eq!(fs::read_to_string(&target).unwrap(), "edited\n");
+
+    dhd()
+        .arg("--allow-drift")
+        .assert()
+        .success()
+        .stderr(predicate::str::contains("applying anyway"));
+    assert_eq!(fs::read_to_string(&target).unwrap(), "managed\n");
+}
+
+#[test]
+fn test_plan_file_conflicts_with_module_selection() {
+    let temp_dir = TempDir::new().unwrap();
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--plan-file", "plan.json", "--modules", "zsh"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("cannot be used with"));
+}
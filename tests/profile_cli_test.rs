@@ -0,0 +1,74 @@
+use assert_cmd::Command;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_profile_writes_a_chrome_trace() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("slow.ts"),
+        r#"
+export default defineModule("slow")
+  .actions([
+    command({ run: "sleep 0.2" })
+  ]);
+"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--profile", "trace.json"])
+        .assert()
+        .success();
+
+    let trace: serde_json::Value =
+        serde_json::from_str(&fs::read_to_string(temp_dir.path().join("trace.json")).unwrap())
+            .unwrap();
+    let events = trace["traceEvents"].as_array().unwrap();
+    let span = |category: &str, name: &str| {
+        events
+            .iter()
+            .find(|event| event["cat"] == category && event["name"] == name)
+            .unwrap_or_else(|| panic!("no {} span {}", category, name))
+    };
+
+    span("load", "slow");
+    span("plan", "slow");
+    span("module", "slow");
+    span("check", "Run command: sleep 0.2");
+    let execute = span("execute", "Run command: sleep 0.2");
+    assert_eq!(execute["ph"], "X");
+    assert!(execute["dur"].as_u64().unwrap() >= 200_000);
+    assert!(events.iter().any(|event| event["ph"] == "M"));
+}
+
+#[test]
+fn test_dry_run_profile_has_no_executions() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("fast.ts"),
+        r#"export default defineModule("fast").actions([command({ run: "touch ran" })]);"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--dry-run", "--profile", "trace.json"])
+        .assert()
+        .success();
+
+    let trace = fs::read_to_string(temp_dir.path().join("trace.json")).unwrap();
+    let trace: serde_json::Value = serde_json::from_str(&trace).unwrap();
+    let categories: Vec<&str> = trace["traceEvents"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .filter_map(|event| event["cat"].as_str())
+        .collect();
+    assert!(categories.contains(&"check"));
+    assert!(!categories.contains(&"execute"));
+    assert!(!temp_dir.path().join("ran").exists());
+}
@@ -0,0 +1,90 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, state_dir: &Path) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir).env("XDG_STATE_HOME", state_dir);
+    cmd
+}
+
+fn write_module(temp_dir: &TempDir, home: &Path, name: &str) {
+    let module = format!(
+        r#"
+export default defineModule("{name}")
+  .actions([
+    copyFile({{ source: "./{name}.conf", target: "{home}/{name}.conf" }}),
+    symlink({{ source: "./{name}rc", target: "{home}/.{name}rc" }})
+  ]);
+"#,
+        home = home.display()
+    );
+    fs::write(temp_dir.path().join(format!("{}.ts", name)), module).unwrap();
+    fs::write(temp_dir.path().join(format!("{}.conf", name)), "managed\n").unwrap();
+    fs::write(temp_dir.path().join(format!("{}rc", name)), "# rc\n").unwrap();
+}
+
+#[test]
+#[cfg(unix)]
+fn test_prune_undoes_what_no_module_declares() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_module(&temp_dir, home.path(), "zsh");
+    write_module(&temp_dir, home.path(), "git");
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--yes"])
+        .assert()
+        .success();
+    fs::remove_file(temp_dir.path().join("git.ts")).unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--prune", "--dry-run"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "Would prune 2 changes no module declares any more",
+        ))
+        .stdout(predicate::str::contains(".gitrc"))
+        .stdout(predicate::str::contains(".zshrc").not());
+    assert!(home.path().join(".gitrc").is_symlink());
+
+    // Not interactive, so nothing is pruned without --yes
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--prune"])
+        .assert()
+        .code(1)
+        .stderr(predicate::str::contains("Aborted before pruning"));
+    assert!(home.path().join(".gitrc").is_symlink());
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--prune", "--yes"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Pruning 2 changes"));
+    assert!(!home.path().join(".gitrc").exists());
+    assert!(!home.path().join("git.conf").exists());
+    assert!(home.path().join(".zshrc").is_symlink());
+    assert!(home.path().join("zsh.conf").exists());
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--prune", "--yes"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Nothing to prune"));
+}
+
+#[test]
+fn test_prune_packages_requires_prune() {
+    let temp_dir = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--prune-packages"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("--prune"));
+}
@@ -0,0 +1,57 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_quiet_apply_only_prints_changes_and_the_summary() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let zshrc = home.path().join(".zshrc");
+    fs::write(
+        temp_dir.path().join("dotfiles.ts"),
+        format!(
+            r#"export default defineModule("dotfiles").actions([
+    copyFile({{ source: "./zshrc", target: "{}" }}),
+]);"#,
+            zshrc.display()
+        ),
+    )
+    .unwrap();
+    fs::write(temp_dir.path().join("zshrc"), "managed\n").unwrap();
+
+    let dhd = || {
+        let mut cmd = Command::cargo_bin("dhd").unwrap();
+        cmd.current_dir(&temp_dir).env("DHD_HOME", home.path());
+        cmd
+    };
+    dhd()
+        .args(["apply", "--yes", "--quiet"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("✅"))
+        .stdout(predicate::str::contains("Execution Summary"))
+        .stdout(predicate::str::contains("Selected modules").not())
+        .stdout(predicate::str::contains("Starting execution").not());
+    assert_eq!(fs::read_to_string(&zshrc).unwrap(), "managed\n");
+
+    dhd()
+        .args(["apply", "--yes", "--quiet"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Execution Summary"))
+        .stdout(predicate::str::contains("up to date").not())
+        .stdout(predicate::str::contains("dotfiles").not());
+}
+
+#[test]
+fn test_quiet_conflicts_with_json_output() {
+    let temp_dir = TempDir::new().unwrap();
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--yes", "--quiet", "--output", "json"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("cannot be used with"));
+}
@@ -0,0 +1,65 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn modules() -> TempDir {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("kernel.ts"),
+        r#"export default defineModule("kernel").actions([command({ run: "true", requiresReboot: true })]);"#,
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("zsh.ts"),
+        r#"export default defineModule("zsh").actions([command({ run: "true" })]);"#,
+    )
+    .unwrap();
+    temp_dir
+}
+
+#[test]
+fn test_apply_needing_a_reboot_exits_6() {
+    let temp_dir = modules();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", temp_dir.path())
+        .args(["apply", "--yes", "--changed-exit-code", "2"])
+        .assert()
+        .code(6)
+        .stdout(predicate::str::contains("Reboot required by: kernel"));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", temp_dir.path())
+        .args([
+            "apply",
+            "--yes",
+            "--modules",
+            "zsh",
+            "--changed-exit-code",
+            "2",
+        ])
+        .assert()
+        .code(2)
+        .stdout(predicate::str::contains("Reboot").not());
+}
+
+#[test]
+fn test_dry_run_only_reports_the_reboot() {
+    let temp_dir = modules();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", temp_dir.path())
+        .args(["apply", "--dry-run"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "Reboot would be required by: kernel",
+        ));
+}
@@ -0,0 +1,95 @@
+use assert_cmd::Command;
+use serde_json::Value;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, name: &str, run: &str) {
+    let module = format!(
+        r#"
+export default defineModule("{name}")
+  .actions([
+    command({{ run: "{run}" }})
+  ]);
+"#
+    );
+    fs::write(temp_dir.path().join(format!("{}.ts", name)), module).unwrap();
+}
+
+fn dhd(temp_dir: &TempDir, state: &TempDir) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir)
+        .env("XDG_STATE_HOME", state.path());
+    cmd
+}
+
+#[test]
+fn test_report_file_is_written_on_failure() {
+    let temp_dir = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_module(&temp_dir, "good", "true");
+    write_module(&temp_dir, "bad", "exit 3");
+    let path = temp_dir.path().join("reports/dhd.json");
+
+    dhd(&temp_dir, &state)
+        .args(["apply", "--keep-going", "--report-file"])
+        .arg(&path)
+        .assert()
+        .failure();
+
+    let report: Value = serde_json::from_str(&fs::read_to_string(&path).unwrap()).unwrap();
+    assert_eq!(report["success"], false);
+    assert_eq!(report["version"], env!("CARGO_PKG_VERSION"));
+    assert!(report["timestamp"].as_u64().unwrap() > 0);
+    assert_eq!(report["summary"]["failed"], 1);
+    let status = |name: &str| {
+        report["modules"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .find(|module| module["module"] == name)
+            .map(|module| module["status"].clone())
+            .unwrap()
+    };
+    assert_eq!(status("good"), "applied");
+    assert_eq!(status("bad"), "failed");
+}
+
+#[test]
+fn test_report_file_records_errors_before_applying() {
+    let temp_dir = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("app.ts"),
+        r#"export default defineModule("app").dependsOn(["missing"]).actions([]);"#,
+    )
+    .unwrap();
+    let path = temp_dir.path().join("dhd.json");
+
+    dhd(&temp_dir, &state)
+        .args(["apply", "--report-file"])
+        .arg(&path)
+        .assert()
+        .failure();
+
+    let report: Value = serde_json::from_str(&fs::read_to_string(&path).unwrap()).unwrap();
+    assert_eq!(report["success"], false);
+    assert!(report["error"].as_str().unwrap().contains("missing"));
+}
+
+#[test]
+fn test_report_file_in_prometheus_format() {
+    let temp_dir = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_module(&temp_dir, "good", "true");
+    let path = temp_dir.path().join("dhd.prom");
+
+    dhd(&temp_dir, &state)
+        .args(["apply", "--report-format", "prometheus", "--report-file"])
+        .arg(&path)
+        .assert()
+        .success();
+
+    let metrics = fs::read_to_string(&path).unwrap();
+    assert!(metrics.contains("dhd_apply_success 1\n"), "{}", metrics);
+    assert!(metrics.contains("dhd_module_status{module=\"good\",status=\"applied\"} 1\n"));
+}
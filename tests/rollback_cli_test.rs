@@ -0,0 +1,133 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, state_dir: &Path) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir).env("XDG_STATE_HOME", state_dir);
+    cmd
+}
+
+fn write_module(temp_dir: &TempDir, home: &Path) {
+    let module = format!(
+        r#"
+export default defineModule("dotfiles")
+  .actions([
+    copyFile({{ source: "./config.txt", target: "{home}/config.txt" }}),
+    symlink({{ source: "./zshrc", target: "{home}/.zshrc", force: true }})
+  ]);
+"#,
+        home = home.display()
+    );
+    fs::write(temp_dir.path().join("dotfiles.ts"), module).unwrap();
+    fs::write(temp_dir.path().join("config.txt"), "managed\n").unwrap();
+    fs::write(temp_dir.path().join("zshrc"), "export EDITOR=vim\n").unwrap();
+}
+
+#[test]
+#[cfg(unix)]
+fn test_rollback_restores_replaced_files() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_module(&temp_dir, home.path());
+    fs::write(home.path().join("config.txt"), "mine\n").unwrap();
+    fs::write(home.path().join(".zshrc"), "my zshrc\n").unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--yes"])
+        .assert()
+        .success();
+    assert!(home.path().join(".zshrc").is_symlink());
+    assert_eq!(
+        fs::read_to_string(home.path().join("config.txt")).unwrap(),
+        "managed\n"
+    );
+    assert!(state.path().join("dhd/state.json").exists());
+
+    dhd(&temp_dir, state.path())
+        .arg("rollback")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Rolling back apply"))
+        .stdout(predicate::str::contains("Restored"));
+
+    assert!(!home.path().join(".zshrc").is_symlink());
+    assert_eq!(
+        fs::read_to_string(home.path().join(".zshrc")).unwrap(),
+        "my zshrc\n"
+    );
+    assert_eq!(
+        fs::read_to_string(home.path().join("config.txt")).unwrap(),
+        "mine\n"
+    );
+
+    // The apply has been undone, so there is nothing left to roll back
+    dhd(&temp_dir, state.path())
+        .arg("rollback")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Nothing to roll back"));
+}
+
+#[test]
+#[cfg(unix)]
+fn test_rollback_removes_created_files() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_module(&temp_dir, home.path());
+
+    dhd(&temp_dir, state.path()).arg("apply").assert().success();
+    dhd(&temp_dir, state.path())
+        .arg("rollback")
+        .assert()
+        .success();
+
+    assert!(!home.path().join("config.txt").exists());
+    assert!(fs::symlink_metadata(home.path().join(".zshrc")).is_err());
+}
+
+#[test]
+fn test_dry_run_records_nothing() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_module(&temp_dir, home.path());
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--dry-run"])
+        .assert()
+        .success();
+
+    assert!(!state.path().join("dhd/state.json").exists());
+}
+
+#[test]
+#[cfg(unix)]
+fn test_dhd_home_holds_the_state_instead_of_xdg_state_home() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let dhd_home = TempDir::new().unwrap();
+    write_module(&temp_dir, home.path());
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--yes", "--dhd-home"])
+        .arg(dhd_home.path())
+        .assert()
+        .success();
+    assert!(dhd_home.path().join("state/state.json").exists());
+    assert!(!state.path().join("dhd").exists());
+
+    // DHD_HOME does the same, and rollback finds the apply there
+    dhd(&temp_dir, state.path())
+        .env("DHD_HOME", dhd_home.path())
+        .arg("rollback")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Rolling back apply"));
+    assert!(!home.path().join(".zshrc").exists());
+}
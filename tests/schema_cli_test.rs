@@ -0,0 +1,37 @@
+use assert_cmd::Command;
+use serde_json::Value;
+
+#[test]
+fn test_schema_describes_declarative_modules() {
+    let output = Command::cargo_bin("dhd")
+        .unwrap()
+        .arg("schema")
+        .assert()
+        .success()
+        .get_output()
+        .stdout
+        .clone();
+    let schema: Value = serde_json::from_slice(&output).unwrap();
+
+    assert_eq!(
+        schema["$schema"],
+        "https://json-schema.org/draft/2020-12/schema"
+    );
+    assert_eq!(schema["x-dhd-module-api"], dhd::version::MODULE_API);
+    assert_eq!(
+        schema["properties"]["actions"]["items"]["$ref"],
+        "#/$defs/action"
+    );
+    let actions = schema["$defs"]["action"]["oneOf"].as_array().unwrap();
+    let install = actions
+        .iter()
+        .find(|action| action["title"] == "PackageInstall")
+        .unwrap();
+    assert!(
+        install["properties"]["type"]["enum"]
+            .as_array()
+            .unwrap()
+            .contains(&Value::from("packageInstall"))
+    );
+    assert!(install["properties"]["names"].is_object());
+}
@@ -0,0 +1,73 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_modules(temp_dir: &TempDir) {
+    fs::write(
+        temp_dir.path().join("dhd.config.ts"),
+        r#"export default defineConfig({ secretEnvPrefix: "CI_" });"#,
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("deploy.tmpl"),
+        "key = {{ secret(\"deploy_key\") }}\n",
+    )
+    .unwrap();
+    let module = format!(
+        r#"
+export default defineModule("deploy")
+  .actions([
+    template({{ source: "./deploy.tmpl", target: "{}" }})
+  ]);
+"#,
+        temp_dir.path().join("deploy.conf").display()
+    );
+    fs::write(temp_dir.path().join("deploy.ts"), module).unwrap();
+}
+
+#[test]
+fn test_secrets_are_read_from_prefixed_variables() {
+    let temp_dir = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_modules(&temp_dir);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("XDG_STATE_HOME", state.path())
+        .env("CI_DEPLOY_KEY", "ci-provided-key")
+        .env("DEPLOY_KEY", "local-key")
+        .args(["apply", "--yes", "--diff", "-vv"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("ci-provided-key").not())
+        .stderr(predicate::str::contains("ci-provided-key").not());
+
+    assert_eq!(
+        fs::read_to_string(temp_dir.path().join("deploy.conf")).unwrap(),
+        "key = ci-provided-key\n"
+    );
+}
+
+#[test]
+fn test_missing_secrets_name_the_variables() {
+    let temp_dir = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_modules(&temp_dir);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("XDG_STATE_HOME", state.path())
+        .env_remove("CI_DEPLOY_KEY")
+        .env_remove("DEPLOY_KEY")
+        .args(["apply", "--yes"])
+        .assert()
+        .failure()
+        .stdout(predicate::str::contains(
+            "Secret not found: deploy_key (set $CI_DEPLOY_KEY or $DEPLOY_KEY, or add",
+        ));
+
+    assert!(!temp_dir.path().join("deploy.conf").exists());
+}
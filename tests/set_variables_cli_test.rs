@@ -0,0 +1,114 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, target: &Path) {
+    fs::write(
+        temp_dir.path().join("dhd.config.ts"),
+        r#"export default defineConfig({ variables: { email: "jane@example.com", editor: "vim" } });"#,
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("git.ts"),
+        format!(
+            r#"export default defineModule("git")
+    .variableSchema({{ signing: {{ type: "bool", default: false }} }})
+    .actions([template({{ source: "gitconfig.tmpl", target: "{}" }})]);"#,
+            target.display()
+        ),
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("gitconfig.tmpl"),
+        "{{ email }} {{ editor }}{{#if signing}} signed{{/if}} {{ git.name }}\n",
+    )
+    .unwrap();
+}
+
+#[test]
+fn test_set_overrides_config_variables() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let target = home.path().join(".gitconfig");
+    write_module(&temp_dir, &target);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", home.path())
+        .args(["apply", "--yes", "--set", "email=user@example.com"])
+        .args([
+            "--set",
+            "signing=true",
+            "--set-json",
+            r#"git={"name": "User"}"#,
+        ])
+        .assert()
+        .success()
+        .stderr(predicate::str::contains("--set email").not())
+        .stderr(predicate::str::contains("--set signing").not());
+
+    assert_eq!(
+        fs::read_to_string(&target).unwrap(),
+        "user@example.com vim signed User\n"
+    );
+}
+
+#[test]
+fn test_set_warns_about_undeclared_variables() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let target = home.path().join(".gitconfig");
+    write_module(&temp_dir, &target);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", home.path())
+        .args([
+            "apply",
+            "--dry-run",
+            "--set",
+            "git.name=User",
+            "--set",
+            "email=user@example.com",
+            "--set",
+            "emial=x",
+        ])
+        .assert()
+        .success()
+        .stderr(predicate::str::contains(
+            "--set emial: no module declares this variable in its variableSchema or sets it",
+        ))
+        .stderr(predicate::str::contains("--set email").not());
+}
+
+#[test]
+fn test_set_is_checked_against_the_variable_schema() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    write_module(&temp_dir, &home.path().join(".gitconfig"));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", home.path())
+        .args(["plan", "--set", "signing=yes"])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains(
+            "variable 'signing' must be true or false, got 'yes'",
+        ));
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["plan", "--set-json", "editor=[1]"])
+        .assert()
+        .code(3)
+        .stderr(predicate::str::contains(
+            "variables are strings, numbers, booleans or objects of them",
+        ));
+}
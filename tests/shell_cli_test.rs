@@ -0,0 +1,41 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_config_shell_and_env_reach_commands_and_hooks() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("dhd.config.ts"),
+        r#"export default defineConfig({ shell: "bash" });"#,
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("greet.ts"),
+        r#"export default defineModule("greet")
+  .preApply({ run: "echo \"pre $GREETING\" > pre", shell: "sh", env: { GREETING: "hello" } })
+  .actions([
+    command({
+      run: "[[ -n $BASH_VERSION ]] && echo \"$GREETING $USER\" > out",
+      env: { GREETING: "hi", USER: "example" }
+    })
+  ]);"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["--log-level", "trace", "apply"])
+        .assert()
+        .success()
+        .stderr(predicate::str::contains("with bash"))
+        .stderr(predicate::str::contains(
+            "Running hook 'echo \"pre $GREETING\" > pre' with sh",
+        ));
+
+    let read = |name| fs::read_to_string(temp_dir.path().join(name)).unwrap();
+    assert_eq!(read("out"), "hi example\n");
+    assert_eq!(read("pre"), "pre hello\n");
+}
@@ -0,0 +1,105 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn git(dir: &Path, args: &[&str]) {
+    let output = std::process::Command::new("git")
+        .arg("-C")
+        .arg(dir)
+        .args(["-c", "user.name=DHD", "-c", "user.email=dhd@example.com"])
+        .args(args)
+        .output()
+        .unwrap();
+    assert!(output.status.success(), "git {:?} failed", args);
+}
+
+fn write_module(dir: &Path, name: &str, dependencies: &str) {
+    fs::write(
+        dir.join(format!("{}.ts", name)),
+        format!(
+            r#"export default defineModule("{name}").dependsOn([{dependencies}]).actions([command({{ run: "touch {name}-ran" }})]);"#
+        ),
+    )
+    .unwrap();
+}
+
+/// A committed repository of modules: `app` depends on `base`, `other` on nothing
+fn repo() -> TempDir {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(temp_dir.path(), "base", "");
+    write_module(temp_dir.path(), "app", r#""base""#);
+    write_module(temp_dir.path(), "other", "");
+    fs::write(temp_dir.path().join(".gitignore"), "*-ran\n").unwrap();
+    git(temp_dir.path(), &["init", "-q"]);
+    git(temp_dir.path(), &["add", "-A"]);
+    git(temp_dir.path(), &["commit", "-q", "-m", "Add modules"]);
+    temp_dir
+}
+
+#[test]
+fn test_since_commit_applies_changed_modules_and_their_dependents() {
+    let temp_dir = repo();
+    let home = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("base.ts"),
+        r#"export default defineModule("base").actions([command({ run: "touch base-ran" })]); // changed"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", home.path())
+        .args(["apply", "--yes", "--since-commit", "HEAD"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Changed since HEAD: base, app"));
+
+    assert!(temp_dir.path().join("base-ran").exists());
+    assert!(temp_dir.path().join("app-ran").exists());
+    assert!(!temp_dir.path().join("other-ran").exists());
+}
+
+#[test]
+fn test_since_commit_without_changes_applies_nothing() {
+    let temp_dir = repo();
+    let home = TempDir::new().unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", home.path())
+        .args(["apply", "--yes", "--since-commit", "HEAD"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "No selected modules changed since HEAD",
+        ));
+
+    assert!(!temp_dir.path().join("base-ran").exists());
+    assert!(!temp_dir.path().join("other-ran").exists());
+}
+
+#[test]
+fn test_since_commit_falls_back_to_every_module_outside_git() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    write_module(temp_dir.path(), "base", "");
+    write_module(temp_dir.path(), "other", "");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("DHD_HOME", home.path())
+        .args(["apply", "--yes", "--since-commit", "HEAD"])
+        .assert()
+        .success()
+        .stderr(predicate::str::contains(
+            "Can't tell what changed since HEAD, applying every selected module",
+        ));
+
+    assert!(temp_dir.path().join("base-ran").exists());
+    assert!(temp_dir.path().join("other-ran").exists());
+}
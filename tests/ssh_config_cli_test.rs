@@ -0,0 +1,61 @@
+use assert_cmd::Command;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, config: &std::path::Path, hosts: &str) {
+    fs::write(
+        temp_dir.path().join("ssh.ts"),
+        format!(
+            r#"export default defineModule("ssh").actions([sshConfig({{ path: "{}", hosts: [{}] }})]);"#,
+            config.display(),
+            hosts
+        ),
+    )
+    .unwrap();
+}
+
+#[test]
+fn test_ssh_config_keeps_hand_written_hosts() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let config = home.path().join(".ssh/config");
+    fs::create_dir_all(config.parent().unwrap()).unwrap();
+    fs::write(
+        &config,
+        "Host nas\n    User admin\n\nHost *\n    AddKeysToAgent yes\n",
+    )
+    .unwrap();
+
+    let dhd = || {
+        let mut cmd = Command::cargo_bin("dhd").unwrap();
+        cmd.current_dir(&temp_dir).env("DHD_HOME", home.path());
+        cmd
+    };
+
+    write_module(
+        &temp_dir,
+        &config,
+        r#"{ host: "work", hostname: "work.example.com", user: "me" }"#,
+    );
+    dhd().args(["apply", "--yes"]).assert().success();
+    assert_eq!(
+        fs::read_to_string(&config).unwrap(),
+        "Host nas\n    User admin\n\n\
+         # BEGIN dhd:Host work\nHost work\n    HostName work.example.com\n    User me\n# END dhd:Host work\n\
+         Host *\n    AddKeysToAgent yes\n"
+    );
+
+    write_module(&temp_dir, &config, r#"{ host: "work", absent: true }"#);
+    dhd().args(["apply", "--yes"]).assert().success();
+    assert_eq!(
+        fs::read_to_string(&config).unwrap(),
+        "Host nas\n    User admin\n\nHost *\n    AddKeysToAgent yes\n"
+    );
+
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::PermissionsExt;
+        let mode = fs::metadata(&config).unwrap().permissions().mode();
+        assert_eq!(mode & 0o777, 0o600);
+    }
+}
@@ -0,0 +1,99 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn write_modules(temp_dir: &TempDir, directory: &Path, link: &Path) {
+    fs::write(
+        temp_dir.path().join("dirs.ts"),
+        format!(
+            r#"
+export default defineModule("dirs")
+  .actions([
+    directory({{ path: "{}" }})
+  ]);
+"#,
+            directory.display()
+        ),
+    )
+    .unwrap();
+    fs::write(
+        temp_dir.path().join("links.ts"),
+        format!(
+            r#"
+export default defineModule("links")
+  .actions([
+    symlink({{ source: "./zshrc", target: "{}" }})
+  ]);
+"#,
+            link.display()
+        ),
+    )
+    .unwrap();
+    fs::write(temp_dir.path().join("zshrc"), "export EDITOR=vim\n").unwrap();
+}
+
+#[test]
+#[cfg(unix)]
+fn test_status_reports_drift_per_module() {
+    let temp_dir = TempDir::new().unwrap();
+    let directory = temp_dir.path().join("present");
+    let link = temp_dir.path().join(".zshrc");
+    fs::create_dir(&directory).unwrap();
+    std::os::unix::fs::symlink("/somewhere/else", &link).unwrap();
+    write_modules(&temp_dir, &directory, &link);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("status")
+        .assert()
+        .code(2)
+        .stdout(predicate::str::contains("● dirs - in sync"))
+        .stdout(predicate::str::contains("● links - 1 drifted"))
+        .stdout(predicate::str::contains("1 in sync, 1 drifted"));
+
+    assert_eq!(
+        fs::read_link(&link).unwrap(),
+        Path::new("/somewhere/else"),
+        "status must not modify the system"
+    );
+}
+
+#[test]
+#[cfg(unix)]
+fn test_status_exits_zero_when_in_sync() {
+    let temp_dir = TempDir::new().unwrap();
+    let directory = temp_dir.path().join("present");
+    let link = temp_dir.path().join(".zshrc");
+    fs::create_dir(&directory).unwrap();
+    std::os::unix::fs::symlink(temp_dir.path().join("zshrc"), &link).unwrap();
+    write_modules(&temp_dir, &directory, &link);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("status")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("2 in sync, 0 drifted"));
+}
+
+#[test]
+fn test_status_drift_exit_code_override() {
+    let temp_dir = TempDir::new().unwrap();
+    let directory = temp_dir.path().join("missing");
+    let link = temp_dir.path().join(".zshrc");
+    write_modules(&temp_dir, &directory, &link);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["status", "--module", "dirs", "--drift-exit-code", "0"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("✗ drifted"));
+
+    assert!(!directory.exists());
+}
@@ -0,0 +1,84 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, state_dir: &Path) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir).env("XDG_STATE_HOME", state_dir);
+    cmd
+}
+
+/// Modules `app`, `base` and `other`, each creating a directory of its name
+/// under `home`; `app` depends on `base`
+fn write_modules(temp_dir: &TempDir, home: &Path) {
+    for (name, dependencies) in [
+        ("app", r#".dependsOn(["base"])"#),
+        ("base", ""),
+        ("other", ""),
+    ] {
+        fs::write(
+            temp_dir.path().join(format!("{}.ts", name)),
+            format!(
+                r#"export default defineModule("{}")
+  {}
+  .actions([ensureDir({{ path: "{}" }})]);"#,
+                name,
+                dependencies,
+                home.join(name).display()
+            ),
+        )
+        .unwrap();
+    }
+}
+
+#[test]
+fn test_modules_from_stdin_pull_in_dependencies() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_modules(&temp_dir, home.path());
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--modules", "-"])
+        .write_stdin("app\n\n")
+        .assert()
+        .success();
+    assert!(home.path().join("app").is_dir());
+    assert!(home.path().join("base").is_dir());
+    assert!(!home.path().join("other").exists());
+}
+
+#[test]
+fn test_modules_from_stdin_fail_on_unknown_names() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_modules(&temp_dir, home.path());
+
+    dhd(&temp_dir, state.path())
+        .args(["plan", "--modules", "-,other"])
+        .write_stdin("missing\n")
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains("Module 'missing' not found"));
+}
+
+#[test]
+fn test_empty_stdin_selects_no_modules() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_modules(&temp_dir, home.path());
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--modules", "-"])
+        .write_stdin("")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("No modules were named on stdin"));
+    for name in ["app", "base", "other"] {
+        assert!(!home.path().join(name).exists());
+    }
+}
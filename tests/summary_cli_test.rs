@@ -0,0 +1,66 @@
+use assert_cmd::Command;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, name: &str, run: &str) {
+    let module = format!(
+        r#"
+export default defineModule("{name}")
+  .actions([
+    command({{ run: "{run}" }})
+  ]);
+"#
+    );
+    fs::write(temp_dir.path().join(format!("{}.ts", name)), module).unwrap();
+}
+
+#[test]
+fn test_summary_lists_failed_actions() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "fine", "true");
+    write_module(&temp_dir, "broken", "echo no such thing >&2; exit 3");
+
+    let output = Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--keep-going", "-vv"])
+        .output()
+        .unwrap();
+    assert!(!output.status.success());
+
+    let stdout = String::from_utf8(output.stdout).unwrap();
+    let summary = stdout
+        .split("Execution Summary:")
+        .nth(1)
+        .expect("summary should be printed");
+    assert!(summary.contains("Modules: 2 (0 skipped)"), "{}", summary);
+    assert!(summary.contains("✅ Completed: 1"), "{}", summary);
+    assert!(summary.contains("❌ Failed: 1"), "{}", summary);
+
+    let failed = summary
+        .split("Failed actions:")
+        .nth(1)
+        .expect("failed actions should be listed");
+    assert!(failed.contains("- broken › "), "{}", failed);
+    assert!(failed.contains("no such thing"), "{}", failed);
+    assert!(!failed.contains("fine"), "{}", failed);
+    assert!(!failed.contains("Stopped after"), "{}", failed);
+}
+
+#[test]
+fn test_summary_of_a_clean_apply_lists_no_failures() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "fine", "true");
+
+    let output = Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("apply")
+        .output()
+        .unwrap();
+    assert!(output.status.success());
+
+    let stdout = String::from_utf8(output.stdout).unwrap();
+    assert!(stdout.contains("Modules: 1 (0 skipped)"), "{}", stdout);
+    assert!(!stdout.contains("Failed actions:"), "{}", stdout);
+}
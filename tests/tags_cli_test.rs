@@ -0,0 +1,110 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, name: &str, tags: &[&str]) {
+    let tags = tags
+        .iter()
+        .map(|t| format!("\"{}\"", t))
+        .collect::<Vec<_>>()
+        .join(", ");
+    let module = format!(
+        r#"
+export default defineModule("{name}")
+  .tags([{tags}])
+  .actions([
+    executeCommand({{ command: "true" }})
+  ]);
+"#
+    );
+    fs::write(temp_dir.path().join(format!("{}.ts", name)), module).unwrap();
+}
+
+fn setup() -> TempDir {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "niri", &["desktop"]);
+    write_module(&temp_dir, "slack", &["desktop", "work"]);
+    write_module(&temp_dir, "git", &["cli"]);
+    temp_dir
+}
+
+#[test]
+fn test_tags_command_lists_tags_with_modules() {
+    let temp_dir = setup();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("tags")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("desktop (2): niri, slack"))
+        .stdout(predicate::str::contains("work (1): slack"))
+        .stdout(predicate::str::contains("cli (1): git"));
+}
+
+#[test]
+fn test_exclude_tags_drops_modules() {
+    let temp_dir = setup();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--dry-run", "--tags", "desktop", "--exclude-tags", "work"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("niri"))
+        .stdout(predicate::str::contains("slack").not());
+}
+
+#[test]
+fn test_modules_and_tags_are_combined() {
+    let temp_dir = setup();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--dry-run", "--modules", "git", "--tags", "work"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("git"))
+        .stdout(predicate::str::contains("slack"))
+        .stdout(predicate::str::contains("niri").not());
+}
+
+#[test]
+fn test_filter_expression_selects_modules() {
+    let temp_dir = setup();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args([
+            "apply",
+            "--dry-run",
+            "--filter",
+            r#"tag == "desktop" and not "work" in tags or name == "git""#,
+        ])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("niri"))
+        .stdout(predicate::str::contains("git"))
+        .stdout(predicate::str::contains("slack").not());
+}
+
+#[test]
+fn test_invalid_filter_expression_fails_before_loading() {
+    let temp_dir = setup();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--dry-run", "--filter", r#"tag == "desktop" and"#])
+        .assert()
+        .failure()
+        .stderr(predicate::str::contains(
+            "Invalid filter at column 21: expected a field, string or list, found the end",
+        ))
+        .stdout(predicate::str::contains("niri").not());
+}
@@ -0,0 +1,70 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn is_root() -> bool {
+    std::process::Command::new("id")
+        .arg("-u")
+        .output()
+        .map(|output| String::from_utf8_lossy(&output.stdout).trim() == "0")
+        .unwrap_or(false)
+}
+
+fn write_module(temp_dir: &TempDir) {
+    fs::write(temp_dir.path().join("zshrc"), "export EDITOR=vim\n").unwrap();
+    fs::write(
+        temp_dir.path().join("shell.ts"),
+        r#"export default defineModule("shell").actions([symlink({ source: "./zshrc", target: "~/.zshrc" })]);"#,
+    )
+    .unwrap();
+}
+
+#[test]
+fn test_unknown_target_user_is_refused() {
+    if !is_root() {
+        // Without root dhd would ask sudo to run it again
+        return;
+    }
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["--target-user", "dhd-no-such-user", "plan"])
+        .assert()
+        .code(3)
+        .stderr(predicate::str::contains(
+            "--target-user dhd-no-such-user: there is no user named 'dhd-no-such-user'",
+        ));
+}
+
+#[test]
+fn test_paths_expand_to_the_target_users_home() {
+    if !is_root() {
+        return;
+    }
+    let passwd = std::process::Command::new("getent")
+        .args(["passwd", "nobody"])
+        .output()
+        .unwrap();
+    let passwd = String::from_utf8_lossy(&passwd.stdout);
+    let Some(home) = passwd.trim().split(':').nth(5) else {
+        return;
+    };
+    let temp_dir = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_module(&temp_dir);
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .env("XDG_STATE_HOME", state.path())
+        .args(["--target-user", "nobody", "plan"])
+        .assert()
+        .stdout(predicate::str::contains(format!(
+            "{}/.zshrc",
+            home.trim_end_matches('/')
+        )));
+}
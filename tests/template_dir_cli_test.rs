@@ -0,0 +1,84 @@
+use assert_cmd::Command;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, state_dir: &Path) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir).env("XDG_STATE_HOME", state_dir);
+    cmd
+}
+
+#[test]
+fn test_template_dir_renders_and_copies_a_tree() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    let target = home.path().join("app");
+
+    let source = temp_dir.path().join("config/app");
+    fs::create_dir_all(source.join("scripts")).unwrap();
+    fs::write(source.join("settings.conf.tmpl"), "font = {{ font }}\n").unwrap();
+    fs::write(source.join("scripts/clock.sh"), "#!/bin/sh\ndate\n").unwrap();
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::PermissionsExt;
+        fs::set_permissions(
+            source.join("scripts/clock.sh"),
+            fs::Permissions::from_mode(0o755),
+        )
+        .unwrap();
+    }
+    fs::write(
+        temp_dir.path().join("app.ts"),
+        format!(
+            r#"
+export default defineModule("app")
+  .variables({{ font: "JetBrains Mono" }})
+  .actions([
+    templateDir({{ source: "config/app", target: "{}" }})
+  ]);
+"#,
+            target.display()
+        ),
+    )
+    .unwrap();
+    fs::create_dir_all(&target).unwrap();
+    fs::write(target.join("settings.conf"), "font = Hack\n").unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--yes"])
+        .assert()
+        .success();
+
+    assert_eq!(
+        fs::read_to_string(target.join("settings.conf")).unwrap(),
+        "font = JetBrains Mono\n"
+    );
+    assert_eq!(
+        fs::read_to_string(target.join("scripts/clock.sh")).unwrap(),
+        "#!/bin/sh\ndate\n"
+    );
+    assert!(!target.join("settings.conf.tmpl").exists());
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::PermissionsExt;
+        let mode = fs::metadata(target.join("scripts/clock.sh"))
+            .unwrap()
+            .permissions()
+            .mode();
+        assert_eq!(mode & 0o7777, 0o755);
+    }
+
+    // Only the replaced file is backed up
+    let backups: Vec<_> = fs::read_dir(&target)
+        .unwrap()
+        .map(|entry| entry.unwrap().file_name().to_string_lossy().into_owned())
+        .filter(|name| name.contains(".dhd-bak-"))
+        .collect();
+    assert_eq!(backups.len(), 1, "{:?}", backups);
+    assert!(backups[0].starts_with("settings.conf.dhd-bak-"));
+
+    // Every file is up to date now
+    dhd(&temp_dir, state.path()).arg("plan").assert().code(0);
+}
@@ -0,0 +1,73 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, name: &str, run: &str) {
+    let module = format!(
+        r#"
+export default defineModule("{name}")
+  .actions([
+    command({{ run: "{run}" }})
+  ]);
+"#
+    );
+    fs::write(temp_dir.path().join(format!("{}.ts", name)), module).unwrap();
+}
+
+#[test]
+fn test_timings_lists_slowest_module_first() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "slow", "sleep 0.3");
+    write_module(&temp_dir, "fast", "true");
+
+    let output = Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--timings", "-j", "1"])
+        .output()
+        .unwrap();
+    assert!(output.status.success());
+
+    let stdout = String::from_utf8(output.stdout).unwrap();
+    let timings = stdout
+        .split("Timings (slowest first):")
+        .nth(1)
+        .expect("timings should be printed");
+    let slow = timings.find("s  slow").unwrap();
+    let fast = timings.find("s  fast").unwrap();
+    assert!(slow < fast);
+    assert!(timings.contains("2 applied, 0 up to date, 0 skipped in"));
+}
+
+#[test]
+fn test_timings_are_off_by_default() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "fast", "true");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("apply")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Timings").not());
+}
+
+#[test]
+fn test_json_output_includes_durations() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "slow", "sleep 0.2");
+
+    let output = Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--output", "json"])
+        .output()
+        .unwrap();
+    let report: serde_json::Value = serde_json::from_slice(&output.stdout).unwrap();
+
+    let module = &report["modules"][0];
+    assert!(module["durationMs"].as_u64().unwrap() >= 200);
+    assert!(module["actions"][0]["durationMs"].as_u64().unwrap() >= 200);
+}
@@ -0,0 +1,82 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use std::path::Path;
+use tempfile::TempDir;
+
+fn dhd(temp_dir: &TempDir, state_dir: &Path) -> Command {
+    let mut cmd = Command::cargo_bin("dhd").unwrap();
+    cmd.current_dir(temp_dir).env("XDG_STATE_HOME", state_dir);
+    cmd
+}
+
+fn write_module(temp_dir: &TempDir, home: &Path, name: &str) {
+    let module = format!(
+        r#"
+export default defineModule("{name}")
+  .actions([
+    copyFile({{ source: "./{name}.conf", target: "{home}/{name}.conf" }}),
+    symlink({{ source: "./{name}rc", target: "{home}/.{name}rc" }})
+  ]);
+"#,
+        home = home.display()
+    );
+    fs::write(temp_dir.path().join(format!("{}.ts", name)), module).unwrap();
+    fs::write(temp_dir.path().join(format!("{}.conf", name)), "managed\n").unwrap();
+    fs::write(temp_dir.path().join(format!("{}rc", name)), "# rc\n").unwrap();
+}
+
+#[test]
+#[cfg(unix)]
+fn test_uninstall_undoes_only_that_module() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let state = TempDir::new().unwrap();
+    write_module(&temp_dir, home.path(), "zsh");
+    write_module(&temp_dir, home.path(), "git");
+    fs::write(home.path().join("zsh.conf"), "mine\n").unwrap();
+
+    dhd(&temp_dir, state.path())
+        .args(["apply", "--yes"])
+        .assert()
+        .success();
+    assert!(home.path().join(".zshrc").is_symlink());
+    assert!(home.path().join(".gitrc").is_symlink());
+
+    dhd(&temp_dir, state.path())
+        .args(["uninstall", "--modules", "zsh", "--dry-run"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Would uninstall zsh (2 changes)"))
+        .stdout(predicate::str::contains("- undo"));
+    assert!(home.path().join(".zshrc").is_symlink());
+
+    dhd(&temp_dir, state.path())
+        .args(["uninstall", "--modules", "zsh"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Uninstalling zsh"));
+
+    assert!(!home.path().join(".zshrc").exists());
+    assert_eq!(
+        fs::read_to_string(home.path().join("zsh.conf")).unwrap(),
+        "mine\n"
+    );
+    assert!(home.path().join(".gitrc").is_symlink());
+    assert!(home.path().join("git.conf").exists());
+
+    // What the module changed has been forgotten
+    dhd(&temp_dir, state.path())
+        .args(["uninstall", "--modules", "zsh"])
+        .assert()
+        .success()
+        .stdout(predicate::str::contains("Nothing recorded for zsh"));
+
+    // The other module's changes can still be rolled back
+    dhd(&temp_dir, state.path())
+        .arg("rollback")
+        .assert()
+        .success();
+    assert!(!home.path().join(".gitrc").exists());
+    assert!(!home.path().join("git.conf").exists());
+}
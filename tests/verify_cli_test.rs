@@ -0,0 +1,68 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+fn write_module(temp_dir: &TempDir, run: &str, verify: &str) {
+    let module = format!(
+        r#"
+export default defineModule("tools")
+  .actions([
+    command({{ run: "{run}", verify: "{verify}" }}),
+    command({{ run: "touch after" }})
+  ]);
+"#
+    );
+    fs::write(temp_dir.path().join("tools.ts"), module).unwrap();
+}
+
+#[test]
+fn test_verify_runs_after_the_action() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "touch built", "test -f built");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("apply")
+        .assert()
+        .success();
+
+    assert!(temp_dir.path().join("after").exists());
+}
+
+#[test]
+fn test_failed_verification_fails_the_apply() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "touch built", "test -f missing");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("apply")
+        .assert()
+        .failure()
+        .stdout(predicate::str::contains(
+            "Verification failed for Run command: touch built",
+        ))
+        .stdout(predicate::str::contains("'test -f missing' exited with 1"));
+
+    // The action itself ran, but nothing after it did
+    assert!(temp_dir.path().join("built").exists());
+    assert!(!temp_dir.path().join("after").exists());
+}
+
+#[test]
+fn test_verify_is_not_run_in_dry_run() {
+    let temp_dir = TempDir::new().unwrap();
+    write_module(&temp_dir, "true", "touch verified");
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .args(["apply", "--dry-run"])
+        .assert()
+        .success();
+
+    assert!(!temp_dir.path().join("verified").exists());
+}
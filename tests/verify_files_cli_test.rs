@@ -0,0 +1,67 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_verify_reports_files_changed_since_the_apply() {
+    let temp_dir = TempDir::new().unwrap();
+    let home = TempDir::new().unwrap();
+    let zshrc = home.path().join(".zshrc");
+    let gitconfig = home.path().join(".gitconfig");
+    fs::write(
+        temp_dir.path().join("dotfiles.ts"),
+        format!(
+            r#"export default defineModule("dotfiles").actions([
+    copyFile({{ source: "./zshrc", target: "{}" }}),
+    copyFile({{ source: "./gitconfig", target: "{}" }}),
+]);"#,
+            zshrc.display(),
+            gitconfig.display()
+        ),
+    )
+    .unwrap();
+    fs::write(temp_dir.path().join("zshrc"), "managed\n").unwrap();
+    fs::write(temp_dir.path().join("gitconfig"), "[user]\n").unwrap();
+
+    let dhd = || {
+        let mut cmd = Command::cargo_bin("dhd").unwrap();
+        cmd.current_dir(&temp_dir).env("DHD_HOME", home.path());
+        cmd
+    };
+    dhd()
+        .arg("verify")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "No files written by DHD on record",
+        ));
+
+    dhd().args(["apply", "--yes"]).assert().success();
+    dhd()
+        .arg("verify")
+        .assert()
+        .success()
+        .stdout(predicate::str::contains(
+            "2 intact, 0 changed, 0 unreadable",
+        ));
+
+    fs::write(&zshrc, "edited by hand\n").unwrap();
+    fs::remove_file(&gitconfig).unwrap();
+    dhd()
+        .arg("verify")
+        .assert()
+        .code(2)
+        .stdout(predicate::str::contains(format!(
+            "{} changed since DHD wrote it (dotfiles)",
+            zshrc.display()
+        )))
+        .stdout(predicate::str::contains(format!(
+            "{} was removed (dotfiles)",
+            gitconfig.display()
+        )));
+
+    // The apply rewrites both files and records what it wrote
+    dhd().args(["apply", "--yes"]).assert().success();
+    dhd().arg("verify").assert().success();
+}
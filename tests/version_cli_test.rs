@@ -0,0 +1,50 @@
+use assert_cmd::Command;
+use predicates::prelude::*;
+use std::fs;
+use tempfile::TempDir;
+
+#[test]
+fn test_version_prints_the_build() {
+    let output = Command::cargo_bin("dhd")
+        .unwrap()
+        .arg("version")
+        .assert()
+        .success()
+        .stdout(predicate::str::starts_with(format!(
+            "dhd {}\ncommit: ",
+            env!("CARGO_PKG_VERSION")
+        )))
+        .stdout(predicate::str::contains("\nbuilt: "))
+        .stdout(predicate::str::contains("\nmodule API: "))
+        .get_output()
+        .stdout
+        .clone();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .arg("--version")
+        .assert()
+        .success()
+        .stdout(output);
+}
+
+#[test]
+fn test_modules_for_a_newer_dhd_are_refused() {
+    let temp_dir = TempDir::new().unwrap();
+    fs::write(
+        temp_dir.path().join("future.ts"),
+        r#"export default defineModule("future").requiresDhd("99.0").actions([teleport({ to: "mars" })]);"#,
+    )
+    .unwrap();
+
+    Command::cargo_bin("dhd")
+        .unwrap()
+        .current_dir(&temp_dir)
+        .arg("check")
+        .assert()
+        .failure()
+        .stdout(predicate::str::contains(format!(
+            "this module requires DHD 99.0 or newer, but this is DHD {}",
+            env!("CARGO_PKG_VERSION")
+        )));
+}